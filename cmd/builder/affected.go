@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+// depsDir holds the input closure of every recipe compiled in this
+// checkout, one JSON file per recipe.
+var depsDir = filepath.Join("local", "deps")
+
+// recipeDeps is the input closure of a recipe as recorded by its last
+// compilation: every file that went into it, as canonical paths.
+type recipeDeps struct {
+	Recipe   string    `json:"recipe"`
+	Dir      string    `json:"dir"`
+	Inputs   []string  `json:"inputs"`
+	Recorded time.Time `json:"recorded"`
+}
+
+// writeRecipeDeps persists the inputs of a compiled recipe for `builder
+// affected`. Failures are only logged: the map is a cache, and a missing
+// entry is rebuilt when it is needed.
+func writeRecipeDeps(build *recipe.BuildFile, recipeDir string, plan *recipe.StagingPlan) {
+	if plan == nil {
+		return
+	}
+	deps := recipeDeps{
+		Recipe:   build.Name,
+		Dir:      canonicalPath(recipeDir),
+		Recorded: time.Now().UTC(),
+	}
+	for _, in := range plan.Inputs {
+		deps.Inputs = append(deps.Inputs, canonicalPath(in))
+	}
+	if err := saveRecipeDeps(deps); err != nil {
+		slog.Warn("recording recipe inputs", "recipe", build.Name, "error", err)
+	}
+}
+
+func saveRecipeDeps(deps recipeDeps) error {
+	if err := os.MkdirAll(depsDir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(deps, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(depsDir, deps.Recipe+".json"), append(b, '\n'), 0o644)
+}
+
+// loadRecipeDeps returns the recorded inputs of the recipe in dir, or
+// false when there are none or they are older than its build.yaml.
+func loadRecipeDeps(name, dir string) (recipeDeps, bool, error) {
+	var deps recipeDeps
+	b, err := os.ReadFile(filepath.Join(depsDir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return deps, false, nil
+	} else if err != nil {
+		return deps, false, err
+	}
+	if err := json.Unmarshal(b, &deps); err != nil {
+		return deps, false, fmt.Errorf("parsing recorded inputs of %s: %w", name, err)
+	}
+	if deps.Dir != canonicalPath(dir) {
+		return deps, false, nil
+	}
+	if info, err := os.Stat(filepath.Join(dir, "build.yaml")); err == nil && info.ModTime().After(deps.Recorded) {
+		return deps, false, nil
+	}
+	return deps, true, nil
+}
+
+// recipeInputs returns the canonical paths of the files the recipe in dir
+// depends on. The recorded closure of its last compilation is used when it
+// is current; otherwise, or with refresh, the recipe is compiled for its
+// default options to record it again. The static references of the
+// recipe are always added, since the recorded closure only covers the
+// architecture and options it was compiled for.
+func recipeInputs(cfg builderConfig, dir string, refresh bool) ([]string, error) {
+	build, err := loadRecipe(dir)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := build.References(cfg.includeDirsFor(dir))
+	if err != nil {
+		return nil, err
+	}
+	deps, ok, err := loadRecipeDeps(build.Name, dir)
+	if err != nil {
+		slog.Warn("ignoring recorded recipe inputs", "recipe", build.Name, "error", err)
+	}
+	if !ok || refresh {
+		_, plan, err := build.GenerateWithStaging(cfg.includeDirsFor(dir))
+		if err != nil {
+			return nil, fmt.Errorf("generating %s: %w", build.Name, err)
+		}
+		writeRecipeDeps(build, dir, plan)
+		deps.Inputs = nil
+		for _, in := range plan.Inputs {
+			deps.Inputs = append(deps.Inputs, canonicalPath(in))
+		}
+	}
+	inputs := append([]string(nil), deps.Inputs...)
+	for _, ref := range refs {
+		inputs = append(inputs, canonicalPath(ref))
+	}
+	sort.Strings(inputs)
+	return inputs, nil
+}
+
+// affectedRecipe is a recipe that a change affects and the changed file
+// that made it so.
+type affectedRecipe struct {
+	Dir    string
+	Reason string
+}
+
+// affectedRecipes returns the recipes that a change since ref affects: a
+// file in the recipe directory changed, or one in its input closure did.
+func affectedRecipes(cfg builderConfig, ref string, refresh bool) ([]affectedRecipe, error) {
+	changed, err := changedFilesSince(cfg, ref)
+	if err != nil {
+		return nil, err
+	}
+	recipes, err := listRecipes(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []affectedRecipe
+	for _, dir := range recipes {
+		reason, err := recipeAffected(cfg, dir, changed, refresh)
+		if err != nil {
+			// A recipe whose inputs cannot be determined may well be
+			// broken by the change; build it to find out.
+			slog.Warn("assuming recipe is affected", "recipe", dir, "error", err)
+			reason = "inputs unknown: " + err.Error()
+		}
+		if reason != "" {
+			out = append(out, affectedRecipe{Dir: dir, Reason: reason})
+		}
+	}
+	return out, nil
+}
+
+// recipeAffected returns the first changed file the recipe in dir depends
+// on, or "" when it is not affected.
+func recipeAffected(cfg builderConfig, dir string, changed map[string]bool, refresh bool) (string, error) {
+	prefix := canonicalPath(dir) + string(filepath.Separator)
+	var inDir []string
+	for path := range changed {
+		if strings.HasPrefix(path, prefix) {
+			inDir = append(inDir, path)
+		}
+	}
+	if len(inDir) > 0 {
+		sort.Strings(inDir)
+		return inDir[0], nil
+	}
+	inputs, err := recipeInputs(cfg, dir, refresh)
+	if err != nil {
+		return "", err
+	}
+	for _, in := range inputs {
+		if changed[in] {
+			return in, nil
+		}
+	}
+	return "", nil
+}
+
+// changedFilesSince returns the canonical paths of the files that differ
+// between ref and the working tree, or that git does not track yet, in the
+// git repositories holding the recipe roots, include directories and
+// template directory.
+func changedFilesSince(cfg builderConfig, ref string) (map[string]bool, error) {
+	type dirRole struct {
+		dir      string
+		required bool
+	}
+	var dirs []dirRole
+	for _, root := range cfg.RecipeRoots {
+		dirs = append(dirs, dirRole{root, true})
+	}
+	for _, dir := range cfg.allIncludeDirs() {
+		dirs = append(dirs, dirRole{dir, false})
+	}
+	if cfg.TemplateDir != "" {
+		dirs = append(dirs, dirRole{cfg.TemplateDir, false})
+	}
+	for _, root := range cfg.RecipeRoots {
+		if dir := cfg.RootSettings[root].TemplateDir; dir != "" {
+			dirs = append(dirs, dirRole{dir, false})
+		}
+	}
+
+	changed := map[string]bool{}
+	seenRepos := map[string]bool{}
+	for _, d := range dirs {
+		repo, err := gitOutput(d.dir, "rev-parse", "--show-toplevel")
+		if err != nil {
+			if d.required {
+				return nil, fmt.Errorf("recipe root %s is not in a git repository: %w", d.dir, err)
+			}
+			slog.Warn("not tracking changes outside git", "dir", d.dir)
+			continue
+		}
+		if seenRepos[repo] {
+			continue
+		}
+		seenRepos[repo] = true
+		names, err := gitOutput(repo, "diff", "--name-only", ref, "--")
+		if err != nil {
+			return nil, fmt.Errorf("listing changes since %s in %s: %w", ref, repo, err)
+		}
+		// Files not yet added to the index, such as a new recipe or
+		// include, are changes too.
+		untracked, err := gitOutput(repo, "ls-files", "--others", "--exclude-standard")
+		if err != nil {
+			return nil, fmt.Errorf("listing untracked files in %s: %w", repo, err)
+		}
+		for _, name := range strings.Split(names+"\n"+untracked, "\n") {
+			if name != "" {
+				changed[canonicalPath(filepath.Join(repo, filepath.FromSlash(name)))] = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// canonicalPath makes path absolute and resolves symlinks in its existing
+// parent directories, so paths from git and from the config compare equal.
+func canonicalPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	// Deleted files: resolve the closest existing directory instead.
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		return filepath.Join(dir, filepath.Base(abs))
+	}
+	return abs
+}
+
+var affectedCmd = cobra.Command{
+	Use:   "affected --since REF",
+	Short: "List the recipes whose inputs (recipe files, includes, templates, local files) changed since a git ref",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		since, _ := cmd.Flags().GetString("since")
+		explain, _ := cmd.Flags().GetBool("explain")
+		refresh, _ := cmd.Flags().GetBool("refresh")
+		if since == "" {
+			return fmt.Errorf("--since is required")
+		}
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		affected, err := affectedRecipes(cfg, since, refresh)
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		for _, a := range affected {
+			name := filepath.Base(a.Dir)
+			if explain {
+				fmt.Fprintf(out, "%s\t%s\n", name, a.Reason)
+			} else {
+				fmt.Fprintln(out, name)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	affectedCmd.Flags().String("since", "", "Git ref to compare the working tree against")
+	affectedCmd.Flags().Bool("explain", false, "Also print the changed file that affects each recipe")
+	affectedCmd.Flags().Bool("refresh", false, "Recompile every recipe to record its inputs instead of using the recorded ones")
+	rootCmd.AddCommand(&affectedCmd)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const affectedTestRecipe = `name: %s
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  directives:
+%s`
+
+// writeTestFile writes contents to root/rel, creating its directory.
+func writeTestFile(t *testing.T, root, rel, contents string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testGit runs git in dir, failing the test on error.
+func testGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+}
+
+// affectedTestTree is a repository with recipes a (including common.yaml)
+// and b, committed.
+func affectedTestTree(t *testing.T) (string, builderConfig) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	root := t.TempDir()
+	writeTestFile(t, root, "recipes/a/build.yaml", sprintfRecipe("a", "    - include: common.yaml\n"))
+	writeTestFile(t, root, "recipes/b/build.yaml", sprintfRecipe("b", "    - run:\n        - echo b\n"))
+	writeTestFile(t, root, "inc/common.yaml", "directives:\n  - run:\n      - echo common\n")
+	writeTestFile(t, root, "inc/unused.yaml", "directives: []\n")
+	writeTestFile(t, root, ".gitignore", "/local/\n")
+	testGit(t, root, "init", "-q")
+	testGit(t, root, "add", "-A")
+	testGit(t, root, "commit", "-q", "-m", "base")
+
+	depsDir = filepath.Join(root, "local", "deps")
+	t.Cleanup(func() { depsDir = filepath.Join("local", "deps") })
+	return root, builderConfig{
+		RecipeRoots: []string{filepath.Join(root, "recipes")},
+		IncludeDirs: []string{filepath.Join(root, "inc")},
+	}
+}
+
+func sprintfRecipe(name, directives string) string {
+	return fmt.Sprintf(affectedTestRecipe, name, directives)
+}
+
+func TestChangedFilesSince(t *testing.T) {
+	root, cfg := affectedTestTree(t)
+	writeTestFile(t, root, "inc/common.yaml", "directives:\n  - run:\n      - echo changed\n")
+	writeTestFile(t, root, "recipes/c/build.yaml", sprintfRecipe("c", "    - run:\n        - echo c\n"))
+	writeTestFile(t, root, "local/deps/a.json", "{}\n")
+
+	changed, err := changedFilesSince(cfg, "HEAD")
+	if err != nil {
+		t.Fatalf("changedFilesSince: %v", err)
+	}
+	var got []string
+	for path := range changed {
+		rel, err := filepath.Rel(canonicalPath(root), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, filepath.ToSlash(rel))
+	}
+	slices.Sort(got)
+	// The untracked recipe counts; ignored files do not.
+	want := []string{"inc/common.yaml", "recipes/c/build.yaml"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("changedFilesSince = %q, want %q", got, want)
+	}
+}
+
+func TestRecipeAffected(t *testing.T) {
+	root, cfg := affectedTestTree(t)
+	path := func(rel string) string { return canonicalPath(filepath.Join(root, filepath.FromSlash(rel))) }
+	for _, tc := range []struct {
+		name    string
+		recipe  string
+		changed []string
+		want    string
+	}{
+		{name: "file in the recipe directory", recipe: "a", changed: []string{"recipes/a/notes.txt", "recipes/a/build.yaml"}, want: "recipes/a/build.yaml"},
+		{name: "included file", recipe: "a", changed: []string{"inc/common.yaml"}, want: "inc/common.yaml"},
+		{name: "include of another recipe", recipe: "b", changed: []string{"inc/common.yaml"}},
+		{name: "unused include", recipe: "a", changed: []string{"inc/unused.yaml"}},
+		{name: "other recipe", recipe: "a", changed: []string{"recipes/b/build.yaml"}},
+		{name: "recipe with a longer name", recipe: "a", changed: []string{"recipes/ab/build.yaml"}},
+		{name: "nothing changed", recipe: "b"},
+	} {
+		changed := map[string]bool{}
+		for _, rel := range tc.changed {
+			changed[path(rel)] = true
+		}
+		want := ""
+		if tc.want != "" {
+			want = path(tc.want)
+		}
+		got, err := recipeAffected(cfg, filepath.Join(root, "recipes", tc.recipe), changed, false)
+		if err != nil {
+			t.Fatalf("%s: recipeAffected: %v", tc.name, err)
+		}
+		if got != want {
+			t.Fatalf("%s: recipeAffected = %q, want %q", tc.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// buildkitConfig locates the standalone buildkitd that --method buildctl
+// submits to.
+type buildkitConfig struct {
+	// Addr is the buildkitd address, e.g. tcp://buildkitd:1234 or
+	// unix:///run/buildkit/buildkitd.sock. $BUILDKIT_HOST is used when it
+	// is empty.
+	Addr string `yaml:"addr,omitempty"`
+	// CACert, Cert and Key are PEM files for a TLS connection to a tcp
+	// address; ServerName overrides the name the certificate is checked
+	// against.
+	CACert     string `yaml:"ca_cert,omitempty"`
+	Cert       string `yaml:"cert,omitempty"`
+	Key        string `yaml:"key,omitempty"`
+	ServerName string `yaml:"server_name,omitempty"`
+}
+
+var buildkitAddr string
+
+// buildkitAddress returns the buildkitd address for --method buildctl: the
+// --buildkit-addr flag, else buildkit.addr from the config, else
+// $BUILDKIT_HOST.
+func buildkitAddress(cfg builderConfig) (string, error) {
+	for _, addr := range []string{buildkitAddr, cfg.Buildkit.Addr, os.Getenv("BUILDKIT_HOST")} {
+		if addr != "" {
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("--method buildctl needs a buildkitd address: set --buildkit-addr, buildkit.addr in the builder config, or BUILDKIT_HOST")
+}
+
+// buildkitTLS returns the TLS settings of the config, or nil when it has
+// none.
+func buildkitTLS(cfg buildkitConfig) *ir.TLSConfig {
+	if cfg.CACert == "" && cfg.Cert == "" && cfg.Key == "" && cfg.ServerName == "" {
+		return nil
+	}
+	return &ir.TLSConfig{CACert: cfg.CACert, Cert: cfg.Cert, Key: cfg.Key, ServerName: cfg.ServerName}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/buildlog"
+)
+
+var (
+	buildLogDir     string
+	buildLogMaxSize int64
+	buildLogKeep    int
+)
+
+// captured is the build log the output of the running build is copied
+// to, if any.
+var captured *capturedLog
+
+// capturedLog copies everything the process and its children write to
+// stdout and stderr into a build log.
+type capturedLog struct {
+	file           *os.File
+	stdout, stderr *os.File
+	pipes          []*os.File
+	wg             sync.WaitGroup
+}
+
+// startBuildLog copies the output of the build of recipe from here on into
+// buildlog.Path(--log-dir, recipe), after rotating the log when it exceeds
+// --log-max-size. Child processes inherit the capture, so docker's output
+// is logged as well.
+func startBuildLog(recipe string) error {
+	if buildLogDir == "" || captured != nil {
+		return nil
+	}
+	f, path, err := openBuildLog(recipe)
+	if err != nil {
+		return err
+	}
+	c := &capturedLog{file: f, stdout: os.Stdout, stderr: os.Stderr}
+	var mu sync.Mutex
+	for _, out := range []**os.File{&os.Stdout, &os.Stderr} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			c.close()
+			return fmt.Errorf("capturing output: %w", err)
+		}
+		dst := io.MultiWriter(*out, buildlog.NewWriter(f, &mu, nil))
+		c.pipes = append(c.pipes, w)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			io.Copy(dst, r)
+			r.Close()
+		}()
+		*out = w
+	}
+	log.SetOutput(os.Stderr)
+	captured = c
+	fmt.Printf("%s%s started %s (log: %s)\n", buildlog.RunMarker, recipe, time.Now().Format(time.RFC3339), filepath.ToSlash(path))
+	return nil
+}
+
+// teeBuildLog returns a writer that copies what is written to out into the
+// build log of recipe, and a function closing the log. It is startBuildLog
+// for builds that run alongside others and write to out rather than to
+// stdout, as those of test-all --run do.
+func teeBuildLog(recipe string, out io.Writer) (io.Writer, func(), error) {
+	if buildLogDir == "" {
+		return out, func() {}, nil
+	}
+	f, path, err := openBuildLog(recipe)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := io.MultiWriter(out, buildlog.NewWriter(f, &sync.Mutex{}, nil))
+	fmt.Fprintf(w, "%s%s started %s (log: %s)\n", buildlog.RunMarker, recipe, time.Now().Format(time.RFC3339), filepath.ToSlash(path))
+	return w, func() { f.Close() }, nil
+}
+
+// openBuildLog opens buildlog.Path(--log-dir, recipe) for appending, after
+// rotating it when it exceeds --log-max-size.
+func openBuildLog(recipe string) (*os.File, string, error) {
+	if err := os.MkdirAll(buildLogDir, 0o755); err != nil {
+		return nil, "", fmt.Errorf("creating log directory: %w", err)
+	}
+	path := buildlog.Path(buildLogDir, recipe)
+	if err := buildlog.Rotate(path, buildLogMaxSize<<20, buildLogKeep); err != nil {
+		return nil, "", fmt.Errorf("rotating %s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, "", fmt.Errorf("opening build log: %w", err)
+	}
+	return f, path, nil
+}
+
+// stopBuildLog restores stdout and stderr and closes the build log once
+// everything written so far is in it.
+func stopBuildLog() {
+	if captured == nil {
+		return
+	}
+	captured.close()
+	captured = nil
+}
+
+func (c *capturedLog) close() {
+	os.Stdout, os.Stderr = c.stdout, c.stderr
+	log.SetOutput(os.Stderr)
+	for _, w := range c.pipes {
+		w.Close()
+	}
+	c.wg.Wait()
+	c.file.Close()
+}
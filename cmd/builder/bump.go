@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/netcache"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/neurodesk/builder/pkg/textdiff"
+	"github.com/spf13/cobra"
+)
+
+// stagedFilesByArch returns the files the recipe stages for each of its
+// architectures, by architecture and name.
+func stagedFilesByArch(cfg builderConfig, build *recipe.BuildFile) (map[recipe.CPUArchitecture]map[string]recipe.StagedFile, error) {
+	files := map[recipe.CPUArchitecture]map[string]recipe.StagedFile{}
+	for _, arch := range build.Architectures {
+		_, plan, err := build.GenerateWithParams(recipe.GenerateParams{
+			IncludeDirs: cfg.includeDirsFor(build.Dir()),
+			Arch:        arch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("generating for %s: %w", arch, err)
+		}
+		files[arch] = map[string]recipe.StagedFile{}
+		for _, f := range plan.Files {
+			files[arch][f.Name] = f
+		}
+	}
+	return files, nil
+}
+
+// repinFiles fetches the files whose source the bump changed, so a missing
+// artifact is found before the build, and re-pins their digests and
+// commits in src: OCI digests to what the new tag names, and git commits to
+// the tag v<version> or <version>, if the repository has one.
+func repinFiles(ctx context.Context, src []byte, before, after map[recipe.CPUArchitecture]map[string]recipe.StagedFile, version string) ([]byte, []string, error) {
+	hc, gc, oc := netcaches()
+	if err := os.MkdirAll(hc.Dir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("creating http cache dir: %w", err)
+	}
+	var notes []string
+	var failed []error
+	done := map[string]bool{}
+	repin := func(old, new, what string) {
+		if old == new || done[old] {
+			return
+		}
+		done[old] = true
+		out, lines, err := recipe.ReplaceScalarValues(src, old, new)
+		if err != nil {
+			failed = append(failed, err)
+			return
+		}
+		if len(lines) == 0 {
+			notes = append(notes, fmt.Sprintf("%s is now %s, but %s is not written in the recipe; update it by hand", what, new, old))
+			return
+		}
+		src = out
+		notes = append(notes, fmt.Sprintf("line %d: re-pinned %s to %s", lines[0], what, new))
+	}
+
+	for _, arch := range slices.Sorted(maps.Keys(after)) {
+		for _, name := range slices.Sorted(maps.Keys(after[arch])) {
+			f := after[arch][name]
+			old, existed := before[arch][name]
+			switch {
+			case f.URL != "":
+				if existed && old.URL == f.URL || done[f.URL] {
+					continue
+				}
+				done[f.URL] = true
+				if _, _, err := hc.Get(ctx, f.URL); err != nil {
+					failed = append(failed, fmt.Errorf("fetching %s: %w", f.URL, err))
+					continue
+				}
+				notes = append(notes, fmt.Sprintf("fetched %s", f.URL))
+			case f.OCI != nil:
+				if existed && old.OCI != nil && old.OCI.Ref == f.OCI.Ref {
+					continue
+				}
+				digest, err := oc.Resolve(ctx, f.OCI.Ref)
+				if err != nil {
+					failed = append(failed, fmt.Errorf("resolving %s: %w", f.OCI.Ref, err))
+					continue
+				}
+				repin(f.OCI.Digest, digest, "the digest of "+f.OCI.Ref)
+			case f.Git != nil && f.Git.Commit != "":
+				var commit string
+				for _, tag := range []string{"v" + version, version} {
+					if c, err := gc.Resolve(ctx, netcache.GitSource{URL: f.Git.URL, Ref: "refs/tags/" + tag}); err == nil {
+						commit = c
+						break
+					}
+				}
+				if commit == "" {
+					if !done[f.Git.Commit] {
+						done[f.Git.Commit] = true
+						notes = append(notes, fmt.Sprintf("%s has no tag v%s or %s; the commit %s of %s is left as it was", f.Git.URL, version, version, shortCommit(f.Git.Commit), name))
+					}
+					continue
+				}
+				repin(f.Git.Commit, commit, "the commit of "+f.Git.URL)
+			}
+		}
+	}
+	return src, notes, errors.Join(failed...)
+}
+
+var bumpCmd = cobra.Command{
+	Use:   "bump <recipe> --version X.Y.Z",
+	Short: "Update a recipe to a new upstream version, re-pin its downloads and print the diff; --write saves it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		version, _ := cmd.Flags().GetString("version")
+		write, _ := cmd.Flags().GetBool("write")
+		offline, _ := cmd.Flags().GetBool("offline")
+		if version == "" {
+			return fmt.Errorf("--version is required")
+		}
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		dir, err := resolveRecipePath(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, "build.yaml")
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, notes, err := recipe.BumpBuildFile(src, version)
+		if err != nil {
+			return fmt.Errorf("bumping %s: %w", path, err)
+		}
+
+		var fetchErr error
+		if !offline {
+			current, err := loadRecipe(dir)
+			if err != nil {
+				return err
+			}
+			bumped, err := recipe.ParseBuildFile(out, dir)
+			if err != nil {
+				return fmt.Errorf("the bumped recipe does not load: %w", err)
+			}
+			before, err := stagedFilesByArch(cfg, current)
+			if err != nil {
+				return err
+			}
+			after, err := stagedFilesByArch(cfg, bumped)
+			if err != nil {
+				return fmt.Errorf("the bumped recipe does not generate: %w", err)
+			}
+			var pinNotes []string
+			out, pinNotes, fetchErr = repinFiles(context.Background(), out, before, after, version)
+			notes = append(notes, pinNotes...)
+		}
+
+		// Generate the Dockerfile of the result, as a build would.
+		bumped, err := recipe.ParseBuildFile(out, dir)
+		if err != nil {
+			return fmt.Errorf("the bumped recipe does not load: %w", err)
+		}
+		def, err := bumped.Generate(cfg.includeDirsFor(dir))
+		if err != nil {
+			return fmt.Errorf("the bumped recipe does not generate: %w", err)
+		}
+		if _, err := ir.GenerateDockerfile(def); err != nil {
+			return fmt.Errorf("generating the Dockerfile of the bumped recipe: %w", err)
+		}
+
+		fmt.Print(textdiff.Unified(path, path+" (bumped)", string(src), string(out), 3))
+		for _, note := range notes {
+			fmt.Fprintf(os.Stderr, "note: %s\n", note)
+		}
+		if fetchErr != nil {
+			return fmt.Errorf("not every download of version %s is available: %w", version, fetchErr)
+		}
+		if !write {
+			return nil
+		}
+		if err := os.WriteFile(path, out, 0o644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s at version %s\n", path, version)
+		return nil
+	},
+}
+
+func init() {
+	bumpCmd.Flags().String("version", "", "The new version")
+	bumpCmd.Flags().Bool("write", false, "Write the bumped recipe back to build.yaml")
+	bumpCmd.Flags().Bool("offline", false, "Do not fetch the new downloads or re-pin digests and commits")
+	rootCmd.AddCommand(&bumpCmd)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/archive"
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/manifest"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+// bundleMetadataFile is the file at the root of a bundle that describes it.
+// The build context is under context/ and named local contexts under
+// locals/<key>/.
+const bundleMetadataFile = "bundle.json"
+
+// bundleFormat is the version of the bundle layout.
+const bundleFormat = 1
+
+type bundleMetadata struct {
+	Format  int    `json:"format"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Tag     string `json:"tag"`
+	Arch    string `json:"arch"`
+	// InputDigest is the input digest of the build without build args.
+	InputDigest    string            `json:"input_digest,omitempty"`
+	Options        map[string]string `json:"options,omitempty"`
+	Locals         []string          `json:"locals,omitempty"`
+	BuilderVersion string            `json:"builder_version,omitempty"`
+	Created        time.Time         `json:"created"`
+}
+
+var bundleCmd = cobra.Command{
+	Use:   "bundle [recipe]",
+	Short: "Package a recipe's staged build context into an archive that builds without the recipe checkout or network",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+		locals, _ := cmd.Flags().GetStringArray("local")
+		output, _ := cmd.Flags().GetString("output")
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		stage, err := prepareStage(cfg, args[0], locals, options)
+		if err != nil {
+			return err
+		}
+		if digest, err := buildInputDigest(stage, nil); err == nil {
+			stage.inputDigest = digest
+		}
+		res, err := prepareDockerStage(stage)
+		if err != nil {
+			return err
+		}
+		if output == "" {
+			output = res.Name + "-" + res.Version + ".tar.zst"
+			if _, err := exec.LookPath("zstd"); err != nil {
+				output = res.Name + "-" + res.Version + ".tar.gz"
+			}
+		}
+		if err := writeBundle(output, stage, res, options, locals); err != nil {
+			return err
+		}
+		fmt.Printf("Bundle written to %s; build it with: builder build --from-bundle %s\n", output, output)
+		return nil
+	},
+}
+
+// writeBundle archives the staged build context res, the named local
+// contexts and the bundle metadata to output.
+func writeBundle(output string, stage *genericStageResult, res *dockerStageResult, options map[string]string, locals []string) error {
+	meta := bundleMetadata{
+		Format:         bundleFormat,
+		Name:           res.Name,
+		Version:        res.Version,
+		Tag:            res.Tag,
+		Arch:           res.Arch,
+		InputDigest:    stage.inputDigest,
+		BuilderVersion: manifest.BuilderVersion(),
+		Created:        time.Now().UTC(),
+	}
+	if len(options) > 0 {
+		meta.Options = options
+	}
+	trees := []archive.Tree{{Prefix: "context", Dir: res.BuildDir}}
+	for _, kv := range locals {
+		key, dir, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid --local %q (want KEY=DIR)", kv)
+		}
+		trees = append(trees, archive.Tree{Prefix: "locals/" + key, Dir: dir})
+		meta.Locals = append(meta.Locals, key)
+	}
+	sort.Strings(meta.Locals)
+
+	metaDir, err := os.MkdirTemp("", "builder-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(metaDir)
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(metaDir, bundleMetadataFile), append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	trees = append(trees, archive.Tree{Dir: metaDir})
+	if err := archive.Create(output, trees); err != nil {
+		return fmt.Errorf("writing bundle: %w", err)
+	}
+	return nil
+}
+
+// readBundle extracts the bundle at path into a temporary directory and
+// returns its metadata and the directory, which the caller removes.
+func readBundle(path string) (*bundleMetadata, string, error) {
+	dir, err := os.MkdirTemp("", "builder-bundle-")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := archive.Extract(path, dir, archive.Options{}); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("extracting bundle %s: %w", path, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, bundleMetadataFile))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("%s is not a builder bundle: %w", path, err)
+	}
+	var meta bundleMetadata
+	if err := json.Unmarshal(b, &meta); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("reading %s of %s: %w", bundleMetadataFile, path, err)
+	}
+	if meta.Format != bundleFormat {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("bundle %s has format %d; this builder reads format %d", path, meta.Format, bundleFormat)
+	}
+	return &meta, dir, nil
+}
+
+// buildFromBundle builds the image of a bundle with Docker. Local contexts
+// given with --local replace the bundled ones of the same key.
+func buildFromBundle(path string, locals []string, argValues map[string]string) error {
+	if buildMethod != "docker" {
+		return fmt.Errorf("--from-bundle supports only --method docker")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("docker CLI not found in PATH; please install Docker and rerun")
+	}
+	meta, dir, err := readBundle(path)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// The bundled digest holds for builds without build args.
+	inputDigest := meta.InputDigest
+	if len(argValues) > 0 {
+		inputDigest = ""
+	}
+	stage := &genericStageResult{
+		build:       &recipe.BuildFile{Name: meta.Name, Architectures: []recipe.CPUArchitecture{recipe.CPUArchitecture(meta.Arch)}},
+		irDef:       &ir.Definition{},
+		version:     meta.Version,
+		inputDigest: inputDigest,
+	}
+	if inputDigest != "" && !buildForce {
+		if reason := upToDate(stage, inputDigest, buildPushRef); reason != "" {
+			fmt.Printf("%s is up-to-date (%s); use --force to rebuild\n", meta.Tag, reason)
+			buildUpToDate = true
+			return nil
+		}
+	}
+
+	given := map[string]bool{}
+	for _, kv := range locals {
+		if k, _, ok := strings.Cut(kv, "="); ok {
+			given[k] = true
+		}
+	}
+	for _, key := range meta.Locals {
+		if !given[key] {
+			locals = append(locals, key+"="+filepath.Join(dir, "locals", key))
+		}
+	}
+
+	buildDir := filepath.Join(dir, "context")
+	dockerArgs := dockerBuildArgs(meta.Tag, filepath.Join(buildDir, "Dockerfile"), filepath.Join(buildDir, "cache"), buildDir, locals, argValues, inputDigest)
+	ctx, cancel := withTimeout(buildTimeout)
+	defer cancel()
+	cmdRun := commandContext(ctx, "docker", dockerArgs...)
+	cmdRun.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	cmdRun.Stdout = os.Stdout
+	cmdRun.Stderr = os.Stderr
+	fmt.Printf("Running: DOCKER_BUILDKIT=1 docker %s\n", strings.Join(dockerArgs, " "))
+	started := startBuild(meta.Name, buildMethod)
+	if err := cmdRun.Run(); err != nil {
+		err = timeoutError(ctx, "build", buildTimeout, fmt.Errorf("docker build failed: %w", err))
+		recordBuild(stage, buildMethod, meta.Tag, started, err, "", nil)
+		return err
+	}
+	fmt.Printf("Built image %s from bundle %s\n", meta.Tag, path)
+	if buildSquash {
+		if err := squashDockerImage(ctx, imageTags(meta.Tag), dir); err != nil {
+			err = timeoutError(ctx, "build", buildTimeout, err)
+			recordBuild(stage, buildMethod, meta.Tag, started, err, "", nil)
+			return err
+		}
+	}
+
+	if buildPushRef != "" {
+		push := commandContext(ctx, "docker", "push", buildPushRef)
+		push.Stdout = os.Stdout
+		push.Stderr = os.Stderr
+		fmt.Printf("Running: docker push %s\n", buildPushRef)
+		if err := push.Run(); err != nil {
+			err = timeoutError(ctx, "build", buildTimeout, fmt.Errorf("docker push failed: %w", err))
+			recordBuild(stage, buildMethod, meta.Tag, started, err, dockerImageID(meta.Tag), nil)
+			return err
+		}
+	}
+	recordBuild(stage, buildMethod, meta.Tag, started, nil, dockerImageID(meta.Tag), nil)
+	return nil
+}
+
+func init() {
+	bundleCmd.Flags().StringP("output", "o", "", "Bundle file to write: .tar.zst (needs zstd), .tar.gz or .tar (default <name>-<version>.tar.zst, or .tar.gz without zstd)")
+	bundleCmd.Flags().StringArray("option", []string{}, "Set a recipe option as KEY=VALUE (repeatable)")
+	bundleCmd.Flags().StringArray("local", []string{}, "Include a named local context as KEY=DIR (repeatable)")
+	rootCmd.AddCommand(&bundleCmd)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v4"
+)
+
+var catalogCmd = cobra.Command{
+	Use:   "catalog",
+	Short: "Emit a JSON or YAML catalog of every recipe for the Neurodesk app menu",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		format, _ := cmd.Flags().GetString("format")
+		if format != "json" && format != "yaml" {
+			return fmt.Errorf("unsupported catalog format %q (supported: json, yaml)", format)
+		}
+		outPath, _ := cmd.Flags().GetString("output")
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		recipes, err := listRecipes(cfg)
+		if err != nil {
+			return err
+		}
+
+		// Recipes that fail to load or generate are left out and reported.
+		entries := []*recipe.CatalogEntry{}
+		failed := 0
+		for _, dir := range recipes {
+			build, err := loadRecipeWithOptions(dir, recipe.LoadOptions{Mode: recipeLoadMode()})
+			if err == nil {
+				var entry *recipe.CatalogEntry
+				if entry, err = build.CatalogEntry(cfg.includeDirsFor(dir)); err == nil {
+					entries = append(entries, entry)
+					continue
+				}
+			}
+			failed++
+			fmt.Fprintf(os.Stderr, "warning: %s: %v\n", filepath.Base(dir), err)
+		}
+
+		var data []byte
+		if format == "json" {
+			if data, err = json.MarshalIndent(entries, "", "  "); err == nil {
+				data = append(data, '\n')
+			}
+		} else {
+			data, err = yaml.Marshal(entries)
+		}
+		if err != nil {
+			return fmt.Errorf("encoding catalog: %w", err)
+		}
+
+		if outPath == "" {
+			os.Stdout.Write(data)
+		} else {
+			if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+				return fmt.Errorf("creating output directory: %w", err)
+			}
+			if err := os.WriteFile(outPath, data, 0o644); err != nil {
+				return fmt.Errorf("writing catalog: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Catalog of %d recipes written to %s\n", len(entries), outPath)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d recipes could not be catalogued", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	catalogCmd.Flags().String("format", "json", "Catalog format (json, yaml)")
+	catalogCmd.Flags().String("output", "", "Write the catalog to this file instead of stdout")
+	rootCmd.AddCommand(&catalogCmd)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ciMatrix is a GitHub Actions strategy matrix, one include entry per
+// recipe and architecture to build.
+type ciMatrix struct {
+	Include []ciMatrixEntry `json:"include"`
+}
+
+type ciMatrixEntry struct {
+	Recipe  string `json:"recipe"`
+	Version string `json:"version"`
+	Arch    string `json:"arch"`
+	RunsOn  string `json:"runs-on"`
+}
+
+var defaultCIRunners = map[string]string{
+	"x86_64":  "ubuntu-latest",
+	"aarch64": "ubuntu-24.04-arm",
+}
+
+var ciMatrixCmd = cobra.Command{
+	Use:   "ci-matrix",
+	Short: "Print a GitHub Actions matrix of the recipes to build, optionally only those affected by changes since a git ref",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		since, _ := cmd.Flags().GetString("changed-since")
+		githubOutput, _ := cmd.Flags().GetBool("github-output")
+		runnerFlags, _ := cmd.Flags().GetStringArray("runner")
+		runners := map[string]string{}
+		for k, v := range defaultCIRunners {
+			runners[k] = v
+		}
+		for _, kv := range runnerFlags {
+			arch, label, ok := strings.Cut(kv, "=")
+			if !ok || arch == "" || label == "" {
+				return fmt.Errorf("invalid --runner %q (want ARCH=LABEL)", kv)
+			}
+			runners[arch] = label
+		}
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		var recipes []string
+		if since == "" {
+			recipes, err = listRecipes(cfg)
+		} else {
+			var affected []affectedRecipe
+			affected, err = affectedRecipes(cfg, since, false)
+			for _, a := range affected {
+				recipes = append(recipes, a.Dir)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		matrix := ciMatrix{Include: []ciMatrixEntry{}}
+		for _, dir := range recipes {
+			build, err := loadRecipe(dir)
+			if err != nil {
+				return fmt.Errorf("loading %s: %w", dir, err)
+			}
+			for _, arch := range build.Architectures {
+				runner, ok := runners[string(arch)]
+				if !ok {
+					return fmt.Errorf("%s: no runner for architecture %s (set one with --runner %s=LABEL)", build.Name, arch, arch)
+				}
+				matrix.Include = append(matrix.Include, ciMatrixEntry{
+					Recipe:  build.Name,
+					Version: build.Version,
+					Arch:    string(arch),
+					RunsOn:  runner,
+				})
+			}
+		}
+
+		b, err := json.Marshal(matrix)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(b))
+		if githubOutput {
+			return writeGitHubOutput(map[string]string{
+				"matrix": string(b),
+				"count":  fmt.Sprint(len(matrix.Include)),
+			})
+		}
+		return nil
+	},
+}
+
+// writeGitHubOutput appends step outputs to the file named by
+// $GITHUB_OUTPUT.
+func writeGitHubOutput(outputs map[string]string) error {
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		return fmt.Errorf("--github-output: GITHUB_OUTPUT is not set")
+	}
+	keys := make([]string, 0, len(outputs))
+	for k := range outputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		fmt.Fprintf(f, "%s=%s\n", k, outputs[k])
+	}
+	return f.Close()
+}
+
+func init() {
+	ciMatrixCmd.Flags().String("changed-since", "", "Only include recipes affected by changes since this git ref")
+	ciMatrixCmd.Flags().Bool("github-output", false, "Also write matrix= and count= to $GITHUB_OUTPUT")
+	ciMatrixCmd.Flags().StringArray("runner", nil, "Runner label for an architecture as ARCH=LABEL (repeatable)")
+	rootCmd.AddCommand(&ciMatrixCmd)
+}
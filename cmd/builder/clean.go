@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/netcache"
+	"github.com/spf13/cobra"
+)
+
+// cleanDirs are the generated artifacts under local/ that builds recreate.
+// Build history, logs and download caches are kept.
+var cleanDirs = []string{
+	filepath.Join("local", "build"),
+	filepath.Join("local", "docker"),
+	filepath.Join("local", "template-tests"),
+	filepath.Join("local", "template_tests"),
+	filepath.Join("local", "graphs"),
+}
+
+var cleanCmd = cobra.Command{
+	Use:   "clean",
+	Short: "Remove build contexts, generated files and dangling builder images; optionally prune the download caches",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		images, _ := cmd.Flags().GetBool("images")
+		cacheDays, _ := cmd.Flags().GetInt("cache-days")
+
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		var total int64
+		for _, dir := range cleanDirs {
+			if _, err := os.Stat(dir); err != nil {
+				continue
+			}
+			size := dirSize(dir)
+			total += size
+			fmt.Printf("%s %s (%s)\n", verb, dir, formatBytes(size))
+			if dryRun {
+				continue
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				return fmt.Errorf("removing %s: %w", dir, err)
+			}
+		}
+
+		if cacheDays > 0 {
+			cutoff := time.Now().Add(-time.Duration(cacheDays) * 24 * time.Hour)
+			hc, gc, oc := netcaches()
+			for _, c := range []struct {
+				name  string
+				prune func(time.Time, bool) (netcache.PruneResult, error)
+			}{
+				{hc.Dir, hc.Prune},
+				{gc.Dir, gc.Prune},
+				{oc.Dir, oc.Prune},
+			} {
+				res, err := c.prune(cutoff, dryRun)
+				total += res.Bytes
+				if len(res.Paths) > 0 {
+					fmt.Printf("%s %d entries unused for %d days from %s (%s)\n", verb, len(res.Paths), cacheDays, c.name, formatBytes(res.Bytes))
+				}
+				if err != nil {
+					return fmt.Errorf("pruning %s: %w", c.name, err)
+				}
+			}
+		}
+
+		if images {
+			if err := cleanImages(dryRun); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%s %s of local files\n", verb, formatBytes(total))
+		return nil
+	},
+}
+
+// cleanImages removes the dangling images of builder builds, which carry
+// the input digest label, left behind when a tag moved to a rebuild.
+func cleanImages(dryRun bool) error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		fmt.Println("docker not found in PATH; skipping images")
+		return nil
+	}
+	out, err := exec.Command("docker", "image", "ls", "--quiet", "--no-trunc",
+		"--filter", "dangling=true", "--filter", "label="+inputDigestLabel).Output()
+	if err != nil {
+		return fmt.Errorf("listing dangling images: %w", err)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil
+	}
+	if dryRun {
+		fmt.Printf("Would remove %d dangling builder images\n", len(ids))
+		return nil
+	}
+	rm := exec.Command("docker", append([]string{"image", "rm"}, ids...)...)
+	rm.Stdout = os.Stdout
+	rm.Stderr = os.Stderr
+	if err := rm.Run(); err != nil {
+		return fmt.Errorf("removing dangling images: %w", err)
+	}
+	fmt.Printf("Removed %d dangling builder images\n", len(ids))
+	return nil
+}
+
+// dirSize returns the size of the regular files below dir.
+func dirSize(dir string) int64 {
+	var n int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				n += info.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
+
+// formatBytes renders n with a binary unit, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	for _, u := range []string{"KiB", "MiB", "GiB", "TiB"} {
+		f /= unit
+		if f < unit && f > -unit || u == "TiB" {
+			return fmt.Sprintf("%.1f %s", f, u)
+		}
+	}
+	return ""
+}
+
+func init() {
+	cleanCmd.Flags().Bool("dry-run", false, "List what would be removed without removing it")
+	cleanCmd.Flags().Bool("images", true, "Also remove dangling images of builder builds")
+	cleanCmd.Flags().Int("cache-days", 0, "Also prune download cache entries (HTTP, git, OCI) unused for this many days (default: keep the caches)")
+	rootCmd.AddCommand(&cleanCmd)
+}
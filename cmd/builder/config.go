@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v4"
+)
+
+// projectConfigFile is the configuration file looked up in the working
+// directory when --config is not given.
+const projectConfigFile = "builder.config.yaml"
+
+// configEnvPrefix starts the environment variables that override
+// configuration fields, e.g. BUILDER_RECIPE_ROOTS for recipe_roots and
+// BUILDER_BUILDKIT_ADDR for buildkit.addr.
+const configEnvPrefix = "BUILDER_"
+
+// rootSettings are the settings of the recipes in one recipe root.
+type rootSettings struct {
+	// IncludeDirs are searched before the global include_dirs.
+	IncludeDirs []string `yaml:"include_dirs,omitempty"`
+	// TemplateDir replaces the global template_dir.
+	TemplateDir string `yaml:"template_dir,omitempty"`
+}
+
+// activeConfig is the configuration loadBuilderConfig loaded last, which
+// loadRecipeWithOptions uses for the recipe root settings.
+var activeConfig builderConfig
+
+// userConfigFile returns the per-user configuration file,
+// ~/.config/builder/config.yaml, or "" if there is no home directory.
+func userConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "builder", "config.yaml")
+}
+
+// loadLayeredConfig merges the configuration sources, lowest precedence
+// first: the per-user file and ./builder.config.yaml, or only the --config
+// file when it is given, then the BUILDER_* environment variables. Later
+// sources replace the fields they set. It returns the sources it used.
+func loadLayeredConfig() (builderConfig, []string, error) {
+	var cfg builderConfig
+	var sources []string
+
+	files := []string{userConfigFile(), projectConfigFile}
+	explicit := rootCmd.PersistentFlags().Changed("config")
+	if explicit {
+		files = []string{rootBuilderConfig}
+	}
+	for _, path := range files {
+		if path == "" {
+			continue
+		}
+		err := cfg.loadConfig(path)
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			continue
+		} else if err != nil {
+			return cfg, sources, fmt.Errorf("%s: %w", path, err)
+		}
+		sources = append(sources, path)
+	}
+
+	env, err := applyConfigEnv(reflect.ValueOf(&cfg).Elem(), configEnvPrefix)
+	if err != nil {
+		return cfg, sources, err
+	}
+	sources = append(sources, env...)
+	if len(sources) == 0 {
+		return cfg, nil, fmt.Errorf("no configuration: create %s or %s, pass --config, or set %sRECIPE_ROOTS", projectConfigFile, userConfigFile(), configEnvPrefix)
+	}
+	return cfg, sources, nil
+}
+
+// applyConfigEnv sets the fields of the struct v from the environment
+// variables named prefix plus their YAML key in upper case, recursing into
+// nested structs, and returns the names of the variables it used. Lists
+// are separated by the OS path list separator.
+func applyConfigEnv(v reflect.Value, prefix string) ([]string, error) {
+	var used []string
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		field := v.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			nested, err := applyConfigEnv(field, name+"_")
+			if err != nil {
+				return nil, err
+			}
+			used = append(used, nested...)
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setConfigField(field, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		used = append(used, "$"+name)
+	}
+	return used, nil
+}
+
+func setConfigField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == reflect.TypeFor[time.Duration]():
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range filepath.SplitList(value) {
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items).Convert(field.Type()))
+	case field.CanInt():
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.CanUint():
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+// rootSettingsFor returns the root_settings of the recipe root holding the
+// recipe in dir.
+func (b builderConfig) rootSettingsFor(dir string) rootSettings {
+	recipeDir := canonicalPath(dir)
+	for _, root := range b.RecipeRoots {
+		settings, ok := b.RootSettings[root]
+		if !ok {
+			continue
+		}
+		if rel, err := filepath.Rel(canonicalPath(root), recipeDir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return settings
+		}
+	}
+	return rootSettings{}
+}
+
+// includeDirsFor returns the include directories of the recipe in dir:
+// those of its recipe root, then the global ones.
+func (b builderConfig) includeDirsFor(dir string) []string {
+	own := b.rootSettingsFor(dir).IncludeDirs
+	if len(own) == 0 {
+		return b.IncludeDirs
+	}
+	return append(append([]string(nil), own...), b.IncludeDirs...)
+}
+
+// allIncludeDirs returns the global include directories and those of every
+// recipe root.
+func (b builderConfig) allIncludeDirs() []string {
+	dirs := append([]string(nil), b.IncludeDirs...)
+	for _, root := range b.RecipeRoots {
+		dirs = append(dirs, b.RootSettings[root].IncludeDirs...)
+	}
+	return dirs
+}
+
+var configCmd = cobra.Command{
+	Use:   "config",
+	Short: "Inspect the builder configuration",
+}
+
+var configShowCmd = cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration merged from the config files and BUILDER_* environment variables",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, sources, err := loadLayeredConfig()
+		if err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# sources, lowest precedence first: %s\n%s", strings.Join(sources, ", "), out)
+		return nil
+	},
+}
+
+func init() {
+	configCmd.AddCommand(&configShowCmd)
+	rootCmd.AddCommand(&configCmd)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+var convertNeurodockerCmd = cobra.Command{
+	Use:   "convert-neurodocker [file]",
+	Short: "Convert a Neurodocker JSON/YAML specification or command line into a build.yaml recipe",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("name")
+		version, _ := cmd.Flags().GetString("version")
+		outPath, _ := cmd.Flags().GetString("output")
+		cli, _ := cmd.Flags().GetString("cli")
+
+		var spec *recipe.NeurodockerSpec
+		var err error
+		switch {
+		case cli != "" && len(args) == 0:
+			spec, err = recipe.ParseNeurodockerArgs(cli)
+		case cli == "" && len(args) == 1:
+			spec, err = readNeurodockerFile(args[0])
+		default:
+			return fmt.Errorf("give either a file or --cli")
+		}
+		if err != nil {
+			return err
+		}
+
+		if name == "" {
+			if len(args) == 0 || args[0] == "-" {
+				return fmt.Errorf("--name is required when converting a command line or stdin")
+			}
+			name = strings.ToLower(strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0])))
+		}
+
+		build, warnings, err := recipe.ConvertNeurodocker(spec, name, version)
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+		}
+		if err != nil {
+			return fmt.Errorf("converting neurodocker specification: %w", err)
+		}
+		return writeRecipeYAML(build, outPath)
+	},
+}
+
+// readNeurodockerFile reads a specification, or a command line when the
+// file does not start with a mapping.
+func readNeurodockerFile(path string) (*recipe.NeurodockerSpec, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	if strings.HasPrefix(text, "neurodocker") || strings.HasPrefix(text, "--") {
+		return recipe.ParseNeurodockerArgs(text)
+	}
+	return recipe.ParseNeurodockerSpec(data)
+}
+
+func init() {
+	convertNeurodockerCmd.Flags().String("cli", "", "Neurodocker command line to convert instead of a file")
+	convertNeurodockerCmd.Flags().String("name", "", "Recipe name (default: the file name without extension)")
+	convertNeurodockerCmd.Flags().String("version", "1.0.0", "Recipe version")
+	convertNeurodockerCmd.Flags().String("output", "", "Write the recipe to this file instead of stdout")
+	rootCmd.AddCommand(&convertNeurodockerCmd)
+}
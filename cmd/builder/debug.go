@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	bkclient "github.com/moby/buildkit/client"
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+var buildDebugOnFailure bool
+
+// debugTailSize is how much of the docker build output is kept to find the
+// failed step.
+const debugTailSize = 64 << 10
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.max {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.max:]...)
+	}
+	return len(p), nil
+}
+
+// dockerfileErrorLine matches the Dockerfile location BuildKit prints
+// above the excerpt of a failed instruction.
+var dockerfileErrorLine = regexp.MustCompile(`(?m)^Dockerfile:(\d+)\s*$`)
+
+// failedDockerfileStep returns the index of the directive that failed a
+// docker build, from the build output.
+func failedDockerfileStep(def *ir.Definition, output []byte) (int, bool) {
+	m := dockerfileErrorLine.FindAllSubmatch(output, -1)
+	if len(m) == 0 {
+		return 0, false
+	}
+	line, err := strconv.Atoi(string(m[len(m)-1][1]))
+	if err != nil {
+		return 0, false
+	}
+	return ir.DockerfileStep(def, line)
+}
+
+// failedReportStep returns the index of the first failed directive of an
+// LLB build report.
+func failedReportStep(report ir.BuildReport) (int, bool) {
+	for _, d := range report.Directives {
+		if d.Status == ir.DirectiveFailed {
+			return d.Step - 1, true
+		}
+	}
+	return 0, false
+}
+
+// debugFailedStep builds the state the failed directive step ran in as
+// <name>:<version>-debug and opens a shell in it, with the staged cache and
+// the --local contexts mounted where the build mounts them. With method
+// docker the state is built with docker build; otherwise with opts, whose
+// exports are replaced by a load into Docker.
+func debugFailedStep(stage *genericStageResult, res *dockerStageResult, locals []string, argValues map[string]string, step int, opts ir.SubmitOptions) error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("the debug shell needs the docker CLI in PATH")
+	}
+	def := stage.irDef.Truncate(step)
+	hasFrom := false
+	for _, d := range def.Directives {
+		switch d.Directive.(type) {
+		case ir.FromImageDirective, ir.StageDirective:
+			hasFrom = true
+		}
+	}
+	if !hasFrom {
+		return fmt.Errorf("step %d has no earlier state to debug", step+1)
+	}
+	failed := stage.irDef.Directives[step]
+	tag := res.Tag + "-debug"
+	fmt.Printf("Building the state before [step %d] %s as %s\n", step+1, failed.Describe(), tag)
+
+	if buildMethod == "docker" {
+		dockerfile, err := ir.GenerateDockerfile(def)
+		if err != nil {
+			return err
+		}
+		dockerfilePath := filepath.Join(res.BuildDir, "Dockerfile.debug")
+		if err := os.WriteFile(dockerfilePath, []byte(dockerfile), 0o644); err != nil {
+			return err
+		}
+		build := exec.Command("docker", dockerBuildArgs(tag, dockerfilePath, res.CacheDir, res.BuildDir, locals, argValues, "")...)
+		build.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+		build.Stdout = os.Stdout
+		build.Stderr = os.Stderr
+		if err := build.Run(); err != nil {
+			return fmt.Errorf("building %s: %w", tag, err)
+		}
+	} else {
+		llbDef, err := ir.GenerateLLBDefinitionWithOptions(def, ir.LLBOptions{BuildArgs: argValues, Platform: buildPlatform})
+		if err != nil {
+			return err
+		}
+		opts.Exports = []bkclient.ExportEntry{dockerLoadExport(tag, buildMethod == "llb", opts.BuilderName)}
+		opts.ImageConfig = def
+		events := make(chan ir.Event)
+		go func() {
+			for range events {
+			}
+		}()
+		err = ir.Submit(context.Background(), llbDef, opts, events)
+		close(events)
+		if err != nil {
+			return fmt.Errorf("building %s: %w", tag, err)
+		}
+	}
+	return runDebugShell(tag, res, locals, failed.Directive)
+}
+
+// runDebugShell runs an interactive shell in the image tag. The command of
+// a failed RUN is mounted at /.neurocontainer-debug/step.sh.
+func runDebugShell(tag string, res *dockerStageResult, locals []string, failed ir.Directive) error {
+	args := []string{"run", "--rm", "-i"}
+	if st, err := os.Stdin.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 {
+		args = append(args, "-t")
+	}
+	if buildPlatform != "" {
+		args = append(args, "--platform", buildPlatform)
+	}
+	mount := func(dir, target string) error {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-v", abs+":"+target+":ro")
+		return nil
+	}
+	if err := mount(res.CacheDir, "/.neurocontainer-cache"); err != nil {
+		return err
+	}
+	for _, kv := range locals {
+		if key, dir, ok := strings.Cut(kv, "="); ok && key != "" {
+			if err := mount(dir, "/.neurocontainer-local/"+key); err != nil {
+				return err
+			}
+		}
+	}
+
+	var command string
+	switch v := failed.(type) {
+	case ir.RunDirective:
+		command = string(v)
+	case ir.RunWithMountsDirective:
+		command = v.Command
+	}
+	if command != "" {
+		dir, err := os.MkdirTemp("", "builder-debug-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if err := os.WriteFile(filepath.Join(dir, "step.sh"), []byte(command+"\n"), 0o755); err != nil {
+			return err
+		}
+		if err := mount(dir, "/.neurocontainer-debug"); err != nil {
+			return err
+		}
+		fmt.Println("The failed command is in /.neurocontainer-debug/step.sh; rerun it with: sh -lex /.neurocontainer-debug/step.sh")
+	}
+	args = append(args, "--entrypoint", "/bin/sh", tag, "-c", "command -v bash >/dev/null && exec bash || exec sh")
+
+	fmt.Printf("Running: docker %s\n", strings.Join(args, " "))
+	shell := exec.Command("docker", args...)
+	shell.Stdin = os.Stdin
+	shell.Stdout = os.Stdout
+	shell.Stderr = os.Stderr
+	// The shell's exit status is the user's; only failing to start matters.
+	if err := shell.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return fmt.Errorf("starting the debug shell: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/ir/docker"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/neurodesk/builder/pkg/resolve"
+	"github.com/neurodesk/builder/pkg/textdiff"
+	"github.com/spf13/cobra"
+)
+
+// stagingPlanFile is written next to the generated Dockerfile in
+// local/build/<recipe> so `builder diff` can compare staging plans too.
+const stagingPlanFile = "staging-plan.txt"
+
+// stagingPlanSummary renders the files a plan stages, one per line in name
+// order, with where each comes from; local files are found with r.
+func stagingPlanSummary(r resolve.Resolver, plan *recipe.StagingPlan) string {
+	sources := stagedFileSources(r, plan)
+	executable := map[string]bool{}
+	if plan != nil {
+		for _, f := range plan.Files {
+			executable[f.Name] = f.Executable
+		}
+	}
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s", name, sources[name])
+		if executable[name] {
+			b.WriteString(" (executable)")
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+var diffCmd = cobra.Command{
+	Use:   "diff [recipe]",
+	Short: "Diff the generated Dockerfile and staging plan against the last ones staged in local/build, with the layers whose cache is invalidated",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		stage, err := prepareStage(cfg, args[0], nil, options)
+		if err != nil {
+			return err
+		}
+		dockerfile, err := ir.GenerateDockerfile(stage.irDef)
+		if err != nil {
+			return fmt.Errorf("generating dockerfile: %w", err)
+		}
+
+		buildDir := filepath.Join("local", "build", stage.build.Name)
+		oldDockerfile, err := readPrevious(filepath.Join(buildDir, "Dockerfile"))
+		if err != nil {
+			return err
+		}
+		oldPlan, err := readPrevious(filepath.Join(buildDir, stagingPlanFile))
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if oldDockerfile == "" {
+			fmt.Fprintf(out, "No previous Dockerfile in %s; run `builder build` to stage one.\n", buildDir)
+			return nil
+		}
+		return writeRecipeDiff(out, buildDir, oldDockerfile, dockerfile, oldPlan, stagingPlanSummary(fileResolver(cfg, stage.recipePath), stage.plan))
+	},
+}
+
+// readPrevious returns the contents of a previously generated file, or ""
+// if there is none.
+func readPrevious(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+func writeRecipeDiff(w io.Writer, buildDir, oldDockerfile, newDockerfile, oldPlan, newPlan string) error {
+	dockerfilePath := filepath.Join(buildDir, "Dockerfile")
+	planPath := filepath.Join(buildDir, stagingPlanFile)
+	if d := textdiff.Unified(dockerfilePath, "generated Dockerfile", oldDockerfile, newDockerfile, 3); d != "" {
+		fmt.Fprint(w, d)
+	}
+	if d := textdiff.Unified(planPath, "generated staging plan", oldPlan, newPlan, 3); d != "" {
+		fmt.Fprint(w, d)
+	}
+
+	changes, err := docker.CompareStageCaches(oldDockerfile, newDockerfile)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Cache impact:")
+	for _, c := range changes {
+		switch {
+		case c.Added:
+			fmt.Fprintf(w, "  stage %s: new, %d layers to build\n", c.Stage, c.Layers)
+		case c.FirstChanged == 0:
+			fmt.Fprintf(w, "  stage %s: cached (%d instructions)\n", c.Stage, c.Instructions)
+		default:
+			fmt.Fprintf(w, "  stage %s: invalidated from instruction %d of %d, %d of %d layers rebuilt\n",
+				c.Stage, c.FirstChanged, c.Instructions, c.RebuiltLayers, c.Layers)
+			fmt.Fprintf(w, "    first changed: %s\n", firstLine(c.Instruction))
+		}
+	}
+	if oldPlan != newPlan {
+		fmt.Fprintln(w, "  staged files changed: steps that copy or mount them are rebuilt as well")
+	}
+	return nil
+}
+
+// firstLine shortens a multi-line instruction for the summary.
+func firstLine(s string) string {
+	if line, _, ok := strings.Cut(s, "\n"); ok {
+		return line + " ..."
+	}
+	return s
+}
+
+func init() {
+	diffCmd.Flags().StringArray("option", []string{}, "Set a recipe option as KEY=VALUE (repeatable)")
+	rootCmd.AddCommand(&diffCmd)
+}
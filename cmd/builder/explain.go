@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+var explainCmd = cobra.Command{
+	Use:   "explain [recipe]",
+	Short: "Print the generated Dockerfile with each instruction annotated with the recipe directive that produced it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		build, err := cfg.getRecipeByName(args[0])
+		if err != nil {
+			return err
+		}
+
+		arch, _ := cmd.Flags().GetString("arch")
+
+		out, _, err := build.GenerateWithParams(recipe.GenerateParams{
+			IncludeDirs: cfg.includeDirsFor(build.Dir()),
+			Options:     options,
+			Arch:        recipe.CPUArchitecture(arch),
+		})
+		if err != nil {
+			return fmt.Errorf("generating build IR: %w", err)
+		}
+
+		dockerfile, err := ir.ExplainDockerfile(out)
+		if err != nil {
+			return fmt.Errorf("generating dockerfile: %w", err)
+		}
+
+		fmt.Println(dockerfile)
+		return nil
+	},
+}
+
+func init() {
+	explainCmd.Flags().StringArray("option", []string{}, "Set a recipe option as KEY=VALUE (repeatable)")
+	explainCmd.Flags().String("arch", "", "Target architecture (x86_64 or aarch64); defaults to the host's if the recipe supports it")
+	rootCmd.AddCommand(&explainCmd)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultExportDir is used when neither --output-dir nor export_dir is set.
+var defaultExportDir = filepath.Join("local", "export")
+
+var exportCmd = cobra.Command{
+	Use:   "export [recipe]",
+	Short: "Convert a built image into a distributable artifact (SIF)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		format, _ := cmd.Flags().GetString("format")
+		if format != "sif" {
+			return fmt.Errorf("unsupported export format %q (supported: sif)", format)
+		}
+		tool, _ := cmd.Flags().GetString("tool")
+		image, _ := cmd.Flags().GetString("image")
+		outDir, _ := cmd.Flags().GetString("output-dir")
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		if outDir == "" {
+			outDir = cfg.ExportDir
+		}
+		if outDir == "" {
+			outDir = defaultExportDir
+		}
+		stage, err := prepareStage(cfg, args[0], nil, options)
+		if err != nil {
+			return err
+		}
+		sif, err := exportSIF(stage, image, tool, outDir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("SIF written to %s\n", sif)
+		return nil
+	},
+}
+
+// exportSIF converts the image built for stage into
+// <outDir>/<name>_<version>.sif and returns its path. The image is read from
+// the local Docker daemon, or from a registry when image is set (as after an
+// LLB build with --push).
+func exportSIF(stage *genericStageResult, image, tool, outDir string) (string, error) {
+	tool, err := sifTool(tool)
+	if err != nil {
+		return "", fmt.Errorf("%w; install one or pass --tool", err)
+	}
+
+	bootstrap := "docker"
+	if image == "" {
+		bootstrap = "docker-daemon"
+		image = stage.build.Name + ":" + stage.version
+		if out, err := exec.Command("docker", "image", "inspect", image).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("docker image %s not found; build it first: %w\n%s", image, err, out)
+		}
+	}
+
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return "", fmt.Errorf("creating output directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp("", "builder-export-")
+	if err != nil {
+		return "", fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	defPath := filepath.Join(tmp, "Singularity.def")
+	def := sifDefinition(bootstrap, image, finalEnvironment(stage.irDef), stage.irDef.Labels(), stage.plan.Readme)
+	if err := os.WriteFile(defPath, []byte(def), 0o644); err != nil {
+		return "", fmt.Errorf("writing definition file: %w", err)
+	}
+
+	sifPath := filepath.Join(outDir, stage.build.Name+"_"+stage.version+".sif")
+	build := exec.Command(tool, "build", "--force", sifPath, defPath)
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	fmt.Printf("Running: %s build --force %s %s\n", tool, sifPath, defPath)
+	if err := build.Run(); err != nil {
+		return "", fmt.Errorf("%s build failed: %w", tool, err)
+	}
+	return sifPath, nil
+}
+
+// sifDefinition returns an Apptainer definition file that bootstraps from
+// image and re-exports its deploy variables, so they survive tools that only
+// read %environment. Labels are copied and the readme becomes %help.
+func sifDefinition(bootstrap, image string, env, labels map[string]string, readme string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Bootstrap: %s\nFrom: %s\n", bootstrap, image)
+
+	var deploy []string
+	for _, k := range []string{"DEPLOY_BINS", "DEPLOY_PATH"} {
+		if v, ok := env[k]; ok {
+			deploy = append(deploy, fmt.Sprintf("    export %s=%s\n", k, strconv.Quote(v)))
+		}
+	}
+	if len(deploy) > 0 {
+		b.WriteString("\n%environment\n")
+		for _, line := range deploy {
+			b.WriteString(line)
+		}
+	}
+
+	if len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("\n%labels\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "    %s %s\n", k, strings.Join(strings.Fields(labels[k]), " "))
+		}
+	}
+
+	if readme = strings.TrimSpace(readme); readme != "" {
+		b.WriteString("\n%help\n")
+		for _, line := range strings.Split(readme, "\n") {
+			// A line starting with % would open a new section.
+			if strings.HasPrefix(line, "%") {
+				line = " " + line
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
+
+func init() {
+	exportCmd.Flags().String("format", "sif", "Artifact format (sif)")
+	exportCmd.Flags().String("tool", "", "Program used to build the SIF (default: apptainer, else singularity)")
+	exportCmd.Flags().String("output-dir", "", "Directory to write the artifact to (default: export_dir from the config, else local/export)")
+	exportCmd.Flags().String("image", "", "Registry image ref to export instead of the local Docker image")
+	exportCmd.Flags().StringArray("option", []string{}, "Select the image built with recipe option KEY=VALUE (repeatable)")
+	rootCmd.AddCommand(&exportCmd)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+var fmtCmd = cobra.Command{
+	Use:   "fmt [recipe...]",
+	Short: "Rewrite build.yaml files in canonical form (all recipes when none are given); --check lists those that are not",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		check, _ := cmd.Flags().GetBool("check")
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		recipes := args
+		if len(recipes) == 0 {
+			if recipes, err = listRecipes(cfg); err != nil {
+				return err
+			}
+		}
+
+		unformatted := 0
+		for _, spec := range recipes {
+			dir, err := resolveRecipePath(cfg, spec)
+			if err != nil {
+				return err
+			}
+			path := filepath.Join(dir, "build.yaml")
+			changed, err := formatRecipeFile(path, !check)
+			if err != nil {
+				return err
+			}
+			if changed {
+				unformatted++
+				fmt.Println(path)
+			}
+		}
+		if check && unformatted > 0 {
+			return fmt.Errorf("%d recipes are not formatted; run builder fmt", unformatted)
+		}
+		return nil
+	},
+}
+
+// formatRecipeFile formats the build.yaml at path, writing the result back
+// when write is set, and reports whether it changed.
+func formatRecipeFile(path string, write bool) (bool, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	out, err := recipe.FormatBuildFile(src)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	if bytes.Equal(src, out) {
+		return false, nil
+	}
+	if write {
+		if err := os.WriteFile(path, out, 0o644); err != nil {
+			return false, fmt.Errorf("writing %s: %w", path, err)
+		}
+	}
+	return true, nil
+}
+
+func init() {
+	fmtCmd.Flags().Bool("check", false, "Only list the recipes that are not formatted, exiting with an error if there are any (for CI)")
+	rootCmd.AddCommand(&fmtCmd)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// impactTarget is the change `graph --impact` analyses: a recipe, an
+// include or template file, or a template by name.
+type impactTarget struct {
+	desc string
+	// matches reports whether a directive with provenance p comes from the
+	// change.
+	matches func(p ir.Provenance) bool
+	// file is the canonical path of a changed file, for recipes that read
+	// it without a directive coming from it, such as a COPY source.
+	file string
+}
+
+// resolveImpactTarget interprets spec as an existing file, then a recipe,
+// then a template name. A recipe counts as changed from its first
+// directive, since which of them changed is not known.
+func resolveImpactTarget(cfg builderConfig, spec string) (impactTarget, error) {
+	if info, err := os.Stat(spec); err == nil && !info.IsDir() {
+		if filepath.Base(spec) == "build.yaml" {
+			return recipeImpactTarget(cfg, filepath.Dir(spec))
+		}
+		return fileImpactTarget(cfg, spec), nil
+	}
+	if dir, err := resolveRecipePath(cfg, spec); err == nil {
+		if _, err := os.Stat(filepath.Join(dir, "build.yaml")); err == nil {
+			return recipeImpactTarget(cfg, dir)
+		}
+	}
+	if strings.ContainsAny(spec, `/\.`) {
+		return impactTarget{}, fmt.Errorf("--impact %s: no such recipe or file", spec)
+	}
+	return impactTarget{
+		desc:    "template " + spec,
+		matches: func(p ir.Provenance) bool { return p.Template == spec },
+	}, nil
+}
+
+func recipeImpactTarget(cfg builderConfig, dir string) (impactTarget, error) {
+	target := fileImpactTarget(cfg, filepath.Join(dir, "build.yaml"))
+	build, err := loadRecipe(dir)
+	if err != nil {
+		return impactTarget{}, err
+	}
+	target.desc = "recipe " + build.Name
+	return target, nil
+}
+
+// fileImpactTarget matches the directives written in file, which
+// provenance names relative to its recipe root or include directory, and
+// those expanded from the template file defines.
+func fileImpactTarget(cfg builderConfig, file string) impactTarget {
+	canon := canonicalPath(file)
+	roots := slices.Concat(cfg.RecipeRoots, cfg.allIncludeDirs())
+	var template string
+	ext := filepath.Ext(file)
+	dir := canonicalPath(filepath.Dir(file))
+	switch {
+	case (ext == ".yaml" || ext == ".yml") && (filepath.Base(dir) == "template_specs" || cfg.TemplateDir != "" && dir == canonicalPath(cfg.TemplateDir)):
+		template = strings.TrimSuffix(filepath.Base(file), ext)
+	case ext == ".star":
+		template = strings.TrimSuffix(filepath.Base(file), ext)
+	}
+	return impactTarget{
+		desc: "file " + file,
+		matches: func(p ir.Provenance) bool {
+			if template != "" && p.Template == template {
+				return true
+			}
+			if p.File == "" {
+				return false
+			}
+			for _, root := range roots {
+				if canonicalPath(filepath.Join(root, filepath.FromSlash(p.File))) == canon {
+					return true
+				}
+			}
+			return false
+		},
+		file: canon,
+	}
+}
+
+// layerImpact is the part of a recipe's layer chain a change invalidates.
+type layerImpact struct {
+	result *recipeGenerationResult
+	// invalidated are the indexes of the invalidated directives.
+	invalidated []int
+	// reason describes the first invalidated layer.
+	reason string
+}
+
+// analyseImpact returns the recipes whose cached layers the change
+// invalidates, and the graph nodes of those layers. A layer is invalidated
+// when it comes from the change or follows one that is in the same stage,
+// since each layer's cache key includes its parent; a stage copying from an
+// invalidated stage is invalidated from the copy on.
+func analyseImpact(results []*recipeGenerationResult, target impactTarget) ([]layerImpact, map[string]bool) {
+	var impacts []layerImpact
+	nodes := map[string]bool{}
+	for _, res := range results {
+		directives := res.Compiled.Definition.Directives
+		impact := layerImpact{result: res}
+		invalid := false
+		stage := ""
+		invalidStages := map[string]bool{}
+		for i, dm := range directives {
+			switch d := dm.Directive.(type) {
+			case ir.StageDirective:
+				stage, invalid = d.Name, false
+				continue
+			case ir.FromImageDirective:
+				stage, invalid = "", false
+				continue
+			case ir.CopyFromDirective:
+				if !invalid && invalidStages[d.Stage] {
+					invalid = true
+					impact.note(fmt.Sprintf("copies from invalidated stage %s", d.Stage))
+				}
+			}
+			if !invalid && target.matches(dm.Provenance) {
+				invalid = true
+				impact.note(dm.Provenance.String())
+			}
+			if invalid {
+				impact.invalidated = append(impact.invalidated, i)
+				if stage != "" {
+					invalidStages[stage] = true
+				}
+			}
+		}
+		if len(impact.invalidated) == 0 && target.file != "" && readsFile(res, target.file) {
+			// The recipe reads the file, but no directive says where: count
+			// every layer but the base images.
+			for i, dm := range directives {
+				switch dm.Directive.(type) {
+				case ir.StageDirective, ir.FromImageDirective:
+				default:
+					impact.invalidated = append(impact.invalidated, i)
+				}
+			}
+			impact.reason = "reads the file; the layers using it are not known, so all are counted"
+		}
+		if len(impact.invalidated) == 0 {
+			continue
+		}
+		for _, i := range impact.invalidated {
+			hash, _ := directiveHashAndSummary(directives[i].Directive)
+			nodes["layer_"+strings.ToLower(hash)] = true
+		}
+		impacts = append(impacts, impact)
+	}
+	return impacts, nodes
+}
+
+// note records why the first invalidated layer is invalidated.
+func (l *layerImpact) note(reason string) {
+	if l.reason == "" {
+		l.reason = reason
+	}
+}
+
+func readsFile(res *recipeGenerationResult, file string) bool {
+	if res.Compiled.Plan == nil {
+		return false
+	}
+	for _, in := range res.Compiled.Plan.Inputs {
+		if canonicalPath(in) == file {
+			return true
+		}
+	}
+	return false
+}
+
+// printImpact reports, per affected recipe, how many of its layers are
+// invalidated and the first of them.
+func printImpact(w io.Writer, target impactTarget, impacts []layerImpact, total int) {
+	fmt.Fprintf(w, "Impact of %s:\n", target.desc)
+	for _, impact := range impacts {
+		build := impact.result.Compiled.Build
+		directives := impact.result.Compiled.Definition.Directives
+		first := directives[impact.invalidated[0]]
+		_, summary := directiveHashAndSummary(first.Directive)
+		fmt.Fprintf(w, "  %s:%s  %d/%d layers invalidated from %s\n", build.Name, build.Version, len(impact.invalidated), len(directives), shortenLabel(summary, 72))
+		if impact.reason != "" {
+			fmt.Fprintf(w, "    (%s)\n", impact.reason)
+		}
+	}
+	fmt.Fprintf(w, "%d of %d recipes affected\n", len(impacts), total)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/manifest"
+	"github.com/spf13/cobra"
+)
+
+// recordBuild appends the manifest of a finished build to the local store.
+// Failing to record is logged rather than failing the build. Partial builds
+// (--until) are not recorded.
+func recordBuild(stage *genericStageResult, method, tag string, started time.Time, buildErr error, digest string, report *ir.BuildReport) {
+	if stage.partial {
+		return
+	}
+	m := manifest.Manifest{
+		Recipe:          stage.build.Name,
+		Version:         stage.version,
+		Tag:             tag,
+		Method:          method,
+		Status:          manifest.StatusSucceeded,
+		ImageDigest:     digest,
+		BuilderVersion:  manifest.BuilderVersion(),
+		Started:         started.UTC(),
+		Duration:        time.Since(started),
+		DirectiveHashes: manifest.DirectiveHashes(stage.irDef),
+		InputDigest:     stage.inputDigest,
+		Report:          report,
+	}
+	if len(stage.build.Architectures) > 0 {
+		m.Arch = string(stage.build.Architectures[0])
+	}
+	if buildErr != nil {
+		m.Status = manifest.StatusFailed
+		m.Error = buildErr.Error()
+		if report != nil {
+			for _, d := range report.Directives {
+				if d.Status == ir.DirectiveFailed {
+					m.FailedStep = d.Label
+					if m.FailedStep == "" {
+						m.FailedStep = string(d.Source)
+					}
+					break
+				}
+			}
+		}
+	}
+	// Recipes outside a git checkout simply have no commit.
+	if commit, err := gitOutput(stage.recipePath, "log", "-1", "--format=%H", "--", "."); err == nil {
+		m.RecipeCommit = commit
+	}
+	observeBuild(m)
+	if err := (manifest.Store{Dir: manifest.DefaultDir}).Add(m); err != nil {
+		slog.Warn("recording build manifest", "error", err)
+	}
+}
+
+// dockerImageID returns the local image ID of ref, or "" if docker cannot
+// inspect it.
+func dockerImageID(ref string) string {
+	out, err := exec.Command("docker", "image", "inspect", "--format", "{{.Id}}", ref).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+var historyCmd = cobra.Command{
+	Use:   "history [recipe]",
+	Short: "List recorded builds from local/manifests, of one recipe or all of them",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		recipeName := ""
+		if len(args) == 1 {
+			recipeName = args[0]
+		}
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		entries, err := (manifest.Store{Dir: manifest.DefaultDir}).History(recipeName)
+		if err != nil {
+			return fmt.Errorf("reading build history: %w", err)
+		}
+		out := cmd.OutOrStdout()
+		if asJSON {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			if entries == nil {
+				entries = []manifest.Manifest{}
+			}
+			return enc.Encode(entries)
+		}
+		if len(entries) == 0 {
+			fmt.Fprintln(out, "No builds recorded.")
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "STARTED\tRECIPE\tVERSION\tMETHOD\tSTATUS\tDURATION\tDIGEST\tCOMMIT")
+		for _, m := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				m.Started.Local().Format("2006-01-02 15:04"), m.Recipe, m.Version, m.Method, m.Status,
+				m.Duration.Round(time.Second), shortHash(strings.TrimPrefix(m.ImageDigest, "sha256:")), shortHash(m.RecipeCommit))
+		}
+		return tw.Flush()
+	},
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	if h == "" {
+		return "-"
+	}
+	return h
+}
+
+func init() {
+	historyCmd.Flags().Bool("json", false, "Print the manifests as JSON")
+	rootCmd.AddCommand(&historyCmd)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	bkclient "github.com/moby/buildkit/client"
+)
+
+var (
+	buildOCILayout string
+	buildLoad      bool
+	buildPlatform  string
+)
+
+// ociLayoutExport exports the image named tag as an OCI image layout: a
+// tarball when path ends in .tar, else a directory.
+func ociLayoutExport(path, tag string) (bkclient.ExportEntry, error) {
+	entry := bkclient.ExportEntry{
+		Type:  bkclient.ExporterOCI,
+		Attrs: map[string]string{"name": tag},
+	}
+	if strings.HasSuffix(path, ".tar") {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return entry, err
+		}
+		entry.Output = func(map[string]string) (io.WriteCloser, error) {
+			return os.Create(path)
+		}
+		return entry, nil
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return entry, err
+	}
+	entry.Attrs["tar"] = "false"
+	entry.OutputDir = path
+	return entry, nil
+}
+
+// dockerLoadExport loads the image into Docker as tag. A buildx builder
+// with the docker driver shares Docker's image store and exports to it
+// directly; any other builder streams a docker image tarball into
+// "docker load".
+func dockerLoadExport(tag string, viaBuildx bool, builderName string) bkclient.ExportEntry {
+	if viaBuildx && buildxDriver(builderName) == "docker" {
+		return bkclient.ExportEntry{Type: "moby", Attrs: map[string]string{"name": tag}}
+	}
+	return bkclient.ExportEntry{
+		Type:  bkclient.ExporterDocker,
+		Attrs: map[string]string{"name": tag},
+		Output: func(map[string]string) (io.WriteCloser, error) {
+			cmd := exec.Command("docker", "load")
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			stdin, err := cmd.StdinPipe()
+			if err != nil {
+				return nil, err
+			}
+			if err := cmd.Start(); err != nil {
+				return nil, fmt.Errorf("starting docker load: %w", err)
+			}
+			return &dockerLoader{stdin: stdin, cmd: cmd}, nil
+		},
+	}
+}
+
+// dockerLoader is the stdin of a running "docker load"; Close waits for it.
+type dockerLoader struct {
+	stdin io.WriteCloser
+	cmd   *exec.Cmd
+}
+
+func (l *dockerLoader) Write(p []byte) (int, error) { return l.stdin.Write(p) }
+
+func (l *dockerLoader) Close() error {
+	if err := l.stdin.Close(); err != nil {
+		return err
+	}
+	if err := l.cmd.Wait(); err != nil {
+		return fmt.Errorf("docker load: %w", err)
+	}
+	return nil
+}
+
+// buildxDriver returns the driver of the buildx builder name (the current
+// builder when empty), or "" when it cannot be inspected.
+func buildxDriver(name string) string {
+	args := []string{"buildx", "inspect"}
+	if name != "" {
+		args = append(args, name)
+	}
+	out, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		return ""
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "Driver:"); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
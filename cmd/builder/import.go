@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v4"
+)
+
+var importDockerfileCmd = cobra.Command{
+	Use:   "import-dockerfile [Dockerfile]",
+	Short: "Convert an existing Dockerfile into a build.yaml recipe",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("name")
+		version, _ := cmd.Flags().GetString("version")
+		outPath, _ := cmd.Flags().GetString("output")
+
+		path := args[0]
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			path = filepath.Join(path, "Dockerfile")
+		}
+		if name == "" {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			name = strings.ToLower(filepath.Base(filepath.Dir(abs)))
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		build, warnings, err := recipe.ImportDockerfile(f, name, version)
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+		}
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", path, err)
+		}
+		return writeRecipeYAML(build, outPath)
+	},
+}
+
+// writeRecipeYAML writes build as YAML to outPath, or stdout when empty.
+func writeRecipeYAML(build *recipe.BuildFile, outPath string) error {
+	out, err := yaml.Marshal(build)
+	if err != nil {
+		return fmt.Errorf("encoding recipe: %w", err)
+	}
+	if outPath == "" {
+		_, err := os.Stdout.Write(out)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	if err := os.WriteFile(outPath, out, 0o644); err != nil {
+		return fmt.Errorf("writing recipe: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Recipe written to %s\n", outPath)
+	return nil
+}
+
+func init() {
+	importDockerfileCmd.Flags().String("name", "", "Recipe name (default: the Dockerfile's directory name)")
+	importDockerfileCmd.Flags().String("version", "1.0.0", "Recipe version")
+	importDockerfileCmd.Flags().String("output", "", "Write the recipe to this file instead of stdout")
+	rootCmd.AddCommand(&importDockerfileCmd)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/neurodesk/builder/pkg/licenses"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+// licensesLabel carries the license summary of an image, for audits of
+// images that are already published.
+const licensesLabel = "org.neurodesk.builder.licenses"
+
+// licensesLabelMax is the number of licenses the label lists by name.
+const licensesLabelMax = 10
+
+// licenseReport is the output of builder licenses --format json.
+type licenseReport struct {
+	Recipe  string `json:"recipe"`
+	Version string `json:"version"`
+	Image   string `json:"image"`
+	// Copyright are the recipe's copyright entries, the licenses of the
+	// software it packages.
+	Copyright []recipe.Copyright `json:"copyright,omitempty"`
+	Packages  []licenses.Package `json:"packages"`
+	Denied    []string           `json:"denied,omitempty"`
+	Summary   string             `json:"summary"`
+}
+
+// imagePackages lists the packages installed in the image ref.
+func imagePackages(ref string) ([]licenses.Package, error) {
+	out, err := exec.Command("docker", "run", "--rm", "--network", "none", "--entrypoint", "/bin/sh", ref, "-c", licenses.Script, "sh").Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing the packages of %s: %w", ref, err)
+	}
+	return licenses.Parse(string(out)), nil
+}
+
+// labelImage adds the label key=value to the image ref, keeping its tag.
+func labelImage(ref, key, value string) error {
+	build := exec.Command("docker", "build", "--label", key+"="+value, "-t", ref, "-")
+	build.Stdin = strings.NewReader("FROM " + ref + "\n")
+	build.Stdout = os.Stderr
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		return fmt.Errorf("labelling %s: %w", ref, err)
+	}
+	return nil
+}
+
+var licensesCmd = cobra.Command{
+	Use:   "licenses <recipe>",
+	Short: "Report the licenses of the packages in a recipe's built image and of the recipe itself, flagging those on the deny list",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := listFormat(cmd)
+		if err != nil {
+			return err
+		}
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+		image, _ := cmd.Flags().GetString("image")
+		deny, _ := cmd.Flags().GetStringArray("deny")
+		label, _ := cmd.Flags().GetBool("label")
+
+		if _, err := exec.LookPath("docker"); err != nil {
+			return fmt.Errorf("docker CLI not found in PATH; builder licenses inspects images in the local Docker engine")
+		}
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		deny = append(cfg.LicenseDeny, deny...)
+		dir, err := resolveRecipePath(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		build, err := loadRecipe(dir)
+		if err != nil {
+			return err
+		}
+		resolved, err := build.ResolveOptions(options)
+		if err != nil {
+			return err
+		}
+		version := build.VersionWithOptions(resolved)
+		if image == "" {
+			image = build.Name + ":" + version
+		}
+
+		pkgs, err := imagePackages(image)
+		if err != nil {
+			return err
+		}
+		report := licenseReport{
+			Recipe:    build.Name,
+			Version:   version,
+			Image:     image,
+			Copyright: build.Copyright,
+			Packages:  pkgs,
+			Summary:   licenses.Summary(pkgs, 0),
+		}
+		for _, c := range build.Copyright {
+			if licenses.Denied(c.License, deny) {
+				report.Denied = append(report.Denied, fmt.Sprintf("recipe copyright %s (%s)", c.Name, c.License))
+			}
+		}
+		for _, p := range licenses.Check(pkgs, deny) {
+			report.Denied = append(report.Denied, fmt.Sprintf("%s package %s %s (%s)", p.Manager, p.Name, p.Version, p.License))
+		}
+
+		out := cmd.OutOrStdout()
+		if format == "json" {
+			if err := printJSON(out, report); err != nil {
+				return err
+			}
+		} else {
+			for _, c := range build.Copyright {
+				fmt.Fprintf(out, "Recipe license: %s\n", strings.Join(nonEmpty(c.License, c.Name, c.URL), ", "))
+			}
+			tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "MANAGER\tPACKAGE\tVERSION\tLICENSE\t")
+			for _, p := range pkgs {
+				license, flag := p.License, ""
+				if license == "" {
+					license = "unknown"
+				}
+				if p.Denied {
+					flag = "DENIED"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.Manager, p.Name, p.Version, shortenLabel(license, 60), flag)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "\n%d packages: %s\n", len(pkgs), report.Summary)
+		}
+
+		if label {
+			if err := labelImage(image, licensesLabel, licenses.Summary(pkgs, licensesLabelMax)); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Labelled %s with %s\n", image, licensesLabel)
+		}
+		if len(report.Denied) > 0 {
+			return fmt.Errorf("%d packages are under a denied license:\n  %s", len(report.Denied), strings.Join(report.Denied, "\n  "))
+		}
+		return nil
+	},
+}
+
+func nonEmpty(values ...string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func init() {
+	licensesCmd.Flags().StringArray("option", []string{}, "Set a recipe option as KEY=VALUE (repeatable)")
+	licensesCmd.Flags().String("image", "", "Image to inspect (default <name>:<version> of the recipe)")
+	licensesCmd.Flags().StringArray("deny", nil, "Also deny this license, an SPDX identifier or a prefix ending in * (repeatable; added to license_deny from the config)")
+	licensesCmd.Flags().String("format", "table", "Output format (table, json)")
+	licensesCmd.Flags().Bool("label", false, "Add the license summary to the image as the "+licensesLabel+" label")
+	rootCmd.AddCommand(&licensesCmd)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var lintCmd = cobra.Command{
+	Use:   "lint [recipe...]",
+	Short: "Check recipe readmes and deploy paths (all recipes when none are given); --strict also rejects deprecated templates",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		strict, _ := cmd.Flags().GetBool("strict")
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		recipes := args
+		if len(recipes) == 0 {
+			if recipes, err = listRecipes(cfg); err != nil {
+				return err
+			}
+		}
+
+		failed := 0
+		for _, spec := range recipes {
+			issues, err := lintRecipe(cfg, spec, strict)
+			if err != nil {
+				issues = []string{err.Error()}
+			}
+			if len(issues) == 0 {
+				continue
+			}
+			failed++
+			for _, issue := range issues {
+				fmt.Printf("%s: %s\n", filepath.Base(spec), issue)
+			}
+		}
+		fmt.Printf("Linted %d recipes: %d with issues\n", len(recipes), failed)
+		if failed > 0 {
+			return fmt.Errorf("%d recipes have lint issues", failed)
+		}
+		return nil
+	},
+}
+
+// lintRecipe returns the lint issues of a recipe. With strict, the recipe
+// is also generated and every deprecated template it uses is an issue.
+func lintRecipe(cfg builderConfig, spec string, strict bool) ([]string, error) {
+	path, err := resolveRecipePath(cfg, spec)
+	if err != nil {
+		return nil, err
+	}
+	build, err := loadRecipe(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading build file: %w", err)
+	}
+	issues := build.LintReadme(cfg.includeDirsFor(path))
+	issues = append(issues, build.LintDeploy(cfg.includeDirsFor(path))...)
+	if !strict {
+		return issues, nil
+	}
+	_, plan, err := build.GenerateWithStaging(cfg.includeDirsFor(path))
+	if err != nil {
+		return nil, fmt.Errorf("generating: %w", err)
+	}
+	for _, n := range plan.TemplateNotices {
+		if n.Deprecated {
+			issues = append(issues, n.String())
+		}
+	}
+	return issues, nil
+}
+
+func init() {
+	lintCmd.Flags().Bool("strict", false, "Also generate each recipe and report deprecated templates as errors")
+	rootCmd.AddCommand(&lintCmd)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/manifest"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+// recipeListing is a recipe as `builder list` and `builder show` print it.
+type recipeListing struct {
+	Name          string                   `json:"name"`
+	Version       string                   `json:"version"`
+	Path          string                   `json:"path"`
+	Draft         bool                     `json:"draft,omitempty"`
+	Architectures []recipe.CPUArchitecture `json:"architectures"`
+	Categories    []recipe.Category        `json:"categories,omitempty"`
+	// LastBuild is the latest build recorded in local/manifests.
+	LastBuild *lastBuild `json:"last_build,omitempty"`
+	// Error is why the recipe could not be loaded.
+	Error string `json:"error,omitempty"`
+}
+
+type lastBuild struct {
+	Status  manifest.Status `json:"status"`
+	Version string          `json:"version"`
+	Arch    string          `json:"arch,omitempty"`
+	Started time.Time       `json:"started"`
+}
+
+// recipeDetails is what `builder show` adds to a recipeListing.
+type recipeDetails struct {
+	recipeListing
+	Readme    string               `json:"readme,omitempty"`
+	ReadmeURL string               `json:"readme_url,omitempty"`
+	Deploy    recipe.CatalogDeploy `json:"deploy"`
+	Options   []optionListing      `json:"options,omitempty"`
+	// Files are the files staged into the build context.
+	Files []fileListing `json:"files,omitempty"`
+	// Templates are the templates the recipe uses, in order of first use.
+	Templates []string `json:"templates,omitempty"`
+	// Inputs are the local files generation read.
+	Inputs []string `json:"inputs,omitempty"`
+}
+
+type optionListing struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     any    `json:"default"`
+	Values      []any  `json:"values,omitempty"`
+}
+
+type fileListing struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+// latestBuilds returns the latest recorded build of each recipe, by name.
+func latestBuilds() map[string]*lastBuild {
+	latest, err := (manifest.Store{Dir: manifest.DefaultDir}).Latest()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: reading build history: %v\n", err)
+	}
+	builds := map[string]*lastBuild{}
+	for _, m := range latest {
+		builds[m.Recipe] = &lastBuild{Status: m.Status, Version: m.Version, Arch: m.Arch, Started: m.Started}
+	}
+	return builds
+}
+
+// listRecipe loads the recipe in dir for `builder list`; a recipe that does
+// not load is listed with the error.
+func listRecipe(dir string, builds map[string]*lastBuild) (*recipe.BuildFile, recipeListing) {
+	build, err := loadRecipeWithOptions(dir, recipe.LoadOptions{Mode: recipeLoadMode()})
+	if err != nil {
+		return nil, recipeListing{Path: dir, Error: err.Error()}
+	}
+	return build, recipeListing{
+		Name:          build.Name,
+		Version:       build.Version,
+		Path:          dir,
+		Draft:         build.Draft,
+		Architectures: build.Architectures,
+		Categories:    build.Categories,
+		LastBuild:     builds[build.Name],
+	}
+}
+
+// describeRecipe generates the recipe in dir with its default options and
+// returns its details.
+func describeRecipe(cfg builderConfig, dir string, builds map[string]*lastBuild) (*recipeDetails, error) {
+	build, listing := listRecipe(dir, builds)
+	if build == nil {
+		return nil, fmt.Errorf("loading %s: %s", dir, listing.Error)
+	}
+	compiled, err := compileRecipe(cfg, dir)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := build.CatalogEntry(cfg.includeDirsFor(dir))
+	if err != nil {
+		return nil, err
+	}
+	details := &recipeDetails{
+		recipeListing: listing,
+		Readme:        entry.Readme,
+		ReadmeURL:     build.ReadmeUrl,
+		Deploy:        entry.Deploy,
+		Inputs:        compiled.Plan.Inputs,
+	}
+	for _, name := range slices.Sorted(maps.Keys(build.Options)) {
+		opt := build.Options[name]
+		details.Options = append(details.Options, optionListing{Name: name, Description: opt.Description, Default: opt.Default, Values: opt.Values})
+	}
+	for _, f := range compiled.Plan.Files {
+		details.Files = append(details.Files, fileListing{Name: f.Name, Source: stagedFileSource(f)})
+	}
+	// Templates starting with _, like the _header every recipe gets, are
+	// the builder's own.
+	seen := map[string]bool{}
+	for _, dm := range compiled.Definition.Directives {
+		if t := dm.Provenance.Template; t != "" && !strings.HasPrefix(t, "_") && !seen[t] {
+			seen[t] = true
+			details.Templates = append(details.Templates, t)
+		}
+	}
+	return details, nil
+}
+
+// stagedFileSource describes where a staged file comes from.
+func stagedFileSource(f recipe.StagedFile) string {
+	switch {
+	case f.HostFilename != "":
+		return f.HostFilename
+	case f.URL != "":
+		return f.URL
+	case f.Git != nil:
+		if f.Git.Commit != "" {
+			return f.Git.URL + "@" + f.Git.Commit
+		} else if f.Git.Ref != "" {
+			return f.Git.URL + "@" + f.Git.Ref
+		}
+		return f.Git.URL
+	case f.OCI != nil:
+		return f.OCI.Ref + "@" + f.OCI.Digest
+	default:
+		return "inline contents"
+	}
+}
+
+func listFormat(cmd *cobra.Command) (string, error) {
+	format, _ := cmd.Flags().GetString("format")
+	if format != "table" && format != "json" {
+		return "", fmt.Errorf("unsupported format %q (supported: table, json)", format)
+	}
+	return format, nil
+}
+
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func joinStrings[S ~string](items []S) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = string(item)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (b *lastBuild) String() string {
+	if b == nil {
+		return "never built"
+	}
+	return fmt.Sprintf("%s (%s, %s)", b.Status, b.Version, b.Started.Local().Format("2006-01-02 15:04"))
+}
+
+var listCmd = cobra.Command{
+	Use:   "list",
+	Short: "List the recipes of the configured recipe roots with their version, categories, architectures and last build",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := listFormat(cmd)
+		if err != nil {
+			return err
+		}
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		dirs, err := listRecipes(cfg)
+		if err != nil {
+			return err
+		}
+		builds := latestBuilds()
+		listings := []recipeListing{}
+		for _, dir := range dirs {
+			_, listing := listRecipe(dir, builds)
+			listings = append(listings, listing)
+		}
+
+		out := cmd.OutOrStdout()
+		if format == "json" {
+			return printJSON(out, listings)
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tVERSION\tCATEGORIES\tARCHS\tLAST BUILD")
+		for _, l := range listings {
+			if l.Error != "" {
+				fmt.Fprintf(tw, "%s\t-\t-\t-\tdoes not load: %s\n", l.Path, l.Error)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", l.Name, l.Version, joinStrings(l.Categories), joinStrings(l.Architectures), l.LastBuild)
+		}
+		return tw.Flush()
+	},
+}
+
+var showCmd = cobra.Command{
+	Use:   "show <recipe>",
+	Short: "Show a recipe's resolved metadata, options, staged files and the templates it uses",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := listFormat(cmd)
+		if err != nil {
+			return err
+		}
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		dir, err := resolveRecipePath(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		details, err := describeRecipe(cfg, dir, latestBuilds())
+		if err != nil {
+			return err
+		}
+
+		out := cmd.OutOrStdout()
+		if format == "json" {
+			return printJSON(out, details)
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Name:\t%s\n", details.Name)
+		fmt.Fprintf(tw, "Version:\t%s\n", details.Version)
+		fmt.Fprintf(tw, "Path:\t%s\n", details.Path)
+		if details.Draft {
+			fmt.Fprintf(tw, "Draft:\tyes\n")
+		}
+		fmt.Fprintf(tw, "Architectures:\t%s\n", joinStrings(details.Architectures))
+		fmt.Fprintf(tw, "Categories:\t%s\n", joinStrings(details.Categories))
+		if details.ReadmeURL != "" {
+			fmt.Fprintf(tw, "Readme URL:\t%s\n", details.ReadmeURL)
+		}
+		fmt.Fprintf(tw, "Binaries:\t%s\n", joinStrings(details.Deploy.Bins))
+		fmt.Fprintf(tw, "Deploy path:\t%s\n", joinStrings(details.Deploy.Path))
+		fmt.Fprintf(tw, "Templates:\t%s\n", joinStrings(details.Templates))
+		fmt.Fprintf(tw, "Last build:\t%s\n", details.LastBuild)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if len(details.Options) > 0 {
+			fmt.Fprintln(out, "\nOptions:")
+			for _, o := range details.Options {
+				fmt.Fprintf(tw, "  %s\tdefault %v\t%s\n", o.Name, o.Default, o.Description)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+		if len(details.Files) > 0 {
+			fmt.Fprintln(out, "\nFiles:")
+			for _, f := range details.Files {
+				fmt.Fprintf(tw, "  %s\t%s\n", f.Name, f.Source)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+		if len(details.Inputs) > 0 {
+			fmt.Fprintln(out, "\nInputs:")
+			for _, in := range details.Inputs {
+				fmt.Fprintf(out, "  %s\n", in)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	listCmd.Flags().String("format", "table", "Output format (table, json)")
+	showCmd.Flags().String("format", "table", "Output format (table, json)")
+	rootCmd.AddCommand(&listCmd, &showCmd)
+}
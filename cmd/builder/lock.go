@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/lockfile"
+	"github.com/neurodesk/builder/pkg/manifest"
+	"github.com/neurodesk/builder/pkg/netcache"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+// lockInputs adds to l the inputs of a generation of a recipe that its
+// build file leaves open: the digests of its base images, of the files it
+// downloads and of the commits its git refs name, and its templates.
+func lockInputs(ctx context.Context, l *lockfile.Lock, def *ir.Definition, plan *recipe.StagingPlan) error {
+	hc, gc, oc := netcaches()
+	if err := os.MkdirAll(hc.Dir, 0o755); err != nil {
+		return fmt.Errorf("creating http cache dir: %w", err)
+	}
+	for _, d := range def.Directives {
+		var image string
+		switch d := d.Directive.(type) {
+		case ir.FromImageDirective:
+			image = string(d)
+		case ir.StageDirective:
+			image = d.Image
+		default:
+			continue
+		}
+		if _, ok := l.BaseImages[image]; ok {
+			continue
+		}
+		digest, err := oc.Resolve(ctx, image)
+		if err != nil {
+			return fmt.Errorf("resolving base image %s: %w", image, err)
+		}
+		if l.BaseImages == nil {
+			l.BaseImages = map[string]string{}
+		}
+		l.BaseImages[image] = digest
+	}
+
+	for _, f := range plan.Files {
+		switch {
+		case f.URL != "":
+			if _, ok := l.URLs[f.URL]; ok {
+				continue
+			}
+			path, _, err := hc.Get(ctx, f.URL)
+			if err != nil {
+				return fmt.Errorf("fetching %s: %w", f.URL, err)
+			}
+			sum, err := sha256File(path)
+			if err != nil {
+				return fmt.Errorf("hashing %s: %w", f.URL, err)
+			}
+			if l.URLs == nil {
+				l.URLs = map[string]string{}
+			}
+			l.URLs[f.URL] = "sha256:" + sum
+		case f.Git != nil && f.Git.Commit == "":
+			key := f.Git.URL + "#" + f.Git.Ref
+			if _, ok := l.Git[key]; ok {
+				continue
+			}
+			commit, err := gc.Resolve(ctx, netcache.GitSource{URL: f.Git.URL, Ref: f.Git.Ref})
+			if err != nil {
+				return fmt.Errorf("resolving %s: %w", key, err)
+			}
+			if l.Git == nil {
+				l.Git = map[string]string{}
+			}
+			l.Git[key] = commit
+		}
+	}
+
+	// Templates starting with _ are the builder's own, locked by its
+	// version.
+	for _, t := range plan.Templates {
+		if strings.HasPrefix(t.Name, "_") {
+			continue
+		}
+		if l.Templates == nil {
+			l.Templates = map[string]lockfile.Template{}
+		}
+		l.Templates[t.Name] = lockfile.Template{Version: t.Version, Digest: t.Digest}
+	}
+	return nil
+}
+
+// recipeLock returns the lock of the recipe: its inputs for each of its
+// architectures, with its default options.
+func recipeLock(ctx context.Context, cfg builderConfig, build *recipe.BuildFile) (*lockfile.Lock, error) {
+	l := &lockfile.Lock{Builder: manifest.BuilderVersion()}
+	for _, arch := range build.Architectures {
+		def, plan, err := build.GenerateWithParams(recipe.GenerateParams{
+			IncludeDirs: cfg.includeDirsFor(build.Dir()),
+			Arch:        arch,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("generating for %s: %w", arch, err)
+		}
+		if err := lockInputs(ctx, l, def, plan); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// checkLocked returns an error if the inputs of stage drifted from its
+// recipe's build.lock, for `builder build --locked`.
+func checkLocked(stage *genericStageResult) error {
+	path := filepath.Join(stage.recipePath, lockfile.FileName)
+	locked, err := lockfile.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("--locked: %s does not exist; run builder lock", path)
+	} else if err != nil {
+		return err
+	}
+	current := &lockfile.Lock{Builder: manifest.BuilderVersion()}
+	if err := lockInputs(context.Background(), current, stage.irDef, stage.plan); err != nil {
+		return fmt.Errorf("--locked: %w", err)
+	}
+	if drift := lockfile.Drift(locked, current); len(drift) > 0 {
+		return fmt.Errorf("--locked: the inputs of %s drifted from %s; run builder lock to update it:\n  %s", stage.build.Name, path, strings.Join(drift, "\n  "))
+	}
+	return nil
+}
+
+var lockCmd = cobra.Command{
+	Use:   "lock [recipe...]",
+	Short: "Write build.lock, pinning the base image digests, download digests and templates of recipes (all recipes when none are given); --check reports drift",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		check, _ := cmd.Flags().GetBool("check")
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		recipes := args
+		if len(recipes) == 0 {
+			if recipes, err = listRecipes(cfg); err != nil {
+				return err
+			}
+		}
+
+		drifted := 0
+		for _, spec := range recipes {
+			dir, err := resolveRecipePath(cfg, spec)
+			if err != nil {
+				return err
+			}
+			build, err := loadRecipe(dir)
+			if err != nil {
+				return err
+			}
+			current, err := recipeLock(context.Background(), cfg, build)
+			if err != nil {
+				return fmt.Errorf("locking %s: %w", dir, err)
+			}
+			path := filepath.Join(dir, lockfile.FileName)
+			if check {
+				locked, err := lockfile.Read(path)
+				if errors.Is(err, os.ErrNotExist) {
+					fmt.Printf("%s: missing\n", path)
+					drifted++
+					continue
+				} else if err != nil {
+					return err
+				}
+				problems := append(lockfile.Drift(locked, current), lockfile.Unused(locked, current)...)
+				for _, p := range problems {
+					fmt.Printf("%s: %s\n", path, p)
+				}
+				if len(problems) > 0 {
+					drifted++
+				}
+				continue
+			}
+			data, err := current.Marshal()
+			if err != nil {
+				return err
+			}
+			if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+				continue
+			}
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return fmt.Errorf("writing %s: %w", path, err)
+			}
+			fmt.Println(path)
+		}
+		if drifted > 0 {
+			return fmt.Errorf("%d recipes are not locked as they build; run builder lock", drifted)
+		}
+		return nil
+	},
+}
+
+func init() {
+	lockCmd.Flags().Bool("check", false, "Only report the recipes whose build.lock is missing or out of date, exiting with an error if there are any (for CI)")
+	rootCmd.AddCommand(&lockCmd)
+}
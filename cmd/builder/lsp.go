@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/neurodesk/builder/pkg/lsp"
+	"github.com/spf13/cobra"
+)
+
+var lspCmd = cobra.Command{
+	Use:   "lsp",
+	Short: "Run a language server for build.yaml recipes over stdio",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// The config only adds templates from template_dir; editors often
+		// start the server outside a recipe repository, so it is optional.
+		if _, err := loadBuilderConfig(); err != nil && verbose {
+			fmt.Fprintf(os.Stderr, "lsp: %v\n", err)
+		}
+		return lsp.Serve(os.Stdin, os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(&lspCmd)
+}
@@ -22,10 +22,16 @@ import (
 	"sync"
 	"time"
 
+	bkclient "github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/neurodesk/builder/pkg/archive"
 	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/ir/docker"
 	"github.com/neurodesk/builder/pkg/netcache"
 	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/neurodesk/builder/pkg/resolve"
+	"github.com/neurodesk/builder/pkg/smoketest"
+	starlarkpkg "github.com/neurodesk/builder/pkg/starlark"
 	"github.com/spf13/cobra"
 	"go.yaml.in/yaml/v4"
 )
@@ -42,18 +48,69 @@ type builderConfig struct {
 	IncludeDirs     []string `yaml:"include_dirs"`
 	TemplateDir     string   `yaml:"template_dir,omitempty"`
 	TemplateBackend string   `yaml:"template_backend,omitempty"`
+	// ExportDir is where `builder export` writes artifacts.
+	ExportDir string `yaml:"export_dir,omitempty"`
+	// ReleaseEndpoint is the base URL `builder release` uploads to.
+	ReleaseEndpoint string `yaml:"release_endpoint,omitempty"`
+	// ContainerRoot is where released containers are published on CVMFS.
+	ContainerRoot string `yaml:"container_root,omitempty"`
+	// LicenseDeny are the licenses `builder licenses` flags: SPDX
+	// identifiers, or prefixes ending in *.
+	LicenseDeny []string `yaml:"license_deny,omitempty"`
+	// Starlark bounds the work recipe scripts may do.
+	Starlark starlarkpkg.Limits `yaml:"starlark,omitempty"`
+	// Buildkit is the buildkitd of --method buildctl.
+	Buildkit buildkitConfig `yaml:"buildkit,omitempty"`
+	// RootSettings are the settings of the recipes of a recipe root, by
+	// the root as written in recipe_roots.
+	RootSettings map[string]rootSettings `yaml:"root_settings,omitempty"`
 }
 
 func (b *builderConfig) getRecipeByName(name string) (*recipe.BuildFile, error) {
 	for _, root := range b.RecipeRoots {
 		// look for a directory with the name of the recipe
 		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
-			return recipe.LoadBuildFile(filepath.Join(root, name))
+			return loadRecipe(filepath.Join(root, name))
 		}
 	}
 	return nil, fmt.Errorf("recipe not found: %s", name)
 }
 
+// loadRecipe loads the recipe in dir, applying the --override file if one
+// was given, in the load mode of the --permissive and --strict-fields flags.
+func loadRecipe(dir string) (*recipe.BuildFile, error) {
+	return loadRecipeWithOptions(dir, recipe.LoadOptions{Override: overridePath, Mode: recipeLoadMode()})
+}
+
+// loadRecipeWithOptions loads the recipe in dir, with the template
+// directory of its recipe root if it has one, and logs the problems a
+// permissive load ignored.
+func loadRecipeWithOptions(dir string, opts recipe.LoadOptions) (*recipe.BuildFile, error) {
+	if opts.TemplateDir == "" {
+		opts.TemplateDir = activeConfig.rootSettingsFor(dir).TemplateDir
+	}
+	build, err := recipe.LoadBuildFileWithOptions(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range build.LoadWarnings() {
+		slog.Warn("ignoring unknown field", "recipe", dir, "error", w)
+	}
+	return build, nil
+}
+
+// recipeLoadMode returns the load mode the --permissive and
+// --strict-fields flags select.
+func recipeLoadMode() recipe.LoadMode {
+	switch {
+	case loadPermissive:
+		return recipe.LoadPermissive
+	case loadStrictFields:
+		return recipe.LoadStrict
+	}
+	return recipe.LoadDefault
+}
+
 func (b *builderConfig) loadConfig(path string) error {
 	f, err := os.Open(path)
 	if err != nil {
@@ -68,8 +125,21 @@ func (b *builderConfig) loadConfig(path string) error {
 }
 
 var rootBuilderConfig string
-var testCaptureOutput bool
+var (
+	testCaptureOutput bool
+	testSkipScripts   bool
+	testBuild         bool
+	testKeepImage     bool
+	testRuntimeName   string
+	testSIF           string
+)
 var verbose bool
+var overridePath string
+
+var (
+	loadPermissive   bool
+	loadStrictFields bool
+)
 var graphOutputPath string
 
 var rootCmd = cobra.Command{
@@ -94,12 +164,23 @@ var generateDockerfileCmd = cobra.Command{
 			return err
 		}
 
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+
 		build, err := cfg.getRecipeByName(recipeName)
 		if err != nil {
 			return err
 		}
 
-		out, _, err := build.GenerateWithStaging(cfg.IncludeDirs)
+		arch, _ := cmd.Flags().GetString("arch")
+
+		out, _, err := build.GenerateWithParams(recipe.GenerateParams{
+			IncludeDirs: cfg.includeDirsFor(build.Dir()),
+			Options:     options,
+			Arch:        recipe.CPUArchitecture(arch),
+		})
 		if err != nil {
 			return fmt.Errorf("generating build IR: %w", err)
 		}
@@ -116,16 +197,23 @@ var generateDockerfileCmd = cobra.Command{
 
 // helper: load config and apply template config
 func loadBuilderConfig() (builderConfig, error) {
-	var cfg builderConfig
-	if err := cfg.loadConfig(rootBuilderConfig); err != nil {
+	cfg, _, err := loadLayeredConfig()
+	if err != nil {
+		return cfg, fmt.Errorf("loading config: %w", err)
+	}
+	if err := resolveRemoteRoots(&cfg); err != nil {
 		return cfg, fmt.Errorf("loading config: %w", err)
 	}
+	activeConfig = cfg
 	if cfg.TemplateDir != "" {
 		recipe.SetTemplateSpecDir(cfg.TemplateDir)
 	}
 	if err := recipe.SetTemplateBackend(cfg.TemplateBackend); err != nil {
 		return cfg, fmt.Errorf("configuring template backend: %w", err)
 	}
+	if err := starlarkpkg.SetLimits(cfg.Starlark); err != nil {
+		return cfg, fmt.Errorf("configuring starlark limits: %w", err)
+	}
 	return cfg, nil
 }
 
@@ -143,6 +231,17 @@ func resolveRecipePath(cfg builderConfig, spec string) (string, error) {
 	return "", fmt.Errorf("recipe not found: %s", spec)
 }
 
+// fileResolver locates files.filename entries: the recipe directory first, then
+// include directories; absolute paths are allowed.
+func fileResolver(cfg builderConfig, recipePath string) resolve.Resolver {
+	return resolve.Resolver{RecipeDir: recipePath, IncludeDirs: cfg.includeDirsFor(recipePath), AllowAbsolute: true}
+}
+
+// copySourceResolver locates COPY sources, which must stay inside the recipe directory.
+func copySourceResolver(recipePath string) resolve.Resolver {
+	return resolve.Resolver{RecipeDir: recipePath, Confine: true}
+}
+
 // helper: copy a whole directory tree
 func copyDir(src, dst string) error {
 	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
@@ -161,6 +260,42 @@ func copyDir(src, dst string) error {
 	})
 }
 
+// helper: copy a git working tree, skipping repository metadata and preserving
+// executable bits and symlinks
+func copyGitTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == ".git" && path != src {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyFile(path, target, info.Mode()&0o111 != 0)
+	})
+}
+
 // helper: write a reader to a file path with optional exec bit
 func writeFromReader(dst string, r io.Reader, exec bool) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
@@ -226,97 +361,111 @@ func parseLocalFlags(lvals []string) (keys []string, kvs []string) {
 	return keys, kvs
 }
 
-// helper: parse COPY directives into srcs/dest (best-effort; handles flags and JSON form)
-type copySpec struct {
-	Src  []string
-	Dest string
-}
-
-func parseCopySpecs(dockerfile string) []copySpec {
-	var specs []copySpec
-	lines := strings.Split(dockerfile, "\n")
-	for _, raw := range lines {
-		line := strings.TrimSpace(raw)
-		if line == "" {
-			continue
-		}
-		upper := strings.ToUpper(line)
-		if !strings.HasPrefix(upper, "COPY ") {
-			continue
-		}
-		// JSON form
-		rest := strings.TrimSpace(line[len("COPY "):])
-		if strings.HasPrefix(rest, "[") {
-			// crude JSON array split
-			end := strings.Index(rest, "]")
-			if end <= 0 {
-				continue
-			}
-			inner := rest[1:end]
-			var parts []string
-			for _, p := range strings.Split(inner, ",") {
-				p = strings.TrimSpace(strings.Trim(p, "\""))
-				if p != "" {
-					parts = append(parts, p)
-				}
-			}
-			if len(parts) >= 2 {
-				specs = append(specs, copySpec{Src: parts[:len(parts)-1], Dest: parts[len(parts)-1]})
-			}
-			continue
-		}
-		// Shell form: split quoted and drop flags
-		toks := splitQuoted(rest)
-		// Drop leading --flag tokens
-		i := 0
-		for i < len(toks) && strings.HasPrefix(toks[i], "--") {
-			i++
-		}
-		parts := toks[i:]
-		if len(parts) >= 2 {
-			specs = append(specs, copySpec{Src: parts[:len(parts)-1], Dest: parts[len(parts)-1]})
+// optionFlags collects repeatable --option KEY=VALUE flags into a map.
+func optionFlags(cmd *cobra.Command) (map[string]string, error) {
+	vals, _ := cmd.Flags().GetStringArray("option")
+	out := make(map[string]string, len(vals))
+	for _, kv := range vals {
+		k, val, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid --option %q (want KEY=VALUE)", kv)
 		}
+		out[k] = val
 	}
-	return specs
+	return out, nil
 }
 
-// helper: stage cache/top-level files and COPY sources into the build context
-func stageIntoBuildContext(cfg builderConfig, recipePath, dockerfile, buildDir string, plan *recipe.StagingPlan) error {
-	// 1) stage plan files into cache/
-	cacheDir := filepath.Join(buildDir, "cache")
-	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
-		return fmt.Errorf("creating cache dir: %w", err)
+// netcacheDir returns the directory of a download cache: $env, else
+// local/<name>.
+func netcacheDir(env, name string) string {
+	if dir := os.Getenv(env); dir != "" {
+		return dir
 	}
+	return filepath.Join("local", name)
+}
 
-	httpCacheDir := os.Getenv("BUILDER_HTTP_CACHE_DIR")
-	if httpCacheDir == "" {
-		httpCacheDir = filepath.Join("local", "httpcache")
-	}
+// netcaches returns the HTTP, git and OCI download caches.
+func netcaches() (*netcache.Cache, *netcache.GitCache, *netcache.OCICache) {
+	return netcache.New(netcacheDir("BUILDER_HTTP_CACHE_DIR", "httpcache")),
+		netcache.NewGit(netcacheDir("BUILDER_GIT_CACHE_DIR", "gitcache")),
+		netcache.NewOCI(netcacheDir("BUILDER_OCI_CACHE_DIR", "ocicache"))
+}
 
-	if err := os.MkdirAll(httpCacheDir, 0o755); err != nil {
+// stagePlanFiles materializes staged files (local, downloaded through the HTTP
+// cache, git checkouts or literal contents) under dir.
+func stagePlanFiles(cfg builderConfig, recipePath, dir string, files []recipe.StagedFile) error {
+	hc, gc, oc := netcaches()
+	if err := os.MkdirAll(hc.Dir, 0o755); err != nil {
 		return fmt.Errorf("creating http cache dir: %w", err)
 	}
-
-	hc := netcache.New(httpCacheDir)
-	for _, f := range plan.Files {
-		dst := filepath.Join(cacheDir, filepath.FromSlash(f.Name))
+	for _, f := range files {
+		dst := filepath.Join(dir, filepath.FromSlash(f.Name))
 		switch {
-		case f.HostFilename != "":
-			src := f.HostFilename
-			if !filepath.IsAbs(src) {
-				cand := filepath.Join(recipePath, src)
-				if _, err := os.Stat(cand); err == nil {
-					src = cand
+		case f.Git != nil:
+			if verbose {
+				fmt.Printf("[verbose] Checking out %s -> %s\n", f.Git.URL, dst)
+			}
+			checkout, fromCache, err := gc.Checkout(context.Background(), *f.Git)
+			if err != nil {
+				return fmt.Errorf("checking out %q: %w", f.Git.URL, err)
+			}
+			if verbose {
+				if fromCache {
+					fmt.Printf("[verbose] Using cached checkout %s\n", checkout)
 				} else {
-					for _, d := range cfg.IncludeDirs {
-						alt := filepath.Join(d, src)
-						if _, err := os.Stat(alt); err == nil {
-							src = alt
-							break
-						}
-					}
+					fmt.Printf("[verbose] Checked out to cache %s\n", checkout)
 				}
 			}
+			if err := os.RemoveAll(dst); err != nil {
+				return fmt.Errorf("clearing staged git tree %q: %w", f.Name, err)
+			}
+			if err := copyGitTree(checkout, dst); err != nil {
+				return fmt.Errorf("staging git tree %q: %w", f.Name, err)
+			}
+		case f.OCI != nil:
+			if verbose {
+				fmt.Printf("[verbose] Pulling %s@%s -> %s\n", f.OCI.Ref, f.OCI.Digest, dst)
+			}
+			blob, fromCache, err := oc.Get(context.Background(), *f.OCI)
+			if err != nil {
+				return fmt.Errorf("pulling %q: %w", f.Name, err)
+			}
+			if verbose {
+				if fromCache {
+					fmt.Printf("[verbose] Using cached blob %s\n", blob)
+				} else {
+					fmt.Printf("[verbose] Pulled to cache %s\n", blob)
+				}
+			}
+			if f.Extract != nil {
+				if err := stageArchive(blob, dst, f); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := copyFile(blob, dst, f.Executable); err != nil {
+				return fmt.Errorf("staging pulled file %q: %w", f.Name, err)
+			}
+		case f.HostFilename != "":
+			src, tree, err := fileResolver(cfg, recipePath).FindTree("file", f.HostFilename)
+			if err != nil {
+				return fmt.Errorf("staging local file %q: %w", f.Name, err)
+			}
+			if tree != nil {
+				if f.Extract != nil {
+					return fmt.Errorf("staging %q: extract needs a single archive, but %q names %d files", f.Name, f.HostFilename, len(tree))
+				}
+				if err := stageFileTree(tree, dst, f); err != nil {
+					return err
+				}
+				continue
+			}
+			if f.Extract != nil {
+				if err := stageArchive(src, dst, f); err != nil {
+					return err
+				}
+				continue
+			}
 			if verbose {
 				fmt.Printf("[verbose] Staging local file %s -> %s\n", src, dst)
 			}
@@ -339,6 +488,12 @@ func stageIntoBuildContext(cfg builderConfig, recipePath, dockerfile, buildDir s
 					fmt.Printf("[verbose] Downloaded to cache %s\n", localPath)
 				}
 			}
+			if f.Extract != nil {
+				if err := stageArchive(localPath, dst, f); err != nil {
+					return err
+				}
+				continue
+			}
 			if err := copyFile(localPath, dst, f.Executable); err != nil {
 				return fmt.Errorf("staging downloaded file %q: %w", f.URL, err)
 			}
@@ -351,6 +506,81 @@ func stageIntoBuildContext(cfg builderConfig, recipePath, dockerfile, buildDir s
 			}
 		}
 	}
+	return nil
+}
+
+// stageFileTree copies the files a directory or glob pattern matched into
+// the directory dst, keeping their relative paths and executable bits.
+func stageFileTree(tree []resolve.Match, dst string, f recipe.StagedFile) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return fmt.Errorf("clearing staged files %q: %w", f.Name, err)
+	}
+	for _, m := range tree {
+		target := filepath.Join(dst, filepath.FromSlash(m.Rel))
+		if verbose {
+			fmt.Printf("[verbose] Staging local file %s -> %s\n", m.Path, target)
+		}
+		executable := f.Executable
+		if st, err := os.Stat(m.Path); err == nil && st.Mode()&0o111 != 0 {
+			executable = true
+		}
+		if err := copyFile(m.Path, target, executable); err != nil {
+			return fmt.Errorf("staging local file %q: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// stageArchive unpacks the archive src of the staged file f into the
+// directory dst, replacing what was staged there before.
+func stageArchive(src, dst string, f recipe.StagedFile) error {
+	if verbose {
+		fmt.Printf("[verbose] Extracting %s -> %s\n", src, dst)
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return fmt.Errorf("clearing staged archive %q: %w", f.Name, err)
+	}
+	opts := archive.Options{StripComponents: f.Extract.StripComponents, Subdir: f.Extract.Subdir}
+	if err := archive.Extract(src, dst, opts); err != nil {
+		return fmt.Errorf("extracting %q: %w", f.Name, err)
+	}
+	return nil
+}
+
+// writeContextFiles writes the files that the Dockerfile generated from def
+// copies from the build context, replacing those of earlier builds, and
+// returns their paths.
+func writeContextFiles(buildDir string, def *ir.Definition) (map[string]bool, error) {
+	if err := os.RemoveAll(filepath.Join(buildDir, ir.ContextDir)); err != nil {
+		return nil, fmt.Errorf("clearing %s: %w", ir.ContextDir, err)
+	}
+	paths := map[string]bool{}
+	for _, f := range ir.ContextFiles(def) {
+		path := filepath.Join(buildDir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(f.Contents), 0o644); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", path, err)
+		}
+		paths[f.Path] = true
+	}
+	return paths, nil
+}
+
+// helper: stage cache/top-level files, the files the Dockerfile generated
+// from def copies from the build context, and COPY sources into the build
+// context
+func stageIntoBuildContext(cfg builderConfig, recipePath string, def *ir.Definition, dockerfile, buildDir string, plan *recipe.StagingPlan) error {
+	// 1) stage plan files into cache/
+	cacheDir := filepath.Join(buildDir, "cache")
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return fmt.Errorf("creating cache dir: %w", err)
+	}
+
+	if err := stagePlanFiles(cfg, recipePath, cacheDir, plan.Files); err != nil {
+		return err
+	}
 
 	// Build a set of virtual file names declared via files{} to support COPY of virtual files
 	vset := map[string]struct{}{}
@@ -360,11 +590,27 @@ func stageIntoBuildContext(cfg builderConfig, recipePath, dockerfile, buildDir s
 		}
 	}
 
-	// 2) stage COPY sources into build context (relative to recipe dir)
-	baseDirAbs, _ := filepath.Abs(recipePath)
+	// 2) write the large literal files the Dockerfile copies in
+	contextFiles, err := writeContextFiles(buildDir, def)
+	if err != nil {
+		return err
+	}
+
+	// 3) stage COPY sources into build context (relative to recipe dir)
+	copies, err := docker.CopyInstructions(dockerfile)
+	if err != nil {
+		return fmt.Errorf("reading COPY instructions: %w", err)
+	}
 	buildDirAbs, _ := filepath.Abs(buildDir)
-	for _, spec := range parseCopySpecs(dockerfile) {
-		for _, srcRel := range spec.Src {
+	for _, cp := range copies {
+		if cp.From != "" {
+			// The sources are in another stage or an image.
+			continue
+		}
+		for _, srcRel := range cp.Src {
+			if contextFiles[srcRel] {
+				continue
+			}
 			// Normalize to forward slashes for checks
 			srcNorm := strings.TrimPrefix(strings.ReplaceAll(srcRel, "\\", "/"), "./")
 
@@ -407,9 +653,19 @@ func stageIntoBuildContext(cfg builderConfig, recipePath, dockerfile, buildDir s
 			if !strings.Contains(srcNorm, "/") {
 				if _, ok := vset[srcNorm]; ok {
 					cacheSrc := filepath.Join(buildDir, "cache", filepath.FromSlash(srcNorm))
-					if st, err := os.Stat(cacheSrc); err != nil || st.IsDir() {
+					st, err := os.Stat(cacheSrc)
+					if err != nil {
 						return fmt.Errorf("virtual COPY source %q not found in staged cache at %q", srcRel, cacheSrc)
 					}
+					if st.IsDir() {
+						if verbose {
+							fmt.Printf("[verbose] Materializing virtual directory %s -> %s\n", cacheSrc, bcPath)
+						}
+						if err := copyDir(cacheSrc, bcPath); err != nil {
+							return fmt.Errorf("copying virtual directory %q into build context: %w", srcRel, err)
+						}
+						continue
+					}
 					if verbose {
 						fmt.Printf("[verbose] Materializing virtual file %s -> %s\n", cacheSrc, bcPath)
 					}
@@ -421,13 +677,9 @@ func stageIntoBuildContext(cfg builderConfig, recipePath, dockerfile, buildDir s
 			}
 
 			// Fallback: treat as real file from the recipe directory
-			src := filepath.Join(baseDirAbs, filepath.FromSlash(srcRel))
-			srcEval, err := filepath.EvalSymlinks(src)
+			srcEval, err := copySourceResolver(recipePath).Find("COPY source", srcRel)
 			if err != nil {
-				return fmt.Errorf("COPY source %q not found in recipe directory", srcRel)
-			}
-			if rel, err := filepath.Rel(baseDirAbs, srcEval); err != nil || strings.HasPrefix(rel, "..") {
-				return fmt.Errorf("COPY source %q is outside the recipe directory", srcRel)
+				return err
 			}
 			st, err := os.Stat(srcEval)
 			if err != nil {
@@ -487,16 +739,23 @@ type genericStageResult struct {
 	build      *recipe.BuildFile
 	plan       *recipe.StagingPlan
 	locals     []string
+	// version is the image version: the recipe version plus the
+	// version_suffix of every enabled option.
+	version string
+	// inputDigest is the input digest of the build, set by skipUpToDate.
+	inputDigest string
+	// partial is set when --until truncated irDef.
+	partial bool
 }
 
 // helper: generate, render, write dockerfile, and stage files/COPYs
-func prepareStage(cfg builderConfig, recipeSpec string, locals []string) (*genericStageResult, error) {
+func prepareStage(cfg builderConfig, recipeSpec string, locals []string, options map[string]string) (*genericStageResult, error) {
 	recipePath, err := resolveRecipePath(cfg, recipeSpec)
 	if err != nil {
 		return nil, err
 	}
 
-	build, err := recipe.LoadBuildFile(recipePath)
+	build, err := loadRecipe(recipePath)
 	if err != nil {
 		return nil, fmt.Errorf("loading build file: %w", err)
 	}
@@ -504,10 +763,17 @@ func prepareStage(cfg builderConfig, recipeSpec string, locals []string) (*gener
 	// local keys for named contexts
 	keys, _ := parseLocalFlags(locals)
 
-	irDef, plan, err := build.GenerateWithStagingAndLocals(cfg.IncludeDirs, keys)
+	irDef, plan, err := build.GenerateWithParams(recipe.GenerateParams{
+		IncludeDirs: cfg.includeDirsFor(recipePath),
+		Locals:      keys,
+		Options:     options,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("generating build IR: %w", err)
 	}
+	writeRecipeDeps(build, recipePath, plan)
+	// Options were validated by GenerateWithParams.
+	resolved, _ := build.ResolveOptions(options)
 
 	return &genericStageResult{
 		cfg:        cfg,
@@ -516,6 +782,7 @@ func prepareStage(cfg builderConfig, recipeSpec string, locals []string) (*gener
 		build:      build,
 		plan:       plan,
 		locals:     keys,
+		version:    build.VersionWithOptions(resolved),
 	}, nil
 }
 
@@ -526,6 +793,9 @@ func prepareDockerStage(stage *genericStageResult) (*dockerStageResult, error) {
 	if err != nil {
 		return nil, fmt.Errorf("generating dockerfile: %w", err)
 	}
+	if err := ir.VerifyDockerfile(stage.irDef, dockerfile); err != nil {
+		return nil, fmt.Errorf("generated Dockerfile does not match the IR: %w", err)
+	}
 
 	if strings.Contains(dockerfile, "\" + ") {
 		return nil, fmt.Errorf("detected unrendered string concatenation in generated Dockerfile; fix recipe/templates")
@@ -541,16 +811,26 @@ func prepareDockerStage(stage *genericStageResult) (*dockerStageResult, error) {
 	if err := os.WriteFile(dockerfilePath, []byte(dockerfile), 0o644); err != nil {
 		return nil, fmt.Errorf("writing Dockerfile: %w", err)
 	}
+	if err := writeReadme(filepath.Join(buildDir, "README.md"), stage.plan.Readme); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(buildDir, stagingPlanFile), []byte(stagingPlanSummary(fileResolver(stage.cfg, stage.recipePath), stage.plan)), 0o644); err != nil {
+		return nil, fmt.Errorf("writing staging plan: %w", err)
+	}
 
 	// Stage files
-	if err := stageIntoBuildContext(stage.cfg, stage.recipePath, dockerfile, buildDir, stage.plan); err != nil {
+	if err := stageIntoBuildContext(stage.cfg, stage.recipePath, stage.irDef, dockerfile, buildDir, stage.plan); err != nil {
 		return nil, err
 	}
 
+	tag := build.Name + ":" + stage.version
+	if stage.partial {
+		tag += "-partial"
+	}
 	return &dockerStageResult{
 		Name:           build.Name,
-		Version:        build.Version,
-		Tag:            build.Name + ":" + build.Version,
+		Version:        stage.version,
+		Tag:            tag,
 		Arch:           string(build.Architectures[0]),
 		BuildDir:       buildDir,
 		DockerfilePath: dockerfilePath,
@@ -560,12 +840,27 @@ func prepareDockerStage(stage *genericStageResult) (*dockerStageResult, error) {
 	}, nil
 }
 
+// writeReadme writes the rendered readme next to a generated Dockerfile for
+// docs publication, removing a stale one when the recipe has none.
+func writeReadme(path, readme string) error {
+	if readme == "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing stale readme: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(readme), 0o644); err != nil {
+		return fmt.Errorf("writing readme: %w", err)
+	}
+	return nil
+}
+
 func compileRecipe(cfg builderConfig, recipeDir string) (*compiledRecipe, error) {
-	build, err := recipe.LoadBuildFile(recipeDir)
+	build, err := loadRecipeWithOptions(recipeDir, recipe.LoadOptions{Mode: recipeLoadMode()})
 	if err != nil {
 		return nil, fmt.Errorf("failed to load build file: %w", err)
 	}
-	def, plan, err := build.GenerateWithStaging(cfg.IncludeDirs)
+	def, plan, err := build.GenerateWithStaging(cfg.includeDirsFor(recipeDir))
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate IR: %w", err)
 	}
@@ -587,13 +882,18 @@ func generateDockerfileForRecipe(cfg builderConfig, recipeDir, outputDir string)
 	if err != nil {
 		return nil, err
 	}
+	writeRecipeDeps(compiled.Build, recipeDir, compiled.Plan)
 	if err := os.MkdirAll(outputDir, 0o755); err != nil {
 		return nil, fmt.Errorf("creating output directory: %w", err)
 	}
-	outputPath := filepath.Join(outputDir, fmt.Sprintf("%s_%s.Dockerfile", compiled.Build.Name, compiled.Build.Version))
+	base := fmt.Sprintf("%s_%s", compiled.Build.Name, compiled.Build.Version)
+	outputPath := filepath.Join(outputDir, base+".Dockerfile")
 	if err := os.WriteFile(outputPath, []byte(compiled.Dockerfile), 0o644); err != nil {
 		return nil, fmt.Errorf("writing dockerfile: %w", err)
 	}
+	if err := writeReadme(filepath.Join(outputDir, base+".README.md"), compiled.Plan.Readme); err != nil {
+		return nil, err
+	}
 	issues := validateCompiledRecipe(cfg, compiled)
 	return &recipeGenerationResult{
 		Compiled:   compiled,
@@ -609,32 +909,18 @@ func validateCompiledRecipe(cfg builderConfig, compiled *compiledRecipe) []strin
 	if _, err := parser.Parse(strings.NewReader(compiled.Dockerfile)); err != nil {
 		return []string{fmt.Sprintf("BuildKit parser validation failed: %v", err)}
 	}
+	if err := ir.VerifyDockerfile(compiled.Definition, compiled.Dockerfile); err != nil {
+		return []string{fmt.Sprintf("generated Dockerfile does not match the IR: %v", err)}
+	}
 	var issues []string
 	missing := false
 	for _, f := range compiled.Plan.Files {
 		if f.HostFilename == "" {
 			continue
 		}
-		src := f.HostFilename
-		var candidates []string
-		if filepath.IsAbs(src) {
-			candidates = []string{src}
-		} else {
-			candidates = append(candidates, filepath.Join(compiled.Path, src))
-			for _, d := range cfg.IncludeDirs {
-				candidates = append(candidates, filepath.Join(d, src))
-			}
-		}
-		found := false
-		for _, cand := range candidates {
-			if st, err := os.Stat(cand); err == nil && !st.IsDir() {
-				found = true
-				break
-			}
-		}
-		if !found {
+		if _, _, err := fileResolver(cfg, compiled.Path).FindTree("file", f.HostFilename); err != nil {
 			missing = true
-			issues = append(issues, fmt.Sprintf("Missing file referenced by files: %s (searched: %s)", src, strings.Join(candidates, ", ")))
+			issues = append(issues, fmt.Sprintf("Missing file referenced by files: %v", err))
 		}
 	}
 
@@ -645,8 +931,22 @@ func validateCompiledRecipe(cfg builderConfig, compiled *compiledRecipe) []strin
 		}
 	}
 
-	for _, spec := range parseCopySpecs(compiled.Dockerfile) {
-		for _, srcRel := range spec.Src {
+	copies, err := docker.CopyInstructions(compiled.Dockerfile)
+	if err != nil {
+		return []string{fmt.Sprintf("BuildKit would reject the generated Dockerfile: %v", err)}
+	}
+	contextFiles := map[string]bool{}
+	for _, f := range ir.ContextFiles(compiled.Definition) {
+		contextFiles[f.Path] = true
+	}
+	for _, cp := range copies {
+		if cp.From != "" {
+			continue
+		}
+		for _, srcRel := range cp.Src {
+			if contextFiles[srcRel] {
+				continue
+			}
 			srcNorm := strings.TrimPrefix(strings.ReplaceAll(srcRel, "\\", "/"), "./")
 			if filepath.IsAbs(srcRel) {
 				if after, ok := strings.CutPrefix(srcNorm, "/.neurocontainer-cache/"); ok {
@@ -675,23 +975,9 @@ func validateCompiledRecipe(cfg builderConfig, compiled *compiledRecipe) []strin
 				}
 			}
 
-			srcPath := filepath.Join(compiled.Path, filepath.FromSlash(srcRel))
-			eval, err := filepath.EvalSymlinks(srcPath)
-			if err != nil {
+			if _, err := copySourceResolver(compiled.Path).Find("COPY source", srcRel); err != nil {
 				missing = true
-				issues = append(issues, fmt.Sprintf("COPY source not found: %s (from %s)", srcRel, srcPath))
-				continue
-			}
-			baseAbs, _ := filepath.Abs(compiled.Path)
-			evalAbs, _ := filepath.Abs(eval)
-			if rel, err := filepath.Rel(baseAbs, evalAbs); err != nil || strings.HasPrefix(rel, "..") {
-				missing = true
-				issues = append(issues, fmt.Sprintf("COPY source escapes recipe directory: %s -> %s", srcRel, eval))
-				continue
-			}
-			if _, err := os.Stat(eval); err != nil {
-				missing = true
-				issues = append(issues, fmt.Sprintf("COPY source missing: %s (resolved %s)", srcRel, eval))
+				issues = append(issues, err.Error())
 			}
 		}
 	}
@@ -711,6 +997,8 @@ func buildTesterBinary(goarch string) (string, func(), error) {
 	outputPath := filepath.Join(tmpDir, "tester")
 	args := []string{"build", "-o", outputPath, "./cmd/tester"}
 	cmd := exec.Command("go", args...)
+	// BUILDER_SOURCE_DIR lets the CLI run outside the repository checkout.
+	cmd.Dir = os.Getenv("BUILDER_SOURCE_DIR")
 	cmd.Env = append(os.Environ(), "GOOS=linux", "GOARCH="+goarch)
 	if verbose {
 		fmt.Printf("Building tester binary (GOARCH=%s)\n", goarch)
@@ -742,18 +1030,103 @@ func goArchFromRecipe(b *recipe.BuildFile) (string, error) {
 	}
 }
 
-func runTesterInContainer(tag, testerPath, platform string, captureOutput bool) ([]byte, error) {
-	mount := fmt.Sprintf("%s:/tester/tester:ro", testerPath)
-	args := []string{"run", "--rm"}
-	if platform != "" {
-		args = append(args, "--platform", platform)
-	}
-	args = append(args, "-v", mount, "--entrypoint", "/tester/tester", tag)
+func runTesterInContainer(ctx context.Context, rt testRuntime, testerPath string, captureOutput bool, extraArgs, testerArgs []string) ([]byte, error) {
+	args := append(append([]string{}, extraArgs...), "-v", testerPath+":/tester/tester:ro")
+	argv := []string{"/tester/tester"}
 	if captureOutput {
-		args = append(args, "--capture-output")
+		argv = append(argv, "--capture-output")
 	}
-	cmd := exec.Command("docker", args...)
-	return cmd.CombinedOutput()
+	argv = append(argv, testerArgs...)
+	// Pass the image's own entrypoint so the tester can check that the deploy
+	// environment survives it.
+	if entrypoint := rt.entrypoint(); entrypoint != "" {
+		argv = append(argv, "--image-entrypoint", entrypoint)
+	}
+	var out bytes.Buffer
+	err := rt.run(ctx, "builder-tester", args, argv, &out)
+	return out.Bytes(), err
+}
+
+// decodeTesterResults decodes the JSON results the tester printed into v.
+// output is what the container wrote to stdout and stderr, so the results
+// can be followed by the tester's error log when it fails.
+func decodeTesterResults(output []byte, v any) error {
+	return json.NewDecoder(bytes.NewReader(output)).Decode(v)
+}
+
+// reportDroppedDeployEnv prints a warning to w for each invocation path through
+// which the tester saw DEPLOY_BINS/DEPLOY_PATH go missing or change.
+func reportDroppedDeployEnv(w io.Writer, output []byte) {
+	var results struct {
+		Environment []struct {
+			Invocation string
+			Dropped    []string
+			Changed    []string
+			Skipped    bool
+			Error      string
+		}
+	}
+	if err := decodeTesterResults(output, &results); err != nil {
+		return
+	}
+	for _, probe := range results.Environment {
+		if probe.Skipped {
+			continue
+		}
+		if len(probe.Dropped) > 0 {
+			fmt.Fprintf(w, "warning: %s drops %s\n", probe.Invocation, strings.Join(probe.Dropped, ", "))
+		}
+		if len(probe.Changed) > 0 {
+			fmt.Fprintf(w, "warning: %s changes %s\n", probe.Invocation, strings.Join(probe.Changed, ", "))
+		}
+		if probe.Error != "" {
+			fmt.Fprintf(w, "warning: %s: %s\n", probe.Invocation, probe.Error)
+		}
+	}
+}
+
+// failedTesterChecks prints the outcome of each command test, runtime check
+// and GUI app check the tester ran to w and returns an error naming those
+// that failed.
+func failedTesterChecks(w io.Writer, output []byte) error {
+	var results struct {
+		Commands []smoketest.Result
+		GUIApps  []smoketest.GUIResult
+		Runtime  []smoketest.Result
+	}
+	if err := decodeTesterResults(output, &results); err != nil {
+		return nil
+	}
+	var failed []string
+	for _, res := range append(results.Commands, results.Runtime...) {
+		if res.Passed {
+			fmt.Fprintf(w, "PASS %s\n", res.Name)
+			continue
+		}
+		fmt.Fprintf(w, "FAIL %s: %s\n", res.Name, res.Error)
+		// The last line is usually the error, e.g. a Python exception.
+		if out := strings.TrimSpace(res.Output); out != "" {
+			lines := strings.Split(out, "\n")
+			fmt.Fprintf(w, "     %s\n", lines[len(lines)-1])
+		}
+		failed = append(failed, res.Name)
+	}
+	for _, res := range results.GUIApps {
+		name := "gui app " + res.Name
+		switch {
+		case !res.Passed:
+			fmt.Fprintf(w, "FAIL %s: %s\n", name, res.Error)
+			failed = append(failed, name)
+		case res.Launch == "skipped":
+			fmt.Fprintf(w, "PASS %s (found %s; not launched, the image has no xvfb-run)\n", name, res.FullPath)
+		default:
+			fmt.Fprintf(w, "PASS %s (%s on a virtual display)\n", name, res.Launch)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d tester check(s) failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
 }
 
 var testCmd = cobra.Command{
@@ -764,8 +1137,34 @@ var testCmd = cobra.Command{
 		if verbose {
 			os.Setenv("BUILDER_VERBOSE", "1")
 		}
-		if _, err := exec.LookPath("docker"); err != nil {
-			return fmt.Errorf("docker CLI not found in PATH; please install Docker and rerun")
+		var tool string
+		switch testRuntimeName {
+		case "docker":
+			if testSIF != "" {
+				return fmt.Errorf("--sif needs --runtime apptainer or singularity")
+			}
+		case "apptainer", "singularity":
+			if testSIF != "" && testBuild {
+				return fmt.Errorf("--sif tests an existing SIF and cannot be combined with --build")
+			}
+			// apptainer falls back to singularity, as in builder export.
+			if testRuntimeName == "singularity" {
+				tool = "singularity"
+			}
+			var err error
+			if tool, err = sifTool(tool); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown --runtime %q (supported: docker, apptainer, singularity)", testRuntimeName)
+		}
+		if testSIF == "" {
+			if _, err := exec.LookPath("docker"); err != nil {
+				return fmt.Errorf("docker CLI not found in PATH; please install Docker and rerun")
+			}
+		}
+		if !testKeepImage && !testBuild {
+			return fmt.Errorf("--keep-image=false only removes an image built with --build")
 		}
 
 		recipeSpec := args[0]
@@ -777,7 +1176,7 @@ var testCmd = cobra.Command{
 		if err != nil {
 			return err
 		}
-		build, err := recipe.LoadBuildFile(recipePath)
+		build, err := loadRecipe(recipePath)
 		if err != nil {
 			return fmt.Errorf("loading build file: %w", err)
 		}
@@ -785,28 +1184,134 @@ var testCmd = cobra.Command{
 		if err != nil {
 			return err
 		}
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+		values, err := build.ResolveOptions(options)
+		if err != nil {
+			return err
+		}
 		testerPath, cleanup, err := buildTesterBinary(goarch)
 		if err != nil {
 			return err
 		}
 		defer cleanup()
 
-		tag := build.Name + ":" + build.Version
+		tag := build.Name + ":" + build.VersionWithOptions(values)
+		if testBuild {
+			// The test flags --option and --local are the build's too.
+			buildUpToDate = false
+			if err := buildCmd.RunE(cmd, []string{recipeSpec}); err != nil {
+				return err
+			}
+			// An up-to-date image was there before this run and stays.
+			if !testKeepImage && buildUpToDate {
+				fmt.Printf("Keeping image %s, which this run did not build\n", tag)
+			}
+			if !testKeepImage && !buildUpToDate {
+				defer func() {
+					rm := exec.Command("docker", "image", "rm", tag)
+					if out, err := rm.CombinedOutput(); err != nil {
+						slog.Warn("removing the tested image", "tag", tag, "error", err, "output", strings.TrimSpace(string(out)))
+					} else {
+						fmt.Printf("Removed image %s\n", tag)
+					}
+				}()
+			}
+		}
+		if testSIF != "" {
+			return testImage(os.Stdout, cfg, recipePath, build, options, apptainerRuntime{tool: tool, sif: testSIF}, testerPath)
+		}
 		inspect := exec.Command("docker", "image", "inspect", tag)
 		if out, err := inspect.CombinedOutput(); err != nil {
 			return fmt.Errorf("docker image %s not found: %w\n%s", tag, err, string(out))
 		}
+		if tool == "" {
+			return testImage(os.Stdout, cfg, recipePath, build, options, dockerRuntime{tag: tag, platform: "linux/" + goarch}, testerPath)
+		}
 
-		platform := "linux/" + goarch
-		output, err := runTesterInContainer(tag, testerPath, platform, testCaptureOutput)
-		fmt.Print(string(output))
+		// Convert the image to a SIF the way builder export does, so the
+		// tests see the same %environment as released containers.
+		stage, err := prepareStage(cfg, recipeSpec, nil, options)
 		if err != nil {
-			return fmt.Errorf("tester reported failure: %w", err)
+			return err
 		}
-		return nil
+		dir, err := os.MkdirTemp("", "builder-test-sif-")
+		if err != nil {
+			return fmt.Errorf("creating temp dir: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		sif, err := exportSIF(stage, "", tool, dir)
+		if err != nil {
+			return err
+		}
+		return testImage(os.Stdout, cfg, recipePath, build, options, apptainerRuntime{tool: tool, sif: sif}, testerPath)
 	},
 }
 
+// testImage runs the deployment tester and then the recipe's script tests
+// in containers of rt, whose image was built from the recipe at recipePath
+// with options, writing their output to w.
+func testImage(w io.Writer, cfg builderConfig, recipePath string, build *recipe.BuildFile, options map[string]string, rt testRuntime, testerPath string) error {
+	_, plan, err := build.GenerateWithParams(recipe.GenerateParams{
+		IncludeDirs: cfg.includeDirsFor(recipePath),
+		Options:     options,
+	})
+	if err != nil {
+		return fmt.Errorf("generating build: %w", err)
+	}
+	dataArgs, err := stageTestData(cfg, recipePath, build.Name, plan)
+	if err != nil {
+		return err
+	}
+	values, err := build.ResolveOptions(options)
+	if err != nil {
+		return err
+	}
+	if build.GPUEnabled(values) {
+		dataArgs = append([]string{"--gpus", "all"}, dataArgs...)
+	}
+
+	var testerArgs []string
+	if len(build.GuiApps) > 0 {
+		apps := make([]smoketest.GUIApp, len(build.GuiApps))
+		for i, app := range build.GuiApps {
+			apps[i] = smoketest.GUIApp{Name: app.Name, Exec: app.Exec}
+		}
+		b, err := json.Marshal(apps)
+		if err != nil {
+			return err
+		}
+		testerArgs = append(testerArgs, "--gui-apps", string(b))
+	}
+	if tests := build.Runtime.Tests(); len(tests) > 0 {
+		b, err := json.Marshal(tests)
+		if err != nil {
+			return err
+		}
+		testerArgs = append(testerArgs, "--runtime-tests", string(b))
+	}
+
+	ctx, cancel := withTimeout(testTimeout)
+	output, err := runTesterInContainer(ctx, rt, testerPath, testCaptureOutput, dataArgs, testerArgs)
+	cancel()
+	w.Write(output)
+	// A failing tester still reports the deploy environment it saw.
+	reportDroppedDeployEnv(w, output)
+	if err != nil {
+		return timeoutError(ctx, "deployment tester", testTimeout, fmt.Errorf("tester reported failure: %w", err))
+	}
+	if err := failedTesterChecks(w, output); err != nil {
+		return err
+	}
+
+	if testSkipScripts {
+		return nil
+	}
+	return runRecipeScriptTests(w, rt, plan.Tests, dataArgs)
+}
+
 // stageCmd prepares the build context (Dockerfile + staged files) but does not build.
 // It emits a small JSON blob with details so wrapper scripts can invoke BuildKit.
 var stageCmd = cobra.Command{
@@ -826,11 +1331,15 @@ var stageCmd = cobra.Command{
 		if lvals, _ := cmd.Flags().GetStringArray("local"); len(lvals) > 0 {
 			locals = append(locals, lvals...)
 		}
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
 		cfg, err := loadBuilderConfig()
 		if err != nil {
 			return err
 		}
-		res, err := prepareStage(cfg, recipeName, locals)
+		res, err := prepareStage(cfg, recipeName, locals, options)
 		if err != nil {
 			return err
 		}
@@ -844,48 +1353,6 @@ var stageCmd = cobra.Command{
 	},
 }
 
-func testRecipes(recipes []string) error {
-	cfg, err := loadBuilderConfig()
-	if err != nil {
-		return fmt.Errorf("loading config: %w", err)
-	}
-
-	outputDir := filepath.Join("local", "docker")
-	if err := os.MkdirAll(outputDir, 0o755); err != nil {
-		return fmt.Errorf("creating output directory: %w", err)
-	}
-
-	var (
-		success int
-		failed  int
-	)
-
-	for _, r := range recipes {
-		fmt.Printf("Testing recipe: %s\n", r)
-		res, err := generateDockerfileForRecipe(cfg, r, outputDir)
-		if err != nil {
-			failed++
-			fmt.Printf("\033[31m  %v\033[0m\n", err)
-			continue
-		}
-		if len(res.Errors) > 0 {
-			failed++
-			for _, msg := range res.Errors {
-				fmt.Printf("\033[31m  %s\033[0m\n", msg)
-			}
-			continue
-		}
-		fmt.Printf("\033[32m  Successfully generated Dockerfile: %s\033[0m\n", res.OutputPath)
-		success++
-	}
-
-	fmt.Printf("Tested %d recipes: %d succeeded, %d failed\n", len(recipes), success, failed)
-	if failed > 0 {
-		return fmt.Errorf("%d recipes failed", failed)
-	}
-	return nil
-}
-
 func listRecipes(cfg builderConfig) ([]string, error) {
 	var recipes []string
 	for _, root := range cfg.RecipeRoots {
@@ -922,7 +1389,34 @@ var testAllCmd = cobra.Command{
 		if err != nil {
 			return err
 		}
-		return testRecipes(recipes)
+		jobs, _ := cmd.Flags().GetInt("jobs")
+		withTemplates, _ := cmd.Flags().GetBool("templates")
+		var templates []templateTestSpec
+		if withTemplates {
+			if _, err := exec.LookPath("docker"); err != nil {
+				return fmt.Errorf("--templates: docker CLI not found in PATH")
+			}
+			templates, err = loadTemplateTestSpecs(cfg.TemplateDir)
+			if err != nil {
+				return err
+			}
+		}
+		var run *testAllRun
+		summaryPath, _ := cmd.Flags().GetString("summary")
+		if doRun, _ := cmd.Flags().GetBool("run"); doRun {
+			if _, err := exec.LookPath("docker"); err != nil {
+				return fmt.Errorf("--run: docker CLI not found in PATH")
+			}
+			runJobs, _ := cmd.Flags().GetInt("run-jobs")
+			statePath, _ := cmd.Flags().GetString("state")
+			if run, err = newTestAllRun(runJobs, statePath); err != nil {
+				return err
+			}
+			if summaryPath == "" {
+				summaryPath = filepath.Join("local", "test-all", "summary.json")
+			}
+		}
+		return testAll(cfg, recipes, templates, jobs, run, summaryPath)
 	},
 }
 
@@ -937,6 +1431,14 @@ var graphCmd = cobra.Command{
 		if err != nil {
 			return err
 		}
+		var target *impactTarget
+		if spec, _ := cmd.Flags().GetString("impact"); spec != "" {
+			t, err := resolveImpactTarget(cfg, spec)
+			if err != nil {
+				return err
+			}
+			target = &t
+		}
 
 		var recipeDirs []string
 		if len(args) > 0 {
@@ -998,7 +1500,14 @@ var graphCmd = cobra.Command{
 			return fmt.Errorf("creating graph output directory: %w", err)
 		}
 
-		dot := buildGraphviz(results)
+		var impacted map[string]bool
+		if target != nil {
+			var impacts []layerImpact
+			impacts, impacted = analyseImpact(results, *target)
+			printImpact(os.Stdout, *target, impacts, len(results))
+		}
+
+		dot := buildGraphviz(results, impacted)
 		if err := os.WriteFile(outPath, []byte(dot), 0o644); err != nil {
 			return fmt.Errorf("writing Graphviz output: %w", err)
 		}
@@ -1007,7 +1516,9 @@ var graphCmd = cobra.Command{
 	},
 }
 
-func buildGraphviz(results []*recipeGenerationResult) string {
+// buildGraphviz renders the layers of results as a graph in which identical
+// layers share a node. The nodes in impacted, if any, are highlighted.
+func buildGraphviz(results []*recipeGenerationResult, impacted map[string]bool) string {
 	var b strings.Builder
 	b.WriteString("digraph BuilderLayers {\n")
 	b.WriteString("  rankdir=LR;\n")
@@ -1070,6 +1581,9 @@ func buildGraphviz(results []*recipeGenerationResult) string {
 	sort.Strings(nodeIDs)
 	for _, id := range nodeIDs {
 		attrs := append([]string{fmt.Sprintf("label=%s", quoteGraphviz(nodes[id]))}, nodeAttrs[id]...)
+		if impacted[id] {
+			attrs = append(attrs, "fillcolor=\"#fca5a5\"", "color=\"#b91c1c\"")
+		}
 		b.WriteString(fmt.Sprintf("  %s [%s];\n", id, strings.Join(attrs, ", ")))
 	}
 
@@ -1104,20 +1618,28 @@ func formatDirectiveLabel(d ir.Directive) string {
 	switch v := d.(type) {
 	case ir.FromImageDirective:
 		return "FROM " + string(v)
+	case ir.StageDirective:
+		return "FROM " + v.Image + " AS " + v.Name
+	case ir.CopyFromDirective:
+		return "COPY --from=" + v.Stage + " " + strings.Join(v.Src, " ") + " " + v.Dest
 	case ir.EnvironmentDirective:
 		if len(v) == 0 {
 			return "ENV"
 		}
-		keys := make([]string, 0, len(v))
-		for k := range v {
-			keys = append(keys, k)
-		}
-		sort.Strings(keys)
-		parts := make([]string, 0, len(keys))
-		for _, k := range keys {
+		parts := make([]string, 0, len(v))
+		for _, k := range v.Keys() {
 			parts = append(parts, fmt.Sprintf("%s=%q", k, v[k]))
 		}
 		return "ENV " + strings.Join(parts, " ")
+	case ir.LabelDirective:
+		if len(v) == 0 {
+			return "LABEL"
+		}
+		parts := make([]string, 0, len(v))
+		for _, k := range v.Keys() {
+			parts = append(parts, fmt.Sprintf("%s=%q", k, v[k]))
+		}
+		return "LABEL " + strings.Join(parts, " ")
 	case ir.RunDirective:
 		return "RUN " + string(v)
 	case ir.RunWithMountsDirective:
@@ -1146,11 +1668,37 @@ func formatDirectiveLabel(d ir.Directive) string {
 			quoted[i] = fmt.Sprintf("%q", arg)
 		}
 		return "ENTRYPOINT [" + strings.Join(quoted, ", ") + "]"
+	case ir.CmdDirective:
+		quoted := make([]string, len(v))
+		for i, arg := range v {
+			quoted[i] = fmt.Sprintf("%q", arg)
+		}
+		return "CMD [" + strings.Join(quoted, ", ") + "]"
+	case ir.HealthcheckDirective:
+		if len(v.Command) == 0 {
+			return "HEALTHCHECK NONE"
+		}
+		return "HEALTHCHECK CMD " + strings.Join(v.Command, " ")
+	case ir.ExposeDirective:
+		return "EXPOSE " + strings.Join(v, " ")
+	case ir.VolumeDirective:
+		return "VOLUME " + strings.Join(v, " ")
+	case ir.ShellDirective:
+		quoted := make([]string, len(v))
+		for i, arg := range v {
+			quoted[i] = fmt.Sprintf("%q", arg)
+		}
+		return "SHELL [" + strings.Join(quoted, ", ") + "]"
+	case ir.ArgDirective:
+		if v.HasDefault {
+			return fmt.Sprintf("ARG %s=%q", v.Name, v.Default)
+		}
+		return "ARG " + v.Name
 	case ir.LiteralFileDirective:
 		if v.Name != "" {
-			return fmt.Sprintf("RUN (literal file %s)", v.Name)
+			return fmt.Sprintf("COPY (literal file %s)", v.Name)
 		}
-		return "RUN (literal file)"
+		return "COPY (literal file)"
 	default:
 		return fmt.Sprintf("%T", d)
 	}
@@ -1178,9 +1726,94 @@ func shortenLabel(s string, max int) string {
 }
 
 var (
-	buildMethod string
+	buildMethod      string
+	buildCacheFrom   []string
+	buildInlineCache bool
+	buildPushRef     string
+	buildBuilderName string
+	buildForce       bool
+	buildLocked      bool
+	buildArgs        []string
 )
 
+// parseBuildArgs turns --build-arg KEY=VALUE flags into a map. A bare KEY
+// takes its value from the environment, as with docker build.
+func parseBuildArgs(vals []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, kv := range vals {
+		k, val, ok := strings.Cut(kv, "=")
+		if k == "" {
+			return nil, fmt.Errorf("invalid --build-arg %q (want KEY=VALUE)", kv)
+		}
+		if !ok {
+			val = os.Getenv(k)
+		}
+		out[k] = val
+	}
+	return out, nil
+}
+
+// llbLocalDirs maps the local inputs of an LLB build to directories: the
+// staged build context as "context", its cache as "cache", and each
+// --local KEY=DIR as KEY, matching the from= of RUN --mount.
+func llbLocalDirs(res *dockerStageResult, locals []string) (map[string]string, error) {
+	dirs := map[string]string{"context": res.BuildDir, "cache": res.CacheDir}
+	for _, kv := range locals {
+		key, dir, ok := strings.Cut(kv, "=")
+		if !ok || key == "" || dir == "" {
+			return nil, fmt.Errorf("invalid --local %q (want KEY=DIR)", kv)
+		}
+		if key == "context" || key == "cache" {
+			return nil, fmt.Errorf("--local %s: the name %q is reserved for the staged build context", kv, key)
+		}
+		dirs[key] = dir
+	}
+	return dirs, nil
+}
+
+// dockerBuildArgs assembles the docker build command line for a staged
+// build context:
+//
+//	docker build -t name:version -f Dockerfile [--build-context key=dir ...] buildDir
+func dockerBuildArgs(tag, dockerfilePath, cacheDir, buildDir string, locals []string, argValues map[string]string, inputDigest string) []string {
+	dockerArgs := []string{"build", "-t", tag, "-f", dockerfilePath}
+	if buildPlatform != "" {
+		dockerArgs = append(dockerArgs, "--platform", buildPlatform)
+	}
+	// Provide cache= build context automatically
+	dockerArgs = append(dockerArgs, "--build-context", "cache="+cacheDir)
+	// Append user-provided build contexts for named mounts
+	for _, kv := range locals {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			fmt.Printf("WARN: ignoring invalid --local %q (want KEY=DIR)\n", kv)
+			continue
+		}
+		dockerArgs = append(dockerArgs, "--build-context", kv)
+	}
+	if buildInlineCache {
+		dockerArgs = append(dockerArgs, "--build-arg", "BUILDKIT_INLINE_CACHE=1")
+	}
+	if inputDigest != "" {
+		dockerArgs = append(dockerArgs, "--label", inputDigestLabel+"="+inputDigest)
+	}
+	argKeys := make([]string, 0, len(argValues))
+	for k := range argValues {
+		argKeys = append(argKeys, k)
+	}
+	sort.Strings(argKeys)
+	for _, k := range argKeys {
+		dockerArgs = append(dockerArgs, "--build-arg", k+"="+argValues[k])
+	}
+	for _, ref := range buildCacheFrom {
+		dockerArgs = append(dockerArgs, "--cache-from", ref)
+	}
+	if buildPushRef != "" {
+		dockerArgs = append(dockerArgs, "-t", buildPushRef)
+	}
+	return append(dockerArgs, buildDir)
+}
+
 var buildCmd = cobra.Command{
 	Use:   "build [recipe]",
 	Short: "Generate Dockerfile and print buildctl command for the recipe",
@@ -1188,6 +1821,17 @@ var buildCmd = cobra.Command{
 		if verbose {
 			os.Setenv("BUILDER_VERBOSE", "1")
 		}
+		if bundle, _ := cmd.Flags().GetString("from-bundle"); bundle != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("--from-bundle builds the recipe in the bundle; do not name a recipe")
+			}
+			locals, _ := cmd.Flags().GetStringArray("local")
+			argValues, err := parseBuildArgs(buildArgs)
+			if err != nil {
+				return err
+			}
+			return buildFromBundle(bundle, locals, argValues)
+		}
 		if len(args) == 0 {
 			return fmt.Errorf("no recipe specified")
 		}
@@ -1197,18 +1841,46 @@ var buildCmd = cobra.Command{
 		if err != nil {
 			return err
 		}
+		logName := filepath.Base(filepath.Clean(recipeName))
+		if p, err := resolveRecipePath(cfg, recipeName); err == nil {
+			logName = filepath.Base(filepath.Clean(p))
+		}
+		if err := startBuildLog(logName); err != nil {
+			return err
+		}
 		// Parse optional local contexts supplied as --local KEY=DIR
 		var locals []string
 		if lvals, _ := cmd.Flags().GetStringArray("local"); len(lvals) > 0 {
 			locals = append(locals, lvals...)
 		}
+		argValues, err := parseBuildArgs(buildArgs)
+		if err != nil {
+			return err
+		}
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		buildCacheFrom = append(buildCacheFrom, buildFromCacheOf...)
 
 		switch buildMethod {
 		case "docker":
-			stage, err := prepareStage(cfg, recipeName, locals)
+			stage, err := prepareStage(cfg, recipeName, locals, options)
 			if err != nil {
 				return err
 			}
+			if buildLocked {
+				if err := checkLocked(stage); err != nil {
+					return err
+				}
+			}
+			if err := applyUntil(stage); err != nil {
+				return err
+			}
+			if skipUpToDate(stage, argValues, buildForce) {
+				return nil
+			}
 
 			res, err := prepareDockerStage(stage)
 			if err != nil {
@@ -1237,20 +1909,11 @@ var buildCmd = cobra.Command{
 				return fmt.Errorf("docker CLI not found in PATH; please install Docker and rerun")
 			}
 
-			// Assemble docker build command
-			// docker build -t name:version -f Dockerfile [--build-context key=dir ...] buildDir
-			dockerArgs := []string{"build", "-t", res.Name + ":" + res.Version, "-f", dockerfilePath}
-			// Provide cache= build context automatically
-			dockerArgs = append(dockerArgs, "--build-context", "cache="+cacheDir)
-			// Append user-provided build contexts for named mounts
+			dockerArgs := dockerBuildArgs(res.Tag, dockerfilePath, cacheDir, buildDir, locals, argValues, stage.inputDigest)
 			for _, kv := range locals {
-				parts := strings.SplitN(kv, "=", 2)
-				if len(parts) != 2 {
-					fmt.Printf("WARN: ignoring invalid --local %q (want KEY=DIR)\n", kv)
-					continue
+				if k, _, ok := strings.Cut(kv, "="); ok {
+					delete(want, k)
 				}
-				dockerArgs = append(dockerArgs, "--build-context", kv)
-				delete(want, parts[0])
 			}
 			// Any remaining keys in 'want' are optional locals; recipes typically guard with has_local.
 			// We only emit an informational message to aid debugging.
@@ -1261,40 +1924,169 @@ var buildCmd = cobra.Command{
 				}
 				fmt.Printf("Info: optional locals not supplied: %s (guard with has_local)\n", strings.Join(keys, ", "))
 			}
-			dockerArgs = append(dockerArgs, buildDir)
 
+			ctx, cancel := withTimeout(buildTimeout)
+			defer cancel()
 			// Ensure DOCKER_BUILDKIT is enabled
-			cmdRun := exec.Command("docker", dockerArgs...)
+			cmdRun := commandContext(ctx, "docker", dockerArgs...)
 			cmdRun.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
 			cmdRun.Stdout = os.Stdout
 			cmdRun.Stderr = os.Stderr
+			output := &tailBuffer{max: debugTailSize}
+			if buildDebugOnFailure {
+				cmdRun.Stderr = io.MultiWriter(os.Stderr, output)
+			}
 
 			fmt.Printf("Running: DOCKER_BUILDKIT=1 docker %s\n", strings.Join(dockerArgs, " "))
+			started := startBuild(res.Name, buildMethod)
 			if err := cmdRun.Run(); err != nil {
-				return fmt.Errorf("docker build failed: %w", err)
+				err = timeoutError(ctx, "build", buildTimeout, fmt.Errorf("docker build failed: %w", err))
+				recordBuild(stage, buildMethod, res.Tag, started, err, "", nil)
+				if buildDebugOnFailure {
+					if step, ok := failedDockerfileStep(stage.irDef, output.buf); !ok {
+						slog.Warn("could not find the failed step in the docker build output; no debug shell")
+					} else if derr := debugFailedStep(stage, res, locals, argValues, step, ir.SubmitOptions{}); derr != nil {
+						slog.Warn("debug shell", "error", derr)
+					}
+				}
+				return err
 			}
 
-			fmt.Printf("Built image %s:%s\n", res.Name, res.Version)
+			fmt.Printf("Built image %s\n", res.Tag)
+			if buildSquash {
+				if err := squashDockerImage(ctx, imageTags(res.Tag), buildDir); err != nil {
+					err = timeoutError(ctx, "build", buildTimeout, err)
+					recordBuild(stage, buildMethod, res.Tag, started, err, "", nil)
+					return err
+				}
+			}
+
+			if buildPushRef != "" {
+				push := commandContext(ctx, "docker", "push", buildPushRef)
+				push.Stdout = os.Stdout
+				push.Stderr = os.Stderr
+				fmt.Printf("Running: docker push %s\n", buildPushRef)
+				if err := push.Run(); err != nil {
+					err = timeoutError(ctx, "build", buildTimeout, fmt.Errorf("docker push failed: %w", err))
+					recordBuild(stage, buildMethod, res.Tag, started, err, dockerImageID(res.Tag), nil)
+					return err
+				}
+			}
+			recordBuild(stage, buildMethod, res.Tag, started, nil, dockerImageID(res.Tag), nil)
 			return nil
-		case "llb":
-			// Build with Docker and LLB
-			if _, err := exec.LookPath("docker"); err != nil {
-				return fmt.Errorf("docker not found in PATH; please install Docker and rerun")
+		case "llb", "buildctl":
+			// Build with LLB, through Docker's buildx or on a standalone
+			// buildkitd.
+			var addr string
+			if buildMethod == "llb" {
+				if _, err := exec.LookPath("docker"); err != nil {
+					return fmt.Errorf("docker not found in PATH; please install Docker and rerun")
+				}
+			} else if addr, err = buildkitAddress(cfg); err != nil {
+				return err
 			}
 
-			stage, err := prepareStage(cfg, recipeName, locals)
+			stage, err := prepareStage(cfg, recipeName, locals, options)
 			if err != nil {
 				return err
 			}
+			if buildLocked {
+				if err := checkLocked(stage); err != nil {
+					return err
+				}
+			}
+			if err := applyUntil(stage); err != nil {
+				return err
+			}
+			if skipUpToDate(stage, argValues, buildForce) {
+				return nil
+			}
+			if stage.inputDigest != "" {
+				stage.irDef.Directives = append(stage.irDef.Directives, ir.DirectiveWithMetadata{
+					Directive: ir.LabelDirective{inputDigestLabel: stage.inputDigest},
+				})
+			}
 
-			llbGen, err := ir.GenerateLLBDefinition(stage.irDef)
+			llbGen, err := ir.GenerateLLBDefinitionWithOptions(stage.irDef, ir.LLBOptions{BuildArgs: argValues, Platform: buildPlatform, Squash: buildSquash})
 			if err != nil {
 				return fmt.Errorf("generating LLB definition: %w", err)
 			}
 
-			slog.Info("submitting build to Docker via Buildx")
+			collector, err := ir.NewReportCollector(stage.irDef, llbGen)
+			if err != nil {
+				return fmt.Errorf("indexing LLB vertices: %w", err)
+			}
+
+			opts := ir.SubmitOptions{
+				BuilderName: buildBuilderName,
+				Addr:        addr,
+				TLS:         buildkitTLS(cfg.Buildkit),
+				CacheFrom:   buildCacheFrom,
+				InlineCache: buildInlineCache,
+				Platform:    buildPlatform,
+				Squash:      buildSquash,
+			}
+			// The staged build context and files are the "context" and
+			// "cache" local inputs of the LLB, next to the --local contexts.
+			res, err := prepareDockerStage(stage)
+			if err != nil {
+				return err
+			}
+			if opts.LocalDirs, err = llbLocalDirs(res, locals); err != nil {
+				return err
+			}
+			if buildPushRef != "" {
+				attrs := map[string]string{"name": buildPushRef, "push": "true"}
+				// Labels also go into the image config; annotations make them
+				// visible to registries without fetching the config blob.
+				for k, v := range stage.irDef.Labels() {
+					attrs["annotation."+k] = v
+				}
+				opts.Exports = append(opts.Exports, bkclient.ExportEntry{
+					Type:  bkclient.ExporterImage,
+					Attrs: attrs,
+				})
+			}
+			// Like docker build, llb builds load the image into Docker as
+			// name:version; buildctl builds only with an explicit --load.
+			load := buildMethod == "llb"
+			if cmd.Flags().Changed("load") {
+				load = buildLoad
+			}
+			var tag string
+			if load {
+				if _, err := exec.LookPath("docker"); err != nil {
+					return fmt.Errorf("--load needs the docker CLI in PATH")
+				}
+				tag = res.Tag
+				opts.Exports = append(opts.Exports, dockerLoadExport(tag, buildMethod == "llb", buildBuilderName))
+			}
+			if buildPushRef != "" {
+				tag = buildPushRef
+			}
+			if buildOCILayout != "" {
+				entry, err := ociLayoutExport(buildOCILayout, res.Tag)
+				if err != nil {
+					return fmt.Errorf("preparing OCI layout %s: %w", buildOCILayout, err)
+				}
+				opts.Exports = append(opts.Exports, entry)
+			}
+			if len(opts.Exports) > 0 {
+				opts.ImageConfig = stage.irDef
+			} else if buildMethod == "buildctl" {
+				slog.Warn("the image is built but not exported; pass --load, --push or --oci-layout to keep it")
+			}
+
+			if buildMethod == "buildctl" {
+				slog.Info("submitting build to buildkitd", "addr", addr)
+			} else {
+				slog.Info("submitting build to Docker via Buildx")
+			}
 
 			events := make(chan ir.Event)
+			started := startBuild(stage.build.Name, buildMethod)
+			// imageDigest is set from the solve response by the event loop.
+			var imageDigest string
 
 			// Pretty console streaming of BuildKit events.
 			// - Prints step start/done/cached/error using vertex names (your original names).
@@ -1330,6 +2122,7 @@ var buildCmd = cobra.Command{
 						if s == nil {
 							continue
 						}
+						collector.Observe(s)
 
 						// Merge provided vertex names into our local map.
 						for id, n := range ev.VertexNames {
@@ -1414,6 +2207,9 @@ var buildCmd = cobra.Command{
 						}
 
 					case ir.EventTypeResult:
+						if ev.Result != nil {
+							imageDigest = ev.Result.ExporterResponse["containerimage.digest"]
+						}
 						total := time.Since(buildStart)
 						if hadError {
 							slog.Error("build finished with errors", "duration", total)
@@ -1424,11 +2220,40 @@ var buildCmd = cobra.Command{
 				}
 			}()
 
-			err = ir.SubmitToDockerViaBuildx(context.Background(), llbGen, "", "", events)
+			ctx, cancel := withTimeout(buildTimeout)
+			defer cancel()
+			err = ir.Submit(ctx, llbGen, opts, events)
+			err = timeoutError(ctx, "build", buildTimeout, err)
 			// We own the channel; close it now that Submit has returned.
 			close(events)
 			wg.Wait()
+
+			report := collector.Report(err)
+			report.Recipe = stage.build.Name
+			report.Version = stage.version
+			for i := range report.Directives {
+				report.Directives[i].Label = formatDirectiveLabel(stage.irDef.Directives[i].Directive)
+			}
+			reportPath, werr := writeBuildReport(report)
+			if werr != nil {
+				slog.Warn("writing build report", "error", werr)
+			} else {
+				slog.Info("build report written", "path", reportPath,
+					"cached", report.Cached, "built", report.Built, "failed", report.Failed)
+			}
+			recordBuild(stage, buildMethod, tag, started, err, imageDigest, &report)
+
+			if err != nil && buildDebugOnFailure {
+				if step, ok := failedReportStep(report); !ok {
+					slog.Warn("no failed step in the build report; no debug shell")
+				} else if derr := debugFailedStep(stage, res, locals, argValues, step, opts); derr != nil {
+					slog.Warn("debug shell", "error", derr)
+				}
+			}
 			if err != nil {
+				if buildMethod == "buildctl" {
+					return fmt.Errorf("submitting to buildkitd at %s: %w", addr, err)
+				}
 				return fmt.Errorf("submitting to Docker via Buildx: %w", err)
 			}
 
@@ -1439,6 +2264,24 @@ var buildCmd = cobra.Command{
 	},
 }
 
+// writeBuildReport writes the per-directive cache report for an LLB build to
+// local/build/<recipe>/report.json and returns the path.
+func writeBuildReport(report ir.BuildReport) (string, error) {
+	dir := filepath.Join("local", "build", report.Recipe)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "report.json")
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
 // ---- Web server (LLB-only) -------------------------------------------------
 
 type apiServer struct {
@@ -1655,7 +2498,7 @@ func (s *apiServer) handleBuildsCollection(w http.ResponseWriter, r *http.Reques
 	for k, v := range req.Locals {
 		localsPairs = append(localsPairs, k+"="+v)
 	}
-	stage, err := prepareStage(s.cfg, recipeDir, localsPairs)
+	stage, err := prepareStage(s.cfg, recipeDir, localsPairs, nil)
 	if err != nil {
 		http.Error(w, "failed to prepare stage: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -1667,6 +2510,11 @@ func (s *apiServer) handleBuildsCollection(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "failed to stage files: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	localDirs, err := llbLocalDirs(dstage, localsPairs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Generate LLB definition
 	llbDef, err := ir.GenerateLLBDefinition(stage.irDef)
@@ -1701,8 +2549,12 @@ func (s *apiServer) handleBuildsCollection(w http.ResponseWriter, r *http.Reques
 			delete(s.builds, buildID)
 			s.mu.Unlock()
 		}()
-		// Submit via buildx using the staged buildDir as the "context" local
-		_ = ir.SubmitToDockerViaBuildx(ctx, llbDef, req.BuilderName, dstage.BuildDir, evCh)
+		// Submit via buildx with the staged build context, its cache and
+		// the requested locals as local inputs.
+		_ = ir.SubmitToDockerViaBuildxWithOptions(ctx, llbDef, ir.SubmitOptions{
+			BuilderName: req.BuilderName,
+			LocalDirs:   localDirs,
+		}, evCh)
 	}()
 
 	writeJSON(w, http.StatusAccepted, map[string]any{"buildId": buildID})
@@ -1843,25 +2695,74 @@ var webCmd = cobra.Command{
 func init() {
 	rootCmd.PersistentFlags().StringVar(&rootBuilderConfig, "config", "builder.config.yaml", "Path to builder configuration file")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
+	rootCmd.PersistentFlags().StringVar(&overridePath, "override", "", "Override file merged onto the recipe (default: build.override.yaml next to it)")
+	rootCmd.PersistentFlags().BoolVar(&loadPermissive, "permissive", false, "Load recipes with unknown fields, warning about each instead of failing")
+	rootCmd.PersistentFlags().BoolVar(&loadStrictFields, "strict-fields", false, "Also reject forward-compatible fields such as apptainer_args, which are otherwise ignored (for CI)")
+	rootCmd.MarkFlagsMutuallyExclusive("permissive", "strict-fields")
 
+	generateDockerfileCmd.Flags().StringArray("option", []string{}, "Set a recipe option as KEY=VALUE (repeatable)")
+	generateDockerfileCmd.Flags().String("arch", "", "Target architecture (x86_64 or aarch64); defaults to the host's if the recipe supports it")
 	rootCmd.AddCommand(&generateDockerfileCmd)
 
 	// test-all flags
+	testAllCmd.Flags().Int("jobs", 1, "Number of recipes and template tests to process in parallel")
+	testAllCmd.Flags().Bool("templates", false, "Also build every template test in test_all.yaml and run its tests")
+	testAllCmd.Flags().Bool("run", false, "Also build every recipe and run the deployment tester and script tests in it")
+	testAllCmd.Flags().Int("run-jobs", 1, "Number of recipes to build and test at once with --run")
+	testAllCmd.Flags().String("state", filepath.Join("local", "test-all", "state.json"), "File recording the recipes whose tests passed, which --run skips while their inputs are unchanged")
+	testAllCmd.Flags().String("summary", "", "Write a JSON summary of the results to this file (default with --run: local/test-all/summary.json)")
+	testAllCmd.Flags().DurationVar(&buildTimeout, "timeout", 0, "With --run, cancel the build of a recipe after this long, e.g. 2h (default: no limit)")
+	testAllCmd.Flags().DurationVar(&testTimeout, "test-timeout", 0, "With --run, stop the deployment tester or a script test and remove its container after this long (default: no limit)")
+	testAllCmd.Flags().StringVar(&buildLogDir, "log-dir", filepath.Join("local", "local_logs"), "With --run, also write each recipe's build output, timestamped, to build_<recipe>.log in this directory (empty disables)")
+	testAllCmd.Flags().Int64Var(&buildLogMaxSize, "log-max-size", 20, "Rotate a build log larger than this many MiB before a build")
+	testAllCmd.Flags().IntVar(&buildLogKeep, "log-keep", 3, "Rotated build logs to keep")
 	rootCmd.AddCommand(&testAllCmd)
 
 	graphCmd.Flags().StringVar(&graphOutputPath, "output", filepath.Join("local", "graphs", "layers.dot"), "Path to Graphviz DOT output")
+	graphCmd.Flags().String("impact", "", "Report and highlight the cached layers a change to this recipe, template or include file invalidates")
 	rootCmd.AddCommand(&graphCmd)
 
 	// test command
 	testCmd.Flags().BoolVar(&testCaptureOutput, "capture-output", false, "Capture output from commands")
+	testCmd.Flags().StringArray("option", []string{}, "Select the image built with recipe option KEY=VALUE (repeatable)")
+	testCmd.Flags().DurationVar(&testTimeout, "timeout", 0, "Stop the deployment tester or a script test and remove its container after this long, e.g. 10m (default: no limit)")
+	testCmd.Flags().BoolVar(&testSkipScripts, "skip-scripts", false, "Only run the deployment tester, not the recipe's script tests")
+	testCmd.Flags().StringVar(&testRuntimeName, "runtime", "docker", "Container runtime to test under: docker, or apptainer or singularity to convert the image to a SIF and run it as Neurodesk does")
+	testCmd.Flags().StringVar(&testSIF, "sif", "", "With --runtime apptainer or singularity, test this SIF, e.g. a pulled release, instead of converting the local image")
+	testCmd.Flags().BoolVar(&testBuild, "build", false, "Build the image first, as builder build does")
+	testCmd.Flags().BoolVar(&testKeepImage, "keep-image", true, "Keep the image built with --build after testing")
+	testCmd.Flags().StringArray("local", []string{}, "With --build, supply a named local context as KEY=DIR")
 	rootCmd.AddCommand(&testCmd)
 
 	// Build command flags: --local KEY=DIR can be repeated to supply named contexts
+	buildCmd.Flags().StringArray("option", []string{}, "Set a recipe option as KEY=VALUE (repeatable)")
 	buildCmd.Flags().StringArray("local", []string{}, "Supply a named local context as KEY=DIR for RUN --mount from=KEY")
-	buildCmd.Flags().StringVar(&buildMethod, "method", "docker", "Build method to use (docker,llb)")
+	buildCmd.Flags().StringVar(&buildMethod, "method", "docker", "Build method to use (docker,llb,buildctl)")
+	buildCmd.Flags().StringArrayVar(&buildCacheFrom, "cache-from", nil, "Registry image ref to import build cache from (repeatable)")
+	buildCmd.Flags().BoolVar(&buildInlineCache, "inline-cache", true, "Embed inline cache metadata in built images")
+	buildCmd.Flags().StringVar(&buildPushRef, "push", "", "Tag the image with this registry ref and push it")
+	buildCmd.Flags().StringArrayVar(&buildArgs, "build-arg", nil, "Set a value for an ARG declared by the recipe as KEY=VALUE (repeatable)")
+	buildCmd.Flags().BoolVar(&buildForce, "force", false, "Build even if the inputs match the last successful build or the pushed image")
+	buildCmd.Flags().BoolVar(&buildLocked, "locked", false, "Refuse to build if the base images, downloads, templates or builder differ from the recipe's build.lock")
+	buildCmd.Flags().StringVar(&buildBuilderName, "builder", "", "Buildx builder to submit LLB builds to (default: current builder)")
+	buildCmd.Flags().StringVar(&buildkitAddr, "buildkit-addr", "", "buildkitd address for --method buildctl (default: buildkit.addr from the config, then $BUILDKIT_HOST)")
+	buildCmd.Flags().BoolVar(&buildDebugOnFailure, "debug-on-failure", false, "When a step fails, build the state before it and open a shell in it")
+	buildCmd.Flags().StringVar(&buildLogDir, "log-dir", filepath.Join("local", "local_logs"), "Also write the build output, timestamped, to build_<recipe>.log in this directory (empty disables)")
+	buildCmd.Flags().Int64Var(&buildLogMaxSize, "log-max-size", 20, "Rotate a build log larger than this many MiB before a build")
+	buildCmd.Flags().IntVar(&buildLogKeep, "log-keep", 3, "Rotated build logs to keep")
+	buildCmd.Flags().DurationVar(&buildTimeout, "timeout", 0, "Cancel the build and push after this long, e.g. 2h (default: no limit)")
+	buildCmd.Flags().StringVar(&buildUntil, "until", "", "Build only up to this step: a step number as shown by `builder explain`, a directive source such as directives[3], or a recipe file:line; the image is tagged <name>:<version>-partial")
+	buildCmd.Flags().StringArrayVar(&buildFromCacheOf, "from-cache-of", nil, "Resume from the layers of an earlier build, e.g. a <name>:<version>-partial image (repeatable)")
+	buildCmd.Flags().StringVar(&buildPlatform, "platform", "", "Target platform, e.g. linux/arm64 (default: the builder's platform)")
+	buildCmd.Flags().BoolVar(&buildLoad, "load", true, "Load the image into Docker as name:version (--method llb; buildctl only when given explicitly, and needs the docker CLI)")
+	buildCmd.Flags().BoolVar(&buildSquash, "squash", false, "Squash the image into a single layer, for distribution as SIF or on CVMFS")
+	buildCmd.Flags().BoolVar(&buildSquash, "flatten", false, "Same as --squash")
+	buildCmd.Flags().StringVar(&buildOCILayout, "oci-layout", "", "With --method llb or buildctl, also export the image as an OCI layout: a directory, or a tarball when the path ends in .tar")
+	buildCmd.Flags().String("from-bundle", "", "Build from an archive written by `builder bundle` instead of a recipe")
 	rootCmd.AddCommand(&buildCmd)
 
 	// Stage command (no build), supports --local as well
+	stageCmd.Flags().StringArray("option", []string{}, "Set a recipe option as KEY=VALUE (repeatable)")
 	stageCmd.Flags().StringArray("local", []string{}, "Supply a named local context as KEY=DIR for RUN --mount from=KEY")
 	rootCmd.AddCommand(&stageCmd)
 
@@ -1871,38 +2772,12 @@ func init() {
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	pushMetrics()
+	if err != nil {
 		slog.Error("fatal", "error", err)
+		stopBuildLog()
 		os.Exit(1)
 	}
-}
-
-// splitQuoted splits a string of quoted args into fields (simple parser for our COPY lines).
-func splitQuoted(s string) []string {
-	var out []string
-	var cur strings.Builder
-	inQ := false
-	esc := false
-	for _, r := range s {
-		switch {
-		case esc:
-			cur.WriteRune(r)
-			esc = false
-		case r == '\\' && inQ:
-			esc = true
-		case r == '"':
-			inQ = !inQ
-		case r == ' ' && !inQ:
-			if cur.Len() > 0 {
-				out = append(out, cur.String())
-				cur.Reset()
-			}
-		default:
-			cur.WriteRune(r)
-		}
-	}
-	if cur.Len() > 0 {
-		out = append(out, cur.String())
-	}
-	return out
+	stopBuildLog()
 }
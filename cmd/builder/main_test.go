@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReportDroppedDeployEnvAfterTesterFailure(t *testing.T) {
+	// A failing tester prints its results, then its error log on stderr,
+	// which the runtime writes to the same output.
+	output := `{"DeployBins":["bet"],"Environment":[` +
+		`{"Invocation":"docker run","Values":{"DEPLOY_PATH":"/opt/bin"}},` +
+		`{"Invocation":"bash -l","Dropped":["DEPLOY_PATH"]},` +
+		`{"Invocation":"entrypoint","Changed":["DEPLOY_BINS"],"Error":"running entrypoint: exit status 1"},` +
+		`{"Invocation":"bash -li","Skipped":true,"Error":"bash not available"}]}` + "\n" +
+		`time=2026-10-16T18:00:00.000Z level=ERROR msg=fatal error="reading deploy path \"/opt/bin\": no such file or directory"` + "\n"
+
+	var w bytes.Buffer
+	reportDroppedDeployEnv(&w, []byte(output))
+	want := "warning: bash -l drops DEPLOY_PATH\n" +
+		"warning: entrypoint changes DEPLOY_BINS\n" +
+		"warning: entrypoint: running entrypoint: exit status 1\n"
+	if w.String() != want {
+		t.Fatalf("reportDroppedDeployEnv wrote\n%s\nwant\n%s", w.String(), want)
+	}
+}
+
+func TestFailedTesterChecksWithTrailingLog(t *testing.T) {
+	output := `{"Commands":[{"Name":"bet runs","Passed":false,"Error":"exit status 1"}]}` + "\n" +
+		"time=2026-10-16T18:00:00.000Z level=ERROR msg=fatal error=boom\n"
+	var w bytes.Buffer
+	err := failedTesterChecks(&w, []byte(output))
+	if err == nil || !strings.Contains(err.Error(), "bet runs") {
+		t.Fatalf("failedTesterChecks = %v, want the failed command", err)
+	}
+	if !strings.Contains(w.String(), "FAIL bet runs: exit status 1") {
+		t.Fatalf("failedTesterChecks wrote %q", w.String())
+	}
+}
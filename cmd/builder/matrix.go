@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+// matrixManifest is the JSON written by `builder matrix`.
+type matrixManifest struct {
+	Name     string        `json:"name"`
+	Variants []matrixEntry `json:"variants"`
+}
+
+type matrixEntry struct {
+	recipe.MatrixVariant
+	Tag        string `json:"tag"`
+	Dockerfile string `json:"dockerfile"`
+	// Status is "generated", "built" or "failed".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+var matrixCmd = cobra.Command{
+	Use:   "matrix [recipe]",
+	Short: "Generate (and optionally build) every option/version variant of a recipe",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("no recipe specified")
+		}
+		versions, _ := cmd.Flags().GetStringArray("version")
+		doBuild, _ := cmd.Flags().GetBool("build")
+		outPath, _ := cmd.Flags().GetString("output")
+		pinned, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		recipePath, err := resolveRecipePath(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		build, err := loadRecipe(recipePath)
+		if err != nil {
+			return fmt.Errorf("loading build file: %w", err)
+		}
+		variants, err := build.Matrix(versions, pinned)
+		if err != nil {
+			return err
+		}
+		if doBuild {
+			if _, err := exec.LookPath("docker"); err != nil {
+				return fmt.Errorf("docker CLI not found in PATH; please install Docker and rerun")
+			}
+		}
+
+		matrixDir := filepath.Join("local", "matrix", build.Name)
+		manifest := matrixManifest{Name: build.Name}
+		failed := 0
+		for _, variant := range variants {
+			entry, err := runMatrixVariant(cfg, recipePath, build, variant, filepath.Join(matrixDir, variant.ImageVersion), doBuild)
+			if err != nil {
+				entry.Status = "failed"
+				entry.Error = err.Error()
+				failed++
+				fmt.Fprintf(os.Stderr, "%s: %v\n", entry.Tag, err)
+			} else {
+				fmt.Printf("%s: %s\n", entry.Tag, entry.Status)
+			}
+			manifest.Variants = append(manifest.Variants, entry)
+		}
+
+		if outPath == "" {
+			outPath = filepath.Join(matrixDir, "matrix.json")
+		}
+		if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+			return fmt.Errorf("creating manifest directory: %w", err)
+		}
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding matrix manifest: %w", err)
+		}
+		if err := os.WriteFile(outPath, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("writing matrix manifest: %w", err)
+		}
+		fmt.Printf("Matrix manifest (%d variants) written to %s\n", len(variants), outPath)
+
+		if failed > 0 {
+			return fmt.Errorf("%d of %d matrix variants failed", failed, len(variants))
+		}
+		return nil
+	},
+}
+
+// runMatrixVariant generates one variant's Dockerfile into dir and, when
+// doBuild is set, stages its build context and builds it with docker.
+func runMatrixVariant(cfg builderConfig, recipePath string, build *recipe.BuildFile, variant recipe.MatrixVariant, dir string, doBuild bool) (matrixEntry, error) {
+	entry := matrixEntry{
+		MatrixVariant: variant,
+		Tag:           build.Name + ":" + variant.ImageVersion,
+		Dockerfile:    filepath.Join(dir, "Dockerfile"),
+	}
+
+	vb := *build
+	vb.Version = variant.Version
+	irDef, plan, err := vb.GenerateWithParams(recipe.GenerateParams{
+		IncludeDirs: cfg.includeDirsFor(recipePath),
+		Options:     variant.Options,
+	})
+	if err != nil {
+		return entry, fmt.Errorf("generating build IR: %w", err)
+	}
+	dockerfile, err := ir.GenerateDockerfile(irDef)
+	if err != nil {
+		return entry, fmt.Errorf("generating dockerfile: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return entry, fmt.Errorf("creating variant directory: %w", err)
+	}
+	if err := os.WriteFile(entry.Dockerfile, []byte(dockerfile), 0o644); err != nil {
+		return entry, fmt.Errorf("writing Dockerfile: %w", err)
+	}
+	entry.Status = "generated"
+	if !doBuild {
+		return entry, nil
+	}
+
+	if err := stageIntoBuildContext(cfg, recipePath, irDef, dockerfile, dir, plan); err != nil {
+		return entry, err
+	}
+	dockerArgs := []string{"build", "-t", entry.Tag, "-f", entry.Dockerfile,
+		"--build-context", "cache=" + filepath.Join(dir, "cache"), dir}
+	run := exec.Command("docker", dockerArgs...)
+	run.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	run.Stdout = os.Stdout
+	run.Stderr = os.Stderr
+	fmt.Printf("Running: DOCKER_BUILDKIT=1 docker %s\n", strings.Join(dockerArgs, " "))
+	if err := run.Run(); err != nil {
+		return entry, fmt.Errorf("docker build failed: %w", err)
+	}
+	entry.Status = "built"
+	return entry, nil
+}
+
+func init() {
+	matrixCmd.Flags().StringArray("version", nil, "Recipe version to include in the matrix (repeatable; default: the recipe's version)")
+	matrixCmd.Flags().StringArray("option", []string{}, "Pin a recipe option to KEY=VALUE instead of expanding it (repeatable)")
+	matrixCmd.Flags().Bool("build", false, "Build every variant with docker after generating it")
+	matrixCmd.Flags().String("output", "", "Path of the JSON manifest (default: local/matrix/<name>/matrix.json)")
+	rootCmd.AddCommand(&matrixCmd)
+}
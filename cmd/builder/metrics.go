@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/manifest"
+	"github.com/neurodesk/builder/pkg/metrics"
+	"github.com/neurodesk/builder/pkg/netcache"
+	"github.com/spf13/cobra"
+)
+
+var (
+	metricsListen string
+	metricsPush   string
+	metricsJob    string
+)
+
+var (
+	buildMetrics = metrics.NewRegistry()
+
+	buildsStarted = buildMetrics.Counter("builder_builds_started_total",
+		"Image builds started.", "recipe", "method")
+	buildsSucceeded = buildMetrics.Counter("builder_builds_succeeded_total",
+		"Image builds that succeeded.", "recipe", "method")
+	buildsFailed = buildMetrics.Counter("builder_builds_failed_total",
+		"Image builds that failed.", "recipe", "method")
+	buildDuration = buildMetrics.Histogram("builder_build_duration_seconds",
+		"Duration of image builds.", metrics.DurationBuckets, "recipe", "method")
+	buildSteps = buildMetrics.Counter("builder_build_steps_total",
+		"Directives of LLB builds by outcome; cached over cached plus built is the cache hit rate.", "recipe", "status")
+	buildStepDuration = buildMetrics.Histogram("builder_build_step_duration_seconds",
+		"Duration of the directives of LLB builds that ran.", metrics.DurationBuckets, "kind", "status")
+)
+
+func init() {
+	buildMetrics.CounterFunc("builder_netcache_downloaded_bytes_total",
+		"Bytes downloaded into the HTTP cache.", func() float64 { return float64(netcache.ReadStats().BytesDownloaded) })
+	buildMetrics.CounterFunc("builder_netcache_hits_total",
+		"HTTP cache lookups served from the cache.", func() float64 { return float64(netcache.ReadStats().Hits) })
+	buildMetrics.CounterFunc("builder_netcache_misses_total",
+		"HTTP cache lookups that downloaded the file.", func() float64 { return float64(netcache.ReadStats().Misses) })
+
+	rootCmd.PersistentFlags().StringVar(&metricsListen, "metrics-listen", "", "Serve Prometheus metrics on this address (e.g. :9100) at /metrics while the command runs")
+	rootCmd.PersistentFlags().StringVar(&metricsPush, "metrics-push", "", "Push metrics to this Prometheus Pushgateway URL when the command finishes")
+	rootCmd.PersistentFlags().StringVar(&metricsJob, "metrics-job", "builder", "Job name for --metrics-push")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		return startMetricsListener()
+	}
+}
+
+// startBuild counts a build as started and returns its start time.
+func startBuild(recipeName, method string) time.Time {
+	buildsStarted.Inc(recipeName, method)
+	return time.Now()
+}
+
+// observeBuild updates the build metrics with a finished build.
+func observeBuild(m manifest.Manifest) {
+	if m.Status == manifest.StatusSucceeded {
+		buildsSucceeded.Inc(m.Recipe, m.Method)
+	} else {
+		buildsFailed.Inc(m.Recipe, m.Method)
+	}
+	buildDuration.Observe(m.Duration.Seconds(), m.Recipe, m.Method)
+	if m.Report == nil {
+		return
+	}
+	for _, d := range m.Report.Directives {
+		switch d.Status {
+		case ir.DirectiveCached, ir.DirectiveBuilt, ir.DirectiveFailed:
+			buildSteps.Inc(m.Recipe, string(d.Status))
+		}
+		if d.Status == ir.DirectiveBuilt || d.Status == ir.DirectiveFailed {
+			buildStepDuration.Observe(d.Duration.Seconds(), d.Kind, string(d.Status))
+		}
+	}
+}
+
+func startMetricsListener() error {
+	if metricsListen == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", metricsListen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", buildMetrics.Handler())
+	go func() {
+		if err := http.Serve(ln, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("metrics listener stopped", "error", err)
+		}
+	}()
+	slog.Info("serving metrics", "addr", ln.Addr().String())
+	return nil
+}
+
+// pushMetrics sends the metrics to the Pushgateway, if one is configured.
+func pushMetrics() {
+	if metricsPush == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := buildMetrics.Push(ctx, metricsPush, metricsJob); err != nil {
+		slog.Warn("pushing metrics", "error", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/neurodesk/builder/pkg/textdiff"
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = cobra.Command{
+	Use:   "migrate <recipe>",
+	Short: "Rewrite a recipe to the current schema_version and print the diff; --write saves it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		write, _ := cmd.Flags().GetBool("write")
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		dir, err := resolveRecipePath(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, "build.yaml")
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, notes, err := recipe.MigrateBuildFile(src)
+		if err != nil {
+			return fmt.Errorf("migrating %s: %w", path, err)
+		}
+		if len(notes) == 0 {
+			fmt.Fprintf(os.Stderr, "%s is already at schema_version %d\n", path, recipe.CurrentSchemaVersion)
+			return nil
+		}
+		fmt.Print(textdiff.Unified(path, path+" (migrated)", string(src), string(out), 3))
+		for _, note := range notes {
+			fmt.Fprintf(os.Stderr, "note: %s\n", note)
+		}
+		if !write {
+			return nil
+		}
+		if err := os.WriteFile(path, out, 0o644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		if _, err := loadRecipe(dir); err != nil {
+			return fmt.Errorf("the migrated recipe does not load; review %s: %w", path, err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	migrateCmd.Flags().Bool("write", false, "Write the migrated recipe back to build.yaml")
+	rootCmd.AddCommand(&migrateCmd)
+}
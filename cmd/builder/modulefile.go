@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/modulefile"
+	"github.com/spf13/cobra"
+)
+
+var modulefileCmd = cobra.Command{
+	Use:   "modulefile [recipe]",
+	Short: "Print an Lmod (Lua) or Environment Modules (TCL) modulefile for the recipe",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "lua" && format != "tcl" {
+			return fmt.Errorf("unsupported modulefile format %q (supported: lua, tcl)", format)
+		}
+		containerRoot, _ := cmd.Flags().GetString("container-root")
+		outPath, _ := cmd.Flags().GetString("output")
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		stage, err := prepareStage(cfg, args[0], nil, options)
+		if err != nil {
+			return err
+		}
+		module := moduleForStage(stage, resolveContainerRoot(cfg, containerRoot))
+		text := module.Lua()
+		if format == "tcl" {
+			text = module.TCL()
+		}
+
+		if outPath == "" {
+			fmt.Print(text)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+		if err := os.WriteFile(outPath, []byte(text), 0o644); err != nil {
+			return fmt.Errorf("writing modulefile: %w", err)
+		}
+		return nil
+	},
+}
+
+// resolveContainerRoot returns flag, else container_root from the config,
+// else defaultContainerRoot.
+func resolveContainerRoot(cfg builderConfig, flag string) string {
+	if flag != "" {
+		return flag
+	}
+	if cfg.ContainerRoot != "" {
+		return cfg.ContainerRoot
+	}
+	return defaultContainerRoot
+}
+
+// moduleForStage describes the generated recipe as a module whose wrapper
+// scripts live in <containerRoot>/<name>_<version>.
+func moduleForStage(stage *genericStageResult, containerRoot string) modulefile.Module {
+	build := stage.build
+	env := finalEnvironment(stage.irDef)
+	module := modulefile.Module{
+		Name:        build.Name,
+		Version:     stage.version,
+		Description: stage.plan.Readme,
+		Dir:         path.Join(containerRoot, build.Name+"_"+stage.version),
+		Bins:        splitDeployList(env["DEPLOY_BINS"]),
+		Path:        splitDeployList(env["DEPLOY_PATH"]),
+	}
+	for _, c := range build.Categories {
+		module.Categories = append(module.Categories, string(c))
+	}
+	for _, app := range build.GuiApps {
+		module.Apps = append(module.Apps, modulefile.App{Name: app.Name, Exec: app.Exec})
+	}
+	return module
+}
+
+// splitDeployList splits a DEPLOY_BINS or DEPLOY_PATH value.
+func splitDeployList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ":")
+}
+
+func init() {
+	modulefileCmd.Flags().String("format", "lua", "Modulefile format (lua, tcl)")
+	modulefileCmd.Flags().String("container-root", "", "CVMFS directory the container is published under (default: container_root from the config, else "+defaultContainerRoot+")")
+	modulefileCmd.Flags().String("output", "", "Write the modulefile to this path instead of stdout")
+	modulefileCmd.Flags().StringArray("option", []string{}, "Describe the image built with recipe option KEY=VALUE (repeatable)")
+	rootCmd.AddCommand(&modulefileCmd)
+}
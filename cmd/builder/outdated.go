@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/neurodesk/builder/pkg/upstream"
+	"github.com/spf13/cobra"
+)
+
+// outdatedRecipe is a recipe `builder outdated` checked.
+type outdatedRecipe struct {
+	Recipe  string `json:"recipe"`
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Source  string `json:"source"`
+	// Latest is the newest upstream version, when it is newer.
+	Latest string `json:"latest,omitempty"`
+	// Candidates are the upstream versions newer than Version, newest
+	// first.
+	Candidates []string `json:"candidates,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// upstreamSource names where u looks for releases.
+func upstreamSource(u *recipe.UpstreamInfo) string {
+	switch u.Kind {
+	case recipe.UpstreamGitHub:
+		return "github:" + u.Repo
+	case recipe.UpstreamPyPI:
+		return "pypi:" + u.Package
+	case recipe.UpstreamConda:
+		channel := u.Channel
+		if channel == "" {
+			channel = "conda-forge"
+		}
+		return "conda:" + channel + "/" + u.Package
+	}
+	return u.URL
+}
+
+var outdatedCmd = cobra.Command{
+	Use:   "outdated [recipe...]",
+	Short: "Report recipes whose upstream has released versions newer than their version",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "json" && format != "table" {
+			return fmt.Errorf("unsupported format %q (supported: json, table)", format)
+		}
+		all, _ := cmd.Flags().GetBool("all")
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		dirs := args
+		if len(dirs) == 0 {
+			if dirs, err = listRecipes(cfg); err != nil {
+				return err
+			}
+		} else {
+			for i, spec := range dirs {
+				if dirs[i], err = resolveRecipePath(cfg, spec); err != nil {
+					return err
+				}
+			}
+		}
+
+		checker := upstream.New()
+		checker.GitHubToken = os.Getenv("GITHUB_TOKEN")
+		results := []outdatedRecipe{}
+		failed, unchecked := 0, 0
+		for _, dir := range dirs {
+			build, err := loadRecipeWithOptions(dir, recipe.LoadOptions{Mode: recipeLoadMode()})
+			if err != nil {
+				results = append(results, outdatedRecipe{Path: dir, Error: err.Error()})
+				failed++
+				continue
+			}
+			src := build.Upstream()
+			if src == nil {
+				unchecked++
+				continue
+			}
+			res := outdatedRecipe{Recipe: build.Name, Path: dir, Version: build.Version, Source: upstreamSource(src)}
+			versions, err := checker.Versions(context.Background(), *src)
+			if err != nil {
+				res.Error = err.Error()
+				failed++
+			} else if res.Candidates = upstream.Newer(build.Version, versions); len(res.Candidates) > 0 {
+				res.Latest = res.Candidates[0]
+			} else if !all {
+				continue
+			}
+			results = append(results, res)
+		}
+		if unchecked > 0 {
+			fmt.Fprintf(os.Stderr, "%d recipes declare no upstream and were not checked\n", unchecked)
+		}
+
+		out := cmd.OutOrStdout()
+		if format == "json" {
+			if err := printJSON(out, results); err != nil {
+				return err
+			}
+		} else {
+			tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "RECIPE\tVERSION\tLATEST\tSOURCE\tNEWER")
+			for _, r := range results {
+				newer := strings.Join(r.Candidates, ", ")
+				if r.Error != "" {
+					newer = "error: " + r.Error
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Recipe, r.Version, r.Latest, r.Source, newer)
+			}
+			if err := tw.Flush(); err != nil {
+				return err
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d recipes could not be checked", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	outdatedCmd.Flags().String("format", "json", "Output format (json, table)")
+	outdatedCmd.Flags().Bool("all", false, "Also list the recipes that are up to date")
+	rootCmd.AddCommand(&outdatedCmd)
+}
@@ -0,0 +1,26 @@
+package main
+
+import "fmt"
+
+var (
+	buildUntil       string
+	buildFromCacheOf []string
+)
+
+// applyUntil truncates the build of stage after the step named by --until
+// (see ir.Definition.FindStep). A partial build is tagged
+// <name>:<version>-partial, is never skipped as up-to-date and is not
+// recorded in the build history.
+func applyUntil(stage *genericStageResult) error {
+	if buildUntil == "" {
+		return nil
+	}
+	step, err := stage.irDef.FindStep(buildUntil)
+	if err != nil {
+		return fmt.Errorf("--until: %w", err)
+	}
+	fmt.Printf("Building until [step %d] %s\n", step+1, stage.irDef.Directives[step].Describe())
+	stage.irDef = stage.irDef.Truncate(step + 1)
+	stage.partial = true
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+var pinBasesCmd = cobra.Command{
+	Use:   "pin-bases [recipe...]",
+	Short: "Pin the base images of recipes to the digests their tags name (all recipes when none are given); --refresh re-resolves existing pins",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refresh, _ := cmd.Flags().GetBool("refresh")
+		check, _ := cmd.Flags().GetBool("check")
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		recipes := args
+		if len(recipes) == 0 {
+			if recipes, err = listRecipes(cfg); err != nil {
+				return err
+			}
+		}
+
+		_, _, oc := netcaches()
+		unpinned, failed := 0, 0
+		for _, spec := range recipes {
+			dir, err := resolveRecipePath(cfg, spec)
+			if err != nil {
+				return err
+			}
+			build, err := loadRecipe(dir)
+			if err != nil {
+				return err
+			}
+			path := filepath.Join(dir, "build.yaml")
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			out := src
+			for _, image := range build.BaseImages() {
+				name, digest := recipe.SplitImageDigest(image)
+				switch {
+				case strings.Contains(image, "{{"):
+					fmt.Fprintf(os.Stderr, "note: %s: base image %s is templated; pin it by hand\n", path, image)
+					continue
+				case digest != "" && !refresh:
+					continue
+				case check:
+					if digest == "" {
+						fmt.Printf("%s: %s is not pinned\n", path, image)
+						unpinned++
+					}
+					continue
+				}
+				resolved, err := oc.Resolve(context.Background(), name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: resolving %s: %v\n", path, name, err)
+					failed++
+					continue
+				}
+				if resolved == digest {
+					continue
+				}
+				pinned := name + "@" + resolved
+				edited, lines, err := recipe.ReplaceScalarValues(out, image, pinned)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				if len(lines) == 0 {
+					fmt.Fprintf(os.Stderr, "note: %s: %s is not written in build.yaml (is it set by an override?); pin it to %s by hand\n", path, image, pinned)
+					continue
+				}
+				out = edited
+				fmt.Printf("%s:%d: %s -> %s\n", path, lines[0], image, pinned)
+			}
+			if string(out) == string(src) {
+				continue
+			}
+			if err := os.WriteFile(path, out, 0o644); err != nil {
+				return fmt.Errorf("writing %s: %w", path, err)
+			}
+		}
+		switch {
+		case unpinned == 1:
+			return fmt.Errorf("1 base image is not pinned; run builder pin-bases")
+		case unpinned > 1:
+			return fmt.Errorf("%d base images are not pinned; run builder pin-bases", unpinned)
+		case failed == 1:
+			return fmt.Errorf("1 base image could not be resolved")
+		case failed > 1:
+			return fmt.Errorf("%d base images could not be resolved", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	pinBasesCmd.Flags().Bool("refresh", false, "Also re-resolve base images that are already pinned, updating their digests")
+	pinBasesCmd.Flags().Bool("check", false, "Only list the base images that are not pinned, exiting with an error if there are any (for CI)")
+	rootCmd.AddCommand(&pinBasesCmd)
+}
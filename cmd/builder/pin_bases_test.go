@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pinBasesTestTree is a recipe root whose recipes use base images of a
+// local registry that has only ok:1, and sets the configuration to it.
+func pinBasesTestTree(t *testing.T) string {
+	t.Helper()
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/ok/manifests/1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(registry.Close)
+	host := strings.TrimPrefix(registry.URL, "http://")
+
+	root := t.TempDir()
+	for _, name := range []string{"ok", "missing", "gone"} {
+		recipe := strings.Replace(sprintfRecipe(name, "    - run:\n        - echo hi\n"), "ubuntu:22.04", host+"/"+name+":1", 1)
+		writeTestFile(t, root, "recipes/"+name+"/build.yaml", recipe)
+	}
+	t.Chdir(root)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("BUILDER_RECIPE_ROOTS", filepath.Join(root, "recipes"))
+	useTestCaches(t)
+	return root
+}
+
+func runPinBases(t *testing.T, check bool, args ...string) error {
+	t.Helper()
+	if err := pinBasesCmd.Flags().Set("check", fmt.Sprint(check)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pinBasesCmd.Flags().Set("check", "false") })
+	return pinBasesCmd.RunE(&pinBasesCmd, args)
+}
+
+func TestPinBasesCounts(t *testing.T) {
+	root := pinBasesTestTree(t)
+	for _, tc := range []struct {
+		check bool
+		args  []string
+		want  string
+	}{
+		{check: true, want: "3 base images are not pinned; run builder pin-bases"},
+		{check: true, args: []string{"gone"}, want: "1 base image is not pinned; run builder pin-bases"},
+		{args: []string{"missing", "gone"}, want: "2 base images could not be resolved"},
+		{args: []string{"ok", "missing"}, want: "1 base image could not be resolved"},
+		// ok is pinned now.
+		{check: true, args: []string{"ok"}},
+	} {
+		err := runPinBases(t, tc.check, tc.args...)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tc.want {
+			t.Fatalf("pin-bases check=%t %q: error %q, want %q", tc.check, tc.args, got, tc.want)
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(root, "recipes", "ok", "build.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "/ok:1@sha256:") {
+		t.Fatalf("ok/build.yaml was not pinned:\n%s", b)
+	}
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/neurodesk/builder/pkg/resolve"
+	"github.com/spf13/cobra"
+)
+
+var prDiffCmd = cobra.Command{
+	Use:   "pr-diff",
+	Short: "Compile changed recipes at a base ref and now, and print a semantic diff as markdown",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		base, _ := cmd.Flags().GetString("base")
+		if base == "" {
+			return fmt.Errorf("--base is required")
+		}
+		outPath, _ := cmd.Flags().GetString("output")
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+
+		changed, err := changedRecipesSince(cfg, base)
+		if err != nil {
+			return err
+		}
+
+		tmp, err := os.MkdirTemp("", "builder-pr-diff-")
+		if err != nil {
+			return fmt.Errorf("creating temp dir: %w", err)
+		}
+		defer os.RemoveAll(tmp)
+
+		var diffs []recipeDiff
+		for i, c := range changed {
+			oldDir := ""
+			if c.existedAtBase {
+				oldDir = filepath.Join(tmp, fmt.Sprint(i))
+				if err := extractGitTree(c.repo, base, c.repoRel, oldDir); err != nil {
+					return fmt.Errorf("extracting %s at %s: %w", c.name, base, err)
+				}
+				oldDir = filepath.Join(oldDir, filepath.FromSlash(c.repoRel))
+			}
+			diffs = append(diffs, diffRecipeDirs(cfg, c.name, oldDir, c.dir))
+		}
+
+		md := renderRecipeDiffsMarkdown(base, diffs)
+		if outPath == "" {
+			fmt.Print(md)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(outPath), 0o755); err != nil {
+			return fmt.Errorf("creating output directory: %w", err)
+		}
+		return os.WriteFile(outPath, []byte(md), 0o644)
+	},
+}
+
+type changedRecipe struct {
+	name string
+	// dir is the recipe directory in the working tree; it may no longer exist.
+	dir string
+	// repo is the git top-level directory containing the recipe root.
+	repo string
+	// repoRel is the recipe directory relative to repo, slash separated.
+	repoRel       string
+	existedAtBase bool
+}
+
+// changedRecipesSince lists recipes in the configured roots with files that
+// differ between base and the working tree, or that git does not track yet.
+func changedRecipesSince(cfg builderConfig, base string) ([]changedRecipe, error) {
+	seen := map[string]bool{}
+	var out []changedRecipe
+	for _, root := range cfg.RecipeRoots {
+		repo, err := gitOutput(root, "rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, fmt.Errorf("recipe root %s is not in a git repository: %w", root, err)
+		}
+		rootAbs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		if resolved, err := filepath.EvalSymlinks(rootAbs); err == nil {
+			rootAbs = resolved
+		}
+		rootRel, err := filepath.Rel(repo, rootAbs)
+		if err != nil {
+			return nil, err
+		}
+		rootRel = filepath.ToSlash(rootRel)
+
+		names, err := gitOutput(repo, "diff", "--name-only", base, "--", rootRel)
+		if err != nil {
+			return nil, fmt.Errorf("listing changes since %s: %w", base, err)
+		}
+		// New recipes that are not yet added to the index are changes too.
+		untracked, err := gitOutput(repo, "ls-files", "--others", "--exclude-standard", "--", rootRel)
+		if err != nil {
+			return nil, fmt.Errorf("listing untracked files: %w", err)
+		}
+		for _, line := range strings.Split(names+"\n"+untracked, "\n") {
+			rel := strings.TrimPrefix(line, rootRel+"/")
+			if line == "" || rel == line && rootRel != "." {
+				continue
+			}
+			name, _, ok := strings.Cut(rel, "/")
+			if !ok {
+				continue
+			}
+			recipeRel := name
+			if rootRel != "." {
+				recipeRel = rootRel + "/" + name
+			}
+			if seen[recipeRel] {
+				continue
+			}
+			seen[recipeRel] = true
+
+			_, err := gitOutput(repo, "cat-file", "-e", base+":"+recipeRel+"/build.yaml")
+			out = append(out, changedRecipe{
+				name:          name,
+				dir:           filepath.Join(root, name),
+				repo:          repo,
+				repoRel:       recipeRel,
+				existedAtBase: err == nil,
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].repoRel < out[j].repoRel })
+	return out, nil
+}
+
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// extractGitTree writes the tree of path at ref into dst, keeping the
+// repository-relative layout.
+func extractGitTree(repo, ref, path, dst string) error {
+	cmd := exec.Command("git", "archive", "--format=tar", ref, "--", path)
+	cmd.Dir = repo
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git archive: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	tr := tar.NewReader(bytes.NewReader(out))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		if rel, err := filepath.Rel(dst, target); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("archive entry escapes destination: %q", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFromReader(target, tr, hdr.Mode&0o111 != 0); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type directiveChange struct {
+	// Kind is one of "added", "removed" or "modified".
+	Kind string
+	// Step is the 1-based index in the new definition (or the old one for removals).
+	Step int
+	Old  string
+	New  string
+}
+
+type valueChange struct {
+	Key string
+	Old string
+	New string
+}
+
+type recipeDiff struct {
+	Name       string
+	OldVersion string
+	NewVersion string
+	Added      bool
+	Removed    bool
+	// Error is set when either side fails to compile.
+	Error string
+
+	Directives []directiveChange
+	Env        []valueChange
+	Labels     []valueChange
+	Files      []valueChange
+
+	// FirstChangedStep is the 1-based step from which cached layers are
+	// invalidated, or 0 when the directive lists are identical.
+	FirstChangedStep int
+	TotalSteps       int
+}
+
+func (d recipeDiff) empty() bool {
+	return d.Error == "" && !d.Added && !d.Removed && d.OldVersion == d.NewVersion &&
+		len(d.Directives) == 0 && len(d.Env) == 0 && len(d.Labels) == 0 && len(d.Files) == 0
+}
+
+func diffRecipeDirs(cfg builderConfig, name, oldDir, newDir string) recipeDiff {
+	d := recipeDiff{Name: name}
+
+	var oldCompiled, newCompiled *compiledRecipe
+	var errs []string
+	if oldDir != "" {
+		c, err := compileRecipe(cfg, oldDir)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("base: %v", err))
+		}
+		oldCompiled = c
+	} else {
+		d.Added = true
+	}
+	if _, err := os.Stat(filepath.Join(newDir, "build.yaml")); err == nil {
+		c, err := compileRecipe(cfg, newDir)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("head: %v", err))
+		}
+		newCompiled = c
+	} else {
+		d.Removed = true
+	}
+	if len(errs) > 0 {
+		d.Error = strings.Join(errs, "; ")
+		return d
+	}
+	if d.Added && d.Removed {
+		d.Error = "recipe has no build.yaml at base or head"
+		return d
+	}
+
+	var oldLabels, newLabels []string
+	oldEnv, newEnv := map[string]string{}, map[string]string{}
+	oldImageLabels, newImageLabels := map[string]string{}, map[string]string{}
+	oldFiles, newFiles := map[string]string{}, map[string]string{}
+	if oldCompiled != nil {
+		d.OldVersion = oldCompiled.Build.Version
+		oldLabels = definitionLabels(oldCompiled.Definition)
+		oldEnv = finalEnvironment(oldCompiled.Definition)
+		oldImageLabels = oldCompiled.Definition.Labels()
+		oldFiles = stagedFileSources(fileResolver(cfg, oldDir), oldCompiled.Plan)
+	}
+	if newCompiled != nil {
+		d.NewVersion = newCompiled.Build.Version
+		newLabels = definitionLabels(newCompiled.Definition)
+		newEnv = finalEnvironment(newCompiled.Definition)
+		newImageLabels = newCompiled.Definition.Labels()
+		newFiles = stagedFileSources(fileResolver(cfg, newDir), newCompiled.Plan)
+	}
+
+	d.Directives = diffDirectiveLabels(oldLabels, newLabels)
+	d.TotalSteps = len(newLabels)
+	for i := 0; i < len(oldLabels) || i < len(newLabels); i++ {
+		if i >= len(oldLabels) || i >= len(newLabels) || oldLabels[i] != newLabels[i] {
+			d.FirstChangedStep = i + 1
+			break
+		}
+	}
+	d.Env = diffStringMaps(oldEnv, newEnv)
+	d.Labels = diffStringMaps(oldImageLabels, newImageLabels)
+	d.Files = diffStringMaps(oldFiles, newFiles)
+
+	// Staged files reach the build through the cache mount of RUN steps,
+	// whose labels stay the same when only a file changes.
+	if newCompiled != nil && len(d.Files) > 0 {
+		var changed []string
+		for _, f := range d.Files {
+			changed = append(changed, f.Key)
+		}
+		if step := firstMountingStep(newCompiled.Definition, changed); step > 0 && (d.FirstChangedStep == 0 || step < d.FirstChangedStep) {
+			d.FirstChangedStep = step
+		}
+	}
+	return d
+}
+
+// firstMountingStep returns the 1-based step, numbered as definitionLabels
+// numbers them, of the first RUN that mounts the staged files and uses one
+// of names, or 0 when none does. A RUN with the mount that names none of
+// them counts when no RUN names one.
+func firstMountingStep(def *ir.Definition, names []string) int {
+	firstMount := 0
+	step := 0
+	for _, d := range def.Directives {
+		if _, ok := d.Directive.(ir.LabelDirective); ok {
+			continue
+		}
+		step++
+		run, ok := d.Directive.(ir.RunWithMountsDirective)
+		if !ok || !slices.ContainsFunc(run.Mounts, isCacheMount) {
+			continue
+		}
+		if firstMount == 0 {
+			firstMount = step
+		}
+		for _, name := range names {
+			if usesStagedFile(run.Command, name) {
+				return step
+			}
+		}
+	}
+	return firstMount
+}
+
+// isCacheMount reports whether mount binds the staged files, as get_file
+// adds it.
+func isCacheMount(mount string) bool {
+	return slices.Contains(strings.Split(strings.TrimPrefix(mount, "--mount="), ","), "from=cache")
+}
+
+// usesStagedFile reports whether command refers to the staged file name, or
+// to a path below it.
+func usesStagedFile(command, name string) bool {
+	path := "/.neurocontainer-cache/" + name
+	for rest := command; ; {
+		i := strings.Index(rest, path)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(path):]
+		if rest == "" || !strings.ContainsAny(rest[:1], "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-") {
+			return true
+		}
+	}
+}
+
+// definitionLabels formats each directive for diffing. LABEL directives are
+// left out: they add no layers, so a version bump in the OCI labels would
+// otherwise look like an early cache invalidation. They are diffed as a map.
+func definitionLabels(def *ir.Definition) []string {
+	labels := make([]string, 0, len(def.Directives))
+	for _, d := range def.Directives {
+		if _, ok := d.Directive.(ir.LabelDirective); ok {
+			continue
+		}
+		labels = append(labels, formatDirectiveLabel(d.Directive))
+	}
+	return labels
+}
+
+// finalEnvironment folds the ENV directives of the final stage into the
+// environment the image ends up with.
+func finalEnvironment(def *ir.Definition) map[string]string {
+	env := map[string]string{}
+	for _, d := range def.FinalStage() {
+		if e, ok := d.Directive.(ir.EnvironmentDirective); ok {
+			for k, v := range e {
+				env[k] = v
+			}
+		}
+	}
+	return env
+}
+
+// stagedFileSources describes where each staged file comes from, so a changed
+// URL or literal shows up as a changed value. Local files, found with r, are
+// described by their contents too, so editing one is a change.
+func stagedFileSources(r resolve.Resolver, plan *recipe.StagingPlan) map[string]string {
+	out := map[string]string{}
+	if plan == nil {
+		return out
+	}
+	for _, f := range plan.Files {
+		switch {
+		case f.Git != nil:
+			ref := f.Git.Commit
+			if ref == "" {
+				ref = f.Git.Ref
+			}
+			out[f.Name] = "git " + f.Git.URL + "@" + ref
+		case f.OCI != nil:
+			out[f.Name] = "oci " + f.OCI.Ref + "@" + f.OCI.Digest
+			if f.OCI.File != "" {
+				out[f.Name] += " " + f.OCI.File
+			}
+		case f.URL != "":
+			out[f.Name] = "url " + f.URL
+		case f.HostFilename != "":
+			out[f.Name] = "file " + f.HostFilename
+			if sum, err := hostFileDigest(r, f.HostFilename); err != nil {
+				out[f.Name] += " (" + err.Error() + ")"
+			} else {
+				out[f.Name] += " sha256:" + sum[:12]
+			}
+		default:
+			sum := sha256.Sum256([]byte(f.Contents))
+			out[f.Name] = "contents sha256:" + hex.EncodeToString(sum[:])[:12]
+		}
+		if f.Extract != nil {
+			out[f.Name] += fmt.Sprintf(" (extracted, strip %d, subdir %q)", f.Extract.StripComponents, f.Extract.Subdir)
+		}
+	}
+	return out
+}
+
+// hostFileDigest returns the sha256 of the local file name, or of the paths
+// and contents of the files below it when it is a directory or pattern.
+func hostFileDigest(r resolve.Resolver, name string) (string, error) {
+	src, tree, err := r.FindTree("file", name)
+	if err != nil {
+		return "", err
+	}
+	if tree == nil {
+		return sha256File(src)
+	}
+	h := sha256.New()
+	for _, m := range tree {
+		sum, err := sha256File(m.Path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s  %s\n", sum, m.Rel)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diffDirectiveLabels computes an LCS edit script between two directive
+// lists. A run of removals directly followed by a run of additions is paired
+// up into modifications.
+func diffDirectiveLabels(oldLabels, newLabels []string) []directiveChange {
+	n, m := len(oldLabels), len(newLabels)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if oldLabels[i] == newLabels[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var changes []directiveChange
+	var removed, added []directiveChange
+	flush := func() {
+		paired := min(len(removed), len(added))
+		for k := 0; k < paired; k++ {
+			changes = append(changes, directiveChange{Kind: "modified", Step: added[k].Step, Old: removed[k].Old, New: added[k].New})
+		}
+		changes = append(changes, removed[paired:]...)
+		changes = append(changes, added[paired:]...)
+		removed, added = nil, nil
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && oldLabels[i] == newLabels[j]:
+			flush()
+			i++
+			j++
+		case i < n && (j >= m || lcs[i+1][j] >= lcs[i][j+1]):
+			if len(added) > 0 {
+				flush()
+			}
+			removed = append(removed, directiveChange{Kind: "removed", Step: i + 1, Old: oldLabels[i]})
+			i++
+		default:
+			added = append(added, directiveChange{Kind: "added", Step: j + 1, New: newLabels[j]})
+			j++
+		}
+	}
+	flush()
+	return changes
+}
+
+func diffStringMaps(oldMap, newMap map[string]string) []valueChange {
+	keys := map[string]struct{}{}
+	for k := range oldMap {
+		keys[k] = struct{}{}
+	}
+	for k := range newMap {
+		keys[k] = struct{}{}
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []valueChange
+	for _, k := range sorted {
+		o, n := oldMap[k], newMap[k]
+		if o != n {
+			changes = append(changes, valueChange{Key: k, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+func renderRecipeDiffsMarkdown(base string, diffs []recipeDiff) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Recipe changes since `%s`\n\n", base)
+
+	var shown []recipeDiff
+	for _, d := range diffs {
+		if !d.empty() {
+			shown = append(shown, d)
+		}
+	}
+	if len(shown) == 0 {
+		b.WriteString("No effective recipe changes.\n")
+		return b.String()
+	}
+
+	for _, d := range shown {
+		fmt.Fprintf(&b, "### %s\n\n", d.Name)
+		switch {
+		case d.Error != "":
+			fmt.Fprintf(&b, "Failed to compile: `%s`\n\n", markdownInline(d.Error))
+			continue
+		case d.Added:
+			fmt.Fprintf(&b, "New recipe, version `%s` (%d steps).\n\n", d.NewVersion, d.TotalSteps)
+			continue
+		case d.Removed:
+			fmt.Fprintf(&b, "Recipe removed (was version `%s`).\n\n", d.OldVersion)
+			continue
+		}
+
+		if d.OldVersion != d.NewVersion {
+			fmt.Fprintf(&b, "- Version: `%s` → `%s`\n", d.OldVersion, d.NewVersion)
+		}
+		if d.FirstChangedStep > 0 {
+			rebuilt := d.TotalSteps - d.FirstChangedStep + 1
+			if rebuilt < 0 {
+				rebuilt = 0
+			}
+			fmt.Fprintf(&b, "- Cache invalidation: from step %d of %d (%d layer(s) rebuilt)\n", d.FirstChangedStep, d.TotalSteps, rebuilt)
+		} else {
+			b.WriteString("- Cache invalidation: none\n")
+		}
+		b.WriteString("\n")
+
+		if len(d.Directives) > 0 {
+			b.WriteString("**Directives**\n\n```diff\n")
+			for _, c := range d.Directives {
+				switch c.Kind {
+				case "added":
+					fmt.Fprintf(&b, "+ [%d] %s\n", c.Step, diffLine(c.New))
+				case "removed":
+					fmt.Fprintf(&b, "- [%d] %s\n", c.Step, diffLine(c.Old))
+				case "modified":
+					fmt.Fprintf(&b, "- [%d] %s\n", c.Step, diffLine(c.Old))
+					fmt.Fprintf(&b, "+ [%d] %s\n", c.Step, diffLine(c.New))
+				}
+			}
+			b.WriteString("```\n\n")
+		}
+		writeValueChangesMarkdown(&b, "Environment", d.Env)
+		writeValueChangesMarkdown(&b, "Labels", d.Labels)
+		writeValueChangesMarkdown(&b, "Files", d.Files)
+	}
+	return b.String()
+}
+
+func writeValueChangesMarkdown(b *strings.Builder, title string, changes []valueChange) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "**%s**\n\n| Name | Before | After |\n| --- | --- | --- |\n", title)
+	for _, c := range changes {
+		fmt.Fprintf(b, "| `%s` | %s | %s |\n", markdownInline(c.Key), markdownCell(c.Old), markdownCell(c.New))
+	}
+	b.WriteString("\n")
+}
+
+func diffLine(s string) string {
+	return shortenLabel(strings.Join(strings.Fields(s), " "), 200)
+}
+
+func markdownInline(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "`", "'"), "\n", " ")
+}
+
+func markdownCell(s string) string {
+	if s == "" {
+		return "_(unset)_"
+	}
+	return "`" + strings.ReplaceAll(markdownInline(shortenLabel(s, 120)), "|", "\\|") + "`"
+}
+
+func init() {
+	prDiffCmd.Flags().String("base", "", "Git ref to compare the working tree against")
+	prDiffCmd.Flags().String("output", "", "Write the markdown to this file instead of stdout")
+	rootCmd.AddCommand(&prDiffCmd)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/netcache"
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/neurodesk/builder/pkg/resolve"
+)
+
+func TestDiffDirectiveLabels(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		old, new []string
+		want     []string
+	}{
+		{
+			name: "identical",
+			old:  []string{"FROM a", "RUN x"},
+			new:  []string{"FROM a", "RUN x"},
+		},
+		{
+			name: "modified",
+			old:  []string{"FROM a", "RUN x", "RUN y"},
+			new:  []string{"FROM a", "RUN z", "RUN y"},
+			want: []string{`modified 2 "RUN x" -> "RUN z"`},
+		},
+		{
+			name: "added at the end",
+			old:  []string{"FROM a"},
+			new:  []string{"FROM a", "RUN x", "RUN y"},
+			want: []string{`added 2 "" -> "RUN x"`, `added 3 "" -> "RUN y"`},
+		},
+		{
+			name: "removed",
+			old:  []string{"FROM a", "RUN x", "RUN y"},
+			new:  []string{"FROM a", "RUN y"},
+			want: []string{`removed 2 "RUN x" -> ""`},
+		},
+		{
+			name: "more removals than additions",
+			old:  []string{"FROM a", "RUN x", "RUN y", "ENV k=v"},
+			new:  []string{"FROM a", "RUN z", "ENV k=v"},
+			want: []string{`modified 2 "RUN x" -> "RUN z"`, `removed 3 "RUN y" -> ""`},
+		},
+		{
+			name: "new recipe",
+			new:  []string{"FROM a"},
+			want: []string{`added 1 "" -> "FROM a"`},
+		},
+	} {
+		var got []string
+		for _, c := range diffDirectiveLabels(tc.old, tc.new) {
+			got = append(got, fmt.Sprintf("%s %d %q -> %q", c.Kind, c.Step, c.Old, c.New))
+		}
+		if strings.Join(got, "\n") != strings.Join(tc.want, "\n") {
+			t.Fatalf("%s: diffDirectiveLabels =\n%s\nwant\n%s", tc.name, strings.Join(got, "\n"), strings.Join(tc.want, "\n"))
+		}
+	}
+}
+
+func TestStagedFileSources(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "tree"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tree", "a"), []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	plan := &recipe.StagingPlan{Files: []recipe.StagedFile{
+		{Name: "data.txt", HostFilename: "data.txt"},
+		{Name: "tree", HostFilename: "tree"},
+		{Name: "missing", HostFilename: "missing.txt"},
+		{Name: "tool.tar.gz", URL: "https://example.com/tool.tar.gz", Extract: &recipe.Extraction{StripComponents: 1}},
+		{Name: "src", Git: &netcache.GitSource{URL: "https://example.com/src.git", Ref: "v1"}},
+		{Name: "hello.sh", Contents: "echo hello\n"},
+	}}
+	r := resolve.Resolver{RecipeDir: dir}
+	got := stagedFileSources(r, plan)
+
+	want := map[string]string{
+		"data.txt":    "file data.txt sha256:2c8b08da5ce6",
+		"tool.tar.gz": `url https://example.com/tool.tar.gz (extracted, strip 1, subdir "")`,
+		"src":         "git https://example.com/src.git@v1",
+		"hello.sh":    "contents sha256:5dbad7dd0b9b",
+	}
+	for name, w := range want {
+		if got[name] != w {
+			t.Fatalf("stagedFileSources[%s] = %q, want %q", name, got[name], w)
+		}
+	}
+	if !strings.HasPrefix(got["tree"], "file tree sha256:") {
+		t.Fatalf("stagedFileSources[tree] = %q, want a digest of the directory", got["tree"])
+	}
+	if !strings.HasPrefix(got["missing"], "file missing.txt (") {
+		t.Fatalf("stagedFileSources[missing] = %q, want the lookup error", got["missing"])
+	}
+
+	// Editing a local file changes its source, so the edit is a change.
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("two\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tree", "a"), []byte("b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	edited := stagedFileSources(r, plan)
+	changes := diffStringMaps(got, edited)
+	if len(changes) != 2 || changes[0].Key != "data.txt" || changes[1].Key != "tree" {
+		t.Fatalf("changes after editing data.txt and tree/a = %+v", changes)
+	}
+}
+
+func TestFirstMountingStep(t *testing.T) {
+	mount := "--mount=type=bind,from=cache,source=/,target=/.neurocontainer-cache,readonly"
+	def := &ir.Definition{Directives: []ir.DirectiveWithMetadata{
+		{Directive: ir.FromImageDirective("ubuntu:24.04")},
+		{Directive: ir.LabelDirective{"a": "b"}},
+		{Directive: ir.RunDirective("apt-get update")},
+		{Directive: ir.RunWithMountsDirective{Mounts: []string{mount}, Command: "tar xf /.neurocontainer-cache/tool.tar.gz"}},
+		{Directive: ir.RunWithMountsDirective{Mounts: []string{mount}, Command: "cp -r /.neurocontainer-cache/src/lib /opt/"}},
+	}}
+	for _, tc := range []struct {
+		names []string
+		want  int
+	}{
+		{names: []string{"src"}, want: 4},
+		{names: []string{"src", "tool.tar.gz"}, want: 3},
+		// "tool" is a prefix of a mounted name, not a name used; no RUN
+		// names it, so the first one with the mount counts.
+		{names: []string{"tool"}, want: 3},
+	} {
+		if got := firstMountingStep(def, tc.names); got != tc.want {
+			t.Fatalf("firstMountingStep(%q) = %d, want %d", tc.names, got, tc.want)
+		}
+	}
+	if got := firstMountingStep(&ir.Definition{Directives: def.Directives[:3]}, []string{"src"}); got != 0 {
+		t.Fatalf("firstMountingStep without mounts = %d, want 0", got)
+	}
+}
+
+func TestRenderRecipeDiffsMarkdown(t *testing.T) {
+	diffs := []recipeDiff{
+		{Name: "unchanged", OldVersion: "1.0", NewVersion: "1.0"},
+		{
+			Name:       "fsl",
+			OldVersion: "6.0.6",
+			NewVersion: "6.0.7",
+			Directives: []directiveChange{
+				{Kind: "modified", Step: 3, Old: "RUN  install\n6.0.6", New: "RUN install 6.0.7"},
+				{Kind: "added", Step: 5, New: "ENV A=1"},
+			},
+			Env:              []valueChange{{Key: "A", New: "1"}},
+			Files:            []valueChange{{Key: "fsl.tar.gz", Old: "url https://x/6.0.6|a", New: "url https://x/6.0.7|a"}},
+			FirstChangedStep: 3,
+			TotalSteps:       6,
+		},
+		{
+			Name:       "data",
+			OldVersion: "1",
+			NewVersion: "1",
+			Files:      []valueChange{{Key: "data.txt", Old: "file data.txt sha256:aaa", New: "file data.txt sha256:bbb"}},
+		},
+		{Name: "new", Added: true, NewVersion: "2.0", TotalSteps: 4},
+		{Name: "old", Removed: true, OldVersion: "0.1"},
+		{Name: "broken", Error: "head: `x` failed"},
+	}
+	want := "## Recipe changes since `origin/main`\n\n" +
+		"### fsl\n\n" +
+		"- Version: `6.0.6` → `6.0.7`\n" +
+		"- Cache invalidation: from step 3 of 6 (4 layer(s) rebuilt)\n\n" +
+		"**Directives**\n\n```diff\n" +
+		"- [3] RUN install 6.0.6\n" +
+		"+ [3] RUN install 6.0.7\n" +
+		"+ [5] ENV A=1\n" +
+		"```\n\n" +
+		"**Environment**\n\n| Name | Before | After |\n| --- | --- | --- |\n" +
+		"| `A` | _(unset)_ | `1` |\n\n" +
+		"**Files**\n\n| Name | Before | After |\n| --- | --- | --- |\n" +
+		"| `fsl.tar.gz` | `url https://x/6.0.6\\|a` | `url https://x/6.0.7\\|a` |\n\n" +
+		"### data\n\n" +
+		"- Cache invalidation: none\n\n" +
+		"**Files**\n\n| Name | Before | After |\n| --- | --- | --- |\n" +
+		"| `data.txt` | `file data.txt sha256:aaa` | `file data.txt sha256:bbb` |\n\n" +
+		"### new\n\nNew recipe, version `2.0` (4 steps).\n\n" +
+		"### old\n\nRecipe removed (was version `0.1`).\n\n" +
+		"### broken\n\nFailed to compile: `head: 'x' failed`\n\n"
+	if got := renderRecipeDiffsMarkdown("origin/main", diffs); got != want {
+		t.Fatalf("renderRecipeDiffsMarkdown =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := renderRecipeDiffsMarkdown("HEAD~1", diffs[:1]); got != "## Recipe changes since `HEAD~1`\n\nNo effective recipe changes.\n" {
+		t.Fatalf("renderRecipeDiffsMarkdown without changes =\n%s", got)
+	}
+}
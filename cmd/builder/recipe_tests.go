@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+)
+
+// stageTestData materializes the recipe's test_data files under
+// local/build/<name>/test-data and returns the docker run arguments that
+// mount them read-only at recipe.TestDataMountPoint. It returns no arguments
+// when the recipe declares no test data.
+func stageTestData(cfg builderConfig, recipePath, name string, plan *recipe.StagingPlan) ([]string, error) {
+	if len(plan.TestData) == 0 {
+		return nil, nil
+	}
+	dir := filepath.Join("local", "build", name, "test-data")
+	// Start clean so files removed from the recipe do not linger.
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("clearing test data dir: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating test data dir: %w", err)
+	}
+	if err := stagePlanFiles(cfg, recipePath, dir, plan.TestData); err != nil {
+		return nil, fmt.Errorf("staging test data: %w", err)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		"-v", abs + ":" + recipe.TestDataMountPoint + ":ro",
+		"-e", "TEST_DATA_DIR=" + recipe.TestDataMountPoint,
+	}, nil
+}
+
+// runRecipeScriptTests runs each non-manual script test in a fresh container
+// of rt. The script is passed to the test's executable (default /bin/bash)
+// with -c. extraArgs are added to every container, e.g. the test data mount.
+// Output is streamed to w; the error lists the failed tests.
+func runRecipeScriptTests(w io.Writer, rt testRuntime, tests []recipe.RecipeTest, extraArgs []string) error {
+	var failed []string
+	for _, t := range tests {
+		switch {
+		case t.Manual:
+			fmt.Fprintf(w, "SKIP %s (manual)\n", t.Name)
+			continue
+		case t.Command != "":
+			// Baked into the image and run by the deployment tester.
+			continue
+		case t.Script == "":
+			fmt.Fprintf(w, "SKIP %s (builtin %q is not supported)\n", t.Name, t.Builtin)
+			continue
+		}
+		executable := t.Executable
+		if executable == "" {
+			executable = "/bin/bash"
+		}
+		fmt.Fprintf(w, "RUN  %s\n", t.Name)
+		ctx, cancel := withTimeout(testTimeout)
+		err := rt.run(ctx, "builder-test", extraArgs, []string{executable, "-c", t.Script}, w)
+		cancel()
+		if err != nil {
+			err = timeoutError(ctx, "test", testTimeout, err)
+			fmt.Fprintf(w, "FAIL %s: %v\n", t.Name, err)
+			failed = append(failed, t.Name)
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s\n", t.Name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d recipe test(s) failed: %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
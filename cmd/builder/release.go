@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultContainerRoot is the Neurodesk CVMFS directory that holds one
+// directory per released container.
+const defaultContainerRoot = "/cvmfs/neurodesk.ardc.edu.au/containers"
+
+// releaseTokenEnv names the environment variable holding the bearer token
+// sent to the release endpoint.
+const releaseTokenEnv = "BUILDER_RELEASE_TOKEN"
+
+var releaseCmd = cobra.Command{
+	Use:   "release [recipe]",
+	Short: "Package a built image for CVMFS (SIF, modulefile, checksums) and upload it",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		tool, _ := cmd.Flags().GetString("tool")
+		image, _ := cmd.Flags().GetString("image")
+		outDir, _ := cmd.Flags().GetString("output-dir")
+		endpoint, _ := cmd.Flags().GetString("endpoint")
+		containerRoot, _ := cmd.Flags().GetString("container-root")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		if endpoint == "" {
+			endpoint = cfg.ReleaseEndpoint
+		}
+		if endpoint == "" && !dryRun {
+			return fmt.Errorf("no release endpoint; set release_endpoint in the config, pass --endpoint or use --dry-run")
+		}
+		containerRoot = resolveContainerRoot(cfg, containerRoot)
+
+		stage, err := prepareStage(cfg, args[0], nil, options)
+		if err != nil {
+			return err
+		}
+		release := stage.build.Name + "_" + stage.version
+		dir := filepath.Join(outDir, release)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("clearing %s: %w", dir, err)
+		}
+
+		if _, err := exportSIF(stage, image, tool, dir); err != nil {
+			return err
+		}
+
+		module := moduleForStage(stage, containerRoot)
+		modulePath := filepath.Join(dir, "modules", stage.build.Name, stage.version+".lua")
+		if err := os.MkdirAll(filepath.Dir(modulePath), 0o755); err != nil {
+			return fmt.Errorf("creating module directory: %w", err)
+		}
+		if err := os.WriteFile(modulePath, []byte(module.Lua()), 0o644); err != nil {
+			return fmt.Errorf("writing modulefile: %w", err)
+		}
+
+		files, err := writeChecksums(dir)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Release %s packaged in %s\n", release, dir)
+
+		for _, rel := range files {
+			target := strings.TrimRight(endpoint, "/") + "/" + url.PathEscape(release) + "/" + rel
+			if dryRun {
+				fmt.Printf("dry run: would upload %s to %s\n", rel, target)
+				continue
+			}
+			fmt.Printf("Uploading %s\n", rel)
+			if err := uploadFile(filepath.Join(dir, filepath.FromSlash(rel)), target); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// checksumFile lists the SHA-256 of every other file in a release directory.
+const checksumFile = "SHA256SUMS"
+
+// writeChecksums writes dir/SHA256SUMS in sha256sum format and returns the
+// slash-separated paths of the release files, with SHA256SUMS last so an
+// upload is only complete once its manifest arrives.
+func writeChecksums(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel != checksumFile {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing release files: %w", err)
+	}
+	sort.Strings(files)
+
+	var sums strings.Builder
+	for _, rel := range files {
+		sum, err := sha256File(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", sum, rel)
+	}
+	if err := os.WriteFile(filepath.Join(dir, checksumFile), []byte(sums.String()), 0o644); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", checksumFile, err)
+	}
+	return append(files, checksumFile), nil
+}
+
+func sha256File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", p, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uploadFile PUTs the file at p to target, authenticating with
+// $BUILDER_RELEASE_TOKEN when it is set.
+func uploadFile(p, target string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, target, f)
+	if err != nil {
+		return fmt.Errorf("creating upload request: %w", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if token := os.Getenv(releaseTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", p, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("uploading %s: %s: %s", p, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func init() {
+	releaseCmd.Flags().String("tool", "", "Program used to build the SIF (default: apptainer, else singularity)")
+	releaseCmd.Flags().String("image", "", "Registry image ref to release instead of the local Docker image")
+	releaseCmd.Flags().String("output-dir", filepath.Join("local", "release"), "Directory to assemble releases in")
+	releaseCmd.Flags().String("endpoint", "", "Base URL to upload the release to (default: release_endpoint from the config)")
+	releaseCmd.Flags().String("container-root", "", "CVMFS directory the container is published under (default: container_root from the config, else "+defaultContainerRoot+")")
+	releaseCmd.Flags().Bool("dry-run", false, "Package the release but only print the uploads")
+	releaseCmd.Flags().StringArray("option", []string{}, "Release the image built with recipe option KEY=VALUE (repeatable)")
+	rootCmd.AddCommand(&releaseCmd)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// remoteRoot is a recipe root in a git repository, written in recipe_roots
+// as URL[#REF[:SUBDIR]] like a Docker build context, e.g.
+// https://github.com/neurodesk/neurocontainers.git#main:recipes.
+type remoteRoot struct {
+	// Spec is the root as written in recipe_roots.
+	Spec string
+	URL  string
+	// Ref is a branch, tag or commit; empty for the remote's default branch.
+	Ref string
+	// Subdir is the directory of the recipes in the repository.
+	Subdir string
+}
+
+// parseRemoteRoot returns the remote root spec names, or false if spec is a
+// local directory.
+func parseRemoteRoot(spec string) (remoteRoot, bool) {
+	url, fragment, _ := strings.Cut(spec, "#")
+	remote := strings.HasSuffix(url, ".git") || strings.HasPrefix(url, "git@")
+	for _, scheme := range []string{"https://", "http://", "ssh://", "git://", "file://"} {
+		remote = remote || strings.HasPrefix(url, scheme)
+	}
+	if !remote {
+		return remoteRoot{}, false
+	}
+	ref, subdir, _ := strings.Cut(fragment, ":")
+	return remoteRoot{Spec: spec, URL: url, Ref: ref, Subdir: strings.Trim(subdir, "/")}, true
+}
+
+// reposDir is where remote recipe roots are checked out: $BUILDER_REPOS_DIR,
+// else local/repos.
+func reposDir() string {
+	return netcacheDir("BUILDER_REPOS_DIR", "repos")
+}
+
+// checkoutDir returns the working tree of the root, named after the
+// repository and keyed by its URL and ref so roots at different refs do not
+// share one.
+func (r remoteRoot) checkoutDir() string {
+	sum := sha256.Sum256([]byte(r.URL + "#" + r.Ref))
+	name := strings.TrimSuffix(path.Base(strings.TrimRight(r.URL, "/")), ".git")
+	if _, after, ok := strings.Cut(name, ":"); ok {
+		name = after
+	}
+	return filepath.Join(reposDir(), name+"-"+hex.EncodeToString(sum[:])[:12])
+}
+
+// dir returns the recipe root in the checkout.
+func (r remoteRoot) dir() string {
+	return filepath.Join(r.checkoutDir(), filepath.FromSlash(r.Subdir))
+}
+
+func (r remoteRoot) fetchRef() string {
+	if r.Ref == "" {
+		return "HEAD"
+	}
+	return r.Ref
+}
+
+func (r remoteRoot) cloned() bool {
+	st, err := os.Stat(filepath.Join(r.checkoutDir(), ".git"))
+	return err == nil && st.IsDir()
+}
+
+// clone makes a shallow checkout of the root's ref.
+func (r remoteRoot) clone() error {
+	dst := r.checkoutDir()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	steps := [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", r.URL},
+		{"fetch", "--quiet", "--depth", "1", "origin", r.fetchRef()},
+		{"checkout", "--quiet", "--detach", "FETCH_HEAD"},
+	}
+	for _, args := range steps {
+		if _, err := gitOutput(tmp, args...); err != nil {
+			return fmt.Errorf("cloning %s: %w", r.Spec, err)
+		}
+	}
+	return os.Rename(tmp, dst)
+}
+
+// update fetches the root's ref and checks it out. Local changes are an
+// error unless force is set, which discards them.
+func (r remoteRoot) update(force bool) (old, updated string, err error) {
+	dir := r.checkoutDir()
+	old, err = gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "", "", err
+	}
+	if dirty, err := gitOutput(dir, "status", "--porcelain"); err != nil {
+		return "", "", err
+	} else if dirty != "" && !force {
+		return "", "", fmt.Errorf("%s has local changes; commit them elsewhere or pass --force to discard them", dir)
+	}
+	if _, err := gitOutput(dir, "fetch", "--quiet", "--depth", "1", "origin", r.fetchRef()); err != nil {
+		return "", "", err
+	}
+	if _, err := gitOutput(dir, "checkout", "--quiet", "--force", "--detach", "FETCH_HEAD"); err != nil {
+		return "", "", err
+	}
+	if force {
+		if _, err := gitOutput(dir, "clean", "--quiet", "-fd"); err != nil {
+			return "", "", err
+		}
+	}
+	updated, err = gitOutput(dir, "rev-parse", "HEAD")
+	return old, updated, err
+}
+
+// remoteRoots returns the remote roots of cfg, as written before
+// resolveRemoteRoots replaced them.
+func remoteRoots(cfg builderConfig) []remoteRoot {
+	var roots []remoteRoot
+	for _, spec := range cfg.RecipeRoots {
+		if r, ok := parseRemoteRoot(spec); ok {
+			roots = append(roots, r)
+		}
+	}
+	return roots
+}
+
+// resolveRemoteRoots replaces the remote roots of cfg with their checkouts,
+// cloning those that are not checked out yet, and moves their
+// root_settings along.
+func resolveRemoteRoots(cfg *builderConfig) error {
+	for i, spec := range cfg.RecipeRoots {
+		r, ok := parseRemoteRoot(spec)
+		if !ok {
+			continue
+		}
+		if !r.cloned() {
+			fmt.Fprintf(os.Stderr, "Cloning recipe root %s into %s\n", spec, r.checkoutDir())
+			if err := r.clone(); err != nil {
+				return err
+			}
+		}
+		cfg.RecipeRoots[i] = r.dir()
+		if settings, ok := cfg.RootSettings[spec]; ok {
+			delete(cfg.RootSettings, spec)
+			cfg.RootSettings[r.dir()] = settings
+		}
+	}
+	return nil
+}
+
+var reposCmd = cobra.Command{
+	Use:   "repos",
+	Short: "Manage the checkouts of git recipe roots under local/repos",
+}
+
+var reposUpdateCmd = cobra.Command{
+	Use:   "update [root...]",
+	Short: "Fetch the configured ref of the git recipe roots, or of the given ones, and check it out",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		cfg, _, err := loadLayeredConfig()
+		if err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+		roots, err := selectRemoteRoots(cfg, args)
+		if err != nil {
+			return err
+		}
+		var failed []error
+		for _, r := range roots {
+			if !r.cloned() {
+				if err := r.clone(); err != nil {
+					failed = append(failed, err)
+					continue
+				}
+				head, _ := gitOutput(r.checkoutDir(), "rev-parse", "HEAD")
+				fmt.Printf("%s: cloned at %s\n", r.Spec, shortCommit(head))
+				continue
+			}
+			old, updated, err := r.update(force)
+			if err != nil {
+				failed = append(failed, fmt.Errorf("updating %s: %w", r.Spec, err))
+				continue
+			}
+			if old == updated {
+				fmt.Printf("%s: up to date at %s\n", r.Spec, shortCommit(updated))
+			} else {
+				fmt.Printf("%s: %s -> %s\n", r.Spec, shortCommit(old), shortCommit(updated))
+			}
+		}
+		return errors.Join(failed...)
+	},
+}
+
+var reposStatusCmd = cobra.Command{
+	Use:   "status",
+	Short: "Show the checked-out commit of each git recipe root, when it was fetched, and whether it is behind its ref",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		offline, _ := cmd.Flags().GetBool("offline")
+		cfg, _, err := loadLayeredConfig()
+		if err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+		roots := remoteRoots(cfg)
+		if len(roots) == 0 {
+			fmt.Println("No git recipe roots configured.")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ROOT\tCOMMIT\tFETCHED\tSTATE\tPATH")
+		for _, r := range roots {
+			commit, fetched, state := rootStatus(r, offline)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Spec, commit, fetched, state, r.dir())
+		}
+		return tw.Flush()
+	},
+}
+
+// selectRemoteRoots returns the remote roots of cfg named in specs, or all
+// of them when specs is empty.
+func selectRemoteRoots(cfg builderConfig, specs []string) ([]remoteRoot, error) {
+	roots := remoteRoots(cfg)
+	if len(specs) == 0 {
+		return roots, nil
+	}
+	var selected []remoteRoot
+	for _, spec := range specs {
+		found := false
+		for _, r := range roots {
+			if r.Spec == spec || r.URL == spec {
+				selected = append(selected, r)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not a git recipe root in the configuration", spec)
+		}
+	}
+	return selected, nil
+}
+
+// rootStatus describes the checkout of r. Unless offline, it asks the remote
+// for the commit of the ref to tell whether the checkout is behind.
+func rootStatus(r remoteRoot, offline bool) (commit, fetched, state string) {
+	if !r.cloned() {
+		return "-", "-", "not cloned"
+	}
+	dir := r.checkoutDir()
+	head, err := gitOutput(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return "-", "-", err.Error()
+	}
+	commit, fetched = shortCommit(head), "-"
+	if st, err := os.Stat(filepath.Join(dir, ".git", "FETCH_HEAD")); err == nil {
+		fetched = st.ModTime().Format(time.DateTime)
+	}
+	var states []string
+	if dirty, err := gitOutput(dir, "status", "--porcelain"); err == nil && dirty != "" {
+		states = append(states, "modified")
+	}
+	if !offline {
+		out, err := gitOutput(dir, "ls-remote", "origin", r.fetchRef(), r.fetchRef()+"^{}")
+		switch {
+		case err != nil:
+			states = append(states, "remote unreachable")
+		case out == "":
+			// A commit ref is not advertised, and cannot move.
+		case !remoteHasCommit(out, head):
+			states = append(states, "behind")
+		}
+	}
+	if len(states) == 0 {
+		states = append(states, "up to date")
+	}
+	return commit, fetched, strings.Join(states, ", ")
+}
+
+// remoteHasCommit reports whether the ls-remote output lists commit.
+func remoteHasCommit(lsRemote, commit string) bool {
+	for _, line := range strings.Split(lsRemote, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == commit {
+			return true
+		}
+	}
+	return false
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+func init() {
+	reposUpdateCmd.Flags().Bool("force", false, "Discard local changes in the checkouts")
+	reposStatusCmd.Flags().Bool("offline", false, "Do not ask the remotes whether the checkouts are behind")
+	reposCmd.AddCommand(&reposUpdateCmd, &reposStatusCmd)
+	rootCmd.AddCommand(&reposCmd)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema for build.yaml, for editor validation and completion",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outPath, _ := cmd.Flags().GetString("output")
+
+		out, err := json.MarshalIndent(recipe.JSONSchema(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding schema: %w", err)
+		}
+		out = append(out, '\n')
+		if outPath == "" {
+			_, err := os.Stdout.Write(out)
+			return err
+		}
+		if err := os.WriteFile(outPath, out, 0o644); err != nil {
+			return fmt.Errorf("writing schema: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	schemaCmd.Flags().String("output", "", "Write the schema to this file instead of stdout")
+	rootCmd.AddCommand(&schemaCmd)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"github.com/spf13/cobra"
+)
+
+// searchResult is a recipe `builder search` found, with what matched.
+type searchResult struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version"`
+	Path    string   `json:"path"`
+	Matches []string `json:"matches,omitempty"`
+}
+
+// searchMatches returns what of the recipe matches query, case
+// insensitively: its name, categories, readme lines, deployed binaries and
+// templates.
+func searchMatches(d *recipeDetails, query string) []string {
+	q := strings.ToLower(query)
+	has := func(s string) bool { return strings.Contains(strings.ToLower(s), q) }
+	var matches []string
+	if has(d.Name) {
+		matches = append(matches, "name")
+	}
+	for _, c := range d.Categories {
+		if has(string(c)) {
+			matches = append(matches, "category "+string(c))
+		}
+	}
+	for _, bin := range d.Deploy.Bins {
+		if has(bin) {
+			matches = append(matches, "binary "+bin)
+		}
+	}
+	for _, t := range d.Templates {
+		if has(t) {
+			matches = append(matches, "template "+t)
+		}
+	}
+	for _, line := range strings.Split(d.Readme, "\n") {
+		if has(line) {
+			matches = append(matches, "readme: "+shortenLabel(strings.TrimSpace(line), 60))
+			break
+		}
+	}
+	return matches
+}
+
+var searchCmd = cobra.Command{
+	Use:   "search [query]",
+	Short: "Find recipes by name, category, readme text, deployed binary or template",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := listFormat(cmd)
+		if err != nil {
+			return err
+		}
+		category, _ := cmd.Flags().GetString("category")
+		arch, _ := cmd.Flags().GetString("arch")
+		query := ""
+		if len(args) == 1 {
+			query = args[0]
+		}
+		if query == "" && category == "" && arch == "" {
+			return fmt.Errorf("give a query, --category or --arch")
+		}
+
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		dirs, err := listRecipes(cfg)
+		if err != nil {
+			return err
+		}
+		builds := latestBuilds()
+		results := []searchResult{}
+		for _, dir := range dirs {
+			build, listing := listRecipe(dir, builds)
+			if build == nil {
+				fmt.Fprintf(os.Stderr, "warning: %s: %s\n", filepath.Base(dir), listing.Error)
+				continue
+			}
+			if category != "" && !slices.Contains(build.Categories, recipe.Category(category)) {
+				continue
+			}
+			if arch != "" && !slices.Contains(build.Architectures, recipe.CPUArchitecture(arch)) {
+				continue
+			}
+			result := searchResult{Name: build.Name, Version: build.Version, Path: dir}
+			if query != "" {
+				details, err := describeRecipe(cfg, dir, builds)
+				if err != nil {
+					// Match what loading the recipe gave.
+					fmt.Fprintf(os.Stderr, "warning: %s: %v\n", build.Name, err)
+					details = &recipeDetails{recipeListing: listing}
+				}
+				if result.Matches = searchMatches(details, query); len(result.Matches) == 0 {
+					continue
+				}
+			}
+			results = append(results, result)
+		}
+
+		out := cmd.OutOrStdout()
+		if format == "json" {
+			return printJSON(out, results)
+		}
+		if len(results) == 0 {
+			fmt.Fprintln(out, "No recipes found.")
+			return nil
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tVERSION\tMATCHES")
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, r.Version, strings.Join(r.Matches, "; "))
+		}
+		return tw.Flush()
+	},
+}
+
+func init() {
+	searchCmd.Flags().String("format", "table", "Output format (table, json)")
+	searchCmd.Flags().String("category", "", "Only recipes in this category, e.g. functional_imaging")
+	searchCmd.Flags().String("arch", "", "Only recipes built for this architecture (x86_64, aarch64)")
+	rootCmd.AddCommand(&searchCmd)
+}
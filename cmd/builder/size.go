@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/neurodesk/builder/pkg/imagesize"
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/manifest"
+	"github.com/spf13/cobra"
+)
+
+// sizeCacheMin is the size from which a cache directory left in the image
+// is reported.
+const sizeCacheMin = 1 << 20
+
+// sizeReport is the output of builder size --json.
+type sizeReport struct {
+	Image    string              `json:"image"`
+	Report   imagesize.Report    `json:"report"`
+	Findings []imagesize.Finding `json:"findings"`
+	Compare  *sizeComparison     `json:"compare,omitempty"`
+}
+
+type sizeComparison struct {
+	Image   string             `json:"image"`
+	Total   int64              `json:"total"`
+	Delta   int64              `json:"delta"`
+	Changes []imagesize.Change `json:"changes"`
+}
+
+var sizeCmd = cobra.Command{
+	Use:   "size [recipe]",
+	Short: "Attribute the size of a recipe's built image to its directives, point out avoidable size and compare with the previous version",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if verbose {
+			os.Setenv("BUILDER_VERBOSE", "1")
+		}
+		options, err := optionFlags(cmd)
+		if err != nil {
+			return err
+		}
+		image, _ := cmd.Flags().GetString("image")
+		compareRef, _ := cmd.Flags().GetString("compare")
+		maxGrowth, _ := cmd.Flags().GetFloat64("max-growth")
+		top, _ := cmd.Flags().GetInt("top")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		if _, err := exec.LookPath("docker"); err != nil {
+			return fmt.Errorf("docker CLI not found in PATH; builder size inspects images in the local Docker engine")
+		}
+		cfg, err := loadBuilderConfig()
+		if err != nil {
+			return err
+		}
+		stage, err := prepareStage(cfg, args[0], nil, options)
+		if err != nil {
+			return err
+		}
+		if image == "" {
+			image = stage.build.Name + ":" + stage.version
+		}
+
+		history, err := imageHistory(image)
+		if err != nil {
+			return err
+		}
+		steps, err := ir.FinalStageSteps(stage.irDef)
+		if err != nil {
+			return err
+		}
+		report, err := imagesize.Attribute(stage.irDef, history, steps)
+		if err != nil {
+			return fmt.Errorf("%s: %w", image, err)
+		}
+		out := sizeReport{Image: image, Report: report, Findings: imagesize.Offenders(stage.irDef, report)}
+		out.Findings = append(out.Findings, imagesize.CacheFindings(imageCacheSizes(image), sizeCacheMin)...)
+
+		if compareRef == "" {
+			prev, ok, err := (manifest.Store{Dir: manifest.DefaultDir}).PreviousVersion(stage.build.Name, stage.version)
+			if err != nil {
+				return fmt.Errorf("reading build history: %w", err)
+			}
+			// Only compare with the previous version while it is still local.
+			if ok && dockerImageID(prev.Tag) != "" {
+				compareRef = prev.Tag
+			}
+		}
+		if compareRef != "" {
+			old, err := imageHistory(compareRef)
+			if err != nil {
+				return err
+			}
+			c := &sizeComparison{Image: compareRef}
+			for _, l := range old {
+				c.Total += l.Size
+			}
+			c.Delta, c.Changes = imagesize.Compare(old, history)
+			out.Compare = c
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(out); err != nil {
+				return err
+			}
+		} else if err := printSizeReport(cmd, stage.irDef, out, top); err != nil {
+			return err
+		}
+
+		if c := out.Compare; c != nil && maxGrowth > 0 && c.Total > 0 {
+			if growth := float64(c.Delta) * 100 / float64(c.Total); growth > maxGrowth {
+				return fmt.Errorf("size regression: %s is %.1f%% larger than %s (limit %.1f%%)", image, growth, c.Image, maxGrowth)
+			}
+		}
+		return nil
+	},
+}
+
+func printSizeReport(cmd *cobra.Command, def *ir.Definition, out sizeReport, top int) error {
+	w := cmd.OutOrStdout()
+	r := out.Report
+	fmt.Fprintf(w, "%s: %s, of which %s from the base image\n", out.Image, formatBytes(r.Total), formatBytes(r.Base))
+
+	fmt.Fprintln(w, "\nLargest directives:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tSIZE\tSHARE\tDIRECTIVE")
+	for _, s := range r.Largest(top) {
+		if s.Size == 0 {
+			break
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%.1f%%\t%s\n", s.Step+1, formatBytes(s.Size), float64(s.Size)*100/float64(max(r.Total, 1)), s.Description)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(out.Findings) > 0 {
+		fmt.Fprintln(w, "\nPossible savings:")
+		for _, f := range out.Findings {
+			where := "image"
+			if f.Step >= 0 {
+				where = fmt.Sprintf("step %d, %s", f.Step+1, def.Directives[f.Step].Describe())
+			}
+			fmt.Fprintf(w, "  %s (%s, %s)\n", f.Message, where, formatBytes(f.Size))
+		}
+	}
+
+	if c := out.Compare; c != nil {
+		growth := 0.0
+		if c.Total > 0 {
+			growth = float64(c.Delta) * 100 / float64(c.Total)
+		}
+		fmt.Fprintf(w, "\nCompared with %s (%s): %s (%+.1f%%)\n", c.Image, formatBytes(c.Total), signedBytes(c.Delta), growth)
+		for i, ch := range c.Changes {
+			if i == top {
+				fmt.Fprintf(w, "  ... %d more layers changed\n", len(c.Changes)-top)
+				break
+			}
+			fmt.Fprintf(w, "  %10s  %s\n", signedBytes(ch.New-ch.Old), shortCreatedBy(ch.CreatedBy))
+		}
+	}
+	return nil
+}
+
+func signedBytes(n int64) string {
+	if n >= 0 {
+		return "+" + formatBytes(n)
+	}
+	return "-" + formatBytes(-n)
+}
+
+// shortCreatedBy trims the history command of a layer to one short line.
+func shortCreatedBy(s string) string {
+	s = strings.TrimSuffix(s, " # buildkit")
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > 100 {
+		s = s[:97] + "..."
+	}
+	return s
+}
+
+// imageHistory returns the history of the local image ref, oldest first.
+func imageHistory(ref string) ([]imagesize.Layer, error) {
+	out, err := exec.Command("docker", "image", "history", "--no-trunc", "--human=false", "--format", "{{json .}}", ref).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("reading history of %s: %s", ref, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("reading history of %s: %w", ref, err)
+	}
+	var layers []imagesize.Layer
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(nil, 16<<20)
+	for sc.Scan() {
+		var entry struct {
+			CreatedBy string
+			Size      string
+		}
+		if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("reading history of %s: %w", ref, err)
+		}
+		size, err := strconv.ParseInt(entry.Size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("reading history of %s: layer size %q: %w", ref, entry.Size, err)
+		}
+		layers = append(layers, imagesize.Layer{CreatedBy: entry.CreatedBy, Size: size})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	// docker lists the newest layer first.
+	for i, j := 0, len(layers)-1; i < j; i, j = i+1, j-1 {
+		layers[i], layers[j] = layers[j], layers[i]
+	}
+	return layers, nil
+}
+
+// imageCacheSizes measures imagesize.CacheDirs in a container of ref. An
+// image without a shell or du yields no sizes.
+func imageCacheSizes(ref string) map[string]int64 {
+	script := `for d in "$@"; do [ -d "$d" ] && du -sk "$d"; done; true`
+	args := append([]string{"run", "--rm", "--network", "none", "--entrypoint", "/bin/sh", ref, "-c", script, "sh"}, imagesize.CacheDirs...)
+	out, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARN: measuring cache directories in %s: %v\n", ref, err)
+		return nil
+	}
+	sizes := map[string]int64{}
+	for _, line := range strings.Split(string(out), "\n") {
+		kb, dir, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(kb), 10, 64); err == nil {
+			sizes[strings.TrimSpace(dir)] = n << 10
+		}
+	}
+	return sizes
+}
+
+func init() {
+	sizeCmd.Flags().StringArray("option", []string{}, "Set a recipe option as KEY=VALUE (repeatable)")
+	sizeCmd.Flags().String("image", "", "Image to inspect (default <name>:<version> of the recipe)")
+	sizeCmd.Flags().String("compare", "", "Image to compare with (default the last successful build of another version, if it is still local)")
+	sizeCmd.Flags().Float64("max-growth", 10, "Fail when the image is more than this many percent larger than the one compared with (0 disables)")
+	sizeCmd.Flags().Int("top", 10, "Number of directives and changed layers to list")
+	sizeCmd.Flags().Bool("json", false, "Print the report as JSON")
+	rootCmd.AddCommand(&sizeCmd)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/flatten"
+)
+
+var buildSquash bool
+
+// imageTags returns tag and, when pushing, the push ref, which docker build
+// tags the image with as well.
+func imageTags(tag string) []string {
+	if buildPushRef != "" {
+		return []string{tag, buildPushRef}
+	}
+	return []string{tag}
+}
+
+// squashDockerImage replaces the image tags[0], and its other tags, with a
+// single-layer image of the same filesystem and config: the container
+// filesystem is exported, rewritten as one layer in workDir and loaded
+// back into Docker.
+func squashDockerImage(ctx context.Context, tags []string, workDir string) error {
+	tag := tags[0]
+	out, err := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{json .}}", tag).Output()
+	if err != nil {
+		return fmt.Errorf("inspecting %s: %w", tag, err)
+	}
+	var inspect struct {
+		Config       json.RawMessage
+		Architecture string
+		Os           string
+		Variant      string
+	}
+	if err := json.Unmarshal(out, &inspect); err != nil {
+		return fmt.Errorf("inspecting %s: %w", tag, err)
+	}
+
+	// The entrypoint only has to be set for images without a command; the
+	// container is never started.
+	out, err = exec.CommandContext(ctx, "docker", "create", "--entrypoint", "/bin/true", tag).Output()
+	if err != nil {
+		return fmt.Errorf("creating a container of %s: %w", tag, err)
+	}
+	id := strings.TrimSpace(string(out))
+	defer exec.Command("docker", "rm", id).Run()
+
+	layer, err := os.CreateTemp(workDir, "squash-*.tar")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(layer.Name())
+	export := commandContext(ctx, "docker", "export", id)
+	export.Stderr = os.Stderr
+	stdout, err := export.StdoutPipe()
+	if err != nil {
+		layer.Close()
+		return err
+	}
+	if err := export.Start(); err != nil {
+		layer.Close()
+		return fmt.Errorf("exporting %s: %w", tag, err)
+	}
+	diffID, err := flatten.Layer(layer, stdout)
+	// Drain the export if the layer failed, so it can exit.
+	io.Copy(io.Discard, stdout)
+	if werr := export.Wait(); werr != nil && err == nil {
+		err = fmt.Errorf("exporting %s: %w", tag, werr)
+	}
+	if cerr := layer.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	img := flatten.Image{
+		Config:       inspect.Config,
+		Architecture: inspect.Architecture,
+		OS:           inspect.Os,
+		Variant:      inspect.Variant,
+		Tags:         tags,
+		CreatedBy:    "builder build --squash",
+		Created:      time.Now(),
+	}
+	load := commandContext(ctx, "docker", "load")
+	load.Stdout = os.Stdout
+	load.Stderr = os.Stderr
+	stdin, err := load.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := load.Start(); err != nil {
+		return fmt.Errorf("loading the squashed image: %w", err)
+	}
+	err = flatten.WriteArchive(stdin, img, layer.Name(), diffID)
+	stdin.Close()
+	if werr := load.Wait(); werr != nil {
+		return fmt.Errorf("loading the squashed image: %w", werr)
+	}
+	if err != nil {
+		return fmt.Errorf("writing the squashed image: %w", err)
+	}
+	fmt.Printf("Squashed %s into a single layer\n", tag)
+	return nil
+}
@@ -88,7 +88,7 @@ var templateTestsCmd = cobra.Command{
 			}
 
 			if doBuild {
-				if err := runDockerBuild(stage); err != nil {
+				if err := runDockerBuild(stage, os.Stdout); err != nil {
 					return fmt.Errorf("%s: %w", spec.Identifier(), err)
 				}
 			}
@@ -111,7 +111,7 @@ var templateTestsCmd = cobra.Command{
 					}
 				}
 
-				if err := runTemplateTests(stage, tests); err != nil {
+				if err := runTemplateTests(stage, tests, os.Stdout); err != nil {
 					return fmt.Errorf("%s: %w", spec.Identifier(), err)
 				}
 			}
@@ -470,7 +470,7 @@ func stageBuildFileForTemplate(cfg builderConfig, build *recipe.BuildFile) (*doc
 		plan = &recipe.StagingPlan{}
 	}
 
-	if err := stageIntoBuildContext(cfg, "", dockerfile, buildDir, plan); err != nil {
+	if err := stageIntoBuildContext(cfg, "", irDef, dockerfile, buildDir, plan); err != nil {
 		return nil, err
 	}
 
@@ -496,7 +496,7 @@ func ensureTemplateLogDir(stage *dockerStageResult) (string, error) {
 	return logDir, nil
 }
 
-func runDockerBuild(stage *dockerStageResult) error {
+func runDockerBuild(stage *dockerStageResult, out io.Writer) error {
 	logDir, err := ensureTemplateLogDir(stage)
 	if err != nil {
 		return err
@@ -509,12 +509,12 @@ func runDockerBuild(stage *dockerStageResult) error {
 		stage.BuildDir,
 	}
 
-	fmt.Printf("Running: DOCKER_BUILDKIT=1 docker %s\n", strings.Join(dockerArgs, " "))
+	fmt.Fprintf(out, "Running: DOCKER_BUILDKIT=1 docker %s\n", strings.Join(dockerArgs, " "))
 
 	cmd := exec.Command("docker", dockerArgs...)
 	cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
 	var buf bytes.Buffer
-	multi := io.MultiWriter(os.Stdout, &buf)
+	multi := io.MultiWriter(out, &buf)
 	cmd.Stdout = multi
 	cmd.Stderr = multi
 
@@ -531,17 +531,17 @@ func runDockerBuild(stage *dockerStageResult) error {
 	if writeErr := os.WriteFile(logPath, append([]byte(logHeader), buf.Bytes()...), 0o644); writeErr != nil {
 		return fmt.Errorf("writing build log: %w", writeErr)
 	}
-	fmt.Printf("Build log written to %s\n", logPath)
+	fmt.Fprintf(out, "Build log written to %s\n", logPath)
 
 	if runErr != nil {
 		return fmt.Errorf("docker build failed: %w", runErr)
 	}
 
-	fmt.Printf("Built image %s\n", stage.Tag)
+	fmt.Fprintf(out, "Built image %s\n", stage.Tag)
 	return nil
 }
 
-func runTemplateTests(stage *dockerStageResult, tests []templateTestCase) error {
+func runTemplateTests(stage *dockerStageResult, tests []templateTestCase, out io.Writer) error {
 	logDir, err := ensureTemplateLogDir(stage)
 	if err != nil {
 		return err
@@ -549,11 +549,11 @@ func runTemplateTests(stage *dockerStageResult, tests []templateTestCase) error
 
 	tag := stage.Tag
 	for _, t := range tests {
-		fmt.Printf("Running test %s for %s\n", t.Name, tag)
+		fmt.Fprintf(out, "Running test %s for %s\n", t.Name, tag)
 		args := []string{"run", "--rm", tag, "bash", "-lc", t.Command}
 		cmd := exec.Command("docker", args...)
 		var buf bytes.Buffer
-		multi := io.MultiWriter(os.Stdout, &buf)
+		multi := io.MultiWriter(out, &buf)
 		cmd.Stdout = multi
 		cmd.Stderr = multi
 		err := cmd.Run()
@@ -569,7 +569,7 @@ func runTemplateTests(stage *dockerStageResult, tests []templateTestCase) error
 		if writeErr := os.WriteFile(logPath, append([]byte(logHeader), buf.Bytes()...), 0o644); writeErr != nil {
 			return fmt.Errorf("writing log %s: %w", logPath, writeErr)
 		}
-		fmt.Printf("Log written to %s\n", logPath)
+		fmt.Fprintf(out, "Log written to %s\n", logPath)
 		if err != nil {
 			return fmt.Errorf("test %s failed: %w", t.Name, err)
 		}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	v "github.com/neurodesk/builder/pkg/validator"
+)
+
+// testAllJob is one unit of work of test-all: generating a recipe (and,
+// with --run, building and testing it), or building and testing a template
+// test case. It writes its progress to out and reports its outcome.
+type testAllJob struct {
+	kind string
+	name string
+	run  func(out io.Writer) testAllOutcome
+}
+
+// testAllOutcome is what a testAllJob reports: a one-line summary when it
+// passed, or its errors.
+type testAllOutcome struct {
+	summary string
+	errs    []string
+	// skipped is set when --run skipped the container tests because they
+	// passed before with the same inputs.
+	skipped bool
+	// image is the image the container tests ran in.
+	image string
+}
+
+// testAllResult is the outcome of a testAllJob, as listed in the summary.
+type testAllResult struct {
+	Kind     string   `json:"kind"`
+	Name     string   `json:"name"`
+	Summary  string   `json:"summary,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+	Skipped  bool     `json:"skipped,omitempty"`
+	Image    string   `json:"image,omitempty"`
+	Duration float64  `json:"duration_seconds"`
+}
+
+func (r testAllResult) Passed() bool { return len(r.Errors) == 0 }
+
+// runTestAllJobs runs jobs on a pool of workers and returns their results
+// in the order of jobs. With a single worker, output is streamed as it is
+// written; otherwise each job's output is printed in one piece when it
+// finishes, so parallel jobs do not interleave.
+func runTestAllJobs(jobs []testAllJob, workers int) []testAllResult {
+	if workers < 1 {
+		workers = 1
+	}
+	results := make([]testAllResult, len(jobs))
+	indexes := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		outputMu sync.Mutex
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				job := jobs[i]
+				var out io.Writer = os.Stdout
+				var buf bytes.Buffer
+				if workers > 1 {
+					out = &buf
+				}
+				fmt.Fprintf(out, "Testing %s: %s\n", job.kind, job.name)
+				start := time.Now()
+				o := job.run(out)
+				if len(o.errs) > 0 {
+					for _, msg := range o.errs {
+						fmt.Fprintf(out, "\033[31m  %s\033[0m\n", msg)
+					}
+				} else {
+					fmt.Fprintf(out, "\033[32m  %s\033[0m\n", o.summary)
+				}
+				if workers > 1 {
+					outputMu.Lock()
+					os.Stdout.Write(buf.Bytes())
+					outputMu.Unlock()
+				}
+				results[i] = testAllResult{
+					Kind:     job.kind,
+					Name:     job.name,
+					Summary:  o.summary,
+					Errors:   o.errs,
+					Skipped:  o.skipped,
+					Image:    o.image,
+					Duration: time.Since(start).Seconds(),
+				}
+			}
+		}()
+	}
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// recipeTestJob generates the Dockerfile of the recipe in dir into
+// outputDir and validates it, then, with run, builds and tests the image.
+func recipeTestJob(cfg builderConfig, dir, outputDir string, run *testAllRun) testAllJob {
+	return testAllJob{
+		kind: "recipe",
+		name: dir,
+		run: func(out io.Writer) testAllOutcome {
+			res, err := generateDockerfileForRecipe(cfg, dir, outputDir)
+			if err != nil {
+				return testAllOutcome{errs: errorMessages(err)}
+			}
+			if len(res.Errors) > 0 {
+				return testAllOutcome{errs: res.Errors}
+			}
+			if run != nil {
+				return run.recipe(cfg, dir, out)
+			}
+			return testAllOutcome{summary: "Successfully generated Dockerfile: " + res.OutputPath}
+		},
+	}
+}
+
+// templateTestJob builds the image of a template test case and runs all
+// of its tests in it.
+func templateTestJob(cfg builderConfig, spec templateTestSpec) testAllJob {
+	return testAllJob{
+		kind: "template",
+		name: spec.Identifier(),
+		run: func(out io.Writer) testAllOutcome {
+			buildFile, err := spec.ToBuildFile()
+			if err != nil {
+				return testAllOutcome{errs: []string{err.Error()}}
+			}
+			stage, err := stageBuildFileForTemplate(cfg, buildFile)
+			if err != nil {
+				return testAllOutcome{errs: []string{err.Error()}}
+			}
+			if err := runDockerBuild(stage, out); err != nil {
+				return testAllOutcome{errs: []string{err.Error()}}
+			}
+			if err := runTemplateTests(stage, spec.Tests, out); err != nil {
+				return testAllOutcome{errs: []string{err.Error()}}
+			}
+			return testAllOutcome{summary: fmt.Sprintf("Built %s and passed %d test(s)", stage.Tag, len(spec.Tests)), image: stage.Tag}
+		},
+	}
+}
+
+// testAll generates every recipe and, when templates are given, builds and
+// tests every template test case, then prints a report covering both. With
+// run, recipes that generate are also built and tested, and a summary is
+// written to summaryPath.
+func testAll(cfg builderConfig, recipes []string, templates []templateTestSpec, workers int, run *testAllRun, summaryPath string) error {
+	outputDir := filepath.Join("local", "docker")
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	if run != nil {
+		defer run.testers.cleanup()
+	}
+
+	var jobs []testAllJob
+	for _, r := range recipes {
+		jobs = append(jobs, recipeTestJob(cfg, r, outputDir, run))
+	}
+	for _, spec := range templates {
+		jobs = append(jobs, templateTestJob(cfg, spec))
+	}
+
+	started := time.Now()
+	results := runTestAllJobs(jobs, workers)
+	failed := printTestAllReport(os.Stdout, results, len(templates) > 0)
+	if summaryPath != "" {
+		if err := writeTestAllSummary(summaryPath, started, results); err != nil {
+			return err
+		}
+		fmt.Printf("Summary written to %s\n", summaryPath)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tests failed", failed, len(results))
+	}
+	return nil
+}
+
+// errorMessages lists the errors err is made of, such as every validation
+// error of a recipe, so each is reported on its own line.
+func errorMessages(err error) []string {
+	var msgs []string
+	for _, e := range v.List(err) {
+		msgs = append(msgs, e.Error())
+	}
+	return msgs
+}
+
+// testAllRun is the execution tier of test-all --run: it builds the image
+// of each recipe and runs the deployment tester and script tests in it.
+type testAllRun struct {
+	// slots limits how many recipes build and test at once.
+	slots   chan struct{}
+	state   *testAllState
+	testers *testerBinaries
+}
+
+func newTestAllRun(jobs int, statePath string) (*testAllRun, error) {
+	state, err := loadTestAllState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	return &testAllRun{
+		slots:   make(chan struct{}, max(jobs, 1)),
+		state:   state,
+		testers: &testerBinaries{paths: map[string]string{}},
+	}, nil
+}
+
+// recipe builds and tests the recipe in dir, unless its tests passed
+// before with the same inputs.
+func (r *testAllRun) recipe(cfg builderConfig, dir string, out io.Writer) testAllOutcome {
+	fail := func(err error) testAllOutcome { return testAllOutcome{errs: []string{err.Error()}} }
+	stage, err := prepareStage(cfg, dir, nil, nil)
+	if err != nil {
+		return fail(err)
+	}
+	digest, err := buildInputDigest(stage, nil)
+	if err != nil {
+		return fail(fmt.Errorf("computing input digest: %w", err))
+	}
+	tag := stage.build.Name + ":" + stage.version
+	if r.state.passed(dir, digest) {
+		return testAllOutcome{summary: fmt.Sprintf("Skipped: tests passed before with inputs %s", shortHash(strings.TrimPrefix(digest, "sha256:"))), skipped: true, image: tag}
+	}
+	goarch, err := goArchFromRecipe(stage.build)
+	if err != nil {
+		return fail(err)
+	}
+
+	r.slots <- struct{}{}
+	defer func() { <-r.slots }()
+	res, err := prepareDockerStage(stage)
+	if err != nil {
+		return fail(err)
+	}
+	if upToDate(stage, digest, "") != "" && dockerImageID(tag) != "" {
+		fmt.Fprintf(out, "%s is up-to-date\n", tag)
+	} else if err := runRecipeBuild(stage, res, digest, out); err != nil {
+		return fail(err)
+	}
+	testerPath, err := r.testers.get(goarch)
+	if err != nil {
+		return fail(err)
+	}
+	rt := dockerRuntime{tag: tag, platform: "linux/" + goarch}
+	if err := testImage(out, cfg, stage.recipePath, stage.build, nil, rt, testerPath); err != nil {
+		return testAllOutcome{errs: []string{err.Error()}, image: tag}
+	}
+	if err := r.state.markPassed(dir, digest); err != nil {
+		fmt.Fprintf(out, "WARN: saving test-all state: %v\n", err)
+	}
+	return testAllOutcome{summary: "Built and tested " + tag, image: tag}
+}
+
+// runRecipeBuild builds the staged recipe with docker build, writing the
+// output to out and to the recipe's build log, and records the build in the
+// build history. The build is cancelled after --timeout.
+func runRecipeBuild(stage *genericStageResult, res *dockerStageResult, inputDigest string, out io.Writer) error {
+	out, closeLog, err := teeBuildLog(filepath.Base(filepath.Clean(stage.recipePath)), out)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	args := dockerBuildArgs(res.Tag, res.DockerfilePath, res.CacheDir, res.BuildDir, nil, nil, inputDigest)
+	fmt.Fprintf(out, "Running: DOCKER_BUILDKIT=1 docker %s\n", strings.Join(args, " "))
+	ctx, cancel := withTimeout(buildTimeout)
+	defer cancel()
+	cmd := commandContext(ctx, "docker", args...)
+	cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	stage.inputDigest = inputDigest
+	started := startBuild(res.Name, "docker")
+	if err := cmd.Run(); err != nil {
+		err = timeoutError(ctx, "build", buildTimeout, fmt.Errorf("docker build failed: %w", err))
+		recordBuild(stage, "docker", res.Tag, started, err, "", nil)
+		return err
+	}
+	recordBuild(stage, "docker", res.Tag, started, nil, dockerImageID(res.Tag), nil)
+	return nil
+}
+
+// testerBinaries builds the tester once per architecture.
+type testerBinaries struct {
+	mu       sync.Mutex
+	paths    map[string]string
+	cleanups []func()
+}
+
+func (t *testerBinaries) get(goarch string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if path, ok := t.paths[goarch]; ok {
+		return path, nil
+	}
+	path, cleanup, err := buildTesterBinary(goarch)
+	if err != nil {
+		return "", err
+	}
+	t.paths[goarch] = path
+	t.cleanups = append(t.cleanups, cleanup)
+	return path, nil
+}
+
+func (t *testerBinaries) cleanup() {
+	for _, c := range t.cleanups {
+		c()
+	}
+}
+
+// testAllState is the resumable state of test-all --run: the input digest
+// each recipe, by directory, last passed its tests with. It is saved after
+// every pass, so an interrupted run resumes where it stopped.
+type testAllState struct {
+	mu     sync.Mutex
+	path   string
+	Passed map[string]string `json:"passed"`
+}
+
+func loadTestAllState(path string) (*testAllState, error) {
+	s := &testAllState{path: path, Passed: map[string]string{}}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if s.Passed == nil {
+		s.Passed = map[string]string{}
+	}
+	return s, nil
+}
+
+func (s *testAllState) passed(dir, digest string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Passed[dir] == digest
+}
+
+func (s *testAllState) markPassed(dir, digest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Passed[dir] = digest
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, append(b, '\n'), 0o644)
+}
+
+// testAllSummary is the summary JSON of test-all, for dashboards.
+type testAllSummary struct {
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Passed   int             `json:"passed"`
+	Failed   int             `json:"failed"`
+	Skipped  int             `json:"skipped"`
+	Results  []testAllResult `json:"results"`
+}
+
+func writeTestAllSummary(path string, started time.Time, results []testAllResult) error {
+	summary := testAllSummary{Started: started.UTC(), Finished: time.Now().UTC(), Results: results}
+	for _, r := range results {
+		switch {
+		case !r.Passed():
+			summary.Failed++
+		case r.Skipped:
+			summary.Skipped++
+		default:
+			summary.Passed++
+		}
+	}
+	b, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("writing summary: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing summary: %w", err)
+	}
+	return nil
+}
+
+// printTestAllReport prints the number of recipes and templates that passed
+// and failed, followed by the failures, and returns the number of failures.
+func printTestAllReport(w io.Writer, results []testAllResult, withTemplates bool) int {
+	type tally struct{ passed, failed int }
+	counts := map[string]*tally{"recipe": {}, "template": {}}
+	var failures []testAllResult
+	skipped := 0
+	for _, r := range results {
+		if r.Skipped {
+			skipped++
+		}
+		if r.Passed() {
+			counts[r.Kind].passed++
+		} else {
+			counts[r.Kind].failed++
+			failures = append(failures, r)
+		}
+	}
+
+	recipes := counts["recipe"]
+	fmt.Fprintf(w, "Tested %d recipes: %d succeeded, %d failed\n", recipes.passed+recipes.failed, recipes.passed, recipes.failed)
+	if withTemplates {
+		templates := counts["template"]
+		fmt.Fprintf(w, "Tested %d templates: %d succeeded, %d failed\n", templates.passed+templates.failed, templates.passed, templates.failed)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(w, "Skipped the container tests of %d recipes, which passed before with the same inputs\n", skipped)
+	}
+	for _, r := range failures {
+		for _, msg := range r.Errors {
+			fmt.Fprintf(w, "FAILED %s %s: %s\n", r.Kind, r.Name, msg)
+		}
+	}
+	return len(failures)
+}
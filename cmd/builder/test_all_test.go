@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTestAllStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "test-all.json")
+	s, err := loadTestAllState(path)
+	if err != nil {
+		t.Fatalf("loading a missing state: %v", err)
+	}
+	if s.passed("recipes/a", "sha256:1") {
+		t.Fatalf("a new state has passed recipes/a")
+	}
+	if err := s.markPassed("recipes/a", "sha256:1"); err != nil {
+		t.Fatalf("markPassed: %v", err)
+	}
+	if err := s.markPassed("recipes/b", "sha256:2"); err != nil {
+		t.Fatalf("markPassed: %v", err)
+	}
+
+	loaded, err := loadTestAllState(path)
+	if err != nil {
+		t.Fatalf("loading the saved state: %v", err)
+	}
+	for _, tc := range []struct {
+		dir, digest string
+		want        bool
+	}{
+		{"recipes/a", "sha256:1", true},
+		{"recipes/b", "sha256:2", true},
+		{"recipes/a", "sha256:2", false},
+		{"recipes/c", "sha256:1", false},
+	} {
+		if got := loaded.passed(tc.dir, tc.digest); got != tc.want {
+			t.Fatalf("passed(%s, %s) = %t, want %t", tc.dir, tc.digest, got, tc.want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadTestAllState(path); err == nil {
+		t.Fatalf("loading a corrupt state succeeded")
+	}
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	empty, err := loadTestAllState(path)
+	if err != nil {
+		t.Fatalf("loading a state without passes: %v", err)
+	}
+	if err := empty.markPassed("recipes/a", "sha256:1"); err != nil {
+		t.Fatalf("markPassed on a state without passes: %v", err)
+	}
+}
+
+func TestTestAllRunSkipsPassedRecipes(t *testing.T) {
+	root, cfg := affectedTestTree(t)
+	useTestCaches(t)
+	t.Chdir(root)
+	// Any build fails, so only a skipped recipe passes.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := filepath.Join(root, "recipes", "b")
+	stage, err := prepareStage(cfg, dir, nil, nil)
+	if err != nil {
+		t.Fatalf("prepareStage: %v", err)
+	}
+	digest, err := buildInputDigest(stage, nil)
+	if err != nil {
+		t.Fatalf("buildInputDigest: %v", err)
+	}
+	statePath := filepath.Join(root, "local", "test-all-state.json")
+	state, err := loadTestAllState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.markPassed(dir, digest); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new run resumes from the saved state.
+	run, err := newTestAllRun(1, statePath)
+	if err != nil {
+		t.Fatalf("newTestAllRun: %v", err)
+	}
+	var out bytes.Buffer
+	o := run.recipe(cfg, dir, &out)
+	if !o.skipped || len(o.errs) > 0 || o.image != "b:1.0.0" {
+		t.Fatalf("recipe with passed inputs = %+v, want skipped", o)
+	}
+
+	// Changing the recipe changes its inputs, so it is built again.
+	writeTestFile(t, root, "recipes/b/build.yaml", sprintfRecipe("b", "    - run:\n        - echo changed\n"))
+	o = run.recipe(cfg, dir, &out)
+	if o.skipped || len(o.errs) != 1 || !strings.Contains(o.errs[0], "docker build failed") {
+		t.Fatalf("recipe with changed inputs = %+v, want a failed build", o)
+	}
+}
+
+func TestTestAllSummaryAndReport(t *testing.T) {
+	results := []testAllResult{
+		{Kind: "recipe", Name: "recipes/a", Summary: "Built and tested a:1"},
+		{Kind: "recipe", Name: "recipes/b", Summary: "Skipped: tests passed before", Skipped: true},
+		{Kind: "recipe", Name: "recipes/c", Errors: []string{"build failed", "tester failed"}},
+		{Kind: "template", Name: "tmpl/x", Summary: "Built x"},
+		{Kind: "template", Name: "tmpl/y", Errors: []string{"test failed"}},
+	}
+
+	path := filepath.Join(t.TempDir(), "out", "summary.json")
+	started := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	if err := writeTestAllSummary(path, started, results); err != nil {
+		t.Fatalf("writeTestAllSummary: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary testAllSummary
+	if err := json.Unmarshal(b, &summary); err != nil {
+		t.Fatalf("parsing the summary: %v", err)
+	}
+	if summary.Passed != 2 || summary.Skipped != 1 || summary.Failed != 2 {
+		t.Fatalf("summary counts passed=%d skipped=%d failed=%d, want 2, 1, 2", summary.Passed, summary.Skipped, summary.Failed)
+	}
+	if !summary.Started.Equal(started) || summary.Finished.Before(started) || len(summary.Results) != len(results) {
+		t.Fatalf("summary = %+v", summary)
+	}
+
+	var w bytes.Buffer
+	if failed := printTestAllReport(&w, results, true); failed != 2 {
+		t.Fatalf("printTestAllReport = %d failures, want 2", failed)
+	}
+	want := strings.Join([]string{
+		"Tested 3 recipes: 2 succeeded, 1 failed",
+		"Tested 2 templates: 1 succeeded, 1 failed",
+		"Skipped the container tests of 1 recipes, which passed before with the same inputs",
+		"FAILED recipe recipes/c: build failed",
+		"FAILED recipe recipes/c: tester failed",
+		"FAILED template tmpl/y: test failed",
+	}, "\n") + "\n"
+	if w.String() != want {
+		t.Fatalf("printTestAllReport wrote\n%s\nwant\n%s", w.String(), want)
+	}
+
+	w.Reset()
+	printTestAllReport(&w, results[:3], false)
+	if strings.Contains(w.String(), "templates") {
+		t.Fatalf("printTestAllReport without templates wrote\n%s", w.String())
+	}
+}
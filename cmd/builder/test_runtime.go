@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+)
+
+// testRuntime runs the containers of builder test: the deployment tester
+// and each script test get a fresh container of the image under test.
+type testRuntime interface {
+	// run runs argv in a fresh container, writing its output to w. args are
+	// docker run flags for the container: -v mounts, -e variables and
+	// --gpus. name prefixes the container name, where the runtime has one.
+	run(ctx context.Context, name string, args, argv []string, w io.Writer) error
+	// entrypoint returns the entrypoint of the image as a JSON array, or ""
+	// when containers of the runtime do not run it.
+	entrypoint() string
+}
+
+// dockerRuntime runs containers of the local Docker image tag.
+type dockerRuntime struct {
+	tag      string
+	platform string
+}
+
+func (d dockerRuntime) run(ctx context.Context, name string, args, argv []string, w io.Writer) error {
+	runArgs := []string{"--rm"}
+	if d.platform != "" {
+		runArgs = append(runArgs, "--platform", d.platform)
+	}
+	runArgs = append(runArgs, args...)
+	runArgs = append(runArgs, "--entrypoint", argv[0], d.tag)
+	runArgs = append(runArgs, argv[1:]...)
+	return dockerRun(ctx, containerName(name), runArgs, func(cmd *exec.Cmd) {
+		cmd.Stdout = w
+		cmd.Stderr = w
+	})
+}
+
+func (d dockerRuntime) entrypoint() string {
+	out, err := exec.Command("docker", "image", "inspect", "--format", "{{json .Config.Entrypoint}}", d.tag).Output()
+	if err != nil {
+		return ""
+	}
+	entrypoint := strings.TrimSpace(string(out))
+	if entrypoint == "null" || entrypoint == "[]" {
+		return ""
+	}
+	return entrypoint
+}
+
+// apptainerRuntime runs containers of the SIF image sif with apptainer exec
+// (or singularity exec), the way Neurodesk runs its containers: with the
+// host environment and $HOME, a read-only image, and the Neurodesk mount
+// points that exist on the host bound at the same path.
+type apptainerRuntime struct {
+	tool string
+	sif  string
+}
+
+func (a apptainerRuntime) run(ctx context.Context, name string, args, argv []string, w io.Writer) error {
+	flags, err := apptainerFlags(args)
+	if err != nil {
+		return err
+	}
+	execArgs := []string{"--silent", "exec"}
+	for _, dir := range neurodeskBinds() {
+		execArgs = append(execArgs, "--bind", dir)
+	}
+	execArgs = append(execArgs, flags...)
+	execArgs = append(execArgs, a.sif)
+	execArgs = append(execArgs, argv...)
+	cmd := commandContext(ctx, a.tool, execArgs...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
+// entrypoint is empty: apptainer exec, which Neurodesk's wrappers use, does
+// not run the runscript.
+func (a apptainerRuntime) entrypoint() string { return "" }
+
+// apptainerFlags translates the docker run flags of a test container into
+// apptainer exec flags.
+func apptainerFlags(args []string) ([]string, error) {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		if i+1 == len(args) {
+			return nil, fmt.Errorf("missing value of %s", args[i])
+		}
+		switch flag, value := args[i], args[i+1]; flag {
+		case "-v":
+			out = append(out, "--bind", value)
+		case "-e":
+			out = append(out, "--env", value)
+		case "--gpus":
+			out = append(out, "--nv")
+		default:
+			return nil, fmt.Errorf("no apptainer equivalent of docker run %s", flag)
+		}
+		i++
+	}
+	return out, nil
+}
+
+// neurodeskBinds returns the Neurodesk mount points that exist on the host,
+// which Neurodesk binds into every container. Apptainer binds /tmp itself.
+func neurodeskBinds() []string {
+	var dirs []string
+	for _, dir := range recipe.GLOBAL_MOUNT_POINT_LIST {
+		if dir == "/tmp" {
+			continue
+		}
+		if st, err := os.Stat(dir); err == nil && st.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// sifTool returns tool, or when it is empty apptainer, or else singularity,
+// whichever is in PATH.
+func sifTool(tool string) (string, error) {
+	if tool != "" {
+		return tool, nil
+	}
+	for _, candidate := range []string{"apptainer", "singularity"} {
+		if _, err := exec.LookPath(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("neither apptainer nor singularity found in PATH")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+var (
+	buildTimeout time.Duration
+	testTimeout  time.Duration
+)
+
+// cancelGrace is how long a cancelled command has to exit after being
+// interrupted before it is killed.
+const cancelGrace = 30 * time.Second
+
+// withTimeout returns a context that expires after d, or never when d is 0.
+func withTimeout(d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), d)
+}
+
+// commandContext is exec.CommandContext, except that a cancelled command is
+// interrupted first, so docker can stop its build or container, and only
+// killed if it has not exited after cancelGrace.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = cancelGrace
+	return cmd
+}
+
+// timeoutError reports err as a timeout of what when ctx expired.
+func timeoutError(ctx context.Context, what string, d time.Duration, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out after %s: %w", what, d, err)
+	}
+	return err
+}
+
+// containerName returns a unique name for a test container, so it can be
+// removed when its test times out.
+func containerName(prefix string) string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return prefix + "-" + hex.EncodeToString(b)
+}
+
+// dockerRun runs "docker run --name <name> args..." under ctx. When ctx
+// ends first, the container is removed, since docker run --rm only cleans
+// up containers that exit by themselves.
+func dockerRun(ctx context.Context, name string, args []string, configure func(*exec.Cmd)) error {
+	cmd := commandContext(ctx, "docker", append([]string{"run", "--name", name}, args...)...)
+	if configure != nil {
+		configure(cmd)
+	}
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		rm := exec.Command("docker", "rm", "-f", name)
+		if out, rmErr := rm.CombinedOutput(); rmErr != nil {
+			fmt.Fprintf(os.Stderr, "WARN: removing container %s: %v\n%s", name, rmErr, out)
+		}
+	}
+	return err
+}
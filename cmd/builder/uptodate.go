@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/manifest"
+)
+
+// inputDigestLabel carries the input digest of a build on its image, so a
+// pushed image tells whether it is up to date.
+const inputDigestLabel = "org.neurodesk.builder.input-digest"
+
+// buildInputDigest returns the manifest.InputDigest of building stage with
+// args: its IR plus the contents of every file it read or stages, every
+// file in the recipe directory (COPY sources), the remote sources it
+// stages, and the architecture. Downloads are hashed by their contents and
+// git sources by the commit their ref resolves to, so a change upstream
+// changes the digest; both go through the caches staging uses.
+func buildInputDigest(stage *genericStageResult, args map[string]string) (string, error) {
+	// OCI sources name their layer by digest, so they need no lookup.
+	hc, gc, _ := netcaches()
+	ctx := context.Background()
+	inputs := map[string]string{}
+	if len(stage.build.Architectures) > 0 {
+		inputs["arch"] = string(stage.build.Architectures[0])
+	}
+	for k, v := range args {
+		inputs["arg:"+k] = v
+	}
+	for _, f := range stage.plan.Files {
+		key := "staged:" + f.Name
+		switch {
+		case f.HostFilename != "":
+			// The contents are hashed with the other inputs.
+			inputs[key] = "host " + f.HostFilename
+		case f.URL != "":
+			path, _, err := hc.Get(ctx, f.URL)
+			if err != nil {
+				return "", fmt.Errorf("fetching %s: %w", f.URL, err)
+			}
+			sum, err := sha256File(path)
+			if err != nil {
+				return "", err
+			}
+			inputs[key] = "url sha256:" + sum
+		case f.Git != nil:
+			src := *f.Git
+			if src.Commit == "" {
+				commit, err := gc.Resolve(ctx, src)
+				if err != nil {
+					return "", fmt.Errorf("resolving %s: %w", src.URL, err)
+				}
+				src.Commit = commit
+			}
+			inputs[key] = fmt.Sprintf("git %s %s depth=%d submodules=%t", src.URL, strings.ToLower(src.Commit), src.Depth, src.Submodules)
+		case f.OCI != nil:
+			inputs[key] = fmt.Sprintf("oci %#v", *f.OCI)
+		default:
+			inputs[key] = "contents " + sha256Hex([]byte(f.Contents))
+		}
+		if f.Executable {
+			inputs[key] += " executable"
+		}
+		if f.Extract != nil {
+			inputs[key] += fmt.Sprintf(" extract %d %q", f.Extract.StripComponents, f.Extract.Subdir)
+		}
+	}
+
+	files := map[string]bool{}
+	for _, path := range stage.plan.Inputs {
+		files[canonicalPath(path)] = true
+	}
+	err := filepath.WalkDir(stage.recipePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			files[canonicalPath(path)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("hashing recipe directory: %w", err)
+	}
+	for path := range files {
+		sum, err := sha256File(path)
+		if err != nil {
+			return "", err
+		}
+		inputs["file:"+path] = sum
+	}
+	return manifest.InputDigest(stage.irDef, inputs), nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// upToDate reports why stage need not be built with digest: the last
+// successful build of its version and architecture had the same inputs and
+// its image is still in the local image store, or the image at pushRef
+// carries the same input digest label. It returns "" when the build has to
+// run.
+func upToDate(stage *genericStageResult, digest, pushRef string) string {
+	arch := ""
+	if len(stage.build.Architectures) > 0 {
+		arch = string(stage.build.Architectures[0])
+	}
+	last, ok, err := (manifest.Store{Dir: manifest.DefaultDir}).LastSuccess(stage.build.Name, stage.version, arch)
+	if err == nil && ok && last.InputDigest == digest && last.Tag != "" && dockerImageID(last.Tag) != "" {
+		return fmt.Sprintf("last built %s", last.Started.Local().Format("2006-01-02 15:04"))
+	}
+	if pushRef != "" && registryInputDigest(pushRef) == digest {
+		return "found in the registry as " + pushRef
+	}
+	return ""
+}
+
+// registryInputDigest returns the input digest label of the image ref in
+// its registry, or "" when it has none or cannot be inspected.
+func registryInputDigest(ref string) string {
+	out, err := exec.Command("docker", "buildx", "imagetools", "inspect", ref, "--format", "{{json .Image}}").Output()
+	if err != nil {
+		return ""
+	}
+	type imageConfig struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	var single imageConfig
+	if err := json.Unmarshal(out, &single); err == nil && single.Config.Labels != nil {
+		return single.Config.Labels[inputDigestLabel]
+	}
+	// Multi-platform images print one config per platform; every platform
+	// must agree.
+	var perPlatform map[string]imageConfig
+	if err := json.Unmarshal(out, &perPlatform); err != nil {
+		return ""
+	}
+	digest := ""
+	for _, img := range perPlatform {
+		d := img.Config.Labels[inputDigestLabel]
+		if d == "" || (digest != "" && d != digest) {
+			return ""
+		}
+		digest = d
+	}
+	return digest
+}
+
+// buildUpToDate is set when the last build was skipped as up-to-date, so
+// builder test --build knows the image is not its own.
+var buildUpToDate bool
+
+// skipUpToDate sets the input digest of stage and reports whether its build
+// can be skipped, printing why. A digest that cannot be computed only means
+// the build runs. Partial builds (--until) are never skipped.
+func skipUpToDate(stage *genericStageResult, args map[string]string, force bool) bool {
+	if stage.partial {
+		return false
+	}
+	digest, err := buildInputDigest(stage, args)
+	if err != nil {
+		slog.Warn("cannot compute input digest; building", "error", err)
+		return false
+	}
+	stage.inputDigest = digest
+	if force {
+		return false
+	}
+	if reason := upToDate(stage, digest, buildPushRef); reason != "" {
+		fmt.Printf("%s:%s is up-to-date (%s; inputs %s); use --force to rebuild\n", stage.build.Name, stage.version, reason, shortHash(strings.TrimPrefix(digest, "sha256:")))
+		buildUpToDate = true
+		return true
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/manifest"
+	"github.com/neurodesk/builder/pkg/netcache"
+	"github.com/neurodesk/builder/pkg/recipe"
+)
+
+// digestTestStage is a stage of a recipe in a new directory, staging
+// files.
+func digestTestStage(t *testing.T, files ...recipe.StagedFile) *genericStageResult {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFile(t, dir, "build.yaml", "name: t\n")
+	return &genericStageResult{
+		recipePath: dir,
+		build:      &recipe.BuildFile{Name: "t", Architectures: []recipe.CPUArchitecture{recipe.CPUArchAMD64}},
+		irDef: &ir.Definition{Directives: []ir.DirectiveWithMetadata{
+			{Directive: ir.FromImageDirective("ubuntu:24.04")},
+			{Directive: ir.RunDirective("echo one")},
+		}},
+		plan:    &recipe.StagingPlan{Files: files},
+		version: "1.0",
+	}
+}
+
+func mustInputDigest(t *testing.T, stage *genericStageResult, args map[string]string) string {
+	t.Helper()
+	digest, err := buildInputDigest(stage, args)
+	if err != nil {
+		t.Fatalf("buildInputDigest: %v", err)
+	}
+	return digest
+}
+
+// useTestCaches points the download caches at new directories.
+func useTestCaches(t *testing.T) {
+	t.Helper()
+	t.Setenv("BUILDER_HTTP_CACHE_DIR", t.TempDir())
+	t.Setenv("BUILDER_GIT_CACHE_DIR", t.TempDir())
+	t.Setenv("BUILDER_OCI_CACHE_DIR", t.TempDir())
+}
+
+func TestBuildInputDigestRecipeChanges(t *testing.T) {
+	useTestCaches(t)
+	stage := digestTestStage(t, recipe.StagedFile{Name: "hello.sh", Contents: "echo hello\n"})
+	base := mustInputDigest(t, stage, nil)
+	if again := mustInputDigest(t, stage, nil); again != base {
+		t.Fatalf("digest is not stable: %s, then %s", base, again)
+	}
+
+	for name, change := range map[string]func(s *genericStageResult){
+		"directive": func(s *genericStageResult) {
+			s.irDef.Directives[1].Directive = ir.RunDirective("echo two")
+		},
+		"literal file": func(s *genericStageResult) {
+			s.plan.Files[0].Contents = "echo bye\n"
+		},
+		"executable bit": func(s *genericStageResult) {
+			s.plan.Files[0].Executable = true
+		},
+		"architecture": func(s *genericStageResult) {
+			s.build.Architectures = []recipe.CPUArchitecture{recipe.CPUArchARM64}
+		},
+		"file in the recipe directory": func(s *genericStageResult) {
+			writeTestFile(t, s.recipePath, "patch.diff", "x\n")
+		},
+	} {
+		changed := digestTestStage(t, recipe.StagedFile{Name: "hello.sh", Contents: "echo hello\n"})
+		before := mustInputDigest(t, changed, nil)
+		change(changed)
+		if mustInputDigest(t, changed, nil) == before {
+			t.Fatalf("%s: changing it does not change the digest", name)
+		}
+	}
+	if mustInputDigest(t, stage, map[string]string{"A": "1"}) == base {
+		t.Fatalf("build args do not change the digest")
+	}
+}
+
+func TestBuildInputDigestHashesHostFileContents(t *testing.T) {
+	useTestCaches(t)
+	stage := digestTestStage(t, recipe.StagedFile{Name: "data.txt", HostFilename: "data.txt"})
+	writeTestFile(t, stage.recipePath, "data.txt", "one\n")
+	before := mustInputDigest(t, stage, nil)
+	writeTestFile(t, stage.recipePath, "data.txt", "two\n")
+	if mustInputDigest(t, stage, nil) == before {
+		t.Fatalf("editing a staged local file does not change the digest")
+	}
+}
+
+func TestBuildInputDigestHashesDownloads(t *testing.T) {
+	useTestCaches(t)
+	body := "release 1\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	stage := digestTestStage(t, recipe.StagedFile{Name: "tool.tar.gz", URL: srv.URL + "/tool.tar.gz"})
+	before := mustInputDigest(t, stage, nil)
+	// The same URL with other contents upstream, as a fresh cache sees it.
+	body = "release 2\n"
+	t.Setenv("BUILDER_HTTP_CACHE_DIR", t.TempDir())
+	if mustInputDigest(t, stage, nil) == before {
+		t.Fatalf("new contents at the same URL do not change the digest")
+	}
+}
+
+func TestBuildInputDigestResolvesGitRefs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	useTestCaches(t)
+	repo := t.TempDir()
+	writeTestFile(t, repo, "README", "one\n")
+	testGit(t, repo, "init", "-q", "-b", "main")
+	testGit(t, repo, "add", "-A")
+	testGit(t, repo, "commit", "-q", "-m", "one")
+
+	stage := digestTestStage(t, recipe.StagedFile{Name: "src", Git: &netcache.GitSource{URL: repo, Ref: "main"}})
+	before := mustInputDigest(t, stage, nil)
+	if mustInputDigest(t, stage, nil) != before {
+		t.Fatalf("digest of an unchanged ref is not stable")
+	}
+	writeTestFile(t, repo, "README", "two\n")
+	testGit(t, repo, "commit", "-q", "-a", "-m", "two")
+	if mustInputDigest(t, stage, nil) == before {
+		t.Fatalf("a new commit on the ref does not change the digest")
+	}
+}
+
+func TestUpToDateNeedsTheLocalImage(t *testing.T) {
+	useTestCaches(t)
+	dir := manifest.DefaultDir
+	manifest.DefaultDir = t.TempDir()
+	t.Cleanup(func() { manifest.DefaultDir = dir })
+
+	// docker image inspect finds the image while the marker file exists.
+	bin := t.TempDir()
+	present := filepath.Join(bin, "present")
+	script := "#!/bin/sh\n[ \"$1 $2\" = \"image inspect\" ] && [ -f " + present + " ] && echo sha256:1234 && exit 0\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	stage := digestTestStage(t)
+	digest := mustInputDigest(t, stage, nil)
+	if reason := upToDate(stage, digest, ""); reason != "" {
+		t.Fatalf("upToDate without a build = %q", reason)
+	}
+	err := (manifest.Store{Dir: manifest.DefaultDir}).Add(manifest.Manifest{
+		Recipe:      "t",
+		Version:     "1.0",
+		Arch:        string(recipe.CPUArchAMD64),
+		Tag:         "t:1.0",
+		Status:      manifest.StatusSucceeded,
+		Started:     time.Now(),
+		InputDigest: digest,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(present, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if reason := upToDate(stage, digest, ""); reason == "" {
+		t.Fatalf("upToDate with the image present = \"\"")
+	}
+	if reason := upToDate(stage, "sha256:other", ""); reason != "" {
+		t.Fatalf("upToDate with other inputs = %q", reason)
+	}
+	// builder clean, or test --keep-image=false, removed the image.
+	if err := os.Remove(present); err != nil {
+		t.Fatal(err)
+	}
+	if reason := upToDate(stage, digest, ""); reason != "" {
+		t.Fatalf("upToDate with the image gone = %q", reason)
+	}
+}
@@ -15,6 +15,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/neurodesk/builder/pkg/buildlog"
+	"github.com/neurodesk/builder/pkg/manifest"
 )
 
 type BuildStatus string
@@ -39,14 +42,35 @@ type BuildResult struct {
 	BaselineReason        string
 	BaselineFailureOutput string
 	StatusDelta           string
+
+	// The fields below are only known for builds read from manifests.
+	FromManifest bool
+	Version      string
+	Method       string
+	Duration     time.Duration
+	ImageDigest  string
+	RecipeCommit string
+	HasReport    bool
+	CachedSteps  int
+	BuiltSteps   int
+	Steps        []StepTiming
+
+	// Trend aggregates the recipe's build history.
+	Trend *manifest.Trend
 }
 
 type TemplateData struct {
-	GeneratedAt  time.Time
-	LogsDir      string
+	GeneratedAt time.Time
+	// Source describes where results were read from, e.g. "logs in DIR".
+	Source       string
 	Builds       []BuildResult
 	HasBaseline  bool
 	BaselinePath string
+	// Live pages subscribe to the server's update stream.
+	Live   bool
+	Trends []manifest.Trend
+	// Window is the number of recent builds considered for flakiness.
+	Window int
 }
 
 var (
@@ -56,6 +80,11 @@ var (
 		"statusBadge":  statusBadgeClass,
 		"statusBorder": statusBorderClass,
 		"deltaClass":   statusDeltaClass,
+		"stepClass":    stepStatusClass,
+		"duration":     formatDuration,
+		"short":        shortID,
+		"percent":      percent,
+		"recentClass":  recentStatusClass,
 	}
 	dashboardTemplate = template.Must(template.New("dashboard").Funcs(templateFuncs).Parse(dashboardTemplateHTML))
 )
@@ -63,49 +92,114 @@ var (
 func main() {
 	logsDir := flag.String("logs", "local/local_logs", "directory containing docker build logs")
 	baselinePath := flag.String("baseline", "unpriv_build_summary.json", "optional baseline summary JSON (leave empty to disable)")
+	manifestsDir := flag.String("manifests", "", "read build manifests written by `builder build` from this directory (e.g. local/manifests) instead of logs")
 	outPath := flag.String("out", "", "write HTML output to this path (default stdout)")
+	serveAddr := flag.String("serve", "", "serve a live dashboard on this address (e.g. :8080) instead of writing HTML")
+	interval := flag.Duration("interval", 2*time.Second, "how often -serve checks the logs or manifests for changes")
+	historyDir := flag.String("history", "local/dashboard_history", "directory where results read from logs are kept for trends (leave empty to disable)")
+	window := flag.Int("window", 10, "number of recent builds per recipe checked for flakiness")
+	trendsPath := flag.String("trends-json", "", "also write the per-recipe trend aggregates as JSON to this path")
 	flag.Parse()
 
-	baselineEntries, baselineLoaded, err := loadBaseline(*baselinePath)
-	if err != nil {
-		log.Fatalf("loading baseline: %v", err)
+	sources := dashboardSources{
+		logsDir:      *logsDir,
+		manifestsDir: *manifestsDir,
+		baselinePath: *baselinePath,
+		historyDir:   *historyDir,
+		window:       *window,
 	}
 
-	builds, err := collectBuilds(*logsDir, baselineEntries)
-	if err != nil {
-		log.Fatalf("collecting build results: %v", err)
+	if *serveAddr != "" {
+		if err := serve(*serveAddr, sources, *interval); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	data := TemplateData{
-		GeneratedAt: time.Now(),
-		LogsDir:     *logsDir,
-		Builds:      builds,
-		HasBaseline: baselineLoaded,
-		BaselinePath: func() string {
-			if baselineLoaded {
-				return *baselinePath
-			}
-			return ""
-		}(),
+	page, trends, err := sources.render(false)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	var buf bytes.Buffer
-	if err := dashboardTemplate.Execute(&buf, data); err != nil {
-		log.Fatalf("rendering dashboard: %v", err)
+	if *trendsPath != "" {
+		b, err := marshalTrends(trends)
+		if err != nil {
+			log.Fatalf("encoding trends: %v", err)
+		}
+		if err := os.WriteFile(*trendsPath, b, 0o644); err != nil {
+			log.Fatalf("writing trends file: %v", err)
+		}
 	}
 
 	if *outPath == "" {
-		if _, err := buf.WriteTo(os.Stdout); err != nil {
+		if _, err := os.Stdout.Write(page); err != nil {
 			log.Fatalf("writing to stdout: %v", err)
 		}
 		return
 	}
 
-	if err := os.WriteFile(*outPath, buf.Bytes(), 0o644); err != nil {
+	if err := os.WriteFile(*outPath, page, 0o644); err != nil {
 		log.Fatalf("writing output file: %v", err)
 	}
 }
 
+// dashboardSources are the files a dashboard is rendered from.
+type dashboardSources struct {
+	logsDir      string
+	manifestsDir string
+	baselinePath string
+	historyDir   string
+	window       int
+}
+
+// render reads the sources and renders the dashboard page, and returns the
+// trends shown on it. A live page reloads itself when the server reports a
+// change.
+func (s dashboardSources) render(live bool) ([]byte, []manifest.Trend, error) {
+	baselineEntries, baselineLoaded, err := loadBaseline(s.baselinePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading baseline: %w", err)
+	}
+
+	var builds []BuildResult
+	source := "logs in " + s.logsDir
+	if s.manifestsDir != "" {
+		source = "manifests in " + s.manifestsDir
+		builds, err = collectManifestBuilds(s.manifestsDir, baselineEntries)
+	} else {
+		builds, err = collectBuilds(s.logsDir, baselineEntries)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("collecting build results: %w", err)
+	}
+
+	history, err := s.buildHistory(builds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading build history: %w", err)
+	}
+	trends := manifest.Trends(history, s.window)
+	attachTrends(builds, trends)
+
+	data := TemplateData{
+		GeneratedAt: time.Now(),
+		Source:      source,
+		Builds:      builds,
+		HasBaseline: baselineLoaded,
+		Live:        live,
+		Trends:      trends,
+		Window:      s.window,
+	}
+	if baselineLoaded {
+		data.BaselinePath = s.baselinePath
+	}
+
+	var buf bytes.Buffer
+	if err := dashboardTemplate.Execute(&buf, data); err != nil {
+		return nil, nil, fmt.Errorf("rendering dashboard: %w", err)
+	}
+	return buf.Bytes(), trends, nil
+}
+
 func collectBuilds(logsDir string, baseline map[string]baselineEntry) ([]BuildResult, error) {
 	entries, err := os.ReadDir(logsDir)
 	if err != nil {
@@ -145,18 +239,27 @@ func collectBuilds(logsDir string, baseline map[string]baselineEntry) ([]BuildRe
 
 		base := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
 		result.Name = strings.TrimPrefix(base, "build_")
-
-		if entry, ok := baseline[normalizeRecipeName(result.Name)]; ok {
-			result.BaselineProvided = true
-			result.BaselineStatus = normalizeBaselineStatus(entry.Status)
-			result.BaselineReason = entry.Reason
-			result.BaselineFailureOutput = entry.FailureOutput
-			result.StatusDelta = computeStatusDelta(result.Status, result.BaselineStatus)
-		}
+		applyBaseline(&result, baseline)
 
 		builds = append(builds, result)
 	}
 
+	sortBuilds(builds)
+	return builds, nil
+}
+
+func applyBaseline(result *BuildResult, baseline map[string]baselineEntry) {
+	if entry, ok := baseline[normalizeRecipeName(result.Name)]; ok {
+		result.BaselineProvided = true
+		result.BaselineStatus = normalizeBaselineStatus(entry.Status)
+		result.BaselineReason = entry.Reason
+		result.BaselineFailureOutput = entry.FailureOutput
+		result.StatusDelta = computeStatusDelta(result.Status, result.BaselineStatus)
+	}
+}
+
+// sortBuilds puts failures first, then unknown results, then successes.
+func sortBuilds(builds []BuildResult) {
 	sort.Slice(builds, func(i, j int) bool {
 		priority := map[BuildStatus]int{
 			BuildStatusFailed:    0,
@@ -168,8 +271,6 @@ func collectBuilds(logsDir string, baseline map[string]baselineEntry) ([]BuildRe
 		}
 		return builds[i].Name < builds[j].Name
 	})
-
-	return builds, nil
 }
 
 func parseLog(path string) (BuildResult, error) {
@@ -179,7 +280,8 @@ func parseLog(path string) (BuildResult, error) {
 	if err != nil {
 		return result, fmt.Errorf("read file: %w", err)
 	}
-	content := string(data)
+	// Logs written by the builder hold several timestamped builds.
+	content := buildlog.LastRun(string(data))
 
 	result.RunCommand = findRunCommand(content)
 	result.ErrorCommand = findErrorCommand(content)
@@ -400,6 +502,25 @@ func determineStatus(content, errorCommand string) BuildStatus {
 	return BuildStatusUnknown
 }
 
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Millisecond).String()
+	}
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
 func statusLabel(status BuildStatus) string {
 	switch status {
 	case BuildStatusSucceeded:
@@ -457,13 +578,43 @@ const dashboardTemplateHTML = `<!DOCTYPE html>
   <div class="mx-auto max-w-7xl space-y-6 px-4 py-10">
     <header class="space-y-2">
       <h1 class="text-3xl font-semibold tracking-tight">Docker Build Status</h1>
-      <p class="text-sm text-slate-400">Generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}} from logs in <span class="font-mono text-slate-200">{{.LogsDir}}</span></p>
+      <p class="text-sm text-slate-400">Generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}} from <span class="font-mono text-slate-200">{{.Source}}</span></p>
       {{if .HasBaseline}}
       <p class="text-sm text-slate-400">Baseline comparison: <span class="font-mono text-slate-200">{{.BaselinePath}}</span></p>
       {{end}}
     </header>
+    {{if .Trends}}
+    <section class="overflow-x-auto rounded-lg border border-slate-800 bg-slate-900/80">
+      <table class="w-full text-left text-sm">
+        <thead class="text-xs uppercase tracking-wide text-slate-400">
+          <tr>
+            <th class="px-4 py-2">Recipe</th>
+            <th class="px-4 py-2">Builds</th>
+            <th class="px-4 py-2">Success rate</th>
+            <th class="px-4 py-2">Mean duration</th>
+            <th class="px-4 py-2">Most common failing step</th>
+            <th class="px-4 py-2">Last {{.Window}}</th>
+          </tr>
+        </thead>
+        <tbody class="divide-y divide-slate-800 text-slate-300">
+          {{range .Trends}}
+          <tr>
+            <td class="px-4 py-2 font-medium text-slate-100">{{.Recipe}}
+              {{if .Flaky}}<span class="ml-1 inline-flex items-center rounded-full px-2 py-0.5 text-[11px] font-medium uppercase tracking-wide bg-amber-500/10 text-amber-200 border border-amber-500/30">Flaky</span>{{end}}
+            </td>
+            <td class="px-4 py-2">{{.Builds}}</td>
+            <td class="px-4 py-2">{{percent .SuccessRate}}</td>
+            <td class="px-4 py-2">{{if .MeanDuration}}{{duration .MeanDuration}}{{else}}-{{end}}</td>
+            <td class="px-4 py-2 font-mono text-xs">{{if .FailingStep}}{{.FailingStep}} ({{.FailingStepCount}}x){{else}}-{{end}}</td>
+            <td class="px-4 py-2"><div class="flex gap-0.5">{{range .Recent}}<span class="h-3 w-1.5 rounded-sm {{recentClass .}}" title="{{.}}"></span>{{end}}</div></td>
+          </tr>
+          {{end}}
+        </tbody>
+      </table>
+    </section>
+    {{end}}
     {{if not .Builds}}
-    <p class="rounded-md border border-slate-800 bg-slate-900/80 px-4 py-6 text-slate-300">No build logs found.</p>
+    <p class="rounded-md border border-slate-800 bg-slate-900/80 px-4 py-6 text-slate-300">No build results found.</p>
     {{else}}
     <div class="grid gap-4 sm:grid-cols-2 xl:grid-cols-3">
       {{range .Builds}}
@@ -473,13 +624,48 @@ const dashboardTemplateHTML = `<!DOCTYPE html>
             <h2 class="text-lg font-semibold text-slate-100">{{.Name}}</h2>
             <p class="text-xs text-slate-400">Updated {{.LastModified.Format "2006-01-02 15:04 MST"}}</p>
           </div>
-          <span class="inline-flex items-center rounded-full px-2.5 py-1 text-xs font-medium tracking-wide {{statusBadge .Status}}">{{statusLabel .Status}}</span>
+          <div class="flex items-center gap-2">
+            {{if and .Trend .Trend.Flaky}}
+            <span class="inline-flex items-center rounded-full px-2 py-0.5 text-[11px] font-medium uppercase tracking-wide bg-amber-500/10 text-amber-200 border border-amber-500/30">Flaky</span>
+            {{end}}
+            <span class="inline-flex items-center rounded-full px-2.5 py-1 text-xs font-medium tracking-wide {{statusBadge .Status}}">{{statusLabel .Status}}</span>
+          </div>
         </div>
         <dl class="mt-4 space-y-3 text-sm text-slate-300">
+          {{if .FromManifest}}
+          <div>
+            <dt class="font-medium text-slate-200">Build</dt>
+            <dd class="font-mono text-xs text-slate-400">
+              {{.Version}} via {{.Method}} in {{duration .Duration}}
+              {{if .ImageDigest}}<br>image {{short .ImageDigest}}{{end}}
+              {{if .RecipeCommit}}<br>commit {{short .RecipeCommit}}{{end}}
+            </dd>
+          </div>
+          {{if .HasReport}}
+          <div>
+            <dt class="font-medium text-slate-200">Steps <span class="font-normal text-slate-400">({{.CachedSteps}} cached, {{.BuiltSteps}} built)</span></dt>
+            <dd>
+              <table class="mt-1 w-full text-xs">
+                {{range .Steps}}
+                <tr title="{{.Status}}{{if .Error}}: {{.Error}}{{end}}">
+                  <td class="pr-2 align-top text-slate-500">{{.Step}}</td>
+                  <td class="w-full">
+                    <div class="truncate font-mono text-slate-300">{{.Label}}</div>
+                    <div class="h-1.5 rounded {{stepClass .Status}}" style="width: {{.Percent}}%"></div>
+                  </td>
+                  <td class="pl-2 text-right align-top whitespace-nowrap text-slate-400">{{duration .Duration}}</td>
+                </tr>
+                {{end}}
+              </table>
+            </dd>
+          </div>
+          {{end}}
+          {{else}}
           <div>
             <dt class="font-medium text-slate-200">Log file</dt>
             <dd class="font-mono text-xs text-slate-400">{{.LogRelative}}</dd>
           </div>
+          {{end}}
           {{if $.HasBaseline}}
           <div>
             <dt class="font-medium text-slate-200">Baseline</dt>
@@ -517,7 +703,7 @@ const dashboardTemplateHTML = `<!DOCTYPE html>
           {{if eq .Status "failed"}}
           {{if .ErrorCommand}}
           <div>
-            <dt class="font-medium text-rose-300">Command line</dt>
+            <dt class="font-medium text-rose-300">{{if .FromManifest}}Failed step{{else}}Command line{{end}}</dt>
             <dd>
               <pre class="mt-1 whitespace-pre-wrap rounded border border-rose-700/40 bg-rose-950/40 p-3 text-xs text-rose-100 overflow-x-auto">{{.ErrorCommand}}</pre>
             </dd>
@@ -525,7 +711,7 @@ const dashboardTemplateHTML = `<!DOCTYPE html>
           {{end}}
           {{if .ErrorOutput}}
           <div>
-            <dt class="font-medium text-rose-300">Command output</dt>
+            <dt class="font-medium text-rose-300">{{if .FromManifest}}Error{{else}}Command output{{end}}</dt>
             <dd>
               <pre class="mt-1 whitespace-pre-wrap rounded border border-rose-700/40 bg-rose-950/30 p-3 text-xs text-rose-100 overflow-x-auto">{{.ErrorOutput}}</pre>
             </dd>
@@ -538,5 +724,10 @@ const dashboardTemplateHTML = `<!DOCTYPE html>
     </div>
     {{end}}
   </div>
+  {{if .Live}}
+  <script>
+    new EventSource("events").addEventListener("update", () => location.reload());
+  </script>
+  {{end}}
 </body>
 </html>`
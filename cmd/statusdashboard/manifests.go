@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/manifest"
+)
+
+// StepTiming is one directive of an LLB build report, for the per-layer
+// timing breakdown.
+type StepTiming struct {
+	Step     int
+	Label    string
+	Kind     string
+	Status   ir.DirectiveStatus
+	Duration time.Duration
+	// Percent is the step's share of the longest step, for the bar width.
+	Percent int
+	Error   string
+}
+
+// collectManifestBuilds returns the latest build of every recipe recorded
+// in the manifest store at dir.
+func collectManifestBuilds(dir string, baseline map[string]baselineEntry) ([]BuildResult, error) {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("manifest directory %q not found", dir)
+	}
+	latest, err := manifest.Store{Dir: dir}.Latest()
+	if err != nil {
+		return nil, fmt.Errorf("reading manifests in %q: %w", dir, err)
+	}
+
+	builds := make([]BuildResult, 0, len(latest))
+	for _, m := range latest {
+		result := manifestResult(m)
+		applyBaseline(&result, baseline)
+		builds = append(builds, result)
+	}
+	sortBuilds(builds)
+	return builds, nil
+}
+
+func manifestResult(m manifest.Manifest) BuildResult {
+	result := BuildResult{
+		Name:         m.Recipe,
+		Status:       normalizeBaselineStatus(string(m.Status)),
+		LastModified: m.Started.Add(m.Duration),
+		FromManifest: true,
+		Version:      m.Version,
+		Method:       m.Method,
+		Duration:     m.Duration,
+		ImageDigest:  m.ImageDigest,
+		RecipeCommit: m.RecipeCommit,
+		ErrorOutput:  m.Error,
+	}
+	if m.Report == nil {
+		return result
+	}
+
+	result.HasReport = true
+	result.CachedSteps = m.Report.Cached
+	result.BuiltSteps = m.Report.Built
+	var longest time.Duration
+	for _, d := range m.Report.Directives {
+		longest = max(longest, d.Duration)
+	}
+	for _, d := range m.Report.Directives {
+		label := d.Label
+		if label == "" {
+			label = string(d.Source)
+		}
+		step := StepTiming{
+			Step:     d.Step,
+			Label:    label,
+			Kind:     d.Kind,
+			Status:   d.Status,
+			Duration: d.Duration,
+			Error:    d.Error,
+		}
+		if longest > 0 {
+			step.Percent = int(100 * d.Duration / longest)
+		}
+		if d.Status == ir.DirectiveFailed && result.ErrorCommand == "" {
+			result.ErrorCommand = label
+			if d.Error != "" {
+				result.ErrorOutput = d.Error
+			}
+		}
+		result.Steps = append(result.Steps, step)
+	}
+	return result
+}
+
+func stepStatusClass(status ir.DirectiveStatus) string {
+	switch status {
+	case ir.DirectiveBuilt:
+		return "bg-sky-500/70"
+	case ir.DirectiveCached:
+		return "bg-emerald-500/60"
+	case ir.DirectiveFailed:
+		return "bg-rose-500/80"
+	default:
+		return "bg-slate-700"
+	}
+}
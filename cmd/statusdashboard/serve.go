@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveDashboard holds the latest rendering of the dashboard and wakes up
+// event stream subscribers when it changes.
+type liveDashboard struct {
+	mu     sync.Mutex
+	page   []byte
+	trends []byte
+	err    error
+	// updated is closed, and replaced, whenever the page changes.
+	updated chan struct{}
+}
+
+func (d *liveDashboard) set(page, trends []byte, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.page, d.trends, d.err = page, trends, err
+	close(d.updated)
+	d.updated = make(chan struct{})
+}
+
+func (d *liveDashboard) current() ([]byte, chan struct{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.page, d.updated, d.err
+}
+
+func (d *liveDashboard) handlePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	page, _, err := d.current()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+func (d *liveDashboard) handleTrends(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	trends, err := d.trends, d.err
+	d.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(trends)
+}
+
+// handleEvents streams an "update" server-sent event every time the page
+// is re-rendered.
+func (d *liveDashboard) handleEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher, _ := w.(http.Flusher)
+	fmt.Fprint(w, ": connected\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	_, updated, _ := d.current()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-updated:
+			_, updated, _ = d.current()
+			fmt.Fprint(w, "event: update\ndata: {}\n\n")
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// serve renders the dashboard, serves it on addr and re-renders it whenever
+// the files it is built from change, until interrupted.
+func serve(addr string, sources dashboardSources, interval time.Duration) error {
+	d := &liveDashboard{updated: make(chan struct{})}
+	update := func() {
+		page, trends, err := sources.render(true)
+		var trendsJSON []byte
+		if err == nil {
+			trendsJSON, err = marshalTrends(trends)
+		}
+		if err != nil {
+			log.Printf("%v", err)
+		}
+		d.set(page, trendsJSON, err)
+	}
+	update()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.handlePage)
+	mux.HandleFunc("/events", d.handleEvents)
+	mux.HandleFunc("/trends.json", d.handleTrends)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// Requests are cancelled on interrupt too, so event streams end and
+	// Shutdown need not wait for them.
+	srv := &http.Server{
+		Addr:        addr,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go watchSources(ctx, sources, interval, update)
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		stopped <- srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("serving dashboard on %s", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// ListenAndServe returns as soon as Shutdown starts; wait for the open
+	// requests to finish.
+	return <-stopped
+}
+
+// watchSources polls the sources every interval and calls changed when
+// any file in them was added, removed or modified.
+func watchSources(ctx context.Context, sources dashboardSources, interval time.Duration, changed func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := sources.fingerprint()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if fp := sources.fingerprint(); fp != last {
+				last = fp
+				changed()
+			}
+		}
+	}
+}
+
+// fingerprint summarises the name, size and modification time of every
+// file the dashboard reads.
+func (s dashboardSources) fingerprint() string {
+	dir := s.logsDir
+	if s.manifestsDir != "" {
+		dir = s.manifestsDir
+	}
+	var parts []string
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && !entry.IsDir() {
+				parts = append(parts, fmt.Sprintf("%s %d %d", entry.Name(), info.Size(), info.ModTime().UnixNano()))
+			}
+		}
+	}
+	if s.baselinePath != "" {
+		if info, err := os.Stat(s.baselinePath); err == nil {
+			parts = append(parts, fmt.Sprintf("baseline %d %d", info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/neurodesk/builder/pkg/manifest"
+)
+
+// buildHistory returns the build history the trends are computed from,
+// oldest first. Manifests already are a history; log results are recorded
+// in the history directory, once per log modification time, so trends
+// accumulate across runs of the dashboard.
+func (s dashboardSources) buildHistory(builds []BuildResult) ([]manifest.Manifest, error) {
+	if s.manifestsDir != "" {
+		return manifest.Store{Dir: s.manifestsDir}.History("")
+	}
+
+	var current []manifest.Manifest
+	for _, b := range builds {
+		if b.Status == BuildStatusUnknown {
+			continue
+		}
+		current = append(current, manifest.Manifest{
+			Recipe:     b.Name,
+			Method:     "docker",
+			Status:     manifest.Status(b.Status),
+			FailedStep: b.ErrorCommand,
+			Started:    b.LastModified.UTC(),
+		})
+	}
+	if s.historyDir == "" {
+		return current, nil
+	}
+
+	store := manifest.Store{Dir: s.historyDir}
+	for _, m := range current {
+		past, err := store.History(m.Recipe)
+		if err != nil {
+			return nil, err
+		}
+		if !recorded(past, m) {
+			if err := store.Add(m); err != nil {
+				return nil, fmt.Errorf("recording %s: %w", m.Recipe, err)
+			}
+		}
+	}
+	return store.History("")
+}
+
+func recorded(past []manifest.Manifest, m manifest.Manifest) bool {
+	for _, p := range past {
+		if p.Started.Equal(m.Started) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachTrends points every build at the trend of its recipe.
+func attachTrends(builds []BuildResult, trends []manifest.Trend) {
+	byRecipe := map[string]*manifest.Trend{}
+	for i := range trends {
+		byRecipe[trends[i].Recipe] = &trends[i]
+	}
+	for i := range builds {
+		builds[i].Trend = byRecipe[builds[i].Name]
+	}
+}
+
+func marshalTrends(trends []manifest.Trend) ([]byte, error) {
+	if trends == nil {
+		trends = []manifest.Trend{}
+	}
+	b, err := json.MarshalIndent(trends, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func percent(rate float64) string {
+	return fmt.Sprintf("%.0f%%", 100*rate)
+}
+
+func recentStatusClass(status manifest.Status) string {
+	switch BuildStatus(status) {
+	case BuildStatusSucceeded:
+		return "bg-emerald-400"
+	case BuildStatusFailed:
+		return "bg-rose-400"
+	default:
+		return "bg-slate-600"
+	}
+}
@@ -14,6 +14,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/neurodesk/builder/pkg/smoketest"
 )
 
 type ExecutableType string
@@ -38,17 +40,157 @@ type ExecutableResult struct {
 	Output string `json:",omitempty"`
 }
 
+// EnvProbeResult records the deploy environment observed through one
+// invocation path (a shell mode or the image entrypoint).
+type EnvProbeResult struct {
+	Invocation string
+	Command    []string `json:",omitempty"`
+
+	Values map[string]string `json:",omitempty"`
+
+	// Dropped lists deploy variables that are set for the container but
+	// missing or empty through this invocation path.
+	Dropped []string `json:",omitempty"`
+	// Changed lists deploy variables whose value differs from the container's.
+	Changed []string `json:",omitempty"`
+
+	Skipped bool   `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
 type TestResults struct {
 	DeployBins  []string
 	DeployPaths []string
 
 	Executables map[string]ExecutableResult
+
+	Environment []EnvProbeResult `json:",omitempty"`
+
+	// Commands are the results of the smoke tests in the image's manifest.
+	Commands []smoketest.Result `json:",omitempty"`
+
+	GUIApps []smoketest.GUIResult `json:",omitempty"`
+
+	// Runtime are the results of the Python import and R library checks.
+	Runtime []smoketest.Result `json:",omitempty"`
 }
 
 type containerTester struct {
 	captureOutput bool
 }
 
+// deployEnvVars are the variables module-style usage relies on.
+var deployEnvVars = []string{"DEPLOY_BINS", "DEPLOY_PATH"}
+
+// envProbeSentinel starts the output of envProbeScript, so what login
+// shells print from their profiles is told apart from it.
+const envProbeSentinel = "__BUILDER_ENV_PROBE__"
+
+// envProbeScript prints envProbeSentinel on its own line, then each deploy
+// variable as NAME=value terminated by a NUL byte, which values cannot
+// contain.
+const envProbeScript = `printf '%s\n' ` + envProbeSentinel + `; printf 'DEPLOY_BINS=%s\000DEPLOY_PATH=%s\000' "$DEPLOY_BINS" "$DEPLOY_PATH"`
+
+type envProbe struct {
+	invocation string
+	command    []string
+}
+
+// probeEnvironment runs envProbeScript through each invocation path and compares
+// what it sees against the tester's own environment, which is the image ENV as
+// seen by `docker run image cmd`.
+func (ct *containerTester) probeEnvironment(entrypoint []string) []EnvProbeResult {
+	expected := make(map[string]string, len(deployEnvVars))
+	for _, name := range deployEnvVars {
+		expected[name] = os.Getenv(name)
+	}
+
+	results := []EnvProbeResult{{
+		Invocation: "docker run",
+		Values:     expected,
+	}}
+
+	probes := []envProbe{
+		{"sh -c", []string{"/bin/sh", "-c", envProbeScript}},
+		{"bash -c", []string{"bash", "-c", envProbeScript}},
+		{"bash -l", []string{"bash", "-l", "-c", envProbeScript}},
+		{"bash -li", []string{"bash", "-l", "-i", "-c", envProbeScript}},
+	}
+	if len(entrypoint) > 0 {
+		cmd := append(append([]string{}, entrypoint...), "/bin/sh", "-c", envProbeScript)
+		probes = append(probes, envProbe{"entrypoint", cmd})
+	}
+
+	for _, probe := range probes {
+		res := EnvProbeResult{Invocation: probe.invocation, Command: probe.command}
+		if _, err := exec.LookPath(probe.command[0]); err != nil {
+			res.Skipped = true
+			res.Error = fmt.Sprintf("%s not available: %v", probe.command[0], err)
+			results = append(results, res)
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		cmd := exec.CommandContext(ctx, probe.command[0], probe.command[1:]...)
+		// Interactive shells may complain about the missing tty on stderr; only
+		// stdout carries probe output.
+		output, err := cmd.Output()
+		cancel()
+		if err != nil {
+			res.Error = fmt.Sprintf("running %s: %v", probe.invocation, err)
+		}
+
+		values, perr := parseEnvProbeOutput(string(output))
+		if perr != nil {
+			if res.Error == "" {
+				res.Error = perr.Error()
+			}
+			results = append(results, res)
+			continue
+		}
+		res.Values = values
+		for _, name := range deployEnvVars {
+			want := expected[name]
+			if want == "" {
+				continue
+			}
+			got, ok := res.Values[name]
+			switch {
+			case !ok || got == "":
+				res.Dropped = append(res.Dropped, name)
+			case got != want:
+				res.Changed = append(res.Changed, name)
+			}
+		}
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// parseEnvProbeOutput returns the deploy variables envProbeScript printed
+// in output, skipping anything printed before its sentinel.
+func parseEnvProbeOutput(output string) (map[string]string, error) {
+	_, probe, ok := strings.Cut(output, envProbeSentinel+"\n")
+	if !ok {
+		return nil, fmt.Errorf("no environment probe output")
+	}
+	values := make(map[string]string)
+	for _, record := range strings.Split(probe, "\x00") {
+		name, value, ok := strings.Cut(record, "=")
+		if !ok {
+			continue
+		}
+		for _, known := range deployEnvVars {
+			if name == known {
+				values[name] = value
+				break
+			}
+		}
+	}
+	return values, nil
+}
+
 func (ct *containerTester) isScript(fullPath string) (bool, error) {
 	// Open the file
 	f, err := os.Open(fullPath)
@@ -233,12 +375,19 @@ func (ct *containerTester) testExecutable(name string, top bool) (ExecutableResu
 	return ret, nil
 }
 
-func (ct *containerTester) run() error {
+func (ct *containerTester) run() (err error) {
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
 	captureOutput := fs.Bool("capture-output", false, "Capture output of running each executable")
 	deployBins := fs.String("deploy-bins", os.Getenv("DEPLOY_BINS"), "Colon-separated list of binaries to test")
 	deployPaths := fs.String("deploy-paths", os.Getenv("DEPLOY_PATHS"), "Colon-separated list of paths to search for executables to test")
+	checkEnv := fs.Bool("check-env", true, "Check that DEPLOY_BINS/DEPLOY_PATH survive shells and the image entrypoint")
+	imageEntrypoint := fs.String("image-entrypoint", "", "JSON array with the image's entrypoint, used to probe the deploy environment through it")
+	smokeTests := fs.String("smoke-tests", smoketest.ManifestPath, "Manifest of command tests to run, if it exists")
+	commandTimeout := fs.Duration("command-timeout", 30*time.Second, "Timeout for each command test")
+	guiApps := fs.String("gui-apps", "", "JSON array of the recipe's GUI apps ({\"Name\", \"Exec\"}) to check")
+	runtimeTests := fs.String("runtime-tests", "", "JSON array of Python import and R library checks to run")
+	guiTimeout := fs.Duration("gui-timeout", 10*time.Second, "How long a GUI app must keep running, or take to exit cleanly, on the virtual display")
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		return fmt.Errorf("parsing flags: %w", err)
@@ -246,6 +395,27 @@ func (ct *containerTester) run() error {
 
 	ct.captureOutput = *captureOutput
 
+	var entrypoint []string
+	if *imageEntrypoint != "" {
+		if err := json.Unmarshal([]byte(*imageEntrypoint), &entrypoint); err != nil {
+			return fmt.Errorf("parsing image entrypoint %q: %w", *imageEntrypoint, err)
+		}
+	}
+
+	var apps []smoketest.GUIApp
+	if *guiApps != "" {
+		if err := json.Unmarshal([]byte(*guiApps), &apps); err != nil {
+			return fmt.Errorf("parsing gui apps %q: %w", *guiApps, err)
+		}
+	}
+
+	var runtime []smoketest.Test
+	if *runtimeTests != "" {
+		if err := json.Unmarshal([]byte(*runtimeTests), &runtime); err != nil {
+			return fmt.Errorf("parsing runtime tests %q: %w", *runtimeTests, err)
+		}
+	}
+
 	deployBinsList := strings.Split(*deployBins, ":")
 	deployPathsList := strings.Split(*deployPaths, ":")
 
@@ -255,6 +425,17 @@ func (ct *containerTester) run() error {
 		Executables: make(map[string]ExecutableResult),
 	}
 
+	// The deploy environment is probed first and reported even when a later
+	// check fails, since a dropped DEPLOY_PATH is a likely cause.
+	if *checkEnv {
+		results.Environment = ct.probeEnvironment(entrypoint)
+	}
+	defer func() {
+		if err != nil && results.Environment != nil {
+			json.NewEncoder(os.Stdout).Encode(results)
+		}
+	}()
+
 	for _, bin := range deployBinsList {
 		res, err := ct.testExecutable(bin, true)
 		if err != nil {
@@ -296,6 +477,24 @@ func (ct *containerTester) run() error {
 		}
 	}
 
+	manifest, ok, err := smoketest.Load(*smokeTests)
+	if err != nil {
+		return fmt.Errorf("reading smoke tests: %w", err)
+	}
+	if ok {
+		for _, t := range manifest.Tests {
+			results.Commands = append(results.Commands, smoketest.Run(t, *commandTimeout))
+		}
+	}
+
+	for _, t := range runtime {
+		results.Runtime = append(results.Runtime, smoketest.Run(t, *commandTimeout))
+	}
+
+	for _, app := range apps {
+		results.GUIApps = append(results.GUIApps, smoketest.CheckGUI(app, *guiTimeout))
+	}
+
 	if err := json.NewEncoder(os.Stdout).Encode(results); err != nil {
 		return fmt.Errorf("encoding test results: %w", err)
 	}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"maps"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvProbeOutput(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		output  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "normal output",
+			output: envProbeSentinel + "\nDEPLOY_BINS=bet:fslmaths\x00DEPLOY_PATH=/opt/fsl/bin\x00",
+			want:   map[string]string{"DEPLOY_BINS": "bet:fslmaths", "DEPLOY_PATH": "/opt/fsl/bin"},
+		},
+		{
+			name:   "profile output before the sentinel",
+			output: "Welcome!\nDEPLOY_PATH=/wrong\n" + envProbeSentinel + "\nDEPLOY_BINS=\x00DEPLOY_PATH=/opt/bin\x00",
+			want:   map[string]string{"DEPLOY_BINS": "", "DEPLOY_PATH": "/opt/bin"},
+		},
+		{
+			name:    "missing sentinel",
+			output:  "DEPLOY_BINS=bet\nDEPLOY_PATH=/opt/fsl/bin\n",
+			wantErr: true,
+		},
+		{
+			name:    "no output",
+			output:  "",
+			wantErr: true,
+		},
+		{
+			name:   "values containing =",
+			output: envProbeSentinel + "\nDEPLOY_BINS=a=b\x00DEPLOY_PATH=/opt/x=1:/opt/y\x00",
+			want:   map[string]string{"DEPLOY_BINS": "a=b", "DEPLOY_PATH": "/opt/x=1:/opt/y"},
+		},
+		{
+			name:   "values containing newlines",
+			output: envProbeSentinel + "\nDEPLOY_BINS=bet\nDEPLOY_PATH=/wrong\x00DEPLOY_PATH=/opt/a\n/opt/b\x00",
+			want:   map[string]string{"DEPLOY_BINS": "bet\nDEPLOY_PATH=/wrong", "DEPLOY_PATH": "/opt/a\n/opt/b"},
+		},
+		{
+			name:   "unknown variables",
+			output: envProbeSentinel + "\nHOME=/root\x00DEPLOY_BINS=bet\x00",
+			want:   map[string]string{"DEPLOY_BINS": "bet"},
+		},
+	} {
+		got, err := parseEnvProbeOutput(tc.output)
+		if tc.wantErr {
+			if err == nil {
+				t.Fatalf("%s: parseEnvProbeOutput = %q, want an error", tc.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: parseEnvProbeOutput: %v", tc.name, err)
+		}
+		if !maps.Equal(got, tc.want) {
+			t.Fatalf("%s: parseEnvProbeOutput = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEnvProbeScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	cmd := exec.Command("sh", "-c", "echo from the profile; "+envProbeScript)
+	cmd.Env = []string{"DEPLOY_BINS=bet:a=b", "DEPLOY_PATH=/opt/a\n/opt/b"}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("running the probe: %v", err)
+	}
+	got, err := parseEnvProbeOutput(string(out))
+	if err != nil {
+		t.Fatalf("parseEnvProbeOutput(%q): %v", out, err)
+	}
+	want := map[string]string{"DEPLOY_BINS": "bet:a=b", "DEPLOY_PATH": "/opt/a\n/opt/b"}
+	if !maps.Equal(got, want) {
+		t.Fatalf("probe saw %q, want %q", got, want)
+	}
+	if strings.Count(string(out), envProbeSentinel) != 1 {
+		t.Fatalf("probe output %q should have one sentinel", out)
+	}
+}
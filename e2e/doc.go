@@ -0,0 +1,10 @@
+// Package e2e holds the end-to-end suite. It builds the fixture recipes in
+// testdata/recipes through the real CLI against a throwaway registry and a
+// containerized BuildKit, so it needs Docker with buildx and is excluded from
+// the default test run:
+//
+//	go test -tags e2e ./e2e/
+//
+// Set E2E_KEEP=1 to leave the registry, builder and work directory in place
+// for debugging.
+package e2e
@@ -0,0 +1,314 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// env is shared by every test in the package; TestMain sets it up once.
+var env struct {
+	repoRoot    string
+	workDir     string
+	builderBin  string
+	configPath  string
+	registry    string // host:port of the fixture registry
+	buildxName  string
+	containerID string
+}
+
+// expectation describes what a fixture's image must look like; it is read
+// from expect.yaml next to the fixture's build.yaml.
+type expectation struct {
+	Method string            `yaml:"method"`
+	Files  map[string]string `yaml:"files"`
+	Labels map[string]string `yaml:"labels"`
+	Env    map[string]string `yaml:"env"`
+	Cmd    []string          `yaml:"cmd"`
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	if _, err := exec.LookPath("docker"); err != nil {
+		fmt.Fprintln(os.Stderr, "e2e: docker not found; skipping")
+		return 0
+	}
+	keep := os.Getenv("E2E_KEEP") != ""
+
+	var err error
+	if env.repoRoot, err = filepath.Abs(".."); err != nil {
+		return fail(err)
+	}
+	if env.workDir, err = os.MkdirTemp("", "builder-e2e-"); err != nil {
+		return fail(err)
+	}
+	if !keep {
+		defer os.RemoveAll(env.workDir)
+	}
+
+	env.builderBin = filepath.Join(env.workDir, "builder")
+	if out, err := command(env.repoRoot, "go", "build", "-o", env.builderBin, "./cmd/builder"); err != nil {
+		return fail(fmt.Errorf("building builder: %w\n%s", err, out))
+	}
+
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	// Registry on an ephemeral loopback port. Both the Docker daemon and a
+	// host-networked BuildKit treat localhost registries as plain HTTP.
+	out, err := command("", "docker", "run", "-d", "--rm", "-p", "127.0.0.1::5000", "registry:2")
+	if err != nil {
+		return fail(fmt.Errorf("starting registry: %w\n%s", err, out))
+	}
+	env.containerID = strings.TrimSpace(out)
+	if !keep {
+		defer command("", "docker", "rm", "-f", env.containerID)
+	}
+	out, err = command("", "docker", "port", env.containerID, "5000/tcp")
+	if err != nil {
+		return fail(fmt.Errorf("reading registry port: %w\n%s", err, out))
+	}
+	env.registry = strings.TrimSpace(strings.Split(out, "\n")[0])
+	if err := waitForRegistry(30 * time.Second); err != nil {
+		return fail(err)
+	}
+
+	env.buildxName = "builder-e2e-" + suffix
+	if out, err := command("", "docker", "buildx", "create", "--name", env.buildxName,
+		"--driver", "docker-container", "--driver-opt", "network=host", "--bootstrap"); err != nil {
+		return fail(fmt.Errorf("creating buildx builder: %w\n%s", err, out))
+	}
+	if !keep {
+		defer command("", "docker", "buildx", "rm", "--force", env.buildxName)
+	}
+
+	recipes, err := filepath.Abs("testdata/recipes")
+	if err != nil {
+		return fail(err)
+	}
+	env.configPath = filepath.Join(env.workDir, "builder.config.yaml")
+	cfg := fmt.Sprintf("recipe_roots:\n  - %s\n", recipes)
+	if err := os.WriteFile(env.configPath, []byte(cfg), 0o644); err != nil {
+		return fail(err)
+	}
+
+	if keep {
+		fmt.Fprintf(os.Stderr, "e2e: keeping work dir %s, registry %s, builder %s\n",
+			env.workDir, env.containerID, env.buildxName)
+	}
+	return m.Run()
+}
+
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "e2e:", err)
+	return 1
+}
+
+// command runs name with args in dir and returns combined output.
+func command(dir, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+	err := cmd.Run()
+	return buf.String(), err
+}
+
+// builder runs the CLI from the work directory so local/ output stays there.
+func builder(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(env.builderBin, append([]string{"--config", env.configPath}, args...)...)
+	cmd.Dir = env.workDir
+	cmd.Env = append(os.Environ(), "BUILDER_SOURCE_DIR="+env.repoRoot)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("builder %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+func docker(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := command("", "docker", args...)
+	if err != nil {
+		t.Fatalf("docker %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+func waitForRegistry(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := http.Get("http://" + env.registry + "/v2/")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("registry at %s not ready: %v", env.registry, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func registryTags(t *testing.T, repo string) []string {
+	t.Helper()
+	resp, err := http.Get("http://" + env.registry + "/v2/" + repo + "/tags/list")
+	if err != nil {
+		t.Fatalf("listing tags: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.Unmarshal(body, &tags); err != nil {
+		t.Fatalf("decoding tag list %q: %v", body, err)
+	}
+	return tags.Tags
+}
+
+type buildReport struct {
+	Cached     int `json:"cached"`
+	Built      int `json:"built"`
+	Failed     int `json:"failed"`
+	Directives []struct {
+		Kind   string `json:"kind"`
+		Status string `json:"status"`
+	} `json:"directives"`
+}
+
+func readReport(t *testing.T, name string) buildReport {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(env.workDir, "local", "build", name, "report.json"))
+	if err != nil {
+		t.Fatalf("reading build report: %v", err)
+	}
+	var rep buildReport
+	if err := json.Unmarshal(b, &rep); err != nil {
+		t.Fatalf("decoding build report: %v", err)
+	}
+	return rep
+}
+
+func TestFixtures(t *testing.T) {
+	entries, err := os.ReadDir("testdata/recipes")
+	if err != nil {
+		t.Fatalf("reading fixtures: %v", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(filepath.Join("testdata/recipes", name, "expect.yaml"))
+			if err != nil {
+				t.Fatalf("reading expect.yaml: %v", err)
+			}
+			var want expectation
+			if err := yaml.Unmarshal(raw, &want); err != nil {
+				t.Fatalf("parsing expect.yaml: %v", err)
+			}
+			runFixture(t, name, want)
+		})
+	}
+}
+
+func runFixture(t *testing.T, name string, want expectation) {
+	var build struct {
+		Version string `yaml:"version"`
+	}
+	raw, err := os.ReadFile(filepath.Join("testdata/recipes", name, "build.yaml"))
+	if err != nil {
+		t.Fatalf("reading build.yaml: %v", err)
+	}
+	if err := yaml.Unmarshal(raw, &build); err != nil {
+		t.Fatalf("parsing build.yaml: %v", err)
+	}
+	localTag := name + ":" + build.Version
+	pushRef := env.registry + "/" + name + ":" + build.Version
+
+	// generate
+	if df := builder(t, "generate", name); !strings.Contains(df, "FROM ") {
+		t.Fatalf("generate produced no FROM:\n%s", df)
+	}
+
+	// build + push
+	switch want.Method {
+	case "llb":
+		builder(t, "build", name, "--method", "llb", "--builder", env.buildxName, "--push", pushRef)
+		first := readReport(t, name)
+		if first.Failed != 0 || first.Built == 0 {
+			t.Fatalf("first build report: %+v", first)
+		}
+		// A second build must be served entirely from cache.
+		builder(t, "build", name, "--method", "llb", "--builder", env.buildxName, "--push", pushRef)
+		second := readReport(t, name)
+		if second.Built != 0 || second.Failed != 0 || second.Cached != first.Built+first.Cached {
+			t.Fatalf("rebuild was not fully cached: first %+v, second %+v", first, second)
+		}
+		docker(t, "pull", pushRef)
+		docker(t, "tag", pushRef, localTag)
+	case "docker", "":
+		builder(t, "build", name, "--push", pushRef)
+	default:
+		t.Fatalf("unknown method %q", want.Method)
+	}
+	if tags := registryTags(t, name); !slices.Contains(tags, build.Version) {
+		t.Fatalf("registry tags for %s = %v, want %s", name, tags, build.Version)
+	}
+
+	// test
+	builder(t, "test", name)
+
+	// image contents
+	for path, contents := range want.Files {
+		got := docker(t, "run", "--rm", "--entrypoint", "cat", localTag, path)
+		if strings.TrimSpace(got) != contents {
+			t.Fatalf("%s = %q, want %q", path, strings.TrimSpace(got), contents)
+		}
+	}
+	var inspect []struct {
+		Config struct {
+			Env    []string
+			Cmd    []string
+			Labels map[string]string
+		}
+	}
+	if err := json.Unmarshal([]byte(docker(t, "image", "inspect", localTag)), &inspect); err != nil || len(inspect) != 1 {
+		t.Fatalf("inspecting %s: %v", localTag, err)
+	}
+	cfg := inspect[0].Config
+	for k, v := range want.Labels {
+		if cfg.Labels[k] != v {
+			t.Fatalf("label %s = %q, want %q", k, cfg.Labels[k], v)
+		}
+	}
+	for k, v := range want.Env {
+		if !slices.Contains(cfg.Env, k+"="+v) {
+			t.Fatalf("env %s=%s missing from %v", k, v, cfg.Env)
+		}
+	}
+	if want.Cmd != nil && !slices.Equal(cfg.Cmd, want.Cmd) {
+		t.Fatalf("cmd = %v, want %v", cfg.Cmd, want.Cmd)
+	}
+}
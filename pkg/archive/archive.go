@@ -0,0 +1,363 @@
+// Package archive unpacks the tar and zip archives that recipes stage with
+// files.extract, and writes the tar archives of `builder bundle`.
+//
+// The format is detected from the contents rather than the file name, since
+// downloaded files often have none: zip, and tar either uncompressed or
+// compressed with gzip or bzip2. xz and zstd compressed tarballs are
+// decompressed with the xz and zstd tools, which must then be on PATH.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Options select the part of an archive that Extract writes.
+type Options struct {
+	// StripComponents drops this many leading path components from every
+	// entry, like tar --strip-components. Entries with no more components
+	// are skipped.
+	StripComponents int
+	// Subdir, when set, extracts only the entries under this directory of
+	// the archive (after StripComponents), with the directory as the root.
+	Subdir string
+}
+
+// Extract unpacks the archive at src into the directory dst, creating it.
+// Entries that would be written outside dst, directly or through a
+// symlink, are rejected.
+func Extract(src, dst string, opts Options) error {
+	if opts.StripComponents < 0 {
+		return fmt.Errorf("strip_components must not be negative")
+	}
+	subdir := ""
+	if opts.Subdir != "" {
+		subdir = path.Clean(strings.Trim(filepath.ToSlash(opts.Subdir), "/"))
+		if !fs.ValidPath(subdir) {
+			return fmt.Errorf("invalid subdir %q", opts.Subdir)
+		}
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	root, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	x := &extractor{root: root, strip: opts.StripComponents, subdir: subdir}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(512)
+
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")) {
+		err = x.zip(src)
+	} else {
+		err = x.tar(br, head)
+	}
+	if err != nil {
+		return err
+	}
+	if x.written == 0 && subdir != "" {
+		return fmt.Errorf("archive has no entries under %q", opts.Subdir)
+	}
+	return nil
+}
+
+type extractor struct {
+	root    string
+	strip   int
+	subdir  string
+	written int
+}
+
+// target maps an archive entry name to its path under root, or "" when the
+// entry is not extracted.
+func (x *extractor) target(name string) (string, error) {
+	name = strings.TrimLeft(strings.ReplaceAll(name, "\\", "/"), "/")
+	parts := strings.FieldsFunc(name, func(r rune) bool { return r == '/' })
+	var kept []string
+	for _, p := range parts {
+		if p != "." {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) <= x.strip {
+		return "", nil
+	}
+	rel := path.Clean(strings.Join(kept[x.strip:], "/"))
+	if x.subdir != "" {
+		if rel != x.subdir && !strings.HasPrefix(rel, x.subdir+"/") {
+			return "", nil
+		}
+		rel = strings.TrimPrefix(strings.TrimPrefix(rel, x.subdir), "/")
+		if rel == "" {
+			return "", nil
+		}
+	}
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return "", fmt.Errorf("archive entry %q points outside the extraction directory", name)
+	}
+	return filepath.Join(x.root, filepath.FromSlash(rel)), nil
+}
+
+// checkParent rejects writing target when its directory resolves outside
+// root through a symlink extracted earlier.
+func (x *extractor) checkParent(target string) error {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rootResolved, err := filepath.EvalSymlinks(x.root)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(rootResolved, dir); err != nil || !filepath.IsLocal(rel) && rel != "." {
+		return fmt.Errorf("archive entry %s is written through a symlink outside the extraction directory", target)
+	}
+	return nil
+}
+
+func (x *extractor) mkdir(target string) error {
+	if err := x.checkParent(target); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return err
+	}
+	x.written++
+	return nil
+}
+
+func (x *extractor) writeFile(target string, r io.Reader, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := x.checkParent(target); err != nil {
+		return err
+	}
+	// Replace rather than write through an existing entry, which may be a
+	// symlink.
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	perm := mode.Perm() | 0o600
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	x.written++
+	return os.Chmod(target, perm)
+}
+
+func (x *extractor) symlink(target, linkname string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := x.checkParent(target); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	x.written++
+	return nil
+}
+
+func (x *extractor) tar(r io.Reader, head []byte) error {
+	var (
+		stream io.Reader
+		err    error
+	)
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("reading gzip stream: %w", err)
+		}
+		defer gz.Close()
+		stream = gz
+	case bytes.HasPrefix(head, []byte("BZh")):
+		stream = bzip2.NewReader(r)
+	case bytes.HasPrefix(head, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		stream, err = decompressWith(r, "xz")
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		stream, err = decompressWith(r, "zstd")
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		stream = r
+	default:
+		return fmt.Errorf("unrecognized archive format (want tar, tar.gz, tar.bz2, tar.xz, tar.zst or zip)")
+	}
+	if err != nil {
+		return err
+	}
+	if c, ok := stream.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar: %w", err)
+		}
+		target, err := x.target(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(target)
+		case tar.TypeReg:
+			err = x.writeFile(target, tr, hdr.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = x.symlink(target, hdr.Linkname)
+		case tar.TypeLink:
+			var from string
+			from, err = x.target(hdr.Linkname)
+			if err == nil && from == "" {
+				err = fmt.Errorf("hard link %q points to %q, which is not extracted", hdr.Name, hdr.Linkname)
+			}
+			if err == nil {
+				err = x.link(from, target)
+			}
+		default:
+			// Devices, fifos and the like have no place in a build context.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %w", hdr.Name, err)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) link(from, target string) error {
+	if err := x.checkParent(target); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	if err := os.Link(from, target); err != nil {
+		return err
+	}
+	x.written++
+	return nil
+}
+
+func (x *extractor) zip(src string) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return fmt.Errorf("reading zip: %w", err)
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		target, err := x.target(zf.Name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			continue
+		}
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdir(target)
+		case mode&fs.ModeSymlink != 0:
+			err = x.zipSymlink(zf, target)
+		default:
+			var rc io.ReadCloser
+			rc, err = zf.Open()
+			if err == nil {
+				err = x.writeFile(target, rc, mode)
+				rc.Close()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("extracting %s: %w", zf.Name, err)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) zipSymlink(zf *zip.File, target string) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	linkname, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return x.symlink(target, string(linkname))
+}
+
+// decompressWith streams r through `tool -dc`.
+func decompressWith(r io.Reader, tool string) (io.ReadCloser, error) {
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, fmt.Errorf("extracting a %s compressed archive needs the %s tool on PATH", tool, tool)
+	}
+	cmd := exec.Command(tool, "-dc")
+	cmd.Stdin = r
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandReader{ReadCloser: out, cmd: cmd, stderr: &stderr}, nil
+}
+
+type commandReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (c *commandReader) Close() error {
+	c.ReadCloser.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %w: %s", c.cmd.Path, err, strings.TrimSpace(c.stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	body     string
+	linkname string
+	typ      byte
+	mode     int64
+}
+
+func writeTarGz(t *testing.T, entries []entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		typ := e.typ
+		if typ == 0 {
+			typ = tar.TypeReg
+		}
+		mode := e.mode
+		if mode == 0 {
+			mode = 0o644
+		}
+		hdr := &tar.Header{Name: e.name, Typeflag: typ, Mode: mode, Size: int64(len(e.body)), Linkname: e.linkname}
+		if typ != tar.TypeReg {
+			hdr.Size = 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if typ == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "archive")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestExtractTarGzStripComponents(t *testing.T) {
+	src := writeTarGz(t, []entry{
+		{name: "tool-1.0/", typ: tar.TypeDir},
+		{name: "tool-1.0/bin/tool", body: "#!/bin/sh\n", mode: 0o755},
+		{name: "tool-1.0/README", body: "readme"},
+		{name: "tool-1.0/bin/alias", typ: tar.TypeSymlink, linkname: "tool"},
+	})
+	dst := filepath.Join(t.TempDir(), "out")
+	if err := Extract(src, dst, Options{StripComponents: 1}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dst, "README")); got != "readme" {
+		t.Fatalf("README = %q", got)
+	}
+	info, err := os.Stat(filepath.Join(dst, "bin", "tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o111 == 0 {
+		t.Fatalf("bin/tool lost its executable bit: %v", info.Mode())
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "bin", "alias")); err != nil || target != "tool" {
+		t.Fatalf("bin/alias = %q, %v", target, err)
+	}
+}
+
+func TestExtractSubdir(t *testing.T) {
+	src := writeTarGz(t, []entry{
+		{name: "pkg/share/data/a.txt", body: "a"},
+		{name: "pkg/share/data/nested/b.txt", body: "b"},
+		{name: "pkg/bin/tool", body: "tool"},
+	})
+	dst := t.TempDir()
+	if err := Extract(src, dst, Options{StripComponents: 1, Subdir: "share/data"}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dst, "nested", "b.txt")); got != "b" {
+		t.Fatalf("nested/b.txt = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "bin")); !os.IsNotExist(err) {
+		t.Fatalf("entries outside subdir were extracted: %v", err)
+	}
+
+	err := Extract(src, t.TempDir(), Options{Subdir: "missing"})
+	if err == nil || !strings.Contains(err.Error(), `no entries under "missing"`) {
+		t.Fatalf("expected missing subdir error, got %v", err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	hdr := &zip.FileHeader{Name: "dist/run.sh", Method: zip.Deflate}
+	hdr.SetMode(0o755)
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("echo hi\n"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// No file extension: the format is detected from the contents.
+	src := filepath.Join(t.TempDir(), "download")
+	if err := os.WriteFile(src, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if err := Extract(src, dst, Options{StripComponents: 1}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if got := readFile(t, filepath.Join(dst, "run.sh")); got != "echo hi\n" {
+		t.Fatalf("run.sh = %q", got)
+	}
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	cases := map[string][]entry{
+		"dotdot": {{name: "../evil", body: "x"}},
+		"symlink": {
+			{name: "link", typ: tar.TypeSymlink, linkname: "/tmp"},
+			{name: "link/evil", body: "x"},
+		},
+	}
+	for name, entries := range cases {
+		t.Run(name, func(t *testing.T) {
+			src := writeTarGz(t, entries)
+			if err := Extract(src, t.TempDir(), Options{}); err == nil {
+				t.Fatalf("expected an error for an entry outside the extraction directory")
+			}
+		})
+	}
+}
+
+func TestExtractRejectsUnknownFormat(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "plain.txt")
+	if err := os.WriteFile(src, []byte("not an archive"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := Extract(src, t.TempDir(), Options{})
+	if err == nil || !strings.Contains(err.Error(), "unrecognized archive format") {
+		t.Fatalf("expected unrecognized format error, got %v", err)
+	}
+}
+
+func TestCreateRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "cache"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "Dockerfile"), []byte("FROM scratch\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "cache", "run.sh"), []byte("echo\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("run.sh", filepath.Join(src, "cache", "alias")); err != nil {
+		t.Fatal(err)
+	}
+	meta := t.TempDir()
+	if err := os.WriteFile(filepath.Join(meta, "bundle.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := Create(dst, []Tree{{Prefix: "context", Dir: src}, {Dir: meta}}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	out := t.TempDir()
+	if err := Extract(dst, out, Options{}); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if got := readFile(t, filepath.Join(out, "context", "Dockerfile")); got != "FROM scratch\n" {
+		t.Fatalf("Dockerfile = %q", got)
+	}
+	if got := readFile(t, filepath.Join(out, "bundle.json")); got != "{}" {
+		t.Fatalf("bundle.json = %q", got)
+	}
+	info, err := os.Stat(filepath.Join(out, "context", "cache", "run.sh"))
+	if err != nil || info.Mode().Perm()&0o111 == 0 {
+		t.Fatalf("cache/run.sh = %v, %v; want an executable file", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(out, "context", "cache", "alias")); err != nil || target != "run.sh" {
+		t.Fatalf("cache/alias = %q, %v", target, err)
+	}
+
+	if err := Create(filepath.Join(t.TempDir(), "bundle.rar"), nil); err == nil {
+		t.Fatalf("expected an error for an unsupported archive name")
+	}
+}
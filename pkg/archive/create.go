@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Tree is a directory to add to an archive.
+type Tree struct {
+	// Prefix is the directory the tree's entries are placed under in the
+	// archive, or "" for its root.
+	Prefix string
+	Dir    string
+}
+
+// Create writes a tar archive of trees to dst, compressed as its name says:
+// .tar.gz or .tgz with gzip, .tar.zst or .tzst with the zstd tool (which
+// must be on PATH), and .tar not at all. Modes and symlinks are kept;
+// hard links are stored as regular files.
+func Create(dst string, trees []Tree) (err error) {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	var w io.WriteCloser
+	switch name := strings.ToLower(dst); {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		w = gzip.NewWriter(out)
+	case strings.HasSuffix(name, ".tar.zst") || strings.HasSuffix(name, ".tzst"):
+		if w, err = compressWith(out, "zstd"); err != nil {
+			return err
+		}
+	case strings.HasSuffix(name, ".tar"):
+		w = nopCloser{out}
+	default:
+		return fmt.Errorf("%s: unsupported archive name (want .tar, .tar.gz, .tgz, .tar.zst or .tzst)", dst)
+	}
+
+	tw := tar.NewWriter(w)
+	for _, t := range trees {
+		if err := addTree(tw, t); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func addTree(tw *tar.Writer, t Tree) error {
+	prefix := strings.Trim(filepath.ToSlash(t.Prefix), "/")
+	return filepath.WalkDir(t.Dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(t.Dir, name)
+		if err != nil {
+			return err
+		}
+		entry := path.Join(prefix, filepath.ToSlash(rel))
+		if entry == "." || entry == "" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(name); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = entry
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// Keep archives reproducible and free of host accounts.
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("archiving %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// compressWith streams what is written through `tool -q -c` to out.
+func compressWith(out io.Writer, tool string) (io.WriteCloser, error) {
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, fmt.Errorf("writing a %s compressed archive needs the %s tool on PATH", tool, tool)
+	}
+	cmd := exec.Command(tool, "-q", "-c")
+	cmd.Stdout = out
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandWriter{WriteCloser: in, cmd: cmd, stderr: &stderr}, nil
+}
+
+type commandWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func (c *commandWriter) Close() error {
+	c.WriteCloser.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %w: %s", c.cmd.Path, err, strings.TrimSpace(c.stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Package buildlog writes and reads the per-recipe build logs under
+// local/local_logs: build_<recipe>.log files in which every line carries a
+// timestamp and every build starts with a marker line, so several builds
+// can share one file until it is rotated.
+package buildlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunMarker starts the first line of every build in a log.
+const RunMarker = "==> builder build "
+
+// TimeFormat is the layout of the timestamp at the start of every line.
+const TimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Path returns the log file of recipe in dir.
+func Path(dir, recipe string) string {
+	return filepath.Join(dir, "build_"+recipe+".log")
+}
+
+// Rotate moves path to path.1, path.1 to path.2 and so on when path is
+// larger than maxSize bytes, keeping keep rotated files. A missing file is
+// not an error.
+func Rotate(path string, maxSize int64, keep int) error {
+	st, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if st.Size() <= maxSize {
+		return nil
+	}
+	if keep < 1 {
+		return os.Remove(path)
+	}
+	if err := os.Remove(fmt.Sprintf("%s.%d", path, keep)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := keep - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
+
+// Writer prefixes every line written to it with a timestamp. Writers that
+// share a mutex can write to the same file, e.g. one for stdout and one for
+// stderr; their lines are not split, but lines written in pieces may be
+// interleaved.
+type Writer struct {
+	w   io.Writer
+	mu  *sync.Mutex
+	now func() time.Time
+	// mid is set when the last write did not end a line.
+	mid bool
+}
+
+// NewWriter returns a Writer to w that locks mu around each write. now is
+// the clock; nil means time.Now.
+func NewWriter(w io.Writer, mu *sync.Mutex, now func() time.Time) *Writer {
+	if now == nil {
+		now = time.Now
+	}
+	return &Writer{w: w, mu: mu, now: now}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var b []byte
+	stamp := w.now().Format(TimeFormat) + " "
+	rest := p
+	for len(rest) > 0 {
+		if !w.mid {
+			b = append(b, stamp...)
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			b = append(b, rest...)
+			w.mid = true
+			break
+		}
+		b = append(b, rest[:i+1]...)
+		rest = rest[i+1:]
+		w.mid = false
+	}
+	if _, err := w.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var timestampPrefix = regexp.MustCompile(`(?m)^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2}) `)
+
+// LastRun returns the output of the last build in a log without the line
+// timestamps. Logs without timestamps or run markers, such as those of
+// shell redirection, are returned as they are.
+func LastRun(content string) string {
+	content = timestampPrefix.ReplaceAllString(content, "")
+	if i := strings.LastIndex(content, "\n"+RunMarker); i >= 0 {
+		return content[i+1:]
+	}
+	return content
+}
@@ -0,0 +1,70 @@
+package buildlog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriterStampsLines(t *testing.T) {
+	var buf bytes.Buffer
+	now := func() time.Time { return time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC) }
+	w := NewWriter(&buf, &sync.Mutex{}, now)
+	fmt.Fprint(w, "one\ntw")
+	fmt.Fprint(w, "o\nthree\n")
+	want := "2025-03-01T12:00:00.000Z one\n2025-03-01T12:00:00.000Z two\n2025-03-01T12:00:00.000Z three\n"
+	if buf.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestLastRun(t *testing.T) {
+	log := strings.Join([]string{
+		"2025-03-01T12:00:00.000Z " + RunMarker + "a started",
+		"2025-03-01T12:00:01.000Z docker build failed",
+		"2025-03-01T13:00:00.000+02:00 " + RunMarker + "a started",
+		"2025-03-01T13:00:01.000+02:00 Running: docker build",
+		"2025-03-01T13:00:09.000+02:00 Built image a:1.0",
+		"",
+	}, "\n")
+	got := LastRun(log)
+	want := RunMarker + "a started\nRunning: docker build\nBuilt image a:1.0\n"
+	if got != want {
+		t.Fatalf("LastRun:\n%s\nwant:\n%s", got, want)
+	}
+	if plain := "Running: docker build\n"; LastRun(plain) != plain {
+		t.Fatalf("LastRun changed a plain log")
+	}
+}
+
+func TestRotateKeepsNewestFiles(t *testing.T) {
+	path := Path(t.TempDir(), "a")
+	for i := range 4 {
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("run %d\n", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := Rotate(path, 1, 2); err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("log not rotated away: %v", err)
+	}
+	for n, want := range map[int]string{1: "run 3\n", 2: "run 2\n"} {
+		b, err := os.ReadFile(fmt.Sprintf("%s.%d", path, n))
+		if err != nil || string(b) != want {
+			t.Fatalf("%s.%d = %q, %v; want %q", filepath.Base(path), n, b, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("kept more than 2 rotated logs")
+	}
+	if err := Rotate(path, 1, 2); err != nil {
+		t.Fatalf("Rotate of a missing log: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Package desktop renders freedesktop.org desktop entries, the .desktop
+// files application menus list, for the GUI applications of a container.
+package desktop
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Entry is an application desktop entry.
+type Entry struct {
+	Name string
+	// Exec is the command line that launches the application.
+	Exec string
+	// TryExec, if set, is the executable whose absence hides the entry.
+	TryExec    string
+	Comment    string
+	Categories []string
+}
+
+// Render returns the entry as a .desktop file. The name and command line
+// are required, and no value may contain control characters, which the
+// format cannot represent.
+func (e Entry) Render() (string, error) {
+	if strings.TrimSpace(e.Name) == "" {
+		return "", fmt.Errorf("desktop entry needs a name")
+	}
+	if strings.TrimSpace(e.Exec) == "" {
+		return "", fmt.Errorf("desktop entry %q needs a command line", e.Name)
+	}
+	var b strings.Builder
+	b.WriteString("[Desktop Entry]\nType=Application\n")
+	fields := []struct{ key, value string }{
+		{"Name", e.Name},
+		{"Comment", e.Comment},
+		{"Exec", e.Exec},
+		{"TryExec", e.TryExec},
+		{"Categories", categories(e.Categories)},
+	}
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		if strings.ContainsFunc(f.value, isControl) {
+			return "", fmt.Errorf("desktop entry %q: %s contains a control character", e.Name, f.key)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", f.key, escape(f.value))
+	}
+	b.WriteString("Terminal=false\n")
+	return b.String(), nil
+}
+
+// categories joins categories as a list value, which ends with a ";".
+func categories(cs []string) string {
+	if len(cs) == 0 {
+		return ""
+	}
+	return strings.Join(cs, ";") + ";"
+}
+
+// escape escapes a backslash, the one character that needs it in the
+// string values Render writes.
+func escape(s string) string {
+	return strings.ReplaceAll(s, `\`, `\\`)
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
@@ -0,0 +1,37 @@
+package desktop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	got, err := Entry{
+		Name:       "fsleyes",
+		Exec:       `fsleyes --scene ortho`,
+		TryExec:    "/opt/fsl/bin/fsleyes",
+		Categories: []string{"Graphics", "Science"},
+	}.Render()
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	want := "[Desktop Entry]\nType=Application\nName=fsleyes\nExec=fsleyes --scene ortho\nTryExec=/opt/fsl/bin/fsleyes\nCategories=Graphics;Science;\nTerminal=false\n"
+	if got != want {
+		t.Fatalf("Render =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderRejectsInvalidEntries(t *testing.T) {
+	for _, tc := range []struct {
+		entry Entry
+		err   string
+	}{
+		{Entry{Exec: "viewer"}, "needs a name"},
+		{Entry{Name: "viewer"}, "needs a command line"},
+		{Entry{Name: "viewer", Exec: "viewer\n--help"}, "Exec contains a control character"},
+	} {
+		if _, err := tc.entry.Render(); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("Render(%+v) error = %v, want %q", tc.entry, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package flatten turns the filesystem of a container, as written by docker
+// export, into a single-layer image in the docker save format, which docker
+// load reads back. Squashing an image this way keeps its config but drops
+// the layers of the base image and of every build step.
+package flatten
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Image describes the single-layer image WriteArchive writes.
+type Image struct {
+	// Config is the container config of the image, the "config" object of
+	// its image config, as shown by docker image inspect.
+	Config       json.RawMessage
+	Architecture string
+	OS           string
+	Variant      string
+	// Tags are the repository tags docker load gives the image.
+	Tags []string
+	// CreatedBy is recorded as the history of the layer.
+	CreatedBy string
+	Created   time.Time
+}
+
+// runtimeFiles are files a container runtime adds to every container, which
+// docker export includes but do not belong in an image.
+var runtimeFiles = map[string]bool{
+	".dockerenv": true,
+}
+
+// Layer copies the tar stream r of docker export to w, leaving out the
+// files the runtime adds, and returns the diff ID of the layer written.
+func Layer(w io.Writer, r io.Reader) (string, error) {
+	h := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(w, h))
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading export: %w", err)
+		}
+		if runtimeFiles[strings.TrimPrefix(hdr.Name, "./")] {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return "", fmt.Errorf("copying %s: %w", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteArchive writes a docker save archive of img to w, with the layer at
+// layerPath whose diff ID Layer returned.
+func WriteArchive(w io.Writer, img Image, layerPath, diffID string) error {
+	created := img.Created.UTC().Format(time.RFC3339Nano)
+	fields := map[string]any{
+		"architecture": img.Architecture,
+		"os":           img.OS,
+		"created":      created,
+		"config":       img.Config,
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": []string{diffID}},
+		"history":      []map[string]string{{"created": created, "created_by": img.CreatedBy}},
+	}
+	if img.Variant != "" {
+		fields["variant"] = img.Variant
+	}
+	config, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(config)
+	configName := hex.EncodeToString(sum[:]) + ".json"
+	layerName := strings.TrimPrefix(diffID, "sha256:") + "/layer.tar"
+	manifest, err := json.Marshal([]map[string]any{{
+		"Config":   configName,
+		"RepoTags": img.Tags,
+		"Layers":   []string{layerName},
+	}})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	file := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: img.Created}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := file(configName, config); err != nil {
+		return err
+	}
+	layer, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer layer.Close()
+	st, err := layer.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: layerName, Mode: 0o644, Size: st.Size(), ModTime: img.Created}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, layer); err != nil {
+		return fmt.Errorf("writing layer: %w", err)
+	}
+	if err := file("manifest.json", manifest); err != nil {
+		return err
+	}
+	return tw.Close()
+}
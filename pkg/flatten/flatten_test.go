@@ -0,0 +1,133 @@
+package flatten
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{".dockerenv", "etc/", "etc/os-release", "opt/tool"} {
+		data, ok := files[name]
+		if !ok {
+			continue
+		}
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if name[len(name)-1] == '/' {
+			hdr = &tar.Header{Name: name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLayerAndArchive(t *testing.T) {
+	export := writeTar(t, map[string]string{".dockerenv": "", "etc/": "", "etc/os-release": "ID=ubuntu\n", "opt/tool": "#!/bin/sh\n"})
+	layerPath := filepath.Join(t.TempDir(), "layer.tar")
+	f, err := os.Create(layerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffID, err := Layer(f, bytes.NewReader(export))
+	if err != nil {
+		t.Fatalf("Layer: %v", err)
+	}
+	f.Close()
+
+	layer, err := os.ReadFile(layerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(layer)
+	if diffID != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Fatalf("diff ID %s does not match the layer", diffID)
+	}
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(layer))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if len(names) != 3 || names[0] != "etc/" {
+		t.Fatalf("layer entries = %v; want everything but .dockerenv", names)
+	}
+
+	var archive bytes.Buffer
+	img := Image{
+		Config:       json.RawMessage(`{"Env":["PATH=/usr/bin"],"Entrypoint":["/opt/tool"]}`),
+		Architecture: "amd64",
+		OS:           "linux",
+		Tags:         []string{"tool:1.0"},
+		CreatedBy:    "builder build --squash",
+		Created:      time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := WriteArchive(&archive, img, layerPath, diffID); err != nil {
+		t.Fatalf("WriteArchive: %v", err)
+	}
+	files := map[string][]byte{}
+	tr = tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[hdr.Name] = data
+	}
+	var manifest []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("manifest.json: %v", err)
+	}
+	if len(manifest) != 1 || len(manifest[0].Layers) != 1 || manifest[0].RepoTags[0] != "tool:1.0" {
+		t.Fatalf("manifest = %+v", manifest)
+	}
+	if !bytes.Equal(files[manifest[0].Layers[0]], layer) {
+		t.Fatalf("archived layer differs")
+	}
+	var config struct {
+		Config struct{ Entrypoint []string }
+		RootFS struct {
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+		History []struct {
+			CreatedBy string `json:"created_by"`
+		}
+	}
+	if err := json.Unmarshal(files[manifest[0].Config], &config); err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if len(config.RootFS.DiffIDs) != 1 || config.RootFS.DiffIDs[0] != diffID || len(config.History) != 1 || config.Config.Entrypoint[0] != "/opt/tool" {
+		t.Fatalf("config = %s", files[manifest[0].Config])
+	}
+}
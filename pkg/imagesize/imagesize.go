@@ -0,0 +1,352 @@
+// Package imagesize attributes the size of a built image to the recipe
+// directives that created its layers, points out the usual causes of
+// bloat and compares the layers of two images.
+package imagesize
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// Layer is one entry of an image's history, oldest first.
+type Layer struct {
+	CreatedBy string `json:"created_by"`
+	Size      int64  `json:"size"`
+}
+
+// StepSize is the size a directive added to the image.
+type StepSize struct {
+	// Step is the 0-based index of the directive.
+	Step        int    `json:"step"`
+	Description string `json:"description"`
+	Size        int64  `json:"size"`
+	Layers      int    `json:"layers"`
+}
+
+// Report attributes the size of an image to the base image and the
+// directives of its final stage.
+type Report struct {
+	Total int64 `json:"total"`
+	// Base is the size of the layers below the final stage's FROM.
+	Base  int64      `json:"base"`
+	Steps []StepSize `json:"steps"`
+}
+
+// Attribute splits history between the base image and the directives of
+// def. steps is ir.FinalStageSteps(def): the last len(steps) history
+// entries are the final stage's instructions, in order.
+func Attribute(def *ir.Definition, history []Layer, steps []int) (Report, error) {
+	if len(history) < len(steps) {
+		return Report{}, fmt.Errorf("image has %d history entries but the final stage has %d instructions; was it built from this recipe?", len(history), len(steps))
+	}
+	var r Report
+	base := len(history) - len(steps)
+	for _, l := range history[:base] {
+		r.Base += l.Size
+	}
+	byStep := map[int]*StepSize{}
+	for i, step := range steps {
+		l := history[base+i]
+		s, ok := byStep[step]
+		if !ok {
+			s = &StepSize{Step: step, Description: def.Directives[step].Describe()}
+			byStep[step] = s
+		}
+		s.Size += l.Size
+		if l.Size > 0 {
+			s.Layers++
+		}
+	}
+	for _, s := range byStep {
+		r.Steps = append(r.Steps, *s)
+	}
+	sort.Slice(r.Steps, func(i, j int) bool { return r.Steps[i].Step < r.Steps[j].Step })
+	r.Total = r.Base
+	for _, s := range r.Steps {
+		r.Total += s.Size
+	}
+	return r, nil
+}
+
+// Largest returns the n steps that added the most, largest first.
+func (r Report) Largest(n int) []StepSize {
+	out := append([]StepSize{}, r.Steps...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Size > out[j].Size })
+	if n >= 0 && len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+// Finding is a likely cause of avoidable size.
+type Finding struct {
+	// Step is the directive responsible, or -1 for findings about the
+	// image as a whole.
+	Step    int    `json:"step"`
+	Size    int64  `json:"size,omitempty"`
+	Message string `json:"message"`
+}
+
+// CacheDirs are package manager caches that should not end up in an image.
+var CacheDirs = []string{
+	"/var/lib/apt/lists",
+	"/var/cache/apt/archives",
+	"/var/cache/yum",
+	"/var/cache/dnf",
+	"/root/.cache/pip",
+	"/opt/conda/pkgs",
+	"/tmp",
+}
+
+// buildDeps are packages only needed to compile software.
+var buildDeps = []string{
+	"build-essential", "gcc", "g++", "gcc-c++", "gfortran", "make", "cmake",
+	"autoconf", "automake", "libtool", "pkg-config", "pkgconfig",
+	"*-dev", "*-devel",
+}
+
+// Offenders looks for the usual causes of bloat in the RUN directives of
+// the final stage of def: package manager caches left in the layer that
+// created them, and build dependencies installed and never removed.
+// report supplies the layer sizes.
+func Offenders(def *ir.Definition, report Report) []Finding {
+	sizes := map[int]int64{}
+	for _, s := range report.Steps {
+		sizes[s.Step] = s.Size
+	}
+	final := len(def.Directives) - len(def.FinalStage())
+
+	var findings []Finding
+	installed := map[string]int{}
+	removed := map[string]bool{}
+	for i := final; i < len(def.Directives); i++ {
+		cmd := runCommand(def.Directives[i].Directive)
+		if cmd == "" {
+			continue
+		}
+		add := func(msg string) {
+			findings = append(findings, Finding{Step: i, Size: sizes[i], Message: msg})
+		}
+		for _, c := range shellCommands(cmd) {
+			manager, verb, args := packageCommand(c)
+			switch {
+			case manager == "":
+			case verb == "install":
+				for _, pkg := range args {
+					if _, ok := installed[pkg]; !ok {
+						installed[pkg] = i
+					}
+				}
+			case verb == "remove" || verb == "purge" || verb == "erase":
+				for _, pkg := range args {
+					removed[pkg] = true
+				}
+			}
+		}
+		switch {
+		case installs(cmd, "apt-get", "apt") && !strings.Contains(cmd, "/var/lib/apt/lists"):
+			add("apt-get install without removing /var/lib/apt/lists/* in the same RUN")
+		case installs(cmd, "yum", "dnf", "microdnf") && !strings.Contains(cmd, "clean all") && !strings.Contains(cmd, "/var/cache/"):
+			add("yum/dnf install without \"clean all\" in the same RUN")
+		}
+		if installs(cmd, "pip", "pip3") && !strings.Contains(cmd, "--no-cache-dir") && !strings.Contains(cmd, "pip cache purge") && !strings.Contains(cmd, ".cache/pip") {
+			add("pip install without --no-cache-dir")
+		}
+		if installs(cmd, "conda", "mamba", "micromamba") && !strings.Contains(cmd, " clean ") {
+			add("conda install without \"conda clean --all\" in the same RUN")
+		}
+	}
+
+	left := map[int][]string{}
+	for pkg, step := range installed {
+		if !removed[pkg] && isBuildDep(pkg) {
+			left[step] = append(left[step], pkg)
+		}
+	}
+	for step, pkgs := range left {
+		sort.Strings(pkgs)
+		findings = append(findings, Finding{Step: step, Size: sizes[step], Message: "build dependencies left installed: " + strings.Join(pkgs, ", ")})
+	}
+	sortFindings(findings)
+	return findings
+}
+
+// CacheFindings reports the CacheDirs measured in the image (path to size)
+// that hold at least min bytes.
+func CacheFindings(sizes map[string]int64, min int64) []Finding {
+	var out []Finding
+	for _, dir := range CacheDirs {
+		if n := sizes[dir]; n >= min && n > 0 {
+			out = append(out, Finding{Step: -1, Size: n, Message: dir + " is not empty in the image"})
+		}
+	}
+	sortFindings(out)
+	return out
+}
+
+func sortFindings(f []Finding) {
+	sort.SliceStable(f, func(i, j int) bool {
+		if f[i].Size != f[j].Size {
+			return f[i].Size > f[j].Size
+		}
+		return f[i].Step < f[j].Step
+	})
+}
+
+// runCommand returns the command of a RUN directive. The mounts of a RUN
+// with mounts come first, on a line of their own, so a cache mounted at a
+// cache directory counts as cleaning it.
+func runCommand(d ir.Directive) string {
+	switch v := d.(type) {
+	case ir.RunDirective:
+		return string(v)
+	case ir.RunWithMountsDirective:
+		return strings.Join(v.Mounts, " ") + "\n" + v.Command
+	}
+	return ""
+}
+
+// shellCommands splits a shell command line into its simple commands.
+func shellCommands(cmd string) [][]string {
+	var out [][]string
+	var cur []string
+	for _, f := range strings.Fields(strings.NewReplacer("\\\n", " ", ";", " ; ", "&&", " && ", "||", " || ", "|", " | ", "\n", " ; ").Replace(cmd)) {
+		switch f {
+		case ";", "&&", "||", "|":
+			if len(cur) > 0 {
+				out = append(out, cur)
+			}
+			cur = nil
+		default:
+			cur = append(cur, strings.Trim(f, `"'`))
+		}
+	}
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// packageCommand recognizes "<manager> [flags] <verb> [flags] pkgs..." and
+// returns the manager, the verb and the package names.
+func packageCommand(words []string) (manager, verb string, pkgs []string) {
+	for len(words) > 0 && (words[0] == "sudo" || strings.Contains(words[0], "=")) {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return "", "", nil
+	}
+	switch path.Base(words[0]) {
+	case "apt-get", "apt", "yum", "dnf", "microdnf":
+		manager = path.Base(words[0])
+	default:
+		return "", "", nil
+	}
+	for _, w := range words[1:] {
+		switch {
+		case strings.HasPrefix(w, "-"):
+		case verb == "":
+			verb = w
+		default:
+			// Drop version pins such as gcc=4:13.2.0-7.
+			name, _, _ := strings.Cut(w, "=")
+			pkgs = append(pkgs, name)
+		}
+	}
+	return manager, verb, pkgs
+}
+
+// installs reports whether cmd runs "<tool> ... install" for one of tools.
+func installs(cmd string, tools ...string) bool {
+	for _, words := range shellCommands(cmd) {
+		for len(words) > 0 && (words[0] == "sudo" || strings.Contains(words[0], "=")) {
+			words = words[1:]
+		}
+		if len(words) < 2 {
+			continue
+		}
+		tool := path.Base(words[0])
+		if tool == "python" || tool == "python3" {
+			if len(words) > 3 && words[1] == "-m" {
+				tool, words = words[2], words[2:]
+			}
+		}
+		for _, t := range tools {
+			if tool != t {
+				continue
+			}
+			for _, w := range words[1:] {
+				if w == "install" || w == "create" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func isBuildDep(pkg string) bool {
+	for _, p := range buildDeps {
+		if ok, _ := path.Match(p, pkg); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Change is the size of layers with the same command in two images.
+type Change struct {
+	CreatedBy string `json:"created_by"`
+	Old       int64  `json:"old"`
+	New       int64  `json:"new"`
+}
+
+// Compare matches the non-empty layers of two images by the command that
+// created them and returns the total growth and the layers that are new,
+// gone or changed in size, largest change first.
+func Compare(old, new []Layer) (delta int64, changes []Change) {
+	remaining := map[string][]int64{}
+	for _, l := range old {
+		delta -= l.Size
+		if l.Size > 0 {
+			remaining[l.CreatedBy] = append(remaining[l.CreatedBy], l.Size)
+		}
+	}
+	for _, l := range new {
+		delta += l.Size
+		if l.Size == 0 {
+			continue
+		}
+		c := Change{CreatedBy: l.CreatedBy, New: l.Size}
+		if sizes := remaining[l.CreatedBy]; len(sizes) > 0 {
+			c.Old = sizes[0]
+			remaining[l.CreatedBy] = sizes[1:]
+		}
+		if c.Old != c.New {
+			changes = append(changes, c)
+		}
+	}
+	for createdBy, sizes := range remaining {
+		for _, size := range sizes {
+			changes = append(changes, Change{CreatedBy: createdBy, Old: size})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i].New-changes[i].Old, changes[j].New-changes[j].Old
+		if a < 0 {
+			a = -a
+		}
+		if b < 0 {
+			b = -b
+		}
+		if a != b {
+			return a > b
+		}
+		return changes[i].CreatedBy < changes[j].CreatedBy
+	})
+	return delta, changes
+}
@@ -0,0 +1,98 @@
+package imagesize
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+func TestAttributeSplitsBaseAndDirectives(t *testing.T) {
+	def, err := ir.New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddEnvironment("env", map[string]string{"A": "1"}).
+		AddRunCommand("deps", "apt-get update && apt-get install -y gcc").
+		AddRunCommand("tool", "make install").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	steps, err := ir.FinalStageSteps(def)
+	if err != nil {
+		t.Fatalf("FinalStageSteps: %v", err)
+	}
+	history := []Layer{
+		{CreatedBy: "base layer", Size: 70},
+		{CreatedBy: "base cmd", Size: 0},
+		{CreatedBy: "ENV A=1", Size: 0},
+		{CreatedBy: "RUN deps", Size: 200},
+		{CreatedBy: "RUN tool", Size: 30},
+	}
+	r, err := Attribute(def, history, steps)
+	if err != nil {
+		t.Fatalf("Attribute: %v", err)
+	}
+	if r.Total != 300 || r.Base != 70 {
+		t.Fatalf("Total, Base = %d, %d; want 300, 70", r.Total, r.Base)
+	}
+	largest := r.Largest(1)
+	if len(largest) != 1 || largest[0].Step != 2 || largest[0].Size != 200 || largest[0].Description != "deps" {
+		t.Fatalf("Largest(1) = %+v", largest)
+	}
+
+	if _, err := Attribute(def, history[:2], steps); err == nil {
+		t.Fatalf("Attribute with too short a history succeeded")
+	}
+}
+
+func TestOffendersFlagsCachesAndBuildDeps(t *testing.T) {
+	def, err := ir.New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddRunCommand("apt", "apt-get update && apt-get install -y --no-install-recommends gcc make libfoo-dev curl").
+		AddRunCommand("clean", "apt-get update && apt-get install -y git && rm -rf /var/lib/apt/lists/*").
+		AddRunCommand("purge", "apt-get purge -y make").
+		AddRunCommand("pip", "python3 -m pip install numpy").
+		AddRunWithMounts("cached", []string{"type=cache,target=/root/.cache/pip"}, "pip install scipy").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	report := Report{Steps: []StepSize{{Step: 1, Size: 500}, {Step: 4, Size: 80}}}
+	var got []string
+	for _, f := range Offenders(def, report) {
+		got = append(got, fmt.Sprintf("%d %d %s", f.Step, f.Size, f.Message))
+	}
+	want := []string{
+		"1 500 apt-get install without removing /var/lib/apt/lists/* in the same RUN",
+		"1 500 build dependencies left installed: gcc, libfoo-dev",
+		"4 80 pip install without --no-cache-dir",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Offenders =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCacheFindingsSkipsSmallDirs(t *testing.T) {
+	got := CacheFindings(map[string]int64{"/var/lib/apt/lists": 40 << 20, "/tmp": 10, "/root/.cache/pip": 60 << 20}, 1<<20)
+	if len(got) != 2 || !strings.HasPrefix(got[0].Message, "/root/.cache/pip") || !strings.HasPrefix(got[1].Message, "/var/lib/apt/lists") {
+		t.Fatalf("CacheFindings = %+v", got)
+	}
+}
+
+func TestCompareMatchesLayersByCommand(t *testing.T) {
+	old := []Layer{{"base", 100}, {"ENV A=1", 0}, {"RUN install tool 1.0", 50}, {"RUN cleanup", 5}}
+	new := []Layer{{"base", 100}, {"ENV A=1", 0}, {"RUN install tool 2.0", 80}, {"RUN cleanup", 5}, {"RUN extra", 10}}
+	delta, changes := Compare(old, new)
+	if delta != 40 {
+		t.Fatalf("delta = %d; want 40", delta)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, fmt.Sprintf("%s %d->%d", c.CreatedBy, c.Old, c.New))
+	}
+	want := "RUN install tool 2.0 0->80, RUN install tool 1.0 50->0, RUN extra 0->10"
+	if strings.Join(got, ", ") != want {
+		t.Fatalf("changes = %s; want %s", strings.Join(got, ", "), want)
+	}
+}
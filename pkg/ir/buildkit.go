@@ -13,8 +13,12 @@ import (
 	"sync"
 	"time"
 
+	"github.com/containerd/platforms"
 	bkclient "github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/client/llb/sourceresolver"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gateway "github.com/moby/buildkit/frontend/gateway/client"
 )
 
 type EventType string
@@ -36,6 +40,49 @@ type Event struct {
 	VertexNames map[string]string       `json:"vertexNames,omitempty"`
 }
 
+// SubmitOptions configures Submit.
+type SubmitOptions struct {
+	// BuilderName selects the buildx builder; empty means the default builder.
+	BuilderName string
+	// Addr, when set, is the address of a standalone buildkitd to submit to
+	// instead of a buildx builder, as for buildctl --addr: e.g.
+	// tcp://buildkitd:1234 or unix:///run/buildkit/buildkitd.sock.
+	Addr string
+	// TLS configures the connection to a tcp Addr.
+	TLS *TLSConfig
+	// LocalContextDir is exposed to the LLB as llb.Local("context").
+	LocalContextDir string
+	// LocalDirs exposes further directories to the LLB as llb.Local(key).
+	LocalDirs map[string]string
+	// Exports are passed to the solve unchanged, e.g. an image exporter with
+	// push=true.
+	Exports []bkclient.ExportEntry
+	// CacheFrom lists registry image refs whose cache metadata is imported.
+	CacheFrom []string
+	// InlineCache embeds cache metadata in exported images so later builds can
+	// reuse their layers via CacheFrom. It has no effect without Exports.
+	InlineCache bool
+	// Platform is the platform the LLB was generated for (see
+	// LLBOptions.Platform); the base image config is resolved for it.
+	Platform string
+	// ImageConfig, when set, is the IR the LLB was generated from. Its
+	// runtime settings are merged into the base image config (see
+	// BuildImageConfig) and attached to the exported image.
+	ImageConfig *Definition
+	// Squash is set when the LLB was generated with LLBOptions.Squash: the
+	// layer history of the base image is left out of the image config.
+	Squash bool
+}
+
+// TLSConfig holds the certificates for a TLS connection to buildkitd, as
+// buildctl's --tlscacert, --tlscert, --tlskey and --tlsservername.
+type TLSConfig struct {
+	CACert     string
+	Cert       string
+	Key        string
+	ServerName string
+}
+
 // SubmitToDockerViaBuildx connects to the active buildx builder using
 // "docker buildx dial-stdio", submits the LLB definition produced by
 // your generator, and streams BuildKit status events as JSON.
@@ -48,6 +95,33 @@ func SubmitToDockerViaBuildx(
 	builderName string, // empty means default builder
 	localContextDir string, // e.g., "."
 	outputChannel chan Event, // optional; if nil, falls back to stdout
+) error {
+	return SubmitToDockerViaBuildxWithOptions(ctx, llbDef, SubmitOptions{
+		BuilderName:     builderName,
+		LocalContextDir: localContextDir,
+	}, outputChannel)
+}
+
+// SubmitToDockerViaBuildxWithOptions is SubmitToDockerViaBuildx with exporter
+// and cache configuration. opts.Addr is ignored.
+func SubmitToDockerViaBuildxWithOptions(
+	ctx context.Context,
+	llbDef *llb.Definition,
+	opts SubmitOptions,
+	outputChannel chan Event, // optional; if nil, falls back to stdout
+) error {
+	opts.Addr = ""
+	return Submit(ctx, llbDef, opts, outputChannel)
+}
+
+// Submit solves llbDef on the buildkitd at opts.Addr, or else on the buildx
+// builder opts.BuilderName, streaming events as SubmitToDockerViaBuildx
+// does.
+func Submit(
+	ctx context.Context,
+	llbDef *llb.Definition,
+	opts SubmitOptions,
+	outputChannel chan Event, // optional; if nil, falls back to stdout
 ) error {
 	if llbDef == nil {
 		return fmt.Errorf("empty LLB definition")
@@ -60,30 +134,27 @@ func SubmitToDockerViaBuildx(
 		return fmt.Errorf("building vertex name index: %w", err)
 	}
 
-	// Prepare a gRPC dialer that talks to buildx over stdio.
-	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
-		return dialBuildxStdio(ctx, builderName)
-	}
-
-	// Connect BuildKit client via stdio.
-	c, err := bkclient.New(
-		ctx,
-		"", // addr unused because we override with custom dialer
-		bkclient.WithContextDialer(dialer),
-	)
+	c, err := newClient(ctx, opts)
 	if err != nil {
 		return fmt.Errorf("buildkit client: %w", err)
 	}
 	defer c.Close()
 
-	// Map local dirs for llb.Local("context") if used in the LLB.
+	// Map local dirs for llb.Local(key) if used in the LLB.
 	localDirs := map[string]string{}
-	if localContextDir != "" {
-		abs, err := filepath.Abs(localContextDir)
+	dirs := map[string]string{}
+	for k, v := range opts.LocalDirs {
+		dirs[k] = v
+	}
+	if opts.LocalContextDir != "" {
+		dirs["context"] = opts.LocalContextDir
+	}
+	for key, dir := range dirs {
+		abs, err := filepath.Abs(dir)
 		if err != nil {
-			return fmt.Errorf("resolve local context dir: %w", err)
+			return fmt.Errorf("resolve local dir %s: %w", key, err)
 		}
-		localDirs["context"] = abs
+		localDirs[key] = abs
 	}
 
 	statusCh := make(chan *bkclient.SolveStatus, 16)
@@ -128,19 +199,16 @@ func SubmitToDockerViaBuildx(
 	}()
 
 	// Kick off the solve.
-	resp, err := c.Solve(ctx, llbDef, bkclient.SolveOpt{
-		LocalDirs: localDirs,
-		// Add exporters here if you want to push or load the result, e.g.:
-		// Exports: []bkclient.ExportEntry{
-		// 	{
-		// 		Type: bkclient.ExporterDocker, // or bkclient.ExporterOCI
-		// 		Attrs: map[string]string{
-		// 			"name": "your-image:tag",
-		// 			"push": "true",
-		// 		},
-		// 	},
-		// },
-	}, statusCh)
+	var resp *bkclient.SolveResponse
+	if opts.ImageConfig != nil {
+		var build gateway.BuildFunc
+		if build, err = imageBuildFunc(llbDef, opts.ImageConfig, opts.Platform, opts.Squash); err != nil {
+			return err
+		}
+		resp, err = c.Build(ctx, solveOptions(opts, localDirs), "", build, statusCh)
+	} else {
+		resp, err = c.Solve(ctx, llbDef, solveOptions(opts, localDirs), statusCh)
+	}
 	if err != nil {
 		slog.Error("buildkit solve error", "error", err)
 	}
@@ -178,13 +246,106 @@ func SubmitToDockerViaBuildx(
 	return nil
 }
 
+// newClient connects to the buildkitd at opts.Addr, or through
+// "docker buildx dial-stdio" to a buildx builder.
+func newClient(ctx context.Context, opts SubmitOptions) (*bkclient.Client, error) {
+	if opts.Addr != "" {
+		var clientOpts []bkclient.ClientOpt
+		if t := opts.TLS; t != nil {
+			if t.CACert != "" {
+				clientOpts = append(clientOpts, bkclient.WithServerConfig(t.ServerName, t.CACert))
+			} else {
+				clientOpts = append(clientOpts, bkclient.WithServerConfigSystem(t.ServerName))
+			}
+			if t.Cert != "" || t.Key != "" {
+				clientOpts = append(clientOpts, bkclient.WithCredentials(t.Cert, t.Key))
+			}
+		}
+		return bkclient.New(ctx, opts.Addr, clientOpts...)
+	}
+
+	// Prepare a gRPC dialer that talks to buildx over stdio.
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialBuildxStdio(ctx, opts.BuilderName)
+	}
+	return bkclient.New(
+		ctx,
+		"", // addr unused because we override with custom dialer
+		bkclient.WithContextDialer(dialer),
+	)
+}
+
+// solveOptions maps SubmitOptions onto a BuildKit SolveOpt.
+func solveOptions(opts SubmitOptions, localDirs map[string]string) bkclient.SolveOpt {
+	so := bkclient.SolveOpt{
+		LocalDirs: localDirs,
+		Exports:   opts.Exports,
+	}
+	for _, ref := range opts.CacheFrom {
+		so.CacheImports = append(so.CacheImports, bkclient.CacheOptionsEntry{
+			Type:  "registry",
+			Attrs: map[string]string{"ref": ref},
+		})
+	}
+	if opts.InlineCache && len(opts.Exports) > 0 {
+		so.CacheExports = append(so.CacheExports, bkclient.CacheOptionsEntry{Type: "inline"})
+	}
+	return so
+}
+
+// imageBuildFunc solves llbDef through the gateway so the result can carry an
+// image config, which a plain Solve of an LLB definition cannot. A squashed
+// image gets the config without the base image's history and rootfs, which
+// the exporter rebuilds for its single layer.
+func imageBuildFunc(llbDef *llb.Definition, def *Definition, platform string, squash bool) (gateway.BuildFunc, error) {
+	resolveOpt := sourceresolver.Opt{
+		ImageOpt: &sourceresolver.ResolveImageOpt{ResolveMode: "default"},
+	}
+	if platform != "" {
+		p, err := platforms.Parse(platform)
+		if err != nil {
+			return nil, fmt.Errorf("platform %q: %w", platform, err)
+		}
+		p = platforms.Normalize(p)
+		resolveOpt.Platform = &p
+	}
+	return func(ctx context.Context, gc gateway.Client) (*gateway.Result, error) {
+		var base []byte
+		if ref := def.BaseImage(); ref != "" {
+			_, _, cfg, err := gc.ResolveImageConfig(ctx, ref, resolveOpt)
+			if err != nil {
+				return nil, fmt.Errorf("resolving image config for %s: %w", ref, err)
+			}
+			base = cfg
+		}
+		cfg, err := BuildImageConfig(def, base)
+		if err != nil {
+			return nil, err
+		}
+		if squash {
+			if cfg, err = withoutLayerHistory(cfg); err != nil {
+				return nil, err
+			}
+		}
+		res, err := gc.Solve(ctx, gateway.SolveRequest{
+			Definition: llbDef.ToPB(),
+			Evaluate:   true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		res.AddMeta(exptypes.ExporterImageConfigKey, cfg)
+		return res, nil
+	}, nil
+}
+
 // buildVertexNameIndex extracts digest->custom name mapping from LLB metadata.
 // Names come from llb.WithCustomName/WithCustomNamef set during LLB creation.
 func buildVertexNameIndex(def *llb.Definition) (map[string]string, error) {
 	out := make(map[string]string, len(def.Metadata))
 	_ = out
 	for dgst, meta := range def.Metadata {
-		customName, ok := meta.Description["lib.customname"]
+		customName, ok := meta.Description["llb.customname"]
 		if !ok {
 			continue
 		}
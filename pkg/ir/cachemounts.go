@@ -0,0 +1,139 @@
+package ir
+
+import (
+	"regexp"
+	"strings"
+)
+
+// packageCache describes the download cache of a package manager and how a
+// RUN that uses it is adapted to keep the cache in a cache mount.
+type packageCache struct {
+	// uses matches commands that run the package manager.
+	uses *regexp.Regexp
+	// mounts are the cache mounts the RUN gets.
+	mounts []string
+	// rewrite adapts the command, e.g. so downloads are kept.
+	rewrite func(cmd string) string
+}
+
+// commandStart matches where a command name can start in a shell command:
+// the start, or after whitespace or an operator, and an optional bin
+// directory such as /usr/bin/ or /opt/conda/condabin/. Paths that merely end
+// in a package manager's name, like /var/cache/yum, do not match.
+const commandStart = `(^|[\s;&|(])(\S*bin/)?`
+
+// managerFlags matches the options before a package manager's command,
+// such as -y or -o Acquire::Retries=3.
+const managerFlags = `((-o\s+\S+|-\S+)\s+)*`
+
+var aptKeepDownloads = "rm -f /etc/apt/apt.conf.d/docker-clean && " +
+	`echo 'Binary::apt::APT::Keep-Downloaded-Packages "true";' > /etc/apt/apt.conf.d/keep-cache && `
+
+var (
+	yumCommand = regexp.MustCompile(commandStart + `(yum)(\s)`)
+	dnfCommand = regexp.MustCompile(commandStart + `(dnf)(\s)`)
+)
+
+var packageCaches = []packageCache{
+	{
+		uses: regexp.MustCompile(commandStart + `apt(-get)?\s+` + managerFlags + `(install|update|upgrade|dist-upgrade|full-upgrade)\b`),
+		mounts: []string{
+			"--mount=type=cache,target=/var/cache/apt,sharing=locked",
+			"--mount=type=cache,target=/var/lib/apt/lists,sharing=locked",
+		},
+		// Debian and Ubuntu images delete downloaded packages after every
+		// install; the lists are in the cache mount, so removing them
+		// would only empty the cache.
+		rewrite: func(cmd string) string {
+			cmd = strings.ReplaceAll(cmd, "rm -rf /var/lib/apt/lists/*", "true")
+			return aptKeepDownloads + cmd
+		},
+	},
+	{
+		uses:    regexp.MustCompile(commandStart + `yum\s+` + managerFlags + `(install|update|upgrade|groupinstall|makecache)\b`),
+		mounts:  []string{"--mount=type=cache,target=/var/cache/yum,sharing=locked"},
+		rewrite: func(cmd string) string { return yumCommand.ReplaceAllString(cmd, "$1$2$3 --setopt=keepcache=1$4") },
+	},
+	{
+		uses:    regexp.MustCompile(commandStart + `dnf\s+` + managerFlags + `(install|update|upgrade|groupinstall|makecache)\b`),
+		mounts:  []string{"--mount=type=cache,target=/var/cache/dnf,sharing=locked"},
+		rewrite: func(cmd string) string { return dnfCommand.ReplaceAllString(cmd, "$1$2$3 --setopt=keepcache=True$4") },
+	},
+	{
+		uses:   regexp.MustCompile(commandStart + `pip3?\s+(\S+\s+)*install\s`),
+		mounts: []string{"--mount=type=cache,target=/root/.cache/pip"},
+		rewrite: func(cmd string) string {
+			return strings.ReplaceAll(cmd, " --no-cache-dir", "")
+		},
+	},
+	{
+		uses:   regexp.MustCompile(commandStart + `(conda|mamba|micromamba)\s+(\S+\s+)*(install|create|update)\s`),
+		mounts: []string{"--mount=type=cache,target=/var/cache/conda/pkgs,sharing=locked"},
+		// conda keeps packages wherever CONDA_PKGS_DIRS points; cleaning
+		// the tarballs and packages would empty the cache.
+		rewrite: func(cmd string) string {
+			cmd = strings.ReplaceAll(cmd, "clean --all", "clean --index-cache")
+			return "export CONDA_PKGS_DIRS=/var/cache/conda/pkgs && " + cmd
+		},
+	},
+}
+
+// WithCacheMounts returns d with every RUN that installs packages with
+// apt, yum, dnf, pip or conda given cache mounts at the package manager's
+// download cache, so repeated builds reuse downloads instead of fetching
+// them again. Commands are adapted to keep their downloads, and cleanups
+// that would empty a mounted cache are dropped; the caches never reach the
+// image either way.
+func (d *Definition) WithCacheMounts() *Definition {
+	out := &Definition{Directives: make([]DirectiveWithMetadata, len(d.Directives))}
+	for i, dm := range d.Directives {
+		out.Directives[i] = dm
+		var run RunWithMountsDirective
+		switch v := dm.Directive.(type) {
+		case RunDirective:
+			run = RunWithMountsDirective{Command: string(v)}
+		case RunWithMountsDirective:
+			run = RunWithMountsDirective{Mounts: append([]string{}, v.Mounts...), Command: v.Command}
+		default:
+			continue
+		}
+		changed := false
+		for _, pc := range packageCaches {
+			if !pc.uses.MatchString(run.Command) {
+				continue
+			}
+			for _, m := range pc.mounts {
+				if !hasMountTarget(run.Mounts, mountTarget(m)) {
+					run.Mounts = append(run.Mounts, m)
+				}
+			}
+			run.Command = pc.rewrite(run.Command)
+			changed = true
+		}
+		if changed {
+			out.Directives[i].Directive = run
+		}
+	}
+	return out
+}
+
+// mountTarget returns the target of a --mount flag.
+func mountTarget(spec string) string {
+	for _, f := range strings.Split(strings.TrimPrefix(spec, "--mount="), ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(f), "=")
+		switch key {
+		case "target", "dst", "destination":
+			return val
+		}
+	}
+	return ""
+}
+
+func hasMountTarget(mounts []string, target string) bool {
+	for _, m := range mounts {
+		if mountTarget(m) == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWithCacheMountsMountsPackageCaches(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddRunCommand("apt", "apt-get update && apt-get install -y curl && rm -rf /var/lib/apt/lists/*").
+		AddRunCommand("yum", "yum install -y curl && yum clean all && rm -rf /var/cache/yum").
+		AddRunWithMounts("pip", []string{"--mount=type=cache,target=/root/.cache/pip"}, "/opt/conda/bin/python -m pip install --no-cache-dir numpy").
+		AddRunCommand("conda", "/opt/miniconda/condabin/conda create -y -n env python && conda clean --all --yes").
+		AddRunCommand("other", "echo apt is not run here > /var/lib/apt.txt").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	got := def.WithCacheMounts()
+
+	apt, ok := got.Directives[1].Directive.(RunWithMountsDirective)
+	if !ok || len(apt.Mounts) != 2 || !strings.HasPrefix(apt.Command, aptKeepDownloads) || strings.Contains(apt.Command, "rm -rf /var/lib/apt/lists") {
+		t.Fatalf("apt RUN = %#v", got.Directives[1].Directive)
+	}
+	yum, ok := got.Directives[2].Directive.(RunWithMountsDirective)
+	if !ok || yum.Command != "yum --setopt=keepcache=1 install -y curl && yum --setopt=keepcache=1 clean all && rm -rf /var/cache/yum" {
+		t.Fatalf("yum RUN = %#v", got.Directives[2].Directive)
+	}
+	pip, ok := got.Directives[3].Directive.(RunWithMountsDirective)
+	if !ok || len(pip.Mounts) != 1 || strings.Contains(pip.Command, "--no-cache-dir") {
+		t.Fatalf("pip RUN = %#v", got.Directives[3].Directive)
+	}
+	conda, ok := got.Directives[4].Directive.(RunWithMountsDirective)
+	if !ok || mountTarget(conda.Mounts[0]) != "/var/cache/conda/pkgs" || !strings.Contains(conda.Command, "CONDA_PKGS_DIRS=/var/cache/conda/pkgs") || strings.Contains(conda.Command, "--all") {
+		t.Fatalf("conda RUN = %#v", got.Directives[4].Directive)
+	}
+	if _, ok := got.Directives[5].Directive.(RunDirective); !ok {
+		t.Fatalf("unrelated RUN rewritten: %#v", got.Directives[5].Directive)
+	}
+	if _, ok := def.Directives[1].Directive.(RunDirective); !ok {
+		t.Fatalf("WithCacheMounts modified its receiver")
+	}
+	if _, err := GenerateLLBDefinition(got); err != nil {
+		t.Fatalf("GenerateLLBDefinition: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package docker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+)
+
+// CopyInstruction is a COPY instruction of a Dockerfile, as BuildKit reads
+// it.
+type CopyInstruction struct {
+	// Line is the 1-based line the instruction starts on.
+	Line int
+	// Stage is the index of the stage the instruction is in.
+	Stage int
+	// From is the value of --from, or "" when the sources are in the build
+	// context.
+	From string
+	// FromStage is the index of the stage From names, or -1 when From is
+	// empty or an image.
+	FromStage int
+	// Src are the source paths, unquoted and with the build args and
+	// environment variables of the stage expanded.
+	Src []string
+	// Heredocs are the names of the sources given as heredocs, whose
+	// content is in the Dockerfile itself.
+	Heredocs []string
+	Dest     string
+}
+
+// CopyInstructions returns the COPY instructions of dockerfile in order. A
+// --from that BuildKit would reject is an error: a build arg, a stage index
+// out of range, the instruction's own stage, or a name that is neither a
+// stage nor a valid image reference.
+func CopyInstructions(dockerfile string) ([]CopyInstruction, error) {
+	res, err := parser.Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		return nil, err
+	}
+	stages, metaArgs, err := instructions.Parse(res.AST, nil)
+	if err != nil {
+		return nil, err
+	}
+	lex := shell.NewLex(res.EscapeToken)
+
+	// ARGs before the first FROM are visible in a stage that declares them
+	// again without a value.
+	meta := map[string]string{}
+	for _, a := range metaArgs {
+		for _, kv := range a.Args {
+			if kv.Value == nil {
+				continue
+			}
+			v, _, err := lex.ProcessWord(*kv.Value, envGetter(meta))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: ARG %s: %w", a.Location()[0].Start.Line, kv.Key, err)
+			}
+			meta[kv.Key] = v
+		}
+	}
+	byName := map[string]int{}
+	for i, s := range stages {
+		if s.Name != "" {
+			byName[strings.ToLower(s.Name)] = i
+		}
+	}
+
+	var out []CopyInstruction
+	for i, s := range stages {
+		env := map[string]string{}
+		expand := func(word string) (string, error) {
+			v, _, err := lex.ProcessWord(word, envGetter(env))
+			return v, err
+		}
+		for _, cmd := range s.Commands {
+			line := 0
+			if loc := cmd.Location(); len(loc) > 0 {
+				line = loc[0].Start.Line
+			}
+			switch c := cmd.(type) {
+			case *instructions.ArgCommand:
+				for _, kv := range c.Args {
+					if kv.Value == nil {
+						if v, ok := meta[kv.Key]; ok {
+							env[kv.Key] = v
+						}
+						continue
+					}
+					v, err := expand(*kv.Value)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: ARG %s: %w", line, kv.Key, err)
+					}
+					env[kv.Key] = v
+				}
+			case *instructions.EnvCommand:
+				for _, kv := range c.Env {
+					v, err := expand(kv.Value)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: ENV %s: %w", line, kv.Key, err)
+					}
+					env[kv.Key] = v
+				}
+			case *instructions.CopyCommand:
+				if err := c.Expand(expand); err != nil {
+					return nil, fmt.Errorf("line %d: COPY: %w", line, err)
+				}
+				cp := CopyInstruction{
+					Line:      line,
+					Stage:     i,
+					From:      c.From,
+					FromStage: -1,
+					Src:       c.SourcePaths,
+					Dest:      c.DestPath,
+				}
+				for _, h := range c.SourceContents {
+					cp.Heredocs = append(cp.Heredocs, h.Path)
+				}
+				if c.From != "" {
+					if cp.FromStage, err = copyFromStage(c.From, i, len(stages), byName); err != nil {
+						return nil, fmt.Errorf("line %d: COPY --from=%s: %w", line, c.From, err)
+					}
+				}
+				out = append(out, cp)
+			}
+		}
+	}
+	return out, nil
+}
+
+// copyFromStage resolves the --from of a COPY in stage current the way
+// BuildKit does: a number is a stage index, a stage name names that stage,
+// and anything else is an image, for which it returns -1.
+func copyFromStage(from string, current, stages int, byName map[string]int) (int, error) {
+	if strings.ContainsAny(from, "$") {
+		return 0, fmt.Errorf("build args are not expanded in --from")
+	}
+	stage, ok := byName[strings.ToLower(from)]
+	if index, err := strconv.Atoi(from); err == nil {
+		if index < 0 || index >= stages {
+			return 0, fmt.Errorf("invalid stage index %d", index)
+		}
+		stage, ok = index, true
+	}
+	if !ok {
+		if _, err := reference.ParseNormalizedNamed(from); err != nil {
+			return 0, fmt.Errorf("not a stage, and not an image: %w", err)
+		}
+		return -1, nil
+	}
+	if stage == current {
+		return 0, fmt.Errorf("a stage cannot copy from itself")
+	}
+	return stage, nil
+}
+
+// envGetter is a shell.EnvGetter over a map.
+type envGetter map[string]string
+
+func (e envGetter) Get(key string) (string, bool) {
+	v, ok := e[key]
+	return v, ok
+}
+
+func (e envGetter) Keys() []string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	return keys
+}
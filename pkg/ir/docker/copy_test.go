@@ -0,0 +1,73 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCopyInstructions(t *testing.T) {
+	dockerfile := `# syntax=docker/dockerfile:1.7
+ARG DIR=/opt
+FROM ubuntu:24.04 AS build
+RUN make
+FROM ubuntu:24.04
+ARG DIR
+ENV NAME="my tool"
+COPY "cache/a.tar.gz" \
+    "cache/b.tar.gz" "$DIR/"
+COPY ["cache/my file", "/opt/${NAME}/"]
+COPY --from=build /out /opt/out
+COPY --from=0 /out /opt/out0
+COPY --from=busybox:1.36 /bin/busybox /bin/
+COPY --chmod=755 <<EOF /usr/local/bin/hello
+#!/bin/sh
+echo hello
+EOF
+`
+	copies, err := CopyInstructions(dockerfile)
+	if err != nil {
+		t.Fatalf("CopyInstructions: %v", err)
+	}
+	var got []string
+	for _, c := range copies {
+		got = append(got, fmt.Sprintf("%d stage=%d from=%q/%d src=%q heredocs=%q dest=%q", c.Line, c.Stage, c.From, c.FromStage, c.Src, c.Heredocs, c.Dest))
+	}
+	want := []string{
+		`8 stage=1 from=""/-1 src=["cache/a.tar.gz" "cache/b.tar.gz"] heredocs=[] dest="/opt/"`,
+		`10 stage=1 from=""/-1 src=["cache/my file"] heredocs=[] dest="/opt/my tool/"`,
+		`11 stage=1 from="build"/0 src=["/out"] heredocs=[] dest="/opt/out"`,
+		`12 stage=1 from="0"/0 src=["/out"] heredocs=[] dest="/opt/out0"`,
+		`13 stage=1 from="busybox:1.36"/-1 src=["/bin/busybox"] heredocs=[] dest="/bin/"`,
+		`14 stage=1 from=""/-1 src=[] heredocs=["EOF"] dest="/usr/local/bin/hello"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("CopyInstructions =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCopyInstructionsRejectsInvalidFrom(t *testing.T) {
+	for from, want := range map[string]string{
+		"build":    "a stage cannot copy from itself",
+		"2":        "invalid stage index 2",
+		"$STAGE":   "build args are not expanded in --from",
+		"Not:Good": "not a stage, and not an image",
+	} {
+		dockerfile := "FROM ubuntu:24.04 AS build\nCOPY --from=" + from + " /out /opt/out\n"
+		_, err := CopyInstructions(dockerfile)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("--from=%s: CopyInstructions = %v; want an error containing %q", from, err, want)
+		}
+		if !strings.Contains(err.Error(), "line 2: COPY --from="+from) {
+			t.Fatalf("--from=%s: error %q does not name the line", from, err)
+		}
+	}
+}
+
+func TestCopyInstructionsReportsUnterminatedQuotes(t *testing.T) {
+	// The shell form splits on whitespace even inside quotes.
+	_, err := CopyInstructions("FROM ubuntu:24.04\nCOPY \"my file\" /opt/\n")
+	if err == nil || !strings.Contains(err.Error(), "line 2: COPY") {
+		t.Fatalf("CopyInstructions = %v; want an error for line 2", err)
+	}
+}
@@ -4,16 +4,22 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 )
 
 // Directive represents a single Dockerfile directive in a tiny AST.
 // Implementations below intentionally keep just the data needed.
 type Directive interface{ isDirective() }
 
-// From emits `FROM <Image>`
-type From struct{ Image string }
+// From emits `FROM <Image>`, or `FROM <Image> AS <Name>` for a named stage.
+// It starts a new stage: SHELL and user bookkeeping are reset.
+type From struct {
+	Image string
+	Name  string
+}
 
 func (From) isDirective() {}
 
@@ -23,9 +29,15 @@ type Env map[string]string
 
 func (Env) isDirective() {}
 
+// Label emits a single grouped LABEL block, rendered like Env.
+type Label map[string]string
+
+func (Label) isDirective() {}
+
 // Run emits a RUN instruction. We render using exec form with
 // ["/bin/bash", "-lc", <Command>] to preserve shell semantics and
-// avoid fragile quoting/word-splitting.
+// avoid fragile quoting/word-splitting. A command of several lines is
+// rendered as a heredoc instead, run by the same shell.
 type Run struct{ Command string }
 
 func (Run) isDirective() {}
@@ -39,14 +51,28 @@ type RunWithMounts struct {
 
 func (RunWithMounts) isDirective() {}
 
-// Copy emits `COPY <srcs...> <dest>`
+// Copy emits `COPY <srcs...> <dest>`, or `COPY --from=<From> ...` when From
+// names an earlier stage.
 type Copy struct {
+	From string
 	Src  []string
 	Dest string
 }
 
 func (Copy) isDirective() {}
 
+// File emits `COPY <<EOF <Path>` with Contents as the heredoc, or
+// `COPY <Source> <Path>` when Source names a file of the build context,
+// writing the file as the current user, with mode 755 when Executable.
+type File struct {
+	Path       string
+	Contents   string
+	Source     string
+	Executable bool
+}
+
+func (File) isDirective() {}
+
 // Workdir emits `WORKDIR <dir>`
 type Workdir string
 
@@ -67,6 +93,56 @@ type ExecEntryPoint []string
 
 func (ExecEntryPoint) isDirective() {}
 
+// Cmd emits CMD in JSON exec-form with argv array.
+type Cmd []string
+
+func (Cmd) isDirective() {}
+
+// Healthcheck emits `HEALTHCHECK [options] CMD <argv>`, or `HEALTHCHECK NONE`
+// when Command is empty. Zero options are omitted.
+type Healthcheck struct {
+	Command     []string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+}
+
+func (Healthcheck) isDirective() {}
+
+// Expose emits `EXPOSE <port>...`
+type Expose []string
+
+func (Expose) isDirective() {}
+
+// Volume emits VOLUME in JSON form.
+type Volume []string
+
+func (Volume) isDirective() {}
+
+// Shell emits SHELL in JSON form. Later Run, RunWithMounts and EntryPoint
+// directives are rendered with this argv prefix instead of the default
+// ["/bin/sh", "-lec"].
+type Shell []string
+
+func (Shell) isDirective() {}
+
+// Arg emits `ARG <Name>` or `ARG <Name>="<Default>"`.
+type Arg struct {
+	Name       string
+	Default    string
+	HasDefault bool
+}
+
+func (Arg) isDirective() {}
+
+// Comment emits each line of its text prefixed with `# `. A comment before a
+// FROM that starts a later stage is kept next to it, after the blank
+// separator line.
+type Comment string
+
+func (Comment) isDirective() {}
+
 // normalizeRunCommand removes blank spacer lines that follow a trailing backslash
 // line-continuation. Templates sometimes emit additional blank lines for readability,
 // but in a shell script they terminate the continued command, causing subsequent
@@ -122,6 +198,134 @@ func normalizeRunCommand(cmd string) string {
 	return b.String()
 }
 
+// heredoc renders body as a heredoc with a quoted delimiter, so BuildKit
+// passes it on unexpanded: the <<'EOF' word for the instruction line and the
+// lines that follow it, ending with the delimiter. The delimiter is EOF, or
+// EOF1, EOF2 and so on when body has a line EOF.
+func heredoc(body string) (word, lines string) {
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	bodyLines := strings.Split(strings.ReplaceAll(body, "\r", ""), "\n")
+	delim := "EOF"
+	for i := 1; slices.Contains(bodyLines, delim); i++ {
+		delim = fmt.Sprintf("EOF%d", i)
+	}
+	return "<<'" + delim + "'", body + delim
+}
+
+// runHeredoc returns the RUN arguments running command from a heredoc, if
+// it has several lines. BuildKit runs a heredoc with the SHELL set in the
+// Dockerfile, so while none is, a shebang for the default shell, without
+// -c, keeps the login shell and errexit of the exec form.
+func runHeredoc(shell []string, shellSet bool, command string) (string, bool) {
+	if !strings.Contains(strings.TrimRight(command, "\n"), "\n") {
+		return "", false
+	}
+	if !shellSet {
+		command = "#!/bin/sh -le\n" + command
+	} else if strings.HasPrefix(command, "#!") {
+		// BuildKit would run the heredoc as a script of its own.
+		return "", false
+	}
+	word, lines := heredoc(command)
+	return word + "\n" + lines, true
+}
+
+// copyArgs renders the sources and destination of a COPY.
+func copyArgs(paths []string) (string, error) {
+	if slices.ContainsFunc(paths, func(p string) bool { return strings.ContainsAny(p, " \t") }) {
+		// The parser splits the shell form on whitespace, quoted or not;
+		// only the JSON form keeps such paths whole.
+		jb, err := encodeArgv(paths)
+		if err != nil {
+			return "", fmt.Errorf("encoding COPY paths: %w", err)
+		}
+		return jb, nil
+	}
+	// Quote each path to handle special chars robustly.
+	quoted := make([]string, len(paths))
+	for i, p := range paths {
+		quoted[i] = fmt.Sprintf("%q", p)
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// encodeArgv JSON-encodes an exec-form argv without HTML escaping.
+func encodeArgv(argv []string) (string, error) {
+	if argv == nil {
+		argv = []string{}
+	}
+	var jbuf bytes.Buffer
+	enc := json.NewEncoder(&jbuf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(argv); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(jbuf.String(), "\n"), nil
+}
+
+// escapeValue prepares val for use inside a double-quoted ENV, LABEL or ARG
+// value.
+func escapeValue(val string) string {
+	// Normalize whitespace (including newlines and tabs) to single spaces to
+	// avoid accidental new Dockerfile instructions when templates emit
+	// multi-line values (e.g., LD_LIBRARY_PATH blocks).
+	if strings.IndexByte(val, '\n') >= 0 || strings.IndexByte(val, '\r') >= 0 || strings.IndexByte(val, '\t') >= 0 {
+		val = strings.Join(strings.Fields(val), " ")
+	}
+	// Minimal escaping for double quotes and backslashes.
+	esc := make([]rune, 0, len(val))
+	for _, r := range val {
+		switch r {
+		case '"':
+			esc = append(esc, '\\', '"')
+		case '\\':
+			esc = append(esc, '\\', '\\')
+		default:
+			esc = append(esc, r)
+		}
+	}
+	return string(esc)
+}
+
+// writeKeyValueBlock renders a grouped KEY="value" instruction (ENV, LABEL)
+// with one pair per line.
+func writeKeyValueBlock(writeLine func(string, ...any), instr string, v map[string]string) {
+	// Stable key order for deterministic output.
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// Render as a single grouped instruction with continuations.
+	// Values are quoted to be safe for spaces/special chars.
+	for i, k := range keys {
+		esc := escapeValue(v[k])
+		if i == 0 {
+			if len(keys) == 1 {
+				writeLine("%s %s=\"%s\"", instr, k, esc)
+			} else {
+				writeLine("%s %s=\"%s\" \\", instr, k, esc)
+			}
+		} else if i == len(keys)-1 {
+			writeLine("    %s=\"%s\"", k, esc)
+		} else {
+			writeLine("    %s=\"%s\" \\", k, esc)
+		}
+	}
+}
+
+// nextInstruction returns the first directive of dirs that is not a Comment.
+func nextInstruction(dirs []Directive) Directive {
+	for _, d := range dirs {
+		if _, ok := d.(Comment); !ok {
+			return d
+		}
+	}
+	return nil
+}
+
 // RenderDockerfile converts the directive list into a Dockerfile string.
 func RenderDockerfile(dirs []Directive) (string, error) {
 	var buf bytes.Buffer
@@ -135,70 +339,76 @@ func RenderDockerfile(dirs []Directive) (string, error) {
 		fmt.Fprintf(&buf, format+"\n", a...)
 	}
 
+	// Shell-form commands are rendered in exec form with this prefix.
+	// shellSet records that a SHELL instruction set it.
+	shell := []string{"/bin/sh", "-lec"}
+	shellSet := false
+	// user is the user set by the last USER of the stage.
+	user := ""
+	stages := 0
+	// separated records that the blank line before the next FROM has been
+	// written ahead of its comment.
+	separated := false
+
 	// Hint Docker/BuildKit features required by RUN --mount, heredocs, etc.
 	writeLine("# syntax=docker/dockerfile:1.7")
 	writeLine("")
 
-	for _, d := range dirs {
+	for i, d := range dirs {
 		switch v := d.(type) {
+		case Comment:
+			if _, ok := nextInstruction(dirs[i+1:]).(From); ok && stages > 0 && !separated {
+				writeLine("")
+				separated = true
+			}
+			for _, line := range strings.Split(string(v), "\n") {
+				writeLine("# %s", line)
+			}
+
 		case From:
 			if v.Image == "" {
 				return "", fmt.Errorf("FROM: empty image")
 			}
-			writeLine("FROM %s", v.Image)
+			if stages > 0 && !separated {
+				// Separate stages visually.
+				writeLine("")
+			}
+			separated = false
+			stages++
+			if v.Name != "" {
+				writeLine("FROM %s AS %s", v.Image, v.Name)
+			} else {
+				writeLine("FROM %s", v.Image)
+			}
+			createdUsers = map[string]struct{}{"root": {}}
+			shell = []string{"/bin/sh", "-lec"}
+			shellSet = false
+			user = ""
 
 		case Env:
 			if len(v) == 0 {
 				// Skip empty ENV blocks
 				continue
 			}
-			// Stable key order for deterministic output.
-			keys := make([]string, 0, len(v))
-			for k := range v {
-				keys = append(keys, k)
-			}
-			sort.Strings(keys)
-			// Render as a single grouped ENV with continuations.
-			// Values are quoted to be safe for spaces/special chars.
-			for i, k := range keys {
-				val := v[k]
-				// Normalize whitespace (including newlines and tabs) to single spaces to
-				// avoid accidental new Dockerfile instructions when templates emit
-				// multi-line values (e.g., LD_LIBRARY_PATH blocks).
-				if strings.IndexByte(val, '\n') >= 0 || strings.IndexByte(val, '\r') >= 0 || strings.IndexByte(val, '\t') >= 0 {
-					val = strings.Join(strings.Fields(val), " ")
-				}
-				// Minimal escaping for double quotes and backslashes.
-				esc := make([]rune, 0, len(val))
-				for _, r := range val {
-					switch r {
-					case '"':
-						esc = append(esc, '\\', '"')
-					case '\\':
-						esc = append(esc, '\\', '\\')
-					default:
-						esc = append(esc, r)
-					}
-				}
-				if i == 0 {
-					if len(keys) == 1 {
-						writeLine("ENV %s=\"%s\"", k, string(esc))
-					} else {
-						writeLine("ENV %s=\"%s\" \\", k, string(esc))
-					}
-				} else if i == len(keys)-1 {
-					writeLine("    %s=\"%s\"", k, string(esc))
-				} else {
-					writeLine("    %s=\"%s\" \\", k, string(esc))
-				}
+			writeKeyValueBlock(writeLine, "ENV", v)
+
+		case Label:
+			if len(v) == 0 {
+				continue
 			}
+			writeKeyValueBlock(writeLine, "LABEL", v)
 
 		case Run:
-			// Use exec form to ensure correct shell parsing and robust handling
-			// of quotes, newlines, and operators. JSON-encode the argv array
-			// without HTML escaping so special characters remain as-is.
+			// A command of several lines is written as a heredoc (see
+			// runHeredoc) so it stays readable. Any other command uses the
+			// exec form, with the argv JSON-encoded without HTML escaping so
+			// quotes and operators remain as-is.
 			command := normalizeRunCommand(v.Command)
-			argv := []string{"/bin/sh", "-lec", command}
+			if args, ok := runHeredoc(shell, shellSet, command); ok {
+				writeLine("RUN %s", args)
+				continue
+			}
+			argv := append(append([]string{}, shell...), command)
 			var jbuf bytes.Buffer
 			enc := json.NewEncoder(&jbuf)
 			enc.SetEscapeHTML(false)
@@ -212,9 +422,18 @@ func RenderDockerfile(dirs []Directive) (string, error) {
 			writeLine("RUN %s", string(jb))
 
 		case RunWithMounts:
-			// JSON exec form is supported with BuildKit options preceding the command.
+			// Rendered like Run, with the BuildKit mount flags preceding the
+			// heredoc or the exec form.
 			command := normalizeRunCommand(v.Command)
-			argv := []string{"/bin/sh", "-lec", command}
+			prefix := ""
+			if len(v.Mounts) > 0 {
+				prefix = strings.Join(v.Mounts, " ") + " "
+			}
+			if args, ok := runHeredoc(shell, shellSet, command); ok {
+				writeLine("RUN %s%s", prefix, args)
+				continue
+			}
+			argv := append(append([]string{}, shell...), command)
 			var jbuf bytes.Buffer
 			enc := json.NewEncoder(&jbuf)
 			enc.SetEscapeHTML(false)
@@ -225,10 +444,6 @@ func RenderDockerfile(dirs []Directive) (string, error) {
 			if len(jb) > 0 && jb[len(jb)-1] == '\n' {
 				jb = jb[:len(jb)-1]
 			}
-			prefix := ""
-			if len(v.Mounts) > 0 {
-				prefix = strings.Join(v.Mounts, " ") + " "
-			}
 			writeLine("RUN %s%s", prefix, string(jb))
 
 		case Copy:
@@ -238,13 +453,37 @@ func RenderDockerfile(dirs []Directive) (string, error) {
 			if v.Dest == "" {
 				return "", fmt.Errorf("COPY: empty destination path")
 			}
-			// Quote each path to handle special chars robustly.
-			srcs := make([]string, len(v.Src))
-			for i, s := range v.Src {
-				srcs[i] = fmt.Sprintf("%q", s)
+			flags := ""
+			if v.From != "" {
+				flags = "--from=" + v.From + " "
+			}
+			args, err := copyArgs(append(append([]string{}, v.Src...), v.Dest))
+			if err != nil {
+				return "", err
+			}
+			writeLine("COPY %s%s", flags, args)
+
+		case File:
+			if v.Path == "" {
+				return "", fmt.Errorf("COPY: empty destination path")
+			}
+			flags := ""
+			if user != "" && user != "root" {
+				flags += "--chown=" + user + " "
+			}
+			if v.Executable {
+				flags += "--chmod=755 "
+			}
+			if v.Source != "" {
+				args, err := copyArgs([]string{v.Source, v.Path})
+				if err != nil {
+					return "", err
+				}
+				writeLine("COPY %s%s", flags, args)
+				continue
 			}
-			dest := fmt.Sprintf("%q", v.Dest)
-			writeLine("COPY %s %s", strings.Join(srcs, " "), dest)
+			word, lines := heredoc(v.Contents)
+			writeLine("COPY %s%s %s\n%s", flags, word, v.Path, lines)
 
 		case Workdir:
 			if v == "" {
@@ -256,7 +495,7 @@ func RenderDockerfile(dirs []Directive) (string, error) {
 			if v == "" {
 				return "", fmt.Errorf("USER: empty user")
 			}
-			user := string(v)
+			user = string(v)
 			if _, ok := createdUsers[user]; !ok {
 				writeLine("RUN test \"$(getent passwd %[1]s)\" \\\n    || useradd --no-user-group --create-home --shell /bin/bash %[1]s", user)
 				createdUsers[user] = struct{}{}
@@ -268,7 +507,7 @@ func RenderDockerfile(dirs []Directive) (string, error) {
 				return "", fmt.Errorf("ENTRYPOINT: empty command")
 			}
 			// Use exec form with JSON encoding to handle special chars robustly.
-			argv := []string{"/bin/sh", "-lec", string(v)}
+			argv := append(append([]string{}, shell...), string(v))
 			var jbuf bytes.Buffer
 			enc := json.NewEncoder(&jbuf)
 			enc.SetEscapeHTML(false)
@@ -295,6 +534,73 @@ func RenderDockerfile(dirs []Directive) (string, error) {
 				jb = jb[:len(jb)-1]
 			}
 			writeLine("ENTRYPOINT %s", string(jb))
+		case Cmd:
+			jb, err := encodeArgv([]string(v))
+			if err != nil {
+				return "", fmt.Errorf("encoding CMD argv: %w", err)
+			}
+			writeLine("CMD %s", jb)
+		case Healthcheck:
+			if len(v.Command) == 0 {
+				writeLine("HEALTHCHECK NONE")
+				continue
+			}
+			var opts []string
+			if v.Interval > 0 {
+				opts = append(opts, "--interval="+v.Interval.String())
+			}
+			if v.Timeout > 0 {
+				opts = append(opts, "--timeout="+v.Timeout.String())
+			}
+			if v.StartPeriod > 0 {
+				opts = append(opts, "--start-period="+v.StartPeriod.String())
+			}
+			if v.Retries > 0 {
+				opts = append(opts, fmt.Sprintf("--retries=%d", v.Retries))
+			}
+			jb, err := encodeArgv(v.Command)
+			if err != nil {
+				return "", fmt.Errorf("encoding HEALTHCHECK argv: %w", err)
+			}
+			if len(opts) > 0 {
+				writeLine("HEALTHCHECK %s CMD %s", strings.Join(opts, " "), jb)
+			} else {
+				writeLine("HEALTHCHECK CMD %s", jb)
+			}
+		case Expose:
+			if len(v) == 0 {
+				return "", fmt.Errorf("EXPOSE: no ports")
+			}
+			writeLine("EXPOSE %s", strings.Join(v, " "))
+		case Volume:
+			if len(v) == 0 {
+				return "", fmt.Errorf("VOLUME: no paths")
+			}
+			jb, err := encodeArgv([]string(v))
+			if err != nil {
+				return "", fmt.Errorf("encoding VOLUME paths: %w", err)
+			}
+			writeLine("VOLUME %s", jb)
+		case Shell:
+			if len(v) == 0 {
+				return "", fmt.Errorf("SHELL: empty argv")
+			}
+			jb, err := encodeArgv([]string(v))
+			if err != nil {
+				return "", fmt.Errorf("encoding SHELL argv: %w", err)
+			}
+			writeLine("SHELL %s", jb)
+			shell = append([]string{}, v...)
+			shellSet = true
+		case Arg:
+			if v.Name == "" {
+				return "", fmt.Errorf("ARG: empty name")
+			}
+			if v.HasDefault {
+				writeLine("ARG %s=\"%s\"", v.Name, escapeValue(v.Default))
+			} else {
+				writeLine("ARG %s", v.Name)
+			}
 		default:
 			return "", fmt.Errorf("unknown directive type: %T", d)
 		}
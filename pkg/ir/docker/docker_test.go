@@ -1,9 +1,11 @@
 package docker
 
 import (
-	"encoding/json"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
 )
 
 func TestRenderDockerfileCollapsesBlankLinesAfterContinuations(t *testing.T) {
@@ -32,27 +34,20 @@ func TestRenderDockerfileCollapsesBlankLinesAfterContinuations(t *testing.T) {
 		t.Fatalf("RenderDockerfile() error = %v", err)
 	}
 
-	lines := strings.Split(df, "\n")
-	var runLine string
-	for _, line := range lines {
-		if strings.HasPrefix(line, "RUN ") {
-			runLine = strings.TrimPrefix(line, "RUN ")
-			break
-		}
-	}
-	if runLine == "" {
-		t.Fatalf("generated Dockerfile missing RUN directive:\n%s", df)
+	// The command has several lines, so it is rendered as a heredoc.
+	res, err := parser.Parse(strings.NewReader(df))
+	if err != nil {
+		t.Fatalf("parsing generated Dockerfile: %v\n%s", err, df)
 	}
-
-	var argv []string
-	if err := json.Unmarshal([]byte(runLine), &argv); err != nil {
-		t.Fatalf("decoding RUN exec form: %v\nraw line: %s", err, runLine)
+	var cmd string
+	for _, node := range res.AST.Children {
+		if strings.EqualFold(node.Value, "run") && len(node.Heredocs) == 1 {
+			cmd = node.Heredocs[0].Content
+		}
 	}
-	if len(argv) != 3 {
-		t.Fatalf("unexpected argv length %d (want 3) in RUN: %v", len(argv), argv)
+	if cmd == "" {
+		t.Fatalf("generated Dockerfile missing RUN heredoc:\n%s", df)
 	}
-
-	cmd := argv[2]
 	if strings.Contains(cmd, "\\\n\n") {
 		t.Fatalf("shell command still contains blank line after continuation: %q", cmd)
 	}
@@ -61,3 +56,223 @@ func TestRenderDockerfileCollapsesBlankLinesAfterContinuations(t *testing.T) {
 		t.Fatalf("expected sanitized command to retain package arguments, got: %q", cmd)
 	}
 }
+
+func TestRenderDockerfileLabelBlock(t *testing.T) {
+	df, err := RenderDockerfile([]Directive{
+		From{Image: "ubuntu:22.04"},
+		Label{
+			"org.opencontainers.image.version": "1.0",
+			"org.opencontainers.image.title":   `my "tool"`,
+		},
+		Label{},
+	})
+	if err != nil {
+		t.Fatalf("RenderDockerfile() error = %v", err)
+	}
+	want := "LABEL org.opencontainers.image.title=\"my \\\"tool\\\"\" \\\n" +
+		"    org.opencontainers.image.version=\"1.0\"\n"
+	if !strings.HasSuffix(df, want) {
+		t.Fatalf("unexpected LABEL rendering:\n%s", df)
+	}
+	if strings.Count(df, "LABEL") != 1 {
+		t.Fatalf("empty label block should be skipped:\n%s", df)
+	}
+}
+
+func TestRenderDockerfileCmdAndHealthcheck(t *testing.T) {
+	df, err := RenderDockerfile([]Directive{
+		From{Image: "ubuntu:22.04"},
+		Cmd{"fsleyes", "--help"},
+		Healthcheck{Command: []string{"/bin/sh", "-c", "curl -f http://localhost/ || exit 1"}, Interval: 30 * time.Second, Retries: 3},
+		Healthcheck{},
+	})
+	if err != nil {
+		t.Fatalf("RenderDockerfile() error = %v", err)
+	}
+	for _, want := range []string{
+		`CMD ["fsleyes","--help"]`,
+		`HEALTHCHECK --interval=30s --retries=3 CMD ["/bin/sh","-c","curl -f http://localhost/ || exit 1"]`,
+		"HEALTHCHECK NONE",
+	} {
+		if !strings.Contains(df, want+"\n") {
+			t.Fatalf("missing %q in:\n%s", want, df)
+		}
+	}
+}
+
+func TestRenderDockerfileExposeVolumeShellArg(t *testing.T) {
+	df, err := RenderDockerfile([]Directive{
+		From{Image: "ubuntu:22.04"},
+		Arg{Name: "TOOL_VERSION", Default: `1.2 "beta"`, HasDefault: true},
+		Arg{Name: "TOKEN"},
+		Run{Command: "echo before"},
+		Shell{"/bin/bash", "-o", "pipefail", "-c"},
+		Run{Command: "echo after"},
+		EntryPoint("exec tool"),
+		Expose{"8080", "53/udp"},
+		Volume{"/data", "/scratch"},
+	})
+	if err != nil {
+		t.Fatalf("RenderDockerfile() error = %v", err)
+	}
+	for _, want := range []string{
+		`ARG TOOL_VERSION="1.2 \"beta\""`,
+		"ARG TOKEN",
+		`RUN ["/bin/sh","-lec","echo before"]`,
+		`SHELL ["/bin/bash","-o","pipefail","-c"]`,
+		`RUN ["/bin/bash","-o","pipefail","-c","echo after"]`,
+		`ENTRYPOINT ["/bin/bash","-o","pipefail","-c","exec tool"]`,
+		"EXPOSE 8080 53/udp",
+		`VOLUME ["/data","/scratch"]`,
+	} {
+		if !strings.Contains(df, want+"\n") {
+			t.Fatalf("missing %q in:\n%s", want, df)
+		}
+	}
+
+	for _, d := range []Directive{Expose{}, Volume{}, Shell{}, Arg{}} {
+		if _, err := RenderDockerfile([]Directive{From{Image: "ubuntu:22.04"}, d}); err == nil {
+			t.Fatalf("expected error for empty %T", d)
+		}
+	}
+}
+
+func TestRenderDockerfileCopyPathsWithSpaces(t *testing.T) {
+	df, err := RenderDockerfile([]Directive{
+		From{Image: "ubuntu:22.04"},
+		Copy{Src: []string{"cache/a.tar.gz"}, Dest: "/opt/"},
+		Copy{Src: []string{"cache/my file.tar.gz"}, Dest: "/opt/"},
+		Copy{From: "build", Src: []string{"/out"}, Dest: "/opt/dir with spaces/"},
+	})
+	if err != nil {
+		t.Fatalf("RenderDockerfile() error = %v", err)
+	}
+	for _, want := range []string{
+		`COPY "cache/a.tar.gz" "/opt/"`,
+		`COPY ["cache/my file.tar.gz","/opt/"]`,
+		`COPY --from=build ["/out","/opt/dir with spaces/"]`,
+	} {
+		if !strings.Contains(df, want+"\n") {
+			t.Fatalf("missing %q in:\n%s", want, df)
+		}
+	}
+}
+
+func TestRenderDockerfileHeredocs(t *testing.T) {
+	df, err := RenderDockerfile([]Directive{
+		From{Image: "ubuntu:22.04"},
+		Run{Command: "echo one"},
+		Run{Command: "echo one &&\n echo two\n"},
+		RunWithMounts{Mounts: []string{"--mount=type=cache,target=/root/.cache"}, Command: "cat > x <<'EOF'\nbody\nEOF"},
+		File{Path: "/etc/motd", Contents: "hello"},
+		User("jovyan"),
+		File{Path: "/home/jovyan/run.sh", Contents: "#!/bin/sh\necho $HOME\n", Executable: true},
+		Shell{"/bin/bash", "-o", "pipefail", "-c"},
+		Run{Command: "echo one\necho two"},
+		Run{Command: "#!/usr/bin/env python3\nprint(1)"},
+	})
+	if err != nil {
+		t.Fatalf("RenderDockerfile() error = %v", err)
+	}
+	for _, want := range []string{
+		`RUN ["/bin/sh","-lec","echo one"]`,
+		"RUN <<'EOF'\n#!/bin/sh -le\necho one &&\n echo two\nEOF",
+		"RUN --mount=type=cache,target=/root/.cache <<'EOF1'\n#!/bin/sh -le\ncat > x <<'EOF'\nbody\nEOF\nEOF1",
+		"COPY <<'EOF' /etc/motd\nhello\nEOF",
+		"COPY --chown=jovyan --chmod=755 <<'EOF' /home/jovyan/run.sh\n#!/bin/sh\necho $HOME\nEOF",
+		"RUN <<'EOF'\necho one\necho two\nEOF",
+		`RUN ["/bin/bash","-o","pipefail","-c","#!/usr/bin/env python3\nprint(1)"]`,
+	} {
+		if !strings.Contains(df, want+"\n") {
+			t.Fatalf("missing %q in:\n%s", want, df)
+		}
+	}
+
+	res, err := parser.Parse(strings.NewReader(df))
+	if err != nil {
+		t.Fatalf("parsing generated Dockerfile: %v", err)
+	}
+	var heredocs []string
+	for _, node := range res.AST.Children {
+		for _, h := range node.Heredocs {
+			heredocs = append(heredocs, h.Content)
+		}
+	}
+	want := []string{
+		"#!/bin/sh -le\necho one &&\n echo two\n",
+		"#!/bin/sh -le\ncat > x <<'EOF'\nbody\nEOF\n",
+		"hello\n",
+		"#!/bin/sh\necho $HOME\n",
+		"echo one\necho two\n",
+	}
+	if strings.Join(heredocs, "|") != strings.Join(want, "|") {
+		t.Fatalf("heredocs = %q; want %q", heredocs, want)
+	}
+}
+
+func TestRenderDockerfileCommentBeforeStage(t *testing.T) {
+	df, err := RenderDockerfile([]Directive{
+		Comment("first"),
+		From{Image: "rockylinux:9", Name: "build"},
+		Run{Command: "make"},
+		Comment("second\nline"),
+		From{Image: "ubuntu:24.04"},
+	})
+	if err != nil {
+		t.Fatalf("RenderDockerfile() error = %v", err)
+	}
+	want := "# first\nFROM rockylinux:9 AS build\n" +
+		"RUN [\"/bin/sh\",\"-lec\",\"make\"]\n\n" +
+		"# second\n# line\nFROM ubuntu:24.04\n"
+	if !strings.HasSuffix(df, want) {
+		t.Fatalf("unexpected Dockerfile:\n%s", df)
+	}
+}
+
+func TestCompareStageCaches(t *testing.T) {
+	old := `# syntax=docker/dockerfile:1.7
+FROM rockylinux:9 AS build
+RUN ["/bin/sh","-lec","make"]
+
+FROM ubuntu:24.04
+ENV A="1"
+RUN ["/bin/sh","-lec","echo one"]
+COPY --from=build "/out" "/opt/out"
+RUN ["/bin/sh","-lec","echo two"]
+`
+	// A comment, a changed ENV and an untouched build stage.
+	changedEnv := strings.Replace(old, `ENV A="1"`, "# moved\nENV A=\"2\"", 1)
+	changes, err := CompareStageCaches(old, changedEnv)
+	if err != nil {
+		t.Fatalf("CompareStageCaches: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 stages, got %+v", changes)
+	}
+	if c := changes[0]; c.Stage != "build" || c.FirstChanged != 0 || c.RebuiltLayers != 0 || c.Layers != 1 {
+		t.Fatalf("build stage: %+v", c)
+	}
+	if c := changes[1]; c.Stage != "1" || c.FirstChanged != 2 || c.Layers != 3 || c.RebuiltLayers != 3 || c.Instruction != `ENV A="2"` {
+		t.Fatalf("final stage: %+v", c)
+	}
+
+	// A change in the build stage reaches the final stage through COPY --from.
+	changedBuild := strings.Replace(old, `"make"]`, `"make -j4"]`, 1)
+	changes, err = CompareStageCaches(old, changedBuild)
+	if err != nil {
+		t.Fatalf("CompareStageCaches: %v", err)
+	}
+	if c := changes[1]; c.FirstChanged != 4 || c.RebuiltLayers != 2 {
+		t.Fatalf("final stage after build change: %+v", c)
+	}
+
+	changes, err = CompareStageCaches(old, old)
+	if err != nil {
+		t.Fatalf("CompareStageCaches: %v", err)
+	}
+	for _, c := range changes {
+		if c.FirstChanged != 0 {
+			t.Fatalf("unchanged Dockerfile reported a change: %+v", c)
+		}
+	}
+}
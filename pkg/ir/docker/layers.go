@@ -0,0 +1,142 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+// StageCacheChange describes how the build cache of one stage fares when a
+// Dockerfile is replaced by a newer version.
+type StageCacheChange struct {
+	// Stage is the stage's name, or its index when it is unnamed.
+	Stage string
+	// Added is set when the stage has no counterpart in the old Dockerfile.
+	Added bool
+	// FirstChanged is the 1-based index of the first instruction whose cache
+	// key changed, or 0 when the whole stage is still cached.
+	FirstChanged int
+	// Instruction is the text of that instruction.
+	Instruction string
+	// Instructions is the number of instructions in the stage.
+	Instructions int
+	// Layers and RebuiltLayers count the filesystem layers (RUN, COPY, ADD)
+	// in the stage and those from FirstChanged on.
+	Layers        int
+	RebuiltLayers int
+}
+
+type stageInstructions struct {
+	name string
+	// lines are the normalized instructions compared for changes, and
+	// original their text as written.
+	lines    []string
+	original []string
+	// from holds the --from stage of each COPY, or "".
+	from []string
+	kind []string
+}
+
+// CompareStageCaches reports, for each stage of newDockerfile, the first
+// instruction whose BuildKit cache key differs from oldDockerfile. Every
+// later instruction of a stage is rebuilt too, as is a COPY --from a stage
+// that changed. Comments and formatting of continuation lines do not count
+// as changes. Stages are matched by name, and unnamed ones by position.
+func CompareStageCaches(oldDockerfile, newDockerfile string) ([]StageCacheChange, error) {
+	oldStages, err := parseStages(oldDockerfile)
+	if err != nil {
+		return nil, fmt.Errorf("parsing old Dockerfile: %w", err)
+	}
+	newStages, err := parseStages(newDockerfile)
+	if err != nil {
+		return nil, fmt.Errorf("parsing new Dockerfile: %w", err)
+	}
+
+	oldByName := map[string]stageInstructions{}
+	for _, s := range oldStages {
+		oldByName[s.name] = s
+	}
+	changed := map[string]bool{}
+
+	var out []StageCacheChange
+	for _, s := range newStages {
+		c := StageCacheChange{Stage: s.name, Instructions: len(s.lines)}
+		old, ok := oldByName[s.name]
+		c.Added = !ok
+		for i, line := range s.lines {
+			if c.FirstChanged == 0 && (!ok || i >= len(old.lines) || old.lines[i] != line || changed[s.from[i]]) {
+				c.FirstChanged = i + 1
+				c.Instruction = s.original[i]
+			}
+			if isLayer(s.kind[i]) {
+				c.Layers++
+				if c.FirstChanged != 0 {
+					c.RebuiltLayers++
+				}
+			}
+		}
+		if c.FirstChanged != 0 {
+			changed[s.name] = true
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func isLayer(kind string) bool {
+	switch kind {
+	case "run", "copy", "add":
+		return true
+	}
+	return false
+}
+
+// parseStages splits a Dockerfile into its stages' normalized instructions.
+// Instructions before the first FROM (ARGs for FROM lines) form no stage.
+func parseStages(dockerfile string) ([]stageInstructions, error) {
+	res, err := parser.Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		return nil, err
+	}
+	var stages []stageInstructions
+	for _, node := range res.AST.Children {
+		kind := strings.ToLower(node.Value)
+		if kind == "from" {
+			name := fmt.Sprint(len(stages))
+			if next := node.Next; next != nil && next.Next != nil && strings.EqualFold(next.Next.Value, "as") && next.Next.Next != nil {
+				name = strings.ToLower(next.Next.Next.Value)
+			}
+			stages = append(stages, stageInstructions{name: name})
+		}
+		if len(stages) == 0 {
+			continue
+		}
+		s := &stages[len(stages)-1]
+		s.lines = append(s.lines, normalizeInstruction(node))
+		s.original = append(s.original, node.Original)
+		s.kind = append(s.kind, kind)
+		from := ""
+		for _, flag := range node.Flags {
+			if v, ok := strings.CutPrefix(flag, "--from="); ok {
+				from = strings.ToLower(v)
+			}
+		}
+		s.from = append(s.from, from)
+	}
+	return stages, nil
+}
+
+// normalizeInstruction renders node from its parsed words, so differences
+// only in line continuations or spacing compare equal.
+func normalizeInstruction(node *parser.Node) string {
+	parts := []string{strings.ToUpper(node.Value)}
+	parts = append(parts, node.Flags...)
+	for n := node.Next; n != nil; n = n.Next {
+		parts = append(parts, n.Value)
+	}
+	for _, heredoc := range node.Heredocs {
+		parts = append(parts, heredoc.Content)
+	}
+	return strings.Join(parts, " ")
+}
@@ -1,83 +1,280 @@
 package ir
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"maps"
 	"path/filepath"
 	"strings"
 
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
 	docker "github.com/neurodesk/builder/pkg/ir/docker"
 )
 
 // GenerateDockerfile converts the intermediate representation into a Dockerfile
 // string by mapping IR directives to the lightweight Docker AST in pkg/ir/docker
-// and rendering it. Unsupported directives are ignored at this stage.
+// and rendering it. Directives with a known provenance are preceded by a
+// comment naming it, once per run of directives from the same place.
+// Unsupported directives are ignored at this stage.
 func GenerateDockerfile(ir *Definition) (string, error) {
+	return generateDockerfile(ir, false)
+}
+
+// ExplainDockerfile is GenerateDockerfile with a comment before the
+// instructions of each directive naming the step, the recipe source and the
+// provenance that produced them. Consecutive directives from the same source
+// share one comment.
+func ExplainDockerfile(ir *Definition) (string, error) {
+	return generateDockerfile(ir, true)
+}
+
+func generateDockerfile(ir *Definition, explain bool) (string, error) {
 	if ir == nil {
 		return "", fmt.Errorf("nil ir definition")
 	}
 
 	var out []docker.Directive
-	for _, d := range ir.Directives {
-		switch v := d.Directive.(type) {
-		case FromImageDirective:
-			out = append(out, docker.From{Image: string(v)})
-		case EnvironmentDirective:
-			// Emit as a single ENV block to keep related vars together
-			env := docker.Env{}
-			maps.Copy(env, v)
-			out = append(out, env)
-		case RunDirective:
-			out = append(out, docker.Run{Command: string(v)})
-		case CopyDirective:
-			if len(v.Parts) < 2 {
-				return "", fmt.Errorf("COPY directive requires at least two parts")
-			}
-			srcs := v.Parts[:len(v.Parts)-1]
-			dest := v.Parts[len(v.Parts)-1]
-			out = append(out, docker.Copy{Src: srcs, Dest: dest})
-		case WorkDirDirective:
-			out = append(out, docker.Workdir(string(v)))
-		case UserDirective:
-			out = append(out, docker.User(string(v)))
-		case EntryPointDirective:
-			out = append(out, docker.EntryPoint(string(v)))
-		case ExecEntryPointDirective:
-			out = append(out, docker.ExecEntryPoint([]string(v)))
-		case RunWithMountsDirective:
-			out = append(out, docker.RunWithMounts{Mounts: v.Mounts, Command: v.Command})
-		case LiteralFileDirective:
-			// Materialize inline file contents inside the image using a safe heredoc.
-			// Use a single RUN with bash -lc to reliably handle newlines and quoting.
-			name := v.Name
-			contents := v.Contents
-			// Ensure parent dir exists, then write file via heredoc.
-			var b strings.Builder
-			dir := filepath.Dir(name)
-			if dir != "." && dir != "/" {
-				b.WriteString("mkdir -p ")
-				b.WriteString(dir)
-				b.WriteString("\n")
-			}
-			// Quote the target path safely for the shell using printf %q
-			// and use eval to avoid word-splitting issues.
-			b.WriteString("TARGET=$(printf %q '")
-			b.WriteString(name)
-			b.WriteString("')\n")
-			b.WriteString("cat > \"$TARGET\" << 'EOF'\n")
-			b.WriteString(contents)
-			if !strings.HasSuffix(contents, "\n") {
-				b.WriteString("\n")
+	for i, d := range ir.Directives {
+		if explain {
+			if i == 0 || d.Source != ir.Directives[i-1].Source || d.Provenance != ir.Directives[i-1].Provenance {
+				out = append(out, docker.Comment(llbStepName(i, d.Describe())))
 			}
-			b.WriteString("EOF\n")
-			if v.Executable {
-				b.WriteString("chmod +x \"$TARGET\"\n")
-			}
-			out = append(out, docker.Run{Command: b.String()})
-		default:
-			return "", fmt.Errorf("unsupported directive: %T", d)
+		} else if !d.Provenance.IsZero() && (i == 0 || d.Provenance != ir.Directives[i-1].Provenance) {
+			out = append(out, docker.Comment(d.Provenance.String()))
+		}
+		instr, err := dockerDirective(d.Directive)
+		if err != nil {
+			return "", err
 		}
+		out = append(out, instr)
 	}
 
 	return docker.RenderDockerfile(out)
 }
+
+// DockerfileStep returns the index of the directive whose instructions, or
+// the comment before them, are at the 1-based line of GenerateDockerfile(ir),
+// such as the line BuildKit reports for a failed step.
+func DockerfileStep(ir *Definition, line int) (int, bool) {
+	if ir == nil || line < 1 {
+		return 0, false
+	}
+	ends, err := dockerfileStepEnds(ir)
+	if err != nil {
+		return 0, false
+	}
+	return stepAtLine(ends, line)
+}
+
+// dockerfileStepEnds returns, for each directive of ir, the last line of
+// GenerateDockerfile(ir) up to and including its instructions.
+func dockerfileStepEnds(ir *Definition) ([]int, error) {
+	ends := make([]int, len(ir.Directives))
+	for i := range ir.Directives {
+		out, err := GenerateDockerfile(ir.Truncate(i + 1))
+		if err != nil {
+			return nil, err
+		}
+		ends[i] = strings.Count(out, "\n")
+	}
+	return ends, nil
+}
+
+func stepAtLine(ends []int, line int) (int, bool) {
+	for i, end := range ends {
+		if line <= end {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// FinalStageSteps returns, for each instruction of the final stage of
+// GenerateDockerfile(ir) after its FROM, the index of the directive that
+// produced it. Docker records one image history entry per instruction, so
+// the last len(steps) history entries of the image line up with the result.
+func FinalStageSteps(ir *Definition) ([]int, error) {
+	if ir == nil {
+		return nil, fmt.Errorf("nil ir definition")
+	}
+	dockerfile, err := GenerateDockerfile(ir)
+	if err != nil {
+		return nil, err
+	}
+	res, err := parser.Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		return nil, fmt.Errorf("parsing generated Dockerfile: %w", err)
+	}
+	ends, err := dockerfileStepEnds(ir)
+	if err != nil {
+		return nil, err
+	}
+	var steps []int
+	for _, node := range res.AST.Children {
+		if strings.EqualFold(node.Value, "from") {
+			steps = steps[:0]
+			continue
+		}
+		step, ok := stepAtLine(ends, node.StartLine)
+		if !ok {
+			return nil, fmt.Errorf("no directive for Dockerfile line %d", node.StartLine)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// LiteralFileInlineMax is the size up to which GenerateDockerfile writes a
+// literal file inline, as a heredoc. Larger ones are copied from the build
+// context, which must provide them as ContextFiles lists them, so they stay
+// out of the Dockerfile and the image history, and clear of ARG_MAX.
+const LiteralFileInlineMax = 16 << 10
+
+// ContextDir is the directory of the build context that holds the
+// ContextFiles.
+const ContextDir = "literal"
+
+// ContextFile is a file that a Dockerfile from GenerateDockerfile copies
+// from the build context.
+type ContextFile struct {
+	// Path is the file's path in the build context.
+	Path     string
+	Contents string
+}
+
+// ContextFiles returns the files that GenerateDockerfile(ir) copies from
+// the build context: the literal files larger than LiteralFileInlineMax, by
+// the digest of their contents.
+func ContextFiles(ir *Definition) []ContextFile {
+	var out []ContextFile
+	seen := map[string]bool{}
+	for _, d := range ir.Directives {
+		l, ok := d.Directive.(LiteralFileDirective)
+		if !ok {
+			continue
+		}
+		if path := literalFileSource(l); path != "" && !seen[path] {
+			seen[path] = true
+			out = append(out, ContextFile{Path: path, Contents: l.Contents})
+		}
+	}
+	return out
+}
+
+// literalFileSource returns the path in the build context that the literal
+// file l is copied from, or "" if it is written inline. BuildKit expands
+// variables and escapes in a COPY destination, so files with a $ or \ in
+// their name are always written inline.
+func literalFileSource(l LiteralFileDirective) string {
+	if len(l.Contents) <= LiteralFileInlineMax || l.Name == "" || strings.ContainsAny(l.Name, "$\\\r\n") {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(l.Contents))
+	return ContextDir + "/" + hex.EncodeToString(sum[:])
+}
+
+// heredocPath reports whether a literal file can be written to name with a
+// COPY heredoc: BuildKit splits the destination of the shell form on
+// whitespace and expands variables in it, and the JSON form takes no
+// heredocs, so other names are written by a RUN.
+func heredocPath(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\r\n\"'$\\")
+}
+
+// dockerDirective maps one IR directive to its Dockerfile instruction.
+func dockerDirective(d Directive) (docker.Directive, error) {
+	switch v := d.(type) {
+	case FromImageDirective:
+		return docker.From{Image: string(v)}, nil
+	case StageDirective:
+		return docker.From{Image: v.Image, Name: v.Name}, nil
+	case CopyFromDirective:
+		if len(v.Src) == 0 {
+			return nil, fmt.Errorf("COPY --from=%s requires at least one source", v.Stage)
+		}
+		return docker.Copy{From: v.Stage, Src: v.Src, Dest: v.Dest}, nil
+	case EnvironmentDirective:
+		// Emit as a single ENV block to keep related vars together
+		env := docker.Env{}
+		maps.Copy(env, v)
+		return env, nil
+	case LabelDirective:
+		label := docker.Label{}
+		maps.Copy(label, v)
+		return label, nil
+	case RunDirective:
+		return docker.Run{Command: string(v)}, nil
+	case CopyDirective:
+		if len(v.Parts) < 2 {
+			return nil, fmt.Errorf("COPY directive requires at least two parts")
+		}
+		srcs := v.Parts[:len(v.Parts)-1]
+		dest := v.Parts[len(v.Parts)-1]
+		return docker.Copy{Src: srcs, Dest: dest}, nil
+	case WorkDirDirective:
+		return docker.Workdir(string(v)), nil
+	case UserDirective:
+		return docker.User(string(v)), nil
+	case EntryPointDirective:
+		return docker.EntryPoint(string(v)), nil
+	case ExecEntryPointDirective:
+		return docker.ExecEntryPoint([]string(v)), nil
+	case CmdDirective:
+		return docker.Cmd([]string(v)), nil
+	case HealthcheckDirective:
+		return docker.Healthcheck{
+			Command:     v.Command,
+			Interval:    v.Interval,
+			Timeout:     v.Timeout,
+			StartPeriod: v.StartPeriod,
+			Retries:     v.Retries,
+		}, nil
+	case ExposeDirective:
+		return docker.Expose([]string(v)), nil
+	case VolumeDirective:
+		return docker.Volume([]string(v)), nil
+	case ShellDirective:
+		return docker.Shell([]string(v)), nil
+	case ArgDirective:
+		return docker.Arg{Name: v.Name, Default: v.Default, HasDefault: v.HasDefault}, nil
+	case RunWithMountsDirective:
+		return docker.RunWithMounts{Mounts: v.Mounts, Command: v.Command}, nil
+	case LiteralFileDirective:
+		if src := literalFileSource(v); src != "" {
+			return docker.File{Path: v.Name, Source: src, Executable: v.Executable}, nil
+		}
+		if heredocPath(v.Name) {
+			return docker.File{Path: v.Name, Contents: v.Contents, Executable: v.Executable}, nil
+		}
+		// Materialize inline file contents inside the image using a safe heredoc.
+		// Use a single RUN with bash -lc to reliably handle newlines and quoting.
+		name := v.Name
+		contents := v.Contents
+		// Ensure parent dir exists, then write file via heredoc.
+		var b strings.Builder
+		dir := filepath.Dir(name)
+		if dir != "." && dir != "/" {
+			b.WriteString("mkdir -p ")
+			b.WriteString(dir)
+			b.WriteString("\n")
+		}
+		// Quote the target path safely for the shell using printf %q
+		// and use eval to avoid word-splitting issues.
+		b.WriteString("TARGET=$(printf %q '")
+		b.WriteString(name)
+		b.WriteString("')\n")
+		b.WriteString("cat > \"$TARGET\" << 'EOF'\n")
+		b.WriteString(contents)
+		if !strings.HasSuffix(contents, "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString("EOF\n")
+		if v.Executable {
+			b.WriteString("chmod +x \"$TARGET\"\n")
+		}
+		return docker.Run{Command: b.String()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported directive: %T", d)
+	}
+}
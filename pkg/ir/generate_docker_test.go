@@ -0,0 +1,197 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExplainDockerfileAnnotatesSources(t *testing.T) {
+	def, err := New().
+		AddFromImage("<default>", "ubuntu:24.04").
+		SetCurrentUser("<default>", "root").
+		AddRunCommand("directives[0]", "echo one").
+		AddRunCommand("directives[1] > template tool[0]", "echo two").
+		AddEnvironment("directives[1] > template tool[0]", map[string]string{"A": "1"}).
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	plain, err := GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	explained, err := ExplainDockerfile(def)
+	if err != nil {
+		t.Fatalf("ExplainDockerfile: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"# [step 1] <default>",
+		"FROM ubuntu:24.04",
+		"USER root",
+		"# [step 3] directives[0]",
+		`RUN ["/bin/sh","-lec","echo one"]`,
+		"# [step 4] directives[1] > template tool[0]",
+		`RUN ["/bin/sh","-lec","echo two"]`,
+		`ENV A="1"`,
+	}, "\n")
+	if !strings.Contains(explained, want) {
+		t.Fatalf("explain output missing annotations:\n%s", explained)
+	}
+
+	var stripped []string
+	for _, line := range strings.Split(explained, "\n") {
+		if !strings.HasPrefix(line, "# [step ") {
+			stripped = append(stripped, line)
+		}
+	}
+	if got := strings.Join(stripped, "\n"); got != plain {
+		t.Fatalf("explain output differs from generate beyond comments:\n%s\nwant:\n%s", got, plain)
+	}
+}
+
+func TestGenerateDockerfileCommentsProvenance(t *testing.T) {
+	p := Provenance{File: "tool/build.yaml", Line: 12, Template: "conda"}
+	def, err := New().
+		AddFromImage("<default>", "ubuntu:24.04").
+		SetProvenance("directives[0]", p).
+		AddRunCommand("directives[0]", "echo one").
+		AddRunCommand("directives[0]", "echo two").
+		SetProvenance("directives[1]", Provenance{File: "tool/build.yaml", Line: 14, StarlarkCall: "<script>:2:4"}).
+		AddRunCommand("directives[1]", "echo three").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if got := def.Directives[1].Provenance; got != p {
+		t.Fatalf("provenance = %+v, want %+v", got, p)
+	}
+
+	df, err := GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	want := strings.Join([]string{
+		"FROM ubuntu:24.04",
+		"# tool/build.yaml:12, template conda",
+		`RUN ["/bin/sh","-lec","echo one"]`,
+		`RUN ["/bin/sh","-lec","echo two"]`,
+		"# tool/build.yaml:14, starlark <script>:2:4",
+		`RUN ["/bin/sh","-lec","echo three"]`,
+	}, "\n")
+	if !strings.Contains(df, want) {
+		t.Fatalf("missing provenance comments:\n%s", df)
+	}
+
+	llbDef, err := GenerateLLBDefinition(def)
+	if err != nil {
+		t.Fatalf("GenerateLLBDefinition: %v", err)
+	}
+	names, err := buildVertexNameIndex(llbDef)
+	if err != nil {
+		t.Fatalf("buildVertexNameIndex: %v", err)
+	}
+	var found bool
+	for _, name := range names {
+		if name == "[step 2] directives[0] (tool/build.yaml:12, template conda)" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("no vertex named after the provenance of step 2: %v", names)
+	}
+}
+
+func TestDockerfileStepMapsLinesToDirectives(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddEnvironment("env", map[string]string{"A": "1"}).
+		AddRunCommand("run one", "echo one").
+		AddRunCommand("run two", "echo two").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	out, err := GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	for i, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, "echo two") {
+			continue
+		}
+		if step, ok := DockerfileStep(def, i+1); !ok || step != 3 {
+			t.Fatalf("DockerfileStep(line %d) = %d, %v; want 3", i+1, step, ok)
+		}
+		if step, ok := DockerfileStep(def, i); !ok || step != 2 {
+			t.Fatalf("DockerfileStep(line %d) = %d, %v; want 2", i, step, ok)
+		}
+		if _, ok := DockerfileStep(def, i+2); ok {
+			t.Fatalf("DockerfileStep past the end succeeded")
+		}
+		return
+	}
+	t.Fatalf("no RUN line in:\n%s", out)
+}
+
+func TestFinalStageStepsMapsInstructionsOfTheLastStage(t *testing.T) {
+	def, err := New().
+		AddStage("builder", "build", "ubuntu:24.04").
+		AddRunCommand("compile", "make").
+		AddFromImage("from", "ubuntu:24.04").
+		AddEnvironment("env", map[string]string{"A": "1", "B": "2"}).
+		AddCopyFrom("copy", "build", []string{"/out"}, "/opt/out").
+		AddRunCommand("run", "echo one").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	steps, err := FinalStageSteps(def)
+	if err != nil {
+		t.Fatalf("FinalStageSteps: %v", err)
+	}
+	want := []int{3, 4, 5}
+	if fmt.Sprint(steps) != fmt.Sprint(want) {
+		t.Fatalf("FinalStageSteps = %v; want %v", steps, want)
+	}
+}
+
+func TestGenerateDockerfileCopiesLargeLiteralFilesFromTheContext(t *testing.T) {
+	large := strings.Repeat("echo large\n", LiteralFileInlineMax/10)
+	def, err := New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddLiteralFile("small", "/etc/motd", "hello\n", false).
+		AddLiteralFile("large", "/opt/tool/run.sh", large, true).
+		AddLiteralFile("again", "/opt/tool/copy of run.sh", large, false).
+		AddLiteralFile("dollar", "/opt/$TOOL.sh", large, false).
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	files := ContextFiles(def)
+	if len(files) != 1 || !strings.HasPrefix(files[0].Path, ContextDir+"/") || files[0].Contents != large {
+		t.Fatalf("ContextFiles = %d files; want one with the large contents", len(files))
+	}
+	out, err := GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	for _, want := range []string{
+		"COPY <<'EOF' /etc/motd\nhello\nEOF\n",
+		fmt.Sprintf("COPY --chmod=755 %q \"/opt/tool/run.sh\"\n", files[0].Path),
+		fmt.Sprintf("COPY [%q,\"/opt/tool/copy of run.sh\"]\n", files[0].Path),
+		"RUN <<'EOF1'\n#!/bin/sh -le\nmkdir -p /opt\nTARGET=$(printf %q '/opt/$TOOL.sh')\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "echo large") != strings.Count(large, "echo large") {
+		t.Fatalf("the large file is inlined other than for /opt/$TOOL.sh")
+	}
+	if err := VerifyDockerfile(def, out); err != nil {
+		t.Fatalf("VerifyDockerfile: %v", err)
+	}
+}
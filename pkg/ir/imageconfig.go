@@ -0,0 +1,208 @@
+package ir
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// BaseImage returns the base image of the final stage, or "" if none.
+func (d *Definition) BaseImage() string {
+	final := d.FinalStage()
+	if len(final) == 0 {
+		return ""
+	}
+	switch f := final[0].Directive.(type) {
+	case FromImageDirective:
+		return string(f)
+	case StageDirective:
+		return f.Image
+	}
+	return ""
+}
+
+// withoutLayerHistory removes the history and rootfs of an image config,
+// whose layers no longer match a squashed image.
+func withoutLayerHistory(config []byte) ([]byte, error) {
+	img := map[string]any{}
+	if err := json.Unmarshal(config, &img); err != nil {
+		return nil, fmt.Errorf("parsing image config: %w", err)
+	}
+	delete(img, "history")
+	delete(img, "rootfs")
+	return json.Marshal(img)
+}
+
+// BuildImageConfig applies the runtime settings of def (ENV, WORKDIR, USER,
+// ENTRYPOINT, CMD, HEALTHCHECK, LABEL, EXPOSE, VOLUME, SHELL) to base, the JSON image config of the
+// FROM image, and returns the resulting config. Fields of base that the IR
+// does not model are preserved. base may be empty, e.g. for scratch images.
+//
+// Only the final stage contributes; earlier stages never reach the image.
+// Semantics follow the Dockerfile frontend: ENV values expand $VAR references
+// against the environment so far, setting an ENTRYPOINT clears a CMD
+// inherited from the base image, and a shell-form ENTRYPOINT uses the SHELL
+// in effect. ARG is build-time only and not recorded.
+func BuildImageConfig(def *Definition, base []byte) ([]byte, error) {
+	img := map[string]any{}
+	if len(base) > 0 {
+		if err := json.Unmarshal(base, &img); err != nil {
+			return nil, fmt.Errorf("parsing base image config: %w", err)
+		}
+	}
+	if _, ok := img["os"]; !ok {
+		img["os"] = "linux"
+	}
+	if _, ok := img["architecture"]; !ok {
+		img["architecture"] = runtime.GOARCH
+	}
+	cfg, _ := img["config"].(map[string]any)
+	if cfg == nil {
+		cfg = map[string]any{}
+	}
+
+	// Keep the base environment order; new keys are appended.
+	var envKeys []string
+	envVals := map[string]string{}
+	if list, ok := cfg["Env"].([]any); ok {
+		for _, item := range list {
+			s, _ := item.(string)
+			k, val, _ := strings.Cut(s, "=")
+			if _, seen := envVals[k]; !seen {
+				envKeys = append(envKeys, k)
+			}
+			envVals[k] = val
+		}
+	}
+	labels := map[string]any{}
+	if existing, ok := cfg["Labels"].(map[string]any); ok {
+		for k, val := range existing {
+			labels[k] = val
+		}
+	}
+	// ExposedPorts and Volumes are sets encoded as maps to empty objects.
+	ports, _ := cfg["ExposedPorts"].(map[string]any)
+	if ports == nil {
+		ports = map[string]any{}
+	}
+	volumes, _ := cfg["Volumes"].(map[string]any)
+	if volumes == nil {
+		volumes = map[string]any{}
+	}
+	shell := append([]string{}, DefaultShell...)
+
+	for _, dm := range def.FinalStage() {
+		switch v := dm.Directive.(type) {
+		case EnvironmentDirective:
+			for _, k := range v.Keys() {
+				val := os.Expand(strings.Join(strings.Fields(v[k]), " "), func(name string) string {
+					return envVals[name]
+				})
+				if _, seen := envVals[k]; !seen {
+					envKeys = append(envKeys, k)
+				}
+				envVals[k] = val
+			}
+		case WorkDirDirective:
+			cfg["WorkingDir"] = string(v)
+		case UserDirective:
+			cfg["User"] = string(v)
+		case EntryPointDirective:
+			cfg["Entrypoint"] = append(append([]string{}, shell...), string(v))
+			delete(cfg, "Cmd")
+		case ExecEntryPointDirective:
+			cfg["Entrypoint"] = []string(v)
+			delete(cfg, "Cmd")
+		case CmdDirective:
+			cfg["Cmd"] = []string(v)
+		case HealthcheckDirective:
+			cfg["Healthcheck"] = healthcheckConfig(v)
+		case LabelDirective:
+			for _, k := range v.Keys() {
+				labels[k] = v[k]
+			}
+		case ExposeDirective:
+			for _, p := range v {
+				for _, k := range exposedPortKeys(p) {
+					ports[k] = map[string]any{}
+				}
+			}
+		case VolumeDirective:
+			for _, p := range v {
+				volumes[p] = map[string]any{}
+			}
+		case ShellDirective:
+			shell = append([]string{}, v...)
+			cfg["Shell"] = []string(v)
+		}
+	}
+
+	env := make([]string, 0, len(envKeys))
+	for _, k := range envKeys {
+		env = append(env, k+"="+envVals[k])
+	}
+	if len(env) > 0 {
+		cfg["Env"] = env
+	}
+	if len(labels) > 0 {
+		cfg["Labels"] = labels
+	}
+	if len(ports) > 0 {
+		cfg["ExposedPorts"] = ports
+	}
+	if len(volumes) > 0 {
+		cfg["Volumes"] = volumes
+	}
+	img["config"] = cfg
+
+	return json.Marshal(img)
+}
+
+// exposedPortKeys returns the image config keys for an EXPOSE entry. Keys
+// always carry a protocol ("8080/tcp") and ranges are expanded, as the
+// Dockerfile frontend does.
+func exposedPortKeys(port string) []string {
+	num, proto, ok := strings.Cut(port, "/")
+	if !ok {
+		proto = "tcp"
+	}
+	lo, hi, isRange := strings.Cut(num, "-")
+	if !isRange {
+		return []string{num + "/" + proto}
+	}
+	start, err1 := strconv.Atoi(lo)
+	end, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || end < start {
+		return []string{port}
+	}
+	keys := make([]string, 0, end-start+1)
+	for p := start; p <= end; p++ {
+		keys = append(keys, strconv.Itoa(p)+"/"+proto)
+	}
+	return keys
+}
+
+// healthcheckConfig renders h in the Docker image config representation,
+// where durations are nanoseconds.
+func healthcheckConfig(h HealthcheckDirective) map[string]any {
+	if len(h.Command) == 0 {
+		return map[string]any{"Test": []string{"NONE"}}
+	}
+	out := map[string]any{"Test": append([]string{"CMD"}, h.Command...)}
+	if h.Interval > 0 {
+		out["Interval"] = int64(h.Interval)
+	}
+	if h.Timeout > 0 {
+		out["Timeout"] = int64(h.Timeout)
+	}
+	if h.StartPeriod > 0 {
+		out["StartPeriod"] = int64(h.StartPeriod)
+	}
+	if h.Retries > 0 {
+		out["Retries"] = h.Retries
+	}
+	return out
+}
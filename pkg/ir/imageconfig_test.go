@@ -0,0 +1,222 @@
+package ir
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildImageConfigMergesBase(t *testing.T) {
+	base := []byte(`{
+		"architecture": "arm64",
+		"os": "linux",
+		"config": {
+			"Env": ["PATH=/usr/bin:/bin", "LANG=C"],
+			"Cmd": ["bash"],
+			"StopSignal": "SIGTERM",
+			"Labels": {"vendor": "base"}
+		},
+		"rootfs": {"type": "layers", "diff_ids": []}
+	}`)
+	def, err := New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddEnvironment("env", map[string]string{"PATH": "/opt/tool/bin:$PATH", "TOOL_HOME": "/opt/tool"}).
+		SetWorkingDirectory("wd", "/data").
+		SetCurrentUser("user", "neuro").
+		SetExecEntryPoint("ep", []string{"/entrypoint.sh"}).
+		AddLabels("labels", map[string]string{"org.opencontainers.image.version": "1.0"}).
+		SetHealthcheck("hc", HealthcheckDirective{Command: []string{"tool", "--ping"}, Interval: 30 * time.Second, Retries: 3}).
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	out, err := BuildImageConfig(def, base)
+	if err != nil {
+		t.Fatalf("BuildImageConfig: %v", err)
+	}
+	var img struct {
+		Architecture string         `json:"architecture"`
+		RootFS       map[string]any `json:"rootfs"`
+		Config       struct {
+			Env         []string
+			Cmd         []string
+			Entrypoint  []string
+			WorkingDir  string
+			User        string
+			StopSignal  string
+			Labels      map[string]string
+			Healthcheck map[string]any
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(out, &img); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if img.Architecture != "arm64" || img.RootFS == nil || img.Config.StopSignal != "SIGTERM" {
+		t.Fatalf("base fields not preserved: %s", out)
+	}
+	wantEnv := []string{"PATH=/opt/tool/bin:/usr/bin:/bin", "LANG=C", "TOOL_HOME=/opt/tool"}
+	if !reflect.DeepEqual(img.Config.Env, wantEnv) {
+		t.Fatalf("Env = %v, want %v", img.Config.Env, wantEnv)
+	}
+	if img.Config.Cmd != nil {
+		t.Fatalf("ENTRYPOINT should clear the inherited CMD, got %v", img.Config.Cmd)
+	}
+	if !reflect.DeepEqual(img.Config.Entrypoint, []string{"/entrypoint.sh"}) {
+		t.Fatalf("Entrypoint = %v", img.Config.Entrypoint)
+	}
+	if img.Config.WorkingDir != "/data" || img.Config.User != "neuro" {
+		t.Fatalf("WorkingDir/User = %q/%q", img.Config.WorkingDir, img.Config.User)
+	}
+	if img.Config.Labels["vendor"] != "base" || img.Config.Labels["org.opencontainers.image.version"] != "1.0" {
+		t.Fatalf("Labels = %v", img.Config.Labels)
+	}
+	hc := img.Config.Healthcheck
+	if !reflect.DeepEqual(hc["Test"], []any{"CMD", "tool", "--ping"}) || hc["Interval"] != float64(30*time.Second) || hc["Retries"] != float64(3) {
+		t.Fatalf("Healthcheck = %v", hc)
+	}
+	if _, ok := hc["Timeout"]; ok {
+		t.Fatalf("unset Timeout should be omitted: %v", hc)
+	}
+}
+
+func TestBuildImageConfigCmdAfterEntrypoint(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "scratch").
+		SetEntryPoint("ep", "exec \"$@\"").
+		SetCmd("cmd", []string{"fsleyes"}).
+		SetHealthcheck("hc", HealthcheckDirective{}).
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	out, err := BuildImageConfig(def, nil)
+	if err != nil {
+		t.Fatalf("BuildImageConfig: %v", err)
+	}
+	var img struct {
+		OS     string `json:"os"`
+		Config struct {
+			Cmd         []string
+			Entrypoint  []string
+			Healthcheck struct{ Test []string }
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(out, &img); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if img.OS != "linux" {
+		t.Fatalf("os = %q", img.OS)
+	}
+	if !reflect.DeepEqual(img.Config.Cmd, []string{"fsleyes"}) {
+		t.Fatalf("Cmd = %v", img.Config.Cmd)
+	}
+	if !reflect.DeepEqual(img.Config.Entrypoint, []string{"/bin/sh", "-lec", "exec \"$@\""}) {
+		t.Fatalf("Entrypoint = %v", img.Config.Entrypoint)
+	}
+	if !reflect.DeepEqual(img.Config.Healthcheck.Test, []string{"NONE"}) {
+		t.Fatalf("Healthcheck = %v", img.Config.Healthcheck)
+	}
+}
+
+func TestBuildImageConfigPortsVolumesShell(t *testing.T) {
+	base := []byte(`{"config":{"ExposedPorts":{"22/tcp":{}},"Volumes":{"/base":{}}}}`)
+	def, err := New().
+		AddFromImage("from", "ubuntu:22.04").
+		AddArg("arg", ArgDirective{Name: "SECRET", Default: "x", HasDefault: true}).
+		AddExpose("expose", "8080", "53/udp", "9000-9002").
+		AddVolumes("volumes", "/data").
+		SetShell("shell", []string{"/bin/bash", "-c"}).
+		SetEntryPoint("ep", "exec tool").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	out, err := BuildImageConfig(def, base)
+	if err != nil {
+		t.Fatalf("BuildImageConfig: %v", err)
+	}
+	var img struct {
+		Config struct {
+			Env          []string
+			ExposedPorts map[string]struct{}
+			Volumes      map[string]struct{}
+			Shell        []string
+			Entrypoint   []string
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(out, &img); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	var ports []string
+	for p := range img.Config.ExposedPorts {
+		ports = append(ports, p)
+	}
+	sort.Strings(ports)
+	if want := []string{"22/tcp", "53/udp", "8080/tcp", "9000/tcp", "9001/tcp", "9002/tcp"}; !reflect.DeepEqual(ports, want) {
+		t.Fatalf("ExposedPorts = %v, want %v", ports, want)
+	}
+	if _, ok := img.Config.Volumes["/base"]; !ok || len(img.Config.Volumes) != 2 {
+		t.Fatalf("Volumes = %v", img.Config.Volumes)
+	}
+	if !reflect.DeepEqual(img.Config.Shell, []string{"/bin/bash", "-c"}) {
+		t.Fatalf("Shell = %v", img.Config.Shell)
+	}
+	if !reflect.DeepEqual(img.Config.Entrypoint, []string{"/bin/bash", "-c", "exec tool"}) {
+		t.Fatalf("Entrypoint = %v", img.Config.Entrypoint)
+	}
+	if len(img.Config.Env) != 0 {
+		t.Fatalf("ARG must not be persisted: Env = %v", img.Config.Env)
+	}
+}
+
+func TestBuildImageConfigUsesFinalStageOnly(t *testing.T) {
+	def, err := New().
+		AddStage("build", "compile", "golang:1.25").
+		AddEnvironment("env", map[string]string{"GOFLAGS": "-mod=mod"}).
+		AddLabels("label", map[string]string{"stage": "compile"}).
+		SetWorkingDirectory("wd", "/src").
+		AddFromImage("from", "alpine:3.20").
+		AddEnvironment("env2", map[string]string{"TOOL_HOME": "/opt/tool"}).
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if got := def.BaseImage(); got != "alpine:3.20" {
+		t.Fatalf("BaseImage = %q", got)
+	}
+	if labels := def.Labels(); len(labels) != 0 {
+		t.Fatalf("Labels leaked from earlier stage: %v", labels)
+	}
+	out, err := BuildImageConfig(def, nil)
+	if err != nil {
+		t.Fatalf("BuildImageConfig: %v", err)
+	}
+	var img struct {
+		Config struct {
+			Env        []string
+			WorkingDir string
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(out, &img); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(img.Config.Env, []string{"TOOL_HOME=/opt/tool"}) || img.Config.WorkingDir != "" {
+		t.Fatalf("config leaked from earlier stage: %+v", img.Config)
+	}
+}
+
+func TestWithoutLayerHistory(t *testing.T) {
+	out, err := withoutLayerHistory([]byte(`{"architecture":"amd64","config":{"Env":["A=1"]},"history":[{"created_by":"x"}],"rootfs":{"type":"layers","diff_ids":["sha256:aa"]}}`))
+	if err != nil {
+		t.Fatalf("withoutLayerHistory: %v", err)
+	}
+	got := string(out)
+	if strings.Contains(got, "history") || strings.Contains(got, "rootfs") || !strings.Contains(got, `"A=1"`) {
+		t.Fatalf("withoutLayerHistory = %s", got)
+	}
+}
@@ -1,6 +1,14 @@
 package ir
 
-import "fmt"
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Directive interface {
 	isDirective()
@@ -11,11 +19,23 @@ type FromImageDirective string
 // isDirective implements Directive.
 func (f FromImageDirective) isDirective() {}
 
+// EnvironmentDirective is map-backed; consumers must iterate it via Keys so
+// generated output does not depend on Go map ordering.
 type EnvironmentDirective map[string]string
 
 // isDirective implements Directive.
 func (e EnvironmentDirective) isDirective() {}
 
+// Keys returns the variable names in sorted order.
+func (e EnvironmentDirective) Keys() []string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 type RunDirective string
 
 // isDirective implements Directive.
@@ -70,6 +90,106 @@ type ExecEntryPointDirective []string
 // isDirective implements Directive.
 func (e ExecEntryPointDirective) isDirective() {}
 
+// CmdDirective sets the default command (CMD) in exec form. When an
+// ENTRYPOINT is set, the argv is passed to it as arguments.
+type CmdDirective []string
+
+// isDirective implements Directive.
+func (c CmdDirective) isDirective() {}
+
+// HealthcheckDirective configures the image HEALTHCHECK. An empty Command
+// disables any healthcheck inherited from the base image (HEALTHCHECK NONE).
+// Zero durations and retries leave the runtime defaults in place.
+type HealthcheckDirective struct {
+	// Command is the exec-form argv of the check.
+	Command     []string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+}
+
+// isDirective implements Directive.
+func (h HealthcheckDirective) isDirective() {}
+
+// LabelDirective sets image labels (LABEL in Dockerfiles, annotations on
+// exported images). Like EnvironmentDirective it is map-backed; iterate via Keys.
+type LabelDirective map[string]string
+
+// isDirective implements Directive.
+func (l LabelDirective) isDirective() {}
+
+// Keys returns the label names in sorted order.
+func (l LabelDirective) Keys() []string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ExposeDirective declares the ports the container listens on, each as
+// "port", "port/proto" or a "low-high" range. It is image metadata only.
+type ExposeDirective []string
+
+// isDirective implements Directive.
+func (e ExposeDirective) isDirective() {}
+
+// VolumeDirective declares absolute paths that are mount points for
+// externally provided volumes.
+type VolumeDirective []string
+
+// isDirective implements Directive.
+func (v VolumeDirective) isDirective() {}
+
+// ShellDirective sets the argv prefix used for shell-form commands; the
+// command is appended as the last argument. It applies to every later RUN and
+// shell-form ENTRYPOINT and is recorded in the image config. The default is
+// ["/bin/sh", "-lec"].
+type ShellDirective []string
+
+// isDirective implements Directive.
+func (s ShellDirective) isDirective() {}
+
+// DefaultShell is the shell used for RUN until a ShellDirective replaces it.
+var DefaultShell = []string{"/bin/sh", "-lec"}
+
+// ArgDirective declares a build argument. Later RUN commands see it as an
+// environment variable, set to the value supplied at build time or Default;
+// it is not persisted in the image. ENV variables of the same name win.
+type ArgDirective struct {
+	Name       string
+	Default    string
+	HasDefault bool
+}
+
+// isDirective implements Directive.
+func (a ArgDirective) isDirective() {}
+
+// StageDirective starts a named build stage (FROM <Image> AS <Name>).
+// Directives up to the next StageDirective or FromImageDirective belong to it.
+// Later stages copy results out of it with CopyFromDirective.
+type StageDirective struct {
+	Name  string
+	Image string
+}
+
+// isDirective implements Directive.
+func (s StageDirective) isDirective() {}
+
+// CopyFromDirective copies paths out of the filesystem of an earlier stage
+// (COPY --from=<Stage>). Src paths are relative to the stage's root; a
+// relative Dest is resolved against the current WORKDIR.
+type CopyFromDirective struct {
+	Stage string
+	Src   []string
+	Dest  string
+}
+
+// isDirective implements Directive.
+func (c CopyFromDirective) isDirective() {}
+
 var (
 	_ Directive = FromImageDirective("")
 
@@ -78,22 +198,91 @@ var (
 	_ Directive = WorkDirDirective("")
 	_ Directive = UserDirective("")
 	_ Directive = EntryPointDirective("")
+	_ Directive = LabelDirective{}
+	_ Directive = CmdDirective{}
+	_ Directive = HealthcheckDirective{}
+	_ Directive = ExposeDirective{}
+	_ Directive = VolumeDirective{}
+	_ Directive = ShellDirective{}
+	_ Directive = ArgDirective{}
+	_ Directive = StageDirective{}
+	_ Directive = CopyFromDirective{}
 )
 
 type DirectiveWithMetadata struct {
-	Directive Directive
-	Source    SourceID
+	Directive  Directive
+	Source     SourceID
+	Provenance Provenance
 }
 
 type Definition struct {
 	Directives []DirectiveWithMetadata
 }
 
+// FinalStage returns the directives of the stage that becomes the image:
+// everything from the last FromImageDirective or StageDirective on. For a
+// single-stage definition that is every directive.
+func (d *Definition) FinalStage() []DirectiveWithMetadata {
+	for i := len(d.Directives) - 1; i >= 0; i-- {
+		switch d.Directives[i].Directive.(type) {
+		case FromImageDirective, StageDirective:
+			return d.Directives[i:]
+		}
+	}
+	return d.Directives
+}
+
+// Labels returns the effective image labels: every LabelDirective of the
+// final stage merged in order, so later directives override earlier ones.
+func (d *Definition) Labels() map[string]string {
+	out := map[string]string{}
+	for _, dm := range d.FinalStage() {
+		if l, ok := dm.Directive.(LabelDirective); ok {
+			maps.Copy(out, l)
+		}
+	}
+	return out
+}
+
+// Truncate returns the definition of the first n directives: the state a
+// build is in before directive n runs.
+func (d *Definition) Truncate(n int) *Definition {
+	n = min(max(n, 0), len(d.Directives))
+	return &Definition{Directives: append([]DirectiveWithMetadata{}, d.Directives[:n]...)}
+}
+
+// FindStep returns the index of the directive spec names: a 1-based step
+// number as in "[step N]", or else the last directive whose source is spec
+// or below it (spec "directives[3]" also matches "directives[3] > ..."), or
+// whose provenance file:line is spec.
+func (d *Definition) FindStep(spec string) (int, error) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 1 || n > len(d.Directives) {
+			return 0, fmt.Errorf("step %d out of range 1-%d", n, len(d.Directives))
+		}
+		return n - 1, nil
+	}
+	for i := len(d.Directives) - 1; i >= 0; i-- {
+		dm := d.Directives[i]
+		src := string(dm.Source)
+		if src == spec || strings.HasPrefix(src, spec+" > ") {
+			return i, nil
+		}
+		if p := dm.Provenance; p.File != "" && p.Line > 0 && fmt.Sprintf("%s:%d", p.File, p.Line) == spec {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no step matches %q", spec)
+}
+
 type SourceID string
 
 type Builder interface {
 	Compile() (*Definition, error)
 
+	// SetProvenance attributes directives added later with src to p.
+	SetProvenance(src SourceID, p Provenance) Builder
+
 	AddFromImage(src SourceID, image string) Builder
 
 	AddEnvironment(src SourceID, env map[string]string) Builder
@@ -103,12 +292,31 @@ type Builder interface {
 	AddLiteralFile(src SourceID, name, contents string, executable bool) Builder
 	SetWorkingDirectory(src SourceID, dir string) Builder
 	SetCurrentUser(src SourceID, user string) Builder
+	// CurrentUser returns the user set by the last USER of the current
+	// stage, or "" if it has none.
+	CurrentUser() string
 	SetEntryPoint(src SourceID, cmd string) Builder
 	SetExecEntryPoint(src SourceID, argv []string) Builder
+	AddLabels(src SourceID, labels map[string]string) Builder
+	SetCmd(src SourceID, argv []string) Builder
+	SetHealthcheck(src SourceID, hc HealthcheckDirective) Builder
+	AddExpose(src SourceID, ports ...string) Builder
+	AddVolumes(src SourceID, paths ...string) Builder
+	SetShell(src SourceID, argv []string) Builder
+	AddArg(src SourceID, arg ArgDirective) Builder
+	AddStage(src SourceID, name, image string) Builder
+	AddCopyFrom(src SourceID, stage string, paths []string, dest string) Builder
+
+	// Len returns the number of directives added so far.
+	Len() int
+	// MergeRuns applies MergeRuns to the directives added after the first
+	// from, so the RUNs among them produce as few layers as possible.
+	MergeRuns(from, max int) Builder
 }
 
 type builderImpl struct {
-	out *Definition
+	out        *Definition
+	provenance map[SourceID]Provenance
 }
 
 func (b *builderImpl) String() string {
@@ -119,20 +327,40 @@ func (b *builderImpl) add(src SourceID, d Directive) *builderImpl {
 	ret := *b
 	ret.out = &Definition{
 		Directives: append(append([]DirectiveWithMetadata{}, b.out.Directives...), DirectiveWithMetadata{
-			Directive: d,
-			Source:    src,
+			Directive:  d,
+			Source:     src,
+			Provenance: b.provenance[src],
 		}),
 	}
 	return &ret
 }
 
+// SetProvenance implements Builder.
+func (b *builderImpl) SetProvenance(src SourceID, p Provenance) Builder {
+	if b.provenance[src] == p {
+		return b
+	}
+	ret := *b
+	ret.provenance = maps.Clone(b.provenance)
+	if ret.provenance == nil {
+		ret.provenance = map[SourceID]Provenance{}
+	}
+	if p.IsZero() {
+		delete(ret.provenance, src)
+	} else {
+		ret.provenance[src] = p
+	}
+	return &ret
+}
+
 func (b *builderImpl) AddFromImage(src SourceID, image string) Builder {
 	return b.add(src, FromImageDirective(image))
 }
 
 // AddEnvironment implements Builder.
 func (b *builderImpl) AddEnvironment(src SourceID, env map[string]string) Builder {
-	return b.add(src, EnvironmentDirective(env))
+	// Copy so later changes to the caller's map cannot alter compiled output.
+	return b.add(src, EnvironmentDirective(maps.Clone(env)))
 }
 
 // AddRunCommand implements Builder.
@@ -169,6 +397,16 @@ func (b *builderImpl) SetCurrentUser(src SourceID, user string) Builder {
 	return b.add(src, UserDirective(user))
 }
 
+// CurrentUser implements Builder.
+func (b *builderImpl) CurrentUser() string {
+	for _, d := range slices.Backward(b.out.FinalStage()) {
+		if u, ok := d.Directive.(UserDirective); ok {
+			return string(u)
+		}
+	}
+	return ""
+}
+
 // SetEntryPoint implements Builder.
 func (b *builderImpl) SetEntryPoint(src SourceID, cmd string) Builder {
 	return b.add(src, EntryPointDirective(cmd))
@@ -182,6 +420,67 @@ func (b *builderImpl) SetExecEntryPoint(src SourceID, argv []string) Builder {
 	return b.add(src, ExecEntryPointDirective(out))
 }
 
+// AddLabels implements Builder.
+func (b *builderImpl) AddLabels(src SourceID, labels map[string]string) Builder {
+	return b.add(src, LabelDirective(maps.Clone(labels)))
+}
+
+// SetCmd implements Builder.
+func (b *builderImpl) SetCmd(src SourceID, argv []string) Builder {
+	return b.add(src, CmdDirective(append([]string{}, argv...)))
+}
+
+// SetHealthcheck implements Builder.
+func (b *builderImpl) SetHealthcheck(src SourceID, hc HealthcheckDirective) Builder {
+	hc.Command = append([]string{}, hc.Command...)
+	return b.add(src, hc)
+}
+
+// AddExpose implements Builder.
+func (b *builderImpl) AddExpose(src SourceID, ports ...string) Builder {
+	return b.add(src, ExposeDirective(append([]string{}, ports...)))
+}
+
+// AddVolumes implements Builder.
+func (b *builderImpl) AddVolumes(src SourceID, paths ...string) Builder {
+	return b.add(src, VolumeDirective(append([]string{}, paths...)))
+}
+
+// SetShell implements Builder.
+func (b *builderImpl) SetShell(src SourceID, argv []string) Builder {
+	return b.add(src, ShellDirective(append([]string{}, argv...)))
+}
+
+// AddArg implements Builder.
+func (b *builderImpl) AddArg(src SourceID, arg ArgDirective) Builder {
+	return b.add(src, arg)
+}
+
+// AddStage implements Builder.
+func (b *builderImpl) AddStage(src SourceID, name, image string) Builder {
+	return b.add(src, StageDirective{Name: name, Image: image})
+}
+
+// AddCopyFrom implements Builder.
+func (b *builderImpl) AddCopyFrom(src SourceID, stage string, paths []string, dest string) Builder {
+	return b.add(src, CopyFromDirective{Stage: stage, Src: append([]string{}, paths...), Dest: dest})
+}
+
+// Len implements Builder.
+func (b *builderImpl) Len() int {
+	return len(b.out.Directives)
+}
+
+// MergeRuns implements Builder.
+func (b *builderImpl) MergeRuns(from, max int) Builder {
+	ret := *b
+	dirs := b.out.Directives
+	ret.out = &Definition{
+		Directives: append(append([]DirectiveWithMetadata{}, dirs[:from]...), MergeRuns(dirs[from:], max)...),
+	}
+	return &ret
+}
+
 func (b *builderImpl) Compile() (*Definition, error) {
 	return b.out, nil
 }
@@ -0,0 +1,39 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindStep(t *testing.T) {
+	def, err := New().
+		AddFromImage("<default>", "ubuntu:24.04").
+		SetProvenance("directives[0] > template tool[0]", Provenance{File: "build.yaml", Line: 12}).
+		AddRunCommand("directives[0] > template tool[0]", "echo one").
+		AddRunCommand("directives[0] > template tool[1]", "echo two").
+		AddRunCommand("directives[1]", "echo three").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	tests := map[string]int{
+		"1":                                0,
+		"4":                                3,
+		"directives[0]":                    2,
+		"directives[0] > template tool[0]": 1,
+		"build.yaml:12":                    1,
+	}
+	for spec, want := range tests {
+		if got, err := def.FindStep(spec); err != nil || got != want {
+			t.Fatalf("FindStep(%q) = %d, %v; want %d", spec, got, err, want)
+		}
+	}
+	for _, spec := range []string{"0", "5", "directives[2]", "directives"} {
+		if _, err := def.FindStep(spec); err == nil {
+			t.Fatalf("FindStep(%q) succeeded", spec)
+		}
+	}
+	if got := def.Truncate(2).Directives; len(got) != 2 || !strings.Contains(got[1].Describe(), "tool[0]") {
+		t.Fatalf("Truncate(2) = %+v", got)
+	}
+}
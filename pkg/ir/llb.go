@@ -8,56 +8,126 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/containerd/platforms"
 	"github.com/moby/buildkit/client/llb"
 )
 
 // GenerateLLBDefinition converts the IR into a BuildKit LLB definition.
 // Notes and current limitations:
-//   - Requires a single FROM image; multiple stages are not implemented.
-//   - RUN is executed via exec-form: ["/bin/sh","-lec", <cmd>].
+//   - Every FROM or StageDirective starts a new stage with fresh WORKDIR,
+//     USER, ENV, ARG and SHELL state. The last stage is the result; earlier
+//     ones are only built if a CopyFromDirective needs them. Stages can be
+//     referenced by name or by their zero-based index.
+//   - RUN is executed via exec-form: ["/bin/sh","-lec", <cmd>], or the argv
+//     of the latest SHELL with <cmd> appended.
 //   - WORKDIR is created if missing and used for subsequent ops.
-//   - ENV is applied to subsequent RUN execs (not persisted to image config).
+//   - ENV is applied to subsequent RUN execs; BuildImageConfig persists it.
 //   - USER is applied to subsequent RUN execs; we insert a useradd step if needed.
 //   - COPY sources are taken from local "context" input; multiple sources are
 //     supported by repeating llb.Copy ops.
 //   - LiteralFileDirective is emitted using Mkdir/Mkfile file ops.
-//   - EntryPointDirective / ExecEntryPointDirective are currently ignored.
-//   - RunWithMountsDirective mounts are currently ignored and treated as RUN.
+//   - ARG values (from LLBOptions.BuildArgs, else the default) are added to
+//     the environment of subsequent RUN execs; ENV of the same name wins.
+//   - ENTRYPOINT, CMD, HEALTHCHECK, LABEL, EXPOSE, VOLUME and SHELL produce
+//     no ops; they only reach the exported image through BuildImageConfig.
+//   - RunWithMountsDirective mounts are mapped to exec mounts (see
+//     parseRunMount): bind mounts from an earlier stage or from the local
+//     input named by from= (default "context"), cache and tmpfs mounts.
+//     Submit resolves local inputs through SubmitOptions.LocalDirs, so the
+//     staged cache is expected as "cache" and --local contexts by key.
+//   - Every op is named "[step N] <source>" so solve status can be mapped
+//     back to the directive that produced it (see ReportCollector).
 func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
+	return GenerateLLBDefinitionWithOptions(ir, LLBOptions{})
+}
+
+// LLBOptions holds build-time inputs that are not part of the IR.
+type LLBOptions struct {
+	// BuildArgs supplies values for ARG directives. Names that no ARG
+	// declares are ignored, as with docker build --build-arg.
+	BuildArgs map[string]string
+	// Platform is the target platform, e.g. linux/arm64, as for docker
+	// build --platform. Empty means the platform of the builder.
+	Platform string
+	// Squash copies the final filesystem onto scratch, so the image has a
+	// single layer. Pass SubmitOptions.Squash too, so the exported image
+	// config drops the base image's layer history.
+	Squash bool
+}
+
+// GenerateLLBDefinitionWithOptions is GenerateLLBDefinition with build args.
+func GenerateLLBDefinitionWithOptions(ir *Definition, opts LLBOptions) (*llb.Definition, error) {
 	if ir == nil {
 		return nil, fmt.Errorf("nil ir definition")
 	}
+	var constraints []llb.ConstraintsOpt
+	if opts.Platform != "" {
+		p, err := platforms.Parse(opts.Platform)
+		if err != nil {
+			return nil, fmt.Errorf("platform %q: %w", opts.Platform, err)
+		}
+		constraints = append(constraints, llb.Platform(platforms.Normalize(p)))
+	}
 
 	var (
 		st       llb.State
 		haveFrom bool
 
+		// Completed stages by name and by index, for COPY --from.
+		stages     = map[string]llb.State{}
+		stageIndex = 0
+		stageName  = ""
+
 		// Execution context for subsequent RUNs.
 		cwd  = "/"
 		user = ""
 
 		// ENV applied to subsequent RUNs.
 		env = map[string]string{}
+
+		// Declared build args; visible to RUNs but not persisted.
+		args = map[string]string{}
+
+		shell = append([]string{}, DefaultShell...)
 	)
 
 	runOpts := func() []llb.RunOption {
-		opts := make([]llb.RunOption, 0, 2+len(env))
+		vars := make(map[string]string, len(args)+len(env))
+		for k, v := range args {
+			vars[k] = v
+		}
+		for k, v := range env {
+			vars[k] = v
+		}
+		out := make([]llb.RunOption, 0, 2+len(vars))
 		if cwd != "" {
-			opts = append(opts, llb.Dir(cwd))
+			out = append(out, llb.Dir(cwd))
 		}
 		if user != "" {
-			opts = append(opts, llb.User(user))
+			out = append(out, llb.User(user))
 		}
 		// Stable env order for determinism.
-		keys := make([]string, 0, len(env))
-		for k := range env {
+		keys := make([]string, 0, len(vars))
+		for k := range vars {
 			keys = append(keys, k)
 		}
 		sort.Strings(keys)
 		for _, k := range keys {
-			opts = append(opts, llb.AddEnv(k, env[k]))
+			out = append(out, llb.AddEnv(k, vars[k]))
+		}
+		return out
+	}
+
+	shellArgs := func(cmd string) llb.RunOption {
+		return llb.Args(append(append([]string{}, shell...), cmd))
+	}
+
+	finishStage := func() {
+		stages[fmt.Sprint(stageIndex)] = st
+		if stageName != "" {
+			stages[stageName] = st
 		}
-		return opts
+		stageIndex++
 	}
 
 	absOrJoinWorkdir := func(p string) string {
@@ -70,25 +140,66 @@ func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
 		return filepath.Join(cwd, p)
 	}
 
-	for _, d := range ir.Directives {
+	for i, d := range ir.Directives {
+		name := llb.WithCustomName(llbStepName(i, d.Describe()))
 		switch v := d.Directive.(type) {
-		case FromImageDirective:
-			if v == "" {
+		case FromImageDirective, StageDirective:
+			image, label := "", ""
+			if f, ok := v.(FromImageDirective); ok {
+				image = string(f)
+			} else {
+				sd := v.(StageDirective)
+				image, label = sd.Image, sd.Name
+				if label == "" {
+					return nil, fmt.Errorf("FROM %s: empty stage name", image)
+				}
+			}
+			if image == "" {
 				return nil, fmt.Errorf("FROM: empty image")
 			}
 			if haveFrom {
-				return nil, fmt.Errorf(
-					"multiple FROM stages are not supported yet",
-				)
+				finishStage()
+			}
+			if _, dup := stages[label]; label != "" && dup {
+				return nil, fmt.Errorf("FROM %s AS %s: duplicate stage name", image, label)
+			}
+			stageName = label
+			cwd, user, shell = "/", "", append([]string{}, DefaultShell...)
+			env, args = map[string]string{}, map[string]string{}
+			if base, ok := stages[image]; ok {
+				// FROM <earlier stage> continues from that stage's result.
+				st = base
+			} else {
+				st = llb.Image(image, append([]llb.ImageOption{name}, imageConstraints(constraints)...)...)
 			}
-			st = llb.Image(string(v))
 			haveFrom = true
 
+		case CopyFromDirective:
+			from, ok := stages[v.Stage]
+			if !ok {
+				return nil, fmt.Errorf("COPY --from=%s: unknown or later stage", v.Stage)
+			}
+			if len(v.Src) == 0 {
+				return nil, fmt.Errorf("COPY --from=%s requires at least one source", v.Stage)
+			}
+			dest := absOrJoinWorkdir(v.Dest)
+			if strings.HasSuffix(v.Dest, "/") && !strings.HasSuffix(dest, "/") {
+				dest += "/"
+			}
+			for _, src := range v.Src {
+				st = st.File(llb.Copy(from, src, dest, &llb.CopyInfo{
+					FollowSymlinks:      true,
+					CopyDirContentsOnly: true,
+					AllowWildcard:       true,
+					CreateDestPath:      true,
+				}), name)
+			}
+
 		case EnvironmentDirective:
 			// Normalize whitespace (incl. newlines/tabs) to single spaces to
 			// avoid accidental instruction injections.
-			for k, val := range v {
-				env[k] = strings.Join(strings.Fields(val), " ")
+			for _, k := range v.Keys() {
+				env[k] = strings.Join(strings.Fields(v[k]), " ")
 			}
 
 		case WorkDirDirective:
@@ -97,7 +208,7 @@ func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
 			}
 			cwd = string(v)
 			// Ensure directory exists.
-			st = st.File(llb.Mkdir(cwd, 0o755, llb.WithParents(true)))
+			st = st.File(llb.Mkdir(cwd, 0o755, llb.WithParents(true)), name)
 
 		case UserDirective:
 			if v == "" {
@@ -114,7 +225,7 @@ func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
 				append(
 					[]llb.RunOption{
 						llb.Args([]string{"/bin/sh", "-lec", createUser}),
-						llb.WithCustomName(string(d.Source)),
+						name,
 					},
 					runOpts()...,
 				)...,
@@ -126,25 +237,24 @@ func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
 			st = st.Run(
 				append(
 					[]llb.RunOption{
-						llb.Args([]string{"/bin/sh", "-lec", cmd}),
-						llb.WithCustomName(string(d.Source)),
+						shellArgs(cmd),
+						name,
 					},
 					runOpts()...,
 				)...,
 			).Root()
 
 		case RunWithMountsDirective:
-			// Mount flags not yet mapped to llb mounts; run as plain RUN.
 			cmd := normalizeRunCommand(v.Command)
-			st = st.Run(
-				append(
-					[]llb.RunOption{
-						llb.Args([]string{"/bin/sh", "-lec", cmd}),
-						llb.WithCustomName(string(d.Source)),
-					},
-					runOpts()...,
-				)...,
-			).Root()
+			run := append([]llb.RunOption{shellArgs(cmd), name}, runOpts()...)
+			for _, spec := range v.Mounts {
+				m, err := parseRunMount(spec)
+				if err != nil {
+					return nil, fmt.Errorf("RUN: %w", err)
+				}
+				run = append(run, m.runOption(stages, absOrJoinWorkdir(m.target)))
+			}
+			st = st.Run(run...).Root()
 
 		case CopyDirective:
 			return nil, fmt.Errorf("COPY directive not supported in LLB path yet")
@@ -153,7 +263,7 @@ func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
 			target := absOrJoinWorkdir(v.Name)
 			dir := filepath.Dir(target)
 			if dir != "" && dir != "." && dir != "/" {
-				st = st.File(llb.Mkdir(dir, 0o755, llb.WithParents(true)))
+				st = st.File(llb.Mkdir(dir, 0o755, llb.WithParents(true)), name)
 			}
 			mode := 0o644
 			if v.Executable {
@@ -161,16 +271,28 @@ func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
 			}
 			st = st.File(
 				llb.Mkfile(target, os.FileMode(mode), []byte(v.Contents)),
-				llb.WithCustomName(string(d.Source)),
+				name,
 			)
 
-		case EntryPointDirective:
-			// Not yet persisted to final image config in LLB path.
-			// Intentionally ignored for now.
+		case ShellDirective:
+			if len(v) == 0 {
+				return nil, fmt.Errorf("SHELL: empty argv")
+			}
+			shell = append([]string{}, v...)
+
+		case ArgDirective:
+			if v.Name == "" {
+				return nil, fmt.Errorf("ARG: empty name")
+			}
+			if val, ok := opts.BuildArgs[v.Name]; ok {
+				args[v.Name] = val
+			} else if v.HasDefault {
+				args[v.Name] = v.Default
+			}
 
-		case ExecEntryPointDirective:
-			// Not yet persisted to final image config in LLB path.
-			// Intentionally ignored for now.
+		case EntryPointDirective, ExecEntryPointDirective, CmdDirective,
+			HealthcheckDirective, LabelDirective, ExposeDirective, VolumeDirective:
+			// Image config only; see BuildImageConfig.
 
 		default:
 			return nil, fmt.Errorf("unsupported directive: %T", d)
@@ -180,8 +302,13 @@ func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
 	if !haveFrom {
 		return nil, fmt.Errorf("no FROM image specified")
 	}
+	if opts.Squash {
+		st = llb.Scratch().File(llb.Copy(st, "/", "/", &llb.CopyInfo{
+			CopyDirContentsOnly: true,
+		}), llb.WithCustomName("squash the image into one layer"))
+	}
 
-	def, err := st.Marshal(context.Background())
+	def, err := st.Marshal(context.Background(), constraints...)
 	if err != nil {
 		return nil, fmt.Errorf("marshal LLB: %w", err)
 	}
@@ -189,6 +316,16 @@ func GenerateLLBDefinition(ir *Definition) (*llb.Definition, error) {
 	return def, nil
 }
 
+// imageConstraints passes the platform constraints on to llb.Image, which
+// resolves the image for them.
+func imageConstraints(constraints []llb.ConstraintsOpt) []llb.ImageOption {
+	out := make([]llb.ImageOption, 0, len(constraints))
+	for _, c := range constraints {
+		out = append(out, c)
+	}
+	return out
+}
+
 // normalizeRunCommand removes blank spacer lines that follow a trailing
 // backslash-newline continuation to avoid terminating continued commands.
 func normalizeRunCommand(cmd string) string {
@@ -242,3 +379,102 @@ func normalizeRunCommand(cmd string) string {
 
 	return b.String()
 }
+
+// runMount is a parsed RUN --mount flag.
+type runMount struct {
+	typ      string // bind, cache or tmpfs
+	from     string // stage or local input of a bind mount
+	source   string
+	target   string
+	readonly bool
+	id       string // cache id
+	sharing  llb.CacheMountSharingMode
+}
+
+// parseRunMount parses a Dockerfile RUN --mount flag, with or without its
+// "--mount=" prefix, e.g. type=bind,from=cache,source=/,target=/c,readonly.
+// Bind mounts are read-only unless rw is given, as in Dockerfiles.
+func parseRunMount(spec string) (runMount, error) {
+	m := runMount{typ: "bind", readonly: true, sharing: llb.CacheMountShared}
+	fields := strings.Split(strings.TrimPrefix(spec, "--mount="), ",")
+	rw := false
+	for _, f := range fields {
+		key, val, hasVal := strings.Cut(strings.TrimSpace(f), "=")
+		switch strings.ToLower(key) {
+		case "type":
+			m.typ = val
+		case "from":
+			m.from = val
+		case "source", "src":
+			m.source = val
+		case "target", "dst", "destination":
+			m.target = val
+		case "id":
+			m.id = val
+		case "sharing":
+			switch val {
+			case "shared":
+				m.sharing = llb.CacheMountShared
+			case "private":
+				m.sharing = llb.CacheMountPrivate
+			case "locked":
+				m.sharing = llb.CacheMountLocked
+			default:
+				return m, fmt.Errorf("mount %q: unknown sharing mode %q", spec, val)
+			}
+		case "readonly", "ro":
+			rw = hasVal && val == "false"
+		case "rw", "readwrite":
+			rw = !hasVal || val == "true"
+		case "":
+		default:
+			return m, fmt.Errorf("mount %q: unsupported option %q", spec, key)
+		}
+	}
+	if m.target == "" {
+		return m, fmt.Errorf("mount %q: missing target", spec)
+	}
+	switch m.typ {
+	case "bind":
+		m.readonly = !rw
+		if m.from == "" {
+			m.from = "context"
+		}
+	case "cache":
+		m.readonly = false
+		if m.id == "" {
+			m.id = m.target
+		}
+	case "tmpfs":
+		m.readonly = false
+	default:
+		return m, fmt.Errorf("mount %q: unsupported type %q", spec, m.typ)
+	}
+	return m, nil
+}
+
+// runOption returns the exec mount of m at target. A bind mount's from
+// names an earlier stage, or else a local input.
+func (m runMount) runOption(stages map[string]llb.State, target string) llb.RunOption {
+	switch m.typ {
+	case "cache":
+		return llb.AddMount(target, llb.Scratch(), llb.AsPersistentCacheDir(m.id, m.sharing))
+	case "tmpfs":
+		return llb.AddMount(target, llb.Scratch(), llb.Tmpfs())
+	}
+	src, ok := stages[m.from]
+	if !ok {
+		src = llb.Local(m.from, llb.SharedKeyHint(m.from))
+	}
+	opts := []llb.MountOption{}
+	if m.source != "" && m.source != "/" {
+		opts = append(opts, llb.SourcePath(m.source))
+	}
+	if m.readonly {
+		opts = append(opts, llb.Readonly)
+	} else {
+		// Writes to a rw bind mount are discarded, as in Dockerfiles.
+		opts = append(opts, llb.ForceNoOutput)
+	}
+	return llb.AddMount(target, src, opts...)
+}
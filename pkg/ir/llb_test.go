@@ -0,0 +1,209 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/moby/buildkit/solver/pb"
+)
+
+// llbOps decodes every op of def.
+func llbOps(t *testing.T, def *Definition) []*pb.Op {
+	t.Helper()
+	llbDef, err := GenerateLLBDefinition(def)
+	if err != nil {
+		t.Fatalf("GenerateLLBDefinition: %v", err)
+	}
+	var ops []*pb.Op
+	for _, dt := range llbDef.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			t.Fatalf("unmarshal op: %v", err)
+		}
+		ops = append(ops, &op)
+	}
+	return ops
+}
+
+func TestGenerateLLBCopiesFromEarlierStage(t *testing.T) {
+	def, err := New().
+		AddStage("build", "compile", "golang:1.25").
+		AddEnvironment("env", map[string]string{"CGO_ENABLED": "0"}).
+		AddRunCommand("run", "go build -o /out/tool ./cmd/tool").
+		AddFromImage("from", "alpine:3.20").
+		AddCopyFrom("copy", "compile", []string{"/out/tool"}, "/usr/local/bin/").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var images []string
+	var copies int
+	for _, op := range llbOps(t, def) {
+		if src := op.GetSource(); src != nil {
+			images = append(images, src.Identifier)
+		}
+		if file := op.GetFile(); file != nil {
+			for _, action := range file.Actions {
+				if cp := action.GetCopy(); cp != nil {
+					copies++
+					if cp.Src != "/out/tool" || cp.Dest != "/usr/local/bin/" {
+						t.Fatalf("copy %q -> %q", cp.Src, cp.Dest)
+					}
+				}
+			}
+		}
+	}
+	if len(images) != 2 {
+		t.Fatalf("expected both stage images to be sources, got %v", images)
+	}
+	if copies != 1 {
+		t.Fatalf("expected 1 copy action, got %d", copies)
+	}
+}
+
+func TestGenerateLLBRejectsUnknownStage(t *testing.T) {
+	for name, b := range map[string]Builder{
+		"unknown": New().
+			AddFromImage("from", "alpine:3.20").
+			AddCopyFrom("copy", "missing", []string{"/x"}, "/x"),
+		"later": New().
+			AddFromImage("from", "alpine:3.20").
+			AddCopyFrom("copy", "compile", []string{"/x"}, "/x").
+			AddStage("build", "compile", "golang:1.25"),
+		"duplicate": New().
+			AddStage("a", "compile", "golang:1.25").
+			AddStage("b", "compile", "golang:1.25").
+			AddFromImage("from", "alpine:3.20"),
+	} {
+		def, err := b.Compile()
+		if err != nil {
+			t.Fatalf("%s: Compile: %v", name, err)
+		}
+		if _, err := GenerateLLBDefinition(def); err == nil || !strings.Contains(err.Error(), "stage") {
+			t.Fatalf("%s: error = %v, want a stage error", name, err)
+		}
+	}
+}
+
+func TestGenerateLLBMapsRunMounts(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddRunWithMounts("run", []string{
+			"--mount=type=bind,from=cache,source=/,target=/.neurocontainer-cache,readonly",
+			"--mount=type=bind,from=weights,source=/,target=/.neurocontainer-local/weights,readonly",
+			"--mount=type=cache,target=/root/.cache/pip",
+		}, "pip install /.neurocontainer-cache/pkg.whl").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	locals := map[string]bool{}
+	var mounts []*pb.Mount
+	for _, op := range llbOps(t, def) {
+		if src := op.GetSource(); src != nil && strings.HasPrefix(src.Identifier, "local://") {
+			locals[strings.TrimPrefix(src.Identifier, "local://")] = true
+		}
+		if exec := op.GetExec(); exec != nil {
+			mounts = exec.Mounts
+		}
+	}
+	if !locals["cache"] || !locals["weights"] || len(locals) != 2 {
+		t.Fatalf("local inputs = %v, want cache and weights", locals)
+	}
+	targets := map[string]*pb.Mount{}
+	for _, m := range mounts {
+		targets[m.Dest] = m
+	}
+	if m := targets["/.neurocontainer-cache"]; m == nil || !m.Readonly {
+		t.Fatalf("cache bind mount = %+v", m)
+	}
+	if m := targets["/root/.cache/pip"]; m == nil || m.MountType != pb.MountType_CACHE {
+		t.Fatalf("pip cache mount = %+v", m)
+	}
+}
+
+func TestParseRunMountRejectsUnsupported(t *testing.T) {
+	for _, spec := range []string{
+		"--mount=type=secret,id=token,target=/run/secrets/token",
+		"--mount=type=bind,source=/",
+		"--mount=type=cache,target=/c,sharing=sometimes",
+	} {
+		if _, err := parseRunMount(spec); err == nil {
+			t.Fatalf("parseRunMount(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestGenerateLLBTargetsPlatform(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddRunCommand("run", "uname -m").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	llbDef, err := GenerateLLBDefinitionWithOptions(def, LLBOptions{Platform: "linux/arm64"})
+	if err != nil {
+		t.Fatalf("GenerateLLBDefinitionWithOptions: %v", err)
+	}
+	var checked int
+	for _, dt := range llbDef.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			t.Fatalf("unmarshal op: %v", err)
+		}
+		if op.GetSource() == nil && op.GetExec() == nil {
+			continue
+		}
+		if p := op.Platform; p == nil || p.OS != "linux" || p.Architecture != "arm64" {
+			t.Fatalf("op platform = %+v, want linux/arm64", p)
+		}
+		checked++
+	}
+	if checked != 2 {
+		t.Fatalf("checked %d ops, want the image and the RUN", checked)
+	}
+	if _, err := GenerateLLBDefinitionWithOptions(def, LLBOptions{Platform: "linux/"}); err == nil {
+		t.Fatalf("expected an error for an invalid platform")
+	}
+}
+
+func TestGenerateLLBSquashCopiesOntoScratch(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddRunCommand("run", "echo one > /one").
+		AddRunCommand("run", "echo two > /two").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	llbDef, err := GenerateLLBDefinitionWithOptions(def, LLBOptions{Squash: true})
+	if err != nil {
+		t.Fatalf("GenerateLLBDefinitionWithOptions: %v", err)
+	}
+	var copies int
+	for _, dt := range llbDef.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			t.Fatalf("unmarshal op: %v", err)
+		}
+		file := op.GetFile()
+		if file == nil {
+			continue
+		}
+		for _, a := range file.Actions {
+			cp := a.GetCopy()
+			if cp == nil {
+				continue
+			}
+			copies++
+			// The destination of the copy is scratch: no input.
+			if a.Input != -1 || cp.Src != "/" || cp.Dest != "/" {
+				t.Fatalf("squash copy = input %d, %q -> %q; want scratch, / -> /", a.Input, cp.Src, cp.Dest)
+			}
+		}
+	}
+	if copies != 1 {
+		t.Fatalf("found %d copies, want the squash copy", copies)
+	}
+}
@@ -0,0 +1,106 @@
+package ir
+
+import "strings"
+
+// MergeRuns merges runs of consecutive RUN directives into one RUN each,
+// of at most max directives when max > 0, so they produce one layer. Each
+// command runs in a subshell, so a cd or export in one does not reach the
+// next, as it would not across RUNs.
+//
+// Directives that only set image config (LABEL, EXPOSE, CMD, ENTRYPOINT,
+// HEALTHCHECK) do not stop a merge: they move after the merged RUN. Any
+// other directive does, as does a RUN with a mount at the target of a
+// different mount of the RUNs before it. A merged RUN keeps the source and
+// provenance of its first directive.
+func MergeRuns(dirs []DirectiveWithMetadata, max int) []DirectiveWithMetadata {
+	var out, run, moved []DirectiveWithMetadata
+	flush := func() {
+		if len(run) > 0 {
+			out = append(out, mergeRun(run))
+		}
+		out = append(out, moved...)
+		run, moved = nil, nil
+	}
+	for _, dm := range dirs {
+		switch dm.Directive.(type) {
+		case RunDirective, RunWithMountsDirective:
+			if len(run) > 0 && (max > 0 && len(run) >= max || !mountsCompatible(run, dm)) {
+				flush()
+			}
+			run = append(run, dm)
+		case LabelDirective, ExposeDirective, CmdDirective, EntryPointDirective, ExecEntryPointDirective, HealthcheckDirective:
+			if len(run) == 0 {
+				out = append(out, dm)
+			} else {
+				moved = append(moved, dm)
+			}
+		default:
+			flush()
+			out = append(out, dm)
+		}
+	}
+	flush()
+	return out
+}
+
+// MergeRuns returns d with MergeRuns applied to its directives.
+func (d *Definition) MergeRuns(max int) *Definition {
+	return &Definition{Directives: MergeRuns(d.Directives, max)}
+}
+
+// runParts returns the mounts and command of a RUN directive.
+func runParts(d Directive) ([]string, string) {
+	switch v := d.(type) {
+	case RunDirective:
+		return nil, string(v)
+	case RunWithMountsDirective:
+		return v.Mounts, v.Command
+	}
+	return nil, ""
+}
+
+// mountsCompatible reports whether the mounts of next can be added to those
+// of run: every target is mounted the same way or not at all.
+func mountsCompatible(run []DirectiveWithMetadata, next DirectiveWithMetadata) bool {
+	byTarget := map[string]string{}
+	for _, dm := range run {
+		mounts, _ := runParts(dm.Directive)
+		for _, m := range mounts {
+			byTarget[mountTarget(m)] = m
+		}
+	}
+	mounts, _ := runParts(next.Directive)
+	for _, m := range mounts {
+		if prev, ok := byTarget[mountTarget(m)]; ok && prev != m {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeRun(run []DirectiveWithMetadata) DirectiveWithMetadata {
+	if len(run) == 1 {
+		return run[0]
+	}
+	var mounts, commands []string
+	seen := map[string]bool{}
+	for _, dm := range run {
+		ms, cmd := runParts(dm.Directive)
+		for _, m := range ms {
+			if !seen[m] {
+				seen[m] = true
+				mounts = append(mounts, m)
+			}
+		}
+		// The newline ends a trailing comment before the closing paren.
+		commands = append(commands, "(\n"+cmd+"\n)")
+	}
+	merged := run[0]
+	command := strings.Join(commands, " &&\n ")
+	if len(mounts) > 0 {
+		merged.Directive = RunWithMountsDirective{Mounts: mounts, Command: command}
+	} else {
+		merged.Directive = RunDirective(command)
+	}
+	return merged
+}
@@ -0,0 +1,55 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMergeRuns(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "ubuntu:24.04").
+		AddRunCommand("a", "cd /tmp && echo a").
+		AddLabels("label", map[string]string{"x": "1"}).
+		AddRunCommand("b", "echo b # comment").
+		AddRunWithMounts("c", []string{"--mount=type=cache,target=/cache,id=one"}, "echo c").
+		AddRunWithMounts("d", []string{"--mount=type=cache,target=/cache,id=two"}, "echo d").
+		AddEnvironment("env", map[string]string{"A": "1"}).
+		AddRunCommand("e", "echo e").
+		AddRunCommand("f", "echo f").
+		AddRunCommand("g", "echo g").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var kinds []string
+	for _, dm := range def.MergeRuns(2).Directives {
+		kinds = append(kinds, fmt.Sprintf("%T %s", dm.Directive, dm.Source))
+	}
+	want := []string{
+		"ir.FromImageDirective from",
+		"ir.RunDirective a",
+		"ir.LabelDirective label",
+		"ir.RunWithMountsDirective c",
+		"ir.RunWithMountsDirective d",
+		"ir.EnvironmentDirective env",
+		"ir.RunDirective e",
+		"ir.RunDirective g",
+	}
+	if strings.Join(kinds, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("MergeRuns(2) =\n%s\nwant\n%s", strings.Join(kinds, "\n"), strings.Join(want, "\n"))
+	}
+
+	merged := def.MergeRuns(0)
+	run, ok := merged.Directives[1].Directive.(RunWithMountsDirective)
+	if !ok || run.Command != "(\ncd /tmp && echo a\n) &&\n (\necho b # comment\n) &&\n (\necho c\n)" || len(run.Mounts) != 1 {
+		t.Fatalf("merged RUN = %#v", merged.Directives[1].Directive)
+	}
+	// d mounts /cache differently from c, so it starts a new RUN.
+	if got := len(merged.Directives); got != 6 {
+		t.Fatalf("MergeRuns(0) has %d directives, want 6", got)
+	}
+	if _, err := GenerateDockerfile(merged); err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Provenance records where in a recipe a directive was written. The recipe
+// compiler fills in what it knows; every field is optional.
+type Provenance struct {
+	// File is the recipe or include file, relative to the recipe directory
+	// or include directory it was found in.
+	File string `json:"file,omitempty"`
+	// Line is the 1-based line of the directive in File.
+	Line int `json:"line,omitempty"`
+	// Template names the template the directive was expanded from.
+	Template string `json:"template,omitempty"`
+	// StarlarkCall is the script position ("file:line:col") of the builtin
+	// call that added the directive.
+	StarlarkCall string `json:"starlark_call,omitempty"`
+}
+
+// IsZero reports whether nothing is known about the directive's origin.
+func (p Provenance) IsZero() bool {
+	return p == Provenance{}
+}
+
+// String renders p as e.g. "build.yaml:12, template fsl".
+func (p Provenance) String() string {
+	var parts []string
+	switch {
+	case p.File != "" && p.Line > 0:
+		parts = append(parts, fmt.Sprintf("%s:%d", p.File, p.Line))
+	case p.File != "":
+		parts = append(parts, p.File)
+	}
+	if p.Template != "" {
+		parts = append(parts, "template "+p.Template)
+	}
+	if p.StarlarkCall != "" {
+		parts = append(parts, "starlark "+p.StarlarkCall)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Describe names the directive by its source and, when known, its
+// provenance, e.g. "directives[3] (build.yaml:12, template fsl)".
+func (d DirectiveWithMetadata) Describe() string {
+	if d.Provenance.IsZero() {
+		return string(d.Source)
+	}
+	return fmt.Sprintf("%s (%s)", d.Source, d.Provenance)
+}
@@ -0,0 +1,248 @@
+package ir
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	bkclient "github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+)
+
+// DirectiveStatus describes how a directive fared in a build.
+type DirectiveStatus string
+
+const (
+	// DirectiveCached means every vertex for the directive was a cache hit.
+	DirectiveCached DirectiveStatus = "cached"
+	// DirectiveBuilt means at least one vertex for the directive was executed.
+	DirectiveBuilt DirectiveStatus = "built"
+	// DirectiveFailed means a vertex for the directive reported an error.
+	DirectiveFailed DirectiveStatus = "failed"
+	// DirectiveNotRun means the directive produced vertices that never completed,
+	// typically because an earlier step failed.
+	DirectiveNotRun DirectiveStatus = "not-run"
+	// DirectiveNoOp means the directive produced no vertices of its own (ENV,
+	// ENTRYPOINT, ...); it only affects later steps or the image config.
+	DirectiveNoOp DirectiveStatus = "no-op"
+)
+
+// DirectiveReport is the per-directive entry of a BuildReport.
+type DirectiveReport struct {
+	Step       int             `json:"step"`
+	Source     SourceID        `json:"source"`
+	Provenance Provenance      `json:"provenance,omitzero"`
+	Kind       string          `json:"kind"`
+	Label      string          `json:"label,omitempty"`
+	Status     DirectiveStatus `json:"status"`
+	Vertices   int             `json:"vertices"`
+	Duration   time.Duration   `json:"duration_ns"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// BuildReport summarises which IR directives were served from the BuildKit
+// cache and which were rebuilt.
+type BuildReport struct {
+	Recipe     string            `json:"recipe,omitempty"`
+	Version    string            `json:"version,omitempty"`
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	Duration   time.Duration     `json:"duration_ns"`
+	Cached     int               `json:"cached"`
+	Built      int               `json:"built"`
+	Failed     int               `json:"failed"`
+	Error      string            `json:"error,omitempty"`
+	Directives []DirectiveReport `json:"directives"`
+}
+
+// llbStepName is the custom vertex name given to every op generated for the
+// directive at index. The "[step N]" prefix lets solve status be mapped back
+// to IR directives; the description (see DirectiveWithMetadata.Describe)
+// keeps the name readable in progress output.
+func llbStepName(index int, desc string) string {
+	return fmt.Sprintf("[step %d] %s", index+1, desc)
+}
+
+var llbStepNamePattern = regexp.MustCompile(`^\[step (\d+)\] `)
+
+// parseLLBStepName returns the directive index encoded by llbStepName.
+func parseLLBStepName(name string) (int, bool) {
+	m := llbStepNamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+type vertexState struct {
+	step      int
+	cached    bool
+	started   *time.Time
+	completed *time.Time
+	err       string
+}
+
+// ReportCollector folds BuildKit solve status into a BuildReport. Vertices
+// are mapped to directives through the custom names set by
+// GenerateLLBDefinition.
+type ReportCollector struct {
+	def      *Definition
+	started  time.Time
+	vertices map[string]*vertexState
+	steps    map[int]int
+}
+
+// NewReportCollector indexes the vertices of llbDef by directive.
+func NewReportCollector(def *Definition, llbDef *llb.Definition) (*ReportCollector, error) {
+	names, err := buildVertexNameIndex(llbDef)
+	if err != nil {
+		return nil, err
+	}
+	c := &ReportCollector{
+		def:      def,
+		started:  time.Now(),
+		vertices: map[string]*vertexState{},
+		steps:    map[int]int{},
+	}
+	for dgst, name := range names {
+		step, ok := parseLLBStepName(name)
+		if !ok || step >= len(def.Directives) {
+			continue
+		}
+		c.vertices[dgst] = &vertexState{step: step}
+		c.steps[step]++
+	}
+	return c, nil
+}
+
+// Observe records the vertex updates carried by a status event.
+func (c *ReportCollector) Observe(s *bkclient.SolveStatus) {
+	if s == nil {
+		return
+	}
+	for _, v := range s.Vertexes {
+		st, ok := c.vertices[v.Digest.String()]
+		if !ok {
+			continue
+		}
+		if v.Cached {
+			st.cached = true
+		}
+		if v.Started != nil {
+			st.started = v.Started
+		}
+		if v.Completed != nil {
+			st.completed = v.Completed
+		}
+		if v.Error != "" {
+			st.err = v.Error
+		}
+	}
+}
+
+// Report builds the report from everything observed so far. buildErr is the
+// overall solve error, if any.
+func (c *ReportCollector) Report(buildErr error) BuildReport {
+	rep := BuildReport{
+		Started:    c.started,
+		Finished:   time.Now(),
+		Directives: make([]DirectiveReport, len(c.def.Directives)),
+	}
+	rep.Duration = rep.Finished.Sub(rep.Started)
+	if buildErr != nil {
+		rep.Error = buildErr.Error()
+	}
+
+	type agg struct {
+		cached, completed int
+		dur               time.Duration
+		err               string
+	}
+	aggs := make([]agg, len(c.def.Directives))
+	for _, st := range c.vertices {
+		a := &aggs[st.step]
+		if st.err != "" && a.err == "" {
+			a.err = st.err
+		}
+		switch {
+		case st.cached:
+			a.cached++
+		case st.completed != nil:
+			a.completed++
+			if st.started != nil {
+				a.dur += st.completed.Sub(*st.started)
+			}
+		}
+	}
+
+	for i, d := range c.def.Directives {
+		a := aggs[i]
+		dr := DirectiveReport{
+			Step:       i + 1,
+			Source:     d.Source,
+			Provenance: d.Provenance,
+			Kind:       DirectiveKind(d.Directive),
+			Vertices:   c.steps[i],
+			Duration:   a.dur,
+			Error:      a.err,
+		}
+		switch {
+		case dr.Vertices == 0:
+			dr.Status = DirectiveNoOp
+		case a.err != "":
+			dr.Status = DirectiveFailed
+			rep.Failed++
+		case a.cached == dr.Vertices:
+			dr.Status = DirectiveCached
+			rep.Cached++
+		case a.cached+a.completed == dr.Vertices:
+			dr.Status = DirectiveBuilt
+			rep.Built++
+		default:
+			dr.Status = DirectiveNotRun
+		}
+		rep.Directives[i] = dr
+	}
+	return rep
+}
+
+// DirectiveKind returns the Dockerfile-style instruction name for d.
+func DirectiveKind(d Directive) string {
+	switch d.(type) {
+	case FromImageDirective, StageDirective:
+		return "FROM"
+	case EnvironmentDirective:
+		return "ENV"
+	case RunDirective, RunWithMountsDirective:
+		return "RUN"
+	case CopyDirective, CopyFromDirective, LiteralFileDirective:
+		return "COPY"
+	case WorkDirDirective:
+		return "WORKDIR"
+	case UserDirective:
+		return "USER"
+	case EntryPointDirective, ExecEntryPointDirective:
+		return "ENTRYPOINT"
+	case LabelDirective:
+		return "LABEL"
+	case CmdDirective:
+		return "CMD"
+	case HealthcheckDirective:
+		return "HEALTHCHECK"
+	case ExposeDirective:
+		return "EXPOSE"
+	case VolumeDirective:
+		return "VOLUME"
+	case ShellDirective:
+		return "SHELL"
+	case ArgDirective:
+		return "ARG"
+	default:
+		return fmt.Sprintf("%T", d)
+	}
+}
@@ -0,0 +1,119 @@
+package ir
+
+import (
+	"testing"
+	"time"
+
+	bkclient "github.com/moby/buildkit/client"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestParseLLBStepName(t *testing.T) {
+	step, ok := parseLLBStepName(llbStepName(4, "build.yaml:12"))
+	if !ok || step != 4 {
+		t.Fatalf("parseLLBStepName = %d, %v; want 4, true", step, ok)
+	}
+	for _, name := range []string{"", "build.yaml:12", "[step 0] x", "[internal] load metadata"} {
+		if _, ok := parseLLBStepName(name); ok {
+			t.Fatalf("parseLLBStepName(%q) unexpectedly matched", name)
+		}
+	}
+}
+
+func TestReportCollectorMapsVerticesToDirectives(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "ubuntu:22.04").
+		AddEnvironment("env", map[string]string{"A": "1"}).
+		AddRunCommand("run1", "echo one").
+		AddRunCommand("run2", "echo two").
+		AddRunCommand("run3", "echo three").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	llbDef, err := GenerateLLBDefinition(def)
+	if err != nil {
+		t.Fatalf("GenerateLLBDefinition: %v", err)
+	}
+	c, err := NewReportCollector(def, llbDef)
+	if err != nil {
+		t.Fatalf("NewReportCollector: %v", err)
+	}
+
+	names, err := buildVertexNameIndex(llbDef)
+	if err != nil {
+		t.Fatalf("buildVertexNameIndex: %v", err)
+	}
+	byStep := map[int]string{}
+	for dgst, name := range names {
+		if step, ok := parseLLBStepName(name); ok {
+			byStep[step] = dgst
+		}
+	}
+
+	t0 := time.Now()
+	t1 := t0.Add(2 * time.Second)
+	c.Observe(&bkclient.SolveStatus{Vertexes: []*bkclient.Vertex{
+		{Digest: digest.Digest(byStep[0]), Cached: true, Started: &t0, Completed: &t0},
+		{Digest: digest.Digest(byStep[2]), Cached: true, Started: &t0, Completed: &t0},
+		{Digest: digest.Digest(byStep[3]), Started: &t0},
+	}})
+	// A later update for the same vertex completes it.
+	c.Observe(&bkclient.SolveStatus{Vertexes: []*bkclient.Vertex{
+		{Digest: digest.Digest(byStep[3]), Started: &t0, Completed: &t1},
+	}})
+
+	rep := c.Report(nil)
+	want := []DirectiveStatus{DirectiveCached, DirectiveNoOp, DirectiveCached, DirectiveBuilt, DirectiveNotRun}
+	if len(rep.Directives) != len(want) {
+		t.Fatalf("got %d directives, want %d", len(rep.Directives), len(want))
+	}
+	for i, w := range want {
+		if got := rep.Directives[i].Status; got != w {
+			t.Fatalf("directive %d (%s): status %q, want %q", i, rep.Directives[i].Source, got, w)
+		}
+	}
+	if rep.Cached != 2 || rep.Built != 1 || rep.Failed != 0 {
+		t.Fatalf("counts cached=%d built=%d failed=%d", rep.Cached, rep.Built, rep.Failed)
+	}
+	if d := rep.Directives[3].Duration; d != 2*time.Second {
+		t.Fatalf("run2 duration = %v, want 2s", d)
+	}
+	if rep.Directives[2].Kind != "RUN" || rep.Directives[0].Kind != "FROM" {
+		t.Fatalf("unexpected kinds: %q, %q", rep.Directives[0].Kind, rep.Directives[2].Kind)
+	}
+}
+
+func TestReportCollectorRecordsFailures(t *testing.T) {
+	def, err := New().
+		AddFromImage("from", "ubuntu:22.04").
+		AddRunCommand("run", "false").
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	llbDef, err := GenerateLLBDefinition(def)
+	if err != nil {
+		t.Fatalf("GenerateLLBDefinition: %v", err)
+	}
+	c, err := NewReportCollector(def, llbDef)
+	if err != nil {
+		t.Fatalf("NewReportCollector: %v", err)
+	}
+	names, _ := buildVertexNameIndex(llbDef)
+	for dgst, name := range names {
+		if step, ok := parseLLBStepName(name); ok && step == 1 {
+			c.Observe(&bkclient.SolveStatus{Vertexes: []*bkclient.Vertex{
+				{Digest: digest.Digest(dgst), Error: "exit code: 1"},
+			}})
+		}
+	}
+	rep := c.Report(nil)
+	if rep.Directives[1].Status != DirectiveFailed || rep.Failed != 1 {
+		t.Fatalf("expected run to be failed, got %+v", rep.Directives[1])
+	}
+	if rep.Directives[1].Error != "exit code: 1" {
+		t.Fatalf("error = %q", rep.Directives[1].Error)
+	}
+}
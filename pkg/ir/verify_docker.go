@@ -0,0 +1,189 @@
+package ir
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+// expectedInstruction is an instruction that a directive should produce in
+// the Dockerfile, as far as VerifyDockerfile checks it.
+type expectedInstruction struct {
+	step    int
+	keyword string
+	// args are the FROM image and stage name, or the COPY sources and
+	// destination.
+	args []string
+	// from is the stage of a COPY --from.
+	from string
+	// heredocs are the contents of the heredoc sources of a COPY.
+	heredocs []string
+}
+
+// expectedInstructions returns the instructions the directives of ir map to,
+// worked out from the IR alone: one per directive, none for an empty ENV or
+// LABEL, and a RUN creating the user before the first USER of a user other
+// than root in each stage.
+func expectedInstructions(ir *Definition) []expectedInstruction {
+	var out []expectedInstruction
+	users := map[string]bool{"root": true}
+	for i, d := range ir.Directives {
+		add := func(keyword string, args ...string) {
+			out = append(out, expectedInstruction{step: i, keyword: keyword, args: args})
+		}
+		switch v := d.Directive.(type) {
+		case FromImageDirective:
+			add("from", string(v))
+			users = map[string]bool{"root": true}
+		case StageDirective:
+			add("from", v.Image, v.Name)
+			users = map[string]bool{"root": true}
+		case CopyFromDirective:
+			add("copy", append(slices.Clone(v.Src), v.Dest)...)
+			out[len(out)-1].from = v.Stage
+		case CopyDirective:
+			add("copy", v.Parts...)
+		case EnvironmentDirective:
+			if len(v) > 0 {
+				add("env")
+			}
+		case LabelDirective:
+			if len(v) > 0 {
+				add("label")
+			}
+		case LiteralFileDirective:
+			if src := literalFileSource(v); src != "" {
+				add("copy", src, v.Name)
+				break
+			}
+			if !heredocPath(v.Name) {
+				add("run")
+				break
+			}
+			contents := v.Contents
+			if !strings.HasSuffix(contents, "\n") {
+				contents += "\n"
+			}
+			add("copy", v.Name)
+			out[len(out)-1].heredocs = []string{contents}
+		case RunDirective, RunWithMountsDirective:
+			add("run")
+		case WorkDirDirective:
+			add("workdir")
+		case UserDirective:
+			if !users[string(v)] {
+				add("run")
+				users[string(v)] = true
+			}
+			add("user")
+		case EntryPointDirective, ExecEntryPointDirective:
+			add("entrypoint")
+		case CmdDirective:
+			add("cmd")
+		case HealthcheckDirective:
+			add("healthcheck")
+		case ExposeDirective:
+			add("expose")
+		case VolumeDirective:
+			add("volume")
+		case ShellDirective:
+			add("shell")
+		case ArgDirective:
+			add("arg")
+		}
+	}
+	return out
+}
+
+// VerifyDockerfile checks that dockerfile, as generated from ir by
+// GenerateDockerfile, parses with the BuildKit parser into the instructions
+// the IR calls for: as many, of the same kinds and in the same order, with
+// the same FROM images and stage names and the same COPY sources,
+// destinations and --from stages. A mismatch is a bug in the generator; the
+// error names the first one, with the step it belongs to.
+func VerifyDockerfile(ir *Definition, dockerfile string) error {
+	if ir == nil {
+		return fmt.Errorf("nil ir definition")
+	}
+	res, err := parser.Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		return fmt.Errorf("parsing generated Dockerfile: %w", err)
+	}
+	nodes := res.AST.Children
+	expected := expectedInstructions(ir)
+
+	for i, want := range expected {
+		step := llbStepName(want.step, ir.Directives[want.step].Describe())
+		if i >= len(nodes) {
+			return fmt.Errorf("%s: the Dockerfile ends before its %s instruction (%d of %d instructions)", step, strings.ToUpper(want.keyword), len(nodes), len(expected))
+		}
+		node := nodes[i]
+		if !strings.EqualFold(node.Value, want.keyword) {
+			return fmt.Errorf("%s: expected %s, Dockerfile line %d has %s", step, strings.ToUpper(want.keyword), node.StartLine, strings.ToUpper(node.Value))
+		}
+		switch want.keyword {
+		case "from":
+			got := nodeArgs(node)
+			if len(got) == 3 && strings.EqualFold(got[1], "as") {
+				got = []string{got[0], got[2]}
+			}
+			if len(want.args) == 2 && want.args[1] == "" {
+				want.args = want.args[:1]
+			}
+			if !slices.Equal(got, want.args) {
+				return fmt.Errorf("%s: expected FROM %s, Dockerfile line %d has FROM %s", step, strings.Join(want.args, " AS "), node.StartLine, strings.Join(got, " AS "))
+			}
+		case "copy":
+			// The parser decodes the JSON form; words of the shell form
+			// are quoted as GenerateDockerfile quotes them. Heredoc
+			// sources are compared by their contents.
+			var got, heredocs []string
+			for _, arg := range nodeArgs(node) {
+				if !node.Attributes["json"] {
+					if parser.MustParseHeredoc(arg) != nil {
+						continue
+					}
+					if unquoted, err := strconv.Unquote(arg); err == nil {
+						arg = unquoted
+					}
+				}
+				got = append(got, arg)
+			}
+			for _, h := range node.Heredocs {
+				heredocs = append(heredocs, h.Content)
+			}
+			if !slices.Equal(got, want.args) {
+				return fmt.Errorf("%s: expected COPY %q, Dockerfile line %d copies %q", step, want.args, node.StartLine, got)
+			}
+			if !slices.Equal(heredocs, want.heredocs) {
+				return fmt.Errorf("%s: expected COPY heredocs %q, Dockerfile line %d has %q", step, want.heredocs, node.StartLine, heredocs)
+			}
+			from := ""
+			for _, f := range node.Flags {
+				if v, ok := strings.CutPrefix(f, "--from="); ok {
+					from = v
+				}
+			}
+			if from != want.from {
+				return fmt.Errorf("%s: expected COPY --from=%q, Dockerfile line %d has --from=%q", step, want.from, node.StartLine, from)
+			}
+		}
+	}
+	if len(nodes) > len(expected) {
+		node := nodes[len(expected)]
+		return fmt.Errorf("Dockerfile line %d: unexpected %s after the last step (%d instructions, expected %d)", node.StartLine, strings.ToUpper(node.Value), len(nodes), len(expected))
+	}
+	return nil
+}
+
+// nodeArgs returns the arguments of an instruction node, as written.
+func nodeArgs(node *parser.Node) []string {
+	var args []string
+	for n := node.Next; n != nil; n = n.Next {
+		args = append(args, n.Value)
+	}
+	return args
+}
@@ -0,0 +1,91 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+)
+
+func verifyTestDefinition(t *testing.T) *Definition {
+	t.Helper()
+	def, err := New().
+		AddArg("arg", ArgDirective{Name: "VERSION", Default: "1", HasDefault: true}).
+		AddStage("builder", "build", "ubuntu:24.04").
+		AddRunCommand("compile", "make").
+		AddFromImage("from", "ubuntu:24.04").
+		AddEnvironment("empty env", map[string]string{}).
+		AddEnvironment("env", map[string]string{"A": "1", "B": "2"}).
+		AddCopy("copy", "cache/my file.tar.gz", "other", "/opt/dir with spaces/").
+		AddCopyFrom("copy from", "build", []string{"/out"}, "/opt/out").
+		AddLiteralFile("literal", "/etc/motd", "hello\nworld\n", false).
+		SetCurrentUser("user", "jovyan").
+		SetCurrentUser("root", "root").
+		SetCurrentUser("user again", "jovyan").
+		SetWorkingDirectory("workdir", "/work").
+		SetCmd("cmd", []string{"bash"}).
+		Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	return def
+}
+
+func TestVerifyDockerfileAcceptsGeneratedDockerfile(t *testing.T) {
+	def := verifyTestDefinition(t)
+	for _, generate := range []func(*Definition) (string, error){GenerateDockerfile, ExplainDockerfile} {
+		out, err := generate(def)
+		if err != nil {
+			t.Fatalf("generating: %v", err)
+		}
+		if err := VerifyDockerfile(def, out); err != nil {
+			t.Fatalf("VerifyDockerfile: %v\n%s", err, out)
+		}
+	}
+}
+
+func TestVerifyDockerfileReportsMismatches(t *testing.T) {
+	def := verifyTestDefinition(t)
+	out, err := GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	cases := []struct {
+		name, old, new, want string
+	}{
+		{"missing instruction", "WORKDIR /work\n", "", "[step 13] workdir: expected WORKDIR"},
+		{"extra instruction", "CMD [\"bash\"]\n", "CMD [\"bash\"]\nEXPOSE 80\n", "unexpected EXPOSE after the last step"},
+		{"reordered", "WORKDIR /work\nCMD [\"bash\"]\n", "CMD [\"bash\"]\nWORKDIR /work\n", "expected WORKDIR, Dockerfile line"},
+		{"copy source", `"other"`, `"another"`, "[step 7] copy: expected COPY"},
+		{"split copy source", `COPY ["cache/my file.tar.gz","other","/opt/dir with spaces/"]`, `COPY "cache/my file.tar.gz" "other" "/opt/dir with spaces/"`, `copies ["\"cache/my" "file.tar.gz\""`},
+		{"copy from", "--from=build", "--from=builder", `expected COPY --from="build"`},
+		{"heredoc", "world\nEOF", "there\nEOF", "[step 9] literal: expected COPY heredocs"},
+		{"stage name", "AS build", "AS built", "expected FROM ubuntu:24.04 AS build,"},
+		{"truncated", "FROM ubuntu:24.04\n", "FROM ubuntu:24.04\n# end\n", ""},
+	}
+	for _, c := range cases {
+		if !strings.Contains(out, c.old) {
+			t.Fatalf("%s: %q is not in:\n%s", c.name, c.old, out)
+		}
+		err := VerifyDockerfile(def, strings.Replace(out, c.old, c.new, 1))
+		if c.want == "" {
+			if err != nil {
+				t.Fatalf("%s: VerifyDockerfile: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: VerifyDockerfile = %v; want an error containing %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestVerifyDockerfileReportsAShortDockerfile(t *testing.T) {
+	def := verifyTestDefinition(t)
+	out, err := GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	short := out[:strings.Index(out, "WORKDIR")]
+	if err := VerifyDockerfile(def, short); err == nil || !strings.Contains(err.Error(), "the Dockerfile ends before its WORKDIR instruction") {
+		t.Fatalf("VerifyDockerfile = %v; want an error about the missing WORKDIR", err)
+	}
+}
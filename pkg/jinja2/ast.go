@@ -1,26 +1,53 @@
 package jinja2
 
+import "fmt"
+
 // Node is any AST node in a parsed Jinja2 template.
 type Node interface {
 	node()
 }
 
+// Pos locates a tag in a template source.
+type Pos struct {
+	// Name is the template name, empty for inline templates.
+	Name   string
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	if p.Name != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Name, p.Line, p.Column)
+	}
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
 // Document is the root node produced by Parse.
 type Document struct {
+	Name  string
 	Nodes []Node
 }
 
 func (*Document) node() {}
 
-// TextNode represents literal text between tags.
+// TextNode represents literal text between tags. Whitespace removed by '-'
+// markers is already stripped from Text; the flags let the renderer apply
+// trim_blocks and lstrip_blocks.
 type TextNode struct {
 	Text string
+	// AfterBlock is set when the text directly follows a {% %} or {# #} tag.
+	AfterBlock bool
+	// BeforeBlock is set when the text directly precedes a {% %} or {# #} tag.
+	BeforeBlock bool
+	// AtStart is set when the text starts the template.
+	AtStart bool
 }
 
 func (*TextNode) node() {}
 
 // OutputNode represents a variable/output expression: {{ expr }}
 type OutputNode struct {
+	Pos  Pos
 	Expr string
 }
 
@@ -28,6 +55,7 @@ func (*OutputNode) node() {}
 
 // SetNode represents a simple assignment: {% set name = expr %}
 type SetNode struct {
+	Pos  Pos
 	Name string
 	Expr string
 }
@@ -36,6 +64,7 @@ func (*SetNode) node() {}
 
 // IfNode represents an if/elif/else block.
 type IfNode struct {
+	Pos   Pos
 	Cond  string
 	Then  []Node
 	Elifs []ElifBranch
@@ -46,12 +75,14 @@ func (*IfNode) node() {}
 
 // ElifBranch is a single elif condition with its body.
 type ElifBranch struct {
+	Pos  Pos
 	Cond string
 	Body []Node
 }
 
 // ForNode represents a for loop: {% for target in iterable %}
 type ForNode struct {
+	Pos      Pos
 	Target   string
 	Iterable string
 	Body     []Node
@@ -70,6 +101,7 @@ func (*RawNode) node() {}
 
 // BlockNode represents a named block for template inheritance.
 type BlockNode struct {
+	Pos  Pos
 	Name string
 	Body []Node
 }
@@ -78,6 +110,7 @@ func (*BlockNode) node() {}
 
 // ExtendsNode declares that this template extends a parent template.
 type ExtendsNode struct {
+	Pos      Pos
 	Template string
 }
 
@@ -85,7 +118,34 @@ func (*ExtendsNode) node() {}
 
 // IncludeNode includes another template by name.
 type IncludeNode struct {
+	Pos      Pos
 	Template string
 }
 
 func (*IncludeNode) node() {}
+
+// MacroNode defines a macro: {% macro name(a, b="x") %}...{% endmacro %}
+type MacroNode struct {
+	Pos    Pos
+	Name   string
+	Params []MacroParam
+	Body   []Node
+}
+
+func (*MacroNode) node() {}
+
+// MacroParam is a macro parameter with an optional default expression.
+type MacroParam struct {
+	Name    string
+	Default string
+}
+
+// CallNode invokes a macro with a body available to it as caller():
+// {% call name(args) %}...{% endcall %}
+type CallNode struct {
+	Pos  Pos
+	Expr string
+	Body []Node
+}
+
+func (*CallNode) node() {}
@@ -0,0 +1,37 @@
+package jinja2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TemplateError is a parse or render error located at a tag of a template.
+type TemplateError struct {
+	Pos Pos
+	// Tag is the offending tag as written, e.g. "{{ self.url }}".
+	Tag string
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Pos, e.Tag, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error { return e.Err }
+
+// locate wraps err with the position and tag it occurred at, unless it
+// already carries a (more precise) location from a nested tag or template.
+func locate(err error, pos Pos, tag string) error {
+	if err == nil {
+		return nil
+	}
+	var te *TemplateError
+	if errors.As(err, &te) {
+		return err
+	}
+	return &TemplateError{Pos: pos, Tag: tag, Err: err}
+}
+
+func outputTag(expr string) string { return "{{ " + expr + " }}" }
+
+func stmtTag(stmt string) string { return "{% " + stmt + " %}" }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -86,6 +87,141 @@ func DefaultFilters() Filters {
 				return StringValue(val.String()), nil
 			}
 		},
+		"replace": func(val Value, args []Value) (Value, error) {
+			if len(args) < 2 || len(args) > 3 {
+				return nil, fmt.Errorf("replace expects old, new and an optional count")
+			}
+			n := -1
+			if len(args) == 3 {
+				c, ok := args[2].(IntValue)
+				if !ok {
+					return nil, fmt.Errorf("replace count must be an integer")
+				}
+				n = int(c)
+			}
+			return StringValue(strings.Replace(val.String(), args[0].String(), args[1].String(), n)), nil
+		},
+		"split": func(val Value, args []Value) (Value, error) {
+			var parts []string
+			switch {
+			case len(args) == 0 || args[0] == (NoneValue{}):
+				parts = strings.Fields(val.String())
+			case len(args) == 1:
+				parts = strings.Split(val.String(), args[0].String())
+			default:
+				n, ok := args[1].(IntValue)
+				if !ok {
+					return nil, fmt.Errorf("split maxsplit must be an integer")
+				}
+				parts = strings.SplitN(val.String(), args[0].String(), int(n)+1)
+			}
+			out := make(ListValue, 0, len(parts))
+			for _, p := range parts {
+				out = append(out, StringValue(p))
+			}
+			return out, nil
+		},
+		"regex_replace": func(val Value, args []Value) (Value, error) {
+			if len(args) < 1 || len(args) > 3 {
+				return nil, fmt.Errorf("regex_replace expects a pattern, a replacement and an optional ignorecase")
+			}
+			// String literals are not unescaped by this engine, so fold the
+			// doubled backslashes of Ansible-style '\\d+' and '\\1'.
+			pattern := strings.ReplaceAll(args[0].String(), `\\`, `\`)
+			if len(args) == 3 && args[2].Truth() {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("regex_replace: %w", err)
+			}
+			repl := ""
+			if len(args) > 1 {
+				repl = pythonReplacement(strings.ReplaceAll(args[1].String(), `\\`, `\`))
+			}
+			return StringValue(re.ReplaceAllString(val.String(), repl)), nil
+		},
+		"basename": func(val Value, _ []Value) (Value, error) {
+			s := val.String()
+			return StringValue(s[strings.LastIndexByte(s, '/')+1:]), nil
+		},
+		"dirname": func(val Value, _ []Value) (Value, error) {
+			s := val.String()
+			head := s[:strings.LastIndexByte(s, '/')+1]
+			if trimmed := strings.TrimRight(head, "/"); trimmed != "" {
+				head = trimmed
+			}
+			return StringValue(head), nil
+		},
+		"int": func(val Value, args []Value) (Value, error) {
+			def := Value(IntValue(0))
+			if len(args) > 0 {
+				def = args[0]
+			}
+			base := 10
+			if len(args) > 1 {
+				b, ok := args[1].(IntValue)
+				if !ok {
+					return nil, fmt.Errorf("int base must be an integer")
+				}
+				base = int(b)
+			}
+			switch v := val.(type) {
+			case IntValue:
+				return v, nil
+			case FloatValue:
+				return IntValue(int64(v)), nil
+			case BoolValue:
+				if v {
+					return IntValue(1), nil
+				}
+				return IntValue(0), nil
+			}
+			s := strings.ReplaceAll(strings.TrimSpace(val.String()), "_", "")
+			if base != 10 {
+				s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0x"), "0o"), "0b")
+			}
+			if n, err := strconv.ParseInt(s, base, 64); err == nil {
+				return IntValue(n), nil
+			}
+			if f, err := strconv.ParseFloat(s, 64); err == nil && base == 10 {
+				return IntValue(int64(f)), nil
+			}
+			return def, nil
+		},
+		"float": func(val Value, args []Value) (Value, error) {
+			def := Value(FloatValue(0))
+			if len(args) > 0 {
+				def = args[0]
+			}
+			switch v := val.(type) {
+			case FloatValue:
+				return v, nil
+			case IntValue:
+				return FloatValue(float64(v)), nil
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(val.String()), 64); err == nil {
+				return FloatValue(f), nil
+			}
+			return def, nil
+		},
+		"bool": func(val Value, _ []Value) (Value, error) {
+			switch v := val.(type) {
+			case BoolValue:
+				return v, nil
+			case StringValue:
+				switch strings.ToLower(strings.TrimSpace(string(v))) {
+				case "yes", "on", "1", "true", "y":
+					return BoolValue(true), nil
+				}
+				return BoolValue(false), nil
+			case IntValue:
+				return BoolValue(v == 1), nil
+			case FloatValue:
+				return BoolValue(v == 1), nil
+			}
+			return BoolValue(false), nil
+		},
 		"length": func(val Value, _ []Value) (Value, error) {
 			switch v := val.(type) {
 			case StringValue:
@@ -106,14 +242,243 @@ func DefaultFilters() Filters {
 	}
 }
 
+// pythonReplacement converts a Python re.sub replacement (\\1, \\g<name>)
+// into Go regexp syntax (${1}, ${name}).
+func pythonReplacement(repl string) string {
+	var b strings.Builder
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		switch {
+		case c == '$':
+			b.WriteString("$$")
+		case c == '\\' && i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9':
+			j := i + 1
+			for j < len(repl) && repl[j] >= '0' && repl[j] <= '9' {
+				j++
+			}
+			b.WriteString("${" + repl[i+1:j] + "}")
+			i = j - 1
+		case c == '\\' && strings.HasPrefix(repl[i+1:], "g<"):
+			if end := strings.IndexByte(repl[i+3:], '>'); end >= 0 {
+				b.WriteString("${" + repl[i+3:i+3+end] + "}")
+				i += 3 + end
+				continue
+			}
+			b.WriteByte(c)
+		case c == '\\' && i+1 < len(repl) && repl[i+1] == '\\':
+			b.WriteByte('\\')
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// testFilters returns the select/reject family, which run e's tests on
+// list items (or on one of their attributes).
+func (e *Evaluator) testFilters() Filters {
+	// pick returns the filter keeping items whose test result equals want.
+	pick := func(name string, byAttr, want bool) func(val Value, args []Value) (Value, error) {
+		return func(val Value, args []Value) (Value, error) {
+			items, err := iterateValue(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s expects an iterable", name)
+			}
+			attr := ""
+			if byAttr {
+				if len(args) == 0 {
+					return nil, fmt.Errorf("%s expects an attribute name", name)
+				}
+				attr, args = args[0].String(), args[1:]
+			}
+			var test func(Value, []Value) (bool, error)
+			testName := ""
+			if len(args) > 0 {
+				testName = args[0].String()
+				if test = e.Tests[testName]; test == nil {
+					return nil, fmt.Errorf("%s: unknown test: %s", name, testName)
+				}
+				args = args[1:]
+			}
+			out := ListValue{}
+			for _, it := range items {
+				subject := it
+				if byAttr {
+					subject = e.lookupPath(it, attr)
+				}
+				var ok bool
+				switch {
+				case test == nil:
+					ok = subject != nil && subject.Truth()
+				case subject == nil && testName != "defined" && testName != "undefined":
+					// A missing attribute fails every other test.
+				default:
+					if ok, err = test(subject, args); err != nil {
+						return nil, err
+					}
+				}
+				if ok == want {
+					out = append(out, it)
+				}
+			}
+			return out, nil
+		}
+	}
+	return Filters{
+		"select":     pick("select", false, true),
+		"reject":     pick("reject", false, false),
+		"selectattr": pick("selectattr", true, true),
+		"rejectattr": pick("rejectattr", true, false),
+	}
+}
+
+// lookupPath resolves a dotted attribute path on v, returning nil when a
+// segment is missing.
+func (e *Evaluator) lookupPath(v Value, path string) Value {
+	for _, key := range strings.Split(path, ".") {
+		next, ok := e.lookupValue(v, key)
+		if !ok {
+			return nil
+		}
+		v = next
+	}
+	return v
+}
+
+// Tests is a registry of test functions used by `x is name` expressions.
+// The value is nil when the tested expression is undefined; only the
+// defined and undefined tests are called with one.
+type Tests map[string]func(val Value, args []Value) (bool, error)
+
+// DefaultTests provides the common Jinja2 tests.
+func DefaultTests() Tests {
+	tests := Tests{
+		"defined":   func(val Value, _ []Value) (bool, error) { return val != nil, nil },
+		"undefined": func(val Value, _ []Value) (bool, error) { return val == nil, nil },
+		"none": func(val Value, _ []Value) (bool, error) {
+			_, ok := val.(NoneValue)
+			return ok, nil
+		},
+		"boolean": func(val Value, _ []Value) (bool, error) {
+			_, ok := val.(BoolValue)
+			return ok, nil
+		},
+		"true":  func(val Value, _ []Value) (bool, error) { return val == BoolValue(true), nil },
+		"false": func(val Value, _ []Value) (bool, error) { return val == BoolValue(false), nil },
+		"integer": func(val Value, _ []Value) (bool, error) {
+			_, ok := val.(IntValue)
+			return ok, nil
+		},
+		"float": func(val Value, _ []Value) (bool, error) {
+			_, ok := val.(FloatValue)
+			return ok, nil
+		},
+		"number": func(val Value, _ []Value) (bool, error) {
+			switch val.(type) {
+			case IntValue, FloatValue:
+				return true, nil
+			}
+			return false, nil
+		},
+		"string": func(val Value, _ []Value) (bool, error) {
+			_, ok := val.(StringValue)
+			return ok, nil
+		},
+		"mapping": func(val Value, _ []Value) (bool, error) {
+			if _, ok := val.(DictValue); ok {
+				return true, nil
+			}
+			return reflect.ValueOf(val).Kind() == reflect.Map, nil
+		},
+		"sequence": func(val Value, _ []Value) (bool, error) {
+			switch val.(type) {
+			case StringValue, ListValue, DictValue:
+				return true, nil
+			}
+			switch reflect.ValueOf(val).Kind() {
+			case reflect.Slice, reflect.Array, reflect.Map:
+				return true, nil
+			}
+			return false, nil
+		},
+		"iterable": func(val Value, _ []Value) (bool, error) {
+			if _, ok := val.(NoneValue); ok {
+				return false, nil
+			}
+			_, err := iterateValue(val)
+			return err == nil, nil
+		},
+		"callable": func(val Value, _ []Value) (bool, error) {
+			_, ok := val.(CallableValue)
+			return ok, nil
+		},
+		"lower": func(val Value, _ []Value) (bool, error) {
+			s, ok := val.(StringValue)
+			return ok && strings.ToLower(string(s)) == string(s), nil
+		},
+		"upper": func(val Value, _ []Value) (bool, error) {
+			s, ok := val.(StringValue)
+			return ok && strings.ToUpper(string(s)) == string(s), nil
+		},
+		"even": func(val Value, _ []Value) (bool, error) {
+			n, ok := val.(IntValue)
+			return ok && n%2 == 0, nil
+		},
+		"odd": func(val Value, _ []Value) (bool, error) {
+			n, ok := val.(IntValue)
+			return ok && n%2 != 0, nil
+		},
+		"divisibleby": func(val Value, args []Value) (bool, error) {
+			if len(args) != 1 {
+				return false, fmt.Errorf("divisibleby expects one argument")
+			}
+			n, ok := val.(IntValue)
+			d, dok := args[0].(IntValue)
+			if !ok || !dok || d == 0 {
+				return false, fmt.Errorf("divisibleby expects non-zero integers")
+			}
+			return n%d == 0, nil
+		},
+		"eq": func(val Value, args []Value) (bool, error) {
+			if len(args) != 1 {
+				return false, fmt.Errorf("eq expects one argument")
+			}
+			return val.String() == args[0].String(), nil
+		},
+		"ne": func(val Value, args []Value) (bool, error) {
+			if len(args) != 1 {
+				return false, fmt.Errorf("ne expects one argument")
+			}
+			return val.String() != args[0].String(), nil
+		},
+	}
+	tests["equalto"] = tests["eq"]
+	return tests
+}
+
+// UndefinedError reports a lookup of a variable, attribute or key that does
+// not exist. `is defined` tests treat it as an undefined value.
+type UndefinedError struct {
+	Message string
+}
+
+func (e UndefinedError) Error() string { return e.Message }
+
+func undefinedf(format string, args ...any) error {
+	return UndefinedError{Message: fmt.Sprintf(format, args...)}
+}
+
 type Evaluator struct {
 	Filters Filters
+	Tests   Tests
 	Funcs   map[string]func(args []Value) (Value, error)
 }
 
 func NewEvaluator() *Evaluator {
-	return &Evaluator{
+	e := &Evaluator{
 		Filters: DefaultFilters(),
+		Tests:   DefaultTests(),
 		Funcs: map[string]func(args []Value) (Value, error){
 			"raise": func(args []Value) (Value, error) {
 				if len(args) == 0 {
@@ -123,15 +488,40 @@ func NewEvaluator() *Evaluator {
 			},
 		},
 	}
+	for name, fn := range e.testFilters() {
+		e.Filters[name] = fn
+	}
+	return e
 }
 
 // Eval evaluates a minimal expression language for variable lookup, string and
-// numeric literals, and a simple filter pipeline (e.g., name|upper|default("x")).
+// numeric literals, a simple filter pipeline (e.g., name|upper|default("x")),
+// inline conditionals (a if cond else b) and tests (x is defined).
 func (e *Evaluator) Eval(expr string, ctx Context) (Value, error) {
 	expr = strings.TrimSpace(expr)
 	if expr == "" {
 		return StringValue(""), nil
 	}
+	if then, cond, els, ok := splitConditional(expr); ok {
+		b, err := e.Truthy(cond, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return e.Eval(then, ctx)
+		}
+		if els == "" {
+			return NoneValue{}, nil
+		}
+		return e.Eval(els, ctx)
+	}
+	if i := keywordIndex(expr, "is", 0); i >= 0 {
+		b, err := e.evalTest(expr[:i], expr[i+len("is"):], ctx)
+		if err != nil {
+			return nil, err
+		}
+		return BoolValue(b), nil
+	}
 	parts, err := splitPipes(expr)
 	if err != nil {
 		return nil, err
@@ -167,6 +557,14 @@ func (e *Evaluator) Truthy(expr string, ctx Context) (bool, error) {
 	for hasOuterParens(s) {
 		s = trimOuterParens(s)
 	}
+	// An inline conditional binds loosest, so it owns any and/or inside it.
+	if _, _, _, ok := splitConditional(s); ok {
+		v, err := e.Eval(s, ctx)
+		if err != nil {
+			return false, err
+		}
+		return v.Truth(), nil
+	}
 	if strings.HasPrefix(s, "not ") {
 		b, err := e.Truthy(strings.TrimSpace(s[4:]), ctx)
 		if err != nil {
@@ -283,6 +681,118 @@ func (e *Evaluator) Truthy(expr string, ctx Context) (bool, error) {
 	return v.Truth(), nil
 }
 
+// evalTest evaluates `lhs is [not] test`, where test is a name optionally
+// followed by call arguments (divisibleby(3)) or a single bare argument
+// (divisibleby 3).
+func (e *Evaluator) evalTest(lhs, test string, ctx Context) (bool, error) {
+	test = strings.TrimSpace(test)
+	negate := false
+	if rest, ok := strings.CutPrefix(test, "not "); ok {
+		negate = true
+		test = strings.TrimSpace(rest)
+	}
+	var name string
+	var args []Value
+	if i := strings.IndexAny(test, " \t("); i >= 0 && test[i] != '(' {
+		name = test[:i]
+		arg, err := e.evalAtom(test[i+1:], ctx)
+		if err != nil {
+			return false, err
+		}
+		args = []Value{arg}
+	} else {
+		var err error
+		if name, args, err = e.parseFilterCall(test, ctx); err != nil {
+			return false, err
+		}
+	}
+	fn := e.Tests[name]
+	if fn == nil {
+		return false, fmt.Errorf("unknown test: %s", name)
+	}
+	val, err := e.Eval(lhs, ctx)
+	if err != nil {
+		var undef UndefinedError
+		if !errors.As(err, &undef) || (name != "defined" && name != "undefined") {
+			return false, err
+		}
+		val = nil
+	}
+	b, err := fn(val, args)
+	if err != nil {
+		return false, fmt.Errorf("test %s: %w", name, err)
+	}
+	return b != negate, nil
+}
+
+// splitConditional splits `then if cond else els` at its top-level if and
+// else keywords. els is empty when the else branch is omitted.
+func splitConditional(s string) (then, cond, els string, ok bool) {
+	i := keywordIndex(s, "if", 0)
+	if i <= 0 {
+		return "", "", "", false
+	}
+	then = strings.TrimSpace(s[:i])
+	rest := i + len("if")
+	if j := keywordIndex(s, "else", rest); j >= 0 {
+		cond = strings.TrimSpace(s[rest:j])
+		els = strings.TrimSpace(s[j+len("else"):])
+		if els == "" {
+			return "", "", "", false
+		}
+	} else {
+		cond = strings.TrimSpace(s[rest:])
+	}
+	if then == "" || cond == "" {
+		return "", "", "", false
+	}
+	return then, cond, els, true
+}
+
+// keywordIndex returns the byte offset of the first whole-word occurrence of
+// kw in s at or after from, outside quotes and brackets, or -1.
+func keywordIndex(s, kw string, from int) int {
+	depth := 0
+	inStr := byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inStr != 0 {
+			if c == inStr {
+				inStr = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			inStr = c
+			continue
+		case '(', '[', '{':
+			depth++
+			continue
+		case ')', ']', '}':
+			if depth > 0 {
+				depth--
+			}
+			continue
+		}
+		if i < from || depth != 0 || !strings.HasPrefix(s[i:], kw) {
+			continue
+		}
+		if i > 0 && isWordChar(s[i-1]) {
+			continue
+		}
+		if end := i + len(kw); end < len(s) && isWordChar(s[end]) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
 func splitLogical(s, op string) ([]string, bool) {
 	lower := strings.ToLower(s)
 	depthParen, depthBracket, depthBrace := 0, 0, 0
@@ -806,6 +1316,11 @@ func (e *Evaluator) evalAtom(s string, ctx Context) (Value, error) {
 	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
 		return IntValue(n), nil
 	}
+	if c := strings.TrimPrefix(s, "-"); c != "" && c[0] >= '0' && c[0] <= '9' {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return FloatValue(f), nil
+		}
+	}
 	if s == "true" {
 		return BoolValue(true), nil
 	}
@@ -842,7 +1357,7 @@ func (e *Evaluator) evalAtom(s string, ctx Context) (Value, error) {
 	if v, ok := ctx[s]; ok {
 		return v, nil
 	}
-	return nil, fmt.Errorf("undefined variable: %s", s)
+	return nil, undefinedf("undefined variable: %s", s)
 }
 
 // hasOuterParens reports whether s is wrapped by a single pair of matching
@@ -1001,13 +1516,9 @@ func (e *Evaluator) evalRef(s string, ctx Context) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
-		var args []Value
-		for _, as := range argStrs {
-			v, err := e.evalAtom(as, ctx)
-			if err != nil {
-				return nil, err
-			}
-			args = append(args, v)
+		args, err := e.callArgs(argStrs, ctx)
+		if err != nil {
+			return nil, err
 		}
 		if fn, ok := e.Funcs[name]; ok {
 			v, err := fn(args)
@@ -1037,7 +1548,7 @@ func (e *Evaluator) evalRef(s string, ctx Context) (Value, error) {
 		}
 		v0, ok := ctx[name]
 		if !ok {
-			return nil, fmt.Errorf("undefined variable: %s", name)
+			return nil, undefinedf("undefined variable: %s", name)
 		}
 		cur = v0
 	}
@@ -1059,7 +1570,7 @@ func (e *Evaluator) evalRef(s string, ctx Context) (Value, error) {
 			if nv, ok := e.lookupOrMethod(cur, attr); ok {
 				cur = nv
 			} else {
-				return nil, fmt.Errorf("undefined attribute: %s on %T", attr, cur)
+				return nil, undefinedf("undefined attribute: %s on %T", attr, cur)
 			}
 			skipSpaces()
 			// optional call immediately after attribute
@@ -1068,13 +1579,9 @@ func (e *Evaluator) evalRef(s string, ctx Context) (Value, error) {
 				if err != nil {
 					return nil, err
 				}
-				var args []Value
-				for _, as := range argStrs {
-					v, err := e.evalAtom(as, ctx)
-					if err != nil {
-						return nil, err
-					}
-					args = append(args, v)
+				args, err := e.callArgs(argStrs, ctx)
+				if err != nil {
+					return nil, err
 				}
 				cv, ok := cur.(CallableValue)
 				if !ok {
@@ -1104,7 +1611,7 @@ func (e *Evaluator) evalRef(s string, ctx Context) (Value, error) {
 				if vv, ok := base[key]; ok {
 					cur = vv
 				} else {
-					return nil, fmt.Errorf("key not found: %s", key)
+					return nil, undefinedf("key not found: %s", key)
 				}
 			case ListValue:
 				// list/tuple indexing by integer
@@ -1125,7 +1632,7 @@ func (e *Evaluator) evalRef(s string, ctx Context) (Value, error) {
 					if mv.IsValid() {
 						cur = FromGo(mv.Interface())
 					} else {
-						return nil, fmt.Errorf("key not found: %s", kv.String())
+						return nil, undefinedf("key not found: %s", kv.String())
 					}
 				case reflect.Slice, reflect.Array:
 					idx, ok := asInt(kv)
@@ -1148,6 +1655,37 @@ func (e *Evaluator) evalRef(s string, ctx Context) (Value, error) {
 	return cur, nil
 }
 
+// keywordArg is a `name=value` call argument. Macros bind it to the named
+// parameter; other callables see its value.
+type keywordArg struct {
+	Name  string
+	Value Value
+}
+
+func (k keywordArg) String() string { return k.Value.String() }
+func (k keywordArg) Truth() bool    { return k.Value.Truth() }
+
+// callArgs evaluates call arguments, turning `name=expr` into keywordArg.
+func (e *Evaluator) callArgs(argStrs []string, ctx Context) ([]Value, error) {
+	var args []Value
+	for _, as := range argStrs {
+		if name, expr, ok := strings.Cut(as, "="); ok && isIdentifier(strings.TrimSpace(name)) && !strings.HasPrefix(expr, "=") {
+			v, err := e.Eval(expr, ctx)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, keywordArg{Name: strings.TrimSpace(name), Value: v})
+			continue
+		}
+		v, err := e.Eval(as, ctx)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return args, nil
+}
+
 // lookupOrMethod attempts attribute lookup, then falls back to method binding.
 func (e *Evaluator) lookupOrMethod(v Value, key string) (Value, bool) {
 	if vv, ok := e.lookupValue(v, key); ok {
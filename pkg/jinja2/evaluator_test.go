@@ -1,6 +1,7 @@
 package jinja2
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -143,3 +144,228 @@ func TestLogicalAndOr(t *testing.T) {
 		t.Fatalf("got %q, want TRUE", got)
 	}
 }
+
+func TestInlineConditional(t *testing.T) {
+	cases := []struct {
+		tpl  string
+		ctx  Context
+		want string
+	}{
+		{"{{ 'gpu' if cuda else 'cpu' }}", Context{"cuda": BoolValue(true)}, "gpu"},
+		{"{{ 'gpu' if cuda else 'cpu' }}", Context{"cuda": BoolValue(false)}, "cpu"},
+		{"[{{ 'x' if n > 3 }}]", Context{"n": IntValue(1)}, "[]"},
+		{"{{ a if a is defined and a else 'fallback' }}", Context{}, "fallback"},
+		{"{{ 'a' if v == '1' else 'b' if v == '2' else 'c' }}", Context{"v": StringValue("2")}, "b"},
+		{"{{ (self.v if self.v else 'none')|upper }}", Context{"self": DictValue{"v": StringValue("x")}}, "X"},
+		{"{% if ('yes' if flag else '') %}Y{% else %}N{% endif %}", Context{"flag": BoolValue(false)}, "N"},
+		{"{% set m = 'gif' if diff else 'png' %}{{ m }}", Context{"diff": BoolValue(true)}, "gif"},
+	}
+	for _, tc := range cases {
+		got, err := renderHelper(t, tc.tpl, tc.ctx)
+		if err != nil {
+			t.Fatalf("%s: render error: %v", tc.tpl, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.tpl, got, tc.want)
+		}
+	}
+}
+
+func TestIsTests(t *testing.T) {
+	ctx := Context{
+		"s":    StringValue("abc"),
+		"n":    IntValue(6),
+		"f":    FloatValue(1.5),
+		"none": NoneValue{},
+		"l":    ListValue{StringValue("a")},
+		"d":    DictValue{"k": StringValue("v")},
+		"self": DictValue{"version": StringValue("1.0")},
+	}
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{"s is defined", true},
+		{"missing is defined", false},
+		{"missing is undefined", true},
+		{"self.url is defined", false},
+		{"d['nope'] is not defined", true},
+		{"self.version is defined", true},
+		{"none is none", true},
+		{"s is none", false},
+		{"s is not none", true},
+		{"s is string", true},
+		{"n is string", false},
+		{"n is number", true},
+		{"f is number", true},
+		{"f is integer", false},
+		{"s is number", false},
+		{"d is mapping", true},
+		{"l is mapping", false},
+		{"l is iterable", true},
+		{"s is iterable", true},
+		{"n is iterable", false},
+		{"l is sequence", true},
+		{"n is even", true},
+		{"n is divisibleby 3", true},
+		{"n is divisibleby(4)", false},
+		{"s is eq('abc')", true},
+		{"s|upper is upper", true},
+		{"s is defined and n is odd", false},
+		{"not missing is defined", true},
+	}
+	e := NewEvaluator()
+	for _, tc := range cases {
+		got, err := e.Truthy(tc.expr, ctx)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	v, err := e.Eval("missing is defined", ctx)
+	if err != nil || v != BoolValue(false) {
+		t.Fatalf("Eval(missing is defined) = %v, %v", v, err)
+	}
+	if _, err := e.Truthy("missing is string", ctx); err == nil {
+		t.Fatalf("expected undefined error for non-defined test on missing variable")
+	}
+	if _, err := e.Truthy("s is bogus", ctx); err == nil || !strings.Contains(err.Error(), "unknown test: bogus") {
+		t.Fatalf("unknown test error = %v", err)
+	}
+}
+
+// filterCase renders expr with ctx and compares the output.
+type filterCase struct {
+	expr string
+	want string
+}
+
+func runFilterCases(t *testing.T, ctx Context, cases []filterCase) {
+	t.Helper()
+	for _, tc := range cases {
+		got, err := renderHelper(t, "{{ "+tc.expr+" }}", ctx)
+		if err != nil {
+			t.Fatalf("%s: render error: %v", tc.expr, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestFilterReplace(t *testing.T) {
+	runFilterCases(t, Context{"v": StringValue("1.2.3")}, []filterCase{
+		{"v|replace('.', '_')", "1_2_3"},
+		{"v|replace('.', '', 1)", "12.3"},
+		{"v|replace('x', 'y')", "1.2.3"},
+	})
+	if _, err := renderHelper(t, "{{ v|replace('.') }}", Context{"v": StringValue("a")}); err == nil {
+		t.Fatalf("expected error for missing replacement")
+	}
+}
+
+func TestFilterSplit(t *testing.T) {
+	runFilterCases(t, Context{"v": StringValue("a,b,c"), "w": StringValue(" x  y ")}, []filterCase{
+		{"v|split(',')|join('+')", "a+b+c"},
+		{"v|split(',', 1)|join('|')", "a|b,c"},
+		{"w|split|join('+')", "x+y"},
+		{"v|split(',')|length", "3"},
+	})
+}
+
+func TestFilterRegexReplace(t *testing.T) {
+	runFilterCases(t, Context{"v": StringValue("v1.2.3-rc1"), "path": StringValue("Foo/Bar")}, []filterCase{
+		{"v|regex_replace('^v', '')", "1.2.3-rc1"},
+		{"v|regex_replace('-rc\\\\d+$', '')", "v1.2.3"},
+		{"v|regex_replace('^v(\\\\d+)\\\\.(\\\\d+).*', '\\\\2.\\\\1')", "2.1"},
+		{"v|regex_replace('^v(?P<major>\\d+).*', 'major=\\g<major>')", "major=1"},
+		{"path|regex_replace('bar', 'baz', true)", "Foo/baz"},
+		{"v|regex_replace('1', '$1')", "v$1.2.3-rc$1"},
+	})
+	if _, err := renderHelper(t, "{{ v|regex_replace('(', '') }}", Context{"v": StringValue("a")}); err == nil {
+		t.Fatalf("expected error for invalid pattern")
+	}
+}
+
+func TestFilterBasenameDirname(t *testing.T) {
+	runFilterCases(t, Context{
+		"file": StringValue("/opt/fsl/bin/bet"),
+		"dir":  StringValue("/opt/fsl/"),
+		"rel":  StringValue("bet"),
+		"root": StringValue("/bet"),
+	}, []filterCase{
+		{"file|basename", "bet"},
+		{"file|dirname", "/opt/fsl/bin"},
+		{"dir|basename", ""},
+		{"dir|dirname", "/opt/fsl"},
+		{"rel|basename", "bet"},
+		{"rel|dirname", ""},
+		{"root|dirname", "/"},
+	})
+}
+
+func TestFilterInt(t *testing.T) {
+	runFilterCases(t, Context{"s": StringValue("42"), "f": StringValue("3.9"), "bad": StringValue("abc"), "hex": StringValue("0xff")}, []filterCase{
+		{"s|int", "42"},
+		{"f|int", "3"},
+		{"bad|int", "0"},
+		{"bad|int(7)", "7"},
+		{"hex|int(0, 16)", "255"},
+		{"true|int", "1"},
+	})
+}
+
+func TestFilterFloat(t *testing.T) {
+	runFilterCases(t, Context{"s": StringValue("2.5"), "n": IntValue(3), "bad": StringValue("x")}, []filterCase{
+		{"s|float", "2.5"},
+		{"n|float", "3"},
+		{"bad|float", "0"},
+		{"bad|float(1.5)", "1.5"},
+	})
+}
+
+func TestFilterBool(t *testing.T) {
+	runFilterCases(t, Context{"yes": StringValue("Yes"), "no": StringValue("no"), "one": IntValue(1), "two": IntValue(2)}, []filterCase{
+		{"yes|bool", "true"},
+		{"'true'|bool", "true"},
+		{"'1'|bool", "true"},
+		{"no|bool", "false"},
+		{"one|bool", "true"},
+		{"two|bool", "false"},
+		{"false|bool", "false"},
+	})
+}
+
+func TestFilterSelectAndReject(t *testing.T) {
+	ctx := Context{
+		"nums": ListValue{IntValue(1), IntValue(2), IntValue(3), IntValue(4)},
+		"strs": ListValue{StringValue("a"), StringValue(""), StringValue("b")},
+		"pkgs": ListValue{
+			DictValue{"name": StringValue("git"), "optional": BoolValue(false), "arch": StringValue("x86_64")},
+			DictValue{"name": StringValue("cuda"), "optional": BoolValue(true), "arch": StringValue("x86_64")},
+			DictValue{"name": StringValue("vim"), "arch": StringValue("aarch64")},
+		},
+	}
+	runFilterCases(t, ctx, []filterCase{
+		{"nums|select('odd')|join(',')", "1,3"},
+		{"nums|reject('odd')|join(',')", "2,4"},
+		{"nums|select('divisibleby', 2)|join(',')", "2,4"},
+		{"strs|select|join(',')", "a,b"},
+		{"strs|reject|length", "1"},
+		{"pkgs|selectattr('optional')|map('string')|length", "1"},
+		{"pkgs|rejectattr('optional')|length", "2"},
+		{"pkgs|selectattr('arch', 'equalto', 'x86_64')|length", "2"},
+		{"pkgs|selectattr('optional', 'defined')|length", "2"},
+		{"pkgs|rejectattr('arch', 'eq', 'x86_64')|length", "1"},
+	})
+	got, err := renderHelper(t, "{% for p in pkgs|selectattr('arch', 'eq', 'x86_64')|rejectattr('optional') %}{{ p.name }}{% endfor %}", ctx)
+	if err != nil || got != "git" {
+		t.Fatalf("chained selectattr/rejectattr = %q, %v", got, err)
+	}
+	if _, err := renderHelper(t, "{{ nums|select('bogus') }}", ctx); err == nil || !strings.Contains(err.Error(), "unknown test") {
+		t.Fatalf("expected unknown test error, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package jinja2
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -81,6 +82,24 @@ func TestForElse(t *testing.T) {
 	}
 }
 
+func TestForOverDictIsSorted(t *testing.T) {
+	doc, err := Parse("{% for k in d %}{{ k }}={{ d[k] }};{% endfor %}")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	r := NewRenderer(nil)
+	ctx := NewContextFromAny(map[string]any{"d": map[string]any{"c": 3, "a": 1, "b": 2, "e": 5, "d": 4}})
+	for i := 0; i < 10; i++ {
+		out, err := r.Render(doc, ctx)
+		if err != nil {
+			t.Fatalf("render error: %v", err)
+		}
+		if out != "a=1;b=2;c=3;d=4;e=5;" {
+			t.Fatalf("got %q", out)
+		}
+	}
+}
+
 func TestSetAndUse(t *testing.T) {
 	tpl := "{% set greeting = 'hi' %}{{ greeting }}"
 	doc, err := Parse(tpl)
@@ -160,3 +179,214 @@ func TestPretty(t *testing.T) {
 		t.Fatalf("pretty printer missing expected content:\n%s", s)
 	}
 }
+
+func TestMacroDefaultsAndKeywords(t *testing.T) {
+	tpl := "{% macro pkg(name, version='latest', mgr=default_mgr) %}{{ mgr }} install {{ name }}={{ version }}{% endmacro %}" +
+		"{{ pkg('git') }};{{ pkg('curl', '8.0') }};{{ pkg('jq', mgr='yum') }}"
+	doc, err := Parse(tpl)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	r := NewRenderer(nil)
+	out, err := r.Render(doc, NewContextFromAny(map[string]any{"default_mgr": "apt"}))
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	want := "apt install git=latest;apt install curl=8.0;yum install jq=latest"
+	if out != want {
+		t.Fatalf("want %q got %q", want, out)
+	}
+
+	doc, err = Parse("{% macro m(a, b) %}{{ a }}{{ b }}{% endmacro %}{{ m('x') }}")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if _, err := r.Render(doc, Context{}); err == nil || !strings.Contains(err.Error(), "missing argument b") {
+		t.Fatalf("expected missing argument error, got %v", err)
+	}
+}
+
+func TestMacroVarargsAndKwargs(t *testing.T) {
+	tpl := "{% macro run(cmd) %}{{ cmd }}{% for a in varargs %} {{ a }}{% endfor %}{% for k in kwargs %} --{{ k }}={{ kwargs[k] }}{% endfor %}{% endmacro %}" +
+		"{{ run('make', 'all', 'install', jobs=4, keep_going='yes') }}"
+	doc, err := Parse(tpl)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	out, err := NewRenderer(nil).Render(doc, Context{})
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	want := "make all install --jobs=4 --keep_going=yes"
+	if out != want {
+		t.Fatalf("want %q got %q", want, out)
+	}
+}
+
+func TestCallBlock(t *testing.T) {
+	tpl := "{% macro section(title) %}# {{ title }}\n{{ caller() }}# end {{ title }}{% endmacro %}" +
+		"{% call section('deps') %}apt-get install {{ pkg }}\n{% endcall %}"
+	doc, err := Parse(tpl)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	out, err := NewRenderer(nil).Render(doc, NewContextFromAny(map[string]any{"pkg": "git"}))
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	want := "# deps\napt-get install git\n# end deps"
+	if out != want {
+		t.Fatalf("want %q got %q", want, out)
+	}
+}
+
+func TestMacrosSharedThroughInclude(t *testing.T) {
+	ldr := MemoryLoader{"macros": "{% macro greet(who) %}hello {{ who }}{% endmacro %}"}
+	doc, err := Parse("{% include 'macros' %}{{ greet(name) }}")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	out, err := NewRenderer(ldr).Render(doc, NewContextFromAny(map[string]any{"name": "Neo"}))
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if out != "hello Neo" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+func TestMacroParseErrors(t *testing.T) {
+	for _, tpl := range []string{
+		"{% macro m %}x{% endmacro %}",
+		"{% macro m(a='x', b) %}x{% endmacro %}",
+		"{% macro m(a) %}x",
+		"{% call m %}x{% endcall %}",
+	} {
+		if _, err := Parse(tpl); err == nil {
+			t.Fatalf("%s: expected parse error", tpl)
+		}
+	}
+}
+
+func TestWhitespaceControlMarkers(t *testing.T) {
+	cases := []struct {
+		tpl  string
+		want string
+	}{
+		{"a  {{- x -}}  b", "a1b"},
+		{"a\n  {%- if true %}\nB{% endif -%}\n  c", "a\nBc"},
+		{"[ {#- note -#} ]", "[]"},
+		{"x {%- raw -%}  {{y}}  {%- endraw -%} z", "x{{ y }}z"},
+		{"{% for i in items -%}\n  {{ i }}\n{%- endfor %}", "12"},
+	}
+	for _, tc := range cases {
+		got, err := renderHelper(t, tc.tpl, NewContextFromAny(map[string]any{"x": 1, "items": []int{1, 2}}))
+		if err != nil {
+			t.Fatalf("%q: render error: %v", tc.tpl, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%q: got %q, want %q", tc.tpl, got, tc.want)
+		}
+	}
+}
+
+func TestTrimAndLstripBlocks(t *testing.T) {
+	tpl := "RUN \\\n    {% if a %}\n    echo a \\\n    {% endif %}\n    && true\n  {%+ if a %}kept{% endif %}\n"
+	doc, err := Parse(tpl)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	ctx := NewContextFromAny(map[string]any{"a": true})
+	cases := []struct {
+		trim, lstrip bool
+		want         string
+	}{
+		{false, false, "RUN \\\n    \n    echo a \\\n    \n    && true\n  kept\n"},
+		{true, false, "RUN \\\n        echo a \\\n        && true\n  kept"},
+		{false, true, "RUN \\\n\n    echo a \\\n\n    && true\n  kept\n"},
+		{true, true, "RUN \\\n    echo a \\\n    && true\n  kept"},
+	}
+	for _, tc := range cases {
+		r := NewRenderer(nil)
+		r.TrimBlocks, r.LstripBlocks = tc.trim, tc.lstrip
+		got, err := r.Render(doc, ctx)
+		if err != nil {
+			t.Fatalf("render error: %v", err)
+		}
+		if got != tc.want {
+			t.Fatalf("trim=%v lstrip=%v: got %q, want %q", tc.trim, tc.lstrip, got, tc.want)
+		}
+	}
+
+	r := NewRenderer(nil)
+	r.TrimBlocks, r.LstripBlocks = true, true
+	doc, err = Parse("  {% if a +%}\nx{% endif %}")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	got, err := r.Render(doc, ctx)
+	if err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	if got != "\nx" {
+		t.Fatalf("leading block with +%%}: got %q", got)
+	}
+}
+
+func TestRenderErrorPositions(t *testing.T) {
+	tpl := "FROM base\nRUN echo {{ ok }}\n    && {{ self.missing }}\n"
+	doc, err := Parse(tpl)
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	_, err = NewRenderer(nil).Render(doc, Context{"ok": IntValue(1), "self": DictValue{}})
+	var te *TemplateError
+	if !errors.As(err, &te) {
+		t.Fatalf("expected TemplateError, got %v", err)
+	}
+	if te.Pos.Line != 3 || te.Pos.Column != 8 || te.Tag != "{{ self.missing }}" {
+		t.Fatalf("error located at %+v %q", te.Pos, te.Tag)
+	}
+	if want := "line 3, column 8: {{ self.missing }}: undefined attribute: missing"; !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("error = %q, want prefix %q", err, want)
+	}
+
+	_, err = TemplateString("{% if a %}\n{% elif b > 1 %}{% endif %}").RenderNamed("fsl", Context{"a": BoolValue(false)})
+	if err == nil || !strings.HasPrefix(err.Error(), "fsl:2:1: {% elif b > 1 %}: undefined variable: b") {
+		t.Fatalf("elif error = %v", err)
+	}
+}
+
+func TestIncludedTemplateErrorNamesTemplate(t *testing.T) {
+	ldr := MemoryLoader{"deps": "apt-get install\n  {% for p in pkgs %}{{ p }}{% endfor %}"}
+	doc, err := ParseNamed("main", "A\n{% include 'deps' %}")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	_, err = NewRenderer(ldr).Render(doc, Context{})
+	if err == nil || !strings.HasPrefix(err.Error(), "deps:2:3: {% for p in pkgs %}: undefined variable: pkgs") {
+		t.Fatalf("error = %v", err)
+	}
+
+	_, err = NewRenderer(MemoryLoader{}).Render(doc, Context{})
+	if err == nil || !strings.HasPrefix(err.Error(), "main:2:1: {% include 'deps' %}: template not found") {
+		t.Fatalf("missing include error = %v", err)
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	for _, tc := range []struct {
+		tpl  string
+		want string
+	}{
+		{"a\n  {% if x %}b", "t:2:3: {% if x %}: expected endif"},
+		{"a {{ x", "t:1:3: {{: unterminated variable tag"},
+		{"x\n{% bogus %}", "t:2:1: {% bogus %}: unsupported statement"},
+		{"{% if a %}\n{% for x in y %}{% endif %}", "t:2:17: {% endif %}: unsupported statement"},
+	} {
+		_, err := ParseNamed("t", tc.tpl)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Fatalf("%q: error = %v, want prefix %q", tc.tpl, err, tc.want)
+		}
+	}
+}
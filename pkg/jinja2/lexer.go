@@ -21,6 +21,12 @@ type token struct {
 	kind tokenKind
 	val  string
 	pos  int // byte offset in source
+	// trim is set for delimiters written with a '-' ({%- or -%}), which
+	// strip the whitespace on that side of the tag.
+	trim bool
+	// keep is set for block delimiters written with a '+' ({%+ or +%}),
+	// which opt the tag out of lstrip_blocks or trim_blocks.
+	keep bool
 }
 
 type lexer struct {
@@ -110,27 +116,27 @@ func (l *lexer) nextTokenOutside() token {
 				}
 				// Consume and emit var start; handle optional trim '-'.
 				l.i += 2
-				if l.i < l.n && l.src[l.i] == '-' {
-					l.i++
-				}
-				return token{kind: tokVarStart, pos: start}
+				tok := token{kind: tokVarStart, pos: start}
+				tok.trim, _ = l.startModifier()
+				return tok
 			case "{%":
 				if l.i > start {
 					s := string(l.src[start:l.i])
 					return token{kind: tokText, val: s, pos: start}
 				}
 				l.i += 2
-				if l.i < l.n && l.src[l.i] == '-' {
-					l.i++
-				}
-				return token{kind: tokStmtStart, pos: start}
+				tok := token{kind: tokStmtStart, pos: start}
+				tok.trim, tok.keep = l.startModifier()
+				return tok
 			case "{#":
 				if l.i > start {
 					s := string(l.src[start:l.i])
 					return token{kind: tokText, val: s, pos: start}
 				}
 				l.i += 2
-				return token{kind: tokCommStart, pos: start}
+				tok := token{kind: tokCommStart, pos: start}
+				tok.trim, tok.keep = l.startModifier()
+				return tok
 			}
 		}
 		l.i++
@@ -143,57 +149,53 @@ func (l *lexer) nextTokenOutside() token {
 	return token{kind: tokEOF, pos: l.i}
 }
 
+// startModifier consumes an optional '-' or '+' right after an opening
+// delimiter.
+func (l *lexer) startModifier() (trim, keep bool) {
+	if l.i < l.n {
+		switch l.src[l.i] {
+		case '-':
+			l.i++
+			return true, false
+		case '+':
+			l.i++
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// closingDelims maps each closing token kind to its delimiter.
+var closingDelims = map[tokenKind]string{
+	tokVarEnd:  "}}",
+	tokStmtEnd: "%}",
+	tokCommEnd: "#}",
+}
+
 // nextTokenInside scans inside a tag of the given closing kind, returning
 // either tokContent chunks or the appropriate closing token.
 func (l *lexer) nextTokenInside(close tokenKind) token {
 	if l.i >= l.n {
 		return token{kind: tokEOF, pos: l.i}
 	}
+	delim := closingDelims[close]
 	start := l.i
 	for l.i < l.n {
-		if close == tokVarEnd && l.i+2 <= l.n {
-			if l.i+3 <= l.n && string(l.src[l.i:l.i+3]) == "-}}" {
-				if l.i > start {
-					s := string(l.src[start:l.i])
-					return token{kind: tokContent, val: s, pos: start}
-				}
-				l.i += 3
-				return token{kind: tokVarEnd, pos: start}
-			}
-			if string(l.src[l.i:l.i+2]) == "}}" {
-				if l.i > start {
-					s := string(l.src[start:l.i])
-					return token{kind: tokContent, val: s, pos: start}
-				}
-				l.i += 2
-				return token{kind: tokVarEnd, pos: start}
-			}
-		}
-		if close == tokStmtEnd && l.i+2 <= l.n {
-			if l.i+3 <= l.n && string(l.src[l.i:l.i+3]) == "-%}" {
-				if l.i > start {
-					s := string(l.src[start:l.i])
-					return token{kind: tokContent, val: s, pos: start}
-				}
-				l.i += 3
-				return token{kind: tokStmtEnd, pos: start}
-			}
-			if string(l.src[l.i:l.i+2]) == "%}" {
-				if l.i > start {
-					s := string(l.src[start:l.i])
-					return token{kind: tokContent, val: s, pos: start}
-				}
-				l.i += 2
-				return token{kind: tokStmtEnd, pos: start}
-			}
+		mod := byte(0)
+		if l.i+3 <= l.n && (l.src[l.i] == '-' || (l.src[l.i] == '+' && close != tokVarEnd)) && string(l.src[l.i+1:l.i+3]) == delim {
+			mod = l.src[l.i]
 		}
-		if close == tokCommEnd && l.i+2 <= l.n && string(l.src[l.i:l.i+2]) == "#}" {
+		if mod != 0 || (l.i+2 <= l.n && string(l.src[l.i:l.i+2]) == delim) {
 			if l.i > start {
 				s := string(l.src[start:l.i])
 				return token{kind: tokContent, val: s, pos: start}
 			}
-			l.i += 2
-			return token{kind: tokCommEnd, pos: start}
+			tok := token{kind: close, pos: start, trim: mod == '-', keep: mod == '+'}
+			l.i += len(delim)
+			if mod != 0 {
+				l.i++
+			}
+			return tok
 		}
 		l.i++
 	}
@@ -0,0 +1,105 @@
+package jinja2
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// macroValue returns the callable stored under a macro's name. Each call
+// renders the body in a copy of ctx (so it sees the template's variables as
+// they are at call time) with the parameters bound. Extra positional
+// arguments are available as varargs and unknown keyword arguments as
+// kwargs; a `caller` keyword argument is bound by {% call %}.
+func (r *Renderer) macroValue(m *MacroNode, ctx Context) CallableValue {
+	return CallableValue{Fn: func(args []Value) (Value, error) {
+		local := make(Context, len(ctx)+len(m.Params)+2)
+		for k, v := range ctx {
+			local[k] = v
+		}
+		kwargs := DictValue{}
+		var positional ListValue
+		for _, a := range args {
+			if kw, ok := a.(keywordArg); ok {
+				kwargs[kw.Name] = kw.Value
+				continue
+			}
+			positional = append(positional, a)
+		}
+		for i, p := range m.Params {
+			if v, ok := kwargs[p.Name]; ok {
+				if i < len(positional) {
+					return nil, fmt.Errorf("macro %s got multiple values for argument %s", m.Name, p.Name)
+				}
+				local[p.Name] = v
+				delete(kwargs, p.Name)
+				continue
+			}
+			if i < len(positional) {
+				local[p.Name] = positional[i]
+				continue
+			}
+			if p.Default == "" {
+				return nil, fmt.Errorf("macro %s: missing argument %s", m.Name, p.Name)
+			}
+			v, err := r.Evaluator.Eval(p.Default, local)
+			if err != nil {
+				return nil, fmt.Errorf("macro %s: default for %s: %w", m.Name, p.Name, err)
+			}
+			local[p.Name] = v
+		}
+		varargs := ListValue{}
+		if len(positional) > len(m.Params) {
+			varargs = append(varargs, positional[len(m.Params):]...)
+		}
+		local["varargs"] = varargs
+		if c, ok := kwargs["caller"]; ok {
+			local["caller"] = c
+			delete(kwargs, "caller")
+		}
+		local["kwargs"] = kwargs
+
+		var buf bytes.Buffer
+		if err := r.renderNodes(&buf, m.Body, local, nil); err != nil {
+			return nil, fmt.Errorf("macro %s: %w", m.Name, err)
+		}
+		return StringValue(buf.String()), nil
+	}}
+}
+
+// renderCall evaluates a {% call %} block: the macro expression is invoked
+// with an extra caller argument that renders the block body in ctx.
+func (r *Renderer) renderCall(buf *bytes.Buffer, c *CallNode, ctx Context, overrides map[string]*BlockNode) error {
+	open := strings.IndexByte(c.Expr, '(')
+	fnVal, err := r.Evaluator.Eval(c.Expr[:open], ctx)
+	if err != nil {
+		return err
+	}
+	fn, ok := fnVal.(CallableValue)
+	if !ok {
+		return fmt.Errorf("call: %s is not callable", strings.TrimSpace(c.Expr[:open]))
+	}
+	var args []Value
+	if inner := strings.TrimSpace(c.Expr[open+1 : len(c.Expr)-1]); inner != "" {
+		argStrs, err := splitArgs(inner)
+		if err != nil {
+			return err
+		}
+		if args, err = r.Evaluator.callArgs(argStrs, ctx); err != nil {
+			return err
+		}
+	}
+	caller := CallableValue{Fn: func([]Value) (Value, error) {
+		var body bytes.Buffer
+		if err := r.renderNodes(&body, c.Body, ctx, overrides); err != nil {
+			return nil, err
+		}
+		return StringValue(body.String()), nil
+	}}
+	out, err := fn.Fn(append(args, keywordArg{Name: "caller", Value: caller}))
+	if err != nil {
+		return err
+	}
+	buf.WriteString(out.String())
+	return nil
+}
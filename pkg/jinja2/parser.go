@@ -7,19 +7,82 @@ import (
 
 // Parse parses a Jinja2 template string into a Document AST.
 // It recognizes text, output expressions, comments, and a subset of block
-// statements: if/elif/else/endif, for/else/endfor, set, and raw/endraw.
+// statements: if/elif/else/endif, for/else/endfor, set, raw/endraw,
+// macro/endmacro and call/endcall.
 // Expressions inside tags are preserved as raw strings.
 func Parse(src string) (*Document, error) {
-	p := &parser{l: newLexer([]byte(src))}
-	nodes, _, _, err := p.parseNodes(map[string]bool{})
+	return ParseNamed("", src)
+}
+
+// ParseNamed is Parse for a template whose name is reported in error
+// positions.
+func ParseNamed(name, src string) (*Document, error) {
+	p := &parser{l: newLexer([]byte(src)), name: name}
+	nodes, endTag, _, err := p.parseNodes(map[string]bool{})
 	if err != nil {
 		return nil, err
 	}
-	return &Document{Nodes: nodes}, nil
+	if endTag != "" {
+		return nil, locate(fmt.Errorf("unexpected %s", endTag), p.endPos, stmtTag(endTag))
+	}
+	return &Document{Name: name, Nodes: nodes}, nil
 }
 
 type parser struct {
-	l *lexer
+	l    *lexer
+	name string
+	// endPos is the position of the tag that ended the last parseNodes.
+	endPos Pos
+	// at is the position of byte offset atOff, the last one converted.
+	at    Pos
+	atOff int
+	// lastText is the text node emitted just before the current tag.
+	lastText *TextNode
+	// trimNext and afterBlock describe the tag that ended last: whether it
+	// closed with '-' and whether it was a block or comment tag.
+	trimNext   bool
+	afterBlock bool
+}
+
+// pos converts a byte offset in the source into a Pos. Offsets only grow
+// while parsing, so it resumes counting from the previous call.
+func (p *parser) pos(off int) Pos {
+	if p.at.Line == 0 || off < p.atOff {
+		p.at, p.atOff = Pos{Name: p.name, Line: 1, Column: 1}, 0
+	}
+	for _, c := range p.l.src[p.atOff:off] {
+		if c == '\n' {
+			p.at.Line++
+			p.at.Column = 1
+		} else {
+			p.at.Column++
+		}
+	}
+	p.atOff = off
+	return p.at
+}
+
+// whitespace is what '-' markers strip.
+const whitespace = " \t\r\n"
+
+// openTag applies the opening delimiter's whitespace control to the text
+// emitted before it.
+func (p *parser) openTag(tok token) {
+	if p.lastText != nil {
+		if tok.trim {
+			p.lastText.Text = strings.TrimRight(p.lastText.Text, whitespace)
+		} else if tok.kind != tokVarStart && !tok.keep {
+			p.lastText.BeforeBlock = true
+		}
+	}
+	p.lastText = nil
+}
+
+// closeTag records the closing delimiter's whitespace control for the text
+// that follows it.
+func (p *parser) closeTag(tok token) {
+	p.trimNext = tok.trim
+	p.afterBlock = tok.kind != tokVarEnd && !tok.keep
 }
 
 // parseNodes parses until an ending statement with a name in `until` is
@@ -31,82 +94,109 @@ func (p *parser) parseNodes(until map[string]bool) (nodes []Node, endTag, endArg
 		case tokEOF:
 			return nodes, "", "", nil
 		case tokText:
-			if tok.val != "" {
-				nodes = append(nodes, &TextNode{Text: tok.val})
+			text := tok.val
+			if p.trimNext {
+				text = strings.TrimLeft(text, whitespace)
+			}
+			if text != "" {
+				p.lastText = &TextNode{Text: text, AfterBlock: p.afterBlock, AtStart: tok.pos == 0}
+				nodes = append(nodes, p.lastText)
 			}
+			p.trimNext, p.afterBlock = false, false
 		case tokVarStart:
+			p.openTag(tok)
+			pos := p.pos(tok.pos)
 			expr, err := p.readUntilVarEnd()
 			if err != nil {
-				return nil, "", "", err
+				return nil, "", "", locate(err, pos, "{{")
 			}
-			nodes = append(nodes, &OutputNode{Expr: strings.TrimSpace(expr)})
+			nodes = append(nodes, &OutputNode{Pos: pos, Expr: strings.TrimSpace(expr)})
 		case tokCommStart:
+			p.openTag(tok)
 			if err := p.skipUntilCommentEnd(); err != nil {
-				return nil, "", "", err
+				return nil, "", "", locate(err, p.pos(tok.pos), "{#")
 			}
 		case tokStmtStart:
+			p.openTag(tok)
+			pos := p.pos(tok.pos)
 			stmt, err := p.readUntilStmtEnd()
 			if err != nil {
-				return nil, "", "", err
+				return nil, "", "", locate(err, pos, "{%")
 			}
 			name, args := splitNameArgs(stmt)
-			if len(until) > 0 && until[name] {
+			if (len(until) > 0 && until[name]) || name == "endblock" {
+				p.endPos = pos
 				return nodes, name, args, nil
 			}
-			switch name {
-			case "raw":
-				rawText, err := p.readRawUntilEndraw()
-				if err != nil {
-					return nil, "", "", err
-				}
-				nodes = append(nodes, &RawNode{Text: rawText})
-			case "block":
-				bn, err := p.parseBlock(args)
-				if err != nil {
-					return nil, "", "", err
-				}
-				nodes = append(nodes, bn)
-			case "endblock":
-				return nodes, "endblock", args, nil
-			case "extends":
-				en, err := parseExtends(args)
-				if err != nil {
-					return nil, "", "", err
-				}
-				nodes = append(nodes, en)
-			case "include":
-				in, err := parseInclude(args)
-				if err != nil {
-					return nil, "", "", err
-				}
-				nodes = append(nodes, in)
-			case "set":
-				n, err := parseSet(args)
-				if err != nil {
-					return nil, "", "", err
-				}
-				nodes = append(nodes, n)
-			case "if":
-				n, err := p.parseIf(args)
-				if err != nil {
-					return nil, "", "", err
-				}
-				nodes = append(nodes, n)
-			case "for":
-				n, err := p.parseFor(args)
-				if err != nil {
-					return nil, "", "", err
-				}
-				nodes = append(nodes, n)
-			default:
-				return nil, "", "", fmt.Errorf("unsupported statement: %q", name)
+			n, err := p.parseStatement(name, args)
+			if err != nil {
+				return nil, "", "", locate(err, pos, stmtTag(stmt))
 			}
+			setPos(n, pos)
+			nodes = append(nodes, n)
 		default:
-			return nil, "", "", fmt.Errorf("unexpected token kind outside: %v", tok.kind)
+			return nil, "", "", locate(fmt.Errorf("unexpected token kind outside: %v", tok.kind), p.pos(tok.pos), tok.val)
 		}
 	}
 }
 
+// parseStatement parses the statement named name, including the body of
+// block statements.
+func (p *parser) parseStatement(name, args string) (Node, error) {
+	switch name {
+	case "raw":
+		trimStart := p.trimNext
+		rawText, err := p.readRawUntilEndraw()
+		if err != nil {
+			return nil, err
+		}
+		if trimStart {
+			rawText = strings.TrimLeft(rawText, whitespace)
+		}
+		return &RawNode{Text: rawText}, nil
+	case "block":
+		return p.parseBlock(args)
+	case "extends":
+		return parseExtends(args)
+	case "include":
+		return parseInclude(args)
+	case "set":
+		return parseSet(args)
+	case "if":
+		return p.parseIf(args)
+	case "for":
+		return p.parseFor(args)
+	case "macro":
+		return p.parseMacro(args)
+	case "call":
+		return p.parseCall(args)
+	default:
+		return nil, fmt.Errorf("unsupported statement: %q", name)
+	}
+}
+
+// setPos records where a statement node's tag starts.
+func setPos(n Node, pos Pos) {
+	switch t := n.(type) {
+	case *SetNode:
+		t.Pos = pos
+	case *IfNode:
+		t.Pos = pos
+	case *ForNode:
+		t.Pos = pos
+	case *BlockNode:
+		t.Pos = pos
+	case *ExtendsNode:
+		t.Pos = pos
+	case *IncludeNode:
+		t.Pos = pos
+	case *MacroNode:
+		t.Pos = pos
+	case *CallNode:
+		t.Pos = pos
+	}
+}
+
 func (p *parser) readUntilVarEnd() (string, error) {
 	var b strings.Builder
 	for {
@@ -115,6 +205,7 @@ func (p *parser) readUntilVarEnd() (string, error) {
 		case tokContent:
 			b.WriteString(t.val)
 		case tokVarEnd:
+			p.closeTag(t)
 			return b.String(), nil
 		case tokEOF:
 			return "", fmt.Errorf("unterminated variable tag {{ ... }}")
@@ -132,6 +223,7 @@ func (p *parser) readUntilStmtEnd() (string, error) {
 		case tokContent:
 			b.WriteString(t.val)
 		case tokStmtEnd:
+			p.closeTag(t)
 			return strings.TrimSpace(b.String()), nil
 		case tokEOF:
 			return "", fmt.Errorf("unterminated statement tag {%% ... %%}")
@@ -145,6 +237,7 @@ func (p *parser) skipUntilCommentEnd() error {
 	for {
 		t := p.l.nextTokenInside(tokCommEnd)
 		if t.kind == tokCommEnd {
+			p.closeTag(t)
 			return nil
 		}
 		if t.kind == tokEOF {
@@ -231,6 +324,9 @@ func (p *parser) readRawUntilEndraw() (string, error) {
 				if strings.TrimSpace(args) != "" {
 					return "", fmt.Errorf("endraw takes no arguments")
 				}
+				if t.trim {
+					return strings.TrimRight(out.String(), whitespace), nil
+				}
 				return out.String(), nil
 			}
 			// Not endraw: keep literally as {% ... %}
@@ -265,7 +361,7 @@ func (p *parser) parseIf(cond string) (*IfNode, error) {
 	}
 	n.Then = body
 	for endTag == "elif" {
-		branch := ElifBranch{Cond: strings.TrimSpace(endArgs)}
+		branch := ElifBranch{Pos: p.endPos, Cond: strings.TrimSpace(endArgs)}
 		body, endTag, endArgs, err = p.parseNodes(map[string]bool{"elif": true, "else": true, "endif": true})
 		if err != nil {
 			return nil, err
@@ -324,6 +420,76 @@ func (p *parser) parseFor(args string) (*ForNode, error) {
 	return n, nil
 }
 
+func (p *parser) parseMacro(args string) (*MacroNode, error) {
+	// Expect: name(param, param=default)
+	args = strings.TrimSpace(args)
+	open := strings.IndexByte(args, '(')
+	if open <= 0 || !strings.HasSuffix(args, ")") {
+		return nil, fmt.Errorf("invalid macro statement, expected 'name(params)': %q", args)
+	}
+	n := &MacroNode{Name: strings.TrimSpace(args[:open])}
+	if !isIdentifier(n.Name) {
+		return nil, fmt.Errorf("invalid macro name %q", n.Name)
+	}
+	if inner := strings.TrimSpace(args[open+1 : len(args)-1]); inner != "" {
+		params, err := splitArgs(inner)
+		if err != nil {
+			return nil, err
+		}
+		seenDefault := false
+		for _, param := range params {
+			name, def, hasDefault := strings.Cut(param, "=")
+			name = strings.TrimSpace(name)
+			if !isIdentifier(name) {
+				return nil, fmt.Errorf("invalid parameter %q in macro %s", param, n.Name)
+			}
+			if hasDefault {
+				seenDefault = true
+			} else if seenDefault {
+				return nil, fmt.Errorf("parameter %s of macro %s follows a parameter with a default", name, n.Name)
+			}
+			n.Params = append(n.Params, MacroParam{Name: name, Default: strings.TrimSpace(def)})
+		}
+	}
+	body, endTag, _, err := p.parseNodes(map[string]bool{"endmacro": true})
+	if err != nil {
+		return nil, err
+	}
+	if endTag != "endmacro" {
+		return nil, fmt.Errorf("expected endmacro for macro %q, got %q", n.Name, endTag)
+	}
+	n.Body = body
+	return n, nil
+}
+
+func (p *parser) parseCall(args string) (*CallNode, error) {
+	args = strings.TrimSpace(args)
+	if i := strings.IndexByte(args, '('); i <= 0 || !strings.HasSuffix(args, ")") {
+		return nil, fmt.Errorf("invalid call statement, expected 'macro(args)': %q", args)
+	}
+	body, endTag, _, err := p.parseNodes(map[string]bool{"endcall": true})
+	if err != nil {
+		return nil, err
+	}
+	if endTag != "endcall" {
+		return nil, fmt.Errorf("expected endcall, got %q", endTag)
+	}
+	return &CallNode{Expr: args, Body: body}, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
 func (p *parser) parseBlock(args string) (*BlockNode, error) {
 	name := strings.TrimSpace(args)
 	if name == "" {
@@ -13,6 +13,11 @@ type Loader interface {
 type Renderer struct {
 	Loader    Loader
 	Evaluator *Evaluator
+	// TrimBlocks removes the first newline after a block or comment tag.
+	TrimBlocks bool
+	// LstripBlocks strips spaces and tabs from the start of a line up to a
+	// block or comment tag.
+	LstripBlocks bool
 }
 
 func NewRenderer(loader Loader) *Renderer {
@@ -26,13 +31,13 @@ func (r *Renderer) Render(doc *Document, ctx Context) (string, error) {
 	for _, n := range doc.Nodes {
 		if en, ok := n.(*ExtendsNode); ok {
 			if r.Loader == nil {
-				return "", fmt.Errorf("extends requires a loader")
+				return "", locate(fmt.Errorf("extends requires a loader"), en.Pos, stmtTag("extends '"+en.Template+"'"))
 			}
 			src, err := r.Loader.Load(en.Template)
 			if err != nil {
-				return "", err
+				return "", locate(err, en.Pos, stmtTag("extends '"+en.Template+"'"))
 			}
-			parent, err = Parse(src)
+			parent, err = ParseNamed(en.Template, src)
 			if err != nil {
 				return "", err
 			}
@@ -59,6 +64,26 @@ func (r *Renderer) renderWithParent(parent *Document, overrides map[string]*Bloc
 	return buf.String(), nil
 }
 
+// text returns a text node's output after trim_blocks and lstrip_blocks.
+func (r *Renderer) text(t *TextNode) string {
+	s := t.Text
+	lineStart := t.AtStart
+	if r.TrimBlocks && t.AfterBlock {
+		if rest, ok := strings.CutPrefix(s, "\n"); ok {
+			s, lineStart = rest, true
+		} else if rest, ok := strings.CutPrefix(s, "\r\n"); ok {
+			s, lineStart = rest, true
+		}
+	}
+	if r.LstripBlocks && t.BeforeBlock {
+		i := strings.LastIndexByte(s, '\n') + 1
+		if (i > 0 || lineStart) && strings.Trim(s[i:], " \t") == "" {
+			s = s[:i]
+		}
+	}
+	return s
+}
+
 // setVar stores a value in the current context, invoking any set hook.
 func (r *Renderer) setVar(ctx Context, name string, val Value) error {
 	// Notify via Value-level hook on the top-level context, if present
@@ -72,20 +97,20 @@ func (r *Renderer) renderNodes(buf *bytes.Buffer, nodes []Node, ctx Context, ove
 	for _, n := range nodes {
 		switch t := n.(type) {
 		case *TextNode:
-			buf.WriteString(t.Text)
+			buf.WriteString(r.text(t))
 		case *RawNode:
 			buf.WriteString(t.Text)
 		case *OutputNode:
 			v, err := r.Evaluator.Eval(t.Expr, ctx)
 			if err != nil {
-				return err
+				return locate(err, t.Pos, outputTag(t.Expr))
 			}
 			// NoneValue.String() is empty, others produce their textual form.
 			fmt.Fprintf(buf, "%s", v.String())
 		case *SetNode:
 			v, err := r.Evaluator.Eval(t.Expr, ctx)
 			if err != nil {
-				return err
+				return locate(err, t.Pos, stmtTag("set "+t.Name+" = "+t.Expr))
 			}
 			// route through renderer-level setter to allow interception
 			if err := r.setVar(ctx, t.Name, v); err != nil {
@@ -94,7 +119,7 @@ func (r *Renderer) renderNodes(buf *bytes.Buffer, nodes []Node, ctx Context, ove
 		case *IfNode:
 			b, err := r.Evaluator.Truthy(t.Cond, ctx)
 			if err != nil {
-				return err
+				return locate(err, t.Pos, stmtTag("if "+t.Cond))
 			}
 			if b {
 				if err := r.renderNodes(buf, t.Then, ctx, overrides); err != nil {
@@ -106,7 +131,7 @@ func (r *Renderer) renderNodes(buf *bytes.Buffer, nodes []Node, ctx Context, ove
 			for _, e := range t.Elifs {
 				b, err := r.Evaluator.Truthy(e.Cond, ctx)
 				if err != nil {
-					return err
+					return locate(err, e.Pos, stmtTag("elif "+e.Cond))
 				}
 				if b {
 					if err := r.renderNodes(buf, e.Body, ctx, overrides); err != nil {
@@ -126,12 +151,12 @@ func (r *Renderer) renderNodes(buf *bytes.Buffer, nodes []Node, ctx Context, ove
 			}
 		case *ForNode:
 			items, err := r.Evaluator.Eval(t.Iterable, ctx)
-			if err != nil {
-				return err
+			var arr []Value
+			if err == nil {
+				arr, err = iterateValue(items)
 			}
-			arr, err := iterateValue(items)
 			if err != nil {
-				return err
+				return locate(err, t.Pos, stmtTag("for "+t.Target+" in "+t.Iterable))
 			}
 			if len(arr) == 0 && len(t.Else) > 0 {
 				if err := r.renderNodes(buf, t.Else, ctx, overrides); err != nil {
@@ -184,17 +209,25 @@ func (r *Renderer) renderNodes(buf *bytes.Buffer, nodes []Node, ctx Context, ove
 			if err := r.renderNodes(buf, t.Body, ctx, overrides); err != nil {
 				return err
 			}
+		case *MacroNode:
+			if err := r.setVar(ctx, t.Name, r.macroValue(t, ctx)); err != nil {
+				return err
+			}
+		case *CallNode:
+			if err := r.renderCall(buf, t, ctx, overrides); err != nil {
+				return locate(err, t.Pos, stmtTag("call "+t.Expr))
+			}
 		case *ExtendsNode:
 			// Handled at top-level.
 		case *IncludeNode:
 			if r.Loader == nil {
-				return fmt.Errorf("include requires a loader")
+				return locate(fmt.Errorf("include requires a loader"), t.Pos, stmtTag("include '"+t.Template+"'"))
 			}
 			src, err := r.Loader.Load(t.Template)
 			if err != nil {
-				return err
+				return locate(err, t.Pos, stmtTag("include '"+t.Template+"'"))
 			}
-			doc, err := Parse(src)
+			doc, err := ParseNamed(t.Template, src)
 			if err != nil {
 				return err
 			}
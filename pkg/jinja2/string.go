@@ -16,7 +16,12 @@ func (t TemplateString) Validate() error {
 }
 
 func (t TemplateString) Render(ctx Context) (string, error) {
-	doc, err := Parse(string(t))
+	return t.RenderNamed("", ctx)
+}
+
+// RenderNamed renders the template, reporting name in error positions.
+func (t TemplateString) RenderNamed(name string, ctx Context) (string, error) {
+	doc, err := ParseNamed(name, string(t))
 	if err != nil {
 		return "", fmt.Errorf("parsing jinja template: %w", err)
 	}
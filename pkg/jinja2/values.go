@@ -3,6 +3,7 @@ package jinja2
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"unicode/utf8"
 )
 
@@ -91,6 +92,16 @@ type DictValue map[string]Value
 func (d DictValue) String() string { return "{...}" }
 func (d DictValue) Truth() bool    { return len(d) > 0 }
 
+// Keys returns the dictionary keys in sorted order.
+func (d DictValue) Keys() []string {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // NewContext creates an empty context.
 type Context map[string]Value
 
@@ -207,8 +218,9 @@ func iterateValue(v Value) ([]Value, error) {
 		copy(out, t)
 		return out, nil
 	case DictValue:
+		// Iterate keys in sorted order so rendered output is deterministic.
 		out := make([]Value, 0, len(t))
-		for k := range t {
+		for _, k := range t.Keys() {
 			out = append(out, StringValue(k))
 		}
 		return out, nil
@@ -228,6 +240,7 @@ func iterateValue(v Value) ([]Value, error) {
 			for it.Next() {
 				out = append(out, FromGo(it.Key().Interface()))
 			}
+			sort.Slice(out, func(i, j int) bool { return out[i].String() < out[j].String() })
 			return out, nil
 		}
 	}
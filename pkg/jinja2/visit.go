@@ -3,6 +3,7 @@ package jinja2
 import (
 	"bytes"
 	"fmt"
+	"strings"
 )
 
 type Visitor interface {
@@ -55,6 +56,18 @@ func Walk(v Visitor, n Node) error {
 				return err
 			}
 		}
+	case *MacroNode:
+		for _, c := range t.Body {
+			if err := Walk(v, c); err != nil {
+				return err
+			}
+		}
+	case *CallNode:
+		for _, c := range t.Body {
+			if err := Walk(v, c); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -130,6 +143,26 @@ func ppNode(buf *bytes.Buffer, indent int, n Node) {
 		for _, c := range t.Body {
 			ppNode(buf, indent+2, c)
 		}
+	case *MacroNode:
+		ind()
+		params := make([]string, 0, len(t.Params))
+		for _, p := range t.Params {
+			if p.Default != "" {
+				params = append(params, p.Name+"="+p.Default)
+			} else {
+				params = append(params, p.Name)
+			}
+		}
+		fmt.Fprintf(buf, "Macro(%s(%s))\n", t.Name, strings.Join(params, ", "))
+		for _, c := range t.Body {
+			ppNode(buf, indent+2, c)
+		}
+	case *CallNode:
+		ind()
+		fmt.Fprintf(buf, "Call(%q)\n", t.Expr)
+		for _, c := range t.Body {
+			ppNode(buf, indent+2, c)
+		}
 	case *ExtendsNode:
 		ind()
 		fmt.Fprintf(buf, "Extends(%q)\n", t.Template)
@@ -0,0 +1,259 @@
+// Package licenses reports the licenses of the packages installed in an
+// image, from the metadata of the package managers that installed them, for
+// `builder licenses`.
+package licenses
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Script prints a line "manager\tname\tversion\tlicense" for each package
+// installed in the image it runs in: Debian packages, with the License
+// fields of their machine-readable copyright files joined by |, RPMs, and
+// the Python and conda packages of the system python3 and of every conda
+// environment under /opt, /usr/local and /root. It needs only a POSIX
+// shell; managers that are missing are skipped.
+const Script = `
+tab=$(printf '\t')
+if command -v dpkg-query >/dev/null 2>&1; then
+  dpkg-query -W -f '${Package}\t${Version}\n' 2>/dev/null | while IFS="$tab" read -r name version; do
+    lic=$(sed -n 's/^License: *//p' "/usr/share/doc/$name/copyright" 2>/dev/null | sort -u | tr '\n' '|')
+    printf 'dpkg\t%s\t%s\t%s\n' "$name" "$version" "${lic%|}"
+  done
+fi
+if command -v rpm >/dev/null 2>&1; then
+  rpm -qa --qf 'rpm\t%{NAME}\t%{VERSION}-%{RELEASE}\t%{LICENSE}\n' 2>/dev/null
+fi
+pythons=$(command -v python3 2>/dev/null)
+for meta in $(find /opt /usr/local /root -maxdepth 4 -type d -name conda-meta 2>/dev/null); do
+  [ -x "${meta%/conda-meta}/bin/python" ] && pythons="$pythons ${meta%/conda-meta}/bin/python"
+done
+for py in $pythons; do
+  "$py" - "$py" <<'EOF' 2>/dev/null
+import glob, json, os, sys
+def out(kind, name, version, lic):
+    print("\t".join([kind, name or "", version or "", " ".join((lic or "").split())]))
+prefix = os.path.dirname(os.path.dirname(os.path.realpath(sys.argv[1])))
+for path in sorted(glob.glob(os.path.join(prefix, "conda-meta", "*.json"))):
+    try:
+        with open(path) as f:
+            m = json.load(f)
+        out("conda", m.get("name"), m.get("version"), m.get("license"))
+    except Exception:
+        pass
+try:
+    from importlib import metadata
+except ImportError:
+    sys.exit()
+for d in metadata.distributions():
+    m = d.metadata
+    lic = m.get("License-Expression") or ""
+    if not lic:
+        classifiers = [c.split("::")[-1].strip() for c in m.get_all("Classifier") or [] if c.startswith("License ::")]
+        lic = " AND ".join(classifiers) or m.get("License") or ""
+    if len(lic) > 100:
+        lic = ""
+    out("pip", m.get("Name"), m.get("Version"), lic)
+EOF
+done
+true
+`
+
+// Package is an installed package.
+type Package struct {
+	Manager string `json:"manager"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// License is the license as the package metadata states it, or "" if
+	// it states none.
+	License string `json:"license,omitempty"`
+	// Denied is set when the license is on the deny list.
+	Denied bool `json:"denied,omitempty"`
+}
+
+// Parse reads the output of Script. Packages seen twice, such as a conda
+// package that pip lists as well, are kept once, the first time.
+func Parse(output string) []Package {
+	var pkgs []Package
+	seen := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(fields) != 4 || fields[1] == "" {
+			continue
+		}
+		p := Package{Manager: fields[0], Name: fields[1], Version: fields[2], License: strings.TrimSpace(fields[3])}
+		if p.Manager == "dpkg" && strings.Contains(p.License, "|") {
+			// Each file of the package is under one of the licenses.
+			parts := strings.Split(p.License, "|")
+			for i, l := range parts {
+				if strings.Contains(l, " ") {
+					parts[i] = "(" + l + ")"
+				}
+			}
+			p.License = strings.Join(parts, " AND ")
+		}
+		key := strings.ToLower(strings.ReplaceAll(p.Name, "_", "-")) + "\x00" + p.Version
+		if p.Manager == "pip" || p.Manager == "conda" {
+			key = "python\x00" + key
+		} else {
+			key = p.Manager + "\x00" + key
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		pkgs = append(pkgs, p)
+	}
+	return pkgs
+}
+
+// Denied reports whether the license expression is denied by the deny
+// list. Expressions are read as SPDX ones: a package under A AND B is
+// denied if A or B is, one under A OR B only if both are. Entries are
+// matched case-insensitively against the license identifiers and may end in
+// * to match a prefix, as in AGPL-*.
+func Denied(license string, deny []string) bool {
+	if len(deny) == 0 {
+		return false
+	}
+	denied, _ := parse(license, deny)
+	return denied
+}
+
+// parse reads the license expression, returning whether deny denies it
+// and the licenses it names.
+func parse(license string, deny []string) (bool, []string) {
+	e := &expression{tokens: strings.FieldsFunc(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(license), func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(",;/|", r)
+	}), deny: deny}
+	denied := e.or()
+	// Whatever follows an unbalanced ) is read as more conjuncts.
+	for e.pos < len(e.tokens) {
+		e.pos++
+		denied = e.or() || denied
+	}
+	return denied, e.licenses
+}
+
+// expression evaluates whether a license expression is denied by
+// recursive descent, AND binding tighter than OR.
+type expression struct {
+	tokens   []string
+	pos      int
+	deny     []string
+	licenses []string
+}
+
+func (e *expression) peek(word string) bool {
+	return e.pos < len(e.tokens) && strings.EqualFold(e.tokens[e.pos], word)
+}
+
+func (e *expression) or() bool {
+	denied := e.and()
+	for e.peek("OR") {
+		e.pos++
+		denied = e.and() && denied
+	}
+	return denied
+}
+
+func (e *expression) and() bool {
+	denied := e.term()
+	for e.peek("AND") {
+		e.pos++
+		denied = e.term() || denied
+	}
+	return denied
+}
+
+// term reads a parenthesized expression or a license: its identifier, or
+// the words of a license written out, such as "MIT License", any of which
+// may be denied, and the exception that follows WITH, which is ignored.
+func (e *expression) term() bool {
+	if e.peek("(") {
+		e.pos++
+		denied := e.or()
+		if e.peek(")") {
+			e.pos++
+		}
+		return denied
+	}
+	denied, exception := false, false
+	var words []string
+	for ; e.pos < len(e.tokens) && !e.peek("AND") && !e.peek("OR") && !e.peek("(") && !e.peek(")"); e.pos++ {
+		exception = exception || e.peek("WITH")
+		if !exception {
+			words = append(words, e.tokens[e.pos])
+			denied = denied || matchesAny(e.tokens[e.pos], e.deny)
+		}
+	}
+	if len(words) > 0 {
+		e.licenses = append(e.licenses, strings.Join(words, " "))
+	}
+	return denied
+}
+
+// Check marks the packages whose license is denied and returns them.
+func Check(pkgs []Package, deny []string) []Package {
+	var denied []Package
+	for i := range pkgs {
+		if Denied(pkgs[i].License, deny) {
+			pkgs[i].Denied = true
+			denied = append(denied, pkgs[i])
+		}
+	}
+	return denied
+}
+
+// Summary counts the packages under each license, most common first, as in
+// "MIT=120, GPL-2.0+=31, unknown=4": a package under MIT AND BSD-3-Clause
+// counts for both, and packages that state no license count as unknown.
+// Licenses past the first max are counted together as other, unless max is
+// 0.
+func Summary(pkgs []Package, max int) string {
+	counts := map[string]int{}
+	for _, p := range pkgs {
+		_, names := parse(p.License, nil)
+		if len(names) == 0 {
+			names = []string{"unknown"}
+		}
+		slices.Sort(names)
+		for _, name := range slices.Compact(names) {
+			counts[name]++
+		}
+	}
+	licenses := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(counts[b]-counts[a], strings.Compare(a, b))
+	})
+	var parts []string
+	other := 0
+	for i, l := range licenses {
+		if max > 0 && i >= max {
+			other += counts[l]
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%d", l, counts[l]))
+	}
+	if other > 0 {
+		parts = append(parts, fmt.Sprintf("other=%d", other))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func matchesAny(id string, patterns []string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if len(id) >= len(prefix) && strings.EqualFold(id[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(id, p) {
+			return true
+		}
+	}
+	return false
+}
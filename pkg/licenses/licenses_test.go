@@ -0,0 +1,69 @@
+package licenses
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	out := "dpkg\tbash\t5.1-6\tGPL-3+|BSD-4-clause-UC\n" +
+		"dpkg\tlibgcrypt20\t1.10.1\tGPL-2+ or LGPL-2.1+|GPL-3+\n" +
+		"rpm\tzlib\t1.2.11-40.el9\tzlib and Boost\n" +
+		"conda\tnumpy\t1.26.4\tBSD-3-Clause\n" +
+		"pip\tnumpy\t1.26.4\tBSD License\n" +
+		"pip\ttyping_extensions\t4.9.0\t\n" +
+		"not a package line\n"
+	want := []Package{
+		{Manager: "dpkg", Name: "bash", Version: "5.1-6", License: "GPL-3+ AND BSD-4-clause-UC"},
+		{Manager: "dpkg", Name: "libgcrypt20", Version: "1.10.1", License: "(GPL-2+ or LGPL-2.1+) AND GPL-3+"},
+		{Manager: "rpm", Name: "zlib", Version: "1.2.11-40.el9", License: "zlib and Boost"},
+		{Manager: "conda", Name: "numpy", Version: "1.26.4", License: "BSD-3-Clause"},
+		{Manager: "pip", Name: "typing_extensions", Version: "4.9.0"},
+	}
+	if got := Parse(out); !slices.Equal(got, want) {
+		t.Fatalf("Parse =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDenied(t *testing.T) {
+	deny := []string{"AGPL-*", "SSPL-1.0", "Commons-Clause"}
+	for license, want := range map[string]bool{
+		"":                                 false,
+		"MIT":                              false,
+		"AGPL-3.0-only":                    true,
+		"agpl-3.0-or-later":                true,
+		"MIT AND SSPL-1.0":                 true,
+		"MIT OR AGPL-3.0-only":             false,
+		"AGPL-3.0-only OR SSPL-1.0":        true,
+		"(MIT OR AGPL-3.0) AND SSPL-1.0":   true,
+		"(MIT AND AGPL-3.0) OR Apache-2.0": false,
+		"Apache-2.0 WITH Commons-Clause":   false,
+		"Apache-2.0 with Commons Clause":   false,
+		"GPL-2.0 | AGPL-3.0":               true,
+	} {
+		if got := Denied(license, deny); got != want {
+			t.Errorf("Denied(%q) = %v, want %v", license, got, want)
+		}
+	}
+}
+
+func TestCheckAndSummary(t *testing.T) {
+	pkgs := []Package{
+		{Name: "a", License: "MIT"},
+		{Name: "b", License: "AGPL-3.0-only"},
+		{Name: "c", License: "MIT"},
+		{Name: "d"},
+		{Name: "e", License: "BSD-3-Clause"},
+		{Name: "f", License: "(MIT OR Apache-2.0) AND MIT"},
+	}
+	denied := Check(pkgs, []string{"AGPL-*"})
+	if len(denied) != 1 || denied[0].Name != "b" || !pkgs[1].Denied || pkgs[0].Denied {
+		t.Fatalf("Check = %+v, packages %+v", denied, pkgs)
+	}
+	if got, want := Summary(pkgs, 0), "MIT=3, AGPL-3.0-only=1, Apache-2.0=1, BSD-3-Clause=1, unknown=1"; got != want {
+		t.Fatalf("Summary = %q, want %q", got, want)
+	}
+	if got, want := Summary(pkgs, 2), "MIT=3, AGPL-3.0-only=1, other=3"; got != want {
+		t.Fatalf("Summary(2) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+// Package lockfile reads and writes build.lock, which pins the inputs of a
+// recipe that its build.yaml leaves open, such as the digests behind base
+// image tags and download URLs, so `builder build --locked` can refuse to
+// build a published release from inputs that have since changed.
+package lockfile
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// FileName is the name of the lockfile, next to build.yaml.
+const FileName = "build.lock"
+
+const header = "# Generated by `builder lock`; do not edit.\n"
+
+// Lock is the content of a build.lock.
+type Lock struct {
+	// Builder is the version of the builder that wrote the lock.
+	Builder string `yaml:"builder"`
+	// BaseImages maps each base image, as the generated Dockerfile names
+	// it, to the digest of its manifest.
+	BaseImages map[string]string `yaml:"base_images,omitempty"`
+	// URLs maps each downloaded file's URL to the sha256 digest of its
+	// contents.
+	URLs map[string]string `yaml:"urls,omitempty"`
+	// Git maps each git source checked out by ref, as url#ref, to the
+	// commit the ref named.
+	Git map[string]string `yaml:"git,omitempty"`
+	// Templates are the templates the recipe uses, by name.
+	Templates map[string]Template `yaml:"templates,omitempty"`
+}
+
+// Template is a template as locked.
+type Template struct {
+	Version string `yaml:"version,omitempty"`
+	// Digest is the sha256 digest of the template's spec file.
+	Digest string `yaml:"digest"`
+}
+
+// Read reads the lockfile at path. A missing file is an error that
+// matches os.ErrNotExist.
+func Read(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var l Lock
+	if err := dec.Decode(&l); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return &l, nil
+}
+
+// Marshal returns the lockfile text of l. Map keys are sorted, so equal
+// locks have equal text.
+func (l *Lock) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(l); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Drift returns how the inputs in current differ from the ones locked: a
+// different builder, and every input of current that is not locked or is
+// locked to another digest, sorted by kind and name. Inputs that are
+// locked but not in current are ignored, since a build for one
+// architecture uses only some of them; see Unused.
+func Drift(locked, current *Lock) []string {
+	var drift []string
+	if current.Builder != locked.Builder {
+		drift = append(drift, fmt.Sprintf("builder: locked %q, running %q", locked.Builder, current.Builder))
+	}
+	compare := func(kind string, locked, current map[string]string) {
+		for _, k := range slices.Sorted(maps.Keys(current)) {
+			switch l, ok := locked[k]; {
+			case !ok:
+				drift = append(drift, fmt.Sprintf("%s %s is not locked", kind, k))
+			case l != current[k]:
+				drift = append(drift, fmt.Sprintf("%s %s: locked %s, now %s", kind, k, l, current[k]))
+			}
+		}
+	}
+	compare("base image", locked.BaseImages, current.BaseImages)
+	compare("url", locked.URLs, current.URLs)
+	compare("git", locked.Git, current.Git)
+	for _, name := range slices.Sorted(maps.Keys(current.Templates)) {
+		l, ok := locked.Templates[name]
+		c := current.Templates[name]
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("template %s is not locked", name))
+		case l.Version != c.Version:
+			drift = append(drift, fmt.Sprintf("template %s: locked version %q, now %q", name, l.Version, c.Version))
+		case l.Digest != c.Digest:
+			drift = append(drift, fmt.Sprintf("template %s: locked %s, now %s", name, l.Digest, c.Digest))
+		}
+	}
+	return drift
+}
+
+// Unused returns the inputs locked that current no longer has.
+func Unused(locked, current *Lock) []string {
+	var unused []string
+	check := func(kind string, locked, current map[string]string) {
+		for _, k := range slices.Sorted(maps.Keys(locked)) {
+			if _, ok := current[k]; !ok {
+				unused = append(unused, fmt.Sprintf("%s %s is locked but no longer used", kind, k))
+			}
+		}
+	}
+	check("base image", locked.BaseImages, current.BaseImages)
+	check("url", locked.URLs, current.URLs)
+	check("git", locked.Git, current.Git)
+	for _, name := range slices.Sorted(maps.Keys(locked.Templates)) {
+		if _, ok := current.Templates[name]; !ok {
+			unused = append(unused, fmt.Sprintf("template %s is locked but no longer used", name))
+		}
+	}
+	return unused
+}
@@ -0,0 +1,71 @@
+package lockfile
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	l := &Lock{
+		Builder:    "v1.2.0",
+		BaseImages: map[string]string{"ubuntu:24.04": "sha256:aa", "gcc:14": "sha256:bb"},
+		URLs:       map[string]string{"https://example.org/tool.tar.gz": "sha256:cc"},
+		Git:        map[string]string{"https://github.com/example/tool#main": "0123456789abcdef0123456789abcdef01234567"},
+		Templates:  map[string]Template{"fsl": {Version: "1", Digest: "sha256:dd"}},
+	}
+	data, err := l.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+	read, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	again, err := read.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if string(again) != string(data) {
+		t.Fatalf("round trip changed the lock:\n%s\nwant:\n%s", again, data)
+	}
+	if drift := Drift(l, read); drift != nil {
+		t.Fatalf("Drift of a lock against itself = %q", drift)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	locked := &Lock{
+		Builder:    "v1.2.0",
+		BaseImages: map[string]string{"ubuntu:24.04": "sha256:aa"},
+		URLs:       map[string]string{"https://example.org/a": "sha256:01", "https://example.org/arm": "sha256:02"},
+		Templates:  map[string]Template{"fsl": {Version: "1", Digest: "sha256:dd"}, "jq": {Digest: "sha256:ee"}},
+	}
+	current := &Lock{
+		Builder:    "v1.3.0",
+		BaseImages: map[string]string{"ubuntu:24.04": "sha256:ff"},
+		URLs:       map[string]string{"https://example.org/a": "sha256:01", "https://example.org/b": "sha256:03"},
+		Templates:  map[string]Template{"fsl": {Version: "2", Digest: "sha256:dd"}},
+	}
+	want := []string{
+		`builder: locked "v1.2.0", running "v1.3.0"`,
+		"base image ubuntu:24.04: locked sha256:aa, now sha256:ff",
+		"url https://example.org/b is not locked",
+		`template fsl: locked version "1", now "2"`,
+	}
+	if got := Drift(locked, current); !slices.Equal(got, want) {
+		t.Fatalf("Drift = %q\nwant %q", got, want)
+	}
+	want = []string{
+		"url https://example.org/arm is locked but no longer used",
+		"template jq is locked but no longer used",
+	}
+	if got := Unused(locked, current); !slices.Equal(got, want) {
+		t.Fatalf("Unused = %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,403 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+	"go.yaml.in/yaml/v4"
+)
+
+// Position is a zero-based line and UTF-16 character offset. Recipes are
+// almost always ASCII, so characters are treated as bytes.
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Diagnostic severities.
+const (
+	SeverityError = 1
+)
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+// Completion item kinds.
+const (
+	kindField    = 5
+	kindModule   = 9
+	kindProperty = 10
+)
+
+type CompletionItem struct {
+	Label         string `json:"label"`
+	Kind          int    `json:"kind,omitempty"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// directiveDocs documents the directive keys for hover and completion.
+var directiveDocs = map[string]string{
+	"group":        "A list of directives applied in their own scope; `with` sets variables for it.",
+	"layer":        "A `group` whose `RUN` steps are merged into a single layer.",
+	"run":          "Shell commands run in one `RUN` step.",
+	"file":         "Declares a file (from `filename`, `url`, `contents` or `git`) available as `get_file(name)`.",
+	"install":      "Packages installed with the recipe's package manager.",
+	"environment":  "Environment variables set in the image (`ENV`).",
+	"user":         "Switches the user for later directives (`USER`), creating it if needed.",
+	"workdir":      "Sets the working directory (`WORKDIR`).",
+	"deploy":       "Binaries (`bins`) and directories (`path`) exposed to users of the container.",
+	"entrypoint":   "Sets the image entrypoint.",
+	"test":         "A test run by `builder test`: a `script` or an `executable`.",
+	"template":     "Runs a template (`name:` plus its parameters), e.g. to install a neuroimaging package.",
+	"include":      "Includes the directives of another YAML file from the include directories.",
+	"copy":         "Copies files from the build context into the image.",
+	"variables":    "Sets template variables, available as `context.<name>` and `local.<name>`.",
+	"boutique":     "A Boutiques descriptor for the tool.",
+	"starlark":     "Runs an inline Starlark script (`script`) or file (`file`).",
+	"labels":       "Image labels (`LABEL`).",
+	"cmd":          "Sets the default command (`CMD`).",
+	"healthcheck":  "Sets the image `HEALTHCHECK`.",
+	"expose":       "Ports the image listens on (`EXPOSE`).",
+	"volumes":      "Mount points declared in the image (`VOLUME`).",
+	"shell":        "Sets the shell for later `RUN` steps (`SHELL`).",
+	"args":         "Build arguments (`ARG`), with optional defaults.",
+	"copy_from":    "Copies paths from an earlier build stage.",
+	"arch":         "Directives applied only for the target architecture (`x86_64` or `aarch64`).",
+	"condition":    "A Jinja2 expression; the directive is skipped when it is false.",
+	"with":         "Variables for a `group`.",
+	"single_layer": "Merges the `RUN` steps of a `group` into a single layer.",
+	"custom":       "A custom directive provided by a Go plugin or a `<name>.star` file.",
+	"customParams": "Parameters passed to a `custom` directive.",
+}
+
+// Diagnose validates a recipe the way LoadBuildFile does and reports
+// problems as diagnostics. Errors that carry a YAML line number are placed
+// on that line, others on the first line.
+func Diagnose(uri, text string) []Diagnostic {
+	dir := "."
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		dir = filepath.Dir(u.Path)
+	}
+	_, err := recipe.ParseBuildFile([]byte(text), dir)
+	if err == nil {
+		return []Diagnostic{}
+	}
+
+	lines := strings.Split(text, "\n")
+	var diags []Diagnostic
+	for _, part := range strings.Split(err.Error(), "\n") {
+		part = strings.TrimSpace(part)
+		// Skip the headers of error lists, such as "yaml: unmarshal errors:".
+		if part == "" || strings.HasSuffix(part, "errors:") {
+			continue
+		}
+		line := 0
+		if m := yamlLinePattern.FindStringSubmatch(part); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			line = max(0, min(n-1, len(lines)-1))
+		}
+		diags = append(diags, Diagnostic{
+			Range: Range{
+				Start: Position{Line: line},
+				End:   Position{Line: line, Character: len(lines[line])},
+			},
+			Severity: SeverityError,
+			Source:   "builder",
+			Message:  part,
+		})
+	}
+	return diags
+}
+
+var yamlLinePattern = regexp.MustCompile(`\bline (\d+)\b`)
+
+// Hover documents the directive key, template or template parameter at
+// pos.
+func Hover(text string, pos Position) (string, bool) {
+	lines := strings.Split(text, "\n")
+	if pos.Line >= len(lines) {
+		return "", false
+	}
+	line := lines[pos.Line]
+	word := wordAt(line, pos.Character)
+	if word == "" {
+		return "", false
+	}
+	key, value := splitKey(line)
+	parent, parentLine := parentKey(lines, pos.Line)
+
+	if parent == "template" {
+		name := blockValue(lines, parentLine, "name")
+		if key == "name" && word == value {
+			return describeTemplate(word)
+		}
+		if word == key {
+			return describeParam(name, key)
+		}
+	}
+	if word == key {
+		if doc, ok := directiveDocs[key]; ok {
+			return fmt.Sprintf("**%s**\n\n%s", key, doc), true
+		}
+	}
+	return "", false
+}
+
+// Complete offers template names after `name:` in a template, parameters
+// inside a template, directive keys in directive lists, top-level keys, and
+// recipe option names after `options.`.
+func Complete(text string, pos Position) []CompletionItem {
+	lines := strings.Split(text, "\n")
+	if pos.Line >= len(lines) {
+		return []CompletionItem{}
+	}
+	line := lines[pos.Line]
+	prefix := line[:min(pos.Character, len(line))]
+
+	if m := optionRefPattern.FindStringSubmatch(prefix); m != nil {
+		return optionItems(text)
+	}
+
+	parent, parentLine := parentKey(lines, pos.Line)
+	if m := keyPrefixPattern.FindStringSubmatch(prefix); m != nil {
+		switch {
+		case parent == "template":
+			return paramItems(blockValue(lines, parentLine, "name"))
+		case m[1] != "":
+			return directiveItems()
+		case len(m[0]) == len(m[2]):
+			return topLevelItems()
+		}
+	}
+	if parent == "template" && templateNamePattern.MatchString(prefix) {
+		var items []CompletionItem
+		for _, name := range recipe.TemplateNames() {
+			items = append(items, CompletionItem{Label: name, Kind: kindModule})
+		}
+		return items
+	}
+	return []CompletionItem{}
+}
+
+var (
+	optionRefPattern    = regexp.MustCompile(`\boptions\.([A-Za-z0-9_]*)$`)
+	keyPrefixPattern    = regexp.MustCompile(`^\s*(- )?([A-Za-z0-9_-]*)$`)
+	templateNamePattern = regexp.MustCompile(`^\s*(- )?name:\s*[A-Za-z0-9_-]*$`)
+)
+
+func directiveItems() []CompletionItem {
+	var items []CompletionItem
+	for key, doc := range directiveDocs {
+		items = append(items, CompletionItem{Label: key, Kind: kindProperty, Documentation: doc})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func topLevelItems() []CompletionItem {
+	defs := recipe.JSONSchema()["$defs"].(map[string]any)
+	props := defs["BuildFile"].(map[string]any)["properties"].(map[string]any)
+	var items []CompletionItem
+	for key := range props {
+		items = append(items, CompletionItem{Label: key, Kind: kindProperty})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func paramItems(template string) []CompletionItem {
+	doc, err := recipe.DescribeTemplate(template)
+	if err != nil {
+		return []CompletionItem{}
+	}
+	seen := map[string]bool{}
+	var items []CompletionItem
+	for _, m := range doc.Methods {
+		for _, param := range m.Params() {
+			if seen[param] {
+				continue
+			}
+			seen[param] = true
+			detail, _ := describeParam(template, param)
+			items = append(items, CompletionItem{Label: param, Kind: kindProperty, Documentation: detail})
+		}
+	}
+	return items
+}
+
+// optionItems lists the options declared by the recipe being edited.
+func optionItems(text string) []CompletionItem {
+	var doc struct {
+		Options map[string]struct {
+			Description string `yaml:"description"`
+		} `yaml:"options"`
+	}
+	_ = yaml.Unmarshal([]byte(text), &doc)
+	var items []CompletionItem
+	for name, opt := range doc.Options {
+		items = append(items, CompletionItem{Label: name, Kind: kindField, Detail: opt.Description})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	if items == nil {
+		return []CompletionItem{}
+	}
+	return items
+}
+
+func describeTemplate(name string) (string, bool) {
+	doc, err := recipe.DescribeTemplate(name)
+	if err != nil {
+		return "", false
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "**template %s**", doc.Name)
+	if doc.URL != "" {
+		fmt.Fprintf(&b, " — %s", doc.URL)
+	}
+	if doc.Deprecation != "" {
+		fmt.Fprintf(&b, "\n\n**Deprecated:** %s", doc.Deprecation)
+	}
+	if doc.Alert != "" {
+		fmt.Fprintf(&b, "\n\n> %s", doc.Alert)
+	}
+	for _, m := range doc.Methods {
+		fmt.Fprintf(&b, "\n\n`method: %s`", m.Method)
+		if len(m.Required) > 0 {
+			fmt.Fprintf(&b, "\n- required: %s", strings.Join(m.Required, ", "))
+		}
+		if len(m.Optional) > 0 {
+			var opts []string
+			for k := range m.Optional {
+				opts = append(opts, k)
+			}
+			sort.Strings(opts)
+			fmt.Fprintf(&b, "\n- optional: %s", strings.Join(opts, ", "))
+		}
+		if len(m.Versions) > 0 {
+			fmt.Fprintf(&b, "\n- versions: %s", strings.Join(m.Versions, ", "))
+		}
+	}
+	return b.String(), true
+}
+
+func describeParam(template, param string) (string, bool) {
+	doc, err := recipe.DescribeTemplate(template)
+	if err != nil {
+		return "", false
+	}
+	if param == "method" {
+		var methods []string
+		for _, m := range doc.Methods {
+			methods = append(methods, m.Method)
+		}
+		return fmt.Sprintf("**method** of %s: one of %s (default binaries)", template, strings.Join(methods, ", ")), true
+	}
+	var parts []string
+	for _, m := range doc.Methods {
+		for _, r := range m.Required {
+			if r == param {
+				parts = append(parts, fmt.Sprintf("required for %s", m.Method))
+			}
+		}
+		if def, ok := m.Optional[param]; ok {
+			parts = append(parts, fmt.Sprintf("optional for %s, default `%s`", m.Method, def))
+		}
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("**%s** of %s: %s", param, template, strings.Join(parts, "; ")), true
+}
+
+// keyColumn returns where the key of a line starts, after any list dash.
+func keyColumn(line string) int {
+	col := len(line) - len(strings.TrimLeft(line, " "))
+	rest := line[col:]
+	for strings.HasPrefix(rest, "- ") {
+		trimmed := strings.TrimLeft(rest[2:], " ")
+		col += len(rest) - len(trimmed)
+		rest = trimmed
+	}
+	return col
+}
+
+// splitKey returns the mapping key on a line and the scalar after it.
+func splitKey(line string) (key, value string) {
+	rest := strings.TrimSpace(line[keyColumn(line):])
+	key, value, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", ""
+	}
+	return strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"'`)
+}
+
+// parentKey returns the key of the mapping enclosing line n, and its line.
+func parentKey(lines []string, n int) (string, int) {
+	col := keyColumn(lines[n])
+	for i := n - 1; i >= 0; i-- {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if keyColumn(lines[i]) < col {
+			key, _ := splitKey(lines[i])
+			return key, i
+		}
+	}
+	return "", -1
+}
+
+// blockValue returns the scalar value of key among the children of the
+// mapping key on line parent.
+func blockValue(lines []string, parent int, key string) string {
+	if parent < 0 {
+		return ""
+	}
+	col := keyColumn(lines[parent])
+	for _, line := range lines[parent+1:] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if keyColumn(line) <= col {
+			break
+		}
+		if k, v := splitKey(line); k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+func wordAt(line string, char int) string {
+	isWord := func(c byte) bool {
+		return c == '_' || c == '-' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	if char > len(line) {
+		return ""
+	}
+	start, end := char, char
+	for start > 0 && isWord(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWord(line[end]) {
+		end++
+	}
+	return line[start:end]
+}
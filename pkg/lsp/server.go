@@ -0,0 +1,211 @@
+// Package lsp implements a minimal Language Server Protocol server for
+// build.yaml recipes, spoken over stdio by `builder lsp`. It supports full
+// document sync, diagnostics from the recipe validators, hover
+// documentation and completion.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC error codes used by the server.
+const (
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Server holds the open documents of one client connection.
+type Server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]string
+}
+
+// Serve runs a server reading requests from r and writing responses and
+// notifications to w until the client sends exit or closes r.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &Server{in: bufio.NewReader(r), out: w, docs: map[string]string{}}
+	for {
+		msg, err := s.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.dispatch(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) read() (*message, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header: %w", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("decoding message: %w", err)
+	}
+	return &msg, nil
+}
+
+func (s *Server) write(msg message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) notify(method string, params any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.write(message{Method: method, Params: raw})
+}
+
+func (s *Server) dispatch(msg *message) error {
+	result, rpcErr := s.handle(msg.Method, msg.Params)
+	if msg.ID == nil {
+		// Notifications get no response.
+		return nil
+	}
+	resp := message{ID: msg.ID, Error: rpcErr}
+	if rpcErr == nil {
+		resp.Result = result
+		if result == nil {
+			resp.Result = json.RawMessage("null")
+		}
+	}
+	return s.write(resp)
+}
+
+func (s *Server) handle(method string, raw json.RawMessage) (any, *responseError) {
+	decode := func(v any) *responseError {
+		if err := json.Unmarshal(raw, v); err != nil {
+			return &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return nil
+	}
+
+	switch method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // full
+				"hoverProvider":      true,
+				"completionProvider": map[string]any{"triggerCharacters": []string{":", ".", " "}},
+			},
+			"serverInfo": map[string]any{"name": "builder"},
+		}, nil
+	case "initialized", "shutdown", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument   struct{ URI string } `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p struct {
+			TextDocument struct{ URI string } `json:"textDocument"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.publish(p.TextDocument.URI, []Diagnostic{})
+		return nil, nil
+
+	case "textDocument/hover", "textDocument/completion":
+		var p struct {
+			TextDocument struct{ URI string } `json:"textDocument"`
+			Position     Position             `json:"position"`
+		}
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		text := s.docs[p.TextDocument.URI]
+		if method == "textDocument/hover" {
+			contents, ok := Hover(text, p.Position)
+			if !ok {
+				return nil, nil
+			}
+			return map[string]any{"contents": map[string]any{"kind": "markdown", "value": contents}}, nil
+		}
+		return Complete(text, p.Position), nil
+	}
+
+	if strings.HasPrefix(method, "$/") {
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not supported: " + method}
+}
+
+func (s *Server) update(uri, text string) {
+	s.docs[uri] = text
+	s.publish(uri, Diagnose(uri, text))
+}
+
+func (s *Server) publish(uri string, diags []Diagnostic) {
+	// A failed write surfaces on the next response.
+	_ = s.notify("textDocument/publishDiagnostics", map[string]any{"uri": uri, "diagnostics": diags})
+}
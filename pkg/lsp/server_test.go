@@ -0,0 +1,183 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const recipeText = `name: tool
+version: 1.0.0
+architectures:
+  - x86_64
+options:
+  gpu:
+    description: Build with CUDA
+    default: false
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  directives:
+    - template:
+        name: jq
+        version: "1.6"
+    - run:
+        - echo hi
+`
+
+func frame(t *testing.T, msg map[string]any) string {
+	t.Helper()
+	body, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func readFrames(t *testing.T, r io.Reader) []map[string]any {
+	t.Helper()
+	br := bufio.NewReader(r)
+	var out []map[string]any
+	for {
+		header, err := textproto.NewReader(br).ReadMIMEHeader()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("reading header: %v", err)
+		}
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, n)
+		if _, err := io.ReadFull(br, body); err != nil {
+			t.Fatalf("reading body: %v", err)
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("decoding %s: %v", body, err)
+		}
+		out = append(out, msg)
+	}
+}
+
+func TestServeSession(t *testing.T) {
+	uri := "file:///recipes/tool/build.yaml"
+	pos := func(line, char int) map[string]any {
+		return map[string]any{"textDocument": map[string]any{"uri": uri}, "position": map[string]any{"line": line, "character": char}}
+	}
+	in := strings.Join([]string{
+		frame(t, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": map[string]any{}}),
+		frame(t, map[string]any{"jsonrpc": "2.0", "method": "initialized", "params": map[string]any{}}),
+		frame(t, map[string]any{"jsonrpc": "2.0", "method": "textDocument/didOpen", "params": map[string]any{
+			"textDocument": map[string]any{"uri": uri, "text": strings.Replace(recipeText, "x86_64", "sparc", 1)},
+		}}),
+		frame(t, map[string]any{"jsonrpc": "2.0", "method": "textDocument/didChange", "params": map[string]any{
+			"textDocument":   map[string]any{"uri": uri},
+			"contentChanges": []any{map[string]any{"text": recipeText}},
+		}}),
+		frame(t, map[string]any{"jsonrpc": "2.0", "id": 2, "method": "textDocument/hover", "params": pos(14, 16)}),
+		frame(t, map[string]any{"jsonrpc": "2.0", "id": 3, "method": "textDocument/completion", "params": pos(15, 10)}),
+		frame(t, map[string]any{"jsonrpc": "2.0", "id": 4, "method": "workspace/symbol", "params": map[string]any{}}),
+		frame(t, map[string]any{"jsonrpc": "2.0", "id": 5, "method": "shutdown"}),
+		frame(t, map[string]any{"jsonrpc": "2.0", "method": "exit"}),
+	}, "")
+
+	var out bytes.Buffer
+	if err := Serve(strings.NewReader(in), &out); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	msgs := readFrames(t, &out)
+	if len(msgs) != 7 {
+		t.Fatalf("got %d messages, want 7: %v", len(msgs), msgs)
+	}
+
+	caps := msgs[0]["result"].(map[string]any)["capabilities"].(map[string]any)
+	if caps["hoverProvider"] != true {
+		t.Fatalf("initialize result = %v", msgs[0])
+	}
+
+	opened := msgs[1]["params"].(map[string]any)["diagnostics"].([]any)
+	if len(opened) == 0 || !strings.Contains(opened[0].(map[string]any)["message"].(string), "architectures") {
+		t.Fatalf("expected an architectures diagnostic, got %v", opened)
+	}
+	if changed := msgs[2]["params"].(map[string]any)["diagnostics"].([]any); len(changed) != 0 {
+		t.Fatalf("valid recipe has diagnostics: %v", changed)
+	}
+
+	hover := msgs[3]["result"].(map[string]any)["contents"].(map[string]any)["value"].(string)
+	if !strings.Contains(hover, "template jq") {
+		t.Fatalf("hover = %q", hover)
+	}
+
+	var labels []string
+	for _, item := range msgs[4]["result"].([]any) {
+		labels = append(labels, item.(map[string]any)["label"].(string))
+	}
+	if !strings.Contains(strings.Join(labels, ","), "version") {
+		t.Fatalf("completion inside a template = %v, want its parameters", labels)
+	}
+
+	if msgs[5]["error"].(map[string]any)["code"].(float64) != codeMethodNotFound {
+		t.Fatalf("unsupported method response = %v", msgs[5])
+	}
+	if _, ok := msgs[6]["result"]; !ok {
+		t.Fatalf("shutdown response = %v", msgs[6])
+	}
+}
+
+func TestDiagnoseYAMLErrorsHaveLines(t *testing.T) {
+	diags := Diagnose("file:///r/build.yaml", "name: x\nversion: [\n")
+	if len(diags) == 0 || diags[0].Range.Start.Line == 0 {
+		t.Fatalf("diagnostics = %+v, want one on a later line", diags)
+	}
+	diags = Diagnose("file:///r/build.yaml", strings.Replace(recipeText, "    - run:", "    - rnu:", 1))
+	if len(diags) != 1 || diags[0].Range.Start.Line != 16 {
+		t.Fatalf("diagnostics = %+v, want one on line 16", diags)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	labels := func(items []CompletionItem) string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Label)
+		}
+		return strings.Join(out, ",")
+	}
+
+	text := strings.Replace(recipeText, "        name: jq", "        name: j", 1)
+	if got := labels(Complete(text, Position{Line: 14, Character: 15})); !strings.Contains(got, "jq") || !strings.Contains(got, "fsl") {
+		t.Fatalf("template name completion = %s", got)
+	}
+
+	text = strings.Replace(recipeText, "        - echo hi", "        - echo {{ options.", 1)
+	if got := labels(Complete(text, Position{Line: 17, Character: 30})); got != "gpu" {
+		t.Fatalf("option completion = %s, want gpu", got)
+	}
+
+	text = strings.Replace(recipeText, "    - run:", "    - ru", 1)
+	if got := labels(Complete(text, Position{Line: 16, Character: 8})); !strings.Contains(got, "run") || !strings.Contains(got, "copy_from") {
+		t.Fatalf("directive completion = %s", got)
+	}
+
+	if got := labels(Complete(recipeText+"vers", Position{Line: 18, Character: 4})); !strings.Contains(got, "variables_from") {
+		t.Fatalf("top-level completion = %s", got)
+	}
+}
+
+func TestHoverDirective(t *testing.T) {
+	doc, ok := Hover(recipeText, Position{Line: 16, Character: 7})
+	if !ok || !strings.Contains(doc, "RUN") {
+		t.Fatalf("hover on run = %q, %v", doc, ok)
+	}
+	doc, ok = Hover(recipeText, Position{Line: 15, Character: 10})
+	if !ok || !strings.Contains(doc, "version") {
+		t.Fatalf("hover on a template parameter = %q, %v", doc, ok)
+	}
+}
@@ -0,0 +1,33 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// InputDigest returns a digest of everything that goes into an image: the
+// IR directives of def and the digests of the other inputs, keyed by a name
+// for each (staged files, build arguments, the architecture). Builds with
+// equal digests produce the same image, so the digest is stable across
+// runs and does not depend on map order.
+func InputDigest(def *ir.Definition, inputs map[string]string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "builder-inputs v1\n")
+	for _, d := range def.Directives {
+		// fmt prints maps with sorted keys, as for DirectiveHashes.
+		fmt.Fprintf(h, "directive %T %#v\n", d.Directive, d.Directive)
+	}
+	keys := make([]string, 0, len(inputs))
+	for k := range inputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "input %q %q\n", k, inputs[k])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
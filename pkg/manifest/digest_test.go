@@ -0,0 +1,79 @@
+package manifest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+func TestInputDigest(t *testing.T) {
+	def := &ir.Definition{Directives: []ir.DirectiveWithMetadata{
+		{Directive: ir.FromImageDirective("ubuntu:22.04")},
+		{Directive: ir.EnvironmentDirective{"A": "1", "B": "2"}},
+	}}
+	inputs := map[string]string{"file:a": "sha256:1", "arg:X": "y", "arch": "x86_64"}
+	base := InputDigest(def, inputs)
+	for i := 0; i < 10; i++ {
+		if got := InputDigest(def, map[string]string{"arch": "x86_64", "arg:X": "y", "file:a": "sha256:1"}); got != base {
+			t.Fatalf("digest not stable: %s != %s", got, base)
+		}
+	}
+
+	if InputDigest(def, map[string]string{"file:a": "sha256:2", "arg:X": "y", "arch": "x86_64"}) == base {
+		t.Fatalf("changing an input did not change the digest")
+	}
+	changed := &ir.Definition{Directives: []ir.DirectiveWithMetadata{
+		{Directive: ir.FromImageDirective("ubuntu:24.04")},
+		{Directive: ir.EnvironmentDirective{"A": "1", "B": "2"}},
+	}}
+	if InputDigest(changed, inputs) == base {
+		t.Fatalf("changing a directive did not change the digest")
+	}
+	// Keys and values must not run together.
+	if InputDigest(def, map[string]string{"a": "bc"}) == InputDigest(def, map[string]string{"ab": "c"}) {
+		t.Fatalf("ambiguous encoding of inputs")
+	}
+}
+
+func TestLastSuccess(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+	base := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, m := range []Manifest{
+		{Recipe: "fsl", Version: "6.0.7", Arch: "x86_64", Status: StatusSucceeded, InputDigest: "old", Started: base},
+		{Recipe: "fsl", Version: "6.0.7", Arch: "x86_64", Status: StatusSucceeded, InputDigest: "new", Started: base.Add(time.Hour)},
+		{Recipe: "fsl", Version: "6.0.7", Arch: "x86_64", Status: StatusFailed, InputDigest: "broken", Started: base.Add(2 * time.Hour)},
+		{Recipe: "fsl", Version: "6.0.7", Arch: "aarch64", Status: StatusSucceeded, InputDigest: "arm", Started: base.Add(3 * time.Hour)},
+	} {
+		if err := s.Add(m); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	m, ok, err := s.LastSuccess("fsl", "6.0.7", "x86_64")
+	if err != nil || !ok || m.InputDigest != "new" {
+		t.Fatalf("LastSuccess = %+v, %v, %v", m, ok, err)
+	}
+	if _, ok, err := s.LastSuccess("fsl", "6.0.6", "x86_64"); err != nil || ok {
+		t.Fatalf("expected no success for another version, got %v, %v", ok, err)
+	}
+}
+
+func TestPreviousVersion(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+	base := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, m := range []Manifest{
+		{Recipe: "fsl", Version: "6.0.6", Tag: "fsl:6.0.6", Status: StatusSucceeded, Started: base},
+		{Recipe: "fsl", Version: "6.0.7", Tag: "fsl:6.0.7", Status: StatusFailed, Started: base.Add(time.Hour)},
+		{Recipe: "fsl", Version: "6.0.8", Tag: "fsl:6.0.8", Status: StatusSucceeded, Started: base.Add(2 * time.Hour)},
+	} {
+		if err := s.Add(m); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if m, ok, err := s.PreviousVersion("fsl", "6.0.8"); err != nil || !ok || m.Tag != "fsl:6.0.6" {
+		t.Fatalf("PreviousVersion = %+v, %v, %v", m, ok, err)
+	}
+	if m, ok, err := s.PreviousVersion("fsl", "6.0.9"); err != nil || !ok || m.Tag != "fsl:6.0.8" {
+		t.Fatalf("PreviousVersion = %+v, %v, %v", m, ok, err)
+	}
+}
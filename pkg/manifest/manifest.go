@@ -0,0 +1,225 @@
+// Package manifest records the outcome of image builds in a local store, so
+// history and dashboards can read structured data instead of build logs.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// Status is the outcome of a build.
+type Status string
+
+const (
+	StatusSucceeded Status = "success"
+	StatusFailed    Status = "failed"
+)
+
+// Manifest describes one build of a recipe.
+type Manifest struct {
+	Recipe  string `json:"recipe"`
+	Version string `json:"version"`
+	Tag     string `json:"tag,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	// Method is the build method, "docker" or "llb".
+	Method string `json:"method"`
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// FailedStep names the step that failed, when it is known.
+	FailedStep string `json:"failed_step,omitempty"`
+	// ImageDigest is the image ID or pushed manifest digest, when known.
+	ImageDigest string `json:"image_digest,omitempty"`
+	// RecipeCommit is the last git commit touching the recipe directory.
+	RecipeCommit   string        `json:"recipe_commit,omitempty"`
+	BuilderVersion string        `json:"builder_version,omitempty"`
+	Started        time.Time     `json:"started"`
+	Duration       time.Duration `json:"duration_ns"`
+	// DirectiveHashes holds a short hash of each IR directive in order, so
+	// two builds can be compared step by step.
+	DirectiveHashes []string `json:"directive_hashes"`
+	// InputDigest is the InputDigest of the build, which a later build with
+	// the same digest can skip.
+	InputDigest string `json:"input_digest,omitempty"`
+	// Report is the per-directive cache report of LLB builds.
+	Report *ir.BuildReport `json:"report,omitempty"`
+}
+
+// DirectiveHashes returns a short content hash of each directive of def.
+func DirectiveHashes(def *ir.Definition) []string {
+	out := make([]string, len(def.Directives))
+	for i, d := range def.Directives {
+		// fmt prints maps with sorted keys, so the hash is stable.
+		sum := sha256.Sum256(fmt.Appendf(nil, "%T %#v", d.Directive, d.Directive))
+		out[i] = hex.EncodeToString(sum[:])[:16]
+	}
+	return out
+}
+
+// BuilderVersion identifies the running builder binary: its module version
+// and, for builds from a checkout, the VCS revision.
+func BuilderVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && s.Value != "" {
+			rev := s.Value
+			if len(rev) > 12 {
+				rev = rev[:12]
+			}
+			version = strings.TrimSpace(version + " " + rev)
+		}
+	}
+	return version
+}
+
+// Store keeps manifests as one JSON Lines file per recipe under Dir.
+type Store struct {
+	Dir string
+}
+
+// DefaultDir is where the builder keeps its manifest store.
+var DefaultDir = filepath.Join("local", "manifests")
+
+func (s Store) path(recipe string) string {
+	return filepath.Join(s.Dir, recipe+".jsonl")
+}
+
+// Add appends m to the history of its recipe.
+func (s Store) Add(m Manifest) error {
+	if m.Recipe == "" || strings.ContainsAny(m.Recipe, `/\`) {
+		return fmt.Errorf("invalid recipe name %q", m.Recipe)
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(m.Recipe), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// History returns the manifests of recipe, or of every recipe if it is
+// empty, oldest first.
+func (s Store) History(recipe string) ([]Manifest, error) {
+	var paths []string
+	if recipe != "" {
+		paths = []string{s.path(recipe)}
+	} else {
+		var err error
+		paths, err = filepath.Glob(filepath.Join(s.Dir, "*.jsonl"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out []Manifest
+	for _, path := range paths {
+		ms, err := readManifests(path)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ms...)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out, nil
+}
+
+// Latest returns the most recent manifest of each recipe in the store.
+func (s Store) Latest() ([]Manifest, error) {
+	all, err := s.History("")
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]Manifest{}
+	for _, m := range all {
+		latest[m.Recipe] = m
+	}
+	out := make([]Manifest, 0, len(latest))
+	for _, m := range latest {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Recipe < out[j].Recipe })
+	return out, nil
+}
+
+// LastSuccess returns the latest successful build of recipe at version for
+// arch, if there is one.
+func (s Store) LastSuccess(recipe, version, arch string) (Manifest, bool, error) {
+	history, err := s.History(recipe)
+	if err != nil {
+		return Manifest{}, false, err
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		m := history[i]
+		if m.Status == StatusSucceeded && m.Version == version && m.Arch == arch {
+			return m, true, nil
+		}
+	}
+	return Manifest{}, false, nil
+}
+
+func readManifests(path string) ([]Manifest, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Manifest
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var m Manifest
+		if err := json.Unmarshal(line, &m); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		out = append(out, m)
+	}
+	return out, sc.Err()
+}
+
+// PreviousVersion returns the latest successful build of recipe at a
+// version other than version, if there is one.
+func (s Store) PreviousVersion(recipe, version string) (Manifest, bool, error) {
+	history, err := s.History(recipe)
+	if err != nil {
+		return Manifest{}, false, err
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		m := history[i]
+		if m.Status == StatusSucceeded && m.Version != version && m.Tag != "" {
+			return m, true, nil
+		}
+	}
+	return Manifest{}, false, nil
+}
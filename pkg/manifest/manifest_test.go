@@ -0,0 +1,133 @@
+package manifest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+func TestStoreHistory(t *testing.T) {
+	s := Store{Dir: t.TempDir()}
+	base := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, m := range []Manifest{
+		{Recipe: "fsl", Version: "6.0.7", Status: StatusFailed, Started: base.Add(2 * time.Hour)},
+		{Recipe: "ants", Version: "2.5.0", Status: StatusSucceeded, Started: base.Add(time.Hour)},
+		{Recipe: "fsl", Version: "6.0.6", Status: StatusSucceeded, Started: base, Duration: time.Minute},
+	} {
+		if err := s.Add(m); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	fsl, err := s.History("fsl")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(fsl) != 2 || fsl[0].Version != "6.0.6" || fsl[1].Status != StatusFailed || fsl[0].Duration != time.Minute {
+		t.Fatalf("fsl history = %+v", fsl)
+	}
+
+	all, err := s.History("")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(all) != 3 || all[1].Recipe != "ants" {
+		t.Fatalf("full history = %+v", all)
+	}
+
+	latest, err := s.Latest()
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if len(latest) != 2 || latest[1].Recipe != "fsl" || latest[1].Version != "6.0.7" {
+		t.Fatalf("latest = %+v", latest)
+	}
+
+	if none, err := s.History("missing"); err != nil || len(none) != 0 {
+		t.Fatalf("History(missing) = %v, %v", none, err)
+	}
+	if err := s.Add(Manifest{Recipe: "../x"}); err == nil {
+		t.Fatalf("expected an error for a recipe name with a path separator")
+	}
+}
+
+func TestDirectiveHashesAreStable(t *testing.T) {
+	build := func(env map[string]string) *ir.Definition {
+		def, err := ir.New().
+			AddFromImage("from", "ubuntu:24.04").
+			AddEnvironment("env", env).
+			AddRunCommand("run", "make").
+			Compile()
+		if err != nil {
+			t.Fatalf("Compile: %v", err)
+		}
+		return def
+	}
+	a := DirectiveHashes(build(map[string]string{"A": "1", "B": "2"}))
+	b := DirectiveHashes(build(map[string]string{"B": "2", "A": "1"}))
+	c := DirectiveHashes(build(map[string]string{"A": "1", "B": "3"}))
+	if len(a) != 3 {
+		t.Fatalf("expected 3 hashes, got %v", a)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("hash %d differs for equal directives: %v vs %v", i, a, b)
+		}
+	}
+	if a[1] == c[1] || a[0] != c[0] || a[2] != c[2] {
+		t.Fatalf("only the changed directive should hash differently: %v vs %v", a, c)
+	}
+}
+
+func TestTrends(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var history []Manifest
+	add := func(recipe string, status Status, step string, d time.Duration) {
+		history = append(history, Manifest{
+			Recipe:     recipe,
+			Status:     status,
+			FailedStep: step,
+			Duration:   d,
+			Started:    base.Add(time.Duration(len(history)) * time.Hour),
+		})
+	}
+	add("flaky", StatusSucceeded, "", time.Minute)
+	add("flaky", StatusFailed, "RUN make", 3*time.Minute)
+	add("flaky", StatusSucceeded, "", 0)
+	add("flaky", StatusFailed, "RUN test", time.Minute)
+	add("flaky", StatusFailed, "RUN make", time.Minute)
+	add("fixed", StatusFailed, "RUN make", time.Minute)
+	add("fixed", StatusSucceeded, "", time.Minute)
+	add("fixed", StatusSucceeded, "", time.Minute)
+
+	trends := Trends(history, 10)
+	if len(trends) != 2 || trends[0].Recipe != "fixed" || trends[1].Recipe != "flaky" {
+		t.Fatalf("unexpected trends: %+v", trends)
+	}
+	fixed, flaky := trends[0], trends[1]
+	if fixed.Flaky || fixed.Builds != 3 || fixed.Successes != 2 {
+		t.Fatalf("fixed = %+v", fixed)
+	}
+	if !flaky.Flaky {
+		t.Fatalf("expected flaky to be flagged: %+v", flaky)
+	}
+	if flaky.SuccessRate != 0.4 || flaky.Failures != 3 {
+		t.Fatalf("flaky counts = %+v", flaky)
+	}
+	if flaky.MeanDuration != 90*time.Second {
+		t.Fatalf("mean duration = %v, want 1m30s (unknown durations are skipped)", flaky.MeanDuration)
+	}
+	if flaky.FailingStep != "RUN make" || flaky.FailingStepCount != 2 {
+		t.Fatalf("failing step = %q x%d", flaky.FailingStep, flaky.FailingStepCount)
+	}
+	if !flaky.Last.Equal(base.Add(4 * time.Hour)) {
+		t.Fatalf("last = %v", flaky.Last)
+	}
+
+	// With a window of two, only the final failure and its predecessor
+	// count, which is no longer flaky.
+	if windowed := Trends(history, 2); windowed[1].Flaky || len(windowed[1].Recent) != 2 {
+		t.Fatalf("windowed = %+v", windowed[1])
+	}
+}
@@ -0,0 +1,103 @@
+package manifest
+
+import (
+	"sort"
+	"time"
+)
+
+// Trend aggregates the build history of one recipe.
+type Trend struct {
+	Recipe    string `json:"recipe"`
+	Builds    int    `json:"builds"`
+	Successes int    `json:"successes"`
+	Failures  int    `json:"failures"`
+	// SuccessRate is Successes over Builds, between 0 and 1.
+	SuccessRate float64 `json:"success_rate"`
+	// MeanDuration averages the builds whose duration is known.
+	MeanDuration time.Duration `json:"mean_duration_ns"`
+	// FailingStep is the step that failed most often, with its count.
+	FailingStep      string `json:"failing_step,omitempty"`
+	FailingStepCount int    `json:"failing_step_count,omitempty"`
+	// Recent holds the statuses of the last builds, oldest first.
+	Recent []Status `json:"recent"`
+	// Flaky is set when the recent builds switch between success and
+	// failure more than once, rather than just regressing or being fixed.
+	Flaky bool      `json:"flaky"`
+	Last  time.Time `json:"last"`
+}
+
+// flakyFlips is the number of success/failure switches within the recent
+// window from which a recipe is considered flaky.
+const flakyFlips = 2
+
+// Trends aggregates history, which must be ordered oldest first, per recipe.
+// Only the last window builds of each recipe count towards Recent and Flaky.
+func Trends(history []Manifest, window int) []Trend {
+	byRecipe := map[string]*Trend{}
+	failingSteps := map[string]map[string]int{}
+	total := map[string]time.Duration{}
+	timed := map[string]int{}
+	for _, m := range history {
+		t := byRecipe[m.Recipe]
+		if t == nil {
+			t = &Trend{Recipe: m.Recipe}
+			byRecipe[m.Recipe] = t
+			failingSteps[m.Recipe] = map[string]int{}
+		}
+		t.Builds++
+		switch m.Status {
+		case StatusSucceeded:
+			t.Successes++
+		case StatusFailed:
+			t.Failures++
+			if m.FailedStep != "" {
+				failingSteps[m.Recipe][m.FailedStep]++
+			}
+		}
+		if m.Duration > 0 {
+			total[m.Recipe] += m.Duration
+			timed[m.Recipe]++
+		}
+		t.Recent = append(t.Recent, m.Status)
+		if len(t.Recent) > window {
+			t.Recent = t.Recent[1:]
+		}
+		if m.Started.After(t.Last) {
+			t.Last = m.Started
+		}
+	}
+
+	out := make([]Trend, 0, len(byRecipe))
+	for name, t := range byRecipe {
+		t.SuccessRate = float64(t.Successes) / float64(t.Builds)
+		if timed[name] > 0 {
+			t.MeanDuration = total[name] / time.Duration(timed[name])
+		}
+		for step, n := range failingSteps[name] {
+			if n > t.FailingStepCount || (n == t.FailingStepCount && step < t.FailingStep) {
+				t.FailingStep, t.FailingStepCount = step, n
+			}
+		}
+		t.Flaky = flips(t.Recent) >= flakyFlips
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Recipe < out[j].Recipe })
+	return out
+}
+
+// flips counts the switches between success and failure in statuses,
+// ignoring builds with any other status.
+func flips(statuses []Status) int {
+	n := 0
+	var last Status
+	for _, s := range statuses {
+		if s != StatusSucceeded && s != StatusFailed {
+			continue
+		}
+		if last != "" && s != last {
+			n++
+		}
+		last = s
+	}
+	return n
+}
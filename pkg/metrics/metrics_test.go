@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	builds := r.Counter("builds_total", "Builds by status.", "recipe", "status")
+	steps := r.Histogram("step_seconds", "Step durations.", []float64{1, 10}, "kind")
+	r.CounterFunc("bytes_total", "Bytes read.", func() float64 { return 2048 })
+
+	builds.Inc("fsl", "success")
+	builds.Inc("fsl", "success")
+	builds.Inc(`a"b`, "failed")
+	steps.Observe(0.5, "run")
+	steps.Observe(5, "run")
+	steps.Observe(50, "run")
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := strings.Join([]string{
+		"# HELP builds_total Builds by status.",
+		"# TYPE builds_total counter",
+		`builds_total{recipe="a\"b",status="failed"} 1`,
+		`builds_total{recipe="fsl",status="success"} 2`,
+		"# HELP step_seconds Step durations.",
+		"# TYPE step_seconds histogram",
+		`step_seconds_bucket{kind="run",le="1"} 1`,
+		`step_seconds_bucket{kind="run",le="10"} 2`,
+		`step_seconds_bucket{kind="run",le="+Inf"} 3`,
+		`step_seconds_sum{kind="run"} 55.5`,
+		`step_seconds_count{kind="run"} 3`,
+		"# HELP bytes_total Bytes read.",
+		"# TYPE bytes_total counter",
+		"bytes_total 2048",
+		"",
+	}, "\n")
+	if got := b.String(); got != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPush(t *testing.T) {
+	var gotPath, gotBody, gotMethod string
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody, gotMethod = r.URL.Path, string(body), r.Method
+	}))
+	defer gw.Close()
+
+	r := NewRegistry()
+	r.Counter("builds_total", "Builds.").Inc()
+	if err := r.Push(context.Background(), gw.URL+"/", "builder"); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if gotMethod != http.MethodPut || gotPath != "/metrics/job/builder" || !strings.Contains(gotBody, "builds_total 1\n") {
+		t.Fatalf("gateway got %s %s:\n%s", gotMethod, gotPath, gotBody)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	if err := r.Push(context.Background(), failing.URL, "builder"); err == nil || !strings.Contains(err.Error(), "bad metrics") {
+		t.Fatalf("expected the gateway's error, got %v", err)
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("c_total", "C.", "a")
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic for missing label values")
+		}
+	}()
+	c.Inc()
+}
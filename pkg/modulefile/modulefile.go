@@ -0,0 +1,98 @@
+// Package modulefile renders environment modulefiles that expose a
+// container's deployed binaries to HPC users.
+package modulefile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Module describes one container version as an environment module.
+type Module struct {
+	Name    string
+	Version string
+	// Description is shown by `module help`; usually the recipe readme.
+	Description string
+	// Dir is the directory holding the container's wrapper scripts; it is
+	// prepended to PATH.
+	Dir string
+	// Bins and Path are the recipe's rendered deploy.bins and deploy.path.
+	Bins []string
+	Path []string
+	// Categories become the module's keywords.
+	Categories []string
+	// Apps are the recipe's GUI applications.
+	Apps []App
+}
+
+// App is a GUI application launched from the container.
+type App struct {
+	Name string
+	Exec string
+}
+
+// whatis returns the one-line descriptions shown by `module whatis`.
+func (m Module) whatis() []string {
+	lines := []string{"Name: " + m.Name, "Version: " + m.Version}
+	if len(m.Categories) > 0 {
+		lines = append(lines, "Keywords: "+strings.Join(m.Categories, ", "))
+	}
+	if len(m.Bins) > 0 {
+		lines = append(lines, "Binaries: "+strings.Join(m.Bins, ", "))
+	}
+	if len(m.Path) > 0 {
+		lines = append(lines, "Deployed paths: "+strings.Join(m.Path, ", "))
+	}
+	for _, app := range m.Apps {
+		lines = append(lines, fmt.Sprintf("GUI app: %s (%s)", app.Name, app.Exec))
+	}
+	return lines
+}
+
+// Lua renders the module as an Lmod modulefile.
+func (m Module) Lua() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- -*- lua -*-\n-- %s/%s, generated by builder\n\n", m.Name, m.Version)
+	if desc := strings.TrimSpace(m.Description); desc != "" {
+		fmt.Fprintf(&b, "help(%s)\n\n", luaLongString(desc))
+	}
+	for _, line := range m.whatis() {
+		fmt.Fprintf(&b, "whatis(%s)\n", strconv.Quote(line))
+	}
+	fmt.Fprintf(&b, "\nprepend_path(\"PATH\", %s)\n", strconv.Quote(m.Dir))
+	return b.String()
+}
+
+// TCL renders the module as an Environment Modules (TCL) modulefile.
+func (m Module) TCL() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%%Module1.0\n## %s/%s, generated by builder\n\n", m.Name, m.Version)
+	if desc := strings.TrimSpace(m.Description); desc != "" {
+		fmt.Fprintf(&b, "proc ModulesHelp { } {\n    puts stderr %s\n}\n\n", tclQuote(desc))
+	}
+	for _, line := range m.whatis() {
+		fmt.Fprintf(&b, "module-whatis %s\n", tclQuote(line))
+	}
+	fmt.Fprintf(&b, "\nprepend-path PATH %s\n", tclQuote(m.Dir))
+	return b.String()
+}
+
+// luaLongString quotes s as a Lua long bracket string, using enough '='
+// signs that s cannot close it.
+func luaLongString(s string) string {
+	level := ""
+	for strings.Contains(s, "]"+level+"]") {
+		level += "="
+	}
+	return "[" + level + "[\n" + s + "\n]" + level + "]"
+}
+
+var tclEscaper = strings.NewReplacer(
+	`\`, `\\`, `"`, `\"`, `$`, `\$`, `[`, `\[`, `]`, `\]`,
+)
+
+// tclQuote quotes s as a TCL double-quoted word with no substitutions.
+func tclQuote(s string) string {
+	return `"` + tclEscaper.Replace(s) + `"`
+}
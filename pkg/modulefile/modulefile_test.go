@@ -0,0 +1,52 @@
+package modulefile
+
+import (
+	"strings"
+	"testing"
+)
+
+var testModule = Module{
+	Name:        "fsl",
+	Version:     "6.0.7",
+	Description: "FSL ]] tools [$HOME]",
+	Dir:         "/cvmfs/example/containers/fsl_6.0.7",
+	Bins:        []string{"bet", "flirt"},
+	Path:        []string{"/opt/fsl/bin"},
+	Categories:  []string{"functional imaging"},
+	Apps:        []App{{Name: "fsleyes", Exec: "fsleyes"}},
+}
+
+func TestLua(t *testing.T) {
+	got := testModule.Lua()
+	for _, want := range []string{
+		"-- fsl/6.0.7, generated by builder\n",
+		"help([=[\nFSL ]] tools [$HOME]\n]=])\n",
+		`whatis("Name: fsl")`,
+		`whatis("Version: 6.0.7")`,
+		`whatis("Keywords: functional imaging")`,
+		`whatis("Binaries: bet, flirt")`,
+		`whatis("Deployed paths: /opt/fsl/bin")`,
+		`whatis("GUI app: fsleyes (fsleyes)")`,
+		`prepend_path("PATH", "/cvmfs/example/containers/fsl_6.0.7")`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("modulefile missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestTCL(t *testing.T) {
+	got := testModule.TCL()
+	for _, want := range []string{
+		"#%Module1.0\n## fsl/6.0.7, generated by builder\n",
+		"proc ModulesHelp { } {\n    puts stderr \"FSL \\]\\] tools \\[\\$HOME\\]\"\n}\n",
+		`module-whatis "Name: fsl"`,
+		`module-whatis "Binaries: bet, flirt"`,
+		`module-whatis "GUI app: fsleyes (fsleyes)"`,
+		`prepend-path PATH "/cvmfs/example/containers/fsl_6.0.7"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("modulefile missing %q:\n%s", want, got)
+		}
+	}
+}
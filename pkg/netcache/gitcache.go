@@ -0,0 +1,166 @@
+package netcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GitSource describes a git repository checkout.
+type GitSource struct {
+	URL string
+	// Ref is a branch or tag name. Ignored when Commit is set.
+	Ref string
+	// Commit pins the checkout to a full 40 character commit hash.
+	Commit string
+	// Depth limits the fetched history; 0 means a shallow fetch of depth 1.
+	Depth int
+	// Submodules enables a recursive submodule checkout.
+	Submodules bool
+}
+
+// GitCache keeps shallow checkouts of git repositories keyed by URL and commit.
+type GitCache struct {
+	Dir string
+	// Git is the git binary to invoke. Defaults to "git".
+	Git string
+}
+
+// NewGit returns a new GitCache rooted at dir.
+func NewGit(dir string) *GitCache {
+	return &GitCache{Dir: dir, Git: "git"}
+}
+
+var fullCommitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Checkout resolves the source to a commit and returns the path to a checked-out
+// working tree for it. Checkouts for a given URL and commit are reused.
+// Returns (path, fromCache, error).
+func (c *GitCache) Checkout(ctx context.Context, src GitSource) (string, bool, error) {
+	if src.URL == "" {
+		return "", false, fmt.Errorf("git source has no url")
+	}
+
+	commit := strings.ToLower(src.Commit)
+	if commit == "" || !fullCommitPattern.MatchString(commit) {
+		resolved, err := c.Resolve(ctx, src)
+		if err != nil {
+			return "", false, err
+		}
+		commit = resolved
+	}
+
+	key := hash(src.URL)[:16] + "-" + commit
+	if src.Submodules {
+		key += "-sub"
+	}
+	dst := filepath.Join(c.Dir, key)
+	unlock, err := lockKey(ctx, dst+".lock")
+	if err != nil {
+		return "", false, err
+	}
+	defer unlock()
+	if st, err := os.Stat(filepath.Join(dst, ".git")); err == nil && st.IsDir() {
+		markUsed(dst)
+		return dst, true, nil
+	}
+
+	tmp, err := os.MkdirTemp(c.Dir, key+".tmp-")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.RemoveAll(tmp)
+
+	depth := src.Depth
+	if depth <= 0 {
+		depth = 1
+	}
+	steps := [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", src.URL},
+		{"fetch", "--quiet", "--depth", strconv.Itoa(depth), "origin", commit},
+		{"checkout", "--quiet", "--detach", "FETCH_HEAD"},
+	}
+	if src.Submodules {
+		steps = append(steps, []string{"submodule", "update", "--quiet", "--init", "--recursive", "--depth", strconv.Itoa(depth)})
+	}
+	for _, args := range steps {
+		if _, err := c.run(ctx, tmp, args...); err != nil {
+			return "", false, err
+		}
+	}
+
+	head, err := c.run(ctx, tmp, "rev-parse", "HEAD")
+	if err != nil {
+		return "", false, err
+	}
+	if head != commit {
+		return "", false, fmt.Errorf("git checkout of %s resolved to %s, expected %s", src.URL, head, commit)
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		// Another process may have populated the same checkout concurrently.
+		if st, statErr := os.Stat(filepath.Join(dst, ".git")); statErr == nil && st.IsDir() {
+			return dst, true, nil
+		}
+		return "", false, err
+	}
+	return dst, false, nil
+}
+
+// Resolve maps the ref of src to a full commit hash using ls-remote,
+// without fetching any objects.
+func (c *GitCache) Resolve(ctx context.Context, src GitSource) (string, error) {
+	if src.Commit != "" {
+		return "", fmt.Errorf("git source %s: commit %q must be a full 40 character hash", src.URL, src.Commit)
+	}
+	ref := src.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	out, err := c.run(ctx, "", "ls-remote", src.URL, ref, ref+"^{}")
+	if err != nil {
+		return "", err
+	}
+	var commit string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		// Prefer the peeled commit of annotated tags.
+		if strings.HasSuffix(fields[1], "^{}") || commit == "" {
+			commit = fields[0]
+		}
+	}
+	if commit == "" {
+		return "", fmt.Errorf("git ref %q not found in %s", ref, src.URL)
+	}
+	return commit, nil
+}
+
+func (c *GitCache) run(ctx context.Context, dir string, args ...string) (string, error) {
+	bin := c.Git
+	if bin == "" {
+		bin = "git"
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if verboseEnabled() {
+		fmt.Fprintf(os.Stderr, "[verbose] git %s\n", strings.Join(args, " "))
+	}
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
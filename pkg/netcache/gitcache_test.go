@@ -0,0 +1,75 @@
+package netcache
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestGitCacheCheckoutPinsCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	gitCmd(t, repo, "init", "--quiet", "-b", "main")
+	gitCmd(t, repo, "config", "uploadpack.allowAnySHA1InWant", "true")
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gitCmd(t, repo, "add", ".")
+	gitCmd(t, repo, "commit", "--quiet", "-m", "one")
+	first := gitCmd(t, repo, "rev-parse", "HEAD")
+	if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte("two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gitCmd(t, repo, "commit", "--quiet", "-am", "two")
+
+	c := NewGit(t.TempDir())
+	url := "file://" + repo
+
+	path, fromCache, err := c.Checkout(context.Background(), GitSource{URL: url, Commit: first})
+	if err != nil {
+		t.Fatalf("checkout: %v", err)
+	}
+	if fromCache {
+		t.Fatalf("first checkout should not come from cache")
+	}
+	if b, _ := os.ReadFile(filepath.Join(path, "file.txt")); string(b) != "one" {
+		t.Fatalf("pinned checkout has contents %q, want %q", b, "one")
+	}
+
+	again, fromCache, err := c.Checkout(context.Background(), GitSource{URL: url, Commit: first})
+	if err != nil {
+		t.Fatalf("second checkout: %v", err)
+	}
+	if !fromCache || again != path {
+		t.Fatalf("expected cached checkout at %s, got %s (fromCache=%v)", path, again, fromCache)
+	}
+
+	head, _, err := c.Checkout(context.Background(), GitSource{URL: url, Ref: "main"})
+	if err != nil {
+		t.Fatalf("ref checkout: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(head, "file.txt")); string(b) != "two" {
+		t.Fatalf("ref checkout has contents %q, want %q", b, "two")
+	}
+}
@@ -1,8 +1,10 @@
 package netcache
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -21,12 +23,14 @@ type Cache struct {
 	Client *http.Client
 }
 
-// New returns a new Cache with a reasonable default HTTP client.
+// New returns a new Cache with a reasonable default HTTP client, which also
+// fetches s3:// and gs:// URLs (see newTransport).
 func New(dir string) *Cache {
 	return &Cache{
 		Dir: dir,
 		Client: &http.Client{
-			Timeout: 30 * time.Minute, // long timeout for large artifacts
+			Timeout:   30 * time.Minute, // long timeout for large artifacts
+			Transport: newTransport(),
 		},
 	}
 }
@@ -42,10 +46,16 @@ type meta struct {
 }
 
 // Get fetches the URL into the cache and returns a local file path.
-// If the cache is valid, it is reused without downloading.
+// If the cache is valid, it is reused without downloading. Concurrent
+// calls for the same URL, in this or other processes, wait for each other.
 // Returns (path, fromCache, error).
 func (c *Cache) Get(ctx context.Context, url string) (string, bool, error) {
 	key := hash(url)
+	unlock, err := lockKey(ctx, filepath.Join(c.Dir, key+".lock"))
+	if err != nil {
+		return "", false, err
+	}
+	defer unlock()
 	mpath := filepath.Join(c.Dir, key+".json")
 	var m meta
 	var haveMeta bool
@@ -75,148 +85,241 @@ func (c *Cache) Get(ctx context.Context, url string) (string, bool, error) {
 		if err == nil {
 			defer resp.Body.Close()
 			if resp.StatusCode == http.StatusNotModified {
+				stats.hits.Add(1)
+				markUsed(mpath)
 				return filepath.Join(c.Dir, m.DataFile), true, nil
 			}
 			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 				// Update cache with new body
-				dataFile := key + ".data"
-				path := filepath.Join(c.Dir, dataFile)
-				if verboseEnabled() {
-					if err := streamToFileWithProgress(resp.Body, path, 0o644, resp.ContentLength, contentFilename(url, resp)); err != nil {
-						return "", false, err
-					}
-				} else {
-					if err := streamToFile(resp.Body, path, 0o644); err != nil {
-						return "", false, err
-					}
-				}
-				nm := meta{
-					URL:          url,
-					ETag:         resp.Header.Get("ETag"),
-					LastModified: resp.Header.Get("Last-Modified"),
-					Filename:     contentFilename(url, resp),
-					DataFile:     dataFile,
+				path := filepath.Join(c.Dir, key+".data")
+				nm, err := c.receive(resp, url, path, 0)
+				if err != nil {
+					return "", false, err
 				}
 				if err := writeMeta(mpath, nm); err != nil {
 					return "", false, err
 				}
+				stats.misses.Add(1)
 				return path, false, nil
 			}
 			// Fall through on non-success codes
 		}
 		// If conditional request fails (network or server), reuse cached file best-effort
 		if p := filepath.Join(c.Dir, m.DataFile); fileExists(p) {
+			stats.hits.Add(1)
+			markUsed(mpath)
 			return p, true, nil
 		}
 		// Else continue to full fetch below
 	}
 
-	// Full fetch with simple retry/backoff on network errors or 5xx
+	// Full fetch with simple retry/backoff on network errors or 5xx. Each
+	// attempt resumes what the previous ones downloaded.
+	path := filepath.Join(c.Dir, key+".data")
 	var lastErr error
 	for attempt := 0; attempt < 3; attempt++ {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		if err != nil {
-			return "", false, err
-		}
-		resp, err := c.Client.Do(req)
-		if err != nil {
-			lastErr = err
-		} else {
-			func() {
-				defer resp.Body.Close()
-				if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-					dataFile := key + ".data"
-					path := filepath.Join(c.Dir, dataFile)
-					var err error
-					if verboseEnabled() {
-						err = streamToFileWithProgress(resp.Body, path, 0o644, resp.ContentLength, contentFilename(url, resp))
-					} else {
-						err = streamToFile(resp.Body, path, 0o644)
-					}
-					if err != nil {
-						lastErr = err
-						return
-					}
-					nm := meta{
-						URL:          url,
-						ETag:         resp.Header.Get("ETag"),
-						LastModified: resp.Header.Get("Last-Modified"),
-						Filename:     contentFilename(url, resp),
-						DataFile:     dataFile,
-					}
-					if err := writeMeta(mpath, nm); err != nil {
-						lastErr = err
-						return
-					}
-					lastErr = nil
-					// success
-				} else {
-					lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
-				}
-			}()
+		nm, err := c.download(ctx, url, path)
+		if err == nil {
+			if err := writeMeta(mpath, nm); err != nil {
+				return "", false, err
+			}
+			stats.misses.Add(1)
+			return path, false, nil
 		}
-		if lastErr == nil {
-			// Success; return cached path derived from meta
-			dataFile := key + ".data"
-			return filepath.Join(c.Dir, dataFile), false, nil
+		lastErr = err
+		if ctx.Err() != nil {
+			break
 		}
 		// Backoff before retrying
-		time.Sleep(time.Duration(1<<attempt) * 2 * time.Second)
+		time.Sleep(time.Duration(1<<attempt) * retryBackoff)
 	}
 	return "", false, lastErr
 }
 
-func streamToFile(r io.Reader, dst string, mode os.FileMode) error {
-	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-		return err
+// retryBackoff is the delay before the first retry of a failed download;
+// later retries wait twice as long as the previous one.
+var retryBackoff = 2 * time.Second
+
+// download fetches url to dst. It resumes a partial download left by an
+// earlier attempt (dst.partial) with a Range request, which If-Range makes
+// the server answer with the whole file instead when it has changed since.
+func (c *Cache) download(ctx context.Context, url, dst string) (meta, error) {
+	partial := dst + ".partial"
+	var offset int64
+	var pm meta
+	if b, err := os.ReadFile(partial + ".json"); err == nil && json.Unmarshal(b, &pm) == nil && pm.URL == url {
+		if st, err := os.Stat(partial); err == nil {
+			offset = st.Size()
+		}
+	}
+	validator := pm.LastModified
+	if pm.ETag != "" && !strings.HasPrefix(pm.ETag, "W/") {
+		validator = pm.ETag
 	}
-	tmp := dst + ".tmp"
-	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return meta{}, err
 	}
-	if _, err := io.Copy(f, r); err != nil {
-		_ = f.Close()
-		_ = os.Remove(tmp)
-		return err
+	if offset > 0 && validator != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+		if verboseEnabled() {
+			fmt.Fprintf(os.Stderr, "[verbose] Resuming %s at %s\n", url, humanBytes(offset))
+		}
+	} else {
+		offset = 0
 	}
-	if err := f.Close(); err != nil {
-		_ = os.Remove(tmp)
-		return err
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return meta{}, err
 	}
-	return os.Rename(tmp, dst)
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != offset {
+			os.Remove(partial)
+			return meta{}, fmt.Errorf("resuming %s: server sent range %q, want bytes %d-", url, resp.Header.Get("Content-Range"), offset)
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		os.Remove(partial)
+		return meta{}, fmt.Errorf("resuming %s: HTTP %d", url, resp.StatusCode)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		offset = 0
+	default:
+		return meta{}, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return c.receive(resp, url, dst, offset)
 }
 
-// streamToFileWithProgress writes r to dst while printing a status line with
-// downloaded bytes, speed, and ETA (when total >= 0). Progress is printed to stderr.
-func streamToFileWithProgress(r io.Reader, dst string, mode os.FileMode, total int64, label string) error {
+// receive writes the body of resp to dst through dst.partial, appending
+// to its first offset bytes when resp answers a Range request. The partial
+// file is kept when the transfer breaks off, for download to resume. A
+// complete file is checked against the length the server announced and,
+// when the server sends one, its SHA-256 digest (Repr-Digest or Digest)
+// before it is renamed to dst.
+func (c *Cache) receive(resp *http.Response, url, dst string, offset int64) (meta, error) {
+	partial := dst + ".partial"
+	nm := meta{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Filename:     contentFilename(url, resp),
+		DataFile:     filepath.Base(dst),
+	}
+	total := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		_, total, _ = parseContentRange(resp.Header.Get("Content-Range"))
+	}
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
-		return err
+		return meta{}, err
+	}
+	// Record the validator first, so an interrupted transfer can resume.
+	if err := writeMeta(partial+".json", nm); err != nil {
+		return meta{}, err
 	}
-	tmp := dst + ".tmp"
-	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(partial, flags, 0o644)
 	if err != nil {
-		return err
+		return meta{}, err
 	}
-	pr := &progressReporter{total: total, label: label, start: time.Now(), lastTick: time.Now()}
-	reader := io.TeeReader(r, pr)
-	_, copyErr := io.Copy(f, reader)
+	var r io.Reader = resp.Body
+	var pr *progressReporter
+	if verboseEnabled() {
+		pr = &progressReporter{total: total, read: offset, resumed: offset, label: nm.Filename, start: time.Now(), lastTick: time.Now()}
+		r = io.TeeReader(r, pr)
+	}
+	n, copyErr := io.Copy(f, r)
+	stats.bytes.Add(n)
 	closeErr := f.Close()
-	pr.finish(copyErr == nil && closeErr == nil)
+	if pr != nil {
+		pr.finish(copyErr == nil && closeErr == nil)
+	}
 	if copyErr != nil {
-		_ = os.Remove(tmp)
-		return copyErr
+		return meta{}, fmt.Errorf("downloading %s (%s received, will resume): %w", url, humanBytes(offset+n), copyErr)
 	}
 	if closeErr != nil {
-		_ = os.Remove(tmp)
-		return closeErr
+		return meta{}, closeErr
+	}
+
+	if size := offset + n; total >= 0 && size != total {
+		if size > total {
+			os.Remove(partial)
+		}
+		return meta{}, fmt.Errorf("downloading %s: got %d bytes, want %d", url, size, total)
+	}
+	if want := reprSHA256(resp.Header); want != nil {
+		got, err := fileSHA256(partial)
+		if err != nil {
+			return meta{}, err
+		}
+		if !bytes.Equal(got, want) {
+			os.Remove(partial)
+			return meta{}, fmt.Errorf("downloading %s: sha-256 digest mismatch", url)
+		}
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		return meta{}, err
+	}
+	os.Remove(partial + ".json")
+	return nm, nil
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range, where
+// total is -1 when the server sent "*".
+func parseContentRange(v string) (start, total int64, ok bool) {
+	var end int64
+	if _, err := fmt.Sscanf(v, "bytes %d-%d/%d", &start, &end, &total); err == nil {
+		return start, total, true
+	}
+	if _, err := fmt.Sscanf(v, "bytes %d-%d/*", &start, &end); err == nil {
+		return start, -1, true
+	}
+	return 0, -1, false
+}
+
+// reprSHA256 returns the SHA-256 digest of the whole file announced in a
+// Repr-Digest (RFC 9530) or Digest (RFC 3230) header, or nil.
+func reprSHA256(h http.Header) []byte {
+	for _, field := range strings.Split(h.Get("Repr-Digest"), ",") {
+		if alg, v, ok := strings.Cut(strings.TrimSpace(field), "="); ok && strings.EqualFold(alg, "sha-256") {
+			if b, err := base64.StdEncoding.DecodeString(strings.Trim(v, ":")); err == nil {
+				return b
+			}
+		}
 	}
-	return os.Rename(tmp, dst)
+	for _, field := range strings.Split(h.Get("Digest"), ",") {
+		if alg, v, ok := strings.Cut(strings.TrimSpace(field), "="); ok && strings.EqualFold(alg, "sha-256") {
+			if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
 }
 
 type progressReporter struct {
-	total    int64
-	read     int64
+	total int64
+	read  int64
+	// resumed is the number of bytes downloaded before, which do not count
+	// towards the speed.
+	resumed  int64
 	label    string
 	start    time.Time
 	lastTick time.Time
@@ -266,7 +369,7 @@ func (p *progressReporter) print(now time.Time) {
 	if elapsed <= 0 {
 		elapsed = 0.001
 	}
-	speed := float64(p.read) / elapsed // bytes/sec
+	speed := float64(p.read-p.resumed) / elapsed // bytes/sec
 	var etaStr string
 	if p.total > 0 && speed > 0 {
 		remain := float64(p.total - p.read)
@@ -303,13 +406,36 @@ func writeMeta(path string, m meta) error {
 	if err != nil {
 		return err
 	}
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+	f, tmp, err := createTemp(path, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
 		return err
 	}
 	return os.Rename(tmp, path)
 }
 
+// createTemp creates a uniquely named file next to dst, to be renamed over
+// it once complete, so that readers never see a partial file.
+func createTemp(dst string, mode os.FileMode) (*os.File, string, error) {
+	f, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	return f, f.Name(), nil
+}
+
 func hash(s string) string {
 	sum := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(sum[:])
@@ -0,0 +1,78 @@
+package netcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	retryBackoff = time.Millisecond
+}
+
+// flakyServer serves body with ServeContent, which honours Range and
+// If-Range, but breaks off the first response halfway.
+func flakyServer(t *testing.T, body []byte, digest []byte, ranges *[]string) *httptest.Server {
+	t.Helper()
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest)+":")
+		if requests.Add(1) == 1 {
+			w.Header().Set("Content-Length", "1000")
+			w.Write(body[:400])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCacheGetResumesInterruptedDownload(t *testing.T) {
+	body := []byte(strings.Repeat("0123456789", 100))
+	sum := sha256.Sum256(body)
+	var ranges []string
+	srv := flakyServer(t, body, sum[:], &ranges)
+
+	dir := t.TempDir()
+	path, _, err := New(dir).Get(context.Background(), srv.URL+"/fsl.tar.gz")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, body) {
+		t.Fatalf("downloaded %d bytes, want the %d byte body", len(b), len(body))
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes=400-" {
+		t.Fatalf("requests had ranges %q, want a full request and bytes=400-", ranges)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "*.partial*")); len(leftovers) != 0 {
+		t.Fatalf("partial files left behind: %v", leftovers)
+	}
+}
+
+func TestCacheGetRejectsDigestMismatch(t *testing.T) {
+	body := []byte(strings.Repeat("x", 1000))
+	var ranges []string
+	srv := flakyServer(t, body, make([]byte, sha256.Size), &ranges)
+
+	dir := t.TempDir()
+	_, _, err := New(dir).Get(context.Background(), srv.URL+"/fsl.tar.gz")
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.data")); len(matches) != 0 {
+		t.Fatalf("corrupt download was cached: %v", matches)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package netcache
+
+import "os"
+
+// Without flock, entries are still finalized atomically but concurrent
+// processes may download the same entry twice.
+func tryLockFile(f *os.File) (bool, error) { return true, nil }
+
+func unlockFile(f *os.File) {}
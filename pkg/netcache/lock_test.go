@@ -0,0 +1,79 @@
+//go:build unix
+
+package netcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheGetConcurrentDownloadsOnce(t *testing.T) {
+	var downloads atomic.Int64
+	body := strings.Repeat("x", 1<<20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"1"`)
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// A cache per goroutine, as separate processes would have.
+			path, _, err := New(dir).Get(context.Background(), srv.URL+"/big")
+			if err == nil {
+				var b []byte
+				if b, err = os.ReadFile(path); err == nil && string(b) != body {
+					t.Errorf("read a partial file of %d bytes", len(b))
+				}
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Fatalf("downloaded %d times, want once", n)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp-*"))
+	if len(leftovers) != 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestLockKeyHonoursContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key.lock")
+	unlock, err := lockKey(context.Background(), path)
+	if err != nil {
+		t.Fatalf("lockKey: %v", err)
+	}
+	defer unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	if _, err := lockKey(ctx, path); err == nil {
+		t.Fatalf("expected the second lock to wait until the context expired")
+	}
+}
@@ -0,0 +1,545 @@
+package netcache
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newTransport returns the transport of the cache's HTTP client: the
+// default transport, which also serves s3://bucket/key and
+// gs://bucket/object URLs. Those are rewritten to the storage service's
+// HTTPS endpoint and authenticated with the credentials found in the
+// environment or, on cloud instances, the instance metadata service.
+// Without credentials the request is sent anonymously, which works for
+// public buckets.
+func newTransport() http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.RegisterProtocol("s3", &s3Transport{base: t})
+	t.RegisterProtocol("gs", &gcsTransport{base: t})
+	return t
+}
+
+// metadataTimeout bounds requests to instance metadata services, which are
+// unreachable off cloud instances.
+const metadataTimeout = 2 * time.Second
+
+func splitObjectURL(u *url.URL) (string, string, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("%s URL %q must name a bucket and an object, as %s://bucket/path", u.Scheme, u.Redacted(), u.Scheme)
+	}
+	return bucket, key, nil
+}
+
+// objectURL returns endpoint/bucket/key with the path escaped as the
+// storage services expect.
+func objectURL(endpoint, bucket, key string) (*url.URL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+	return u, nil
+}
+
+// escapePath percent-encodes everything in p but unreserved characters and
+// slashes, as SigV4 canonical URIs require.
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// forward sends a copy of req to u with its headers, which include the
+// cache's conditional request headers.
+func forward(base http.RoundTripper, req *http.Request, u *url.URL, authorize func(*http.Request)) (*http.Response, error) {
+	out, err := http.NewRequestWithContext(req.Context(), req.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Header {
+		out.Header[k] = append([]string(nil), v...)
+	}
+	if authorize != nil {
+		authorize(out)
+	}
+	return base.RoundTrip(out)
+}
+
+// s3Transport serves s3:// URLs.
+type s3Transport struct {
+	base http.RoundTripper
+
+	mu       sync.Mutex
+	resolved bool
+	creds    *awsCredentials
+	// regions records the region of buckets outside the configured one,
+	// learned from S3's redirects.
+	regions map[string]string
+}
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is zero for credentials that do not expire.
+	Expires time.Time
+}
+
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	bucket, key, err := splitObjectURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := t.credentials(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	region := t.region(bucket)
+	resp, err := t.send(req, bucket, key, region, creds)
+	if err != nil {
+		return nil, err
+	}
+	// A bucket in another region answers with a redirect naming it.
+	if r := resp.Header.Get("X-Amz-Bucket-Region"); r != "" && r != region && resp.StatusCode >= 300 && resp.StatusCode < 500 {
+		resp.Body.Close()
+		t.mu.Lock()
+		if t.regions == nil {
+			t.regions = map[string]string{}
+		}
+		t.regions[bucket] = r
+		t.mu.Unlock()
+		return t.send(req, bucket, key, r, creds)
+	}
+	return resp, nil
+}
+
+func (t *s3Transport) region(bucket string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if r, ok := t.regions[bucket]; ok {
+		return r
+	}
+	if r := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"); r != "" {
+		return r
+	}
+	return "us-east-1"
+}
+
+func (t *s3Transport) send(req *http.Request, bucket, key, region string, creds *awsCredentials) (*http.Response, error) {
+	// Custom endpoints (MinIO and the like) and bucket names with dots,
+	// which do not match S3's wildcard certificate, use path-style URLs.
+	var (
+		u   *url.URL
+		err error
+	)
+	if endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); endpoint != "" {
+		u, err = objectURL(endpoint, bucket, key)
+	} else if strings.Contains(bucket, ".") {
+		u, err = objectURL("https://s3."+region+".amazonaws.com", bucket, key)
+	} else {
+		u = &url.URL{Scheme: "https", Host: bucket + ".s3." + region + ".amazonaws.com", Path: "/" + key, RawPath: escapePath("/" + key)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = req.URL.RawQuery
+	var authorize func(*http.Request)
+	if creds != nil {
+		authorize = func(r *http.Request) { signV4(r, creds, region, "s3", time.Now().UTC()) }
+	}
+	return forward(t.base, req, u, authorize)
+}
+
+// credentials returns the AWS credentials to sign with, or nil to send
+// requests anonymously. They are resolved once and again when they expire.
+func (t *s3Transport) credentials(ctx context.Context) (*awsCredentials, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resolved && (t.creds == nil || t.creds.Expires.IsZero() || time.Until(t.creds.Expires) > 5*time.Minute) {
+		return t.creds, nil
+	}
+	creds, err := resolveAWSCredentials(ctx, t.base)
+	if err != nil {
+		return nil, err
+	}
+	t.resolved, t.creds = true, creds
+	return creds, nil
+}
+
+// resolveAWSCredentials reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN, falling back to the role of an EC2 instance.
+func resolveAWSCredentials(ctx context.Context, rt http.RoundTripper) (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, nil
+	}
+	endpoint := strings.TrimSuffix(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "/")
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+
+	// IMDSv2 session token; instances that only serve IMDSv1 refuse it.
+	header := http.Header{}
+	if token, err := metadataRequest(ctx, rt, http.MethodPut, endpoint+"/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"}}); err == nil {
+		header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+	}
+	roles, err := metadataRequest(ctx, rt, http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		// Not on EC2, or no instance role.
+		return nil, nil
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	b, err := metadataRequest(ctx, rt, http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/"+role, header)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials of instance role %q: %w", role, err)
+	}
+	var body struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("decoding credentials of instance role %q: %w", role, err)
+	}
+	return &awsCredentials{AccessKeyID: body.AccessKeyID, SecretAccessKey: body.SecretAccessKey, SessionToken: body.Token, Expires: body.Expiration}, nil
+}
+
+func metadataRequest(ctx context.Context, rt http.RoundTripper, method, url string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: HTTP %d", method, url, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to req,
+// signing the host, Range and x-amz-* headers. The payload is left
+// unsigned unless X-Amz-Content-Sha256 is already set.
+func signV4(req *http.Request, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") || lk == "range" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(q url.Values) string {
+	var parts []string
+	for k, vs := range q {
+		for _, v := range vs {
+			parts = append(parts, escapePath(k)+"="+strings.ReplaceAll(escapePath(v), "/", "%2F"))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// gcsTransport serves gs:// URLs through the Cloud Storage XML API.
+type gcsTransport struct {
+	base http.RoundTripper
+
+	mu       sync.Mutex
+	resolved bool
+	token    string
+	expires  time.Time
+}
+
+const gcsReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+func (t *gcsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	bucket, object, err := splitObjectURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := os.Getenv("STORAGE_EMULATOR_HOST")
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	} else if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := objectURL(endpoint, bucket, object)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = req.URL.RawQuery
+	token, err := t.accessToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	var authorize func(*http.Request)
+	if token != "" {
+		authorize = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	return forward(t.base, req, u, authorize)
+}
+
+// accessToken returns the OAuth token to authenticate with, or "" to send
+// requests anonymously.
+func (t *gcsTransport) accessToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resolved && (t.expires.IsZero() || time.Until(t.expires) > 5*time.Minute) {
+		return t.token, nil
+	}
+	token, expires, err := resolveGCSToken(ctx, t.base)
+	if err != nil {
+		return "", err
+	}
+	t.resolved, t.token, t.expires = true, token, expires
+	return token, nil
+}
+
+// resolveGCSToken looks for a token in GOOGLE_OAUTH_ACCESS_TOKEN, then for
+// application default credentials (GOOGLE_APPLICATION_CREDENTIALS or the
+// file `gcloud auth application-default login` writes), then asks the
+// metadata server of a Compute Engine instance.
+func resolveGCSToken(ctx context.Context, rt http.RoundTripper) (string, time.Time, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, time.Time{}, nil
+	}
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			if p := filepath.Join(dir, "gcloud", "application_default_credentials.json"); fileExists(p) {
+				path = p
+			}
+		}
+	}
+	if path != "" {
+		token, expires, err := googleCredentialsToken(ctx, rt, path)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("google credentials %s: %w", path, err)
+		}
+		return token, expires, nil
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	mctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+	b, err := metadataRequest(mctx, rt, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token",
+		http.Header{"Metadata-Flavor": {"Google"}})
+	if err != nil {
+		// Not on Compute Engine.
+		return "", time.Time{}, nil
+	}
+	return decodeTokenResponse(b)
+}
+
+// googleCredentialsToken exchanges a service account key or an authorized
+// user's refresh token for an access token.
+func googleCredentialsToken(ctx context.Context, rt http.RoundTripper, path string) (string, time.Time, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var cred struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		TokenURI     string `json:"token_uri"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(b, &cred); err != nil {
+		return "", time.Time{}, err
+	}
+	if cred.TokenURI == "" {
+		cred.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	form := url.Values{}
+	switch cred.Type {
+	case "service_account":
+		assertion, err := signJWT(cred.PrivateKey, map[string]any{
+			"iss":   cred.ClientEmail,
+			"scope": gcsReadScope,
+			"aud":   cred.TokenURI,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", cred.ClientID)
+		form.Set("client_secret", cred.ClientSecret)
+		form.Set("refresh_token", cred.RefreshToken)
+	default:
+		return "", time.Time{}, fmt.Errorf("unsupported credentials type %q", cred.Type)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cred.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token request to %s: HTTP %d: %s", cred.TokenURI, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return decodeTokenResponse(body)
+}
+
+func decodeTokenResponse(b []byte) (string, time.Time, error) {
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("token response has no access_token")
+	}
+	var expires time.Time
+	if body.ExpiresIn > 0 {
+		expires = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return body.AccessToken, expires, nil
+}
+
+// signJWT returns an RS256 signed JWT of claims.
+func signJWT(privateKeyPEM string, claims map[string]any) (string, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return "", fmt.Errorf("private_key is not PEM encoded")
+	}
+	var key *rsa.PrivateKey
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rk, ok := k.(*rsa.PrivateKey)
+		if !ok {
+			return "", fmt.Errorf("private_key is not an RSA key")
+		}
+		key = rk
+	} else if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		key = k
+	} else {
+		return "", fmt.Errorf("parsing private_key: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + enc.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+func firstEnv(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,414 @@
+package netcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// OCISource describes a file stored as a layer of an OCI artifact, such as
+// one pushed with `oras push`.
+type OCISource struct {
+	// Ref names the repository, e.g. ghcr.io/neurodesk/data or
+	// ghcr.io/neurodesk/data:v1. A tag is informational: the artifact is
+	// always fetched by Digest.
+	Ref string
+	// Digest is the sha256 digest of the artifact's manifest.
+	Digest string
+	// File selects the layer by its org.opencontainers.image.title
+	// annotation (the file name oras records). It may be empty when the
+	// artifact has a single layer.
+	File string
+}
+
+// OCICache keeps blobs pulled from OCI registries, keyed by their digest.
+type OCICache struct {
+	Dir    string
+	Client *http.Client
+	// DockerConfig is the docker config.json holding registry credentials.
+	// Defaults to $DOCKER_CONFIG/config.json or ~/.docker/config.json.
+	DockerConfig string
+}
+
+// NewOCI returns a new OCICache rooted at dir.
+func NewOCI(dir string) *OCICache {
+	return &OCICache{
+		Dir:    dir,
+		Client: &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ValidDigest reports whether digest is a sha256 content digest.
+func ValidDigest(digest string) bool {
+	return digestPattern.MatchString(digest)
+}
+
+const titleAnnotation = "org.opencontainers.image.title"
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// Get returns the path of the blob src selects, pulling the manifest and
+// blob when they are not cached. Both are checked against their digests.
+// Returns (path, fromCache, error).
+func (c *OCICache) Get(ctx context.Context, src OCISource) (string, bool, error) {
+	if !ValidDigest(src.Digest) {
+		return "", false, fmt.Errorf("oci source %s: digest %q is not a sha256 digest", src.Ref, src.Digest)
+	}
+	repo, err := parseOCIRef(src.Ref)
+	if err != nil {
+		return "", false, err
+	}
+
+	manifestPath := c.blobPath(src.Digest)
+	if !fileExists(manifestPath) {
+		if err := c.fetchBlob(ctx, repo, "manifests", src.Digest, manifestPath); err != nil {
+			return "", false, fmt.Errorf("fetching manifest of %s@%s: %w", src.Ref, src.Digest, err)
+		}
+	}
+	b, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return "", false, err
+	}
+	var m ociManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", false, fmt.Errorf("parsing manifest of %s@%s: %w", src.Ref, src.Digest, err)
+	}
+	if len(m.Manifests) > 0 {
+		return "", false, fmt.Errorf("%s@%s is an index of %d manifests; use the digest of a single artifact manifest", src.Ref, src.Digest, len(m.Manifests))
+	}
+	layer, err := selectLayer(m.Layers, src.File)
+	if err != nil {
+		return "", false, fmt.Errorf("%s@%s: %w", src.Ref, src.Digest, err)
+	}
+
+	path := c.blobPath(layer.Digest)
+	if fileExists(path) {
+		stats.hits.Add(1)
+		markUsed(manifestPath)
+		markUsed(path)
+		return path, true, nil
+	}
+	if err := c.fetchBlob(ctx, repo, "blobs", layer.Digest, path); err != nil {
+		return "", false, fmt.Errorf("fetching %s from %s@%s: %w", layer.Digest, src.Ref, src.Digest, err)
+	}
+	stats.misses.Add(1)
+	return path, false, nil
+}
+
+// Resolve returns the digest of the manifest, or index of manifests, that
+// the tag of ref names, or "latest" when ref has no tag. A ref with a
+// digest resolves to it without asking the registry.
+func (c *OCICache) Resolve(ctx context.Context, ref string) (string, error) {
+	if _, digest, ok := strings.Cut(ref, "@"); ok {
+		if !ValidDigest(digest) {
+			return "", fmt.Errorf("%s: %q is not a sha256 digest", ref, digest)
+		}
+		return digest, nil
+	}
+	repo, err := parseOCIRef(ref)
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", repo.scheme, repo.registry, repo.name, ociTag(ref))
+	resp, err := c.do(ctx, repo, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: HTTP %d", url, resp.StatusCode)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	digest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	if header := resp.Header.Get("Docker-Content-Digest"); header != "" && header != digest {
+		return "", fmt.Errorf("GET %s: manifest digest %s does not match Docker-Content-Digest %s", url, digest, header)
+	}
+	return digest, nil
+}
+
+// ociTag returns the tag of ref, or "latest".
+func ociTag(ref string) string {
+	ref = strings.TrimPrefix(strings.TrimPrefix(ref, "oci://"), "oras://")
+	ref, _, _ = strings.Cut(ref, "@")
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	if _, tag, ok := strings.Cut(name, ":"); ok {
+		return tag
+	}
+	return "latest"
+}
+
+func selectLayer(layers []ociDescriptor, file string) (ociDescriptor, error) {
+	if file == "" {
+		if len(layers) != 1 {
+			return ociDescriptor{}, fmt.Errorf("artifact has %d layers; select one with file (one of %s)", len(layers), layerTitles(layers))
+		}
+		return layers[0], nil
+	}
+	for _, l := range layers {
+		if l.Annotations[titleAnnotation] == file {
+			return l, nil
+		}
+	}
+	return ociDescriptor{}, fmt.Errorf("artifact has no file %q (have %s)", file, layerTitles(layers))
+}
+
+func layerTitles(layers []ociDescriptor) string {
+	var titles []string
+	for _, l := range layers {
+		if t := l.Annotations[titleAnnotation]; t != "" {
+			titles = append(titles, t)
+		}
+	}
+	if len(titles) == 0 {
+		return "no titled layers"
+	}
+	return strings.Join(titles, ", ")
+}
+
+func (c *OCICache) blobPath(digest string) string {
+	algo, hexDigest, _ := strings.Cut(digest, ":")
+	return filepath.Join(c.Dir, "blobs", algo, hexDigest)
+}
+
+// ociRepo is a repository on a registry.
+type ociRepo struct {
+	scheme   string
+	registry string
+	name     string
+}
+
+// parseOCIRef splits ref into its registry and repository, applying the
+// Docker Hub defaults. Registries on localhost are reached over plain
+// HTTP.
+func parseOCIRef(ref string) (ociRepo, error) {
+	ref = strings.TrimPrefix(strings.TrimPrefix(ref, "oci://"), "oras://")
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	registry, name, ok := strings.Cut(ref, "/")
+	if !ok || !strings.ContainsAny(registry, ".:") && registry != "localhost" {
+		registry, name = "docker.io", ref
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+		name = name[:i]
+	}
+	if name == "" {
+		return ociRepo{}, fmt.Errorf("invalid oci reference %q", ref)
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+		if !strings.Contains(name, "/") {
+			name = "library/" + name
+		}
+	}
+	scheme := "https"
+	if host := strings.Split(registry, ":")[0]; host == "localhost" || host == "127.0.0.1" {
+		scheme = "http"
+	}
+	return ociRepo{scheme: scheme, registry: registry, name: name}, nil
+}
+
+// fetchBlob downloads a manifest or blob by digest to dst, verifying it.
+// Concurrent fetches of the same digest wait for each other.
+func (c *OCICache) fetchBlob(ctx context.Context, repo ociRepo, kind, digest, dst string) error {
+	unlock, err := lockKey(ctx, dst+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if fileExists(dst) {
+		return nil
+	}
+	url := fmt.Sprintf("%s://%s/v2/%s/%s/%s", repo.scheme, repo.registry, repo.name, kind, digest)
+	resp, err := c.do(ctx, repo, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %d", url, resp.StatusCode)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	stats.bytes.Add(n)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("digest mismatch: got %s, want %s", got, digest)
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// do sends a GET to url, answering a bearer token challenge once.
+func (c *OCICache) do(ctx context.Context, repo ociRepo, url string) (*http.Response, error) {
+	get := func(token string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join([]string{
+			"application/vnd.oci.image.manifest.v1+json",
+			"application/vnd.oci.image.index.v1+json",
+			"application/vnd.docker.distribution.manifest.v2+json",
+			"application/vnd.docker.distribution.manifest.list.v2+json",
+			"*/*",
+		}, ", "))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if user, pass, ok := c.credentials(repo.registry); ok {
+			req.SetBasicAuth(user, pass)
+		}
+		return c.Client.Do(req)
+	}
+	resp, err := get("")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	token, err := c.token(ctx, repo, challenge)
+	if err != nil {
+		return nil, err
+	}
+	return get(token)
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// token obtains a pull token for repo from the realm of a Bearer challenge.
+func (c *OCICache) token(ctx context.Context, repo ociRepo, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("registry %s requires unsupported authentication %q", repo.registry, challenge)
+	}
+	attrs := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(params, -1) {
+		attrs[m[1]] = m[2]
+	}
+	if attrs["realm"] == "" {
+		return "", fmt.Errorf("registry %s sent a challenge without a realm", repo.registry)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attrs["realm"], nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	if attrs["service"] != "" {
+		q.Set("service", attrs["service"])
+	}
+	scope := attrs["scope"]
+	if scope == "" {
+		scope = "repository:" + repo.name + ":pull"
+	}
+	q.Set("scope", scope)
+	req.URL.RawQuery = q.Encode()
+	if user, pass, ok := c.credentials(repo.registry); ok {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting a token for %s from %s: HTTP %d", repo.name, attrs["realm"], resp.StatusCode)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// credentials returns the username and password docker login stored for
+// registry. Credential helpers are not consulted.
+func (c *OCICache) credentials(registry string) (string, string, bool) {
+	path := c.DockerConfig
+	if path == "" {
+		dir := os.Getenv("DOCKER_CONFIG")
+		if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", "", false
+			}
+			dir = filepath.Join(home, ".docker")
+		}
+		path = filepath.Join(dir, "config.json")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false
+	}
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return "", "", false
+	}
+	keys := []string{registry, "https://" + registry}
+	if registry == "registry-1.docker.io" {
+		keys = append(keys, "https://index.docker.io/v1/", "docker.io")
+	}
+	for _, k := range keys {
+		entry, ok := cfg.Auths[k]
+		if !ok || entry.Auth == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			continue
+		}
+		user, pass, ok := strings.Cut(string(decoded), ":")
+		if ok {
+			return user, pass, true
+		}
+	}
+	return "", "", false
+}
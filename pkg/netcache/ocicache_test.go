@@ -0,0 +1,172 @@
+package netcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fakeRegistry serves blobs and manifests of the repository neurodesk/data
+// behind a bearer token challenge.
+func fakeRegistry(t *testing.T, blobs map[string][]byte, requests *atomic.Int64) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:neurodesk/data:pull" {
+				http.Error(w, "bad scope", http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "secret"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		requests.Add(1)
+		for _, prefix := range []string{"/v2/neurodesk/data/manifests/", "/v2/neurodesk/data/blobs/"} {
+			if digest, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+				if b, ok := blobs[digest]; ok {
+					w.Write(b)
+					return
+				}
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOCICacheGetPullsLayerByTitle(t *testing.T) {
+	model := []byte("model weights")
+	readme := []byte("readme")
+	blobs := map[string][]byte{
+		sha256Digest(model):  model,
+		sha256Digest(readme): readme,
+	}
+	manifest, _ := json.Marshal(ociManifest{
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Layers: []ociDescriptor{
+			{Digest: sha256Digest(readme), Size: int64(len(readme)), Annotations: map[string]string{titleAnnotation: "README.md"}},
+			{Digest: sha256Digest(model), Size: int64(len(model)), Annotations: map[string]string{titleAnnotation: "model.bin"}},
+		},
+	})
+	manifestDigest := sha256Digest(manifest)
+	blobs[manifestDigest] = manifest
+
+	var requests atomic.Int64
+	srv := fakeRegistry(t, blobs, &requests)
+	ref := strings.Replace(srv.URL, "http://", "", 1) + "/neurodesk/data:v1"
+
+	c := NewOCI(t.TempDir())
+	c.DockerConfig = "/nonexistent"
+	src := OCISource{Ref: ref, Digest: manifestDigest, File: "model.bin"}
+	path, fromCache, err := c.Get(context.Background(), src)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if fromCache {
+		t.Fatalf("first Get should not come from the cache")
+	}
+	if b, _ := os.ReadFile(path); string(b) != "model weights" {
+		t.Fatalf("pulled %q", b)
+	}
+
+	before := requests.Load()
+	if _, fromCache, err := c.Get(context.Background(), src); err != nil || !fromCache {
+		t.Fatalf("second Get = fromCache %v, %v", fromCache, err)
+	}
+	if requests.Load() != before {
+		t.Fatalf("second Get contacted the registry")
+	}
+
+	src.File = ""
+	if _, _, err := c.Get(context.Background(), src); err == nil || !strings.Contains(err.Error(), "README.md, model.bin") {
+		t.Fatalf("expected an error listing the layers, got %v", err)
+	}
+}
+
+func TestOCICacheGetRejectsDigestMismatch(t *testing.T) {
+	manifest := []byte(`{"layers":[]}`)
+	wrong := sha256Digest([]byte("something else"))
+	var requests atomic.Int64
+	srv := fakeRegistry(t, map[string][]byte{wrong: manifest}, &requests)
+	ref := strings.Replace(srv.URL, "http://", "", 1) + "/neurodesk/data"
+
+	c := NewOCI(t.TempDir())
+	c.DockerConfig = "/nonexistent"
+	_, _, err := c.Get(context.Background(), OCISource{Ref: ref, Digest: wrong})
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+}
+
+func TestParseOCIRef(t *testing.T) {
+	tests := map[string]ociRepo{
+		"ghcr.io/neurodesk/data:v1":       {"https", "ghcr.io", "neurodesk/data"},
+		"oras://ghcr.io/neurodesk/data":   {"https", "ghcr.io", "neurodesk/data"},
+		"localhost:5000/models@sha256:00": {"http", "localhost:5000", "models"},
+		"ubuntu":                          {"https", "registry-1.docker.io", "library/ubuntu"},
+		"neurodesk/data":                  {"https", "registry-1.docker.io", "neurodesk/data"},
+	}
+	for ref, want := range tests {
+		got, err := parseOCIRef(ref)
+		if err != nil || got != want {
+			t.Fatalf("parseOCIRef(%q) = %+v, %v; want %+v", ref, got, err, want)
+		}
+	}
+}
+
+func TestOCICacheResolveTag(t *testing.T) {
+	manifest := []byte(`{"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`)
+	var requests atomic.Int64
+	srv := fakeRegistry(t, map[string][]byte{"v2": manifest}, &requests)
+	repo := strings.Replace(srv.URL, "http://", "", 1) + "/neurodesk/data"
+
+	c := NewOCI(t.TempDir())
+	c.DockerConfig = "/nonexistent"
+	digest, err := c.Resolve(context.Background(), repo+":v2")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if digest != sha256Digest(manifest) {
+		t.Fatalf("Resolve = %s, want %s", digest, sha256Digest(manifest))
+	}
+	if _, err := c.Resolve(context.Background(), repo+":v3"); err == nil {
+		t.Fatalf("Resolve of a missing tag succeeded")
+	}
+	pinned := repo + "@" + sha256Digest(manifest)
+	if digest, err := c.Resolve(context.Background(), pinned); err != nil || digest != sha256Digest(manifest) || requests.Load() != 2 {
+		t.Fatalf("Resolve(%s) = %s, %v after %d requests; want the digest without a request", pinned, digest, err, requests.Load())
+	}
+}
+
+func TestOCITag(t *testing.T) {
+	tests := map[string]string{
+		"ubuntu:22.04":                      "22.04",
+		"ubuntu":                            "latest",
+		"localhost:5000/models":             "latest",
+		"localhost:5000/models:v1@sha256:0": "v1",
+		"oras://ghcr.io/neurodesk/data:v1":  "v1",
+	}
+	for ref, want := range tests {
+		if got := ociTag(ref); got != want {
+			t.Fatalf("ociTag(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package netcache
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PruneResult lists the cache entries Prune removed, or would remove in a
+// dry run.
+type PruneResult struct {
+	// Paths are the removed files and directories.
+	Paths []string
+	// Bytes is the size of the removed files.
+	Bytes int64
+}
+
+// Prune removes the entries of the HTTP cache last used before cutoff.
+func (c *Cache) Prune(cutoff time.Time, dryRun bool) (PruneResult, error) {
+	return pruneDir(c.Dir, cutoff, dryRun)
+}
+
+// Prune removes the git checkouts last used before cutoff.
+func (c *GitCache) Prune(cutoff time.Time, dryRun bool) (PruneResult, error) {
+	return pruneDir(c.Dir, cutoff, dryRun)
+}
+
+// Prune removes the blobs last used before cutoff.
+func (c *OCICache) Prune(cutoff time.Time, dryRun bool) (PruneResult, error) {
+	var res PruneResult
+	algos, err := os.ReadDir(filepath.Join(c.Dir, "blobs"))
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	for _, a := range algos {
+		if !a.IsDir() {
+			continue
+		}
+		r, err := pruneDir(filepath.Join(c.Dir, "blobs", a.Name()), cutoff, dryRun)
+		res.Paths = append(res.Paths, r.Paths...)
+		res.Bytes += r.Bytes
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// markUsed records that the cache entry at path was used, for Prune.
+func markUsed(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// pruneDir removes the entries of a cache directory whose files were all
+// last modified before cutoff. The files of an entry share the name of its
+// key up to the first dot: the payload, its metadata, its lock and any
+// partial download or temporary files.
+func pruneDir(dir string, cutoff time.Time, dryRun bool) (PruneResult, error) {
+	var res PruneResult
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	groups := map[string][]fs.DirEntry{}
+	for _, e := range entries {
+		key, _, _ := strings.Cut(e.Name(), ".")
+		groups[key] = append(groups[key], e)
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		stale := true
+		for _, e := range groups[key] {
+			info, err := e.Info()
+			if err != nil || !info.ModTime().Before(cutoff) {
+				stale = false
+				break
+			}
+		}
+		if !stale {
+			continue
+		}
+		for _, e := range groups[key] {
+			path := filepath.Join(dir, e.Name())
+			res.Bytes += treeSize(path)
+			res.Paths = append(res.Paths, path)
+			if dryRun {
+				continue
+			}
+			if err := os.RemoveAll(path); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// treeSize returns the size of the regular files at or below path.
+func treeSize(path string) int64 {
+	var n int64
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				n += info.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
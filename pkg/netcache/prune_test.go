@@ -0,0 +1,53 @@
+package netcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneRemovesEntriesUnusedSinceCutoff(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	write := func(name, data string, mtime time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("aaa.data", "12345", old)
+	write("aaa.json", "{}", old)
+	write("aaa.lock", "", old)
+	write("bbb.data", "678", old)
+	write("bbb.json", "{}", old)
+	write("bbb.lock", "", old)
+	// bbb was used recently: its metadata was touched on a hit.
+	markUsed(filepath.Join(dir, "bbb.json"))
+
+	c := New(dir)
+	cutoff := time.Now().Add(-7 * 24 * time.Hour)
+	res, err := c.Prune(cutoff, true)
+	if err != nil {
+		t.Fatalf("Prune dry run: %v", err)
+	}
+	if len(res.Paths) != 3 || res.Bytes != 7 {
+		t.Fatalf("dry run = %d paths, %d bytes; want 3 paths, 7 bytes", len(res.Paths), res.Bytes)
+	}
+	if !fileExists(filepath.Join(dir, "aaa.data")) {
+		t.Fatalf("dry run removed files")
+	}
+
+	if _, err := c.Prune(cutoff, false); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if fileExists(filepath.Join(dir, "aaa.data")) || fileExists(filepath.Join(dir, "aaa.json")) {
+		t.Fatalf("stale entry was kept")
+	}
+	if !fileExists(filepath.Join(dir, "bbb.data")) {
+		t.Fatalf("recently used entry was removed")
+	}
+}
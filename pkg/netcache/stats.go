@@ -0,0 +1,26 @@
+package netcache
+
+import "sync/atomic"
+
+// Stats counts the HTTP cache activity of the process, for metrics.
+type Stats struct {
+	// Hits counts Get calls served from the cache, Misses those that
+	// downloaded the file.
+	Hits   int64
+	Misses int64
+	// BytesDownloaded counts response body bytes written to the cache.
+	BytesDownloaded int64
+}
+
+var stats struct {
+	hits, misses, bytes atomic.Int64
+}
+
+// ReadStats returns the cache activity so far.
+func ReadStats() Stats {
+	return Stats{
+		Hits:            stats.hits.Load(),
+		Misses:          stats.misses.Load(),
+		BytesDownloaded: stats.bytes.Load(),
+	}
+}
@@ -0,0 +1,119 @@
+package recipe
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	v "github.com/neurodesk/builder/pkg/validator"
+)
+
+// ArchDirective is an `arch:` block mapping each target architecture
+// (x86_64, aarch64) to a list of directives.
+//
+// Only the list for the architecture being generated is applied, in the
+// enclosing scope, so variables it sets are visible afterwards. An
+// architecture without an entry applies nothing.
+type ArchDirective map[CPUArchitecture][]Directive
+
+func (a ArchDirective) Validate(ctx Context) error {
+	var errs []error
+	for _, arch := range a.archs() {
+		if err := validateArchKey(arch, "arch"); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, validateDirectives(ctx, a[arch], "arch."+string(arch)))
+	}
+	return v.All(errs...)
+}
+
+func (a ArchDirective) Apply(ctx *Context, src ir.SourceID) error {
+	for i, directive := range a[ctx.Arch] {
+		if directive.Source == "" {
+			directive.Source = nestedSource(src, "arch.%s[%d]", ctx.Arch, i)
+		}
+		if err := directive.Apply(ctx); err != nil {
+			return fmt.Errorf("applying arch.%s[%d] (%s): %w", ctx.Arch, i, directive.kind(), err)
+		}
+	}
+	return nil
+}
+
+func (a ArchDirective) archs() []CPUArchitecture {
+	archs := make([]CPUArchitecture, 0, len(a))
+	for arch := range a {
+		archs = append(archs, arch)
+	}
+	sort.Slice(archs, func(i, j int) bool { return archs[i] < archs[j] })
+	return archs
+}
+
+func validateArchKey(arch CPUArchitecture, description string) error {
+	switch arch {
+	case CPUArchAMD64, CPUArchARM64:
+		return nil
+	}
+	return fmt.Errorf("%s: unknown architecture %q (want %s or %s)", description, arch, CPUArchAMD64, CPUArchARM64)
+}
+
+// validateArch checks a file whose source is given per architecture: the
+// entries replace the source fields, so those must be empty, and each
+// entry must be a complete source.
+func (f FileDirective) validateArch() error {
+	if f.Filename != "" || f.Url != "" || f.Contents != "" || f.Git != nil || f.OCI != nil {
+		return fmt.Errorf("file must set either arch or one of filename, url, contents, git, or oci")
+	}
+	errs := []error{f.Name.Validate()}
+	if !f.Extract && (f.StripComponents != 0 || f.Subdir != "") {
+		errs = append(errs, fmt.Errorf("strip_components and subdir require extract: true"))
+	}
+	for arch := range f.Arch {
+		description := "arch." + string(arch)
+		if err := validateArchKey(arch, description); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		variant, _ := FileInfo(f).forArch(arch)
+		if variant.Arch != nil {
+			errs = append(errs, fmt.Errorf("%s: arch entries cannot be nested", description))
+			continue
+		}
+		if err := FileDirective(variant).Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", description, err))
+		}
+	}
+	return v.All(errs...)
+}
+
+// forArch returns the file with the source for arch filled in. Settings on
+// the entry take precedence over those on the file.
+func (f FileInfo) forArch(arch CPUArchitecture) (FileInfo, error) {
+	variant, ok := f.Arch[arch]
+	if !ok {
+		return FileInfo{}, fmt.Errorf("file %s has no source for architecture %s", f.Name, arch)
+	}
+	if variant.Name == "" {
+		variant.Name = f.Name
+	}
+	variant.Executable = variant.Executable || f.Executable
+	if variant.Retry == nil {
+		variant.Retry = f.Retry
+	}
+	if variant.Insecure == nil {
+		variant.Insecure = f.Insecure
+	}
+	if variant.Refresh == nil {
+		variant.Refresh = f.Refresh
+	}
+	if !variant.Extract && f.Extract {
+		variant.Extract = true
+		if variant.StripComponents == 0 {
+			variant.StripComponents = f.StripComponents
+		}
+		if variant.Subdir == "" {
+			variant.Subdir = f.Subdir
+		}
+	}
+	return variant, nil
+}
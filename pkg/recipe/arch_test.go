@@ -0,0 +1,128 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const archRecipe = `name: multiarch
+version: 1.0.0
+architectures:
+  - x86_64
+  - aarch64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - arch:
+        x86_64:
+          - variables:
+              triple: x86_64-linux-gnu
+        aarch64:
+          - variables:
+              triple: aarch64-linux-gnu
+          - run:
+              - echo arm only
+    - run:
+        - echo {{ local.triple }}
+    - file:
+        name: tool.tar.gz
+        executable: true
+        arch:
+          x86_64:
+            url: https://example.org/tool-x64.tar.gz
+          aarch64:
+            url: https://example.org/tool-arm64.tar.gz
+    - run:
+        - tar xf {{ get_file("tool.tar.gz") }}
+`
+
+func TestArchBlocksFollowTargetArchitecture(t *testing.T) {
+	build, err := loadBuildYAML(t, archRecipe)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+
+	for _, tc := range []struct {
+		arch    CPUArchitecture
+		want    []string
+		notWant []string
+	}{
+		{CPUArchAMD64, []string{"echo x86_64-linux-gnu"}, []string{"arm only", "aarch64-linux-gnu"}},
+		{CPUArchARM64, []string{"echo arm only", "echo aarch64-linux-gnu"}, []string{"x86_64-linux-gnu"}},
+	} {
+		t.Run(string(tc.arch), func(t *testing.T) {
+			def, plan, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{t.TempDir()}, Arch: tc.arch})
+			if err != nil {
+				t.Fatalf("GenerateWithParams: %v", err)
+			}
+			dockerfile, err := ir.GenerateDockerfile(def)
+			if err != nil {
+				t.Fatalf("GenerateDockerfile: %v", err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(dockerfile, want) {
+					t.Fatalf("Dockerfile missing %q:\n%s", want, dockerfile)
+				}
+			}
+			for _, notWant := range tc.notWant {
+				if strings.Contains(dockerfile, notWant) {
+					t.Fatalf("Dockerfile unexpectedly contains %q:\n%s", notWant, dockerfile)
+				}
+			}
+
+			if len(plan.Files) != 1 {
+				t.Fatalf("expected one staged file, got %+v", plan.Files)
+			}
+			wantURL := map[CPUArchitecture]string{
+				CPUArchAMD64: "https://example.org/tool-x64.tar.gz",
+				CPUArchARM64: "https://example.org/tool-arm64.tar.gz",
+			}[tc.arch]
+			if got := plan.Files[0]; got.URL != wantURL || !got.Executable {
+				t.Fatalf("staged file = %+v, want url %s and executable", got, wantURL)
+			}
+		})
+	}
+
+	if _, _, err := build.GenerateWithParams(GenerateParams{Arch: "riscv64"}); err == nil {
+		t.Fatalf("generating for an undeclared architecture succeeded")
+	}
+}
+
+func TestArchBlocksAreValidated(t *testing.T) {
+	for _, tc := range []struct {
+		directive string
+		wantErr   string
+	}{
+		{"arch:\n        ppc64le:\n          - run: [echo]", "unknown architecture"},
+		{"arch:\n        x86_64:\n          - expose: 70000", "arch.x86_64[0]"},
+		{"file:\n        name: a\n        url: https://example.org/a\n        arch:\n          x86_64:\n            url: https://example.org/b", "either arch or"},
+		{"file:\n        name: a\n        arch:\n          x86_64: {}", "arch.x86_64"},
+	} {
+		t.Run(tc.wantErr, func(t *testing.T) {
+			_, err := loadBuildYAML(t, strings.Replace(singleDirectiveRecipe, "DIRECTIVE", tc.directive, 1))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+const singleDirectiveRecipe = `name: single
+version: 1.0.0
+architectures:
+  - x86_64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - DIRECTIVE
+`
@@ -0,0 +1,42 @@
+package recipe
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// SplitImageDigest splits an image reference such as
+// ubuntu:22.04@sha256:... into the image and its digest, which is empty
+// when the image is not pinned.
+func SplitImageDigest(image string) (string, string) {
+	name, digest, _ := strings.Cut(image, "@")
+	return name, digest
+}
+
+// BaseImages returns the base images the recipe names, the build's first
+// and then its stages', as written: templated ones are not evaluated.
+func (b *BuildFile) BaseImages() []string {
+	var images []string
+	add := func(image string) {
+		if image != "" && !slices.Contains(images, image) {
+			images = append(images, image)
+		}
+	}
+	add(b.Build.BaseImage)
+	for _, s := range b.Build.Stages {
+		add(s.BaseImage)
+	}
+	return images
+}
+
+// checkBaseImage warns when a recipe loaded with LoadStrict builds on an
+// image that is not pinned to a digest (see `builder pin-bases`).
+func (c *Context) checkBaseImage(image string) {
+	if !c.root().strict {
+		return
+	}
+	if _, digest := SplitImageDigest(image); digest == "" {
+		slog.Warn("base image is not pinned to a digest; run builder pin-bases", "recipe", c.root().Name, "image", image)
+	}
+}
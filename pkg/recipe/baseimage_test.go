@@ -0,0 +1,59 @@
+package recipe
+
+import (
+	"bytes"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBaseImages(t *testing.T) {
+	build, err := loadBuildYAML(t, stagesRecipeHeader+`  stages:
+    - name: compile
+      base-image: gcc:14@sha256:1f2e
+    - name: docs
+      base-image: ubuntu:24.04
+  directives:
+    - run: [true]
+`)
+	if err != nil {
+		t.Fatalf("loading: %v", err)
+	}
+	if got, want := build.BaseImages(), []string{"ubuntu:24.04", "gcc:14@sha256:1f2e"}; !slices.Equal(got, want) {
+		t.Fatalf("BaseImages() = %q, want %q", got, want)
+	}
+	if name, digest := SplitImageDigest("gcc:14@sha256:1f2e"); name != "gcc:14" || digest != "sha256:1f2e" {
+		t.Fatalf("SplitImageDigest = %q, %q", name, digest)
+	}
+}
+
+func TestStrictGenerationWarnsAboutUnpinnedBaseImages(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	text := stagesRecipeHeader + `  stages:
+    - name: compile
+      base-image: gcc:14@sha256:1f2e
+  directives:
+    - run: [true]
+`
+	for _, mode := range []LoadMode{LoadDefault, LoadStrict} {
+		logs.Reset()
+		build, err := loadWithMode(t, text, mode)
+		if err != nil {
+			t.Fatalf("loading: %v", err)
+		}
+		if _, err := build.Generate(nil); err != nil {
+			t.Fatalf("generating: %v", err)
+		}
+		warned := strings.Count(logs.String(), "not pinned to a digest")
+		if mode == LoadStrict && (warned != 1 || !strings.Contains(logs.String(), "image=ubuntu:24.04")) {
+			t.Fatalf("strict generation logged:\n%s\nwant one warning about ubuntu:24.04", logs.String())
+		}
+		if mode == LoadDefault && warned != 0 {
+			t.Fatalf("default generation warned about base images:\n%s", logs.String())
+		}
+	}
+}
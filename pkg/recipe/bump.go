@@ -0,0 +1,298 @@
+package recipe
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// CompareVersions orders two versions by their runs of digits, compared as
+// numbers, and of letters, compared as text; separators such as . and -
+// only split runs. A run of digits sorts after a run of letters, so 1.0.1
+// is newer than 1.0a, and a version that extends another with letters is a
+// pre-release of it: 2.0rc1 sorts before 2.0, 2.0.1 after it.
+func CompareVersions(a, b string) int {
+	ta, tb := versionRuns(a), versionRuns(b)
+	for i := 0; i < len(ta) && i < len(tb); i++ {
+		x, y := ta[i], tb[i]
+		xNum, yNum := isDigits(x), isDigits(y)
+		switch {
+		case xNum && yNum:
+			x, y = strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(x) != len(y) {
+				return len(x) - len(y)
+			}
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		case xNum:
+			return 1
+		case yNum:
+			return -1
+		default:
+			if c := strings.Compare(strings.ToLower(x), strings.ToLower(y)); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(ta) > len(tb):
+		return extraRank(ta[len(tb)])
+	case len(tb) > len(ta):
+		return -extraRank(tb[len(ta)])
+	}
+	return 0
+}
+
+// extraRank is how a version compares with the one it extends by run.
+func extraRank(run string) int {
+	if isDigits(run) {
+		return 1
+	}
+	return -1
+}
+
+func versionRuns(version string) []string {
+	var runs []string
+	start := -1
+	kind := func(r rune) int {
+		switch {
+		case unicode.IsDigit(r):
+			return 1
+		case unicode.IsLetter(r):
+			return 2
+		}
+		return 0
+	}
+	runes := []rune(version)
+	for i := 0; i <= len(runes); i++ {
+		if start >= 0 && (i == len(runes) || kind(runes[i]) != kind(runes[start])) {
+			runs = append(runs, string(runes[start:i]))
+			start = -1
+		}
+		if i < len(runes) && start < 0 && kind(runes[i]) != 0 {
+			start = i
+		}
+	}
+	return runs
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) }) < 0
+}
+
+// scalarEdit sets the scalar node, written on one line, to value.
+type scalarEdit struct {
+	node  *yaml.Node
+	value string
+}
+
+// BumpBuildFile sets the version of the build.yaml src and returns the new
+// text with a note per change. Where the version is written out rather than
+// templated, it is replaced in url and ref values and in template versions
+// equal to it. When the new version sorts before the old one (see
+// CompareVersions), epoch is incremented so the new image still counts as
+// newer. The rest of the file, comments included, is kept as written.
+func BumpBuildFile(src []byte, version string) ([]byte, []string, error) {
+	root, lines, err := parseBlockMapping(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	versionKey, versionNode := mappingEntry(root, "version")
+	if versionNode == nil || versionNode.Kind != yaml.ScalarNode {
+		return nil, nil, errors.New("build file has no version")
+	}
+	old := versionNode.Value
+	if old == version {
+		return nil, nil, fmt.Errorf("already at version %s", version)
+	}
+
+	edits := []scalarEdit{{versionNode, version}}
+	notes := []string{fmt.Sprintf("line %d: version %s -> %s", versionNode.Line, old, version)}
+	walkEntries(root, func(key, val *yaml.Node) {
+		switch {
+		case (key.Value == "url" || key.Value == "ref") && val.Kind == yaml.ScalarNode && strings.Contains(val.Value, old):
+			updated := strings.ReplaceAll(val.Value, old, version)
+			edits = append(edits, scalarEdit{val, updated})
+			notes = append(notes, fmt.Sprintf("line %d: %s %s -> %s", val.Line, key.Value, val.Value, updated))
+		case key.Value == "template":
+			if v := mappingValue(val, "version"); v != nil && v.Kind == yaml.ScalarNode && v.Value == old {
+				edits = append(edits, scalarEdit{v, version})
+				notes = append(notes, fmt.Sprintf("line %d: template %s version %s -> %s", v.Line, scalarOf(mappingValue(val, "name")), old, version))
+			}
+		}
+	})
+
+	var insert []string
+	if CompareVersions(version, old) < 0 {
+		_, epochNode := mappingEntry(root, "epoch")
+		epoch, line := 0, versionKey.Line+1
+		if epochNode != nil {
+			if epoch, err = strconv.Atoi(epochNode.Value); err != nil {
+				return nil, nil, fmt.Errorf("line %d: epoch %q is not a number", epochNode.Line, epochNode.Value)
+			}
+			edits = append(edits, scalarEdit{epochNode, strconv.Itoa(epoch + 1)})
+			line = epochNode.Line
+		} else {
+			insert = []string{strings.Repeat(" ", versionKey.Column-1) + fmt.Sprintf("epoch: %d\n", epoch+1)}
+		}
+		notes = append(notes, fmt.Sprintf("line %d: epoch %d -> %d, since %s sorts before %s", line, epoch, epoch+1, version, old))
+	}
+
+	if err := applyScalarEdits(lines, edits); err != nil {
+		return nil, nil, err
+	}
+	if insert != nil {
+		lines = slices.Insert(lines, versionKey.Line, insert...)
+	}
+	out, err := checkParses(lines, "bumped")
+	return out, notes, err
+}
+
+// ReplaceScalarValues replaces the scalars of the build.yaml src whose
+// value is old with new, such as a pinned commit, and returns the new text
+// and the lines it changed.
+func ReplaceScalarValues(src []byte, old, new string) ([]byte, []int, error) {
+	root, lines, err := parseBlockMapping(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	var edits []scalarEdit
+	var changed []int
+	walkEntries(root, func(key, val *yaml.Node) {
+		if val.Kind == yaml.ScalarNode && val.Value == old {
+			edits = append(edits, scalarEdit{val, new})
+			changed = append(changed, val.Line)
+		}
+	})
+	if len(edits) == 0 {
+		return src, nil, nil
+	}
+	if err := applyScalarEdits(lines, edits); err != nil {
+		return nil, nil, err
+	}
+	out, err := checkParses(lines, "edited")
+	return out, changed, err
+}
+
+// parseBlockMapping parses src, which must be a block mapping, and splits
+// it into lines that keep their line endings.
+func parseBlockMapping(src []byte) (*yaml.Node, []string, error) {
+	text := string(src)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing build file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode || doc.Content[0].Style&yaml.FlowStyle != 0 {
+		return nil, nil, errors.New("build file is not a block mapping")
+	}
+	lines := strings.SplitAfter(text, "\n")
+	return doc.Content[0], lines[:len(lines)-1], nil
+}
+
+func checkParses(lines []string, what string) ([]byte, error) {
+	out := strings.Join(lines, "")
+	var check yaml.Node
+	if err := yaml.Unmarshal([]byte(out), &check); err != nil {
+		return nil, fmt.Errorf("%s build file does not parse: %w", what, err)
+	}
+	return []byte(out), nil
+}
+
+// walkEntries calls fn with every key and value of the mappings under node.
+func walkEntries(node *yaml.Node, fn func(key, val *yaml.Node)) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			fn(node.Content[i], node.Content[i+1])
+		}
+	}
+	for _, child := range node.Content {
+		walkEntries(child, fn)
+	}
+}
+
+func scalarOf(node *yaml.Node) string {
+	if node == nil {
+		return ""
+	}
+	return node.Value
+}
+
+// applyScalarEdits rewrites the scalars in place, keeping their quoting
+// style unless the new value needs quotes. Edits are applied from the end
+// of the file so the columns of earlier ones stay valid.
+func applyScalarEdits(lines []string, edits []scalarEdit) error {
+	slices.SortFunc(edits, func(a, b scalarEdit) int {
+		if a.node.Line != b.node.Line {
+			return b.node.Line - a.node.Line
+		}
+		return b.node.Column - a.node.Column
+	})
+	for _, e := range edits {
+		line := lines[e.node.Line-1]
+		start := e.node.Column - 1
+		end, ok := scalarEnd(line, start, e.node)
+		if !ok {
+			return fmt.Errorf("line %d: %q spans several lines; edit it by hand", e.node.Line, e.node.Value)
+		}
+		lines[e.node.Line-1] = line[:start] + quoteScalar(e.value, e.node) + line[end:]
+	}
+	return nil
+}
+
+// scalarEnd returns the end of the scalar node starting at start in line,
+// or false if it does not end on the line.
+func scalarEnd(line string, start int, node *yaml.Node) (int, bool) {
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, true
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] == '\'' {
+				if i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					continue
+				}
+				return i + 1, true
+			}
+		}
+	case 0:
+		if end := start + len(node.Value); !strings.Contains(node.Value, "\n") && end <= len(line) && line[start:end] == node.Value {
+			return end, true
+		}
+	}
+	return 0, false
+}
+
+// quoteScalar writes value in the style of node, quoting a plain value that
+// would read back with a different type, such as a version 2.0 where the
+// old one was a string.
+func quoteScalar(value string, node *yaml.Node) string {
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		return strconv.Quote(value)
+	case yaml.SingleQuotedStyle:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	var parsed yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &parsed); err == nil && len(parsed.Content) == 1 &&
+		parsed.Content[0].Kind == yaml.ScalarNode && parsed.Content[0].Tag == node.Tag && parsed.Content[0].Value == value {
+		return value
+	}
+	return strconv.Quote(value)
+}
@@ -0,0 +1,99 @@
+package recipe
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const bumpRecipe = `name: tool
+version: 1.2.0 # upstream release
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - template:
+        name: miniconda
+        version: 1.2.0
+    - file:
+        name: tool.tar.gz
+        url: "https://example.org/tool-1.2.0.tar.gz"
+    - file:
+        name: src
+        git: {url: https://example.org/tool.git, ref: v1.2.0}
+    - file:
+        name: model.bin
+        oci: {ref: "ghcr.io/example/model:1.2.0", digest: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+    - file:
+        name: docs.tar.gz
+        url: https://example.org/{{ context.version }}/docs.tar.gz
+`
+
+func TestBumpBuildFile(t *testing.T) {
+	out, notes, err := BumpBuildFile([]byte(bumpRecipe), "1.10.0")
+	if err != nil {
+		t.Fatalf("BumpBuildFile: %v", err)
+	}
+	want := strings.NewReplacer(
+		"version: 1.2.0 #", "version: 1.10.0 #",
+		"        version: 1.2.0", "        version: 1.10.0",
+		"tool-1.2.0.tar.gz", "tool-1.10.0.tar.gz",
+		"ref: v1.2.0", "ref: v1.10.0",
+		"model:1.2.0", "model:1.10.0",
+	).Replace(bumpRecipe)
+	if string(out) != want {
+		t.Fatalf("bumped recipe:\n%s\nwant:\n%s", out, want)
+	}
+	if len(notes) != 5 {
+		t.Fatalf("notes = %q, want 5", notes)
+	}
+	if _, _, err := BumpBuildFile(out, "1.10.0"); err == nil {
+		t.Fatalf("bumping to the current version succeeded")
+	}
+}
+
+func TestBumpBuildFileToOlderVersionIncrementsEpoch(t *testing.T) {
+	out, _, err := BumpBuildFile([]byte(bumpRecipe), "1.1")
+	if err != nil {
+		t.Fatalf("BumpBuildFile: %v", err)
+	}
+	if !strings.Contains(string(out), "version: \"1.1\" # upstream release\nepoch: 1\n") {
+		t.Fatalf("bumped recipe has no quoted version and epoch 1:\n%s", out)
+	}
+	again, _, err := BumpBuildFile(out, "1.0")
+	if err != nil {
+		t.Fatalf("BumpBuildFile: %v", err)
+	}
+	if !strings.Contains(string(again), "\nepoch: 2\n") {
+		t.Fatalf("second bump did not increment epoch:\n%s", again)
+	}
+	if _, err := ParseBuildFile(again, "."); err != nil {
+		t.Fatalf("bumped recipe does not load: %v", err)
+	}
+}
+
+func TestReplaceScalarValues(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	pinned := "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	out, lines, err := ReplaceScalarValues([]byte(bumpRecipe), digest, pinned)
+	if err != nil {
+		t.Fatalf("ReplaceScalarValues: %v", err)
+	}
+	if !slices.Equal(lines, []int{20}) || string(out) != strings.Replace(bumpRecipe, digest, pinned, 1) {
+		t.Fatalf("ReplaceScalarValues changed lines %v:\n%s", lines, out)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	ordered := []string{"0.9", "1.0a", "1.0rc1", "1.0", "1.0.1", "1.2", "1.10", "2.0-beta", "2.0"}
+	for i := range ordered {
+		for j := range ordered {
+			got := CompareVersions(ordered[i], ordered[j])
+			if i < j && got >= 0 || i > j && got <= 0 || i == j && got != 0 {
+				t.Fatalf("CompareVersions(%q, %q) = %d", ordered[i], ordered[j], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package recipe
+
+import (
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// CatalogEntry describes a recipe for the Neurodesk application menu.
+type CatalogEntry struct {
+	Name          string            `json:"name" yaml:"name"`
+	Version       string            `json:"version" yaml:"version"`
+	Draft         bool              `json:"draft,omitempty" yaml:"draft,omitempty"`
+	Architectures []CPUArchitecture `json:"architectures" yaml:"architectures"`
+	Categories    []Category        `json:"categories,omitempty" yaml:"categories,omitempty"`
+	// Readme is the rendered readme.
+	Readme    string        `json:"readme,omitempty" yaml:"readme,omitempty"`
+	ReadmeURL string        `json:"readme_url,omitempty" yaml:"readme_url,omitempty"`
+	Icon      string        `json:"icon,omitempty" yaml:"icon,omitempty"`
+	GuiApps   []CatalogApp  `json:"gui_apps,omitempty" yaml:"gui_apps,omitempty"`
+	Deploy    CatalogDeploy `json:"deploy" yaml:"deploy"`
+}
+
+// CatalogApp is a GUI application in a CatalogEntry.
+type CatalogApp struct {
+	Name string `json:"name" yaml:"name"`
+	Exec string `json:"exec" yaml:"exec"`
+}
+
+// CatalogDeploy lists the rendered deploy.bins and deploy.path.
+type CatalogDeploy struct {
+	Bins []string `json:"bins,omitempty" yaml:"bins,omitempty"`
+	Path []string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// CatalogEntry generates the recipe with default options and returns its
+// catalog entry, with the readme and deploy lists rendered.
+func (b *BuildFile) CatalogEntry(includeDirs []string) (*CatalogEntry, error) {
+	def, plan, err := b.GenerateWithStaging(includeDirs)
+	if err != nil {
+		return nil, err
+	}
+	entry := &CatalogEntry{
+		Name:          b.Name,
+		Version:       b.Version,
+		Draft:         b.Draft,
+		Architectures: b.Architectures,
+		Categories:    b.Categories,
+		Readme:        plan.Readme,
+		ReadmeURL:     b.ReadmeUrl,
+		Icon:          b.Icon,
+	}
+	for _, app := range b.GuiApps {
+		entry.GuiApps = append(entry.GuiApps, CatalogApp{Name: app.Name, Exec: app.Exec})
+	}
+	for _, d := range def.FinalStage() {
+		env, ok := d.Directive.(ir.EnvironmentDirective)
+		if !ok {
+			continue
+		}
+		if v, ok := env["DEPLOY_BINS"]; ok {
+			entry.Deploy.Bins = strings.Split(v, ":")
+		}
+		if v, ok := env["DEPLOY_PATH"]; ok {
+			entry.Deploy.Path = strings.Split(v, ":")
+		}
+	}
+	return entry, nil
+}
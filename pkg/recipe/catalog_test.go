@@ -0,0 +1,54 @@
+package recipe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCatalogEntry(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: viewer
+version: 2.1.0
+architectures:
+  - x86_64
+categories:
+  - "data visualisation"
+readme: "{{ context.name }} shows images."
+readme_url: https://example.org/viewer
+gui_apps:
+  - name: viewer-gui
+    exec: viewer
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - deploy:
+        bins: [viewer, "viewer-{{ context.version }}"]
+        path: [/opt/viewer/bin]
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	got, err := build.CatalogEntry(nil)
+	if err != nil {
+		t.Fatalf("CatalogEntry: %v", err)
+	}
+	want := &CatalogEntry{
+		Name:          "viewer",
+		Version:       "2.1.0",
+		Architectures: []CPUArchitecture{CPUArchAMD64},
+		Categories:    []Category{"data visualisation"},
+		Readme:        "viewer shows images.\n",
+		ReadmeURL:     "https://example.org/viewer",
+		GuiApps:       []CatalogApp{{Name: "viewer-gui", Exec: "viewer"}},
+		Deploy: CatalogDeploy{
+			Bins: []string{"viewer", "viewer-2.1.0"},
+			Path: []string{"/opt/viewer/bin"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("CatalogEntry =\n%#v\nwant\n%#v", got, want)
+	}
+}
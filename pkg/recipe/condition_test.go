@@ -0,0 +1,75 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/common"
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/jinja2"
+)
+
+func TestDirectiveConditions(t *testing.T) {
+	for _, tc := range []struct {
+		condition string
+		arch      CPUArchitecture
+		locals    []string
+		want      bool
+	}{
+		{`arch == "aarch64"`, "aarch64", nil, true},
+		{`arch == "aarch64"`, "x86_64", nil, false},
+		{`arch != "aarch64"`, "x86_64", nil, true},
+		{`context.arch == "x86_64"`, "x86_64", nil, true},
+		{`options.gpu`, "x86_64", nil, true},
+		{`context.options.jobs > 2`, "x86_64", nil, true},
+		{`not options.gpu`, "x86_64", nil, false},
+		{`flavour == "full" and options.jobs == 4`, "x86_64", nil, true},
+		{`version == "2.0.0"`, "x86_64", nil, true},
+		{`name == "conditional"`, "x86_64", nil, true},
+		{`has_local("data")`, "x86_64", []string{"data"}, true},
+		{`has_local("data")`, "x86_64", nil, false},
+		{`missing is defined`, "x86_64", nil, false},
+		{`"gpu" if options.gpu else ""`, "x86_64", nil, true},
+	} {
+		t.Run(tc.condition+"/"+string(tc.arch), func(t *testing.T) {
+			root := newContext(common.PkgManagerApt, "2.0.0", nil, ir.New(), nil)
+			root.Name = "conditional"
+			root.Arch = tc.arch
+			root.SetVariable("options", map[string]any{"gpu": true, "jobs": 4})
+			root.SetVariable("flavour", "full")
+			for _, k := range tc.locals {
+				if root.locals == nil {
+					root.locals = map[string]struct{}{}
+				}
+				root.locals[k] = struct{}{}
+			}
+
+			// Conditions inside a group must see the enclosing variables.
+			run := RunDirective{"echo applied"}
+			group := GroupDirective{{Condition: tc.condition, Run: &run}}
+			if err := (Directive{Group: &group}).Apply(root); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			def, err := root.Compile()
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			dockerfile, err := ir.GenerateDockerfile(def)
+			if err != nil {
+				t.Fatalf("GenerateDockerfile: %v", err)
+			}
+			if got := strings.Contains(dockerfile, "echo applied"); got != tc.want {
+				t.Fatalf("applied = %v, want %v:\n%s", got, tc.want, dockerfile)
+			}
+		})
+	}
+}
+
+func TestDirectiveConditionErrors(t *testing.T) {
+	ctx := newContext(common.PkgManagerApt, "1.0.0", nil, ir.New(), nil)
+	run := RunDirective{jinja2.TemplateString("echo never")}
+	err := Directive{Condition: `undefined_flag == 1`, Run: &run}.Apply(ctx)
+	if err == nil || !strings.Contains(err.Error(), `evaluating condition "undefined_flag == 1"`) {
+		t.Fatalf("error = %v", err)
+	}
+}
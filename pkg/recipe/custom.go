@@ -0,0 +1,87 @@
+package recipe
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// CustomDirectiveHandler implements a `custom: <name>` directive. Params are
+// the directive's customParams as written in the recipe; handlers render
+// template strings themselves (ctx.EvaluateValue) and add to the build with
+// ctx.AddDirective or ctx.InstallPackages.
+type CustomDirectiveHandler interface {
+	Validate(params map[string]any) error
+	Apply(ctx *Context, src ir.SourceID, params map[string]any) error
+}
+
+var (
+	customDirectivesMu sync.RWMutex
+	customDirectives   = map[string]CustomDirectiveHandler{}
+)
+
+// RegisterCustomDirective makes handler available as `custom: <name>`. It
+// is meant to be called from init functions of programs embedding the
+// builder, and fails if name is already registered.
+func RegisterCustomDirective(name string, handler CustomDirectiveHandler) error {
+	if name == "" || handler == nil {
+		return fmt.Errorf("custom directive needs a name and a handler")
+	}
+	customDirectivesMu.Lock()
+	defer customDirectivesMu.Unlock()
+	if _, ok := customDirectives[name]; ok {
+		return fmt.Errorf("custom directive %q is already registered", name)
+	}
+	customDirectives[name] = handler
+	return nil
+}
+
+// CustomDirectives returns the names of the registered Go handlers.
+func CustomDirectives() []string {
+	customDirectivesMu.RLock()
+	defer customDirectivesMu.RUnlock()
+	names := make([]string, 0, len(customDirectives))
+	for name := range customDirectives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupCustomDirective(name string) (CustomDirectiveHandler, bool) {
+	customDirectivesMu.RLock()
+	defer customDirectivesMu.RUnlock()
+	h, ok := customDirectives[name]
+	return h, ok
+}
+
+// validateCustom checks that a handler exists for name: a registered Go
+// handler, or else <name>.star in the include directories, which is run
+// like a Starlark template with customParams as its params.
+func validateCustom(ctx Context, name string, params map[string]any) error {
+	if h, ok := lookupCustomDirective(name); ok {
+		return h.Validate(params)
+	}
+	if _, ok := findStarlarkTemplate(ctx.specDir(), ctx.IncludeDirectories, name); ok || starlarkPluginPending(ctx) {
+		return nil
+	}
+	return fmt.Errorf("unknown custom directive %q: no registered handler and no %s.star in the include directories", name, name)
+}
+
+func applyCustom(ctx *Context, src ir.SourceID, name string, params map[string]any) error {
+	if h, ok := lookupCustomDirective(name); ok {
+		if err := h.Apply(ctx, src, params); err != nil {
+			return fmt.Errorf("custom directive %q: %w", name, err)
+		}
+		return nil
+	}
+	if path, ok := findStarlarkTemplate(ctx.specDir(), ctx.IncludeDirectories, name); ok {
+		if err := applyStarlarkTemplate(ctx, src, path, params); err != nil {
+			return fmt.Errorf("custom directive %q: %w", name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown custom directive %q: no registered handler and no %s.star in the include directories", name, name)
+}
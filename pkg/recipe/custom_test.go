@@ -0,0 +1,99 @@
+package recipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// symlinkHandler is a custom directive creating the symlinks in
+// customParams.links (link path to target).
+type symlinkHandler struct{}
+
+func (symlinkHandler) Validate(params map[string]any) error {
+	if _, ok := params["links"].(map[string]any); !ok {
+		return fmt.Errorf("links must be a mapping")
+	}
+	return nil
+}
+
+func (symlinkHandler) Apply(ctx *Context, src ir.SourceID, params map[string]any) error {
+	var cmds []any
+	for link, target := range params["links"].(map[string]any) {
+		cmds = append(cmds, fmt.Sprintf("ln -sf %v %s", target, link))
+	}
+	return ctx.AddDirective(src, "run", cmds)
+}
+
+const customRecipe = `name: plugged
+version: 1.0.0
+architectures:
+  - x86_64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - custom: test_symlinks
+      customParams:
+        links:
+          /usr/local/bin/tool: /opt/tool/bin/tool
+    - custom: banner
+      customParams:
+        text: "{{ context.version }}"
+`
+
+func TestCustomDirectivePlugins(t *testing.T) {
+	if err := RegisterCustomDirective("test_symlinks", symlinkHandler{}); err != nil {
+		t.Fatalf("RegisterCustomDirective: %v", err)
+	}
+	if err := RegisterCustomDirective("test_symlinks", symlinkHandler{}); err == nil {
+		t.Fatalf("registering a name twice succeeded")
+	}
+
+	dir := t.TempDir()
+	banner := "def execute(ctx, params):\n    return [{\"run\": [\"echo banner \" + params[\"text\"]]}]\n"
+	if err := os.WriteFile(filepath.Join(dir, "banner.star"), []byte(banner), 0o644); err != nil {
+		t.Fatalf("writing banner.star: %v", err)
+	}
+
+	build, err := loadBuildYAML(t, customRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, _, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{dir}})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	for _, want := range []string{"ln -sf /opt/tool/bin/tool /usr/local/bin/tool", "echo banner 1.0.0"} {
+		if !strings.Contains(dockerfile, want) {
+			t.Fatalf("Dockerfile is missing %q:\n%s", want, dockerfile)
+		}
+	}
+
+	// Without the include directory the Starlark plugin is not found.
+	if _, _, err := build.GenerateWithParams(GenerateParams{}); err == nil || !strings.Contains(err.Error(), `unknown custom directive "banner"`) {
+		t.Fatalf("missing plugin error = %v", err)
+	}
+}
+
+func TestCustomDirectiveValidation(t *testing.T) {
+	if err := RegisterCustomDirective("test_symlinks_validate", symlinkHandler{}); err != nil {
+		t.Fatalf("RegisterCustomDirective: %v", err)
+	}
+	_, err := loadBuildYAML(t, strings.Replace(customRecipe, "custom: test_symlinks\n      customParams:\n        links:\n          /usr/local/bin/tool: /opt/tool/bin/tool\n",
+		"custom: test_symlinks_validate\n      customParams:\n        links: nope\n", 1))
+	if err == nil || !strings.Contains(err.Error(), "links must be a mapping") {
+		t.Fatalf("error = %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package recipe
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// systemDeployDirs are directories every base image has or that package
+// managers install into; deploy paths inside them are not checked.
+var systemDeployDirs = []string{"/bin", "/sbin", "/usr", "/lib", "/lib64"}
+
+// LintDeploy checks the rendered DEPLOY_PATH and DEPLOY_BINS of the recipe
+// against the directives that build its final stage, and returns one
+// message per problem:
+//   - each DEPLOY_PATH directory, or the directory of its install prefix
+//     (e.g. /opt/fsl-6.0.7 for /opt/fsl-6.0.7/bin), must be created or
+//     mentioned by a RUN, COPY or WORKDIR, so a typo is caught before the
+//     image is built and tested;
+//   - DEPLOY_BINS entries must be bare command names.
+//
+// Directories under /usr and the other system directories are assumed to
+// exist. A recipe that does not generate is not checked; LintReadme reports
+// it.
+func (b *BuildFile) LintDeploy(includeDirs []string) []string {
+	def, _, err := b.GenerateWithStaging(includeDirs)
+	if err != nil {
+		return nil
+	}
+	stage := def.FinalStage()
+	env := map[string]string{}
+	for _, dm := range stage {
+		if e, ok := dm.Directive.(ir.EnvironmentDirective); ok {
+			for k, v := range e {
+				env[k] = v
+			}
+		}
+	}
+
+	var issues []string
+	for _, bin := range deployBins(def) {
+		switch {
+		case env["DEPLOY_BINS"] == "":
+			// No bins at all.
+		case bin == "":
+			issues = append(issues, "deploy.bins has an empty entry")
+		case strings.ContainsAny(bin, "/ \t"):
+			issues = append(issues, fmt.Sprintf("deploy.bins entry %q must be a command name; put its directory in deploy.path", bin))
+		}
+	}
+
+	texts := deployEvidence(stage, env)
+	for _, dir := range strings.Split(env["DEPLOY_PATH"], ":") {
+		if dir == "" {
+			continue
+		}
+		expanded := os.Expand(dir, func(k string) string { return env[k] })
+		if !path.IsAbs(expanded) {
+			issues = append(issues, fmt.Sprintf("deploy.path entry %q is not an absolute path", dir))
+			continue
+		}
+		expanded = path.Clean(expanded)
+		if isSystemDeployDir(expanded) || deployDirReferenced(expanded, texts) {
+			continue
+		}
+		issues = append(issues, fmt.Sprintf("deploy.path entry %q: no RUN, COPY or WORKDIR creates or installs into it", dir))
+	}
+	return issues
+}
+
+// deployEvidence returns the text of the directives of stage that can
+// create directories: RUN commands and COPY, WORKDIR and file destinations,
+// with the variables of env expanded.
+func deployEvidence(stage []ir.DirectiveWithMetadata, env map[string]string) []string {
+	var texts []string
+	for _, dm := range stage {
+		switch d := dm.Directive.(type) {
+		case ir.RunDirective:
+			texts = append(texts, string(d))
+		case ir.RunWithMountsDirective:
+			texts = append(texts, d.Command)
+		case ir.CopyDirective:
+			if len(d.Parts) > 0 {
+				texts = append(texts, d.Parts[len(d.Parts)-1])
+			}
+		case ir.CopyFromDirective:
+			texts = append(texts, d.Dest)
+		case ir.LiteralFileDirective:
+			texts = append(texts, d.Name)
+		case ir.WorkDirDirective:
+			texts = append(texts, string(d))
+		}
+	}
+	for i, text := range texts {
+		texts[i] = os.Expand(text, func(k string) string {
+			if v, ok := env[k]; ok {
+				return v
+			}
+			return "${" + k + "}"
+		})
+	}
+	return texts
+}
+
+// deployDirReferenced reports whether a text mentions dir, one of its
+// ancestors below the top-level directory, or a path inside dir.
+func deployDirReferenced(dir string, texts []string) bool {
+	candidates := []string{dir}
+	for p := path.Dir(dir); strings.Count(p, "/") >= 2; p = path.Dir(p) {
+		candidates = append(candidates, p)
+	}
+	for _, text := range texts {
+		for _, c := range candidates {
+			if containsPath(text, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsPath reports whether p occurs in text as a whole path or a path
+// prefix: not preceded or followed by other path name characters.
+func containsPath(text, p string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], p)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(p)
+		if (start == 0 || !isPathNameChar(text[start-1])) && (end == len(text) || !isPathNameChar(text[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isPathNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("._-+", c) >= 0
+}
+
+func isSystemDeployDir(dir string) bool {
+	for _, sys := range systemDeployDirs {
+		if dir == sys || strings.HasPrefix(dir, sys+"/") {
+			return true
+		}
+	}
+	return false
+}
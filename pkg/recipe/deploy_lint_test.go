@@ -0,0 +1,69 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+)
+
+const deployLintRecipe = `name: deployed
+version: 6.0.7
+architectures:
+  - x86_64
+readme: Deployed tools.
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - environment:
+        TOOLDIR: /opt/deployed-{{ context.version }}
+    - run:
+        - curl -fsSL https://example.org/deployed.tar.gz | tar -xz -C ${TOOLDIR}
+    - workdir: /srv/app/data
+    - deploy:
+        bins: [deployed, "bad bin", "sub/tool"]
+        path:
+          - /opt/deployed-{{ context.version }}/bin
+          - $TOOLDIR/lib
+          - /srv/app
+          - /usr/local/deployed/bin
+          - /opt/deployed-6.07/bin
+          - relative/bin
+`
+
+func TestLintDeploy(t *testing.T) {
+	build, err := loadBuildYAML(t, deployLintRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	issues := build.LintDeploy(nil)
+	want := []string{
+		`deploy.bins entry "bad bin" must be a command name; put its directory in deploy.path`,
+		`deploy.bins entry "sub/tool" must be a command name; put its directory in deploy.path`,
+		`deploy.path entry "/opt/deployed-6.07/bin": no RUN, COPY or WORKDIR creates or installs into it`,
+		`deploy.path entry "relative/bin" is not an absolute path`,
+	}
+	if strings.Join(issues, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("issues = %q, want %q", issues, want)
+	}
+}
+
+func TestContainsPath(t *testing.T) {
+	for _, tc := range []struct {
+		text, path string
+		want       bool
+	}{
+		{"mkdir -p /opt/tool", "/opt/tool", true},
+		{"tar -C /opt/tool/bin", "/opt/tool", true},
+		{"cp x '/opt/tool'", "/opt/tool", true},
+		{"mkdir -p /opt/tool-2", "/opt/tool", false},
+		{"mkdir -p /srv/opt/tool", "/opt/tool", false},
+		{"mkdir /opt/toolbox /opt/tool", "/opt/tool", true},
+	} {
+		if got := containsPath(tc.text, tc.path); got != tc.want {
+			t.Fatalf("containsPath(%q, %q) = %v, want %v", tc.text, tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package recipe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// FormatBuildFile returns the canonical form of the build.yaml src:
+//   - keys of the recipe's own mappings (the recipe, build, stages,
+//     directives and their settings) in the order of the fields of the types
+//     they decode into, followed by unknown keys as written; user mappings
+//     such as variables keep their order;
+//   - two-space indentation, with lists indented under their keys;
+//   - multi-line run commands as literal block scalars.
+//
+// It works on the YAML tree, so comments are kept with the keys and values
+// they are attached to. Formatting a formatted file does not change it.
+func FormatBuildFile(src []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(src, &doc); err != nil {
+		return nil, fmt.Errorf("parsing build file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode || len(doc.Content[0].Content) == 0 {
+		return nil, errors.New("build file is empty or not a mapping")
+	}
+	root := doc.Content[0]
+	first := root.Content[0]
+	formatNode(root, reflect.TypeFor[BuildFile]())
+	// A comment at the top of the file stays there, whichever key it was
+	// attached to.
+	if root.Content[0] != first {
+		root.Content[0].HeadComment = joinComments(first.HeadComment, root.Content[0].HeadComment)
+		first.HeadComment = ""
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("formatting build file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("formatting build file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// formatNode puts node, which decodes into t, in canonical form.
+func formatNode(node *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case node.Kind == yaml.SequenceNode && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for _, item := range node.Content {
+			formatNode(item, t.Elem())
+		}
+		if t == reflect.TypeFor[RunDirective]() {
+			for _, item := range node.Content {
+				if item.Kind == yaml.ScalarNode && strings.Contains(strings.TrimRight(item.Value, "\n"), "\n") {
+					item.Style = yaml.LiteralStyle
+				}
+			}
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 1; i < len(node.Content); i += 2 {
+			formatNode(node.Content[i], t.Elem())
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := yamlFields(t)
+		rank := func(key *yaml.Node) int {
+			if i := slices.IndexFunc(fields, func(f reflect.StructField) bool { return yamlName(f) == key.Value }); i >= 0 {
+				return i
+			}
+			return len(fields)
+		}
+		pairs := make([][2]*yaml.Node, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			pairs = append(pairs, [2]*yaml.Node{node.Content[i], node.Content[i+1]})
+		}
+		slices.SortStableFunc(pairs, func(a, b [2]*yaml.Node) int {
+			return rank(a[0]) - rank(b[0])
+		})
+		node.Content = node.Content[:0]
+		for _, p := range pairs {
+			node.Content = append(node.Content, p[0], p[1])
+			if r := rank(p[0]); r < len(fields) {
+				formatNode(p[1], fields[r].Type)
+			}
+		}
+	}
+}
+
+// yamlFields returns the fields of the struct t that YAML keys decode into,
+// in declaration order, with those of inlined structs in place.
+func yamlFields(t reflect.Type) []reflect.StructField {
+	var out []reflect.StructField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		if strings.Contains(tag, ",inline") {
+			if f.Type.Kind() == reflect.Struct {
+				out = append(out, yamlFields(f.Type)...)
+			}
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// yamlName returns the key of the struct field f.
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+func joinComments(a, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "\n" + b
+}
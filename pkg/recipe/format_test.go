@@ -0,0 +1,93 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.yaml.in/yaml/v4"
+)
+
+const unformattedRecipe = `# Tool recipe.
+version: 1.0.0 # upstream release
+name: tool
+build:
+    directives:
+    - run:
+      - "apt-get update &&\n  apt-get install -y curl\n"
+      - echo done
+      condition: arch == "x86_64"
+    # The tool itself.
+    - install: [git]
+    pkg-manager: apt
+    kind: neurodocker
+    base-image: ubuntu:24.04
+variables:
+  zeta: 1
+  alpha: 2
+architectures: [x86_64]
+`
+
+const formattedRecipe = `# Tool recipe.
+name: tool
+version: 1.0.0 # upstream release
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - run:
+        - |
+          apt-get update &&
+            apt-get install -y curl
+        - echo done
+      condition: arch == "x86_64"
+    # The tool itself.
+    - install: [git]
+variables:
+  zeta: 1
+  alpha: 2
+`
+
+func TestFormatBuildFile(t *testing.T) {
+	out, err := FormatBuildFile([]byte(unformattedRecipe))
+	if err != nil {
+		t.Fatalf("FormatBuildFile: %v", err)
+	}
+	if string(out) != formattedRecipe {
+		t.Fatalf("formatted recipe:\n%s\nwant:\n%s", out, formattedRecipe)
+	}
+}
+
+func TestFormatKeepsRecipesAndIsStable(t *testing.T) {
+	paths, err := filepath.Glob("testdata/golden/*/build.yaml")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no golden recipes: %v", err)
+	}
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		out, err := FormatBuildFile(src)
+		if err != nil {
+			t.Fatalf("formatting %s: %v", path, err)
+		}
+		var before, after any
+		if err := yaml.Unmarshal(src, &before); err != nil {
+			t.Fatalf("parsing %s: %v", path, err)
+		}
+		if err := yaml.Unmarshal(out, &after); err != nil {
+			t.Fatalf("parsing formatted %s: %v", path, err)
+		}
+		if !reflect.DeepEqual(before, after) {
+			t.Fatalf("formatting %s changed its contents:\n%s", path, out)
+		}
+		again, err := FormatBuildFile(out)
+		if err != nil || string(again) != string(out) {
+			t.Fatalf("formatting %s twice gave a different result (%v):\n%s", path, err, again)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package recipe
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden Dockerfiles under testdata/golden")
+
+// TestGoldenDockerfiles renders each recipe under testdata/golden several
+// times and compares the output with the checked-in Dockerfile.golden. Run
+// `go test ./pkg/recipe -run TestGoldenDockerfiles -update` to refresh them.
+func TestGoldenDockerfiles(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	if err != nil {
+		t.Fatalf("listing golden recipes: %v", err)
+	}
+	if len(dirs) == 0 {
+		t.Fatalf("no golden recipes found")
+	}
+
+	for _, dir := range dirs {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			var first string
+			for i := 0; i < 5; i++ {
+				build, err := LoadBuildFile(dir)
+				if err != nil {
+					t.Fatalf("loading build file: %v", err)
+				}
+				def, _, err := build.GenerateWithStaging(nil)
+				if err != nil {
+					t.Fatalf("generating build: %v", err)
+				}
+				dockerfile, err := ir.GenerateDockerfile(def)
+				if err != nil {
+					t.Fatalf("rendering dockerfile: %v", err)
+				}
+				if i == 0 {
+					if err := ir.VerifyDockerfile(def, dockerfile); err != nil {
+						t.Fatalf("verifying dockerfile: %v", err)
+					}
+					first = dockerfile
+				} else if dockerfile != first {
+					t.Fatalf("dockerfile differs between runs:\n--- run 1\n%s\n--- run %d\n%s", first, i+1, dockerfile)
+				}
+			}
+
+			goldenPath := filepath.Join(dir, "Dockerfile.golden")
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, []byte(first), 0o644); err != nil {
+					t.Fatalf("writing golden file: %v", err)
+				}
+				return
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("reading golden file (run with -update to create it): %v", err)
+			}
+			if string(want) != first {
+				t.Fatalf("dockerfile does not match %s (run with -update to refresh):\n--- got\n%s\n--- want\n%s", goldenPath, first, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package recipe
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/common"
+)
+
+// GPUInfo is the recipe's `gpu:` block. When GPU mode is on, the main image
+// is built FROM an nvidia/cuda image instead of build.base-image, gets the
+// NVIDIA container runtime environment and is tagged with a "-gpu" suffix.
+type GPUInfo struct {
+	// CUDA is the full CUDA version of the base image, e.g. "12.4.1".
+	CUDA string `yaml:"cuda"`
+	// CuDNN is the cuDNN major version ("8" or "9"), or empty for none.
+	CuDNN string `yaml:"cudnn,omitempty"`
+	// Flavor selects the base, runtime (default) or devel image.
+	Flavor string `yaml:"flavor,omitempty"`
+	// OS is the distribution part of the tag, e.g. "ubuntu22.04". It
+	// defaults to the one matching build.base-image.
+	OS string `yaml:"os,omitempty"`
+	// Option names a boolean option that turns GPU mode on. Without it
+	// GPU mode is always on.
+	Option string `yaml:"option,omitempty"`
+}
+
+// GPUImageRepository is the image repository GPU mode builds from.
+const GPUImageRepository = "nvidia/cuda"
+
+// GPUVersionSuffix is appended to the image version in GPU mode.
+const GPUVersionSuffix = "-gpu"
+
+var (
+	cudaVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
+	cudaOSPattern      = regexp.MustCompile(`^(ubuntu\d+\.\d+|rockylinux\d+|ubi\d+)$`)
+	ubuntuTagPattern   = regexp.MustCompile(`^\d+\.\d+$`)
+)
+
+// cudnnCUDARange lists, per cuDNN major version, the CUDA versions
+// (major*100+minor, inclusive) that nvidia/cuda publishes images for.
+var cudnnCUDARange = map[string][2]int{
+	"8": {1100, 1202},
+	"9": {1203, 1299},
+}
+
+func (g *GPUInfo) flavor() string {
+	if g.Flavor == "" {
+		return "runtime"
+	}
+	return g.Flavor
+}
+
+// cudaMajorMinor returns the CUDA version as major*100+minor.
+func (g *GPUInfo) cudaMajorMinor() (int, string, bool) {
+	m := cudaVersionPattern.FindStringSubmatch(g.CUDA)
+	if m == nil {
+		return 0, "", false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	return major*100 + minor, m[1] + "." + m[2], true
+}
+
+// Validate checks the block against the rest of the build file: the CUDA
+// and cuDNN versions must have a published image, and a gating option must
+// be a declared boolean.
+func (g *GPUInfo) Validate(b *BuildFile) error {
+	if g == nil {
+		return nil
+	}
+	version, _, ok := g.cudaMajorMinor()
+	if !ok {
+		return fmt.Errorf("gpu.cuda must be a full CUDA version such as 12.4.1, got %q", g.CUDA)
+	}
+	if version < 1100 || version >= 1300 {
+		return fmt.Errorf("gpu.cuda %s is not supported (want 11.x or 12.x)", g.CUDA)
+	}
+	switch g.flavor() {
+	case "base", "runtime", "devel":
+	default:
+		return fmt.Errorf("gpu.flavor must be one of base, runtime or devel, got %q", g.Flavor)
+	}
+	if g.CuDNN != "" {
+		r, ok := cudnnCUDARange[g.CuDNN]
+		if !ok {
+			return fmt.Errorf("gpu.cudnn must be 8 or 9, got %q", g.CuDNN)
+		}
+		if version < r[0] || version > r[1] {
+			return fmt.Errorf("gpu.cudnn %s is not available for CUDA %s (needs CUDA %d.%d to %d.%d)",
+				g.CuDNN, g.CUDA, r[0]/100, r[0]%100, r[1]/100, r[1]%100)
+		}
+		if g.flavor() == "base" {
+			return fmt.Errorf("gpu.cudnn requires the runtime or devel flavor")
+		}
+	}
+	if g.OS != "" {
+		if !cudaOSPattern.MatchString(g.OS) {
+			return fmt.Errorf("gpu.os must look like ubuntu22.04, rockylinux9 or ubi9, got %q", g.OS)
+		}
+		if pm := b.Build.PackageManager; pm != "" && pm != packageManagerForCUDAOS(g.OS) {
+			return fmt.Errorf("gpu.os %s does not match build.pkg-manager %s", g.OS, pm)
+		}
+	}
+	if g.Option != "" {
+		opt, ok := b.Options[g.Option]
+		if !ok {
+			return fmt.Errorf("gpu.option %q is not a declared option", g.Option)
+		}
+		if _, isBool := opt.Default.(bool); opt.Default != nil && !isBool {
+			return fmt.Errorf("gpu.option %q must be a boolean option", g.Option)
+		}
+		if opt.VersionSuffix != "" {
+			return fmt.Errorf("gpu.option %q must not set version_suffix; GPU mode adds %s", g.Option, GPUVersionSuffix)
+		}
+	}
+	return nil
+}
+
+func packageManagerForCUDAOS(dist string) common.PackageManager {
+	if strings.HasPrefix(dist, "ubuntu") {
+		return common.PkgManagerApt
+	}
+	return common.PkgManagerYum
+}
+
+// Image returns the nvidia/cuda image to build from. baseImage is the
+// rendered build.base-image, used to pick the OS when gpu.os is not set.
+func (g *GPUInfo) Image(baseImage string) (string, error) {
+	dist := g.OS
+	if dist == "" {
+		var ok bool
+		if dist, ok = cudaOSForImage(baseImage); !ok {
+			return "", fmt.Errorf("cannot derive gpu.os from base image %q; set gpu.os", baseImage)
+		}
+	}
+	parts := []string{g.CUDA}
+	switch g.CuDNN {
+	case "8":
+		parts = append(parts, "cudnn8")
+	case "9":
+		parts = append(parts, "cudnn")
+	}
+	parts = append(parts, g.flavor(), dist)
+	return GPUImageRepository + ":" + strings.Join(parts, "-"), nil
+}
+
+// cudaOSForImage maps an ubuntu or rockylinux base image to the matching
+// nvidia/cuda OS tag, e.g. ubuntu:22.04 to ubuntu22.04. A digest the image
+// is pinned to is ignored.
+func cudaOSForImage(image string) (string, bool) {
+	image, _ = SplitImageDigest(image)
+	repo, tag, ok := strings.Cut(image, ":")
+	if !ok {
+		return "", false
+	}
+	switch repo {
+	case "ubuntu", "docker.io/library/ubuntu":
+		if ubuntuTagPattern.MatchString(tag) {
+			return "ubuntu" + tag, true
+		}
+	case "rockylinux", "rockylinux/rockylinux", "docker.io/library/rockylinux":
+		major, _, _ := strings.Cut(tag, ".")
+		if _, err := strconv.Atoi(major); err == nil {
+			return "rockylinux" + major, true
+		}
+	}
+	return "", false
+}
+
+// Environment returns the variables the NVIDIA container runtime reads.
+func (g *GPUInfo) Environment() map[string]string {
+	_, majorMinor, _ := g.cudaMajorMinor()
+	return map[string]string{
+		"NVIDIA_VISIBLE_DEVICES":     "all",
+		"NVIDIA_DRIVER_CAPABILITIES": "compute,utility",
+		"NVIDIA_REQUIRE_CUDA":        "cuda>=" + majorMinor,
+	}
+}
+
+// GPUEnabled reports whether GPU mode is on for the resolved option values.
+func (b *BuildFile) GPUEnabled(values map[string]any) bool {
+	if b.GPU == nil {
+		return false
+	}
+	return b.GPU.Option == "" || optionEnabled(values[b.GPU.Option])
+}
@@ -0,0 +1,110 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const gpuRecipe = `name: gpu-tool
+version: 1.0.0
+architectures:
+  - x86_64
+options:
+  cuda:
+    description: Build the CUDA variant
+gpu:
+  cuda: 11.8.0
+  cudnn: "8"
+  flavor: devel
+  option: cuda
+build:
+  kind: neurodocker
+  base-image: ubuntu:20.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+`
+
+func TestGPUModeFollowsOption(t *testing.T) {
+	build, err := loadBuildYAML(t, gpuRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+
+	generate := func(opts map[string]string) string {
+		t.Helper()
+		def, _, err := build.GenerateWithParams(GenerateParams{Options: opts})
+		if err != nil {
+			t.Fatalf("GenerateWithParams: %v", err)
+		}
+		df, err := ir.GenerateDockerfile(def)
+		if err != nil {
+			t.Fatalf("GenerateDockerfile: %v", err)
+		}
+		return df
+	}
+
+	cpu := generate(nil)
+	if !strings.Contains(cpu, "FROM ubuntu:20.04") || strings.Contains(cpu, "NVIDIA_") {
+		t.Fatalf("CPU variant:\n%s", cpu)
+	}
+	gpu := generate(map[string]string{"cuda": "true"})
+	for _, want := range []string{
+		"FROM nvidia/cuda:11.8.0-cudnn8-devel-ubuntu20.04",
+		`NVIDIA_VISIBLE_DEVICES="all"`,
+		`NVIDIA_REQUIRE_CUDA="cuda>=11.8"`,
+		`org.opencontainers.image.version="1.0.0-gpu"`,
+	} {
+		if !strings.Contains(gpu, want) {
+			t.Fatalf("GPU variant missing %q:\n%s", want, gpu)
+		}
+	}
+
+	variants, err := build.Matrix(nil, nil)
+	if err != nil {
+		t.Fatalf("Matrix: %v", err)
+	}
+	if len(variants) != 2 || variants[0].ImageVersion != "1.0.0" || variants[1].ImageVersion != "1.0.0-gpu" {
+		t.Fatalf("variants = %+v", variants)
+	}
+}
+
+func TestGPUBlockIsValidated(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, want string
+	}{
+		{"cuda: 11.8.0", "cuda: 11.8", "gpu.cuda must be a full CUDA version"},
+		{"cuda: 11.8.0", "cuda: 10.2.89", "gpu.cuda 10.2.89 is not supported"},
+		{`cudnn: "8"`, `cudnn: "9"`, "gpu.cudnn 9 is not available for CUDA 11.8.0"},
+		{"flavor: devel", "flavor: base", "gpu.cudnn requires the runtime or devel flavor"},
+		{"flavor: devel", "flavor: slim", "gpu.flavor must be one of"},
+		{"option: cuda", "option: gpu", `gpu.option "gpu" is not a declared option`},
+		{"    description: Build the CUDA variant", "    version_suffix: -cuda", "must not set version_suffix"},
+		{"  option: cuda", "  option: cuda\n  os: rockylinux9", "gpu.os rockylinux9 does not match build.pkg-manager apt"},
+	} {
+		_, err := loadBuildYAML(t, strings.Replace(gpuRecipe, tc.from, tc.to, 1))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: error = %v, want %q", tc.to, err, tc.want)
+		}
+	}
+}
+
+func TestGPUImageDerivesOS(t *testing.T) {
+	g := &GPUInfo{CUDA: "12.2.2"}
+	for base, want := range map[string]string{
+		"ubuntu:22.04":             "nvidia/cuda:12.2.2-runtime-ubuntu22.04",
+		"ubuntu:22.04@sha256:0e5e": "nvidia/cuda:12.2.2-runtime-ubuntu22.04",
+		"rockylinux:9.3":           "nvidia/cuda:12.2.2-runtime-rockylinux9",
+		"rockylinux/rockylinux:8":  "nvidia/cuda:12.2.2-runtime-rockylinux8",
+	} {
+		got, err := g.Image(base)
+		if err != nil || got != want {
+			t.Fatalf("Image(%q) = %q, %v; want %q", base, got, err, want)
+		}
+	}
+	if _, err := g.Image("debian:12"); err == nil {
+		t.Fatalf("expected an error deriving the OS from debian:12")
+	}
+}
@@ -0,0 +1,285 @@
+package recipe
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/neurodesk/builder/pkg/common"
+	"github.com/neurodesk/builder/pkg/jinja2"
+)
+
+// ImportDockerfile converts a Dockerfile into an equivalent build file named
+// name at version. Earlier stages become build.stages and the last stage
+// the main image. Instructions with no recipe equivalent, and flags that
+// would be lost, are reported as warnings rather than failing the import.
+func ImportDockerfile(r io.Reader, name, version string) (*BuildFile, []string, error) {
+	result, err := parser.Parse(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing Dockerfile: %w", err)
+	}
+	stages, metaArgs, err := instructions.Parse(result.AST, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing Dockerfile: %w", err)
+	}
+	if len(stages) == 0 {
+		return nil, nil, fmt.Errorf("Dockerfile has no FROM instruction")
+	}
+
+	imp := &dockerfileImporter{stageNames: map[string]string{}}
+	if len(metaArgs) > 0 {
+		imp.warnf("line %d: ARG before FROM is not supported; substitute its value into the base image", metaArgs[0].Location()[0].Start.Line)
+	}
+
+	build := &BuildFile{
+		Name:          name,
+		Version:       version,
+		Architectures: []CPUArchitecture{CPUArchAMD64},
+	}
+	disabled := false
+	for i, stage := range stages {
+		base := stage.BaseName
+		if strings.Contains(base, "$") {
+			imp.warnf("stage %d: base image %q uses a build argument", i, base)
+		}
+		if stage.Platform != "" {
+			imp.warnf("stage %d: --platform=%s dropped; use architectures instead", i, stage.Platform)
+		}
+		directives := imp.convertStage(stage)
+
+		if i == len(stages)-1 {
+			build.Build = BuildRecipe{
+				Kind:               BuildKindNeuroDocker,
+				BaseImage:          base,
+				PackageManager:     guessPackageManager(base),
+				Stages:             build.Build.Stages,
+				Directives:         directives,
+				AddDefaultTemplate: &disabled,
+				AddTzdata:          &disabled,
+			}
+			break
+		}
+		stageName := imp.registerStage(stage.Name, i)
+		build.Build.Stages = append(build.Build.Stages, StageRecipe{
+			Name:           stageName,
+			BaseImage:      base,
+			PackageManager: guessPackageManager(base),
+			Directives:     directives,
+		})
+	}
+
+	if err := build.Validate(Context{}); err != nil {
+		return nil, imp.warnings, fmt.Errorf("imported recipe is invalid: %w", err)
+	}
+	return build, imp.warnings, nil
+}
+
+type dockerfileImporter struct {
+	// stageNames maps Dockerfile stage names and indexes to recipe stage
+	// names.
+	stageNames map[string]string
+	warnings   []string
+}
+
+func (imp *dockerfileImporter) warnf(format string, args ...any) {
+	imp.warnings = append(imp.warnings, fmt.Sprintf(format, args...))
+}
+
+// registerStage records the recipe name of stage index i, deriving one
+// that satisfies stageNamePattern.
+func (imp *dockerfileImporter) registerStage(name string, i int) string {
+	recipeName := strings.ToLower(name)
+	if !stageNamePattern.MatchString(recipeName) {
+		recipeName = fmt.Sprintf("stage%d", i)
+	}
+	if name != "" {
+		imp.stageNames[strings.ToLower(name)] = recipeName
+	}
+	imp.stageNames[strconv.Itoa(i)] = recipeName
+	return recipeName
+}
+
+func (imp *dockerfileImporter) convertStage(stage instructions.Stage) []Directive {
+	var out []Directive
+	for _, cmd := range stage.Commands {
+		line := 0
+		if loc := cmd.Location(); len(loc) > 0 {
+			line = loc[0].Start.Line
+		}
+		out = append(out, imp.convertCommand(cmd, line)...)
+	}
+	return out
+}
+
+func (imp *dockerfileImporter) convertCommand(cmd instructions.Command, line int) []Directive {
+	switch c := cmd.(type) {
+	case *instructions.RunCommand:
+		if len(c.Files) > 0 {
+			imp.warnf("line %d: RUN with a heredoc is not supported", line)
+			return nil
+		}
+		if len(c.FlagsUsed) > 0 {
+			imp.warnf("line %d: RUN flags dropped: --%s", line, strings.Join(c.FlagsUsed, ", --"))
+		}
+		run := RunDirective{templateLiteral(commandLine(c.ShellDependantCmdLine))}
+		return []Directive{{Run: &run}}
+
+	case *instructions.EnvCommand:
+		env := EnvironmentDirective{}
+		for _, kv := range c.Env {
+			env[kv.Key] = templateLiteral(kv.Value)
+		}
+		return []Directive{{Environment: &env}}
+
+	case *instructions.LabelCommand:
+		labels := LabelsDirective{}
+		for _, kv := range c.Labels {
+			labels[kv.Key] = templateLiteral(kv.Value)
+		}
+		return []Directive{{Labels: &labels}}
+
+	case *instructions.ArgCommand:
+		args := ArgsDirective{}
+		for _, kv := range c.Args {
+			if kv.Value == nil {
+				args[kv.Key] = nil
+				continue
+			}
+			val := templateLiteral(*kv.Value)
+			args[kv.Key] = &val
+		}
+		return []Directive{{Args: &args}}
+
+	case *instructions.CopyCommand:
+		if c.Chown != "" || c.Chmod != "" || c.Link || c.Parents || len(c.ExcludePatterns) > 0 {
+			imp.warnf("line %d: COPY flags dropped", line)
+		}
+		if len(c.SourceContents) > 0 {
+			imp.warnf("line %d: COPY with a heredoc is not supported", line)
+			return nil
+		}
+		if c.From != "" {
+			stage, ok := imp.stageNames[strings.ToLower(c.From)]
+			if !ok {
+				imp.warnf("line %d: COPY --from=%s does not name an earlier stage", line, c.From)
+				return nil
+			}
+			return []Directive{{CopyFrom: &CopyFromDirective{
+				Stage: stage,
+				Src:   toAnySlice(c.SourcePaths),
+				Dest:  templateLiteral(c.DestPath),
+			}}}
+		}
+		var cp CopyDirective = toAnySlice(append(append([]string{}, c.SourcePaths...), c.DestPath))
+		return []Directive{{Copy: &cp}}
+
+	case *instructions.AddCommand:
+		if len(c.SourcePaths) == 1 && isRemoteSource(c.SourcePaths[0]) {
+			// A remote ADD becomes a downloaded file copied into place.
+			src := c.SourcePaths[0]
+			fileName := path.Base(strings.SplitN(src, "?", 2)[0])
+			file := FileDirective{Name: jinja2.TemplateString(fileName), Url: templateLiteral(src)}
+			var cp CopyDirective = []any{fileName, c.DestPath}
+			return []Directive{{File: &file}, {Copy: &cp}}
+		}
+		imp.warnf("line %d: ADD converted to copy; archives are no longer extracted", line)
+		var cp CopyDirective = toAnySlice(append(append([]string{}, c.SourcePaths...), c.DestPath))
+		return []Directive{{Copy: &cp}}
+
+	case *instructions.WorkdirCommand:
+		w := WorkDirDirective(templateLiteral(c.Path))
+		return []Directive{{WorkDir: &w}}
+
+	case *instructions.UserCommand:
+		u := UserDirective(templateLiteral(c.User))
+		return []Directive{{User: &u}}
+
+	case *instructions.EntrypointCommand:
+		e := EntryPointDirective(templateLiteral(commandLine(c.ShellDependantCmdLine)))
+		return []Directive{{EntryPoint: &e}}
+
+	case *instructions.CmdCommand:
+		var cmdDirective CmdDirective
+		if c.PrependShell {
+			cmdDirective = toAnySlice([]string{"/bin/sh", "-c", strings.Join(c.CmdLine, " ")})
+		} else {
+			cmdDirective = toAnySlice(c.CmdLine)
+		}
+		return []Directive{{Cmd: &cmdDirective}}
+
+	case *instructions.ExposeCommand:
+		var e ExposeDirective = toAnySlice(c.Ports)
+		return []Directive{{Expose: &e}}
+
+	case *instructions.VolumeCommand:
+		var vol VolumesDirective = toAnySlice(c.Volumes)
+		return []Directive{{Volumes: &vol}}
+
+	case *instructions.ShellCommand:
+		var sh ShellDirective = toAnySlice(c.Shell)
+		return []Directive{{Shell: &sh}}
+	}
+
+	imp.warnf("line %d: %s is not supported and was skipped", line, strings.ToUpper(cmd.Name()))
+	return nil
+}
+
+// commandLine returns a RUN or ENTRYPOINT command as a single shell
+// command; exec-form arguments are quoted.
+func commandLine(c instructions.ShellDependantCmdLine) string {
+	if c.PrependShell {
+		return strings.Join(c.CmdLine, " ")
+	}
+	quoted := make([]string, len(c.CmdLine))
+	for i, arg := range c.CmdLine {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`!*?[](){}<>|&;#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// templateDelimiters escapes template delimiters in Dockerfile text.
+var templateDelimiters = strings.NewReplacer(
+	"{{", "{{ '{{' }}",
+	"{%", "{{ '{%' }}",
+	"{#", "{{ '{#' }}",
+)
+
+// templateLiteral returns s as a template that renders to s.
+func templateLiteral(s string) jinja2.TemplateString {
+	return jinja2.TemplateString(templateDelimiters.Replace(s))
+}
+
+func isRemoteSource(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+func toAnySlice(items []string) []any {
+	out := make([]any, len(items))
+	for i, item := range items {
+		out[i] = item
+	}
+	return out
+}
+
+// guessPackageManager picks yum for Red Hat family base images and apt
+// otherwise.
+func guessPackageManager(image string) common.PackageManager {
+	lower := strings.ToLower(image)
+	for _, family := range []string{"centos", "fedora", "rocky", "alma", "rhel", "ubi", "amazonlinux", "oraclelinux"} {
+		if strings.Contains(lower, family) {
+			return common.PkgManagerYum
+		}
+	}
+	return common.PkgManagerApt
+}
@@ -0,0 +1,101 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"go.yaml.in/yaml/v4"
+)
+
+const legacyDockerfile = `FROM golang:1.22 AS build
+WORKDIR /src
+COPY . .
+RUN go build -o /out/tool ./cmd/tool
+
+FROM ubuntu:22.04
+ENV PATH=/opt/tool/bin:$PATH LANG=C.UTF-8
+RUN apt-get update && \
+    apt-get install -y curl
+RUN ["echo", "hello world"]
+RUN echo "{{ not a template }}"
+COPY --from=build /out/tool /opt/tool/bin/tool
+ADD https://example.org/data.tar.gz /opt/data/
+COPY --chown=1000 app.sh /usr/local/bin/
+USER 1000
+EXPOSE 8080
+STOPSIGNAL SIGTERM
+ENTRYPOINT ["/usr/local/bin/app.sh"]
+CMD ["--help"]
+`
+
+func TestImportDockerfile(t *testing.T) {
+	build, warnings, err := ImportDockerfile(strings.NewReader(legacyDockerfile), "legacy", "1.0.0")
+	if err != nil {
+		t.Fatalf("ImportDockerfile: %v", err)
+	}
+	if build.Build.BaseImage != "ubuntu:22.04" || len(build.Build.Stages) != 1 || build.Build.Stages[0].Name != "build" {
+		t.Fatalf("unexpected build: %+v", build.Build)
+	}
+
+	wantWarnings := []string{"COPY flags dropped", "STOPSIGNAL is not supported"}
+	if len(warnings) != len(wantWarnings) {
+		t.Fatalf("warnings = %q, want %d", warnings, len(wantWarnings))
+	}
+	for i, want := range wantWarnings {
+		if !strings.Contains(warnings[i], want) {
+			t.Fatalf("warning %d = %q, want it to contain %q", i, warnings[i], want)
+		}
+	}
+
+	// The emitted YAML must load back as a recipe and generate the same
+	// instructions.
+	out, err := yaml.Marshal(build)
+	if err != nil {
+		t.Fatalf("yaml.Marshal: %v", err)
+	}
+	loaded, err := loadBuildYAML(t, string(out))
+	if err != nil {
+		t.Fatalf("loading imported recipe: %v\n%s", err, out)
+	}
+	def, err := loaded.Generate([]string{t.TempDir()})
+	if err != nil {
+		t.Fatalf("Generate: %v\n%s", err, out)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	for _, want := range []string{
+		"FROM golang:1.22 AS build",
+		"FROM ubuntu:22.04",
+		"apt-get install -y curl",
+		"echo 'hello world'",
+		`echo \"{{ not a template }}\"`,
+		`COPY --from=build "/out/tool" "/opt/tool/bin/tool"`,
+		`COPY "cache/data.tar.gz" "/opt/data/"`,
+		"USER 1000",
+		"EXPOSE 8080",
+		"/usr/local/bin/app.sh",
+		`CMD ["--help"]`,
+	} {
+		if !strings.Contains(dockerfile, want) {
+			t.Fatalf("Dockerfile missing %q:\n%s\nrecipe:\n%s", want, dockerfile, out)
+		}
+	}
+}
+
+func TestImportDockerfileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		dockerfile string
+		wantErr    string
+	}{
+		{"", "parsing Dockerfile"},
+		{"ARG X=1\n", "no FROM"},
+		{"FROM ubuntu:22.04\nEXPOSE 70000\n", "invalid port"},
+	} {
+		if _, _, err := ImportDockerfile(strings.NewReader(tc.dockerfile), "x", "1.0.0"); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("%q: expected error containing %q, got %v", tc.dockerfile, tc.wantErr, err)
+		}
+	}
+}
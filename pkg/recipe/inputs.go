@@ -0,0 +1,49 @@
+package recipe
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/neurodesk/builder/pkg/resolve"
+)
+
+// inputs lists the files the generation in ctx read, for StagingPlan.Inputs.
+func (b *BuildFile) inputs(ctx *Context, plan *StagingPlan) []string {
+	set := map[string]struct{}{}
+	for path := range ctx.root().inputs {
+		set[path] = struct{}{}
+	}
+	if b.dir != "" {
+		set[filepath.Join(b.dir, "build.yaml")] = struct{}{}
+	}
+	if b.overridePath != "" {
+		set[b.overridePath] = struct{}{}
+	}
+	// Host files are looked up as the stager does; a missing one is an
+	// error there, not here.
+	resolver := resolve.Resolver{RecipeDir: b.dir, IncludeDirs: ctx.IncludeDirectories, AllowAbsolute: true}
+	for _, files := range [][]StagedFile{plan.Files, plan.TestData} {
+		for _, f := range files {
+			if f.HostFilename == "" {
+				continue
+			}
+			path, tree, err := resolver.FindTree("file", f.HostFilename)
+			if err != nil {
+				continue
+			}
+			if tree == nil {
+				set[path] = struct{}{}
+			}
+			for _, m := range tree {
+				set[m.Path] = struct{}{}
+			}
+		}
+	}
+
+	out := make([]string, 0, len(set))
+	for path := range set {
+		out = append(out, path)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,111 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestStagingPlanInputs(t *testing.T) {
+	inc := t.TempDir()
+	for name, contents := range map[string]string{
+		"common.yaml": "directives:\n  - starlark:\n      file: setup.star\n",
+		"setup.star":  "load(\"lib.star\", \"greeting\")\nrun(\"echo \" + greeting)\n",
+		"lib.star":    "greeting = \"hi\"\n",
+		"unused.yaml": "directives: []\n",
+		"vars.yaml":   "tool_version: \"2.0\"\n",
+	} {
+		if err := os.WriteFile(filepath.Join(inc, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	dir := writeRecipeFiles(t, map[string]string{
+		"build.yaml": `name: inputs
+version: "1.0"
+architectures: [x86_64]
+variables_from: [vars.yaml]
+files:
+  - name: data.txt
+    filename: data.txt
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  directives:
+    - include: common.yaml
+    - run: ["cat {{ get_file('data.txt') }}"]
+`,
+		"data.txt": "data\n",
+	})
+
+	b, err := LoadBuildFile(dir)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+	_, plan, err := b.GenerateWithParams(GenerateParams{IncludeDirs: []string{inc}})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "build.yaml"),
+		filepath.Join(dir, "data.txt"),
+		filepath.Join(inc, "common.yaml"),
+		filepath.Join(inc, "lib.star"),
+		filepath.Join(inc, "setup.star"),
+		filepath.Join(inc, "vars.yaml"),
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(plan.Inputs, want) {
+		t.Fatalf("Inputs = %v\nwant %v", plan.Inputs, want)
+	}
+}
+
+func TestStagingPlanInputsExpandPatterns(t *testing.T) {
+	dir := writeRecipeFiles(t, map[string]string{
+		"build.yaml": `name: patched
+version: "1.0"
+architectures: [x86_64]
+files:
+  - name: patches
+    filename: patches/*.patch
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  directives:
+    - run: ["for p in {{ get_file('patches') }}/*.patch; do patch -p1 < $p; done"]
+`,
+		"patches/0001-fix.patch":   "fix\n",
+		"patches/0002-build.patch": "build\n",
+		"patches/notes.txt":        "notes\n",
+	})
+
+	b, err := LoadBuildFile(dir)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+	_, plan, err := b.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("GenerateWithStaging: %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "build.yaml"),
+		filepath.Join(dir, "patches", "0001-fix.patch"),
+		filepath.Join(dir, "patches", "0002-build.patch"),
+	}
+	if !reflect.DeepEqual(plan.Inputs, want) {
+		t.Fatalf("Inputs = %v\nwant %v", plan.Inputs, want)
+	}
+
+	refs, err := b.References(nil)
+	if err != nil {
+		t.Fatalf("References: %v", err)
+	}
+	if !reflect.DeepEqual(refs, want[1:]) {
+		t.Fatalf("References = %v\nwant %v", refs, want[1:])
+	}
+}
@@ -0,0 +1,94 @@
+package recipe
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// LoadMode controls how a build file with fields the builder does not know
+// is loaded.
+type LoadMode int
+
+const (
+	// LoadDefault rejects unknown fields, except the forward-compatible
+	// fields, which are ignored.
+	LoadDefault LoadMode = iota
+	// LoadPermissive loads build files with unknown fields, ignoring them
+	// and recording a warning for each (see BuildFile.LoadWarnings).
+	LoadPermissive
+	// LoadStrict also rejects the forward-compatible fields, for CI, so
+	// recipes do not rely on settings that have no effect yet.
+	LoadStrict
+)
+
+// LoadOptions are the options of LoadBuildFileWithOptions.
+type LoadOptions struct {
+	// Override is the override file merged onto the recipe; when empty,
+	// build.override.yaml next to it is used if it exists.
+	Override string
+	Mode     LoadMode
+	// TemplateDir, when set, replaces the directory set with
+	// SetTemplateSpecDir for this recipe.
+	TemplateDir string
+}
+
+// forwardCompatFields are the fields, by the type that would hold them,
+// that recipes may set for features the builder does not have yet. They are
+// ignored, except by LoadStrict.
+var forwardCompatFields = map[string][]string{
+	// Arguments for apptainer run, for when recipes can set them.
+	"BuildFile": {"apptainer_args"},
+}
+
+var unknownFieldPattern = regexp.MustCompile(`^field (\S+) not found in type (?:\w+\.)?(\w+)$`)
+
+// checkUnknownFields sorts the unknown-field errors of the decode error err
+// by mode: forward-compatible fields are dropped unless mode is LoadStrict,
+// and with LoadPermissive the other unknown fields become warnings. It
+// returns the warnings and an error with the remaining problems, if any.
+func checkUnknownFields(err error, mode LoadMode) ([]string, error) {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return nil, err
+	}
+	var warnings []string
+	var rest []*yaml.UnmarshalError
+	for _, e := range te.Errors {
+		m := unknownFieldPattern.FindStringSubmatch(e.Err.Error())
+		switch {
+		case m == nil:
+			rest = append(rest, e)
+		case slices.Contains(forwardCompatFields[m[2]], m[1]):
+			if mode == LoadStrict {
+				rest = append(rest, &yaml.UnmarshalError{
+					Err:    fmt.Errorf("field %s is reserved for a future version of the builder and has no effect yet", m[1]),
+					Line:   e.Line,
+					Column: e.Column,
+				})
+			}
+		case mode == LoadPermissive:
+			warnings = append(warnings, e.Error())
+		default:
+			rest = append(rest, e)
+		}
+	}
+	if len(rest) > 0 {
+		return warnings, &yaml.TypeError{Errors: rest}
+	}
+	return warnings, nil
+}
+
+// Dir returns the directory the build file was loaded from.
+func (b *BuildFile) Dir() string {
+	return b.dir
+}
+
+// LoadWarnings returns the problems that loading the build file in
+// LoadPermissive mode ignored, such as unknown fields with their lines.
+func (b *BuildFile) LoadWarnings() []string {
+	return b.loadWarnings
+}
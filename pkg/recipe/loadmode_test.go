@@ -0,0 +1,69 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const unknownFieldsRecipe = `name: future
+version: 1.0.0
+architectures: [x86_64]
+apptainer_args: [--nv]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - run: [echo hi]
+      retries: 3
+`
+
+func loadWithMode(t *testing.T, text string, mode LoadMode) (*BuildFile, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "build.yaml"), []byte(text), 0o644); err != nil {
+		t.Fatalf("writing build.yaml: %v", err)
+	}
+	return LoadBuildFileWithOptions(dir, LoadOptions{Mode: mode})
+}
+
+func TestLoadModes(t *testing.T) {
+	// Forward-compatible fields alone load in the default mode.
+	withoutUnknown := strings.Replace(unknownFieldsRecipe, "      retries: 3\n", "", 1)
+	if _, err := loadWithMode(t, withoutUnknown, LoadDefault); err != nil {
+		t.Fatalf("default mode with apptainer_args: %v", err)
+	}
+
+	_, err := loadWithMode(t, unknownFieldsRecipe, LoadDefault)
+	if err == nil || !strings.Contains(err.Error(), "line 11: field retries not found in type recipe.Directive") {
+		t.Fatalf("default mode error = %v", err)
+	}
+	if strings.Contains(err.Error(), "apptainer_args") {
+		t.Fatalf("default mode reports the forward-compatible field: %v", err)
+	}
+
+	build, err := loadWithMode(t, unknownFieldsRecipe, LoadPermissive)
+	if err != nil {
+		t.Fatalf("permissive mode: %v", err)
+	}
+	if got := build.LoadWarnings(); len(got) != 1 || got[0] != "line 11: field retries not found in type recipe.Directive" {
+		t.Fatalf("LoadWarnings = %q", got)
+	}
+	if len(build.Build.Directives) != 1 || build.Build.Directives[0].Run == nil {
+		t.Fatalf("permissive mode did not decode the rest of the directive: %+v", build.Build.Directives)
+	}
+
+	_, err = loadWithMode(t, withoutUnknown, LoadStrict)
+	if err == nil || !strings.Contains(err.Error(), "line 4: field apptainer_args is reserved for a future version") {
+		t.Fatalf("strict mode error = %v", err)
+	}
+}
+
+func TestSchemaAllowsForwardCompatFields(t *testing.T) {
+	build := JSONSchema()["$defs"].(map[string]any)["BuildFile"].(map[string]any)
+	if _, ok := build["properties"].(map[string]any)["apptainer_args"]; !ok {
+		t.Fatalf("schema does not allow apptainer_args")
+	}
+}
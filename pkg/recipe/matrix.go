@@ -0,0 +1,115 @@
+package recipe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MatrixVariant is one build of a recipe matrix: a recipe version combined
+// with a value for every option.
+type MatrixVariant struct {
+	// Version is the recipe version the variant is built from.
+	Version string `json:"version"`
+	// Options holds every option value in --option form.
+	Options map[string]string `json:"options"`
+	// ImageVersion is Version with the enabled options' version_suffix
+	// appended; it is the variant's image tag.
+	ImageVersion string `json:"image_version"`
+}
+
+// matrixValues returns the values an option takes in a matrix: its declared
+// values, false and true for boolean options, or else just the default.
+func (o OptionInfo) matrixValues() []any {
+	if len(o.Values) > 0 {
+		return o.Values
+	}
+	switch o.Default.(type) {
+	case nil, bool:
+		return []any{false, true}
+	default:
+		return []any{o.Default}
+	}
+}
+
+// Matrix expands the recipe into one variant per combination of versions and
+// option values. versions defaults to the recipe version; pinned fixes
+// options to a single value. Variants are ordered by version, then by option
+// values with options in name order. Two variants that would share an image
+// tag are an error: give the options that tell them apart a version_suffix.
+func (b *BuildFile) Matrix(versions []string, pinned map[string]string) ([]MatrixVariant, error) {
+	if len(versions) == 0 {
+		versions = []string{b.Version}
+	}
+	if _, err := b.ResolveOptions(pinned); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(b.Options))
+	for name := range b.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combos := []map[string]string{{}}
+	for _, name := range names {
+		var values []string
+		if v, ok := pinned[name]; ok {
+			values = []string{v}
+		} else {
+			for _, v := range b.Options[name].matrixValues() {
+				values = append(values, fmt.Sprint(v))
+			}
+		}
+		next := make([]map[string]string, 0, len(combos)*len(values))
+		for _, combo := range combos {
+			for _, v := range values {
+				c := make(map[string]string, len(combo)+1)
+				for k, cv := range combo {
+					c[k] = cv
+				}
+				c[name] = v
+				next = append(next, c)
+			}
+		}
+		combos = next
+	}
+
+	var out []MatrixVariant
+	seen := map[string]MatrixVariant{}
+	for _, version := range versions {
+		vb := *b
+		vb.Version = version
+		for _, combo := range combos {
+			values, err := vb.ResolveOptions(combo)
+			if err != nil {
+				return nil, err
+			}
+			variant := MatrixVariant{
+				Version:      version,
+				Options:      combo,
+				ImageVersion: vb.VersionWithOptions(values),
+			}
+			if prev, dup := seen[variant.ImageVersion]; dup {
+				return nil, fmt.Errorf("matrix variants %s and %s would both be tagged %s:%s; add a version_suffix to the options that differ",
+					describeVariant(prev), describeVariant(variant), b.Name, variant.ImageVersion)
+			}
+			seen[variant.ImageVersion] = variant
+			out = append(out, variant)
+		}
+	}
+	return out, nil
+}
+
+func describeVariant(v MatrixVariant) string {
+	keys := make([]string, 0, len(v.Options))
+	for k := range v.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{"version=" + v.Version}
+	for _, k := range keys {
+		parts = append(parts, k+"="+v.Options[k])
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
@@ -0,0 +1,59 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatrixExpandsOptionsAndVersions(t *testing.T) {
+	build, err := loadBuildYAML(t, optionsRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+
+	variants, err := build.Matrix([]string{"1.0.0", "2.0.0"}, map[string]string{"jobs": "2"})
+	if err != nil {
+		t.Fatalf("Matrix: %v", err)
+	}
+	var got []string
+	for _, v := range variants {
+		got = append(got, v.ImageVersion)
+		if v.Options["jobs"] != "2" {
+			t.Fatalf("pinned option not applied: %#v", v.Options)
+		}
+	}
+	want := "1.0.0-full 1.0.0-full-gpu 2.0.0-full 2.0.0-full-gpu"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("image versions = %v, want %s", got, want)
+	}
+
+	def, _, err := build.GenerateWithParams(GenerateParams{Options: variants[3].Options})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	if got := def.Labels()[OCILabelVersion]; got != variants[3].ImageVersion {
+		t.Fatalf("version label = %q, want %q", got, variants[3].ImageVersion)
+	}
+}
+
+func TestMatrixRejectsDuplicateTags(t *testing.T) {
+	build, err := loadBuildYAML(t, strings.Replace(optionsRecipe, "    default: 4\n",
+		"    default: 4\n    values: [4, 8]\n", 1))
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	if _, err := build.Matrix(nil, nil); err == nil || !strings.Contains(err.Error(), "add a version_suffix") {
+		t.Fatalf("Matrix error = %v", err)
+	}
+	if _, err := build.Matrix(nil, map[string]string{"jobs": "4"}); err != nil {
+		t.Fatalf("Matrix with jobs pinned: %v", err)
+	}
+}
+
+func TestOptionValuesMustMatchDefault(t *testing.T) {
+	_, err := loadBuildYAML(t, strings.Replace(optionsRecipe, "    default: 4\n",
+		"    default: 4\n    values: [4, many]\n", 1))
+	if err == nil || !strings.Contains(err.Error(), `value many does not match the type of the default 4`) {
+		t.Fatalf("error = %v", err)
+	}
+}
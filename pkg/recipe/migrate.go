@@ -0,0 +1,388 @@
+package recipe
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	v "github.com/neurodesk/builder/pkg/validator"
+	"go.yaml.in/yaml/v4"
+)
+
+// CurrentSchemaVersion is the newest recipe format, declared with
+// schema_version. Recipes without schema_version are version 1.
+//
+// Version 2 drops the top-level files and deploy fields, whose directives
+// replace them, and top-level variables except where a variables directive
+// cannot: with variables_from, env:// values or build stages.
+const CurrentSchemaVersion = 2
+
+// directiveSpellings maps the old spellings of directive keys to the current
+// ones, for MigrateBuildFile.
+var directiveSpellings = map[string]string{
+	"work_dir":     "workdir",
+	"entry_point":  "entrypoint",
+	"copy-from":    "copy_from",
+	"single-layer": "single_layer",
+}
+
+// validateSchemaVersion checks the declared schema_version and rejects the
+// fields it no longer has.
+func (b *BuildFile) validateSchemaVersion() error {
+	if b.SchemaVersion < 0 || b.SchemaVersion > CurrentSchemaVersion {
+		return v.At("schema_version", fmt.Errorf("version %d is not supported; this builder reads versions 1 to %d", b.SchemaVersion, CurrentSchemaVersion))
+	}
+	if b.SchemaVersion < 2 {
+		return nil
+	}
+	removed := func(field string, present bool, use string) error {
+		if !present {
+			return nil
+		}
+		return v.At(field, fmt.Errorf("top-level %s is not part of schema_version %d; use %s (see builder migrate)", field, b.SchemaVersion, use))
+	}
+	return v.All(
+		removed("variables", len(b.Variables) > 0 && b.variablesStayTopLevel() == "", "a variables directive"),
+		removed("files", len(b.Files) > 0 && len(b.Build.Stages) == 0, "file directives"),
+		removed("deploy", len(b.Deploy.Bins) > 0 || len(b.Deploy.Path) > 0 || b.Deploy.Webapp != nil, "a deploy directive"),
+	)
+}
+
+// variablesStayTopLevel returns why the top-level variables cannot become a
+// variables directive, or "" if they can. Directives are applied after the
+// stages are built and their values are not resolved like top-level ones.
+func (b *BuildFile) variablesStayTopLevel() string {
+	switch {
+	case len(b.VariablesFrom) > 0:
+		return "variables_from overrides them"
+	case len(b.Build.Stages) > 0:
+		return "build stages can use them"
+	}
+	for _, val := range b.Variables {
+		if _, ok := envVariableName(val); ok {
+			return "they have env:// values"
+		}
+	}
+	return ""
+}
+
+// lineEdit replaces lines [start, end) of a file with text.
+type lineEdit struct {
+	start, end int
+	text       []string
+}
+
+// MigrateBuildFile rewrites the build.yaml src to CurrentSchemaVersion and
+// returns the new text with a note per change. It moves top-level files,
+// deploy and, where possible, variables into build.directives, renames old
+// directive spellings and sets schema_version. The rest of the file,
+// comments included, is kept as written. A file already at the current
+// version is returned unchanged.
+func MigrateBuildFile(src []byte) ([]byte, []string, error) {
+	text := string(src)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing build file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode || doc.Content[0].Style&yaml.FlowStyle != 0 {
+		return nil, nil, errors.New("build file is not a block mapping")
+	}
+	root := doc.Content[0]
+
+	versionKey, versionNode := mappingEntry(root, "schema_version")
+	if versionNode != nil {
+		version, err := strconv.Atoi(versionNode.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: schema_version %q is not a number", versionNode.Line, versionNode.Value)
+		}
+		if version >= CurrentSchemaVersion {
+			return src, nil, nil
+		}
+	}
+
+	// Decode the fields that decide what can move, tolerating the unknown
+	// keys of old spellings.
+	var b BuildFile
+	_ = root.Decode(&b)
+
+	lines := strings.SplitAfter(text, "\n")
+	lines = lines[:len(lines)-1]
+	var notes []string
+
+	// Renames keep the line count, so they are applied first.
+	build := mappingValue(root, "build")
+	for _, r := range renameDirectives(build) {
+		line := lines[r.key.Line-1]
+		col := r.key.Column - 1
+		lines[r.key.Line-1] = line[:col] + r.to + line[col+len(r.key.Value):]
+		notes = append(notes, fmt.Sprintf("line %d: renamed %s to %s", r.key.Line, r.key.Value, r.to))
+	}
+
+	var edits []lineEdit
+	var prepend, appendDirs [][]string // directive bodies, without indentation
+	move := func(field string) (*yaml.Node, *yaml.Node) {
+		key, val := mappingEntry(root, field)
+		if key == nil || key.Column != 1 {
+			return nil, nil
+		}
+		edits = append(edits, lineEdit{start: key.Line - 1, end: entryEnd(lines, key, val)})
+		return key, val
+	}
+
+	if len(b.Variables) > 0 {
+		if reason := b.variablesStayTopLevel(); reason != "" {
+			notes = append(notes, fmt.Sprintf("kept top-level variables: %s", reason))
+		} else if key, val := move("variables"); key != nil {
+			prepend = append(prepend, nodeLines(lines, key, val, "variables"))
+			notes = append(notes, "moved top-level variables into a variables directive at the start of build.directives")
+		}
+	}
+	if len(b.Files) > 0 {
+		if len(b.Build.Stages) > 0 {
+			notes = append(notes, "kept top-level files: build stages can use them")
+		} else if key, val := move("files"); key != nil {
+			if val.Kind != yaml.SequenceNode || val.Style&yaml.FlowStyle != 0 {
+				return nil, nil, fmt.Errorf("line %d: top-level files must be a block list to migrate", key.Line)
+			}
+			for i, item := range val.Content {
+				end := blockEnd(lines, item.Line-1, indentOf(lines[item.Line-1]))
+				if i+1 < len(val.Content) {
+					end = min(end, val.Content[i+1].Line-1)
+				}
+				prepend = append(prepend, itemLines(lines, item, end, "file"))
+			}
+			notes = append(notes, fmt.Sprintf("moved top-level files into %d file directives at the start of build.directives", len(val.Content)))
+		}
+	}
+	if key, val := mappingEntry(root, "deploy"); key != nil && !isEmptyNode(val) {
+		key, val = move("deploy")
+		appendDirs = append(appendDirs, nodeLines(lines, key, val, "deploy"))
+		notes = append(notes, "moved top-level deploy into a deploy directive at the end of build.directives; at the top level it had no effect")
+	} else if key != nil {
+		move("deploy")
+	}
+
+	if len(prepend) > 0 || len(appendDirs) > 0 {
+		dirEdits, err := directiveInsertions(lines, root, prepend, appendDirs)
+		if err != nil {
+			return nil, nil, err
+		}
+		edits = append(edits, dirEdits...)
+	}
+
+	version := fmt.Sprintf("schema_version: %d\n", CurrentSchemaVersion)
+	if versionKey != nil {
+		edits = append(edits, lineEdit{start: versionKey.Line - 1, end: versionKey.Line, text: []string{version}})
+	} else {
+		first := root.Content[0].Line - 1
+		edits = append(edits, lineEdit{start: first, end: first, text: []string{version}})
+	}
+	notes = append(notes, fmt.Sprintf("set schema_version to %d", CurrentSchemaVersion))
+
+	// Apply from the bottom up so earlier line numbers stay valid; at the
+	// same line, removals go before insertions so the insertions survive.
+	slices.SortStableFunc(edits, func(a, b lineEdit) int {
+		if a.start != b.start {
+			return b.start - a.start
+		}
+		return (b.end - b.start) - (a.end - a.start)
+	})
+	for _, e := range edits {
+		lines = slices.Replace(lines, e.start, e.end, e.text...)
+	}
+	out := strings.Join(lines, "")
+
+	var check yaml.Node
+	if err := yaml.Unmarshal([]byte(out), &check); err != nil {
+		return nil, nil, fmt.Errorf("migrated build file does not parse: %w", err)
+	}
+	return []byte(out), notes, nil
+}
+
+// rename is a directive key to respell.
+type rename struct {
+	key *yaml.Node
+	to  string
+}
+
+// renameDirectives returns the directive keys with old spellings in the
+// directives and stages of the build mapping, including nested groups,
+// layers and arch blocks.
+func renameDirectives(build *yaml.Node) []rename {
+	var out []rename
+	var walk func(list *yaml.Node)
+	walk = func(list *yaml.Node) {
+		if list == nil || list.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range list.Content {
+			if item.Kind != yaml.MappingNode {
+				continue
+			}
+			for i := 0; i+1 < len(item.Content); i += 2 {
+				key := item.Content[i]
+				if to, ok := directiveSpellings[key.Value]; ok && mappingValue(item, to) == nil {
+					out = append(out, rename{key: key, to: to})
+				}
+			}
+			walk(mappingValue(item, "group"))
+			walk(mappingValue(item, "layer"))
+			if arch := mappingValue(item, "arch"); arch != nil && arch.Kind == yaml.MappingNode {
+				for i := 1; i < len(arch.Content); i += 2 {
+					walk(arch.Content[i])
+				}
+			}
+		}
+	}
+	walk(mappingValue(build, "directives"))
+	if stages := mappingValue(build, "stages"); stages != nil && stages.Kind == yaml.SequenceNode {
+		for _, stage := range stages.Content {
+			walk(mappingValue(stage, "directives"))
+		}
+	}
+	return out
+}
+
+// directiveInsertions returns the edits adding the directive bodies prepend
+// at the start of build.directives and appendDirs at its end, creating the
+// list if the recipe has none.
+func directiveInsertions(lines []string, root *yaml.Node, prepend, appendDirs [][]string) ([]lineEdit, error) {
+	buildKey, build := mappingEntry(root, "build")
+	if build == nil || build.Kind != yaml.MappingNode || build.Style&yaml.FlowStyle != 0 || len(build.Content) == 0 {
+		return nil, errors.New("build must be a block mapping to migrate top-level fields into build.directives")
+	}
+	key, dirs := mappingEntry(build, "directives")
+	if key == nil {
+		// Add a directives list at the end of build.
+		keyIndent := build.Content[0].Column - 1
+		at := blockEnd(lines, buildKey.Line-1, 0)
+		text := []string{strings.Repeat(" ", keyIndent) + "directives:\n"}
+		text = append(text, directiveText(slices.Concat(prepend, appendDirs), keyIndent+2)...)
+		return []lineEdit{{start: at, end: at, text: text}}, nil
+	}
+	if dirs.Kind != yaml.SequenceNode || dirs.Style&yaml.FlowStyle != 0 || len(dirs.Content) == 0 {
+		return nil, fmt.Errorf("line %d: build.directives must be a non-empty block list to migrate top-level fields into it", key.Line)
+	}
+	first := dirs.Content[0].Line - 1
+	last := dirs.Content[len(dirs.Content)-1].Line - 1
+	indent := indentOf(lines[first])
+	var edits []lineEdit
+	if len(prepend) > 0 {
+		edits = append(edits, lineEdit{start: first, end: first, text: directiveText(prepend, indent)})
+	}
+	if len(appendDirs) > 0 {
+		at := blockEnd(lines, last, indentOf(lines[last]))
+		edits = append(edits, lineEdit{start: at, end: at, text: directiveText(appendDirs, indent)})
+	}
+	return edits, nil
+}
+
+// directiveText renders directive bodies as list items at indent.
+func directiveText(bodies [][]string, indent int) []string {
+	pad := strings.Repeat(" ", indent)
+	var out []string
+	for _, body := range bodies {
+		for i, line := range body {
+			switch {
+			case i == 0:
+				out = append(out, pad+"- "+line)
+			case strings.TrimSpace(line) == "":
+				out = append(out, "\n")
+			default:
+				out = append(out, pad+"  "+line)
+			}
+		}
+	}
+	return out
+}
+
+// nodeLines returns the directive body "name: <val>" for the top-level
+// field key, with the block lines of val indented by two spaces.
+func nodeLines(lines []string, key, val *yaml.Node, name string) []string {
+	end := entryEnd(lines, key, val)
+	if val.Line == key.Line {
+		body := []string{name + ": " + lines[key.Line-1][val.Column-1:]}
+		for _, line := range lines[key.Line:end] {
+			body = append(body, "  "+strings.TrimLeft(line, " "))
+		}
+		return body
+	}
+	body := []string{name + ":\n"}
+	for _, line := range lines[key.Line:end] {
+		body = append(body, "  "+dedent(line, val.Column-1))
+	}
+	return body
+}
+
+// itemLines returns the directive body "name:" for the list item node,
+// which runs to line end, with the item's lines indented under it.
+func itemLines(lines []string, item *yaml.Node, end int, name string) []string {
+	body := []string{name + ":\n", "  " + lines[item.Line-1][item.Column-1:]}
+	for _, line := range lines[item.Line:end] {
+		body = append(body, "  "+dedent(line, item.Column-1))
+	}
+	return body
+}
+
+// blockEnd returns the line after the block that starts at line start and
+// continues on the lines indented by more than indent. Comments that follow
+// it at a lower indentation belong to what comes next.
+func blockEnd(lines []string, start, indent int) int {
+	end := start + 1
+	for i := start + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" {
+			continue
+		}
+		if indentOf(lines[i]) > indent {
+			end = i + 1
+			continue
+		}
+		if !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+	}
+	return end
+}
+
+// entryEnd returns the line after the mapping entry of key and val, whose
+// value may be a list at the indentation of the key.
+func entryEnd(lines []string, key, val *yaml.Node) int {
+	if val.Kind == yaml.SequenceNode && len(val.Content) > 0 && val.Line > key.Line {
+		last := val.Content[len(val.Content)-1].Line - 1
+		return max(blockEnd(lines, key.Line-1, key.Column-1), blockEnd(lines, last, indentOf(lines[last])))
+	}
+	return blockEnd(lines, key.Line-1, key.Column-1)
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// dedent removes up to n leading spaces from line.
+func dedent(line string, n int) string {
+	return line[min(n, indentOf(line)):]
+}
+
+// mappingEntry returns the key and value nodes of key in the YAML mapping
+// node, or nils.
+func mappingEntry(node *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+func isEmptyNode(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null" || len(node.Content) == 0 && node.Kind != yaml.ScalarNode
+}
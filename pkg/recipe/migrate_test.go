@@ -0,0 +1,112 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+)
+
+const legacyRecipe = `# A recipe in the original format.
+name: legacy
+version: 1.0.0
+architectures: [x86_64]
+variables:
+  url: https://example.org/tool.tar.gz # download
+files:
+- name: setup.sh
+  contents: |
+    echo setup
+  executable: true
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - run:
+        - curl -fsSL {{ context.url }} | tar -xz -C /opt
+    - group:
+        - work_dir: /opt/tool
+    - entry_point: /opt/tool/run
+deploy:
+  bins: [tool]
+readme: Legacy tool.
+`
+
+const migratedRecipe = `# A recipe in the original format.
+schema_version: 2
+name: legacy
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - variables:
+        url: https://example.org/tool.tar.gz # download
+    - file:
+        name: setup.sh
+        contents: |
+          echo setup
+        executable: true
+    - run:
+        - curl -fsSL {{ context.url }} | tar -xz -C /opt
+    - group:
+        - workdir: /opt/tool
+    - entrypoint: /opt/tool/run
+    - deploy:
+        bins: [tool]
+readme: Legacy tool.
+`
+
+func TestMigrateBuildFile(t *testing.T) {
+	out, notes, err := MigrateBuildFile([]byte(legacyRecipe))
+	if err != nil {
+		t.Fatalf("MigrateBuildFile: %v", err)
+	}
+	if string(out) != migratedRecipe {
+		t.Fatalf("migrated recipe:\n%s\nwant:\n%s", out, migratedRecipe)
+	}
+	if got := strings.Join(notes, "\n"); !strings.Contains(got, "line 20: renamed work_dir to workdir") || !strings.Contains(got, "at the top level it had no effect") {
+		t.Fatalf("notes = %q", notes)
+	}
+
+	build, err := loadBuildYAML(t, string(out))
+	if err != nil {
+		t.Fatalf("loading migrated recipe: %v", err)
+	}
+	if _, err := build.Generate(nil); err != nil {
+		t.Fatalf("generating migrated recipe: %v", err)
+	}
+
+	again, notes, err := MigrateBuildFile(out)
+	if err != nil || string(again) != string(out) || notes != nil {
+		t.Fatalf("migrating a current recipe changed it: %v, %q", err, notes)
+	}
+}
+
+func TestMigrateKeepsVariablesUsedBeforeDirectives(t *testing.T) {
+	src := strings.Replace(legacyRecipe, "variables:\n", "variables_from: [site.yaml]\nvariables:\n", 1)
+	out, notes, err := MigrateBuildFile([]byte(src))
+	if err != nil {
+		t.Fatalf("MigrateBuildFile: %v", err)
+	}
+	if !strings.Contains(string(out), "\nvariables:\n  url:") {
+		t.Fatalf("top-level variables were moved:\n%s", out)
+	}
+	if !strings.Contains(strings.Join(notes, "\n"), "kept top-level variables: variables_from overrides them") {
+		t.Fatalf("notes = %q", notes)
+	}
+}
+
+func TestSchemaVersionValidation(t *testing.T) {
+	current := strings.Replace(migratedRecipe, "readme:", "files:\n  - name: x\n    contents: y\nreadme:", 1)
+	_, err := loadBuildYAML(t, current)
+	if err == nil || !strings.Contains(err.Error(), "files: top-level files is not part of schema_version 2") {
+		t.Fatalf("top-level files with schema_version 2: %v", err)
+	}
+
+	future := strings.Replace(migratedRecipe, "schema_version: 2", "schema_version: 9", 1)
+	if _, err := loadBuildYAML(t, future); err == nil || !strings.Contains(err.Error(), "schema_version: version 9 is not supported") {
+		t.Fatalf("schema_version 9: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package recipe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/common"
+	"github.com/neurodesk/builder/pkg/jinja2"
+	"go.yaml.in/yaml/v4"
+)
+
+// NeurodockerSpec is a Neurodocker renderer specification, as written by
+// `neurodocker generate ... --json` or passed to Renderer.from_dict.
+type NeurodockerSpec struct {
+	PkgManager   string                   `json:"pkg_manager" yaml:"pkg_manager"`
+	Instructions []NeurodockerInstruction `json:"instructions" yaml:"instructions"`
+}
+
+// NeurodockerInstruction is one renderer call: a built-in instruction such
+// as "run" or "install", or the name of a template such as "fsl".
+type NeurodockerInstruction struct {
+	Name string         `json:"name" yaml:"name"`
+	Kwds map[string]any `json:"kwds" yaml:"kwds"`
+}
+
+// ParseNeurodockerSpec parses a JSON or YAML Neurodocker specification.
+// Keys other than pkg_manager and instructions are ignored.
+func ParseNeurodockerSpec(data []byte) (*NeurodockerSpec, error) {
+	var spec NeurodockerSpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("parsing neurodocker specification: %w", err)
+	}
+	if len(spec.Instructions) == 0 {
+		return nil, fmt.Errorf("neurodocker specification has no instructions")
+	}
+	return &spec, nil
+}
+
+// neurodockerListOptions take every following argument up to the next
+// option, like Neurodocker's own click options.
+var neurodockerListOptions = map[string]bool{
+	"install": true, "env": true, "label": true, "arg": true, "copy": true, "entrypoint": true,
+}
+
+// ParseNeurodockerArgs parses a `neurodocker generate docker ...` command
+// line. The leading "neurodocker generate docker" (or singularity) words
+// are optional, and line continuations are accepted.
+func ParseNeurodockerArgs(cmdline string) (*NeurodockerSpec, error) {
+	words, err := shellWords(strings.NewReplacer("\\\n", " ", "\n", " ", "\t", " ").Replace(cmdline))
+	if err != nil {
+		return nil, err
+	}
+	for len(words) > 0 && !strings.HasPrefix(words[0], "-") {
+		switch words[0] {
+		case "neurodocker", "generate", "docker", "singularity":
+			words = words[1:]
+			continue
+		}
+		return nil, fmt.Errorf("unexpected argument %q", words[0])
+	}
+
+	spec := &NeurodockerSpec{}
+	for len(words) > 0 {
+		opt, ok := strings.CutPrefix(words[0], "--")
+		if !ok {
+			return nil, fmt.Errorf("unexpected argument %q", words[0])
+		}
+		words = words[1:]
+		var values []string
+		if name, val, ok := strings.Cut(opt, "="); ok {
+			opt, values = name, []string{val}
+		}
+		for len(words) > 0 && !strings.HasPrefix(words[0], "--") {
+			values = append(values, words[0])
+			words = words[1:]
+		}
+
+		switch opt {
+		case "yes", "json", "template-path":
+			continue
+		case "pkg-manager", "base-image":
+			if len(values) != 1 {
+				return nil, fmt.Errorf("--%s takes one value", opt)
+			}
+			if opt == "pkg-manager" {
+				spec.PkgManager = values[0]
+			} else {
+				spec.Instructions = append(spec.Instructions, NeurodockerInstruction{Name: "from_", Kwds: map[string]any{"base_image": values[0]}})
+			}
+			continue
+		}
+
+		inst, err := neurodockerInstructionFromArgs(opt, values)
+		if err != nil {
+			return nil, fmt.Errorf("--%s: %w", opt, err)
+		}
+		spec.Instructions = append(spec.Instructions, inst)
+	}
+	if len(spec.Instructions) == 0 {
+		return nil, fmt.Errorf("neurodocker command has no instructions")
+	}
+	return spec, nil
+}
+
+func neurodockerInstructionFromArgs(opt string, values []string) (NeurodockerInstruction, error) {
+	name := strings.ReplaceAll(opt, "-", "_")
+	single := func(key string) (NeurodockerInstruction, error) {
+		if len(values) != 1 {
+			return NeurodockerInstruction{}, fmt.Errorf("takes one value, got %d", len(values))
+		}
+		return NeurodockerInstruction{Name: name, Kwds: map[string]any{key: values[0]}}, nil
+	}
+
+	switch name {
+	case "run", "run_bash":
+		return single("command")
+	case "user":
+		return single("user")
+	case "workdir":
+		return single("path")
+	case "install":
+		return NeurodockerInstruction{Name: name, Kwds: map[string]any{"pkgs": toAnySlice(values)}}, nil
+	case "entrypoint":
+		return NeurodockerInstruction{Name: name, Kwds: map[string]any{"args": toAnySlice(values)}}, nil
+	case "copy":
+		if len(values) < 2 {
+			return NeurodockerInstruction{}, fmt.Errorf("needs a source and a destination")
+		}
+		return NeurodockerInstruction{Name: name, Kwds: map[string]any{
+			"source":      toAnySlice(values[:len(values)-1]),
+			"destination": values[len(values)-1],
+		}}, nil
+	}
+
+	// env, label, arg and templates take KEY=VALUE pairs.
+	kwds := map[string]any{}
+	for _, kv := range values {
+		k, val, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return NeurodockerInstruction{}, fmt.Errorf("invalid %q (want KEY=VALUE)", kv)
+		}
+		kwds[k] = val
+	}
+	if name == "arg" {
+		if len(kwds) != 1 {
+			return NeurodockerInstruction{}, fmt.Errorf("takes one KEY=VALUE")
+		}
+		for k, val := range kwds {
+			kwds = map[string]any{"key": k, "value": val}
+		}
+	}
+	return NeurodockerInstruction{Name: name, Kwds: kwds}, nil
+}
+
+// ConvertNeurodocker converts a Neurodocker specification into a build file
+// named name at version. Template instructions become template directives
+// of the same name, with their keywords as parameters. Keywords with no
+// recipe equivalent are reported as warnings.
+func ConvertNeurodocker(spec *NeurodockerSpec, name, version string) (*BuildFile, []string, error) {
+	var warnings []string
+	warnf := func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	build := &BuildFile{
+		Name:          name,
+		Version:       version,
+		Architectures: []CPUArchitecture{CPUArchAMD64},
+		Build: BuildRecipe{
+			Kind:           BuildKindNeuroDocker,
+			PackageManager: common.PackageManager(spec.PkgManager),
+		},
+	}
+
+	for i, inst := range spec.Instructions {
+		description := fmt.Sprintf("instructions[%d] (%s)", i, inst.Name)
+		kwds := inst.Kwds
+		str := func(key string) (string, error) {
+			s, ok := kwds[key].(string)
+			if !ok {
+				return "", fmt.Errorf("%s: %s must be a string", description, key)
+			}
+			return s, nil
+		}
+		strs := func(key string) ([]string, error) {
+			switch val := kwds[key].(type) {
+			case string:
+				return []string{val}, nil
+			case []any:
+				out := make([]string, len(val))
+				for j, item := range val {
+					s, ok := item.(string)
+					if !ok {
+						return nil, fmt.Errorf("%s: %s[%d] must be a string", description, key, j)
+					}
+					out[j] = s
+				}
+				return out, nil
+			}
+			return nil, fmt.Errorf("%s: %s must be a string or a list of strings", description, key)
+		}
+
+		var d Directive
+		switch inst.Name {
+		case "from_":
+			image, err := str("base_image")
+			if err != nil {
+				return nil, warnings, err
+			}
+			if build.Build.BaseImage != "" {
+				warnf("%s: only the first base image is used", description)
+				continue
+			}
+			build.Build.BaseImage = image
+			continue
+
+		case "run", "run_bash":
+			cmd, err := str("command")
+			if err != nil {
+				return nil, warnings, err
+			}
+			if inst.Name == "run_bash" {
+				cmd = "bash -c " + shellQuote(cmd)
+			}
+			d.Run = &RunDirective{templateLiteral(cmd)}
+
+		case "install":
+			pkgs, err := strs("pkgs")
+			if err != nil {
+				return nil, warnings, err
+			}
+			if opts, ok := kwds["opts"]; ok && opts != nil {
+				warnf("%s: install options %v dropped", description, opts)
+			}
+			var install InstallDirective = toAnySlice(pkgs)
+			d.Install = &install
+
+		case "env", "label":
+			values := map[string]jinja2.TemplateString{}
+			for k, val := range kwds {
+				values[k] = templateLiteral(fmt.Sprint(val))
+			}
+			if inst.Name == "env" {
+				env := EnvironmentDirective(values)
+				d.Environment = &env
+			} else {
+				labels := LabelsDirective(values)
+				d.Labels = &labels
+			}
+
+		case "arg":
+			key, err := str("key")
+			if err != nil {
+				return nil, warnings, err
+			}
+			args := ArgsDirective{key: nil}
+			if val, ok := kwds["value"]; ok && val != nil {
+				s := templateLiteral(fmt.Sprint(val))
+				args[key] = &s
+			}
+			d.Args = &args
+
+		case "copy":
+			srcs, err := strs("source")
+			if err != nil {
+				return nil, warnings, err
+			}
+			dest, err := str("destination")
+			if err != nil {
+				return nil, warnings, err
+			}
+			var cp CopyDirective = toAnySlice(append(srcs, dest))
+			d.Copy = &cp
+
+		case "entrypoint":
+			args, err := strs("args")
+			if err != nil {
+				return nil, warnings, err
+			}
+			quoted := make([]string, len(args))
+			for j, arg := range args {
+				quoted[j] = shellQuote(arg)
+			}
+			e := EntryPointDirective(templateLiteral(strings.Join(quoted, " ")))
+			d.EntryPoint = &e
+
+		case "user":
+			user, err := str("user")
+			if err != nil {
+				return nil, warnings, err
+			}
+			u := UserDirective(templateLiteral(user))
+			d.User = &u
+
+		case "workdir":
+			path, err := str("path")
+			if err != nil {
+				return nil, warnings, err
+			}
+			w := WorkDirDirective(templateLiteral(path))
+			d.WorkDir = &w
+
+		default:
+			if _, err := getTemplateSpec(templateSpecDir, inst.Name); err != nil {
+				return nil, warnings, fmt.Errorf("%s: unknown instruction or template", description)
+			}
+			params := map[string]any{}
+			for k, val := range kwds {
+				params[k] = val
+			}
+			d.Template = &TemplateDirective{Name: inst.Name, Params: params}
+		}
+		build.Build.Directives = append(build.Build.Directives, d)
+	}
+
+	if err := build.Validate(Context{}); err != nil {
+		return nil, warnings, fmt.Errorf("converted recipe is invalid: %w", err)
+	}
+	return build, warnings, nil
+}
@@ -0,0 +1,107 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"go.yaml.in/yaml/v4"
+)
+
+const neurodockerJSON = `{
+  "pkg_manager": "apt",
+  "instructions": [
+    {"name": "from_", "kwds": {"base_image": "ubuntu:22.04"}},
+    {"name": "install", "kwds": {"pkgs": ["git", "curl"], "opts": null}},
+    {"name": "env", "kwds": {"LANG": "C.UTF-8"}},
+    {"name": "jq", "kwds": {"version": "1.6"}},
+    {"name": "run", "kwds": {"command": "echo done"}},
+    {"name": "workdir", "kwds": {"path": "/work"}},
+    {"name": "entrypoint", "kwds": {"args": ["/bin/bash", "-l"]}}
+  ]
+}`
+
+func TestConvertNeurodockerSpec(t *testing.T) {
+	spec, err := ParseNeurodockerSpec([]byte(neurodockerJSON))
+	if err != nil {
+		t.Fatalf("ParseNeurodockerSpec: %v", err)
+	}
+	build, warnings, err := ConvertNeurodocker(spec, "legacy", "1.6")
+	if err != nil {
+		t.Fatalf("ConvertNeurodocker: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %q", warnings)
+	}
+	if build.Build.BaseImage != "ubuntu:22.04" || build.Build.PackageManager != "apt" {
+		t.Fatalf("unexpected build: %+v", build.Build)
+	}
+	tpl := build.Build.Directives[2].Template
+	if tpl == nil || tpl.Name != "jq" || tpl.Params["version"] != "1.6" {
+		t.Fatalf("jq not converted to a template directive: %+v", build.Build.Directives[2])
+	}
+
+	out, err := yaml.Marshal(build)
+	if err != nil {
+		t.Fatalf("yaml.Marshal: %v", err)
+	}
+	loaded, err := loadBuildYAML(t, string(out))
+	if err != nil {
+		t.Fatalf("loading converted recipe: %v\n%s", err, out)
+	}
+	def, err := loaded.Generate([]string{t.TempDir()})
+	if err != nil {
+		t.Fatalf("Generate: %v\n%s", err, out)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	for _, want := range []string{"FROM ubuntu:22.04", "git", "curl", "jq", "echo done", "WORKDIR /work", "/bin/bash -l"} {
+		if !strings.Contains(dockerfile, want) {
+			t.Fatalf("Dockerfile missing %q:\n%s", want, dockerfile)
+		}
+	}
+}
+
+func TestParseNeurodockerArgs(t *testing.T) {
+	spec, err := ParseNeurodockerArgs(`neurodocker generate docker --pkg-manager apt \
+  --base-image ubuntu:22.04 --yes \
+  --install git curl \
+  --env LANG=C.UTF-8 TZ=UTC \
+  --jq version=1.6 \
+  --run "echo 'done'" \
+  --copy a.sh b.sh /opt/ \
+  --user=nonroot`)
+	if err != nil {
+		t.Fatalf("ParseNeurodockerArgs: %v", err)
+	}
+
+	var names []string
+	for _, inst := range spec.Instructions {
+		names = append(names, inst.Name)
+	}
+	if got, want := strings.Join(names, ","), "from_,install,env,jq,run,copy,user"; got != want || spec.PkgManager != "apt" {
+		t.Fatalf("instructions = %s (pkg manager %q), want %s", got, spec.PkgManager, want)
+	}
+	if got := spec.Instructions[4].Kwds["command"]; got != "echo 'done'" {
+		t.Fatalf("run command = %q", got)
+	}
+	if got := spec.Instructions[5].Kwds["destination"]; got != "/opt/" {
+		t.Fatalf("copy destination = %q", got)
+	}
+	if _, _, err := ConvertNeurodocker(spec, "legacy", "1.0.0"); err != nil {
+		t.Fatalf("ConvertNeurodocker: %v", err)
+	}
+
+	if _, err := ParseNeurodockerArgs("--base-image ubuntu:22.04 --env NOVALUE"); err == nil {
+		t.Fatalf("expected an error for an invalid --env value")
+	}
+	spec, err = ParseNeurodockerArgs("--pkg-manager apt --base-image ubuntu:22.04 --nosuchtool version=1")
+	if err != nil {
+		t.Fatalf("ParseNeurodockerArgs: %v", err)
+	}
+	if _, _, err := ConvertNeurodocker(spec, "legacy", "1.0.0"); err == nil || !strings.Contains(err.Error(), "unknown instruction or template") {
+		t.Fatalf("expected unknown template error, got %v", err)
+	}
+}
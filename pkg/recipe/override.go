@@ -0,0 +1,123 @@
+package recipe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.yaml.in/yaml/v4"
+)
+
+// OverrideFileName is the override file picked up next to a build.yaml.
+const OverrideFileName = "build.override.yaml"
+
+// LoadBuildFileWithOverride loads the recipe in path with an override file
+// deep-merged onto it before it is decoded and validated. override may be
+// empty, in which case build.override.yaml in path is used if it exists.
+//
+// Mappings in the override are merged key by key; any other value replaces
+// the recipe's, and null removes the key. A key ending in "+" appends its
+// list to the recipe's list of that name, e.g. `directives+:` under build
+// adds directives after the recipe's own.
+func LoadBuildFileWithOverride(path, override string) (*BuildFile, error) {
+	return LoadBuildFileWithOptions(path, LoadOptions{Override: override})
+}
+
+// LoadBuildFileWithOptions loads the recipe in path like
+// LoadBuildFileWithOverride, handling unknown fields according to
+// opts.Mode.
+func LoadBuildFileWithOptions(path string, opts LoadOptions) (*BuildFile, error) {
+	override := opts.Override
+	if override == "" {
+		candidate := filepath.Join(path, OverrideFileName)
+		if _, err := os.Stat(candidate); err == nil {
+			override = candidate
+		}
+	}
+	if override == "" {
+		data, err := os.ReadFile(filepath.Join(path, "build.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		build, err := decodeBuildFile(path, bytes.NewReader(data), opts)
+		if err != nil {
+			return nil, err
+		}
+		build.annotateProvenance(data)
+		return build, nil
+	}
+
+	base, err := readYAMLMapping(filepath.Join(path, "build.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	over, err := readYAMLMapping(override)
+	if err != nil {
+		return nil, err
+	}
+	if err := mergeOverride(base, over, ""); err != nil {
+		return nil, fmt.Errorf("applying override %q: %w", override, err)
+	}
+
+	merged, err := yaml.Marshal(base)
+	if err != nil {
+		return nil, fmt.Errorf("applying override %q: %w", override, err)
+	}
+	build, err := decodeBuildFile(path, bytes.NewReader(merged), opts)
+	if err != nil {
+		return nil, fmt.Errorf("applying override %q: %w", override, err)
+	}
+	build.overridePath = override
+	return build, nil
+}
+
+func readYAMLMapping(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]any{}
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	return out, nil
+}
+
+// mergeOverride merges over into base in place. prefix is the dotted path
+// of base, for errors.
+func mergeOverride(base, over map[string]any, prefix string) error {
+	for key, val := range over {
+		if name, ok := strings.CutSuffix(key, "+"); ok {
+			add, ok := val.([]any)
+			if !ok {
+				return fmt.Errorf("%s%s: appending needs a list, got %T", prefix, key, val)
+			}
+			switch existing := base[name].(type) {
+			case nil:
+				base[name] = add
+			case []any:
+				base[name] = append(existing, add...)
+			default:
+				return fmt.Errorf("%s%s: cannot append to %T", prefix, key, existing)
+			}
+			continue
+		}
+
+		if val == nil {
+			delete(base, key)
+			continue
+		}
+		if sub, ok := val.(map[string]any); ok {
+			if existing, ok := base[key].(map[string]any); ok {
+				if err := mergeOverride(existing, sub, prefix+key+"."); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		base[key] = val
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const upstreamRecipe = `name: upstream
+version: 1.0.0
+architectures:
+  - x86_64
+readme_url: https://example.org/readme
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - run:
+        - echo upstream
+`
+
+func writeRecipeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatalf("creating directory for %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestOverrideFileIsMergedOntoRecipe(t *testing.T) {
+	dir := writeRecipeFiles(t, map[string]string{
+		"build.yaml": upstreamRecipe,
+		OverrideFileName: `version: 1.0.1
+readme_url: null
+build:
+  base-image: ubuntu:24.04
+  directives+:
+    - run:
+        - echo site
+`,
+	})
+
+	build, err := LoadBuildFile(dir)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+	if build.Version != "1.0.1" || build.Name != "upstream" || build.ReadmeUrl != "" {
+		t.Fatalf("override not merged: version %q, name %q, readme_url %q", build.Version, build.Name, build.ReadmeUrl)
+	}
+	if build.Build.PackageManager != "apt" {
+		t.Fatalf("merging build replaced its other keys: %+v", build.Build)
+	}
+
+	def, err := build.Generate([]string{t.TempDir()})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	up, site := strings.Index(dockerfile, "echo upstream"), strings.Index(dockerfile, "echo site")
+	if !strings.Contains(dockerfile, "FROM ubuntu:24.04") || up < 0 || site < up {
+		t.Fatalf("unexpected Dockerfile:\n%s", dockerfile)
+	}
+}
+
+func TestExplicitOverrideFile(t *testing.T) {
+	dir := writeRecipeFiles(t, map[string]string{"build.yaml": upstreamRecipe})
+	site := writeRecipeFiles(t, map[string]string{
+		"site.yaml": "version: 2.0.0\n",
+		"bad.yaml":  "build:\n  directives+: nope\n",
+		"typo.yaml": "verison: 2.0.0\n",
+	})
+
+	build, err := LoadBuildFileWithOverride(dir, filepath.Join(site, "site.yaml"))
+	if err != nil {
+		t.Fatalf("LoadBuildFileWithOverride: %v", err)
+	}
+	if build.Version != "2.0.0" {
+		t.Fatalf("version = %q, want 2.0.0", build.Version)
+	}
+
+	for name, wantErr := range map[string]string{
+		"bad.yaml":  "build.directives+: appending needs a list",
+		"typo.yaml": "verison",
+	} {
+		if _, err := LoadBuildFileWithOverride(dir, filepath.Join(site, name)); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("%s: expected error containing %q, got %v", name, wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package recipe
+
+import (
+	"path/filepath"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"go.yaml.in/yaml/v4"
+)
+
+// annotateProvenance records the file and line of every directive of b,
+// including those nested in groups and arch blocks, from data, the YAML b
+// was decoded from. The file is named relative to the recipe roots, e.g.
+// "fsl/build.yaml", so generated output does not depend on where the
+// recipes are checked out.
+func (b *BuildFile) annotateProvenance(data []byte) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return
+	}
+	file := filepath.ToSlash(filepath.Join(filepath.Base(b.dir), "build.yaml"))
+	build := mappingValue(doc.Content[0], "build")
+	annotateDirectives(b.Build.Directives, mappingValue(build, "directives"), file)
+	if stages := mappingValue(build, "stages"); stages != nil && stages.Kind == yaml.SequenceNode {
+		for i := range b.Build.Stages {
+			if i < len(stages.Content) {
+				annotateDirectives(b.Build.Stages[i].Directives, mappingValue(stages.Content[i], "directives"), file)
+			}
+		}
+	}
+}
+
+// annotateDirectives sets the provenance of each directive in ds from the
+// matching item of node, a YAML sequence.
+func annotateDirectives(ds []Directive, node *yaml.Node, file string) {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return
+	}
+	for i := range ds {
+		if i >= len(node.Content) {
+			return
+		}
+		item := node.Content[i]
+		ds[i].provenance = ir.Provenance{File: file, Line: item.Line}
+		if ds[i].Group != nil {
+			annotateDirectives(*ds[i].Group, mappingValue(item, "group"), file)
+		}
+		if ds[i].Layer != nil {
+			annotateDirectives(*ds[i].Layer, mappingValue(item, "layer"), file)
+		}
+		if ds[i].Arch != nil {
+			archs := mappingValue(item, "arch")
+			for arch, list := range *ds[i].Arch {
+				annotateDirectives(list, mappingValue(archs, string(arch)), file)
+			}
+		}
+	}
+}
+
+// mappingValue returns the value of key in the YAML mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// applyProvenance makes d's provenance current in ctx for the duration of
+// its application, so that everything it expands to (group members,
+// template bodies, Starlark calls) is attributed to it. Directives decoded
+// from YAML supply a file and line; others inherit them from the directive
+// that produced them. It returns a function restoring the previous state.
+func (c *Context) applyProvenance(d Directive) func() {
+	saved := c.provenance
+	if d.provenance.File != "" {
+		c.provenance.File = d.provenance.File
+		c.provenance.Line = d.provenance.Line
+	}
+	c.builder = c.builder.SetProvenance(d.Source, c.provenance)
+	return func() { c.provenance = saved }
+}
@@ -0,0 +1,106 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const provenanceRecipe = `name: provenance
+version: 1.0.0
+architectures:
+  - x86_64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - run:
+        - echo top
+    - group:
+        - workdir: /opt
+        - run:
+            - echo grouped
+    - include: common.yaml
+    - starlark:
+        script: |
+          x = 1
+          run("echo starlark")
+`
+
+func TestProvenanceRecordsRecipePositions(t *testing.T) {
+	build, err := loadBuildYAML(t, provenanceRecipe)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+	file := filepath.Base(build.dir) + "/build.yaml"
+	includeDir := t.TempDir()
+	common := "builder: neurodocker\ndirectives:\n  - run:\n      - echo included\n"
+	if err := os.WriteFile(filepath.Join(includeDir, "common.yaml"), []byte(common), 0o644); err != nil {
+		t.Fatalf("writing include: %v", err)
+	}
+
+	def, _, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{includeDir}})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+
+	got := map[string]ir.Provenance{}
+	for _, d := range def.Directives {
+		if run, ok := d.Directive.(ir.RunDirective); ok {
+			got[string(run)] = d.Provenance
+		}
+	}
+	for cmd, want := range map[string]ir.Provenance{
+		"echo top":      {File: file, Line: 12},
+		"echo grouped":  {File: file, Line: 16},
+		"echo included": {File: "common.yaml", Line: 3},
+		"echo starlark": {File: file, Line: 19, StarlarkCall: "<script>:2:4"},
+	} {
+		if got[cmd] != want {
+			t.Fatalf("provenance of %q = %+v, want %+v", cmd, got[cmd], want)
+		}
+	}
+}
+
+func TestProvenanceNamesTemplates(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: provenance
+version: 1.0.0
+architectures:
+  - x86_64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - template:
+        name: miniconda
+        version: latest
+`)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+	def, _, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{t.TempDir()}})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	want := ir.Provenance{File: filepath.Base(build.dir) + "/build.yaml", Line: 12, Template: "miniconda"}
+	var n int
+	for _, d := range def.Directives {
+		if d.Provenance.Template != "" {
+			n++
+			if d.Provenance != want {
+				t.Fatalf("provenance = %+v, want %+v", d.Provenance, want)
+			}
+		}
+	}
+	if n == 0 {
+		t.Fatalf("no directive attributed to the template")
+	}
+}
@@ -0,0 +1,101 @@
+package recipe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/jinja2"
+)
+
+// ReadmePath is where the rendered readme is written inside the image.
+const ReadmePath = "/README.md"
+
+// readmeError reports a readme template that failed to render.
+type readmeError struct {
+	field string
+	err   error
+}
+
+func (e *readmeError) Error() string { return fmt.Sprintf("rendering %s: %v", e.field, e.err) }
+func (e *readmeError) Unwrap() error { return e.err }
+
+// renderedReadme is the readme text plus the rendered structured_readme
+// example, which LintReadme checks against deploy.bins.
+type renderedReadme struct {
+	text    string
+	example string
+}
+
+// renderReadme renders the recipe's readme: the readme template if set,
+// otherwise the structured_readme sections under a "# name version" title.
+// The text is "" when the recipe has neither.
+func (b *BuildFile) renderReadme(ctx *Context) (renderedReadme, error) {
+	render := func(t string) (string, error) {
+		val, err := ctx.evaluateValue(jinja2.TemplateString(t))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(fmt.Sprint(val)), nil
+	}
+
+	if b.Readme != "" {
+		text, err := render(string(b.Readme))
+		if err != nil {
+			return renderedReadme{}, &readmeError{"readme", err}
+		}
+		return renderedReadme{text: text + "\n"}, nil
+	}
+
+	sr := b.StructuredReadme
+	sections := []struct{ title, body, field string }{
+		{"", sr.Description, "description"},
+		{"Documentation", sr.Documentation, "documentation"},
+		{"Example", sr.Example, "example"},
+		{"Citation", sr.Citation, "citation"},
+	}
+	var (
+		out   renderedReadme
+		parts []string
+	)
+	for _, s := range sections {
+		if s.body == "" {
+			continue
+		}
+		text, err := render(s.body)
+		if err != nil {
+			return renderedReadme{}, &readmeError{"structured_readme." + s.field, err}
+		}
+		if s.field == "example" {
+			out.example = text
+		}
+		if s.title != "" {
+			text = "## " + s.title + "\n\n" + text
+		}
+		parts = append(parts, text)
+	}
+	if len(parts) == 0 {
+		return out, nil
+	}
+	title := fmt.Sprintf("# %s %s", ctx.Name, ctx.Version)
+	out.text = title + "\n\n" + strings.Join(parts, "\n\n") + "\n"
+	return out, nil
+}
+
+// addReadme writes text to ReadmePath.
+func addReadme(ctx *Context, text string) {
+	addRootFile(ctx, "<readme>", ReadmePath, text)
+}
+
+// addRootFile writes text to name as root, restoring the current user
+// afterwards.
+func addRootFile(ctx *Context, src ir.SourceID, name, text string) {
+	user := ctx.builder.CurrentUser()
+	if user != "" && user != "root" {
+		ctx.builder = ctx.builder.SetCurrentUser(src, "root")
+	}
+	ctx.builder = ctx.builder.AddLiteralFile(src, name, text, false)
+	if user != "" && user != "root" {
+		ctx.builder = ctx.builder.SetCurrentUser(src, user)
+	}
+}
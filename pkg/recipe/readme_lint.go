@@ -0,0 +1,123 @@
+package recipe
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+var (
+	envAssignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+	badHeadingPattern    = regexp.MustCompile(`^#{1,6}[^#\s]`)
+)
+
+// LintReadme checks the recipe's readme and returns one message per problem:
+//   - structured_readme must have a description, example and citation
+//     (recipes with a free-form readme are exempt);
+//   - every readme template must render, leaving no template syntax behind;
+//   - each command in the example must be one of deploy.bins;
+//   - the rendered markdown must close its code fences and put a space
+//     after heading markers.
+func (b *BuildFile) LintReadme(includeDirs []string) []string {
+	var issues []string
+	sr := b.StructuredReadme
+	if b.Readme == "" {
+		if sr == (StructuredReadme{}) {
+			return []string{"recipe has no readme or structured_readme"}
+		}
+		for _, f := range []struct{ name, value string }{
+			{"description", sr.Description},
+			{"example", sr.Example},
+			{"citation", sr.Citation},
+		} {
+			if strings.TrimSpace(f.value) == "" {
+				issues = append(issues, fmt.Sprintf("structured_readme.%s is missing", f.name))
+			}
+		}
+	}
+
+	def, plan, err := b.GenerateWithStaging(includeDirs)
+	if err != nil {
+		var re *readmeError
+		if errors.As(err, &re) {
+			return append(issues, fmt.Sprintf("%s does not render: %v", re.field, re.err))
+		}
+		return append(issues, fmt.Sprintf("readme not checked, recipe does not generate: %v", err))
+	}
+
+	if strings.Contains(plan.Readme, "{{") || strings.Contains(plan.Readme, "{%") {
+		issues = append(issues, "rendered readme still contains template syntax")
+	}
+	issues = append(issues, lintMarkdown(plan.Readme)...)
+
+	bins := deployBins(def)
+	for _, cmd := range exampleCommands(plan.readmeExample) {
+		if !slices.Contains(bins, cmd) {
+			issues = append(issues, fmt.Sprintf("structured_readme.example runs %q, which is not in deploy.bins", cmd))
+		}
+	}
+	return issues
+}
+
+// deployBins returns the names in the final DEPLOY_BINS of def.
+func deployBins(def *ir.Definition) []string {
+	var bins []string
+	for _, d := range def.FinalStage() {
+		if env, ok := d.Directive.(ir.EnvironmentDirective); ok {
+			if v, ok := env["DEPLOY_BINS"]; ok {
+				bins = strings.Split(v, ":")
+			}
+		}
+	}
+	return bins
+}
+
+// exampleCommands returns the program each example line runs: the first
+// word after an optional "$ " prompt and any VAR=value assignments. Blank
+// lines, comments, code fences and continuation lines are skipped.
+func exampleCommands(example string) []string {
+	var cmds []string
+	continued := false
+	for _, line := range strings.Split(example, "\n") {
+		line = strings.TrimSpace(line)
+		wasContinued := continued
+		continued = strings.HasSuffix(line, "\\")
+		if wasContinued || line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "```") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "$ ")
+		for _, word := range strings.Fields(line) {
+			if envAssignmentPattern.MatchString(word) {
+				continue
+			}
+			cmds = append(cmds, path.Base(word))
+			break
+		}
+	}
+	return cmds
+}
+
+// lintMarkdown reports unclosed code fences and headings without a space
+// after their '#' markers.
+func lintMarkdown(text string) []string {
+	var issues []string
+	inFence := false
+	for i, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+			continue
+		}
+		if !inFence && badHeadingPattern.MatchString(line) {
+			issues = append(issues, fmt.Sprintf("readme line %d: heading needs a space after '#': %q", i+1, line))
+		}
+	}
+	if inFence {
+		issues = append(issues, "readme has an unclosed code fence")
+	}
+	return issues
+}
@@ -1,18 +1,28 @@
 package recipe
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/neurodesk/builder/pkg/common"
 	"github.com/neurodesk/builder/pkg/ir"
 	"github.com/neurodesk/builder/pkg/jinja2"
+	"github.com/neurodesk/builder/pkg/netcache"
+	"github.com/neurodesk/builder/pkg/resolve"
 	starlarkpkg "github.com/neurodesk/builder/pkg/starlark"
 	v "github.com/neurodesk/builder/pkg/validator"
 	"go.yaml.in/yaml/v4"
@@ -56,6 +66,7 @@ type contextFile struct {
 	Name         string
 	HostFilename string
 	Executable   bool
+	Extract      *Extraction
 }
 
 func (c contextFile) isFile() {}
@@ -68,6 +79,7 @@ type httpFile struct {
 	Executable bool
 	Retry      *int
 	Insecure   *bool
+	Extract    *Extraction
 }
 
 func (h httpFile) isFile() {}
@@ -84,10 +96,31 @@ func (l literalFile) isFile() {}
 
 func (l literalFile) GetName() string { return l.Name }
 
+type gitFile struct {
+	Name   string
+	Source netcache.GitSource
+}
+
+func (g gitFile) isFile() {}
+
+func (g gitFile) GetName() string { return g.Name }
+
+type ociFile struct {
+	Name    string
+	Source  netcache.OCISource
+	Extract *Extraction
+}
+
+func (o ociFile) isFile() {}
+
+func (o ociFile) GetName() string { return o.Name }
+
 var (
 	_ file = contextFile{}
 	_ file = httpFile{}
 	_ file = literalFile{}
+	_ file = gitFile{}
+	_ file = ociFile{}
 )
 
 type Context struct {
@@ -98,6 +131,10 @@ type Context struct {
 	IncludeDirectories []string
 	Arch               CPUArchitecture
 
+	// templateDir replaces the directory set with SetTemplateSpecDir for
+	// the recipe; only set on the root context.
+	templateDir string
+
 	builder   ir.Builder
 	parent    *Context
 	variables map[string]jinja2.Value
@@ -109,8 +146,38 @@ type Context struct {
 	deployBins []string
 	deployPath []string
 
+	// Labels derived from recipe metadata, emitted right after FROM so that
+	// explicit labels directives can override them.
+	metadataLabels map[string]string
+
 	// Accumulated commands from Starlark run_command builtins
 	runCommands []string
+
+	// Tests declared by test directives; only populated on the root context.
+	tests []RecipeTest
+
+	// Names of the stages generated so far; only populated on the root context.
+	stages map[string]struct{}
+
+	// The recipe's gpu block when GPU mode is on; only set on the root context.
+	gpu *GPUInfo
+
+	// Set when the recipe was loaded with LoadStrict, to warn about base
+	// images that are not pinned; only set on the root context.
+	strict bool
+
+	// Where the directive being applied came from; see applyProvenance.
+	provenance ir.Provenance
+
+	// Files read while generating; only populated on the root context.
+	inputs map[string]struct{}
+
+	// Template alerts and deprecations; only populated on the root context.
+	notices []TemplateNotice
+	noticed map[string]struct{}
+
+	// Templates used so far; only populated on the root context.
+	templates []TemplateUse
 }
 
 // OnLookup implements jinja2.LookupHook.
@@ -197,6 +264,10 @@ func (c *Context) hasLocal(k string) bool {
 	return false
 }
 
+// HasLocal reports whether the named local context was supplied (--local
+// KEY=DIR), for the starlark.RecipeContext interface.
+func (c *Context) HasLocal(key string) bool { return c.hasLocal(key) }
+
 // AddRunCommand implements starlark.RecipeContext hook to accumulate commands.
 func (c *Context) AddRunCommand(cmd string) { c.runCommands = append(c.runCommands, cmd) }
 
@@ -210,18 +281,70 @@ func (c *Context) InstallPackages(src ir.SourceID, pkgs ...string) error {
 	return c.installPackages(src, pkgs...)
 }
 
+// directiveFromGo decodes a directive from the Go form of its YAML, as
+// produced from Starlark values.
+func directiveFromGo(v any) (Directive, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return Directive{}, fmt.Errorf("encoding directive: %w", err)
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	var d Directive
+	if err := dec.Decode(&d); err != nil {
+		return Directive{}, fmt.Errorf("decoding directive: %w", err)
+	}
+	return d, nil
+}
+
+// AddStarlarkDirective is AddDirective for the Starlark directive builtins;
+// callSite is the script position of the call, recorded as the directive's
+// provenance.
+func (c *Context) AddStarlarkDirective(src ir.SourceID, callSite, kind string, spec any) error {
+	saved := c.provenance
+	c.provenance.StarlarkCall = callSite
+	defer func() { c.provenance = saved }()
+	return c.AddDirective(src, kind, spec)
+}
+
+// AddDirective decodes spec as the body of a `kind:` directive, validates it
+// and applies it, so Starlark builds exactly what the same YAML would.
+func (c *Context) AddDirective(src ir.SourceID, kind string, spec any) error {
+	d, err := directiveFromGo(map[string]any{kind: spec})
+	if err != nil {
+		return fmt.Errorf("%s: %w", kind, err)
+	}
+	if d.kind() != kind {
+		return fmt.Errorf("unknown directive %q", kind)
+	}
+	d.Source = src
+	if err := d.Validate(*c); err != nil {
+		return fmt.Errorf("%s: %w", kind, err)
+	}
+	if err := d.Apply(c); err != nil {
+		return fmt.Errorf("%s: %w", kind, err)
+	}
+	return nil
+}
+
 func (c *Context) Compile() (*ir.Definition, error) {
 	return c.builder.Compile()
 }
 
 func (c *Context) childContext() *Context {
-	return newContext(
+	child := newContext(
 		c.PackageManager,
 		c.Version,
 		c.IncludeDirectories,
 		c.builder,
 		c,
 	)
+	// Recipe identity is not a variable, so OnLookup cannot reach the parent's.
+	child.Name = c.Name
+	child.OriginalVersion = c.OriginalVersion
+	child.Arch = c.Arch
+	child.provenance = c.provenance
+	return child
 }
 
 func (c *Context) parallelJobs() int {
@@ -432,12 +555,53 @@ func (c *Context) addFile(f file) error {
 	return nil
 }
 
+// root returns the outermost context; tests are collected there so that tests
+// declared inside groups and templates are not lost with the child context.
+func (c *Context) root() *Context {
+	for c.parent != nil {
+		c = c.parent
+	}
+	return c
+}
+
+// specDir returns the template spec directory of the recipe.
+func (c *Context) specDir() string {
+	if dir := c.root().templateDir; dir != "" {
+		return dir
+	}
+	return templateSpecDir
+}
+
+// specDir returns the template spec directory of the recipe.
+func (b *BuildFile) specDir() string {
+	if b.templateDir != "" {
+		return b.templateDir
+	}
+	return templateSpecDir
+}
+
+// recordInput notes that generation read path, for StagingPlan.Inputs.
+func (c *Context) recordInput(path string) {
+	r := c.root()
+	if r.inputs == nil {
+		r.inputs = map[string]struct{}{}
+	}
+	r.inputs[path] = struct{}{}
+}
+
 func (c *Context) addBuiltinTest(name string, manual bool, builtin string) {
-	// TODO(joshua): Handle tests
+	r := c.root()
+	r.tests = append(r.tests, RecipeTest{Name: name, Manual: manual, Builtin: builtin})
 }
 
 func (c *Context) addScriptTest(name string, manual bool, executable string, script string) {
-	// TODO(joshua): Handle tests
+	r := c.root()
+	r.tests = append(r.tests, RecipeTest{Name: name, Manual: manual, Executable: executable, Script: script})
+}
+
+func (c *Context) addCommandTest(name string, manual bool, command, expect string, exitCode int) {
+	r := c.root()
+	r.tests = append(r.tests, RecipeTest{Name: name, Manual: manual, Command: command, Expect: expect, ExitCode: exitCode})
 }
 
 var (
@@ -463,6 +627,7 @@ func newContext(
 		parent:    parent,
 		variables: map[string]jinja2.Value{},
 		files:     map[string]file{},
+		stages:    map[string]struct{}{},
 	}
 }
 
@@ -576,16 +741,97 @@ type DeployInfo struct {
 }
 
 type FileInfo struct {
-	Name       jinja2.TemplateString `yaml:"name"`
+	Name       jinja2.TemplateString `yaml:"name,omitempty"`
 	Executable bool                  `yaml:"executable,omitempty"`
 	Retry      *int                  `yaml:"retry,omitempty"`
 	Insecure   *bool                 `yaml:"insecure,omitempty"`
 	Refresh    *bool                 `yaml:"refresh,omitempty"`
 
 	// Only one of the following should be set.
-	Filename jinja2.TemplateString `yaml:"filename,omitempty"` // Path to a file to include.
+	Filename jinja2.TemplateString `yaml:"filename,omitempty"` // Path to a file, directory or glob pattern to include.
 	Url      jinja2.TemplateString `yaml:"url,omitempty"`      // URL to download file from.
 	Contents jinja2.TemplateString `yaml:"contents,omitempty"` // Literal contents of the file.
+	Git      *GitInfo              `yaml:"git,omitempty"`      // Git repository to check out.
+	OCI      *OCIInfo              `yaml:"oci,omitempty"`      // Layer of an OCI artifact to pull.
+
+	// Extract unpacks a tar or zip archive from filename, url or oci into a
+	// directory of the same name when it is staged. StripComponents and
+	// Subdir select the part of the archive that is kept.
+	Extract         bool                  `yaml:"extract,omitempty"`
+	StripComponents int                   `yaml:"strip_components,omitempty"`
+	Subdir          jinja2.TemplateString `yaml:"subdir,omitempty"`
+
+	// Arch gives the source per target architecture instead of the fields
+	// above, e.g. a different url for x86_64 and aarch64.
+	Arch map[CPUArchitecture]FileInfo `yaml:"arch,omitempty"`
+}
+
+// Extraction says how a staged archive is unpacked (files.extract).
+type Extraction struct {
+	StripComponents int
+	Subdir          string
+}
+
+// GitInfo describes a git checkout staged as a directory in the build cache.
+type GitInfo struct {
+	Url        jinja2.TemplateString `yaml:"url"`
+	Ref        jinja2.TemplateString `yaml:"ref,omitempty"`    // Branch or tag.
+	Commit     jinja2.TemplateString `yaml:"commit,omitempty"` // Full commit hash to pin to.
+	Depth      int                   `yaml:"depth,omitempty"`
+	Submodules bool                  `yaml:"submodules,omitempty"`
+}
+
+func (g GitInfo) Validate() error {
+	return v.All(
+		func() error {
+			if g.Url == "" {
+				return fmt.Errorf("git source must have a url")
+			}
+			return nil
+		}(),
+		g.Url.Validate(),
+		g.Ref.Validate(),
+		g.Commit.Validate(),
+		func() error {
+			if g.Ref != "" && g.Commit != "" {
+				return fmt.Errorf("git source must have only one of ref or commit")
+			}
+			if g.Depth < 0 {
+				return fmt.Errorf("git depth must not be negative")
+			}
+			return nil
+		}(),
+	)
+}
+
+// OCIInfo describes a file stored in an OCI registry, such as an artifact
+// pushed with `oras push`, pinned by the digest of its manifest.
+type OCIInfo struct {
+	Ref    jinja2.TemplateString `yaml:"ref"`
+	Digest jinja2.TemplateString `yaml:"digest"`
+	// File selects the layer by its title annotation when the artifact
+	// has more than one.
+	File jinja2.TemplateString `yaml:"file,omitempty"`
+}
+
+func (o OCIInfo) Validate() error {
+	return v.All(
+		func() error {
+			if o.Ref == "" {
+				return fmt.Errorf("oci source must have a ref")
+			}
+			if o.Digest == "" {
+				return fmt.Errorf("oci source must have a digest")
+			}
+			if d := string(o.Digest); !isTemplated(d) && !netcache.ValidDigest(d) {
+				return fmt.Errorf("oci digest %q must be sha256: followed by 64 lowercase hex digits", d)
+			}
+			return nil
+		}(),
+		o.Ref.Validate(),
+		o.Digest.Validate(),
+		o.File.Validate(),
+	)
 }
 
 type GuiApp struct {
@@ -597,6 +843,126 @@ type OptionInfo struct {
 	Description   string `yaml:"description,omitempty"`
 	Default       any    `yaml:"default,omitempty"`
 	VersionSuffix string `yaml:"version_suffix,omitempty"`
+	// Values lists the values `builder matrix` builds the option with.
+	Values []any `yaml:"values,omitempty"`
+}
+
+// versionSuffixPattern keeps suffixed versions valid as image tags.
+var versionSuffixPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]*$`)
+
+func (o OptionInfo) Validate(name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("option name %q is not a valid variable name", name)
+	}
+	switch o.Default.(type) {
+	case nil, bool, int, float64, string:
+	default:
+		return fmt.Errorf("option %q: default must be a boolean, number or string, got %T", name, o.Default)
+	}
+	for _, val := range o.Values {
+		switch val.(type) {
+		case bool, int, float64, string:
+		default:
+			return fmt.Errorf("option %q: values must be booleans, numbers or strings, got %T", name, val)
+		}
+		if o.Default != nil && fmt.Sprintf("%T", val) != fmt.Sprintf("%T", o.Default) {
+			return fmt.Errorf("option %q: value %v does not match the type of the default %v", name, val, o.Default)
+		}
+	}
+	if !versionSuffixPattern.MatchString(o.VersionSuffix) {
+		return fmt.Errorf("option %q: version_suffix %q may only contain letters, digits, '.', '_' and '-'", name, o.VersionSuffix)
+	}
+	return nil
+}
+
+// parse converts a --option value to the type of the option's default. Options
+// without a default are booleans.
+func (o OptionInfo) parse(raw string) (any, error) {
+	switch o.Default.(type) {
+	case nil, bool:
+		return strconv.ParseBool(raw)
+	case int:
+		return strconv.Atoi(raw)
+	case float64:
+		return strconv.ParseFloat(raw, 64)
+	default:
+		return raw, nil
+	}
+}
+
+// optionEnabled reports whether an option value counts as switched on for
+// version_suffix purposes: true, a non-zero number or a non-empty string.
+func optionEnabled(val any) bool {
+	switch val := val.(type) {
+	case bool:
+		return val
+	case int:
+		return val != 0
+	case float64:
+		return val != 0
+	case string:
+		return val != ""
+	default:
+		return val != nil
+	}
+}
+
+// ResolveOptions returns the value of every declared option: the default, or
+// the override given on the command line. Overrides are parsed to the type of
+// the default; unknown option names are an error.
+func (b *BuildFile) ResolveOptions(overrides map[string]string) (map[string]any, error) {
+	names := make([]string, 0, len(b.Options))
+	for name := range b.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for name := range overrides {
+		if _, ok := b.Options[name]; !ok {
+			if len(names) == 0 {
+				return nil, fmt.Errorf("unknown option %q: recipe %s declares no options", name, b.Name)
+			}
+			return nil, fmt.Errorf("unknown option %q (available: %s)", name, strings.Join(names, ", "))
+		}
+	}
+
+	values := make(map[string]any, len(b.Options))
+	for _, name := range names {
+		info := b.Options[name]
+		if raw, ok := overrides[name]; ok {
+			val, err := info.parse(raw)
+			if err != nil {
+				return nil, fmt.Errorf("option %q: invalid value %q: %w", name, raw, err)
+			}
+			values[name] = val
+		} else if info.Default != nil {
+			values[name] = info.Default
+		} else {
+			values[name] = false
+		}
+	}
+	return values, nil
+}
+
+// VersionWithOptions returns the recipe version with the version_suffix of
+// every enabled option appended, in option name order, followed by
+// GPUVersionSuffix in GPU mode.
+func (b *BuildFile) VersionWithOptions(values map[string]any) string {
+	names := make([]string, 0, len(b.Options))
+	for name := range b.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	version := b.Version
+	for _, name := range names {
+		if suffix := b.Options[name].VersionSuffix; suffix != "" && optionEnabled(values[name]) {
+			version += suffix
+		}
+	}
+	if b.GPUEnabled(values) {
+		version += GPUVersionSuffix
+	}
+	return version
 }
 
 type TestBuiltin string
@@ -608,6 +974,13 @@ type TestInfo struct {
 	Executable jinja2.TemplateString `yaml:"executable,omitempty"`
 	Script     jinja2.TemplateString `yaml:"script,omitempty"`
 	Builtin    TestBuiltin           `yaml:"builtin,omitempty"`
+
+	// Command is a smoke test baked into the image (see smoketest) and run
+	// by the deployment tester: it passes when it exits with ExitCode and
+	// its output matches the regular expression Expect, if set.
+	Command  jinja2.TemplateString `yaml:"command,omitempty"`
+	Expect   jinja2.TemplateString `yaml:"expect,omitempty"`
+	ExitCode int                   `yaml:"exit_code,omitempty"`
 }
 
 type BuildKind string
@@ -619,9 +992,27 @@ const (
 type GroupDirective []Directive
 
 func (g GroupDirective) Validate(ctx Context) error {
-	return v.Map(g, func(directive Directive, description string) error {
-		return directive.Validate(ctx)
-	}, "group")
+	return validateDirectives(ctx, g, "group")
+}
+
+// withSources returns a copy of g in which directives without a source are
+// attributed to entry i of the named list inside parent, so explain output
+// can point at them.
+func (g GroupDirective) withSources(parent ir.SourceID, list string) GroupDirective {
+	out := make(GroupDirective, len(g))
+	for i, directive := range g {
+		if directive.Source == "" {
+			directive.Source = nestedSource(parent, "%s[%d]", list, i)
+		}
+		out[i] = directive
+	}
+	return out
+}
+
+// nestedSource names a directive nested inside the one parent identifies,
+// e.g. "directives[2] > template fsl".
+func nestedSource(parent ir.SourceID, format string, a ...any) ir.SourceID {
+	return ir.SourceID(string(parent) + " > " + fmt.Sprintf(format, a...))
 }
 
 func (g GroupDirective) Apply(ctx *Context, with map[string]any) error {
@@ -635,9 +1026,9 @@ func (g GroupDirective) Apply(ctx *Context, with map[string]any) error {
 		child.SetVariable(k, result)
 	}
 
-	for _, directive := range g {
+	for i, directive := range g {
 		if err := directive.Apply(child); err != nil {
-			return fmt.Errorf("applying group directive: %w", err)
+			return fmt.Errorf("applying group[%d] (%s): %w", i, directive.kind(), err)
 		}
 	}
 
@@ -662,6 +1053,39 @@ func (g GroupDirective) Apply(ctx *Context, with map[string]any) error {
 	return nil
 }
 
+// applySingleLayer applies g like Apply and merges the RUNs it adds into
+// one (see ir.MergeRuns), so they produce a single layer. A directive that
+// cannot move past a RUN, such as environment or workdir, between two of
+// them is an error.
+func (g GroupDirective) applySingleLayer(ctx *Context, with map[string]any) error {
+	from := ctx.builder.Len()
+	if err := g.Apply(ctx, with); err != nil {
+		return err
+	}
+	ctx.builder = ctx.builder.MergeRuns(from, 0)
+	def, err := ctx.builder.Compile()
+	if err != nil {
+		return err
+	}
+	var separator *ir.DirectiveWithMetadata
+	runs := 0
+	for i, dm := range def.Directives[from:] {
+		switch dm.Directive.(type) {
+		case ir.RunDirective, ir.RunWithMountsDirective:
+			if runs++; runs > 1 && separator == nil {
+				return fmt.Errorf("cannot merge the RUNs into a single layer: they mount the same target differently")
+			} else if runs > 1 {
+				return fmt.Errorf("cannot merge the RUNs into a single layer: %s separates them", separator.Describe())
+			}
+		default:
+			if runs > 0 && separator == nil {
+				separator = &def.Directives[from+i]
+			}
+		}
+	}
+	return nil
+}
+
 type RunDirective []jinja2.TemplateString
 
 func (r RunDirective) Validate() error {
@@ -787,6 +1211,9 @@ func trimSpacesAfterBackslash(s string) string {
 type FileDirective FileInfo
 
 func (f FileDirective) Validate() error {
+	if f.Arch != nil {
+		return f.validateArch()
+	}
 	return v.All(
 		f.Name.Validate(),
 		func() error {
@@ -809,22 +1236,83 @@ func (f FileDirective) Validate() error {
 				}
 				count++
 			}
+			if f.Git != nil {
+				if err := f.Git.Validate(); err != nil {
+					return fmt.Errorf("validating git: %w", err)
+				}
+				count++
+			}
+			if f.OCI != nil {
+				if err := f.OCI.Validate(); err != nil {
+					return fmt.Errorf("validating oci: %w", err)
+				}
+				count++
+			}
 			if count == 0 {
-				return fmt.Errorf("file must have one of filename, url, or contents")
+				return fmt.Errorf("file must have one of filename, url, contents, git, or oci")
 			}
 			if count > 1 {
-				return fmt.Errorf("file must have only one of filename, url, or contents")
+				return fmt.Errorf("file must have only one of filename, url, contents, git, or oci")
 			}
 			return nil
 		}(),
+		f.validateExtract(),
 	)
 }
 
+func (f FileDirective) validateExtract() error {
+	if !f.Extract {
+		if f.StripComponents != 0 || f.Subdir != "" {
+			return fmt.Errorf("strip_components and subdir require extract: true")
+		}
+		return nil
+	}
+	if f.Filename == "" && f.Url == "" && f.OCI == nil {
+		return fmt.Errorf("extract requires a filename, url or oci source")
+	}
+	if f.Executable {
+		return fmt.Errorf("an extracted file cannot be executable; the archive's modes are kept")
+	}
+	if f.StripComponents < 0 {
+		return fmt.Errorf("strip_components must not be negative")
+	}
+	return f.Subdir.Validate()
+}
+
+// extraction evaluates the extract settings of f, or returns nil when it is
+// not extracted.
+func (f FileDirective) extraction(ctx *Context) (*Extraction, error) {
+	if !f.Extract {
+		return nil, nil
+	}
+	x := &Extraction{StripComponents: f.StripComponents}
+	if f.Subdir != "" {
+		val, err := ctx.evaluateValue(f.Subdir)
+		if err != nil {
+			return nil, fmt.Errorf("evaluating subdir: %w", err)
+		}
+		x.Subdir = fmt.Sprint(val)
+	}
+	return x, nil
+}
+
 func (f FileDirective) Apply(ctx *Context) error {
+	if f.Arch != nil {
+		variant, err := FileInfo(f).forArch(ctx.Arch)
+		if err != nil {
+			return err
+		}
+		f = FileDirective(variant)
+	}
+
 	name, err := ctx.evaluateValue(f.Name)
 	if err != nil {
 		return fmt.Errorf("evaluating file name: %w", err)
 	}
+	extract, err := f.extraction(ctx)
+	if err != nil {
+		return err
+	}
 
 	if f.Filename != "" {
 		val, err := ctx.evaluateValue(f.Filename)
@@ -836,6 +1324,7 @@ func (f FileDirective) Apply(ctx *Context) error {
 			Name:         name.(string),
 			HostFilename: val.(string),
 			Executable:   f.Executable,
+			Extract:      extract,
 		})
 	} else if f.Url != "" {
 		val, err := ctx.evaluateValue(f.Url)
@@ -849,6 +1338,7 @@ func (f FileDirective) Apply(ctx *Context) error {
 			Executable: f.Executable,
 			Retry:      f.Retry,
 			Insecure:   f.Insecure,
+			Extract:    extract,
 		})
 	} else if f.Contents != "" {
 		val, err := ctx.evaluateValue(f.Contents)
@@ -861,6 +1351,60 @@ func (f FileDirective) Apply(ctx *Context) error {
 			Contents:   val.(string),
 			Executable: f.Executable,
 		})
+	} else if f.Git != nil {
+		src := netcache.GitSource{Depth: f.Git.Depth, Submodules: f.Git.Submodules}
+		for _, field := range []struct {
+			tpl  jinja2.TemplateString
+			dst  *string
+			desc string
+		}{
+			{f.Git.Url, &src.URL, "git url"},
+			{f.Git.Ref, &src.Ref, "git ref"},
+			{f.Git.Commit, &src.Commit, "git commit"},
+		} {
+			if field.tpl == "" {
+				continue
+			}
+			val, err := ctx.evaluateValue(field.tpl)
+			if err != nil {
+				return fmt.Errorf("evaluating %s: %w", field.desc, err)
+			}
+			*field.dst = val.(string)
+		}
+
+		return ctx.addFile(gitFile{
+			Name:   name.(string),
+			Source: src,
+		})
+	} else if f.OCI != nil {
+		var src netcache.OCISource
+		for _, field := range []struct {
+			tpl  jinja2.TemplateString
+			dst  *string
+			desc string
+		}{
+			{f.OCI.Ref, &src.Ref, "oci ref"},
+			{f.OCI.Digest, &src.Digest, "oci digest"},
+			{f.OCI.File, &src.File, "oci file"},
+		} {
+			if field.tpl == "" {
+				continue
+			}
+			val, err := ctx.evaluateValue(field.tpl)
+			if err != nil {
+				return fmt.Errorf("evaluating %s: %w", field.desc, err)
+			}
+			*field.dst = fmt.Sprint(val)
+		}
+		if !netcache.ValidDigest(src.Digest) {
+			return fmt.Errorf("oci digest %q must be sha256: followed by 64 lowercase hex digits", src.Digest)
+		}
+
+		return ctx.addFile(ociFile{
+			Name:    name.(string),
+			Source:  src,
+			Extract: extract,
+		})
 	} else {
 		return fmt.Errorf("file directive not implemented")
 	}
@@ -927,78 +1471,450 @@ func (e EntryPointDirective) Apply(ctx *Context, src ir.SourceID) error {
 	return nil
 }
 
-type DeployDirective DeployInfo
+// CmdDirective sets the image CMD. A string is split into words the way a
+// shell would; a list is used verbatim as the exec-form argv.
+type CmdDirective any
 
-func (d DeployDirective) Validate() error {
-	return v.All(
-		v.Map(d.Bins, func(cmd jinja2.TemplateString, description string) error {
-			return cmd.Validate()
-		}, "deploy.bins"),
-		v.Map(d.Path, func(cmd jinja2.TemplateString, description string) error {
-			return cmd.Validate()
-		}, "deploy.path"),
-	)
+func validateCmd(c CmdDirective) error {
+	return validateArgv(c, "cmd")
 }
 
-func (d DeployDirective) Apply(ctx *Context) error {
-	if len(d.Bins) > 0 {
-		var bins []string
-		for _, cmd := range d.Bins {
-			result, err := ctx.evaluateValue(cmd)
-			if err != nil {
-				return fmt.Errorf("evaluating deploy.bin command: %w", err)
-			}
-			s, ok := result.(string)
+// validateArgv checks that val is a template string or a list of them.
+func validateArgv(val any, what string) error {
+	switch val := val.(type) {
+	case string:
+		return jinja2.TemplateString(val).Validate()
+	case []any:
+		return v.Map(val, func(item any, description string) error {
+			s, ok := item.(string)
 			if !ok {
-				return fmt.Errorf("deploy.bin command must be a string, got %T", result)
+				return fmt.Errorf("must be a string, got %T", item)
 			}
-			bins = append(bins, s)
-		}
-		ctx.deployBins = append(ctx.deployBins, bins...)
+			return jinja2.TemplateString(s).Validate()
+		}, what)
+	default:
+		return fmt.Errorf("%s must be a string or list of strings, got %T", what, val)
 	}
+}
 
-	if len(d.Path) > 0 {
-		var path []string
-		for _, cmd := range d.Path {
-			result, err := ctx.evaluateValue(cmd)
-			if err != nil {
-				return fmt.Errorf("evaluating deploy.path command: %w", err)
-			}
-			s, ok := result.(string)
+// evaluateArgv renders a string (split into shell words) or list of strings
+// into an argv.
+func evaluateArgv(ctx *Context, val any, what string) ([]string, error) {
+	render := func(s string) (string, error) {
+		result, err := ctx.evaluateValue(jinja2.TemplateString(s))
+		if err != nil {
+			return "", fmt.Errorf("evaluating %s: %w", what, err)
+		}
+		rs, ok := result.(string)
+		if !ok {
+			return "", fmt.Errorf("%s must be a string, got %T", what, result)
+		}
+		return rs, nil
+	}
+	switch val := val.(type) {
+	case string:
+		s, err := render(val)
+		if err != nil {
+			return nil, err
+		}
+		return shellWords(s)
+	case []any:
+		argv := make([]string, 0, len(val))
+		for i, item := range val {
+			s, ok := item.(string)
 			if !ok {
-				return fmt.Errorf("deploy.path command must be a string, got %T", result)
+				return nil, fmt.Errorf("%s[%d] must be a string, got %T", what, i, item)
 			}
-			path = append(path, s)
+			r, err := render(s)
+			if err != nil {
+				return nil, err
+			}
+			argv = append(argv, r)
 		}
-		ctx.deployPath = append(ctx.deployPath, path...)
+		return argv, nil
+	default:
+		return nil, fmt.Errorf("%s must be a string or list of strings, got %T", what, val)
 	}
-
-	return nil
 }
 
-type EnvironmentDirective map[string]jinja2.TemplateString
+// HealthcheckDirective sets the image HEALTHCHECK. Command follows the cmd
+// rules; durations use Go syntax ("30s", "1m30s"). Disable removes any
+// healthcheck inherited from the base image.
+type HealthcheckDirective struct {
+	Command     any    `yaml:"command,omitempty"`
+	Interval    string `yaml:"interval,omitempty"`
+	Timeout     string `yaml:"timeout,omitempty"`
+	StartPeriod string `yaml:"start-period,omitempty"`
+	Retries     int    `yaml:"retries,omitempty"`
+	Disable     bool   `yaml:"disable,omitempty"`
+}
 
-func (e EnvironmentDirective) Validate() error {
-	for k, val := range e {
-		if err := v.HasNoJinja(k, "environment key"); err != nil {
-			return err
+func (h HealthcheckDirective) durations() (interval, timeout, startPeriod time.Duration, err error) {
+	parse := func(s, field string) (time.Duration, error) {
+		if s == "" {
+			return 0, nil
 		}
-		if err := val.Validate(); err != nil {
-			return fmt.Errorf("environment[%q]: %w", k, err)
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("healthcheck.%s: %w", field, err)
+		}
+		if d < 0 {
+			return 0, fmt.Errorf("healthcheck.%s must not be negative", field)
 		}
+		return d, nil
 	}
-	return nil
+	if interval, err = parse(h.Interval, "interval"); err != nil {
+		return
+	}
+	if timeout, err = parse(h.Timeout, "timeout"); err != nil {
+		return
+	}
+	startPeriod, err = parse(h.StartPeriod, "start-period")
+	return
 }
 
-func (e EnvironmentDirective) Apply(ctx *Context, src ir.SourceID) error {
-	env := map[string]string{}
-	for key, val := range e {
-		result, err := ctx.evaluateValue(val)
-		if err != nil {
-			return fmt.Errorf("evaluating environment[%q]: %w", key, err)
+func (h HealthcheckDirective) Validate() error {
+	if h.Disable {
+		if h.Command != nil {
+			return fmt.Errorf("healthcheck cannot set both command and disable")
 		}
-		s, ok := result.(string)
-		if !ok {
+		return nil
+	}
+	if h.Command == nil {
+		return fmt.Errorf("healthcheck.command must not be empty")
+	}
+	if h.Retries < 0 {
+		return fmt.Errorf("healthcheck.retries must not be negative")
+	}
+	if _, _, _, err := h.durations(); err != nil {
+		return err
+	}
+	return validateCmd(h.Command)
+}
+
+func (h HealthcheckDirective) Apply(ctx *Context, src ir.SourceID) error {
+	if h.Disable {
+		ctx.builder = ctx.builder.SetHealthcheck(src, ir.HealthcheckDirective{})
+		return nil
+	}
+	argv, err := evaluateArgv(ctx, h.Command, "healthcheck.command")
+	if err != nil {
+		return err
+	}
+	if len(argv) == 0 {
+		return fmt.Errorf("healthcheck.command must not be empty")
+	}
+	interval, timeout, startPeriod, err := h.durations()
+	if err != nil {
+		return err
+	}
+	ctx.builder = ctx.builder.SetHealthcheck(src, ir.HealthcheckDirective{
+		Command:     argv,
+		Interval:    interval,
+		Timeout:     timeout,
+		StartPeriod: startPeriod,
+		Retries:     h.Retries,
+	})
+	return nil
+}
+
+// ExposeDirective declares the ports the container listens on. It takes a
+// port number, a string of space-separated ports ("8080 53/udp"), or a list
+// of either. Ports may carry a protocol (tcp, udp, sctp) and be a range
+// ("8000-8010").
+type ExposeDirective any
+
+var exposePortPattern = regexp.MustCompile(`^(\d+)(?:-(\d+))?(?:/(tcp|udp|sctp))?$`)
+
+// validatePort checks a single rendered EXPOSE entry.
+func validatePort(port string) error {
+	m := exposePortPattern.FindStringSubmatch(port)
+	if m == nil {
+		return fmt.Errorf("invalid port %q: want PORT, PORT/PROTO or LOW-HIGH[/PROTO] with PROTO one of tcp, udp, sctp", port)
+	}
+	lo, _ := strconv.Atoi(m[1])
+	hi := lo
+	if m[2] != "" {
+		hi, _ = strconv.Atoi(m[2])
+	}
+	if lo < 1 || hi > 65535 || hi < lo {
+		return fmt.Errorf("invalid port %q: ports must be between 1 and 65535 with low <= high", port)
+	}
+	return nil
+}
+
+// exposeValue converts integer ports into strings so the value can be handled
+// like a cmd argv.
+func exposeValue(e ExposeDirective) any {
+	switch val := any(e).(type) {
+	case int:
+		return strconv.Itoa(val)
+	case []any:
+		out := make([]any, len(val))
+		for i, item := range val {
+			if n, ok := item.(int); ok {
+				out[i] = strconv.Itoa(n)
+			} else {
+				out[i] = item
+			}
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// literalWords returns the space-separated words of the entries in val that
+// contain no template syntax, so they can be checked before rendering.
+func literalWords(val any) []string {
+	var items []string
+	switch val := val.(type) {
+	case string:
+		items = []string{val}
+	case []any:
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+	}
+	var words []string
+	for _, s := range items {
+		if v.HasNoJinja(s, "") == nil {
+			words = append(words, strings.Fields(s)...)
+		}
+	}
+	return words
+}
+
+func validateExpose(e ExposeDirective) error {
+	val := exposeValue(e)
+	if err := validateArgv(val, "expose"); err != nil {
+		return err
+	}
+	for _, port := range literalWords(val) {
+		if err := validatePort(port); err != nil {
+			return fmt.Errorf("expose: %w", err)
+		}
+	}
+	return nil
+}
+
+func applyExpose(ctx *Context, src ir.SourceID, e ExposeDirective) error {
+	ports, err := evaluateArgv(ctx, exposeValue(e), "expose")
+	if err != nil {
+		return err
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("expose must list at least one port")
+	}
+	for _, port := range ports {
+		if err := validatePort(port); err != nil {
+			return fmt.Errorf("expose: %w", err)
+		}
+	}
+	ctx.builder = ctx.builder.AddExpose(src, ports...)
+	return nil
+}
+
+// VolumesDirective declares volume mount points: an absolute path, a string
+// of space-separated paths, or a list of paths.
+type VolumesDirective any
+
+func validateVolumePath(p string) error {
+	if !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("volume path %q must be absolute", p)
+	}
+	return nil
+}
+
+func validateVolumes(d VolumesDirective) error {
+	if err := validateArgv(d, "volumes"); err != nil {
+		return err
+	}
+	for _, p := range literalWords(any(d)) {
+		if err := validateVolumePath(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyVolumes(ctx *Context, src ir.SourceID, d VolumesDirective) error {
+	paths, err := evaluateArgv(ctx, any(d), "volumes")
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("volumes must list at least one path")
+	}
+	for _, p := range paths {
+		if err := validateVolumePath(p); err != nil {
+			return err
+		}
+	}
+	ctx.builder = ctx.builder.AddVolumes(src, paths...)
+	return nil
+}
+
+// ShellDirective replaces the shell used by later run steps and shell-form
+// entrypoints, e.g. [/bin/bash, -o, pipefail, -c]. It follows the cmd rules;
+// the command is passed as the final argument.
+type ShellDirective any
+
+func validateShell(s ShellDirective) error {
+	return validateArgv(s, "shell")
+}
+
+func applyShell(ctx *Context, src ir.SourceID, s ShellDirective) error {
+	argv, err := evaluateArgv(ctx, any(s), "shell")
+	if err != nil {
+		return err
+	}
+	if len(argv) == 0 {
+		return fmt.Errorf("shell must not be empty")
+	}
+	ctx.builder = ctx.builder.SetShell(src, argv)
+	return nil
+}
+
+// ArgsDirective declares build arguments. Values are template defaults; a
+// null value declares the argument without a default. Values passed with
+// --build-arg override the defaults.
+type ArgsDirective map[string]*jinja2.TemplateString
+
+// identifierPattern matches names usable as shell and template variables.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (a ArgsDirective) keys() []string {
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (a ArgsDirective) Validate() error {
+	if len(a) == 0 {
+		return fmt.Errorf("args must declare at least one argument")
+	}
+	for _, k := range a.keys() {
+		if !identifierPattern.MatchString(k) {
+			return fmt.Errorf("args key %q is not a valid variable name", k)
+		}
+		if a[k] == nil {
+			continue
+		}
+		if err := a[k].Validate(); err != nil {
+			return fmt.Errorf("args[%q]: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (a ArgsDirective) Apply(ctx *Context, src ir.SourceID) error {
+	for _, key := range a.keys() {
+		arg := ir.ArgDirective{Name: key}
+		if a[key] != nil {
+			result, err := ctx.evaluateValue(*a[key])
+			if err != nil {
+				return fmt.Errorf("evaluating args[%q]: %w", key, err)
+			}
+			s, ok := result.(string)
+			if !ok {
+				return fmt.Errorf("args[%q] must be a string, got %T", key, result)
+			}
+			arg.Default = s
+			arg.HasDefault = true
+		}
+		ctx.builder = ctx.builder.AddArg(src, arg)
+	}
+	return nil
+}
+
+type DeployDirective DeployInfo
+
+func (d DeployDirective) Validate() error {
+	return v.All(
+		v.Map(d.Bins, func(cmd jinja2.TemplateString, description string) error {
+			return cmd.Validate()
+		}, "deploy.bins"),
+		v.Map(d.Path, func(cmd jinja2.TemplateString, description string) error {
+			return cmd.Validate()
+		}, "deploy.path"),
+	)
+}
+
+func (d DeployDirective) Apply(ctx *Context) error {
+	if len(d.Bins) > 0 {
+		var bins []string
+		for _, cmd := range d.Bins {
+			result, err := ctx.evaluateValue(cmd)
+			if err != nil {
+				return fmt.Errorf("evaluating deploy.bin command: %w", err)
+			}
+			s, ok := result.(string)
+			if !ok {
+				return fmt.Errorf("deploy.bin command must be a string, got %T", result)
+			}
+			bins = append(bins, s)
+		}
+		ctx.deployBins = append(ctx.deployBins, bins...)
+	}
+
+	if len(d.Path) > 0 {
+		var path []string
+		for _, cmd := range d.Path {
+			result, err := ctx.evaluateValue(cmd)
+			if err != nil {
+				return fmt.Errorf("evaluating deploy.path command: %w", err)
+			}
+			s, ok := result.(string)
+			if !ok {
+				return fmt.Errorf("deploy.path command must be a string, got %T", result)
+			}
+			path = append(path, s)
+		}
+		ctx.deployPath = append(ctx.deployPath, path...)
+	}
+
+	return nil
+}
+
+type EnvironmentDirective map[string]jinja2.TemplateString
+
+func (e EnvironmentDirective) keys() []string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (e EnvironmentDirective) Validate() error {
+	for _, k := range e.keys() {
+		if err := v.HasNoJinja(k, "environment key"); err != nil {
+			return err
+		}
+		if err := e[k].Validate(); err != nil {
+			return fmt.Errorf("environment[%q]: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (e EnvironmentDirective) Apply(ctx *Context, src ir.SourceID) error {
+	env := map[string]string{}
+	for _, key := range e.keys() {
+		val := e[key]
+		result, err := ctx.evaluateValue(val)
+		if err != nil {
+			return fmt.Errorf("evaluating environment[%q]: %w", key, err)
+		}
+		s, ok := result.(string)
+		if !ok {
 			return fmt.Errorf("environment[%q] must be a string, got %T", key, result)
 		}
 		env[key] = s
@@ -1007,6 +1923,50 @@ func (e EnvironmentDirective) Apply(ctx *Context, src ir.SourceID) error {
 	return nil
 }
 
+// LabelsDirective adds image labels. Values are templates; keys are literal.
+type LabelsDirective map[string]jinja2.TemplateString
+
+func (l LabelsDirective) keys() []string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (l LabelsDirective) Validate() error {
+	for _, k := range l.keys() {
+		if err := v.NotEmpty(k, "labels key"); err != nil {
+			return err
+		}
+		if err := v.HasNoJinja(k, "labels key"); err != nil {
+			return err
+		}
+		if err := l[k].Validate(); err != nil {
+			return fmt.Errorf("labels[%q]: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (l LabelsDirective) Apply(ctx *Context, src ir.SourceID) error {
+	labels := map[string]string{}
+	for _, key := range l.keys() {
+		result, err := ctx.evaluateValue(l[key])
+		if err != nil {
+			return fmt.Errorf("evaluating labels[%q]: %w", key, err)
+		}
+		s, ok := result.(string)
+		if !ok {
+			return fmt.Errorf("labels[%q] must be a string, got %T", key, result)
+		}
+		labels[key] = s
+	}
+	ctx.builder = ctx.builder.AddLabels(src, labels)
+	return nil
+}
+
 type TestDirective TestInfo
 
 func (t TestDirective) Validate() error {
@@ -1020,16 +1980,27 @@ func (t TestDirective) Validate() error {
 			if t.Builtin != "" {
 				count++
 			}
+			if t.Command != "" {
+				count++
+			}
 			if count == 0 {
-				return fmt.Errorf("test must have one of script, or builtin")
+				return fmt.Errorf("test must have one of script, builtin or command")
 			}
 			if count > 1 {
-				return fmt.Errorf("test must have only one of script, or builtin")
+				return fmt.Errorf("test must have only one of script, builtin or command")
 			}
 			return nil
 		}(),
 		t.Executable.Validate(),
 		t.Script.Validate(),
+		t.Command.Validate(),
+		func() error {
+			if t.Command == "" && (t.Expect != "" || t.ExitCode != 0) {
+				return fmt.Errorf("test.expect and test.exit_code require test.command")
+			}
+			return nil
+		}(),
+		t.Expect.Validate(),
 	)
 }
 
@@ -1067,6 +2038,28 @@ func (t TestDirective) Apply(ctx *Context) error {
 			script,
 		)
 		return nil
+	} else if t.Command != "" {
+		result, err := ctx.evaluateValue(t.Command)
+		if err != nil {
+			return fmt.Errorf("evaluating test command: %w", err)
+		}
+		command, ok := result.(string)
+		if !ok {
+			return fmt.Errorf("test command must be a string, got %T", result)
+		}
+		result, err = ctx.evaluateValue(t.Expect)
+		if err != nil {
+			return fmt.Errorf("evaluating test expect: %w", err)
+		}
+		expect, ok := result.(string)
+		if !ok {
+			return fmt.Errorf("test expect must be a string, got %T", result)
+		}
+		if _, err := regexp.Compile(expect); err != nil {
+			return fmt.Errorf("test expect: %w", err)
+		}
+		ctx.addCommandTest(t.Name, t.Manual, command, expect, t.ExitCode)
+		return nil
 	} else {
 		return fmt.Errorf("test directive not implemented")
 	}
@@ -1081,50 +2074,70 @@ func (t TemplateDirective) Validate(ctx Context) error {
 	if err := v.NotEmpty(t.Name, "template.name"); err != nil {
 		return err
 	}
+	if _, ok := findStarlarkTemplate(ctx.specDir(), ctx.IncludeDirectories, t.Name); ok {
+		return nil
+	}
 
 	params := templateParams(func(k string) (any, bool, error) {
 		val, ok := t.Params[k]
 		return val, ok, nil
 	})
-	templateSpec, err := getTemplateSpec(t.Name)
+	templateSpec, err := getTemplateSpec(ctx.specDir(), t.Name)
 	if err != nil {
+		if starlarkPluginPending(ctx) {
+			return nil
+		}
 		return fmt.Errorf("template %q not found", t.Name)
 	}
 	method, err := params.GetString("method", "binaries")
 	if err != nil {
 		return fmt.Errorf("getting method parameter: %w", err)
 	}
-	if _, err := templateSpec.GetMethodTemplate(method); err != nil {
+	methodTemplate, err := templateSpec.GetMethodTemplate(method)
+	if err != nil {
 		return err
 	}
 	if _, err := loadTemplateMacro(t.Name, method); err != nil {
 		return err
 	}
-	return nil
+	return methodTemplate.checkParams(t.Name, method, t.Params)
 }
 
 func (t TemplateDirective) Apply(ctx *Context, src ir.SourceID) error {
-	params := templateParams(func(k string) (any, bool, error) {
-		if val, ok := t.Params[k]; ok {
-			rss, err := ctx.evaluateValue(val)
-			if err != nil {
-				return nil, false, fmt.Errorf("evaluating template param %q: %w", k, err)
-			}
-
-			switch v := rss.(type) {
-			case bool:
-				if v {
-					return "true", true, nil
-				}
-				return "false", true, nil
-			case int, int32, int64, float32, float64:
-				return fmt.Sprint(v), true, nil
-			}
-
-			return rss, true, nil
+	if path, ok := findStarlarkTemplate(ctx.specDir(), ctx.IncludeDirectories, t.Name); ok {
+		if err := applyStarlarkTemplate(ctx, src, path, t.Params); err != nil {
+			return fmt.Errorf("executing template %q: %w", t.Name, err)
+		}
+		return nil
+	}
+	methodTemplate, method := lookupMethodTemplate(ctx.specDir(), t.Name, t.Params)
+	if methodTemplate == nil {
+		// applyTemplateMacro reports the unknown template or method.
+		methodTemplate = &recipeTemplateSpec{}
+	} else if err := methodTemplate.checkParams(t.Name, method, t.Params); err != nil {
+		return err
+	}
+	// Parameters are evaluated and coerced up front, so a value that does
+	// not fit its declared type fails here rather than rendering a broken
+	// command.
+	values := map[string]any{}
+	for _, k := range slices.Sorted(maps.Keys(t.Params)) {
+		val, err := ctx.evaluateValue(t.Params[k])
+		if err != nil {
+			return fmt.Errorf("evaluating template param %q: %w", k, err)
+		}
+		if val, err = methodTemplate.coerceArgument(t.Name, k, val); err != nil {
+			return err
 		}
-		return nil, false, nil
+		values[k] = val
+	}
+	params := templateParams(func(k string) (any, bool, error) {
+		val, ok := values[k]
+		return val, ok, nil
 	})
+	if path, ok := templateSpecPath(ctx.specDir(), t.Name); ok {
+		ctx.recordInput(path)
+	}
 	if err := applyTemplateMacro(ctx, src, t.Name, params); err != nil {
 		return fmt.Errorf("executing template %q: %w", t.Name, err)
 	}
@@ -1137,43 +2150,33 @@ func (i IncludeDirective) Validate() error {
 	return v.HasNoJinja(string(i), "include")
 }
 
-func (i IncludeDirective) Apply(ctx *Context) error {
+func (i IncludeDirective) Apply(ctx *Context, src ir.SourceID) error {
 	path := string(i)
 
-	var fullPath string
-
-	for _, dir := range ctx.IncludeDirectories {
-		fullPath = filepath.Join(dir, path)
-		if _, err := os.Stat(fullPath); err != nil {
-			if os.IsNotExist(err) {
-				fullPath = ""
-				continue
-			}
-			return fmt.Errorf("stating include file %q: %w", fullPath, err)
-		}
-	}
-
-	if fullPath == "" {
-		return fmt.Errorf("include file %q not found in include directories", path)
+	fullPath, err := resolve.Resolver{IncludeDirs: ctx.IncludeDirectories}.Find("include file", path)
+	if err != nil {
+		return err
 	}
+	ctx.recordInput(fullPath)
 
-	f, err := os.Open(fullPath)
+	data, err := os.ReadFile(fullPath)
 	if err != nil {
 		return err
 	}
 
-	dec := yaml.NewDecoder(f)
+	dec := yaml.NewDecoder(bytes.NewReader(data))
 	dec.KnownFields(true)
 
 	var build IncludeFile
 	if err := dec.Decode(&build); err != nil {
 		return err
 	}
-
-	var group GroupDirective
-	for _, directive := range build.Directives {
-		group = append(group, directive)
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err == nil && len(doc.Content) > 0 {
+		annotateDirectives(build.Directives, mappingValue(doc.Content[0], "directives"), filepath.ToSlash(path))
 	}
+
+	group := GroupDirective(build.Directives).withSources(src, "include "+path)
 	return group.Apply(ctx, map[string]any{})
 }
 
@@ -1258,12 +2261,12 @@ func (b BoutiqueDirective) Validate() error {
 	return v.All(
 		v.NotEmpty(b.Name, "boutique.name"),
 		v.NotEmpty(b.CommandLine, "boutique.command-line"),
-		v.Map(b.Inputs, func(input BoutiqueInput, description string) error {
+		v.Map(b.Inputs, func(input BoutiqueInput, _ string) error {
 			return v.All(
-				v.NotEmpty(input.Id, description+".id"),
-				v.NotEmpty(input.Name, description+".name"),
-				v.NotEmpty(input.ValueKey, description+".value-key"),
-				v.NotEmpty(input.Type, description+".type"),
+				v.NotEmpty(input.Id, "id"),
+				v.NotEmpty(input.Name, "name"),
+				v.NotEmpty(input.ValueKey, "value-key"),
+				v.NotEmpty(input.Type, "type"),
 			)
 		}, "boutique.inputs"),
 	)
@@ -1315,22 +2318,45 @@ func (s StarlarkDirective) Validate(ctx Context) error {
 	return nil
 }
 
+// starlarkVariables returns the variables Starlark sees as context.*,
+// including those of enclosing contexts (top-level variables and options
+// live on the root).
+func (c *Context) starlarkVariables() jinja2.Context {
+	vars := jinja2.Context{
+		"version":        jinja2.StringValue(c.Version),
+		"parallel_jobs":  jinja2.IntValue(c.parallelJobs()),
+		"PackageManager": jinja2.StringValue(string(c.PackageManager)),
+		"arch":           jinja2.StringValue(string(c.Arch)),
+	}
+	var chain []*Context
+	for ctx := c; ctx != nil; ctx = ctx.parent {
+		chain = append(chain, ctx)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key, value := range chain[i].variables {
+			vars[key] = value
+		}
+	}
+	return vars
+}
+
+// enableStarlarkLoad lets load("lib.star", ...) resolve modules like
+// starlark files.
+func (c *Context) enableStarlarkLoad(eval *starlarkpkg.Evaluator) {
+	eval.SetModuleResolver(func(module string) (string, error) {
+		path, err := resolve.Resolver{IncludeDirs: c.IncludeDirectories}.Find("starlark module", module)
+		if err == nil {
+			c.recordInput(path)
+		}
+		return path, err
+	})
+}
+
 func (s StarlarkDirective) Apply(ctx *Context, src ir.SourceID) error {
 	// Create Starlark evaluator with enhanced context
 	eval := starlarkpkg.NewEvaluatorWithStarlarkContext(ctx, src)
 
-	// Prepare context variables for Starlark
-	jinjaCtx := jinja2.Context{
-		"version":        jinja2.StringValue(ctx.Version),
-		"parallel_jobs":  jinja2.IntValue(ctx.parallelJobs()),
-		"PackageManager": jinja2.StringValue(string(ctx.PackageManager)),
-		"arch":           jinja2.StringValue(string(ctx.Arch)),
-	}
-
-	// Add all context variables
-	for key, value := range ctx.variables {
-		jinjaCtx[key] = value
-	}
+	jinjaCtx := ctx.starlarkVariables()
 
 	// Create context objects for Starlark
 	contextObj := starlarkpkg.NewContextObject(jinjaCtx)
@@ -1340,6 +2366,8 @@ func (s StarlarkDirective) Apply(ctx *Context, src ir.SourceID) error {
 	eval.SetGlobalStarlark("context", contextObj)
 	eval.SetGlobalStarlark("local", localObj)
 
+	ctx.enableStarlarkLoad(eval)
+
 	var script string
 
 	if s.Script != "" {
@@ -1347,18 +2375,11 @@ func (s StarlarkDirective) Apply(ctx *Context, src ir.SourceID) error {
 		script = string(s.Script)
 	} else if s.File != "" {
 		// Find and read the file
-		var fullPath string
-		for _, dir := range ctx.IncludeDirectories {
-			fullPath = filepath.Join(dir, s.File)
-			if _, err := os.Stat(fullPath); err == nil {
-				break
-			}
-			fullPath = ""
-		}
-
-		if fullPath == "" {
-			return fmt.Errorf("starlark file %q not found in include directories", s.File)
+		fullPath, err := resolve.Resolver{IncludeDirs: ctx.IncludeDirectories}.Find("starlark file", s.File)
+		if err != nil {
+			return err
 		}
+		ctx.recordInput(fullPath)
 
 		scriptBytes, readErr := os.ReadFile(fullPath)
 		if readErr != nil {
@@ -1367,8 +2388,13 @@ func (s StarlarkDirective) Apply(ctx *Context, src ir.SourceID) error {
 		script = string(scriptBytes)
 	}
 
-	// Execute the Starlark script
-	_, execErr := eval.ExecString(script)
+	// Execute the Starlark script, named after its file so call sites in
+	// provenance and errors point into it.
+	scriptName := "<script>"
+	if s.File != "" {
+		scriptName = s.File
+	}
+	_, execErr := eval.ExecFile(scriptName, script)
 	if execErr != nil {
 		return fmt.Errorf("executing starlark script: %w", execErr)
 	}
@@ -1422,6 +2448,7 @@ type Directive struct {
 	Source ir.SourceID `yaml:"source,omitempty"`
 
 	Group       *GroupDirective       `yaml:"group,omitempty"`
+	Layer       *GroupDirective       `yaml:"layer,omitempty"`
 	Run         *RunDirective         `yaml:"run,omitempty"`
 	File        *FileDirective        `yaml:"file,omitempty"`
 	Install     *InstallDirective     `yaml:"install,omitempty"`
@@ -1437,6 +2464,15 @@ type Directive struct {
 	Variables   *VariablesDirective   `yaml:"variables,omitempty"`
 	Boutique    *BoutiqueDirective    `yaml:"boutique,omitempty"`
 	Starlark    *StarlarkDirective    `yaml:"starlark,omitempty"`
+	Labels      *LabelsDirective      `yaml:"labels,omitempty"`
+	Cmd         *CmdDirective         `yaml:"cmd,omitempty"`
+	Healthcheck *HealthcheckDirective `yaml:"healthcheck,omitempty"`
+	Expose      *ExposeDirective      `yaml:"expose,omitempty"`
+	Volumes     *VolumesDirective     `yaml:"volumes,omitempty"`
+	Shell       *ShellDirective       `yaml:"shell,omitempty"`
+	Args        *ArgsDirective        `yaml:"args,omitempty"`
+	CopyFrom    *CopyFromDirective    `yaml:"copy_from,omitempty"`
+	Arch        *ArchDirective        `yaml:"arch,omitempty"`
 
 	// Optional condition for this directive to be applied.
 	Condition string `yaml:"condition,omitempty"`
@@ -1444,13 +2480,50 @@ type Directive struct {
 	// Variables for the group.
 	With map[string]any `yaml:"with,omitempty"`
 
+	// SingleLayer merges the RUNs of the group into one layer, as layer
+	// does.
+	SingleLayer bool `yaml:"single_layer,omitempty"`
+
+	// Custom names a directive provided by a plugin: a Go handler added
+	// with RegisterCustomDirective or a <name>.star file in the include
+	// directories. CustomParams are passed to it.
 	Custom       string         `yaml:"custom,omitempty"`
 	CustomParams map[string]any `yaml:"customParams,omitempty"`
+
+	// provenance is where the directive was written, for directives decoded
+	// from a recipe or include file.
+	provenance ir.Provenance
+}
+
+// kind returns the YAML key of the directive's action, e.g. "run", for
+// error messages.
+func (d Directive) kind() string {
+	rv := reflect.ValueOf(d)
+	for i := range rv.NumField() {
+		if f := rv.Field(i); f.Kind() == reflect.Pointer && !f.IsNil() {
+			name, _, _ := strings.Cut(rv.Type().Field(i).Tag.Get("yaml"), ",")
+			return name
+		}
+	}
+	if d.Custom != "" {
+		return "custom"
+	}
+	return "empty"
+}
+
+// validateDirectives validates every directive of dirs, placing the errors
+// at description[i].
+func validateDirectives(ctx Context, dirs []Directive, description string) error {
+	return v.Map(dirs, func(directive Directive, _ string) error {
+		return directive.Validate(ctx)
+	}, description)
 }
 
 func (d Directive) Validate(ctx Context) error {
 	if d.Group != nil {
 		return d.Group.Validate(ctx)
+	} else if d.Layer != nil {
+		return validateDirectives(ctx, *d.Layer, "layer")
 	} else if d.Run != nil {
 		return d.Run.Validate()
 	} else if d.File != nil {
@@ -1464,7 +2537,7 @@ func (d Directive) Validate(ctx Context) error {
 			return v.Map(val, func(item any, description string) error {
 				s, ok := item.(string)
 				if !ok {
-					return fmt.Errorf("%s must be a string, got %T", description, item)
+					return fmt.Errorf("must be a string, got %T", item)
 				}
 				return jinja2.TemplateString(s).Validate()
 			}, "install")
@@ -1496,7 +2569,7 @@ func (d Directive) Validate(ctx Context) error {
 			return v.Map(val, func(item any, description string) error {
 				s, ok := item.(string)
 				if !ok {
-					return fmt.Errorf("%s must be a string, got %T", description, item)
+					return fmt.Errorf("must be a string, got %T", item)
 				}
 				return jinja2.TemplateString(s).Validate()
 			}, "copy")
@@ -1509,65 +2582,94 @@ func (d Directive) Validate(ctx Context) error {
 		return d.Boutique.Validate()
 	} else if d.Starlark != nil {
 		return d.Starlark.Validate(ctx)
+	} else if d.Labels != nil {
+		return d.Labels.Validate()
+	} else if d.Cmd != nil {
+		return validateCmd(*d.Cmd)
+	} else if d.Healthcheck != nil {
+		return d.Healthcheck.Validate()
+	} else if d.Expose != nil {
+		return validateExpose(*d.Expose)
+	} else if d.Volumes != nil {
+		return validateVolumes(*d.Volumes)
+	} else if d.Shell != nil {
+		return validateShell(*d.Shell)
+	} else if d.Args != nil {
+		return d.Args.Validate()
+	} else if d.CopyFrom != nil {
+		return d.CopyFrom.Validate()
+	} else if d.Arch != nil {
+		return d.Arch.Validate(ctx)
+	} else if d.Custom != "" {
+		return validateCustom(ctx, d.Custom, d.CustomParams)
 	}
 	return fmt.Errorf("directive must have exactly one action")
 }
 
-func (d Directive) Apply(ctx *Context) error {
-	// Evaluate condition if present
-	if d.Condition != "" {
-		// Evaluate the condition as a boolean Jinja2 expression rather than
-		// rendering it as a template string.
-		condCtx := jinja2.Context{
-			"context":       ctx,
-			"local":         ctx,
-			"parallel_jobs": jinja2.IntValue(ctx.parallelJobs()),
-			"arch":          jinja2.StringValue(string(ctx.Arch)),
-		}
-		for k, v := range ctx.variables {
-			condCtx[k] = v
-		}
-		// Provide top-level helpers for convenience in conditions
-		condCtx["has_local"] = jinja2.CallableValue{Fn: func(args []jinja2.Value) (jinja2.Value, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("has_local expects 1 argument")
-			}
-			return jinja2.BoolValue(ctx.hasLocal(args[0].String())), nil
-		}}
-		condCtx["get_local"] = jinja2.CallableValue{Fn: func(args []jinja2.Value) (jinja2.Value, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("get_local expects 1 argument")
-			}
-			return jinja2.StringValue("/.neurocontainer-local/" + args[0].String()), nil
-		}}
-		condCtx["get_file"] = jinja2.CallableValue{Fn: func(args []jinja2.Value) (jinja2.Value, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("get_file expects 1 argument")
-			}
-			name := args[0].String()
-			if _, ok := ctx.files[name]; ok {
-				return jinja2.StringValue("/.neurocontainer-cache/" + name), nil
-			}
-			return jinja2.StringValue("/.neurocontainer-cache/" + name), nil
-		}}
+// conditionHolds evaluates d.Condition as a boolean Jinja2 expression (not
+// a template) against ctx. A directive without a condition always applies.
+func (d Directive) conditionHolds(ctx *Context) (bool, error) {
+	if d.Condition == "" {
+		return true, nil
+	}
+	ok, err := jinja2.NewEvaluator().Truthy(d.Condition, ctx.conditionContext())
+	if err != nil {
+		return false, fmt.Errorf("evaluating condition %q: %w", d.Condition, err)
+	}
+	return ok, nil
+}
+
+// conditionContext returns the variables a condition sees: those of ctx
+// and its enclosing contexts (so options and top-level variables are
+// visible inside groups and templates), the recipe identity, the target
+// arch and the local and file helpers.
+func (c *Context) conditionContext() jinja2.Context {
+	condCtx := c.starlarkVariables()
+	condCtx["context"] = c
+	condCtx["local"] = c
+	condCtx["name"] = jinja2.StringValue(c.Name)
+	condCtx["original_version"] = jinja2.StringValue(c.OriginalVersion)
+	condCtx["has_local"] = jinja2.CallableValue{Fn: func(args []jinja2.Value) (jinja2.Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("has_local expects 1 argument")
+		}
+		return jinja2.BoolValue(c.hasLocal(args[0].String())), nil
+	}}
+	condCtx["get_local"] = jinja2.CallableValue{Fn: func(args []jinja2.Value) (jinja2.Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("get_local expects 1 argument")
+		}
+		return jinja2.StringValue("/.neurocontainer-local/" + args[0].String()), nil
+	}}
+	condCtx["get_file"] = jinja2.CallableValue{Fn: func(args []jinja2.Value) (jinja2.Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("get_file expects 1 argument")
+		}
+		return jinja2.StringValue("/.neurocontainer-cache/" + args[0].String()), nil
+	}}
+	return condCtx
+}
 
-		ev := jinja2.NewEvaluator()
-		condBool, err := ev.Truthy(d.Condition, condCtx)
-		if err != nil {
-			return fmt.Errorf("evaluating condition %q: %w", d.Condition, err)
-		}
-		if !condBool {
-			// Skip this directive
-			return nil
-		}
+func (d Directive) Apply(ctx *Context) error {
+	if ok, err := d.conditionHolds(ctx); err != nil {
+		return err
+	} else if !ok {
+		return nil
 	}
 
 	if d.Source == "" {
 		d.Source = ir.SourceID(uuid.NewString())
 	}
+	defer ctx.applyProvenance(d)()
 
 	if d.Group != nil {
-		return d.Group.Apply(ctx, d.With)
+		group := d.Group.withSources(d.Source, "group")
+		if d.SingleLayer {
+			return group.applySingleLayer(ctx, d.With)
+		}
+		return group.Apply(ctx, d.With)
+	} else if d.Layer != nil {
+		return d.Layer.withSources(d.Source, "layer").applySingleLayer(ctx, d.With)
 	} else if d.Run != nil {
 		return d.Run.Apply(ctx, d.Source)
 	} else if d.File != nil {
@@ -1631,7 +2733,7 @@ func (d Directive) Apply(ctx *Context) error {
 	} else if d.Template != nil {
 		return d.Template.Apply(ctx, d.Source)
 	} else if d.Include != nil {
-		return d.Include.Apply(ctx)
+		return d.Include.Apply(ctx, d.Source)
 	} else if d.Copy != nil {
 		// string or list (accept []string or []any)
 		copy := any(*d.Copy)
@@ -1704,22 +2806,173 @@ func (d Directive) Apply(ctx *Context) error {
 		return d.Boutique.Apply(ctx, d.Source)
 	} else if d.Starlark != nil {
 		return d.Starlark.Apply(ctx, d.Source)
+	} else if d.Labels != nil {
+		return d.Labels.Apply(ctx, d.Source)
+	} else if d.Cmd != nil {
+		argv, err := evaluateArgv(ctx, any(*d.Cmd), "cmd")
+		if err != nil {
+			return err
+		}
+		ctx.builder = ctx.builder.SetCmd(d.Source, argv)
+		return nil
+	} else if d.Healthcheck != nil {
+		return d.Healthcheck.Apply(ctx, d.Source)
+	} else if d.Expose != nil {
+		return applyExpose(ctx, d.Source, *d.Expose)
+	} else if d.Volumes != nil {
+		return applyVolumes(ctx, d.Source, *d.Volumes)
+	} else if d.Shell != nil {
+		return applyShell(ctx, d.Source, *d.Shell)
+	} else if d.Args != nil {
+		return d.Args.Apply(ctx, d.Source)
+	} else if d.CopyFrom != nil {
+		return d.CopyFrom.Apply(ctx, d.Source)
+	} else if d.Arch != nil {
+		return d.Arch.Apply(ctx, d.Source)
+	} else if d.Custom != "" {
+		return applyCustom(ctx, d.Source, d.Custom, d.CustomParams)
 	} else {
 		return fmt.Errorf("directive not implemented")
 	}
 }
 
+// StageRecipe is a named build stage with its own base image and directives,
+// built before the main image. Stages run in order; the main directives and
+// later stages copy results out of them with copy_from.
+type StageRecipe struct {
+	Name           string                `yaml:"name"`
+	BaseImage      string                `yaml:"base-image"`
+	PackageManager common.PackageManager `yaml:"pkg-manager,omitempty"`
+	Directives     []Directive           `yaml:"directives,omitempty"`
+}
+
+var stageNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
+
+func (s StageRecipe) Validate(ctx Context) error {
+	var nameErr error
+	if !stageNamePattern.MatchString(s.Name) {
+		nameErr = fmt.Errorf("stage name %q must be lowercase letters, digits, '.', '_' or '-', starting with a letter", s.Name)
+	}
+	var pmErr error
+	if s.PackageManager != "" {
+		pmErr = v.MatchesAllowed(s.PackageManager, []common.PackageManager{
+			common.PkgManagerApt,
+			common.PkgManagerYum,
+		}, "pkg-manager")
+	}
+	return v.All(
+		nameErr,
+		v.NotEmpty(s.BaseImage, "base-image"),
+		pmErr,
+		validateDirectives(ctx, s.Directives, "directives"),
+	)
+}
+
+// Generate emits the stage into ctx's builder. The stage's directives run in
+// a child context so deploy settings and variables stay inside the stage.
+func (s StageRecipe) Generate(ctx *Context) error {
+	baseImg, err := ctx.evaluateValue(jinja2.TemplateString(s.BaseImage))
+	if err != nil {
+		return fmt.Errorf("evaluating base image: %w", err)
+	}
+	image, ok := baseImg.(string)
+	if !ok {
+		return fmt.Errorf("base image must be a string, got %T", baseImg)
+	}
+	ctx.checkBaseImage(image)
+
+	src := ir.SourceID("<stage " + s.Name + ">")
+	child := ctx.childContext()
+	if s.PackageManager != "" {
+		child.PackageManager = s.PackageManager
+	}
+	child.builder = child.builder.AddStage(src, s.Name, image).SetCurrentUser(src, "root")
+	for i, directive := range s.Directives {
+		if directive.Source == "" {
+			directive.Source = ir.SourceID(fmt.Sprintf("stage %s directives[%d]", s.Name, i))
+		}
+		if err := directive.Apply(child); err != nil {
+			return fmt.Errorf("applying stage %s directives[%d] (%s): %w", s.Name, i, directive.kind(), err)
+		}
+	}
+	ctx.builder = child.builder
+	ctx.root().stages[s.Name] = struct{}{}
+	return nil
+}
+
+// CopyFromDirective copies files out of an earlier stage. Src is a path or a
+// list of paths in the stage (relative paths are relative to its root); Dest
+// is resolved against the current workdir.
+type CopyFromDirective struct {
+	Stage string                `yaml:"stage"`
+	Src   any                   `yaml:"src"`
+	Dest  jinja2.TemplateString `yaml:"dest"`
+}
+
+func (c CopyFromDirective) Validate() error {
+	return v.All(
+		v.NotEmpty(c.Stage, "copy_from.stage"),
+		v.HasNoJinja(c.Stage, "copy_from.stage"),
+		validateArgv(c.Src, "copy_from.src"),
+		v.NotEmpty(string(c.Dest), "copy_from.dest"),
+		c.Dest.Validate(),
+	)
+}
+
+func (c CopyFromDirective) Apply(ctx *Context, src ir.SourceID) error {
+	if _, ok := ctx.root().stages[c.Stage]; !ok {
+		return fmt.Errorf("copy_from: stage %q is not defined before this directive", c.Stage)
+	}
+	paths, err := evaluateArgv(ctx, c.Src, "copy_from.src")
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("copy_from.src must not be empty")
+	}
+	result, err := ctx.evaluateValue(c.Dest)
+	if err != nil {
+		return fmt.Errorf("evaluating copy_from.dest: %w", err)
+	}
+	dest, ok := result.(string)
+	if !ok {
+		return fmt.Errorf("copy_from.dest must be a string, got %T", result)
+	}
+	if len(paths) > 1 && !strings.HasSuffix(dest, "/") {
+		return fmt.Errorf("copy_from.dest must end with '/' when copying several paths")
+	}
+	ctx.builder = ctx.builder.AddCopyFrom(src, c.Stage, paths, dest)
+	return nil
+}
+
 type BuildRecipe struct {
 	Kind BuildKind `yaml:"kind"`
 
 	BaseImage      string                `yaml:"base-image"`
 	PackageManager common.PackageManager `yaml:"pkg-manager,omitempty"`
 
+	// Stages are built, in order, before the main image.
+	Stages []StageRecipe `yaml:"stages,omitempty"`
+
 	Directives []Directive `yaml:"directives,omitempty"`
 
 	AddDefaultTemplate *bool `yaml:"add-default-template,omitempty"`
 	AddTzdata          *bool `yaml:"add-tzdata,omitempty"`
 	FixLocaleDef       *bool `yaml:"fix-locale-def,omitempty"`
+	// AddOCILabels controls the org.opencontainers.image.* labels derived from
+	// recipe metadata. Defaults to true.
+	AddOCILabels *bool `yaml:"add-oci-labels,omitempty"`
+	// AddReadme controls writing the rendered readme to ReadmePath.
+	// Defaults to true.
+	AddReadme *bool `yaml:"add-readme,omitempty"`
+	// CacheMounts gives RUNs that install packages cache mounts at the
+	// package managers' download caches (see ir.Definition.WithCacheMounts),
+	// so local rebuilds reuse downloads.
+	CacheMounts bool `yaml:"cache_mounts,omitempty"`
+	// MergeRuns merges consecutive RUNs into one layer each (see
+	// ir.MergeRuns), of at most MaxCommandsPerLayer RUNs when it is set.
+	MergeRuns           bool `yaml:"merge_runs,omitempty"`
+	MaxCommandsPerLayer int  `yaml:"max_commands_per_layer,omitempty"`
 }
 
 func (b BuildRecipe) Validate(ctx Context) error {
@@ -1730,12 +2983,30 @@ func (b BuildRecipe) Validate(ctx Context) error {
 			common.PkgManagerApt,
 			common.PkgManagerYum,
 		}, "build.pkg-manager"),
-		v.Map(b.Directives, func(directive Directive, description string) error {
-			return directive.Validate(ctx)
-		}, "build.directives"),
+		v.Map(b.Stages, func(stage StageRecipe, _ string) error {
+			return stage.Validate(ctx)
+		}, "build.stages"),
+		v.NoDuplicates(b.stageNames(), "build.stages names"),
+		validateDirectives(ctx, b.Directives, "build.directives"),
+		validateMaxCommandsPerLayer(b.MaxCommandsPerLayer),
 	)
 }
 
+func validateMaxCommandsPerLayer(n int) error {
+	if n < 0 {
+		return fmt.Errorf("build.max_commands_per_layer must not be negative, got %d", n)
+	}
+	return nil
+}
+
+func (b BuildRecipe) stageNames() []string {
+	names := make([]string, len(b.Stages))
+	for i, s := range b.Stages {
+		names[i] = s.Name
+	}
+	return names
+}
+
 func (b *BuildRecipe) Generate(ctx *Context) error {
 	if b.Kind != BuildKindNeuroDocker {
 		return fmt.Errorf("unsupported build kind: %s", b.Kind)
@@ -1749,6 +3020,19 @@ func (b *BuildRecipe) Generate(ctx *Context) error {
 	if !ok {
 		return fmt.Errorf("base image must be a string, got %T", baseImg)
 	}
+	ctx.checkBaseImage(s)
+	gpu := ctx.root().gpu
+	if gpu != nil {
+		if s, err = gpu.Image(s); err != nil {
+			return err
+		}
+	}
+
+	for _, stage := range b.Stages {
+		if err := stage.Generate(ctx); err != nil {
+			return fmt.Errorf("stage %q: %w", stage.Name, err)
+		}
+	}
 
 	defaultSourceId := ir.SourceID("<default>")
 
@@ -1757,6 +3041,14 @@ func (b *BuildRecipe) Generate(ctx *Context) error {
 	// Always set the user to root initially to ensure we can install packages
 	ctx.builder = ctx.builder.SetCurrentUser(defaultSourceId, "root")
 
+	if gpu != nil {
+		ctx.builder = ctx.builder.AddEnvironment(defaultSourceId, gpu.Environment())
+	}
+
+	if (b.AddOCILabels == nil || *b.AddOCILabels) && len(ctx.metadataLabels) > 0 {
+		ctx.builder = ctx.builder.AddLabels(defaultSourceId, ctx.metadataLabels)
+	}
+
 	if b.AddDefaultTemplate == nil || *b.AddDefaultTemplate {
 		if err := applyTemplateMacro(ctx, defaultSourceId, "_header", func(k string) (any, bool, error) {
 			if k == "method" {
@@ -1769,7 +3061,7 @@ func (b *BuildRecipe) Generate(ctx *Context) error {
 	}
 
 	if err := (GroupDirective{
-		Directive{Run: &RunDirective{
+		Directive{Source: defaultSourceId, Run: &RunDirective{
 			"printf '#!/bin/bash\\nls -la' > /usr/bin/ll",
 			"chmod +x /usr/bin/ll",
 			jinja2.TemplateString(fmt.Sprintf("mkdir -p %s", strings.Join(GLOBAL_MOUNT_POINT_LIST, " "))),
@@ -1781,20 +3073,23 @@ func (b *BuildRecipe) Generate(ctx *Context) error {
 	if (b.AddTzdata == nil || *b.AddTzdata) && ctx.PackageManager == common.PkgManagerApt {
 		install := InstallDirective("tzdata")
 		if err := (GroupDirective{
-			Directive{Environment: &EnvironmentDirective{
+			Directive{Source: defaultSourceId, Environment: &EnvironmentDirective{
 				"DEBIAN_FRONTEND": "noninteractive",
 				"TZ":              "UTC",
 			}},
-			Directive{Install: &install},
-			Directive{Run: &RunDirective{"ln -snf /usr/share/zoneinfo/UTC /etc/localtime && echo UTC > /etc/timezone"}},
+			Directive{Source: defaultSourceId, Install: &install},
+			Directive{Source: defaultSourceId, Run: &RunDirective{"ln -snf /usr/share/zoneinfo/UTC /etc/localtime && echo UTC > /etc/timezone"}},
 		}).Apply(ctx, nil); err != nil {
 			return fmt.Errorf("adding tzdata: %w", err)
 		}
 	}
 
-	for _, directive := range b.Directives {
+	for i, directive := range b.Directives {
+		if directive.Source == "" {
+			directive.Source = ir.SourceID(fmt.Sprintf("directives[%d]", i))
+		}
 		if err := directive.Apply(ctx); err != nil {
-			return fmt.Errorf("applying directive: %w", err)
+			return fmt.Errorf("applying directives[%d] (%s): %w", i, directive.kind(), err)
 		}
 	}
 
@@ -1812,8 +3107,6 @@ func (b *BuildRecipe) Generate(ctx *Context) error {
 		})
 	}
 
-	// TODO(joshua): handle README.md file.
-
 	if b.FixLocaleDef != nil && *b.FixLocaleDef {
 		// No-op for now: older recipes may set this flag. Left intentionally
 		// blank to avoid failing generation.
@@ -1835,13 +3128,20 @@ type AutoUpdateInfo struct {
 }
 
 type BuildFile struct {
+	// SchemaVersion is the recipe format the file is written in; see
+	// CurrentSchemaVersion.
+	SchemaVersion int `yaml:"schema_version,omitempty"`
+
 	Name          string                `yaml:"name"`
 	Version       string                `yaml:"version"`
 	Epoch         int                   `yaml:"epoch,omitempty"`
 	Architectures []CPUArchitecture     `yaml:"architectures"`
 	Options       map[string]OptionInfo `yaml:"options,omitempty"`
+	GPU           *GPUInfo              `yaml:"gpu,omitempty"`
 
 	AutoUpdate *AutoUpdateInfo `yaml:"auto_update,omitempty"`
+	// UpstreamInfo is where new releases appear; see Upstream.
+	UpstreamInfo *UpstreamInfo `yaml:"upstream,omitempty"`
 
 	Build BuildRecipe `yaml:"build"`
 
@@ -1855,6 +3155,8 @@ type BuildFile struct {
 	Icon    string   `yaml:"icon,omitempty"`
 	GuiApps []GuiApp `yaml:"gui_apps,omitempty"`
 
+	Runtime *RuntimeInfo `yaml:"runtime,omitempty"`
+
 	// Deprecated (still supported for backward compatibility)
 	Draft     bool           `yaml:"draft,omitempty"`
 	Variables map[string]any `yaml:"variables,omitempty"`
@@ -1862,8 +3164,71 @@ type BuildFile struct {
 	Files     []FileInfo     `yaml:"files,omitempty"`
 	Tests     any            `yaml:"tests,omitempty"`
 
-	// Forward-compat: allow apptainer_args in recipes but ignore for now.
-	ApptainerArgs any `yaml:"apptainer_args,omitempty"`
+	// VariablesFrom names YAML files of further top-level variables, found
+	// in the recipe directory or the include directories. Their values
+	// override those in variables, later files winning.
+	VariablesFrom []string `yaml:"variables_from,omitempty"`
+
+	// TestData declares files mounted read-only at TestDataMountPoint while
+	// tests run; they are not part of the image.
+	TestData []FileInfo `yaml:"test_data,omitempty"`
+
+	// dir is the directory the build file was loaded from.
+	dir string
+	// overridePath is the override file merged onto it, if any.
+	overridePath string
+	// loadWarnings are the problems a permissive load ignored.
+	loadWarnings []string
+	// loadMode is LoadOptions.Mode.
+	loadMode LoadMode
+	// templateDir is LoadOptions.TemplateDir.
+	templateDir string
+}
+
+// OCI annotation keys derived from recipe metadata.
+const (
+	OCILabelTitle    = "org.opencontainers.image.title"
+	OCILabelVersion  = "org.opencontainers.image.version"
+	OCILabelSource   = "org.opencontainers.image.source"
+	OCILabelLicenses = "org.opencontainers.image.licenses"
+)
+
+// OCILabels returns the org.opencontainers.image.* labels for the recipe:
+// title and version from the recipe, source from auto_update.repo (a GitHub
+// "owner/repo" or a URL) and licenses as an SPDX "AND" expression of the
+// copyright entries. Empty values are omitted.
+func (b *BuildFile) OCILabels() map[string]string {
+	labels := map[string]string{}
+	if b.Name != "" {
+		labels[OCILabelTitle] = b.Name
+	}
+	if b.Version != "" {
+		labels[OCILabelVersion] = b.Version
+	}
+	if b.AutoUpdate != nil && b.AutoUpdate.Repo != "" {
+		repo := b.AutoUpdate.Repo
+		if !strings.Contains(repo, "://") {
+			repo = "https://github.com/" + strings.Trim(repo, "/")
+		}
+		labels[OCILabelSource] = repo
+	}
+	var licenses []string
+	seen := map[string]bool{}
+	for _, c := range b.Copyright {
+		l := strings.TrimSpace(c.License)
+		if l == "" || seen[l] {
+			continue
+		}
+		seen[l] = true
+		if strings.Contains(l, " ") {
+			l = "(" + l + ")"
+		}
+		licenses = append(licenses, l)
+	}
+	if len(licenses) > 0 {
+		labels[OCILabelLicenses] = strings.Join(licenses, " AND ")
+	}
+	return labels
 }
 
 func (b *BuildFile) Validate(ctx Context) error {
@@ -1877,6 +3242,17 @@ func (b *BuildFile) Validate(ctx Context) error {
 		v.Map(b.Files, func(fi FileInfo, description string) error {
 			return FileDirective(fi).Validate()
 		}, "files"),
+		v.Map(b.TestData, func(fi FileInfo, description string) error {
+			return FileDirective(fi).Validate()
+		}, "test_data"),
+		v.MapDict(b.Options, func(name string, info OptionInfo) error {
+			return info.Validate(name)
+		}, "options"),
+		b.GPU.Validate(b),
+		b.UpstreamInfo.Validate(),
+		b.Runtime.Validate(),
+		b.validateVariableSources(),
+		b.validateSchemaVersion(),
 	)
 }
 
@@ -1888,10 +3264,54 @@ type StagedFile struct {
 	HostFilename string
 	URL          string
 	Contents     string
+	// Git is staged as a directory rather than a single file.
+	Git *netcache.GitSource
+	// OCI is a layer of an artifact in an OCI registry.
+	OCI *netcache.OCISource
+	// Extract, when set, stages the HostFilename, URL or OCI archive
+	// unpacked into a directory.
+	Extract *Extraction
+}
+
+// TestDataMountPoint is where `builder test` mounts the recipe's test_data
+// files (read-only). Tests also see it as $TEST_DATA_DIR.
+const TestDataMountPoint = "/.neurocontainer-test-data"
+
+// RecipeTest is a test declared by a test directive, with templates rendered.
+type RecipeTest struct {
+	Name       string
+	Manual     bool
+	Builtin    string
+	Executable string
+	Script     string
+	Command    string
+	Expect     string
+	ExitCode   int
 }
 
 type StagingPlan struct {
 	Files []StagedFile
+	// TestData lists files staged only for `builder test`, never into the image.
+	TestData []StagedFile
+	// Tests are the recipe's test directives in declaration order.
+	Tests []RecipeTest
+	// Readme is the rendered readme (see ReadmePath); empty when the
+	// recipe has none.
+	Readme string
+	// Inputs are the files generation read, sorted: the build file and
+	// override, include, variables, starlark and template files from the
+	// template and include directories, and host files staged by
+	// files.filename. Built-in templates are part of the builder and are
+	// not listed.
+	Inputs []string
+	// TemplateNotices are the alerts and deprecation notices of the
+	// templates the recipe uses, in the order they were first applied.
+	TemplateNotices []TemplateNotice
+	// Templates are the templates the recipe uses, in the order they were
+	// first applied.
+	Templates []TemplateUse
+
+	readmeExample string
 }
 
 func (b *BuildFile) Generate(includeDirs []string) (*ir.Definition, error) {
@@ -1910,18 +3330,39 @@ func (b *BuildFile) GenerateWithStaging(includeDirs []string) (*ir.Definition, *
 // GenerateWithStagingAndLocals is like GenerateWithStaging, but allows the caller
 // to specify which optional local contexts are available (by key).
 func (b *BuildFile) GenerateWithStagingAndLocals(includeDirs []string, locals []string) (*ir.Definition, *StagingPlan, error) {
+	return b.GenerateWithParams(GenerateParams{IncludeDirs: includeDirs, Locals: locals})
+}
+
+// GenerateParams are the caller-supplied inputs to generation.
+type GenerateParams struct {
+	IncludeDirs []string
+	// Locals are the keys of the optional named local contexts available.
+	Locals []string
+	// Options override option defaults by name (see ResolveOptions).
+	Options map[string]string
+	// Arch selects the target architecture, which must be one the recipe
+	// declares. Empty picks the host architecture if declared, else the
+	// first one.
+	Arch CPUArchitecture
+}
+
+// GenerateWithParams builds the IR and staging plan from params.
+func (b *BuildFile) GenerateWithParams(params GenerateParams) (*ir.Definition, *StagingPlan, error) {
 	ctx := newContext(
 		b.Build.PackageManager,
 		b.Version,
-		includeDirs,
+		params.IncludeDirs,
 		ir.New(),
 		nil,
 	)
 	ctx.Name = b.Name
+	ctx.templateDir = b.templateDir
+	ctx.metadataLabels = b.OCILabels()
+	ctx.strict = b.loadMode == LoadStrict
 
-	if len(locals) > 0 {
-		ctx.locals = make(map[string]struct{}, len(locals))
-		for _, k := range locals {
+	if len(params.Locals) > 0 {
+		ctx.locals = make(map[string]struct{}, len(params.Locals))
+		for _, k := range params.Locals {
 			if k == "" {
 				continue
 			}
@@ -1929,9 +3370,14 @@ func (b *BuildFile) GenerateWithStagingAndLocals(includeDirs []string, locals []
 		}
 	}
 
-	// Prefer the current host architecture when the recipe explicitly supports it.
-	// This keeps generated template URLs aligned with the actual build platform.
-	if hostArch, ok := currentHostArchitecture(); ok {
+	if params.Arch != "" {
+		if !slices.Contains(b.Architectures, params.Arch) {
+			return nil, nil, fmt.Errorf("recipe %s does not support architecture %s", b.Name, params.Arch)
+		}
+		ctx.Arch = params.Arch
+	} else if hostArch, ok := currentHostArchitecture(); ok {
+		// Prefer the current host architecture when the recipe explicitly supports it.
+		// This keeps generated template URLs aligned with the actual build platform.
 		for _, arch := range b.Architectures {
 			if arch == hostArch {
 				ctx.Arch = hostArch
@@ -1945,23 +3391,34 @@ func (b *BuildFile) GenerateWithStagingAndLocals(includeDirs []string, locals []
 		ctx.Arch = b.Architectures[0]
 	}
 
-	// Expose declared options (with defaults) to template/evaluator as context.options
-	if len(b.Options) > 0 {
-		optVals := make(map[string]any, len(b.Options))
-		for k, info := range b.Options {
-			if info.Default != nil {
-				optVals[k] = info.Default
-			} else {
-				// If no explicit default, assume false-y
-				optVals[k] = false
-			}
+	// Expose resolved options to templates and Starlark as context.options.
+	// Options with a version_suffix change context.version (and the image
+	// version label); context.original_version keeps the recipe version.
+	options, err := b.ResolveOptions(params.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(options) > 0 {
+		ctx.SetVariable("options", options)
+	}
+	if b.GPUEnabled(options) {
+		ctx.gpu = b.GPU
+		ctx.SetVariable("gpu", map[string]any{"cuda": b.GPU.CUDA, "cudnn": b.GPU.CuDNN})
+	}
+	if version := b.VersionWithOptions(options); version != b.Version {
+		ctx.Version = version
+		if _, ok := ctx.metadataLabels[OCILabelVersion]; ok {
+			ctx.metadataLabels[OCILabelVersion] = version
 		}
-		ctx.SetVariable("options", optVals)
 	}
 
 	// Apply top-level variables early so they are available to directives
-	if len(b.Variables) > 0 {
-		vars := VariablesDirective(b.Variables)
+	if len(b.Variables) > 0 || len(b.VariablesFrom) > 0 {
+		resolved, err := b.resolveVariables(params.IncludeDirs, ctx.recordInput)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolving top-level variables: %w", err)
+		}
+		vars := VariablesDirective(resolved)
 		if err := vars.Apply(ctx); err != nil {
 			return nil, nil, fmt.Errorf("applying top-level variables: %w", err)
 		}
@@ -1978,46 +3435,100 @@ func (b *BuildFile) GenerateWithStagingAndLocals(includeDirs []string, locals []
 		return nil, nil, fmt.Errorf("generating build: %w", err)
 	}
 
+	readme, err := b.renderReadme(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if readme.text != "" && (b.Build.AddReadme == nil || *b.Build.AddReadme) {
+		addReadme(ctx, readme.text)
+	}
+	if err := addSmokeTests(ctx, ctx.tests); err != nil {
+		return nil, nil, err
+	}
+
 	def, err := ctx.Compile()
 	if err != nil {
 		return nil, nil, err
 	}
+	if b.Build.MergeRuns {
+		def = def.MergeRuns(b.Build.MaxCommandsPerLayer)
+	}
+	if b.Build.CacheMounts {
+		def = def.WithCacheMounts()
+	}
+
+	// Test data is rendered in its own context so its names cannot collide
+	// with (or be referenced through get_file as) image files.
+	testDataCtx := ctx.childContext()
+	for _, f := range b.TestData {
+		if err := FileDirective(f).Apply(testDataCtx); err != nil {
+			return nil, nil, fmt.Errorf("adding test data %q: %w", f.Name, err)
+		}
+	}
+
+	plan := &StagingPlan{
+		Files:    stagedFiles(ctx.files),
+		TestData: stagedFiles(testDataCtx.files),
+		Tests:    ctx.tests,
+		Readme:   readme.text,
+
+		readmeExample: readme.example,
+	}
+	plan.Inputs = b.inputs(ctx, plan)
+	plan.TemplateNotices = ctx.notices
+	plan.Templates = ctx.templates
 
-	// Collect staging files from ctx.files
-	plan := &StagingPlan{}
-	for name, f := range ctx.files {
+	return def, plan, nil
+}
+
+// stagedFiles converts registered files into a staging list sorted by name.
+func stagedFiles(files map[string]file) []StagedFile {
+	var out []StagedFile
+	for name, f := range files {
 		switch t := f.(type) {
 		case contextFile:
-			plan.Files = append(plan.Files, StagedFile{Name: name, Executable: t.Executable, HostFilename: t.HostFilename})
+			out = append(out, StagedFile{Name: name, Executable: t.Executable, HostFilename: t.HostFilename, Extract: t.Extract})
 		case httpFile:
-			plan.Files = append(plan.Files, StagedFile{Name: name, Executable: t.Executable, URL: t.URL})
+			out = append(out, StagedFile{Name: name, Executable: t.Executable, URL: t.URL, Extract: t.Extract})
 		case literalFile:
-			plan.Files = append(plan.Files, StagedFile{Name: name, Executable: t.Executable, Contents: t.Contents})
+			out = append(out, StagedFile{Name: name, Executable: t.Executable, Contents: t.Contents})
+		case gitFile:
+			src := t.Source
+			out = append(out, StagedFile{Name: name, Git: &src})
+		case ociFile:
+			src := t.Source
+			out = append(out, StagedFile{Name: name, OCI: &src, Extract: t.Extract})
 		}
 	}
-	// Sort plan for determinism
-	sort.Slice(plan.Files, func(i, j int) bool { return plan.Files[i].Name < plan.Files[j].Name })
-
-	return def, plan, nil
+	// Sort for determinism
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
 }
 
+// LoadBuildFile loads and validates the build.yaml in path, applying
+// build.override.yaml from the same directory if there is one.
 func LoadBuildFile(path string) (*BuildFile, error) {
-	buildYaml := filepath.Join(path, "build.yaml")
-
-	f, err := os.Open(buildYaml)
-	if err != nil {
-		return nil, err
-	}
+	return LoadBuildFileWithOptions(path, LoadOptions{})
+}
 
-	dec := yaml.NewDecoder(f)
+func decodeBuildFile(path string, r io.Reader, opts LoadOptions) (*BuildFile, error) {
+	dec := yaml.NewDecoder(r)
 	dec.KnownFields(true)
 
 	var build BuildFile
-	if err := dec.Decode(&build); err != nil {
+	// Unknown fields do not stop decoding: the rest of the file is decoded
+	// either way.
+	warnings, err := checkUnknownFields(dec.Decode(&build), opts.Mode)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := build.Validate(Context{}); err != nil {
+	build.dir = path
+	build.loadWarnings = warnings
+	build.loadMode = opts.Mode
+	build.templateDir = opts.TemplateDir
+
+	if err := build.Validate(Context{templateDir: opts.TemplateDir}); err != nil {
 		return nil, fmt.Errorf("validating build file %q: %w", path, err)
 	}
 
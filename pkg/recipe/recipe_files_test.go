@@ -0,0 +1,270 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/jinja2"
+)
+
+func TestGitFileIsAddedToStagingPlan(t *testing.T) {
+	dir := t.TempDir()
+	buildYAML := `name: gitsrc
+version: 1.2.3
+
+files:
+  - name: src
+    git:
+      url: https://example.com/{{ context.name }}.git
+      commit: 0123456789abcdef0123456789abcdef01234567
+      depth: 5
+      submodules: true
+
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - run:
+        - ls {{ get_file("src") }}
+`
+	if err := os.WriteFile(filepath.Join(dir, "build.yaml"), []byte(buildYAML), 0o644); err != nil {
+		t.Fatalf("writing build.yaml: %v", err)
+	}
+
+	build, err := LoadBuildFile(dir)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+
+	_, plan, err := build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("generating build: %v", err)
+	}
+
+	if len(plan.Files) != 1 || plan.Files[0].Git == nil {
+		t.Fatalf("expected a single git staged file, got %+v", plan.Files)
+	}
+	got := *plan.Files[0].Git
+	if got.URL != "https://example.com/gitsrc.git" {
+		t.Fatalf("unexpected git url %q", got.URL)
+	}
+	if got.Commit != "0123456789abcdef0123456789abcdef01234567" || got.Depth != 5 || !got.Submodules {
+		t.Fatalf("unexpected git source %+v", got)
+	}
+}
+
+func TestGitFileValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		info FileInfo
+		ok   bool
+	}{
+		{"url only", FileInfo{Name: "a", Git: &GitInfo{Url: "https://example.com/a.git"}}, true},
+		{"missing url", FileInfo{Name: "a", Git: &GitInfo{Ref: "main"}}, false},
+		{"ref and commit", FileInfo{Name: "a", Git: &GitInfo{Url: "u", Ref: "main", Commit: "abc"}}, false},
+		{"negative depth", FileInfo{Name: "a", Git: &GitInfo{Url: "u", Depth: -1}}, false},
+		{"git and url", FileInfo{Name: "a", Url: "https://example.com", Git: &GitInfo{Url: "u"}}, false},
+	}
+	for _, tt := range tests {
+		err := FileDirective(tt.info).Validate()
+		if tt.ok && err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Fatalf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestTestDataAndTestsAreCollectedSeparately(t *testing.T) {
+	dir := t.TempDir()
+	buildYAML := `name: withdata
+version: 2.0.0
+
+files:
+  - name: installer.sh
+    contents: echo install
+
+test_data:
+  - name: sample.nii.gz
+    url: https://example.com/{{ context.name }}/sample.nii.gz
+  - name: expected.txt
+    contents: "{{ context.version }}"
+
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - group:
+        - test:
+            name: roundtrip
+            script: tool $TEST_DATA_DIR/sample.nii.gz
+    - test:
+        name: interactive
+        manual: true
+        script: tool --gui
+`
+	if err := os.WriteFile(filepath.Join(dir, "build.yaml"), []byte(buildYAML), 0o644); err != nil {
+		t.Fatalf("writing build.yaml: %v", err)
+	}
+	build, err := LoadBuildFile(dir)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	_, plan, err := build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("generating build: %v", err)
+	}
+
+	if len(plan.Files) != 1 || plan.Files[0].Name != "installer.sh" {
+		t.Fatalf("test data leaked into image files: %+v", plan.Files)
+	}
+	if len(plan.TestData) != 2 {
+		t.Fatalf("expected 2 test data files, got %+v", plan.TestData)
+	}
+	if plan.TestData[0].Name != "expected.txt" || plan.TestData[0].Contents != "2.0.0" {
+		t.Fatalf("unexpected literal test data %+v", plan.TestData[0])
+	}
+	if plan.TestData[1].URL != "https://example.com/withdata/sample.nii.gz" {
+		t.Fatalf("unexpected url test data %+v", plan.TestData[1])
+	}
+
+	if len(plan.Tests) != 2 {
+		t.Fatalf("expected 2 tests, got %+v", plan.Tests)
+	}
+	if plan.Tests[0].Name != "roundtrip" || plan.Tests[0].Script != "tool $TEST_DATA_DIR/sample.nii.gz" {
+		t.Fatalf("unexpected first test %+v", plan.Tests[0])
+	}
+	if !plan.Tests[1].Manual {
+		t.Fatalf("expected second test to be manual: %+v", plan.Tests[1])
+	}
+}
+
+func TestExtractedFileIsAddedToStagingPlan(t *testing.T) {
+	dir := t.TempDir()
+	buildYAML := `name: archived
+version: 6.0.7
+
+files:
+  - name: tool
+    url: https://example.com/tool-{{ context.version }}.tar.gz
+    extract: true
+    strip_components: 1
+    subdir: share/tool-{{ context.version }}
+
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - copy: tool /opt/tool
+`
+	if err := os.WriteFile(filepath.Join(dir, "build.yaml"), []byte(buildYAML), 0o644); err != nil {
+		t.Fatalf("writing build.yaml: %v", err)
+	}
+	build, err := LoadBuildFile(dir)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	_, plan, err := build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("generating build: %v", err)
+	}
+	if len(plan.Files) != 1 || plan.Files[0].Extract == nil {
+		t.Fatalf("expected a single extracted staged file, got %+v", plan.Files)
+	}
+	if got := *plan.Files[0].Extract; got.StripComponents != 1 || got.Subdir != "share/tool-6.0.7" {
+		t.Fatalf("unexpected extraction %+v", got)
+	}
+}
+
+func TestExtractValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		info FileInfo
+		ok   bool
+	}{
+		{"url", FileInfo{Name: "a", Url: "https://example.com/a.tgz", Extract: true, StripComponents: 1}, true},
+		{"filename", FileInfo{Name: "a", Filename: "a.zip", Extract: true, Subdir: "bin"}, true},
+		{"contents", FileInfo{Name: "a", Contents: "x", Extract: true}, false},
+		{"git", FileInfo{Name: "a", Git: &GitInfo{Url: "u"}, Extract: true}, false},
+		{"executable", FileInfo{Name: "a", Url: "u", Extract: true, Executable: true}, false},
+		{"negative strip", FileInfo{Name: "a", Url: "u", Extract: true, StripComponents: -1}, false},
+		{"strip without extract", FileInfo{Name: "a", Url: "u", StripComponents: 1}, false},
+		{"arch inherits extract", FileInfo{Name: "a", Extract: true, StripComponents: 1, Arch: map[CPUArchitecture]FileInfo{
+			CPUArchAMD64: {Url: "https://example.com/a-x86_64.tgz"},
+		}}, true},
+	}
+	for _, tt := range tests {
+		err := FileDirective(tt.info).Validate()
+		if tt.ok && err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Fatalf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestOCIFileValidation(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		name string
+		info FileInfo
+		ok   bool
+	}{
+		{"ref and digest", FileInfo{Name: "a", OCI: &OCIInfo{Ref: "ghcr.io/neurodesk/data", Digest: jinja2.TemplateString(digest)}}, true},
+		{"templated digest", FileInfo{Name: "a", OCI: &OCIInfo{Ref: "ghcr.io/neurodesk/data", Digest: "{{ context.digest }}"}}, true},
+		{"missing digest", FileInfo{Name: "a", OCI: &OCIInfo{Ref: "ghcr.io/neurodesk/data"}}, false},
+		{"tag as digest", FileInfo{Name: "a", OCI: &OCIInfo{Ref: "ghcr.io/neurodesk/data", Digest: "latest"}}, false},
+		{"oci and url", FileInfo{Name: "a", Url: "https://example.com", OCI: &OCIInfo{Ref: "r", Digest: jinja2.TemplateString(digest)}}, false},
+		{"extract", FileInfo{Name: "a", Extract: true, OCI: &OCIInfo{Ref: "r", Digest: jinja2.TemplateString(digest)}}, true},
+	}
+	for _, tt := range tests {
+		err := FileDirective(tt.info).Validate()
+		if tt.ok && err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Fatalf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestOCIFileIsAddedToStagingPlan(t *testing.T) {
+	dir := writeRecipeFiles(t, map[string]string{
+		"build.yaml": `name: model
+version: "2.1"
+files:
+  - name: weights
+    oci:
+      ref: ghcr.io/neurodesk/{{ context.name }}:{{ context.version }}
+      digest: sha256:` + strings.Repeat("0f", 32) + `
+      file: weights.bin
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - run: ["cp {{ get_file('weights') }} /opt/weights.bin"]
+`,
+	})
+	build, err := LoadBuildFile(dir)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	_, plan, err := build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("generating build: %v", err)
+	}
+	if len(plan.Files) != 1 || plan.Files[0].OCI == nil {
+		t.Fatalf("expected a single oci staged file, got %+v", plan.Files)
+	}
+	if got := *plan.Files[0].OCI; got.Ref != "ghcr.io/neurodesk/model:2.1" || got.File != "weights.bin" {
+		t.Fatalf("unexpected oci source %+v", got)
+	}
+}
@@ -0,0 +1,68 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func generateLabels(t *testing.T, buildYAML string) map[string]string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "build.yaml"), []byte(buildYAML), 0o644); err != nil {
+		t.Fatalf("writing build.yaml: %v", err)
+	}
+	build, err := LoadBuildFile(dir)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, err := build.Generate(nil)
+	if err != nil {
+		t.Fatalf("generating build: %v", err)
+	}
+	return def.Labels()
+}
+
+func TestLabelsDirectiveOverridesMetadataLabels(t *testing.T) {
+	labels := generateLabels(t, `name: labelled
+version: 1.0.0
+copyright:
+  - license: GPL-3.0-or-later
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - labels:
+        org.opencontainers.image.title: Labelled Tool
+        maintainer: "{{ context.name }}@example.com"
+`)
+	want := map[string]string{
+		OCILabelTitle:    "Labelled Tool",
+		OCILabelVersion:  "1.0.0",
+		OCILabelLicenses: "GPL-3.0-or-later",
+		"maintainer":     "labelled@example.com",
+	}
+	if len(labels) != len(want) {
+		t.Fatalf("labels = %v, want %v", labels, want)
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Fatalf("labels[%q] = %q, want %q", k, labels[k], v)
+		}
+	}
+}
+
+func TestMetadataLabelsCanBeDisabled(t *testing.T) {
+	labels := generateLabels(t, `name: unlabelled
+version: 1.0.0
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-oci-labels: false
+`)
+	if len(labels) != 0 {
+		t.Fatalf("expected no labels, got %v", labels)
+	}
+}
@@ -0,0 +1,118 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const optionsRecipe = `name: optioned
+version: 2.0.0
+options:
+  gpu:
+    description: Build with CUDA support
+    version_suffix: -gpu
+  jobs:
+    default: 4
+  flavour:
+    default: minimal
+    version_suffix: -full
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - run:
+        - echo "{{ context.version }} {{ context.original_version }} jobs={{ context.options.jobs }}"
+    - group:
+        - starlark:
+            script: |
+              def main():
+                  if context.options["gpu"]:
+                      run_command("echo gpu-enabled")
+              main()
+`
+
+func TestResolveOptions(t *testing.T) {
+	build, err := loadBuildYAML(t, optionsRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	values, err := build.ResolveOptions(map[string]string{"gpu": "true", "jobs": "8"})
+	if err != nil {
+		t.Fatalf("ResolveOptions: %v", err)
+	}
+	if values["gpu"] != true || values["jobs"] != 8 || values["flavour"] != "minimal" {
+		t.Fatalf("values = %#v", values)
+	}
+	if got := build.VersionWithOptions(values); got != "2.0.0-full-gpu" {
+		t.Fatalf("VersionWithOptions = %q", got)
+	}
+
+	if _, err := build.ResolveOptions(map[string]string{"cuda": "true"}); err == nil ||
+		!strings.Contains(err.Error(), `unknown option "cuda" (available: flavour, gpu, jobs)`) {
+		t.Fatalf("unknown option error = %v", err)
+	}
+	if _, err := build.ResolveOptions(map[string]string{"jobs": "many"}); err == nil ||
+		!strings.Contains(err.Error(), `option "jobs": invalid value "many"`) {
+		t.Fatalf("invalid value error = %v", err)
+	}
+}
+
+func TestOptionsReachTemplatesAndStarlark(t *testing.T) {
+	build, err := loadBuildYAML(t, optionsRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	generate := func(opts map[string]string) (string, map[string]string) {
+		t.Helper()
+		def, _, err := build.GenerateWithParams(GenerateParams{Options: opts})
+		if err != nil {
+			t.Fatalf("GenerateWithParams: %v", err)
+		}
+		df, err := ir.GenerateDockerfile(def)
+		if err != nil {
+			t.Fatalf("GenerateDockerfile: %v", err)
+		}
+		return df, def.Labels()
+	}
+
+	df, labels := generate(nil)
+	if !strings.Contains(df, "2.0.0-full 2.0.0 jobs=4") || strings.Contains(df, "gpu-enabled") {
+		t.Fatalf("defaults not applied:\n%s", df)
+	}
+	if labels[OCILabelVersion] != "2.0.0-full" {
+		t.Fatalf("version label = %q", labels[OCILabelVersion])
+	}
+
+	df, labels = generate(map[string]string{"gpu": "1", "flavour": ""})
+	if !strings.Contains(df, "2.0.0-gpu 2.0.0 jobs=4") || !strings.Contains(df, "gpu-enabled") {
+		t.Fatalf("overrides not applied:\n%s", df)
+	}
+	if labels[OCILabelVersion] != "2.0.0-gpu" {
+		t.Fatalf("version label = %q", labels[OCILabelVersion])
+	}
+}
+
+func TestOptionsAreValidated(t *testing.T) {
+	for _, tc := range []struct {
+		options string
+		wantErr string
+	}{
+		{"  bad-name: {}\n", "not a valid variable name"},
+		{"  gpu:\n    version_suffix: \" gpu\"\n", "version_suffix"},
+		{"  gpu:\n    default: [1, 2]\n", "default must be"},
+	} {
+		_, err := loadBuildYAML(t, "name: bad\nversion: 1.0.0\noptions:\n"+tc.options+`build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+`)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("error = %v, want %q for:\n%s", err, tc.wantErr, tc.options)
+		}
+	}
+}
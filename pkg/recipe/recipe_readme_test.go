@@ -0,0 +1,161 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const readmeRecipe = `name: documented
+version: 3.1.0
+architectures:
+  - x86_64
+readme: |
+  # {{ context.name }} {{ context.version }}
+  Run documented --help.
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - user: neuro
+`
+
+func TestReadmeIsRenderedIntoPlan(t *testing.T) {
+	build, err := loadBuildYAML(t, readmeRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	_, plan, err := build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("GenerateWithStaging: %v", err)
+	}
+	if want := "# documented 3.1.0\nRun documented --help.\n"; plan.Readme != want {
+		t.Fatalf("Readme = %q, want %q", plan.Readme, want)
+	}
+}
+
+func TestReadmeIsWrittenIntoImageAsRoot(t *testing.T) {
+	build, err := loadBuildYAML(t, readmeRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, _, err := build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("GenerateWithStaging: %v", err)
+	}
+	var users []string
+	var readme *ir.LiteralFileDirective
+	for _, d := range def.Directives {
+		switch v := d.Directive.(type) {
+		case ir.UserDirective:
+			users = append(users, string(v))
+		case ir.LiteralFileDirective:
+			if v.Name == ReadmePath {
+				readme = &v
+				users = append(users, "<readme>")
+			}
+		}
+	}
+	if readme == nil || !strings.HasPrefix(readme.Contents, "# documented 3.1.0\n") {
+		t.Fatalf("README literal file = %+v", readme)
+	}
+	if got := strings.Join(users, " "); got != "root neuro root <readme> neuro" {
+		t.Fatalf("user sequence = %s", got)
+	}
+
+	build.Build.AddReadme = new(bool)
+	def, _, err = build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("GenerateWithStaging: %v", err)
+	}
+	for _, d := range def.Directives {
+		if f, ok := d.Directive.(ir.LiteralFileDirective); ok && f.Name == ReadmePath {
+			t.Fatalf("add-readme: false still wrote %s", ReadmePath)
+		}
+	}
+}
+
+func TestStructuredReadme(t *testing.T) {
+	build, err := loadBuildYAML(t, strings.Replace(readmeRecipe, `readme: |
+  # {{ context.name }} {{ context.version }}
+  Run documented --help.
+`, `structured_readme:
+  description: Tools for {{ context.name }}.
+  example: documented --input in.nii
+  citation: Doe et al.
+`, 1))
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	_, plan, err := build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("GenerateWithStaging: %v", err)
+	}
+	want := "# documented 3.1.0\n\nTools for documented.\n\n## Example\n\ndocumented --input in.nii\n\n## Citation\n\nDoe et al.\n"
+	if plan.Readme != want {
+		t.Fatalf("Readme = %q, want %q", plan.Readme, want)
+	}
+}
+
+const lintRecipe = `name: linted
+version: 1.0.0
+architectures:
+  - x86_64
+structured_readme:
+  description: "Linted tools."
+  example: |
+    ` + "```" + `
+    $ OMP_NUM_THREADS=2 /opt/linted/bin/linted in.nii \
+        other-arg
+    linted-extra --help
+    ` + "```" + `
+  citation: Doe et al.
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - deploy:
+        bins: [linted]
+`
+
+func TestLintReadme(t *testing.T) {
+	build, err := loadBuildYAML(t, lintRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	issues := build.LintReadme(nil)
+	if len(issues) != 1 || issues[0] != `structured_readme.example runs "linted-extra", which is not in deploy.bins` {
+		t.Fatalf("issues = %q", issues)
+	}
+
+	build.StructuredReadme.Citation = ""
+	build.StructuredReadme.Description = "#Heading\n```\nunclosed"
+	issues = build.LintReadme(nil)
+	for _, want := range []string{
+		"structured_readme.citation is missing",
+		`heading needs a space after '#': "#Heading"`,
+		"readme has an unclosed code fence",
+	} {
+		if !strings.Contains(strings.Join(issues, "\n"), want) {
+			t.Fatalf("issues %q missing %q", issues, want)
+		}
+	}
+
+	build.StructuredReadme.Description = "{% if %}"
+	issues = build.LintReadme(nil)
+	if !strings.Contains(strings.Join(issues, "\n"), "structured_readme.description does not render") {
+		t.Fatalf("issues = %q", issues)
+	}
+
+	build.StructuredReadme = StructuredReadme{}
+	if issues = build.LintReadme(nil); len(issues) != 1 || issues[0] != "recipe has no readme or structured_readme" {
+		t.Fatalf("issues = %q", issues)
+	}
+}
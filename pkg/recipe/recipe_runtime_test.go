@@ -0,0 +1,283 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+func loadBuildYAML(t *testing.T, buildYAML string) (*BuildFile, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "build.yaml"), []byte(buildYAML), 0o644); err != nil {
+		t.Fatalf("writing build.yaml: %v", err)
+	}
+	return LoadBuildFile(dir)
+}
+
+func TestBuildConfigDirectivesAreValidated(t *testing.T) {
+	for _, tc := range []struct {
+		directive string
+		wantErr   string
+	}{
+		{"expose: 70000", "invalid port"},
+		{"expose: [8080, 80/icmp]", "invalid port"},
+		{"expose: 9000-8000", "invalid port"},
+		{"volumes: [data]", "must be absolute"},
+		{"shell: 42", "shell must be a string or list"},
+		{"args: {1BAD: x}", "not a valid variable name"},
+	} {
+		_, err := loadBuildYAML(t, `name: bad
+version: 1.0.0
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - `+tc.directive+`
+`)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("%s: error = %v, want %q", tc.directive, err, tc.wantErr)
+		}
+	}
+}
+
+func TestTemplatedPortsAreCheckedAfterRendering(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: templated
+version: 1.0.0
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - variables:
+        port: "99999"
+    - expose: "{{ local.port }}"
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	if _, err := build.Generate(nil); err == nil || !strings.Contains(err.Error(), "invalid port") {
+		t.Fatalf("Generate error = %v, want invalid port", err)
+	}
+}
+
+func TestDirectiveErrorsNameDirectiveAndPosition(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: broken
+version: 1.0.0
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - run:
+        - echo ok
+    - group:
+        - environment:
+            A: "1"
+        - run:
+            - |
+              echo {{ context.version }}
+              echo {{ local.nope }}
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	_, _, err = build.GenerateWithParams(GenerateParams{})
+	want := "applying directives[1] (group): applying group[1] (run): "
+	if err == nil || !strings.Contains(err.Error(), want) || !strings.Contains(err.Error(), "line 2, column 6: {{ local.nope }}") {
+		t.Fatalf("error = %v", err)
+	}
+}
+
+func TestDirectiveValidationErrorsNameDirective(t *testing.T) {
+	_, err := loadBuildYAML(t, `name: broken
+version: 1.0.0
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - run: [echo ok]
+    - run:
+        - echo ok
+        - |
+          {% if x %}
+          echo x
+`)
+	want := `build.directives[1].run[1]: invalid jinja template: line 1, column 1: {% if x %}: expected endif`
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("error = %v, want %q", err, want)
+	}
+}
+
+func TestCacheMountsOptionMountsInstallCaches(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: cached
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  cache_mounts: true
+  directives:
+    - install: curl
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, _, err := build.GenerateWithParams(GenerateParams{Arch: CPUArchAMD64})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	var installs int
+	for _, d := range def.Directives {
+		if run, ok := d.Directive.(ir.RunWithMountsDirective); ok && strings.Contains(run.Command, "apt-get install") {
+			if !strings.Contains(strings.Join(run.Mounts, " "), "target=/var/cache/apt") {
+				t.Fatalf("install RUN without the apt cache mount: %#v", run)
+			}
+			installs++
+		}
+		if run, ok := d.Directive.(ir.RunDirective); ok && strings.Contains(string(run), "apt-get install") {
+			t.Fatalf("install RUN without cache mounts: %s", run)
+		}
+	}
+	// The default header, tzdata and the recipe's install.
+	if installs != 3 {
+		t.Fatalf("found %d install RUNs with cache mounts, want 3", installs)
+	}
+}
+
+func TestLayerMergesRunsIntoOneLayer(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: layered
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - layer:
+        - run: [cd /opt, echo one]
+        - labels: {stage: one}
+        - run: [echo two]
+    - group:
+        - run: [echo three]
+        - run: [echo four]
+      single_layer: true
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, _, err := build.GenerateWithParams(GenerateParams{Arch: CPUArchAMD64})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	n := len(def.Directives)
+	if n < 3 {
+		t.Fatalf("definition too short: %#v", def.Directives)
+	}
+	first, ok := def.Directives[n-3].Directive.(ir.RunDirective)
+	if !ok || !strings.Contains(string(first), "echo one") || !strings.Contains(string(first), "echo two") {
+		t.Fatalf("layer RUN = %#v", def.Directives[n-3].Directive)
+	}
+	if _, ok := def.Directives[n-2].Directive.(ir.LabelDirective); !ok {
+		t.Fatalf("label after the layer RUN = %#v", def.Directives[n-2].Directive)
+	}
+	last, ok := def.Directives[n-1].Directive.(ir.RunDirective)
+	if !ok || !strings.Contains(string(last), "echo three") || !strings.Contains(string(last), "echo four") {
+		t.Fatalf("single_layer RUN = %#v", def.Directives[n-1].Directive)
+	}
+}
+
+func TestLayerRejectsDirectivesBetweenRuns(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: layered
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - layer:
+        - run: [echo one]
+        - workdir: /opt
+        - run: [echo two]
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	_, _, err = build.GenerateWithParams(GenerateParams{Arch: CPUArchAMD64})
+	if err == nil || !strings.Contains(err.Error(), "single layer") {
+		t.Fatalf("GenerateWithParams error = %v, want a single layer error", err)
+	}
+}
+
+func TestMergeRunsOptionCapsCommandsPerLayer(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: merged
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  merge_runs: true
+  max_commands_per_layer: 2
+  directives:
+    - run: [echo one]
+    - run: [echo two]
+    - run: [echo three]
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, _, err := build.GenerateWithParams(GenerateParams{Arch: CPUArchAMD64})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	var runs []string
+	for _, d := range def.Directives {
+		if run, ok := d.Directive.(ir.RunDirective); ok {
+			runs = append(runs, string(run))
+		}
+	}
+	// The default ll script RUN merges with echo one; echo two and three
+	// form the second layer.
+	if len(runs) != 2 || !strings.Contains(runs[1], "echo two") || !strings.Contains(runs[1], "echo three") {
+		t.Fatalf("RUNs = %q, want 2 layers of at most 2 commands", runs)
+	}
+}
+
+func TestValidationReportsEveryError(t *testing.T) {
+	_, err := loadBuildYAML(t, `name: broken
+version: 1.0.0
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - run:
+        - "{% if x %}"
+    - group:
+        - run: ["{{ unclosed"]
+    - install: [curl, 3]
+`)
+	if err == nil {
+		t.Fatalf("expected validation errors")
+	}
+	for _, want := range []string{
+		"3 errors:",
+		"build.directives[0].run[0]: invalid jinja template",
+		"build.directives[1].group[0].run[0]: invalid jinja template",
+		"build.directives[2].install[1]: must be a string, got int",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error = %v, want %q", err, want)
+		}
+	}
+}
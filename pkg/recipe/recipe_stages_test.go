@@ -0,0 +1,96 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const stagesRecipeHeader = `name: staged
+version: 1.0.0
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+`
+
+func TestStagesAreValidated(t *testing.T) {
+	for _, tc := range []struct {
+		body    string
+		wantErr string
+	}{
+		{`  stages:
+    - name: compile
+      base-image: gcc:14
+    - name: compile
+      base-image: gcc:14
+`, "duplicate"},
+		{`  stages:
+    - name: Compile
+      base-image: gcc:14
+`, "stage name"},
+		{`  stages:
+    - name: compile
+`, "base-image"},
+		{`  directives:
+    - copy_from:
+        stage: compile
+        src: /a
+`, "copy_from.dest"},
+	} {
+		if _, err := loadBuildYAML(t, stagesRecipeHeader+tc.body); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("error = %v, want %q for:\n%s", err, tc.wantErr, tc.body)
+		}
+	}
+}
+
+func TestCopyFromRequiresEarlierStage(t *testing.T) {
+	build, err := loadBuildYAML(t, stagesRecipeHeader+`  directives:
+    - copy_from:
+        stage: compile
+        src: /out/tool
+        dest: /usr/local/bin/
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	if _, err := build.Generate(nil); err == nil || !strings.Contains(err.Error(), `stage "compile" is not defined`) {
+		t.Fatalf("Generate error = %v", err)
+	}
+}
+
+func TestStageDeploySettingsStayInStage(t *testing.T) {
+	build, err := loadBuildYAML(t, stagesRecipeHeader+`  stages:
+    - name: compile
+      base-image: gcc:14
+      directives:
+        - deploy:
+            bins: [should-not-leak]
+        - run: [make]
+  directives:
+    - copy_from:
+        stage: compile
+        src: [/out/a, /out/b]
+        dest: /opt/staged/
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, err := build.Generate(nil)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	df, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	if strings.Contains(df, "should-not-leak") {
+		t.Fatalf("deploy settings leaked out of the stage:\n%s", df)
+	}
+	if !strings.Contains(df, "FROM gcc:14 AS compile\n") || !strings.Contains(df, `COPY --from=compile "/out/a" "/out/b" "/opt/staged/"`) {
+		t.Fatalf("unexpected Dockerfile:\n%s", df)
+	}
+}
@@ -0,0 +1,149 @@
+package recipe
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/resolve"
+	"go.yaml.in/yaml/v4"
+)
+
+// References lists the files a recipe refers to by name, without generating
+// it: include files and, recursively, what they refer to; starlark files;
+// template specs and starlark templates from the template and include
+// directories; starlark custom directives; and files.filename entries.
+// Built-in templates are part of the builder and are not listed. Names
+// that are templates themselves are only known once the recipe is
+// generated, and are skipped.
+func (b *BuildFile) References(includeDirs []string) ([]string, error) {
+	w := referenceWalker{b: b, includeDirs: includeDirs, seen: map[string]bool{}}
+	for _, f := range b.Files {
+		if err := w.file(f); err != nil {
+			return nil, err
+		}
+	}
+	for _, stage := range b.Build.Stages {
+		if err := w.walk(stage.Directives); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.walk(b.Build.Directives); err != nil {
+		return nil, err
+	}
+	sort.Strings(w.out)
+	return w.out, nil
+}
+
+type referenceWalker struct {
+	b           *BuildFile
+	includeDirs []string
+	seen        map[string]bool
+	out         []string
+}
+
+func (w *referenceWalker) add(path string) bool {
+	if w.seen[path] {
+		return false
+	}
+	w.seen[path] = true
+	w.out = append(w.out, path)
+	return true
+}
+
+func isTemplated(s string) bool {
+	return strings.Contains(s, "{{") || strings.Contains(s, "{%")
+}
+
+func (w *referenceWalker) file(f FileInfo) error {
+	for _, variant := range f.Arch {
+		if err := w.file(variant); err != nil {
+			return err
+		}
+	}
+	name := string(f.Filename)
+	if name == "" || isTemplated(name) {
+		return nil
+	}
+	path, tree, err := resolve.Resolver{RecipeDir: w.b.dir, IncludeDirs: w.includeDirs, AllowAbsolute: true}.FindTree("file", name)
+	if err != nil {
+		return err
+	}
+	if tree == nil {
+		w.add(path)
+	}
+	for _, m := range tree {
+		w.add(m.Path)
+	}
+	return nil
+}
+
+func (w *referenceWalker) walk(directives []Directive) error {
+	for _, d := range directives {
+		switch {
+		case d.Group != nil:
+			if err := w.walk(*d.Group); err != nil {
+				return err
+			}
+		case d.Layer != nil:
+			if err := w.walk(*d.Layer); err != nil {
+				return err
+			}
+		case d.Arch != nil:
+			for _, arch := range d.Arch.archs() {
+				if err := w.walk((*d.Arch)[arch]); err != nil {
+					return err
+				}
+			}
+		case d.Include != nil:
+			if err := w.include(string(*d.Include)); err != nil {
+				return err
+			}
+		case d.Starlark != nil && d.Starlark.File != "":
+			path, err := resolve.Resolver{IncludeDirs: w.includeDirs}.Find("starlark file", d.Starlark.File)
+			if err != nil {
+				return err
+			}
+			w.add(path)
+		case d.Template != nil:
+			w.template(d.Template.Name)
+		case d.Custom != "":
+			if path, ok := findStarlarkTemplate(w.b.specDir(), w.includeDirs, d.Custom); ok {
+				w.add(path)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *referenceWalker) include(name string) error {
+	path, err := resolve.Resolver{IncludeDirs: w.includeDirs}.Find("include file", name)
+	if err != nil {
+		return err
+	}
+	if !w.add(path) {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var inc IncludeFile
+	if err := yaml.Unmarshal(data, &inc); err != nil {
+		return fmt.Errorf("parsing include file %s: %w", path, err)
+	}
+	return w.walk(inc.Directives)
+}
+
+func (w *referenceWalker) template(name string) {
+	if name == "" || isTemplated(name) {
+		return
+	}
+	if path, ok := findStarlarkTemplate(w.b.specDir(), w.includeDirs, name); ok {
+		w.add(path)
+	}
+	if path, ok := templateSpecPath(w.b.specDir(), name); ok {
+		w.add(path)
+	}
+}
@@ -0,0 +1,79 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReferencesFollowIncludesAndTemplates(t *testing.T) {
+	inc := t.TempDir()
+	for name, contents := range map[string]string{
+		"common.yaml":  "directives:\n  - include: nested.yaml\n  - template:\n      name: mytool\n",
+		"nested.yaml":  "directives:\n  - starlark:\n      file: helpers.star\n",
+		"helpers.star": "run(\"true\")\n",
+		"mytool.star":  "def execute(ctx, params):\n    return []\n",
+		"shared.txt":   "data\n",
+	} {
+		if err := os.WriteFile(filepath.Join(inc, name), []byte(contents), 0o644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	b, err := loadBuildYAML(t, `name: refs
+version: "1.0"
+architectures: [x86_64]
+files:
+  - name: shared.txt
+    filename: shared.txt
+  - name: templated.txt
+    filename: "{{ context.version }}.txt"
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - group:
+        - include: common.yaml
+    - arch:
+        x86_64:
+          - include: common.yaml
+    - template:
+        name: miniconda
+        version: latest
+`)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+	got, err := b.References([]string{inc})
+	if err != nil {
+		t.Fatalf("References: %v", err)
+	}
+	var want []string
+	for _, name := range []string{"common.yaml", "helpers.star", "mytool.star", "nested.yaml", "shared.txt"} {
+		want = append(want, filepath.Join(inc, name))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("References() = %v, want %v", got, want)
+	}
+}
+
+func TestReferencesReportMissingIncludes(t *testing.T) {
+	b, err := loadBuildYAML(t, `name: refs
+version: "1.0"
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - include: missing.yaml
+`)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+	if _, err := b.References([]string{t.TempDir()}); err == nil {
+		t.Fatalf("expected an error for a missing include file")
+	}
+}
@@ -0,0 +1,52 @@
+package recipe
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/neurodesk/builder/pkg/smoketest"
+)
+
+// RuntimeInfo is the recipe's `runtime:` block: the Python modules and R
+// packages the image provides. `builder test` imports or loads each in the
+// container, catching broken conda, pip or CRAN layers that scanning the
+// deployed binaries misses.
+type RuntimeInfo struct {
+	// Python is the interpreter that imports PythonModules, python3 on the
+	// PATH by default.
+	Python        string   `yaml:"python,omitempty"`
+	PythonModules []string `yaml:"python_modules,omitempty"`
+	// Rscript loads RPackages, Rscript on the PATH by default.
+	Rscript   string   `yaml:"rscript,omitempty"`
+	RPackages []string `yaml:"r_packages,omitempty"`
+}
+
+var (
+	pythonModulePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+	rPackagePattern     = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9.]*[A-Za-z0-9]$|^[A-Za-z]$`)
+)
+
+func (r *RuntimeInfo) Validate() error {
+	if r == nil {
+		return nil
+	}
+	for _, m := range r.PythonModules {
+		if !pythonModulePattern.MatchString(m) {
+			return fmt.Errorf("runtime.python_modules: %q is not a module name", m)
+		}
+	}
+	for _, p := range r.RPackages {
+		if !rPackagePattern.MatchString(p) {
+			return fmt.Errorf("runtime.r_packages: %q is not a package name", p)
+		}
+	}
+	return nil
+}
+
+// Tests returns the checks `builder test` runs for r.
+func (r *RuntimeInfo) Tests() []smoketest.Test {
+	if r == nil {
+		return nil
+	}
+	return append(smoketest.PythonImports(r.Python, r.PythonModules), smoketest.RLibraries(r.Rscript, r.RPackages)...)
+}
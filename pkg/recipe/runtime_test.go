@@ -0,0 +1,53 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuntimeInfo(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: pyr
+version: 1.0.0
+architectures: [x86_64]
+runtime:
+  python: /opt/conda/bin/python
+  python_modules: [numpy, nibabel.processing]
+  r_packages: [oro.nifti]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	tests := build.Runtime.Tests()
+	var commands []string
+	for _, tc := range tests {
+		commands = append(commands, tc.Command)
+	}
+	want := []string{
+		`'/opt/conda/bin/python' -c 'import numpy'`,
+		`'/opt/conda/bin/python' -c 'import nibabel.processing'`,
+		`'Rscript' -e 'library(oro.nifti)'`,
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Runtime.Tests commands =\n%s\nwant\n%s", strings.Join(commands, "\n"), strings.Join(want, "\n"))
+	}
+
+	for _, bad := range []string{"python_modules: [numpy; rm -rf /]", "r_packages: [\"library(x)\"]"} {
+		_, err := loadBuildYAML(t, `name: pyr
+version: 1.0.0
+architectures: [x86_64]
+runtime:
+  `+bad+`
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+`)
+		if err == nil || !strings.Contains(err.Error(), "runtime.") {
+			t.Fatalf("runtime %s: error = %v, want a runtime error", bad, err)
+		}
+	}
+}
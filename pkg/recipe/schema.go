@@ -0,0 +1,120 @@
+package recipe
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/common"
+)
+
+// schemaEnums lists the allowed values of string types that are
+// enumerations.
+var schemaEnums = map[reflect.Type][]string{
+	reflect.TypeFor[CPUArchitecture]():       {string(CPUArchAMD64), string(CPUArchARM64)},
+	reflect.TypeFor[BuildKind]():             {string(BuildKindNeuroDocker)},
+	reflect.TypeFor[common.PackageManager](): {string(common.PkgManagerApt), string(common.PkgManagerYum)},
+	reflect.TypeFor[UpstreamKind]():          {string(UpstreamGitHub), string(UpstreamPyPI), string(UpstreamConda), string(UpstreamURL)},
+}
+
+// JSONSchema returns a JSON Schema (draft 2020-12) for build.yaml, derived
+// from the yaml tags of BuildFile and the types it contains. Fields without
+// omitempty are required and unknown keys are rejected, matching how build
+// files are decoded; values the recipe validators check further, such as
+// ports or Jinja syntax, are only typed.
+func JSONSchema() map[string]any {
+	g := &schemaGenerator{defs: map[string]any{}}
+	root := g.schema(reflect.TypeFor[BuildFile]())
+	return map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "Neurodesk builder recipe (build.yaml)",
+		"$ref":    root["$ref"],
+		"$defs":   g.defs,
+	}
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	if values, ok := schemaEnums[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		values := g.schema(t.Elem())
+		if t.Elem().Kind() == reflect.Pointer {
+			// e.g. args: {NAME: } declares an argument without a default.
+			values = map[string]any{"anyOf": []any{values, map[string]any{"type": "null"}}}
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}
+	case reflect.Struct:
+		return g.structRef(t)
+	}
+	return map[string]any{}
+}
+
+// structRef returns a reference to the definition of struct t, adding it
+// to the definitions first. Named structs are referenced rather than
+// inlined so that recursive types such as Directive terminate.
+func (g *schemaGenerator) structRef(t reflect.Type) map[string]any {
+	ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+	if _, ok := g.defs[t.Name()]; ok {
+		return ref
+	}
+	def := map[string]any{"type": "object"}
+	g.defs[t.Name()] = def
+
+	properties := map[string]any{}
+	var required []string
+	additional := any(false)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			if field.Type.Kind() == reflect.Map {
+				additional = g.schema(field.Type.Elem())
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	for _, name := range forwardCompatFields[t.Name()] {
+		properties[name] = map[string]any{}
+	}
+	def["properties"] = properties
+	def["additionalProperties"] = additional
+	if len(required) > 0 {
+		def["required"] = required
+	}
+	return ref
+}
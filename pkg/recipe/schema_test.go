@@ -0,0 +1,162 @@
+package recipe
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v4"
+)
+
+func TestJSONSchema(t *testing.T) {
+	schema := JSONSchema()
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatalf("schema is not JSON-encodable: %v", err)
+	}
+	defs := schema["$defs"].(map[string]any)
+	def := func(name string) map[string]any {
+		t.Helper()
+		d, ok := defs[name].(map[string]any)
+		if !ok {
+			t.Fatalf("schema has no definition for %s", name)
+		}
+		return d
+	}
+
+	build := def("BuildFile")
+	for _, name := range []string{"name", "version", "architectures", "build"} {
+		if !slices.Contains(build["required"].([]string), name) {
+			t.Fatalf("BuildFile does not require %q: %v", name, build["required"])
+		}
+	}
+	if build["additionalProperties"] != false {
+		t.Fatalf("BuildFile allows unknown keys")
+	}
+	arch := build["properties"].(map[string]any)["architectures"].(map[string]any)["items"].(map[string]any)
+	if !slices.Equal(arch["enum"].([]string), []string{"x86_64", "aarch64"}) {
+		t.Fatalf("architectures items = %v", arch)
+	}
+
+	directive := def("Directive")["properties"].(map[string]any)
+	for _, key := range []string{"run", "group", "template", "arch", "condition", "custom"} {
+		if _, ok := directive[key]; !ok {
+			t.Fatalf("Directive has no %q property", key)
+		}
+	}
+	group := directive["group"].(map[string]any)["items"].(map[string]any)
+	if group["$ref"] != "#/$defs/Directive" {
+		t.Fatalf("group items = %v, want a reference to Directive", group)
+	}
+
+	// Template parameters are inlined next to name.
+	template := def("TemplateDirective")
+	if _, ok := template["additionalProperties"].(map[string]any); !ok {
+		t.Fatalf("TemplateDirective rejects parameters: %v", template["additionalProperties"])
+	}
+}
+
+// TestJSONSchemaAcceptsGoldenRecipes checks the golden recipes against the
+// schema's keys, required fields and types.
+func TestJSONSchemaAcceptsGoldenRecipes(t *testing.T) {
+	schema := JSONSchema()
+	defs := schema["$defs"].(map[string]any)
+	paths, _ := filepath.Glob(filepath.Join("testdata", "golden", "*", "build.yaml"))
+	if len(paths) == 0 {
+		t.Fatalf("no golden recipes found")
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("parsing %s: %v", path, err)
+		}
+		if err := checkSchema(defs, schema, doc, "$"); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+}
+
+// checkSchema is a minimal validator for the subset of JSON Schema that
+// JSONSchema produces.
+func checkSchema(defs, schema map[string]any, val any, at string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		return checkSchema(defs, defs[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any), val, at)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		var errs []error
+		for _, sub := range anyOf {
+			err := checkSchema(defs, sub.(map[string]any), val, at)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+	if enum, ok := schema["enum"].([]string); ok && !slices.Contains(enum, fmt.Sprint(val)) {
+		return fmt.Errorf("%s: %v is not one of %v", at, val, enum)
+	}
+	switch schema["type"] {
+	case "object":
+		obj, ok := val.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %T", at, val)
+		}
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required %q", at, name)
+			}
+		}
+		for key, item := range obj {
+			sub, ok := props[key].(map[string]any)
+			if !ok {
+				switch extra := schema["additionalProperties"].(type) {
+				case map[string]any:
+					sub = extra
+				default:
+					return fmt.Errorf("%s: unknown key %q", at, key)
+				}
+			}
+			if err := checkSchema(defs, sub, item, at+"."+key); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := val.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %T", at, val)
+		}
+		for i, item := range items {
+			if err := checkSchema(defs, schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := val.(string); !ok {
+			return fmt.Errorf("%s: expected a string, got %T", at, val)
+		}
+	case "null":
+		if val != nil {
+			return fmt.Errorf("%s: expected null, got %T", at, val)
+		}
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %T", at, val)
+		}
+	case "integer":
+		if _, ok := val.(int); !ok {
+			return fmt.Errorf("%s: expected an integer, got %T", at, val)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package recipe
+
+import (
+	"fmt"
+
+	"github.com/neurodesk/builder/pkg/smoketest"
+)
+
+// addSmokeTests writes the non-manual command tests to
+// smoketest.ManifestPath, where the deployment tester finds them. Recipes
+// without command tests get no manifest.
+func addSmokeTests(ctx *Context, tests []RecipeTest) error {
+	var manifest []smoketest.Test
+	for _, t := range tests {
+		if t.Command == "" || t.Manual {
+			continue
+		}
+		manifest = append(manifest, smoketest.Test{Name: t.Name, Command: t.Command, Expect: t.Expect, ExitCode: t.ExitCode})
+	}
+	if len(manifest) == 0 {
+		return nil
+	}
+	text, err := smoketest.Encode(manifest)
+	if err != nil {
+		return fmt.Errorf("encoding smoke tests: %w", err)
+	}
+	addRootFile(ctx, "<tests>", smoketest.ManifestPath, text)
+	return nil
+}
@@ -0,0 +1,94 @@
+package recipe
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/smoketest"
+)
+
+func TestCommandTestsAreBakedIntoImage(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: smoked
+version: 2.1.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - test:
+        name: version
+        command: smoked --version
+        expect: "{{ context.version }}"
+    - test:
+        name: usage
+        command: smoked-{{ context.version }}
+        exit_code: 1
+    - test:
+        name: gui
+        manual: true
+        command: smoked --gui
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, plan, err := build.GenerateWithParams(GenerateParams{Arch: CPUArchAMD64})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	if len(plan.Tests) != 3 || plan.Tests[1].Command != "smoked-2.1.0" || plan.Tests[1].ExitCode != 1 {
+		t.Fatalf("plan.Tests = %+v", plan.Tests)
+	}
+
+	var manifest *ir.LiteralFileDirective
+	for _, d := range def.Directives {
+		if f, ok := d.Directive.(ir.LiteralFileDirective); ok && f.Name == smoketest.ManifestPath {
+			manifest = &f
+		}
+	}
+	if manifest == nil {
+		t.Fatalf("no %s in the image", smoketest.ManifestPath)
+	}
+	var m smoketest.Manifest
+	if err := json.Unmarshal([]byte(manifest.Contents), &m); err != nil {
+		t.Fatalf("parsing manifest: %v", err)
+	}
+	// Manual tests are left out.
+	want := []smoketest.Test{
+		{Name: "version", Command: "smoked --version", Expect: "2.1.0"},
+		{Name: "usage", Command: "smoked-2.1.0", ExitCode: 1},
+	}
+	if len(m.Tests) != len(want) || m.Tests[0] != want[0] || m.Tests[1] != want[1] {
+		t.Fatalf("manifest tests = %+v, want %+v", m.Tests, want)
+	}
+}
+
+func TestCommandTestValidation(t *testing.T) {
+	for _, tc := range []struct {
+		test string
+		err  string
+	}{
+		{"{name: t, command: x, script: y}", "only one of"},
+		{"{name: t, script: y, expect: z}", "require test.command"},
+		{"{name: t, command: x, expect: '('}", "test expect"},
+	} {
+		build, err := loadBuildYAML(t, `name: smoked
+version: 2.1.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - test: `+tc.test+`
+`)
+		if err == nil {
+			_, _, err = build.GenerateWithParams(GenerateParams{Arch: CPUArchAMD64})
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("test %s: error = %v, want %q", tc.test, err, tc.err)
+		}
+	}
+}
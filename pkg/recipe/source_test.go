@@ -0,0 +1,70 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const sourcesRecipe = `name: sources
+version: 1.0.0
+architectures:
+  - x86_64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - run:
+        - echo top
+    - group:
+        - run:
+            - echo grouped
+    - arch:
+        x86_64:
+          - run:
+              - echo arch
+    - include: common.yaml
+    - source: custom-id
+      run:
+        - echo custom
+`
+
+func TestDirectiveSourcesNameRecipePositions(t *testing.T) {
+	build, err := loadBuildYAML(t, sourcesRecipe)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+	includeDir := t.TempDir()
+	common := "builder: neurodocker\ndirectives:\n  - run:\n      - echo included\n"
+	if err := os.WriteFile(filepath.Join(includeDir, "common.yaml"), []byte(common), 0o644); err != nil {
+		t.Fatalf("writing include: %v", err)
+	}
+
+	def, _, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{includeDir}, Arch: CPUArchAMD64})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+
+	sources := map[string]ir.SourceID{}
+	for _, d := range def.Directives {
+		if run, ok := d.Directive.(ir.RunDirective); ok {
+			sources[string(run)] = d.Source
+		}
+	}
+	for cmd, want := range map[string]ir.SourceID{
+		"echo top":      "directives[0]",
+		"echo grouped":  "directives[1] > group[0]",
+		"echo arch":     "directives[2] > arch.x86_64[0]",
+		"echo included": "directives[3] > include common.yaml[0]",
+		"echo custom":   "custom-id",
+	} {
+		if got := sources[cmd]; got != want {
+			t.Fatalf("source of %q = %q, want %q (all: %v)", cmd, got, want, sources)
+		}
+	}
+}
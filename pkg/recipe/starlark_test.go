@@ -1,6 +1,9 @@
 package recipe
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/neurodesk/builder/pkg/common"
@@ -400,3 +403,178 @@ run_command("echo two")
 		t.Fatalf("expected at least 2 RUN directives, got %d", runCount)
 	}
 }
+
+const starlarkDirectivesRecipe = `name: scripted
+version: 1.0.0
+architectures:
+  - x86_64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - starlark:
+        script: |
+          workdir("/opt/tool")
+          env(TOOL_HOME="/opt/tool", MODE=context.version)
+          run("echo building {{ context.version }}", "make install")
+          file("notes.txt", contents="hello")
+          copy("notes.txt", "/opt/tool/notes.txt")
+          user("neuro")
+          deploy(bins=["tool"], path=["/opt/tool/bin"])
+          test("version", script="tool --version")
+          add_directive("labels", {"org.example.scripted": "yes"})
+`
+
+func TestStarlarkDirectiveAPIBuildsDirectives(t *testing.T) {
+	build, err := loadBuildYAML(t, starlarkDirectivesRecipe)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, plan, err := build.GenerateWithParams(GenerateParams{})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+
+	var last int
+	for _, want := range []string{
+		"WORKDIR /opt/tool",
+		"TOOL_HOME=",
+		"echo building 1.0.0",
+		"make install",
+		"/opt/tool/notes.txt",
+		"USER neuro",
+		"DEPLOY_BINS=",
+	} {
+		i := strings.Index(dockerfile[last:], want)
+		if i < 0 {
+			t.Fatalf("Dockerfile is missing %q after offset %d:\n%s", want, last, dockerfile)
+		}
+		last += i
+	}
+	if got := def.Labels()["org.example.scripted"]; got != "yes" {
+		t.Fatalf("label = %q, want yes", got)
+	}
+	if len(plan.Tests) != 1 || plan.Tests[0].Name != "version" {
+		t.Fatalf("tests = %#v, want the version test", plan.Tests)
+	}
+}
+
+func TestStarlarkDirectiveAPIErrors(t *testing.T) {
+	for _, tc := range []struct {
+		script  string
+		wantErr string
+	}{
+		{`workdir()`, "workdir: got 0 arguments, want 1"},
+		{`run()`, "run requires one or more commands"},
+		{`copy("only-one")`, "copy requires one or more sources and a destination"},
+		{`file(url="https://example.org/x")`, "file requires a name"},
+		{`template("no-such-template")`, "no-such-template"},
+		{`add_directive("bogus", "x")`, "bogus"},
+		{`env(1, 2)`, "env takes a dict or keyword arguments"},
+	} {
+		ctx := newContext(common.PkgManagerApt, "1.0.0", nil, ir.New(), nil)
+		err := StarlarkDirective{Script: jinja2.TemplateString(tc.script)}.Apply(ctx, "")
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("%s: error = %v, want %q", tc.script, err, tc.wantErr)
+		}
+	}
+}
+
+func TestStarlarkLoadFromIncludeDirs(t *testing.T) {
+	dir := t.TempDir()
+	lib := "def install_tool(v):\n    run(\"curl -fsSL https://example.org/tool-\" + v + \".tar.gz | tar xz\")\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.star"), []byte(lib), 0o644); err != nil {
+		t.Fatalf("writing lib.star: %v", err)
+	}
+	ctx := newContext(common.PkgManagerApt, "1.0.0", []string{dir}, ir.New(), nil)
+
+	directive := StarlarkDirective{Script: jinja2.TemplateString(`
+load("lib.star", "install_tool")
+install_tool(context.version)
+`)}
+	if err := directive.Apply(ctx, ""); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	def, err := ctx.Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	if !strings.Contains(dockerfile, "tool-1.0.0.tar.gz") {
+		t.Fatalf("Dockerfile is missing the loaded helper's command:\n%s", dockerfile)
+	}
+
+	err = StarlarkDirective{Script: `load("nope.star", "x")`}.Apply(ctx, "")
+	if err == nil || !strings.Contains(err.Error(), `starlark module "nope.star" not found`) {
+		t.Fatalf("missing module error = %v", err)
+	}
+}
+
+func TestLocalsReachTemplatesAndStarlark(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: locals
+version: 1.0.0
+architectures:
+  - x86_64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - run:
+        - "{% if has_local('data') %}cp -r {{ get_local('data') }} /opt/data{% else %}echo no data{% endif %}"
+    - starlark:
+        script: |
+          def licenses():
+              if has_local("licenses"):
+                  run("cp " + get_local("licenses") + "/license.txt /opt")
+              else:
+                  run("echo no licenses")
+          licenses()
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	generate := func(locals ...string) string {
+		t.Helper()
+		def, _, err := build.GenerateWithParams(GenerateParams{Locals: locals})
+		if err != nil {
+			t.Fatalf("GenerateWithParams: %v", err)
+		}
+		dockerfile, err := ir.GenerateDockerfile(def)
+		if err != nil {
+			t.Fatalf("GenerateDockerfile: %v", err)
+		}
+		return dockerfile
+	}
+
+	without := generate()
+	for _, want := range []string{"echo no data", "echo no licenses"} {
+		if !strings.Contains(without, want) {
+			t.Fatalf("without locals, Dockerfile is missing %q:\n%s", want, without)
+		}
+	}
+
+	with := generate("data", "licenses")
+	for _, want := range []string{
+		"from=data,source=/,target=/.neurocontainer-local/data",
+		"cp -r /.neurocontainer-local/data /opt/data",
+		"from=licenses,source=/,target=/.neurocontainer-local/licenses",
+		"cp /.neurocontainer-local/licenses/license.txt /opt",
+	} {
+		if !strings.Contains(with, want) {
+			t.Fatalf("with locals, Dockerfile is missing %q:\n%s", want, with)
+		}
+	}
+}
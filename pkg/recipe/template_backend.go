@@ -215,7 +215,7 @@ func (t *macroTemplateSelf) String() string { return "<self>" }
 func (t *macroTemplateSelf) Truth() bool { return true }
 
 func applyTemplateMacro(ctx *Context, src ir.SourceID, name string, params templateParams) error {
-	templateSpec, err := getTemplateSpec(name)
+	templateSpec, err := getTemplateSpec(ctx.specDir(), name)
 	if err != nil {
 		return fmt.Errorf("loading template metadata for %q: %w", name, err)
 	}
@@ -234,6 +234,13 @@ func applyTemplateMacro(ctx *Context, src ir.SourceID, name string, params templ
 	if err != nil {
 		return err
 	}
+	missing, err := methodTemplate.Arguments.missing(params)
+	if err != nil {
+		return err
+	}
+	if err := methodTemplate.Arguments.argumentError(name, method, missing, nil); err != nil {
+		return err
+	}
 
 	child := ctx.childContext()
 	lookupKey := "self"
@@ -249,9 +256,20 @@ func applyTemplateMacro(ctx *Context, src ir.SourceID, name string, params templ
 		template: methodTemplate,
 	}
 
-	for _, directive := range macro.Directives {
+	ctx.noteTemplate(src, templateSpec)
+	ctx.useTemplate(templateSpec)
+	child.provenance.Template = name
+	// The template's env block comes first, so the install steps see it.
+	if len(methodTemplate.Env) > 0 {
+		env := EnvironmentDirective(methodTemplate.Env)
+		directive := Directive{Source: nestedSource(src, "template %s env", name), Environment: &env}
+		if err := directive.Apply(child); err != nil {
+			return fmt.Errorf("applying env of template %q: %w", name, err)
+		}
+	}
+	for i, directive := range macro.Directives {
 		if directive.Source == "" {
-			directive.Source = src
+			directive.Source = nestedSource(src, "template %s[%d]", name, i)
 		}
 		if err := directive.Apply(child); err != nil {
 			return fmt.Errorf("applying macro template %q: %w", name, err)
@@ -259,21 +277,25 @@ func applyTemplateMacro(ctx *Context, src ir.SourceID, name string, params templ
 	}
 
 	delete(child.variables, lookupKey)
+	ctx.adoptTemplateChild(child)
+	return nil
+}
 
-	ctx.builder = child.builder
+// adoptTemplateChild takes over the build and any new variables, files and
+// run commands from a template's child context.
+func (c *Context) adoptTemplateChild(child *Context) {
+	c.builder = child.builder
 	for k, v := range child.variables {
-		if _, exists := ctx.variables[k]; !exists {
-			ctx.variables[k] = v
+		if _, exists := c.variables[k]; !exists {
+			c.variables[k] = v
 		}
 	}
 	for name, f := range child.files {
-		if _, exists := ctx.files[name]; !exists {
-			ctx.files[name] = f
+		if _, exists := c.files[name]; !exists {
+			c.files[name] = f
 		}
 	}
 	if len(child.runCommands) > 0 {
-		ctx.runCommands = append(ctx.runCommands, child.runCommands...)
+		c.runCommands = append(c.runCommands, child.runCommands...)
 	}
-
-	return nil
 }
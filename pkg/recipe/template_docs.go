@@ -0,0 +1,106 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TemplateDoc describes a YAML template for documentation and editor
+// tooling.
+type TemplateDoc struct {
+	Name    string
+	URL     string
+	Alert   string
+	Version string
+	// Deprecation is the deprecation notice; empty unless deprecated.
+	Deprecation string
+	Methods     []TemplateMethodDoc
+}
+
+// TemplateMethodDoc describes one install method of a template.
+type TemplateMethodDoc struct {
+	Method   string
+	Required []string
+	// Optional maps each optional argument to its default, unrendered.
+	Optional map[string]string
+	// Versions are the versions the method has download URLs for.
+	Versions []string
+}
+
+// Params returns the parameter names the method accepts, sorted, including
+// method itself.
+func (m TemplateMethodDoc) Params() []string {
+	params := append([]string{"method"}, m.Required...)
+	for k := range m.Optional {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	return params
+}
+
+// TemplateNames returns the names of the built-in templates and those in
+// the template spec directory, sorted.
+func TemplateNames() []string {
+	seen := map[string]bool{}
+	for name := range embeddedTemplateSpecs {
+		seen[name] = true
+	}
+	if templateSpecDir != "" {
+		entries, _ := os.ReadDir(templateSpecDir)
+		for _, entry := range entries {
+			if name, ok := strings.CutSuffix(entry.Name(), ".yaml"); ok && !entry.IsDir() {
+				seen[name] = true
+			}
+		}
+	}
+	var names []string
+	for name := range seen {
+		if !strings.HasPrefix(name, "_") && name != "test_all" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DescribeTemplate returns the documentation of the named template.
+func DescribeTemplate(name string) (TemplateDoc, error) {
+	spec, err := getTemplateSpec(templateSpecDir, name)
+	if err != nil {
+		return TemplateDoc{}, err
+	}
+	doc := TemplateDoc{Name: name, URL: spec.URL, Alert: spec.Alert, Version: spec.Version, Deprecation: spec.deprecation()}
+	for _, method := range []string{"binaries", "source"} {
+		m, err := spec.GetMethodTemplate(method)
+		if err != nil {
+			continue
+		}
+		md := TemplateMethodDoc{
+			Method:   method,
+			Required: append([]string(nil), m.Arguments.Required...),
+			Optional: map[string]string{},
+		}
+		for k, val := range m.Arguments.Optional {
+			md.Optional[k] = string(val)
+		}
+		for version := range m.Urls {
+			md.Versions = append(md.Versions, version)
+		}
+		sort.Strings(md.Versions)
+		doc.Methods = append(doc.Methods, md)
+	}
+	return doc, nil
+}
+
+// ParseBuildFile decodes and validates a build file held in memory, as
+// LoadBuildFile does for one on disk. dir is the directory it belongs to.
+func ParseBuildFile(data []byte, dir string) (*BuildFile, error) {
+	build, err := decodeBuildFile(filepath.Clean(dir), strings.NewReader(string(data)), LoadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	build.annotateProvenance(data)
+	return build, nil
+}
@@ -0,0 +1,75 @@
+package recipe
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+// TemplateNotice is a message from a template a recipe uses: its alert, or
+// that it is deprecated.
+type TemplateNotice struct {
+	Template string
+	// Source is the directive that used the template.
+	Source  ir.SourceID
+	Message string
+	// Deprecated is set for deprecation notices, which `builder lint
+	// --strict` treats as errors.
+	Deprecated bool
+}
+
+func (n TemplateNotice) String() string {
+	return fmt.Sprintf("%s: %s", n.Source, n.Message)
+}
+
+// deprecation describes the deprecation of the template, or returns "" if
+// it is not deprecated.
+func (t templateSpec) deprecation() string {
+	if t.Deprecated == "" && t.SupersededBy == "" {
+		return ""
+	}
+	msg := fmt.Sprintf("template %q", t.Name)
+	if t.Version != "" {
+		msg += " (version " + t.Version + ")"
+	}
+	msg += " is deprecated"
+	if t.Deprecated != "" && t.Deprecated != "true" {
+		msg += ": " + t.Deprecated
+	}
+	if t.SupersededBy != "" {
+		msg += fmt.Sprintf("; use %q instead", t.SupersededBy)
+	}
+	return msg
+}
+
+// noteTemplate logs the alert and deprecation of the template used by the
+// directive src and records them for StagingPlan.TemplateNotices. Each
+// notice is reported once per generation.
+func (c *Context) noteTemplate(src ir.SourceID, spec templateSpec) {
+	var notices []TemplateNotice
+	if spec.Alert != "" {
+		notices = append(notices, TemplateNotice{Template: spec.Name, Source: src, Message: spec.Alert})
+	}
+	if msg := spec.deprecation(); msg != "" {
+		notices = append(notices, TemplateNotice{Template: spec.Name, Source: src, Message: msg, Deprecated: true})
+	}
+
+	r := c.root()
+	for _, n := range notices {
+		key := n.Template + "\x00" + n.Message
+		if _, seen := r.noticed[key]; seen {
+			continue
+		}
+		if r.noticed == nil {
+			r.noticed = map[string]struct{}{}
+		}
+		r.noticed[key] = struct{}{}
+		r.notices = append(r.notices, n)
+		if n.Deprecated {
+			slog.Warn(n.Message, "directive", n.Source)
+		} else {
+			slog.Info("template notice", "template", n.Template, "directive", n.Source, "alert", n.Message)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTemplateNotices(t *testing.T) {
+	spec, err := templateSpecFiles.ReadFile("template_specs/fsl.yaml")
+	if err != nil {
+		t.Fatalf("reading fsl spec: %v", err)
+	}
+	dir := t.TempDir()
+	deprecated := strings.Replace(string(spec), "name: fsl\n", "name: fsl\nversion: \"1\"\ndeprecated: true\nsuperseded_by: fsl_conda\n", 1)
+	if err := os.WriteFile(filepath.Join(dir, "fsl.yaml"), []byte(deprecated), 0o644); err != nil {
+		t.Fatalf("writing spec: %v", err)
+	}
+	SetTemplateSpecDir(dir)
+	defer SetTemplateSpecDir("")
+
+	build, err := loadBuildYAML(t, `name: fsltest
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - template:
+        name: fsl
+        version: 6.0.7.19
+    - template:
+        name: fsl
+        version: 6.0.7.18
+        install_path: /opt/fsl-old
+`)
+	if err != nil {
+		t.Fatalf("loading recipe: %v", err)
+	}
+	_, plan, err := build.GenerateWithStaging(nil)
+	if err != nil {
+		t.Fatalf("GenerateWithStaging: %v", err)
+	}
+	if len(plan.TemplateNotices) != 2 {
+		t.Fatalf("expected the alert and the deprecation once each, got %+v", plan.TemplateNotices)
+	}
+	alert, dep := plan.TemplateNotices[0], plan.TemplateNotices[1]
+	if alert.Deprecated || !strings.Contains(alert.Message, "FSL is non-free") || alert.Source != "directives[0]" {
+		t.Fatalf("unexpected alert %+v", alert)
+	}
+	if !dep.Deprecated || dep.Message != `template "fsl" (version 1) is deprecated; use "fsl_conda" instead` {
+		t.Fatalf("unexpected deprecation %+v", dep)
+	}
+
+	// The template is recorded once, with its version and the digest of
+	// the spec file it came from, for build.lock.
+	var fsl []TemplateUse
+	for _, u := range plan.Templates {
+		if u.Name == "fsl" {
+			fsl = append(fsl, u)
+		}
+	}
+	if want := contentDigest([]byte(deprecated)); len(fsl) != 1 || fsl[0].Version != "1" || fsl[0].Digest != want {
+		t.Fatalf("plan.Templates = %+v, want fsl once at version 1 with digest %s", plan.Templates, want)
+	}
+}
+
+func TestLoadOptionsTemplateDir(t *testing.T) {
+	spec, err := templateSpecFiles.ReadFile("template_specs/jq.yaml")
+	if err != nil {
+		t.Fatalf("reading jq spec: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "jq.yaml"), spec, 0o644); err != nil {
+		t.Fatalf("writing spec: %v", err)
+	}
+	recipeDir := t.TempDir()
+	text := `name: site
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - template:
+        name: jq
+        version: "1.6"
+`
+	if err := os.WriteFile(filepath.Join(recipeDir, "build.yaml"), []byte(text), 0o644); err != nil {
+		t.Fatalf("writing build.yaml: %v", err)
+	}
+	specPath := filepath.Join(dir, "jq.yaml")
+	for _, tc := range []struct {
+		opts LoadOptions
+		want bool
+	}{
+		{LoadOptions{}, false},
+		{LoadOptions{TemplateDir: dir}, true},
+	} {
+		build, err := LoadBuildFileWithOptions(recipeDir, tc.opts)
+		if err != nil {
+			t.Fatalf("loading with %+v: %v", tc.opts, err)
+		}
+		_, plan, err := build.GenerateWithStaging(nil)
+		if err != nil {
+			t.Fatalf("GenerateWithStaging with %+v: %v", tc.opts, err)
+		}
+		if got := slices.Contains(plan.Inputs, specPath); got != tc.want {
+			t.Fatalf("with %+v, template spec among the inputs = %v, want %v: %q", tc.opts, got, tc.want, plan.Inputs)
+		}
+	}
+}
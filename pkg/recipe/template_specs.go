@@ -1,11 +1,15 @@
 package recipe
 
 import (
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/neurodesk/builder/pkg/common"
@@ -211,30 +215,32 @@ type templateDepends struct {
 
 func (d *templateDepends) Validate() error {
 	return v.All(
-		v.Map(d.Apt, func(item string, key string) error {
+		v.Map(d.Apt, func(item string, _ string) error {
 			return v.All(
-				v.NotEmpty(item, fmt.Sprintf("apt dependency %q", key)),
-				v.HasNoJinja(item, fmt.Sprintf("apt dependency %q", key)),
+				v.NotEmpty(item, "apt dependency"),
+				v.HasNoJinja(item, "apt dependency"),
 			)
-		}, "apt dependencies"),
-		v.Map(d.Yum, func(item string, key string) error {
+		}, "dependencies.apt"),
+		v.Map(d.Yum, func(item string, _ string) error {
 			return v.All(
-				v.NotEmpty(item, fmt.Sprintf("yum dependency %q", key)),
-				v.HasNoJinja(item, fmt.Sprintf("yum dependency %q", key)),
+				v.NotEmpty(item, "yum dependency"),
+				v.HasNoJinja(item, "yum dependency"),
 			)
-		}, "yum dependencies"),
-		v.Map(d.Debs, func(item string, key string) error {
+		}, "dependencies.yum"),
+		v.Map(d.Debs, func(item string, _ string) error {
 			return v.All(
-				v.NotEmpty(item, fmt.Sprintf("debs dependency %q", key)),
-				v.HasNoJinja(item, fmt.Sprintf("debs dependency %q", key)),
+				v.NotEmpty(item, "debs dependency"),
+				v.HasNoJinja(item, "debs dependency"),
 			)
-		}, "debs dependencies"),
+		}, "dependencies.debs"),
 	)
 }
 
 type templateArguments struct {
 	Optional map[string]jinja2.TemplateString `yaml:"optional,omitempty"`
 	Required []string                         `yaml:"required,omitempty"`
+	// Types declares the types of some arguments; see coerceArgument.
+	Types map[string]templateArgumentType `yaml:"types,omitempty"`
 }
 
 func (t *templateArguments) Validate() error {
@@ -247,9 +253,126 @@ func (t *templateArguments) Validate() error {
 			)
 		}, "optional arguments"),
 		v.NoDuplicates(t.Required, "required arguments"),
+		v.MapDict(t.Types, func(key string, typ templateArgumentType) error {
+			_, optional := t.Optional[key]
+			return typ.validate(key, optional || slices.Contains(t.Required, key))
+		}, "argument types"),
 	)
 }
 
+// missing returns the required arguments params does not set.
+func (t *templateArguments) missing(params templateParams) ([]string, error) {
+	var out []string
+	for _, key := range t.Required {
+		if _, ok, err := params(key); err != nil {
+			return nil, fmt.Errorf("getting parameter %q: %w", key, err)
+		} else if !ok {
+			out = append(out, key)
+		}
+	}
+	return out, nil
+}
+
+// unknown returns the keys of params the template does not declare.
+// "method" is accepted by every template.
+func (t *templateArguments) unknown(params map[string]any) []string {
+	var out []string
+	for key := range params {
+		if key == "method" || slices.Contains(t.Required, key) {
+			continue
+		}
+		if _, ok := t.Optional[key]; ok {
+			continue
+		}
+		out = append(out, key)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// describe lists the arguments for error messages, e.g. "required: version;
+// optional: install_path (default "/opt/fsl-{{ self.version }}")".
+func (t *templateArguments) describe() string {
+	var parts []string
+	if len(t.Required) > 0 {
+		required := make([]string, len(t.Required))
+		for i, key := range t.Required {
+			required[i] = key
+			if typ, ok := t.Types[key]; ok {
+				required[i] += " (" + typ.Type + ")"
+			}
+		}
+		parts = append(parts, "required: "+strings.Join(required, ", "))
+	}
+	if len(t.Optional) > 0 {
+		keys := make([]string, 0, len(t.Optional))
+		for key := range t.Optional {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for i, key := range keys {
+			if typ, ok := t.Types[key]; ok {
+				keys[i] = fmt.Sprintf("%s (%s, default %q)", key, typ.Type, string(t.Optional[key]))
+			} else {
+				keys[i] = fmt.Sprintf("%s (default %q)", key, string(t.Optional[key]))
+			}
+		}
+		parts = append(parts, "optional: "+strings.Join(keys, ", "))
+	}
+	if len(parts) == 0 {
+		return "the template takes no parameters besides method"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// argumentError describes missing and unknown parameters of template name
+// with method, or returns nil when there are none.
+func (t *templateArguments) argumentError(name, method string, missing, unknown []string) error {
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "missing required parameter(s) "+strings.Join(missing, ", "))
+	}
+	if len(unknown) > 0 {
+		problems = append(problems, "unknown parameter(s) "+strings.Join(unknown, ", "))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("template %q (method %s): %s (%s)", name, method, strings.Join(problems, " and "), t.describe())
+}
+
+// checkParams validates the parameters a recipe passes to template name
+// with method: every required one is set and none is undeclared.
+func (t *recipeTemplateSpec) checkParams(name, method string, params map[string]any) error {
+	missing, err := t.Arguments.missing(func(k string) (any, bool, error) {
+		val, ok := params[k]
+		return val, ok, nil
+	})
+	if err != nil {
+		return err
+	}
+	return t.Arguments.argumentError(name, method, missing, t.Arguments.unknown(params))
+}
+
+// lookupMethodTemplate returns the spec of the YAML template name for the
+// method in params, or nil when either is unknown; applying the template
+// reports that.
+func lookupMethodTemplate(dir, name string, params map[string]any) (*recipeTemplateSpec, string) {
+	spec, err := getTemplateSpec(dir, name)
+	if err != nil {
+		return nil, ""
+	}
+	method := "binaries"
+	if m, ok := params["method"].(string); ok {
+		method = m
+	}
+	methodTemplate, err := spec.GetMethodTemplate(method)
+	if err != nil {
+		return nil, ""
+	}
+	return methodTemplate, method
+}
+
 type recipeTemplateSpec struct {
 	Arguments    templateArguments                `yaml:"arguments,omitempty"`
 	Dependencies templateDepends                  `yaml:"dependencies,omitempty"`
@@ -284,6 +407,18 @@ func (t *recipeTemplateSpec) Validate() error {
 }
 
 func (t *recipeTemplateSpec) Execute(name string, context templateContext, params templateParams) (*templateResult, error) {
+	missing, err := t.Arguments.missing(params)
+	if err != nil {
+		return nil, err
+	}
+	method, err := params.GetString("method", "binaries")
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Arguments.argumentError(name, method, missing, nil); err != nil {
+		return nil, err
+	}
+
 	key := "self"
 
 	if name == "_header" {
@@ -298,7 +433,7 @@ func (t *recipeTemplateSpec) Execute(name string, context templateContext, param
 		},
 	}
 
-	result, err := t.Instructions.Render(ctx)
+	result, err := t.Instructions.RenderNamed(name, ctx)
 	if err != nil {
 		return nil, fmt.Errorf("rendering instructions: %w", err)
 	}
@@ -308,8 +443,13 @@ func (t *recipeTemplateSpec) Execute(name string, context templateContext, param
 		Environment:  map[string]string{},
 	}
 
-	for key, value := range t.Env {
-		val, err := value.Render(ctx)
+	keys := make([]string, 0, len(t.Env))
+	for key := range t.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		val, err := t.Env[key].RenderNamed(name, ctx)
 		if err != nil {
 			return nil, fmt.Errorf("rendering env %q: %w", key, err)
 		}
@@ -324,9 +464,42 @@ type templateSpec struct {
 	URL  string `yaml:"url"`
 
 	Alert string `yaml:"alert,omitempty"`
+	// Version is the version of the template itself, for deprecation
+	// notices and documentation.
+	Version string `yaml:"version,omitempty"`
+	// Deprecated marks the template as deprecated; the value is the reason
+	// (or just "true"). SupersededBy names its replacement and implies it.
+	Deprecated   string `yaml:"deprecated,omitempty"`
+	SupersededBy string `yaml:"superseded_by,omitempty"`
 
 	Source   *recipeTemplateSpec `yaml:"source,omitempty"`
 	Binaries *recipeTemplateSpec `yaml:"binaries,omitempty"`
+
+	// digest is the sha256 digest of the spec file, for build.lock.
+	digest string
+}
+
+// TemplateUse is a template a recipe uses, as recorded in build.lock.
+type TemplateUse struct {
+	Name string
+	// Version is the version of the template itself, if it declares one.
+	Version string
+	// Digest is the sha256 digest of the template's spec file.
+	Digest string
+}
+
+func contentDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// useTemplate records spec for StagingPlan.Templates, once per generation.
+func (c *Context) useTemplate(spec templateSpec) {
+	r := c.root()
+	if slices.ContainsFunc(r.templates, func(t TemplateUse) bool { return t.Name == spec.Name }) {
+		return
+	}
+	r.templates = append(r.templates, TemplateUse{Name: spec.Name, Version: spec.Version, Digest: spec.digest})
 }
 
 func (t templateSpec) GetMethodTemplate(method string) (*recipeTemplateSpec, error) {
@@ -393,6 +566,7 @@ func loadTemplateSpecFromDir(name, dir string) (templateSpec, error) {
 	if err := tpl.Validate(); err != nil {
 		return templateSpec{}, fmt.Errorf("invalid template %q: %w", name, err)
 	}
+	tpl.digest = contentDigest(content)
 
 	return tpl, nil
 }
@@ -401,9 +575,24 @@ func SetTemplateSpecDir(dir string) {
 	templateSpecDir = dir
 }
 
-func getTemplateSpec(name string) (templateSpec, error) {
-	if templateSpecDir != "" {
-		if tpl, err := loadTemplateSpecFromDir(name, templateSpecDir); err == nil {
+// templateSpecPath returns the file in the template directory dir that
+// defines template name, if there is one.
+func templateSpecPath(dir, name string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	path := filepath.Join(dir, name+".yaml")
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// getTemplateSpec returns the spec of template name from the template
+// directory dir, or else the embedded templates.
+func getTemplateSpec(dir, name string) (templateSpec, error) {
+	if dir != "" {
+		if tpl, err := loadTemplateSpecFromDir(name, dir); err == nil {
 			return tpl, nil
 		}
 	}
@@ -444,6 +633,7 @@ func init() {
 		if err := tpl.Validate(); err != nil {
 			panic(fmt.Errorf("invalid template %q: %w", name, err))
 		}
+		tpl.digest = contentDigest(content)
 		embeddedTemplateSpecs[strings.TrimSuffix(name, ".yaml")] = tpl
 	}
 }
@@ -5,10 +5,11 @@ import (
 	"testing"
 
 	"github.com/neurodesk/builder/pkg/common"
+	"github.com/neurodesk/builder/pkg/ir"
 )
 
 func TestTemplateSpecContextArchExposedToUrls(t *testing.T) {
-	templateSpec, err := getTemplateSpec("miniconda")
+	templateSpec, err := getTemplateSpec("", "miniconda")
 	if err != nil {
 		t.Fatalf("Failed to get miniconda template spec: %v", err)
 	}
@@ -41,7 +42,7 @@ func TestTemplateSpecContextArchExposedToUrls(t *testing.T) {
 }
 
 func TestTemplateSpecOptionalArgumentCanOverrideInstallerVersion(t *testing.T) {
-	templateSpec, err := getTemplateSpec("miniconda")
+	templateSpec, err := getTemplateSpec("", "miniconda")
 	if err != nil {
 		t.Fatalf("Failed to get miniconda template spec: %v", err)
 	}
@@ -70,3 +71,118 @@ func TestTemplateSpecOptionalArgumentCanOverrideInstallerVersion(t *testing.T) {
 		t.Fatalf("Expected rendered instructions to contain %q, got:\n%s", want, result.Instructions)
 	}
 }
+
+func TestTemplateSpecExecuteRendersEnv(t *testing.T) {
+	templateSpec, err := getTemplateSpec("", "fsl")
+	if err != nil {
+		t.Fatalf("Failed to get fsl template spec: %v", err)
+	}
+	result, err := templateSpec.Execute(templateContext{
+		PackageManager: common.PkgManagerApt,
+		Arch:           "x86_64",
+	}, func(k string) (any, bool, error) {
+		if k == "version" {
+			return "6.0.7.19", true, nil
+		}
+		return nil, false, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute fsl template spec: %v", err)
+	}
+	if got := result.Environment["FSLDIR"]; got != "/opt/fsl-6.0.7.19" {
+		t.Fatalf("FSLDIR = %q", got)
+	}
+	if got := result.Environment["PATH"]; got != "/opt/fsl-6.0.7.19/bin:$PATH" {
+		t.Fatalf("PATH = %q", got)
+	}
+}
+
+func TestTemplateEnvIsAppliedBeforeInstructions(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: fsltest
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - template:
+        name: fsl
+        version: 6.0.7.19
+        install_path: /opt/fsl
+`)
+	if err != nil {
+		t.Fatalf("loading recipe: %v", err)
+	}
+	def, err := build.Generate(nil)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	envAt, runAt := -1, -1
+	for i, d := range def.Directives {
+		if !strings.Contains(string(d.Source), "template fsl") {
+			continue
+		}
+		switch dir := d.Directive.(type) {
+		case ir.EnvironmentDirective:
+			if envAt < 0 {
+				envAt = i
+			}
+			if dir["FSLDIR"] != "/opt/fsl" || dir["FSLOUTPUTTYPE"] != "NIFTI_GZ" {
+				t.Fatalf("unexpected fsl env %v", dir)
+			}
+			if d.Provenance.Template != "fsl" {
+				t.Fatalf("env provenance = %+v", d.Provenance)
+			}
+		case ir.RunDirective:
+			if runAt < 0 {
+				runAt = i
+			}
+		}
+	}
+	if envAt < 0 || runAt < 0 || envAt > runAt {
+		t.Fatalf("expected the fsl env before its first RUN, got env at %d and run at %d", envAt, runAt)
+	}
+}
+
+func TestTemplateParamsAreChecked(t *testing.T) {
+	for _, tc := range []struct {
+		params string
+		want   []string
+	}{
+		{"install_path: /opt/fsl", []string{`template "fsl" (method binaries)`, "missing required parameter(s) version", "required: version", `install_path (default "/opt/fsl-{{ self.version }}")`}},
+		{"version: 6.0.7.19\n        instal_path: /opt/fsl", []string{"unknown parameter(s) instal_path", `exclude_paths (list, default "")`}},
+	} {
+		_, err := loadBuildYAML(t, `name: fsltest
+version: 1.0.0
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - template:
+        name: fsl
+        `+tc.params+`
+`)
+		if err == nil {
+			t.Fatalf("%s: expected an error", tc.params)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Fatalf("%s: error %q does not mention %q", tc.params, err, want)
+			}
+		}
+	}
+}
+
+func TestTemplateSpecExecuteRequiresArguments(t *testing.T) {
+	templateSpec, err := getTemplateSpec("", "fsl")
+	if err != nil {
+		t.Fatalf("Failed to get fsl template spec: %v", err)
+	}
+	_, err = templateSpec.Execute(templateContext{PackageManager: common.PkgManagerApt, Arch: "x86_64"},
+		func(k string) (any, bool, error) { return nil, false, nil })
+	if err == nil || !strings.Contains(err.Error(), "missing required parameter(s) version") {
+		t.Fatalf("expected a missing version error, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package recipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"github.com/neurodesk/builder/pkg/jinja2"
+	"github.com/neurodesk/builder/pkg/resolve"
+	starlarkpkg "github.com/neurodesk/builder/pkg/starlark"
+	v "github.com/neurodesk/builder/pkg/validator"
+)
+
+// A template can be written in Starlark instead of YAML: <name>.star defines
+//
+//	def execute(ctx, params):
+//	    return [{"run": ["..."]}, {"environment": {"K": "v"}}]
+//
+// where ctx exposes the same variables as context in a starlark directive,
+// params holds the template directive's parameters, and each returned dict
+// is a directive exactly as written in YAML. The script may also call the
+// directive builtins (run, env, ...) and return None.
+
+// findStarlarkTemplate returns the path of <name>.star in the template spec
+// directory templateDir or an include directory. A Starlark template takes
+// precedence over a YAML template of the same name.
+func findStarlarkTemplate(templateDir string, includeDirs []string, name string) (string, bool) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	var dirs []string
+	if templateDir != "" {
+		dirs = append(dirs, templateDir)
+	}
+	dirs = append(dirs, includeDirs...)
+	path, err := resolve.Resolver{IncludeDirs: dirs}.Find("starlark template", name+".star")
+	return path, err == nil
+}
+
+// starlarkPluginPending reports whether name must be left unresolved during
+// validation: build files are validated on load, before the include
+// directories are known, so a .star file can only be looked for when
+// generating.
+func starlarkPluginPending(ctx Context) bool {
+	return ctx.IncludeDirectories == nil
+}
+
+func applyStarlarkTemplate(ctx *Context, src ir.SourceID, path string, params map[string]any) error {
+	script, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading starlark template %q: %w", path, err)
+	}
+	ctx.recordInput(path)
+
+	values := jinja2.DictValue{}
+	for k, v := range params {
+		val, err := ctx.evaluateValue(v)
+		if err != nil {
+			return fmt.Errorf("evaluating template param %q: %w", k, err)
+		}
+		values[k] = jinja2.FromGo(val)
+	}
+
+	child := ctx.childContext()
+	child.provenance.Template = strings.TrimSuffix(filepath.Base(path), ".star")
+	eval := starlarkpkg.NewEvaluatorWithStarlarkContext(child, src)
+	child.enableStarlarkLoad(eval)
+	if _, err := eval.ExecFile(path, script); err != nil {
+		return err
+	}
+	result, err := eval.Call("execute",
+		starlarkpkg.NewContextObject(child.starlarkVariables()),
+		starlarkpkg.ConvertToStarlark(values),
+	)
+	if err != nil {
+		return err
+	}
+
+	var items []any
+	switch val := starlarkpkg.ToGo(result).(type) {
+	case nil:
+	case []any:
+		items = val
+	default:
+		return fmt.Errorf("execute must return a list of directives or None, got %s", result.Type())
+	}
+	for i, item := range items {
+		description := fmt.Sprintf("directives[%d]", i)
+		if _, ok := item.(map[string]any); !ok {
+			return fmt.Errorf("%s: expected a dict, got %T", description, item)
+		}
+		d, err := directiveFromGo(item)
+		if err != nil {
+			return fmt.Errorf("%s: %w", description, err)
+		}
+		if d.Source == "" {
+			d.Source = nestedSource(src, "template %s[%d]", child.provenance.Template, i)
+		}
+		if err := v.At(description, d.Validate(*child)); err != nil {
+			return err
+		}
+		if err := d.Apply(child); err != nil {
+			return fmt.Errorf("applying %s (%s): %w", description, d.kind(), err)
+		}
+	}
+
+	ctx.adoptTemplateChild(child)
+	return nil
+}
@@ -0,0 +1,148 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/common"
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+func writeStarlarkTemplates(t *testing.T, templates map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range templates {
+		if err := os.WriteFile(filepath.Join(dir, name+".star"), []byte(src), 0o644); err != nil {
+			t.Fatalf("writing %s.star: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func applyTemplateDockerfile(t *testing.T, dir string, directive TemplateDirective) (string, error) {
+	t.Helper()
+	ctx := newContext(common.PkgManagerApt, "2.1.0", []string{dir}, ir.New(), nil)
+	if err := directive.Validate(*ctx); err != nil {
+		return "", err
+	}
+	if err := directive.Apply(ctx, ""); err != nil {
+		return "", err
+	}
+	def, err := ctx.Compile()
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	return dockerfile, nil
+}
+
+func TestStarlarkTemplateReturnsDirectives(t *testing.T) {
+	dir := writeStarlarkTemplates(t, map[string]string{
+		"mytool": `
+def execute(ctx, params):
+    prefix = "/opt/mytool-" + params["version"]
+    steps = [{"run": ["curl -fsSL https://example.org/mytool-" + params["version"] + ".tar.gz | tar xz -C " + prefix]}]
+    if params.get("shared", False):
+        steps.append({"environment": {"MYTOOL_SHARED": "1"}})
+    steps.append({"environment": {"MYTOOL_HOME": prefix, "BUILT_FOR": ctx.version}})
+    return steps
+`,
+	})
+
+	dockerfile, err := applyTemplateDockerfile(t, dir, TemplateDirective{
+		Name:   "mytool",
+		Params: map[string]any{"version": "{{ context.version }}", "shared": true},
+	})
+	if err != nil {
+		t.Fatalf("applying template: %v", err)
+	}
+	for _, want := range []string{"mytool-2.1.0.tar.gz | tar xz -C /opt/mytool-2.1.0", "MYTOOL_SHARED", "MYTOOL_HOME", "BUILT_FOR"} {
+		if !strings.Contains(dockerfile, want) {
+			t.Fatalf("Dockerfile is missing %q:\n%s", want, dockerfile)
+		}
+	}
+}
+
+func TestStarlarkTemplateOverridesYAMLTemplate(t *testing.T) {
+	dir := writeStarlarkTemplates(t, map[string]string{
+		"dcm2niix": `
+def execute(ctx, params):
+    run("echo starlark dcm2niix " + params["version"])
+`,
+	})
+	dockerfile, err := applyTemplateDockerfile(t, dir, TemplateDirective{
+		Name:   "dcm2niix",
+		Params: map[string]any{"version": "1.0"},
+	})
+	if err != nil {
+		t.Fatalf("applying template: %v", err)
+	}
+	if !strings.Contains(dockerfile, "echo starlark dcm2niix 1.0") {
+		t.Fatalf("Starlark template was not used:\n%s", dockerfile)
+	}
+}
+
+func TestStarlarkTemplateErrors(t *testing.T) {
+	dir := writeStarlarkTemplates(t, map[string]string{
+		"noexecute": "x = 1\n",
+		"notalist":  "def execute(ctx, params):\n    return 1\n",
+		"badentry":  "def execute(ctx, params):\n    return [\"run\"]\n",
+		"unknown":   "def execute(ctx, params):\n    return [{\"bogus\": 1}]\n",
+		"invalid":   "def execute(ctx, params):\n    return [{\"run\": [\"echo {{ unclosed\"]}]\n",
+	})
+	for _, tc := range []struct {
+		name    string
+		wantErr string
+	}{
+		{"noexecute", "execute is not defined"},
+		{"notalist", "execute must return a list of directives or None, got int"},
+		{"badentry", "directives[0]: expected a dict"},
+		{"unknown", "directives[0]: decoding directive"},
+		{"invalid", "directives[0].run[0]: invalid jinja template"},
+	} {
+		_, err := applyTemplateDockerfile(t, dir, TemplateDirective{Name: tc.name})
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("%s: error = %v, want %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestStarlarkTemplateInRecipe(t *testing.T) {
+	dir := writeStarlarkTemplates(t, map[string]string{
+		"mytool": "def execute(ctx, params):\n    return [{\"run\": [\"echo mytool \" + params[\"version\"]]}]\n",
+	})
+	build, err := loadBuildYAML(t, `name: templated
+version: 1.0.0
+architectures:
+  - x86_64
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - template:
+        name: mytool
+        version: "3.1"
+`)
+	if err != nil {
+		t.Fatalf("loading build file: %v", err)
+	}
+	def, _, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{dir}})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	if !strings.Contains(dockerfile, "echo mytool 3.1") {
+		t.Fatalf("Dockerfile is missing the template's command:\n%s", dockerfile)
+	}
+}
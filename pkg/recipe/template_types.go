@@ -0,0 +1,151 @@
+package recipe
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Template argument types. Macros read every argument as a string, so
+// coercion turns each value into the string form its type implies.
+const (
+	argTypeString = "string"
+	argTypeInt    = "int"
+	argTypeBool   = "bool"
+	argTypeList   = "list"
+	argTypeEnum   = "enum"
+)
+
+// templateArgumentType declares the type of a template argument under
+// arguments.types. An enum without values accepts the keys of the
+// template's urls, which is how version-keyed templates list their
+// versions.
+type templateArgumentType struct {
+	Type   string   `yaml:"type"`
+	Values []string `yaml:"values,omitempty"`
+}
+
+func (a templateArgumentType) validate(key string, declared bool) error {
+	if !declared {
+		return fmt.Errorf("arguments.types: %q is not a required or optional argument", key)
+	}
+	switch a.Type {
+	case argTypeString, argTypeInt, argTypeBool, argTypeList:
+		if len(a.Values) > 0 {
+			return fmt.Errorf("arguments.types[%q]: values are only allowed for enum", key)
+		}
+	case argTypeEnum:
+	default:
+		return fmt.Errorf("arguments.types[%q]: unknown type %q (want string, int, bool, list or enum)", key, a.Type)
+	}
+	return nil
+}
+
+// enumValues returns the values an enum argument accepts, sorted; nil
+// means any value.
+func (t *recipeTemplateSpec) enumValues(a templateArgumentType) []string {
+	values := a.Values
+	if len(values) == 0 {
+		if _, ok := t.Urls["*"]; ok {
+			return nil
+		}
+		for k := range t.Urls {
+			values = append(values, k)
+		}
+	}
+	values = slices.Clone(values)
+	sort.Strings(values)
+	return values
+}
+
+// coerceArgument converts the evaluated value of parameter key of template
+// name to the string form its declared type implies, or reports why it does
+// not fit. Undeclared arguments keep the loose conversion templates have
+// always had: booleans and numbers become strings, anything else passes
+// through.
+func (t *recipeTemplateSpec) coerceArgument(name, key string, val any) (any, error) {
+	typ, ok := t.Arguments.Types[key]
+	if !ok {
+		switch v := val.(type) {
+		case bool:
+			return strconv.FormatBool(v), nil
+		case int, int32, int64, float32, float64:
+			return fmt.Sprint(v), nil
+		}
+		return val, nil
+	}
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("parameter %q of template %q %s", key, name, fmt.Sprintf(format, args...))
+	}
+
+	if typ.Type == argTypeList {
+		switch v := val.(type) {
+		case string:
+			return strings.Join(strings.Fields(v), " "), nil
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				s, ok := scalarString(item)
+				if !ok {
+					return nil, fail("must be a list of scalars, got %T at index %d", item, i)
+				}
+				items[i] = s
+			}
+			return strings.Join(items, " "), nil
+		default:
+			return nil, fail("must be a list, got %T", val)
+		}
+	}
+
+	s, ok := scalarString(val)
+	if !ok {
+		return nil, fail("takes a single %s value, got %s", typ.Type, describeValue(val))
+	}
+	switch typ.Type {
+	case argTypeInt:
+		if _, err := strconv.Atoi(s); err != nil {
+			return nil, fail("must be an integer, got %q", s)
+		}
+	case argTypeBool:
+		switch strings.ToLower(s) {
+		case "true", "yes", "y", "1":
+			s = "true"
+		case "false", "no", "n", "0":
+			s = "false"
+		default:
+			return nil, fail("must be true or false, got %q", s)
+		}
+	case argTypeEnum:
+		if values := t.enumValues(typ); values != nil && !slices.Contains(values, s) {
+			return nil, fail("must be one of %s; got %q", strings.Join(values, ", "), s)
+		}
+	}
+	return s, nil
+}
+
+// scalarString formats a YAML scalar as a string.
+func scalarString(val any) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int, int32, int64, uint, uint32, uint64, float32, float64:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+func describeValue(val any) string {
+	switch val.(type) {
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "a mapping"
+	case nil:
+		return "nothing"
+	}
+	return fmt.Sprintf("%T", val)
+}
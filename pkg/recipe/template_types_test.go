@@ -0,0 +1,104 @@
+package recipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/jinja2"
+)
+
+func TestCoerceTemplateArgument(t *testing.T) {
+	spec := &recipeTemplateSpec{
+		Arguments: templateArguments{
+			Required: []string{"version"},
+			Optional: map[string]jinja2.TemplateString{"jobs": "1", "gui": "false", "extras": "", "flavour": "full"},
+			Types: map[string]templateArgumentType{
+				"version": {Type: argTypeEnum},
+				"jobs":    {Type: argTypeInt},
+				"gui":     {Type: argTypeBool},
+				"extras":  {Type: argTypeList},
+				"flavour": {Type: argTypeEnum, Values: []string{"full", "libre"}},
+			},
+		},
+		Urls: map[string]jinja2.TemplateString{"1.0": "a", "2.0": "b"},
+	}
+	for _, tc := range []struct {
+		key     string
+		val     any
+		want    any
+		wantErr string
+	}{
+		{"version", "2.0", "2.0", ""},
+		{"version", "latst", nil, `must be one of 1.0, 2.0; got "latst"`},
+		{"version", []any{"1.0"}, nil, "takes a single enum value, got a list"},
+		{"jobs", 4, "4", ""},
+		{"jobs", "four", nil, "must be an integer"},
+		{"gui", true, "true", ""},
+		{"gui", "Yes", "true", ""},
+		{"gui", "maybe", nil, "must be true or false"},
+		{"extras", []any{"a", "b"}, "a b", ""},
+		{"extras", " a  b ", "a b", ""},
+		{"extras", []any{[]any{"a"}}, nil, "must be a list of scalars"},
+		{"flavour", "libre", "libre", ""},
+		{"flavour", "free", nil, "must be one of full, libre"},
+		{"other", false, "false", ""},
+		{"other", []any{"kept"}, []any{"kept"}, ""},
+	} {
+		got, err := spec.coerceArgument("tool", tc.key, tc.val)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("%s=%v: expected error containing %q, got %v", tc.key, tc.val, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s=%v: %v", tc.key, tc.val, err)
+		}
+		if s, ok := tc.want.(string); ok && got != s {
+			t.Fatalf("%s=%v: got %v, want %v", tc.key, tc.val, got, tc.want)
+		}
+	}
+
+	// A "*" url accepts any version.
+	spec.Urls["*"] = "c"
+	if _, err := spec.coerceArgument("tool", "version", "3.0"); err != nil {
+		t.Fatalf("wildcard urls: %v", err)
+	}
+}
+
+func TestTemplateArgumentTypesAreValidated(t *testing.T) {
+	for _, tc := range []struct {
+		args    templateArguments
+		wantErr string
+	}{
+		{templateArguments{Types: map[string]templateArgumentType{"version": {Type: argTypeEnum}}}, "not a required or optional argument"},
+		{templateArguments{Required: []string{"n"}, Types: map[string]templateArgumentType{"n": {Type: "float"}}}, "unknown type"},
+		{templateArguments{Required: []string{"n"}, Types: map[string]templateArgumentType{"n": {Type: argTypeInt, Values: []string{"1"}}}}, "only allowed for enum"},
+	} {
+		if err := tc.args.Validate(); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+		}
+	}
+}
+
+func TestTemplateVersionTypoFailsGeneration(t *testing.T) {
+	build, err := loadBuildYAML(t, `name: fsltest
+version: 1.0.0
+architectures: [x86_64]
+build:
+  kind: neurodocker
+  base-image: ubuntu:24.04
+  pkg-manager: apt
+  directives:
+    - template:
+        name: fsl
+        version: latst
+`)
+	if err != nil {
+		t.Fatalf("loading recipe: %v", err)
+	}
+	_, err = build.Generate(nil)
+	if err == nil || !strings.Contains(err.Error(), `parameter "version" of template "fsl" must be one of`) || !strings.Contains(err.Error(), "6.0.7.19") {
+		t.Fatalf("expected a version error listing the known versions, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package recipe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// UpstreamKind is where an upstream publishes its releases.
+type UpstreamKind string
+
+const (
+	UpstreamGitHub UpstreamKind = "github"
+	UpstreamPyPI   UpstreamKind = "pypi"
+	UpstreamConda  UpstreamKind = "conda"
+	UpstreamURL    UpstreamKind = "url"
+)
+
+// UpstreamInfo says where new releases of the software a recipe packages
+// appear, for `builder outdated`.
+type UpstreamInfo struct {
+	Kind UpstreamKind `yaml:"kind"`
+	// Repo is the GitHub "owner/repo" whose releases are versions.
+	Repo string `yaml:"repo,omitempty"`
+	// Package is the PyPI or conda package.
+	Package string `yaml:"package,omitempty"`
+	// Channel is the conda channel; conda-forge if empty.
+	Channel string `yaml:"channel,omitempty"`
+	// URL is a page listing the releases.
+	URL string `yaml:"url,omitempty"`
+	// Regex extracts versions: from the page at URL, or from GitHub tag
+	// names, where a leading v is dropped by default. Its first group, if it
+	// has one, is the version.
+	Regex string `yaml:"regex,omitempty"`
+	// Prereleases also reports pre-releases: GitHub releases marked as
+	// such, and elsewhere versions with letters, such as 2.0rc1.
+	Prereleases bool `yaml:"prereleases,omitempty"`
+}
+
+// Upstream returns the upstream of the recipe: upstream, or else the GitHub
+// repository of an auto_update entry with a github method.
+func (b *BuildFile) Upstream() *UpstreamInfo {
+	if b.UpstreamInfo != nil {
+		return b.UpstreamInfo
+	}
+	if b.AutoUpdate != nil && b.AutoUpdate.Repo != "" && strings.HasPrefix(string(b.AutoUpdate.Method), "github") {
+		repo := strings.TrimPrefix(strings.Trim(b.AutoUpdate.Repo, "/"), "https://github.com/")
+		return &UpstreamInfo{Kind: UpstreamGitHub, Repo: repo}
+	}
+	return nil
+}
+
+func (u *UpstreamInfo) Validate() error {
+	if u == nil {
+		return nil
+	}
+	require := func(field, value string) error {
+		if value == "" {
+			return fmt.Errorf("upstream: kind %s needs %s", u.Kind, field)
+		}
+		return nil
+	}
+	var err error
+	switch u.Kind {
+	case UpstreamGitHub:
+		err = require("repo", u.Repo)
+		if err == nil && strings.Count(u.Repo, "/") != 1 {
+			err = fmt.Errorf("upstream: repo must be a GitHub owner/repo, got %q", u.Repo)
+		}
+	case UpstreamPyPI, UpstreamConda:
+		err = require("package", u.Package)
+	case UpstreamURL:
+		if err = require("url", u.URL); err == nil {
+			err = require("regex", u.Regex)
+		}
+	default:
+		return fmt.Errorf("upstream: kind must be one of github, pypi, conda or url, got %q", u.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	if u.Regex != "" {
+		if _, err := regexp.Compile(u.Regex); err != nil {
+			return fmt.Errorf("upstream: invalid regex: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package recipe
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/neurodesk/builder/pkg/resolve"
+	"go.yaml.in/yaml/v4"
+)
+
+// envVariablePrefix marks a top-level variable whose value is read from the
+// environment of the builder at generation time, e.g.
+// `mirror: env://SITE_MIRROR`.
+const envVariablePrefix = "env://"
+
+// validateVariableSources checks the shape of variables_from and env://
+// references; whether the files and environment variables exist is only
+// checked when generating.
+func (b *BuildFile) validateVariableSources() error {
+	for i, path := range b.VariablesFrom {
+		if strings.TrimSpace(path) == "" {
+			return fmt.Errorf("variables_from[%d]: path must not be empty", i)
+		}
+	}
+	for key, val := range b.Variables {
+		if name, ok := envVariableName(val); ok && !identifierPattern.MatchString(name) {
+			return fmt.Errorf("variables.%s: invalid environment variable name %q", key, name)
+		}
+	}
+	return nil
+}
+
+// resolveVariables returns the top-level variables: those in the recipe,
+// overridden by each variables_from file in order, with env:// values
+// replaced by the environment variable they name. variables_from paths are
+// looked up in the recipe directory, then in includeDirs, and passed to
+// record.
+func (b *BuildFile) resolveVariables(includeDirs []string, record func(path string)) (map[string]any, error) {
+	out := make(map[string]any, len(b.Variables))
+	for k, val := range b.Variables {
+		out[k] = val
+	}
+
+	resolver := resolve.Resolver{RecipeDir: b.dir, IncludeDirs: includeDirs, AllowAbsolute: true}
+	for _, name := range b.VariablesFrom {
+		path, err := resolver.Find("variables file", name)
+		if err != nil {
+			return nil, err
+		}
+		record(path)
+		vars, err := readVariablesFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, val := range vars {
+			out[k] = val
+		}
+	}
+
+	keys := make([]string, 0, len(out))
+	for k := range out {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name, ok := envVariableName(out[k])
+		if !ok {
+			continue
+		}
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("variable %q: environment variable %s is not set", k, name)
+		}
+		out[k] = val
+	}
+	return out, nil
+}
+
+func readVariablesFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading variables file: %w", err)
+	}
+	var vars map[string]any
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("parsing variables file %q: %w", path, err)
+	}
+	return vars, nil
+}
+
+func envVariableName(val any) (string, bool) {
+	s, ok := val.(string)
+	if !ok {
+		return "", false
+	}
+	return strings.CutPrefix(s, envVariablePrefix)
+}
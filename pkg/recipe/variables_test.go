@@ -0,0 +1,88 @@
+package recipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/ir"
+)
+
+const siteVariablesRecipe = `name: site
+version: 1.0.0
+architectures:
+  - x86_64
+variables:
+  mirror: https://default.example.org
+  license_server: env://BUILDER_TEST_LICENSE_SERVER
+variables_from:
+  - site-vars.yaml
+build:
+  kind: neurodocker
+  base-image: ubuntu:22.04
+  pkg-manager: apt
+  add-default-template: false
+  add-tzdata: false
+  directives:
+    - run:
+        - echo {{ context.mirror }} {{ context.license_server }} {{ context.proxy }}
+`
+
+func TestVariablesFromFilesAndEnvironment(t *testing.T) {
+	recipeDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(recipeDir, "build.yaml"), []byte(siteVariablesRecipe), 0o644); err != nil {
+		t.Fatalf("writing build.yaml: %v", err)
+	}
+	build, err := LoadBuildFile(recipeDir)
+	if err != nil {
+		t.Fatalf("LoadBuildFile: %v", err)
+	}
+
+	// The variables file is only needed when generating, and is also found
+	// in the include directories.
+	siteDir := t.TempDir()
+	if _, _, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{siteDir}}); err == nil || !strings.Contains(err.Error(), "site-vars.yaml") {
+		t.Fatalf("expected missing variables file error, got %v", err)
+	}
+	siteVars := "mirror: https://mirror.site.example\nproxy: env://BUILDER_TEST_PROXY\n"
+	if err := os.WriteFile(filepath.Join(siteDir, "site-vars.yaml"), []byte(siteVars), 0o644); err != nil {
+		t.Fatalf("writing site-vars.yaml: %v", err)
+	}
+
+	t.Setenv("BUILDER_TEST_LICENSE_SERVER", "27000@license.site")
+	if _, _, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{siteDir}}); err == nil || !strings.Contains(err.Error(), "BUILDER_TEST_PROXY is not set") {
+		t.Fatalf("expected missing environment variable error, got %v", err)
+	}
+
+	t.Setenv("BUILDER_TEST_PROXY", "http://proxy:3128")
+	def, _, err := build.GenerateWithParams(GenerateParams{IncludeDirs: []string{siteDir}})
+	if err != nil {
+		t.Fatalf("GenerateWithParams: %v", err)
+	}
+	dockerfile, err := ir.GenerateDockerfile(def)
+	if err != nil {
+		t.Fatalf("GenerateDockerfile: %v", err)
+	}
+	want := "echo https://mirror.site.example 27000@license.site http://proxy:3128"
+	if !strings.Contains(dockerfile, want) {
+		t.Fatalf("Dockerfile missing %q:\n%s", want, dockerfile)
+	}
+}
+
+func TestVariableSourcesAreValidated(t *testing.T) {
+	for _, tc := range []struct {
+		variables string
+		wantErr   string
+	}{
+		{"variables:\n  x: env://not-a-name\n", "invalid environment variable name"},
+		{"variables_from:\n  - \"\"\n", "variables_from[0]"},
+	} {
+		t.Run(tc.wantErr, func(t *testing.T) {
+			_, err := loadBuildYAML(t, strings.NewReplacer("build:\n", tc.variables+"build:\n", "DIRECTIVE", "run: [echo]").Replace(singleDirectiveRecipe))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package resolve
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Match is a file that a directory or glob pattern resolved to.
+type Match struct {
+	// Path is where the file is on the host.
+	Path string
+	// Rel is its slash-separated path below the directory the pattern was
+	// resolved in, which is kept when the files are staged.
+	Rel string
+}
+
+// IsPattern reports whether path is a glob pattern rather than a path.
+func IsPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// FindTree is Find for paths that may also name a directory or glob
+// pattern. It returns the location of a single file, or else the files
+// below it as Files does.
+func (r Resolver) FindTree(kind, p string) (string, []Match, error) {
+	if !IsPattern(filepath.ToSlash(p)) {
+		found, err := r.Find(kind, p)
+		if err != nil {
+			return "", nil, err
+		}
+		if st, err := os.Stat(found); err != nil || !st.IsDir() {
+			return found, nil, err
+		}
+	}
+	files, err := r.Files(kind, p)
+	return "", files, err
+}
+
+// Files returns the regular files that path names when it is a directory or
+// a glob pattern. A directory yields every file below it. A pattern is
+// matched with path.Match per component, where a ** component matches any
+// number of directories, below its leading components without
+// metacharacters, which are found like Find finds a path (the recipe
+// directory, then each include directory). Rel is relative to that
+// directory. .git directories are skipped, and matching no file is an
+// error.
+func (r Resolver) Files(kind, p string) ([]Match, error) {
+	pattern := filepath.ToSlash(p)
+	var base, rest []string
+	if IsPattern(pattern) {
+		segments := strings.Split(pattern, "/")
+		for i, seg := range segments {
+			if IsPattern(seg) {
+				base, rest = segments[:i], segments[i:]
+				break
+			}
+		}
+		for _, seg := range rest {
+			if seg == "**" {
+				continue
+			}
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("%s pattern %q: %w", kind, p, err)
+			}
+		}
+	} else {
+		base = []string{pattern}
+	}
+
+	baseDir := strings.Join(base, "/")
+	if baseDir == "" {
+		baseDir = "."
+	}
+	dir, err := r.Find(kind, filepath.FromSlash(baseDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Match
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" && name != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if st, err := os.Stat(name); err != nil || !st.Mode().IsRegular() {
+				return nil
+			}
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rest != nil && !matchSegments(rest, strings.Split(rel, "/")) {
+			return nil
+		}
+		out = append(out, Match{Path: name, Rel: rel})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing %s %q: %w", kind, p, err)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s %q matched no files in %s", kind, p, dir)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Rel < out[j].Rel })
+	return out, nil
+}
+
+// matchSegments matches the components of a path against those of a
+// pattern, where ** matches zero or more components.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,158 @@
+// Package resolve locates resources that recipes refer to by relative path:
+// files.filename entries, COPY sources, include files and starlark files.
+//
+// Lookup precedence is always:
+//
+//  1. Absolute paths are used as-is when AllowAbsolute is set, and rejected otherwise.
+//  2. The recipe directory, when RecipeDir is set.
+//  3. Each include directory, in configured order.
+//
+// The first candidate that exists wins. When Confine is set, a candidate whose
+// symlink-resolved location is outside the directory it was found in is
+// rejected with an *EscapeError instead of being used; otherwise symlinks and
+// ".." components are followed freely.
+package resolve
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Resolver searches a recipe directory and include directories for a path.
+type Resolver struct {
+	RecipeDir   string
+	IncludeDirs []string
+
+	AllowAbsolute bool
+	Confine       bool
+}
+
+// NotFoundError reports every location that was searched for Path.
+type NotFoundError struct {
+	Kind     string
+	Path     string
+	Searched []string
+}
+
+func (e *NotFoundError) Error() string {
+	if len(e.Searched) == 0 {
+		return fmt.Sprintf("%s %q not found: no search locations configured", e.Kind, e.Path)
+	}
+	return fmt.Sprintf("%s %q not found (searched: %s)", e.Kind, e.Path, strings.Join(e.Searched, ", "))
+}
+
+// EscapeError reports a candidate that resolves outside its search root.
+type EscapeError struct {
+	Kind     string
+	Path     string
+	Root     string
+	Resolved string
+}
+
+func (e *EscapeError) Error() string {
+	return fmt.Sprintf("%s %q resolves to %s, outside %s", e.Kind, e.Path, e.Resolved, e.Root)
+}
+
+// IsNotFound reports whether err is a *NotFoundError.
+func IsNotFound(err error) bool {
+	var nf *NotFoundError
+	return errors.As(err, &nf)
+}
+
+type candidate struct {
+	root string
+	path string
+}
+
+func (r Resolver) candidates(path string) []candidate {
+	var out []candidate
+	if r.RecipeDir != "" {
+		out = append(out, candidate{root: r.RecipeDir, path: filepath.Join(r.RecipeDir, path)})
+	}
+	for _, dir := range r.IncludeDirs {
+		if dir == "" {
+			continue
+		}
+		out = append(out, candidate{root: dir, path: filepath.Join(dir, path)})
+	}
+	return out
+}
+
+// Candidates returns the locations Find would check for path, in order.
+func (r Resolver) Candidates(path string) []string {
+	if filepath.IsAbs(path) {
+		if r.AllowAbsolute {
+			return []string{path}
+		}
+		return nil
+	}
+	var out []string
+	for _, c := range r.candidates(filepath.FromSlash(path)) {
+		out = append(out, c.path)
+	}
+	return out
+}
+
+// Find returns the first existing location for path. kind describes the
+// resource in error messages (for example "file" or "include file").
+func (r Resolver) Find(kind, path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("%s path is empty", kind)
+	}
+	if filepath.IsAbs(path) {
+		if !r.AllowAbsolute {
+			return "", fmt.Errorf("absolute %s paths are not allowed: %q", kind, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return "", &NotFoundError{Kind: kind, Path: path, Searched: []string{path}}
+			}
+			return "", fmt.Errorf("stating %s %q: %w", kind, path, err)
+		}
+		return path, nil
+	}
+
+	var searched []string
+	for _, c := range r.candidates(filepath.FromSlash(path)) {
+		searched = append(searched, c.path)
+		if _, err := os.Stat(c.path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return "", fmt.Errorf("stating %s %q: %w", kind, c.path, err)
+		}
+		if r.Confine {
+			if err := confined(kind, path, c); err != nil {
+				return "", err
+			}
+		}
+		return c.path, nil
+	}
+	return "", &NotFoundError{Kind: kind, Path: path, Searched: searched}
+}
+
+func confined(kind, path string, c candidate) error {
+	root, err := filepath.Abs(c.root)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	target, err := filepath.EvalSymlinks(c.path)
+	if err != nil {
+		return fmt.Errorf("resolving %s %q: %w", kind, c.path, err)
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &EscapeError{Kind: kind, Path: path, Root: root, Resolved: target}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package resolve
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindPrecedence(t *testing.T) {
+	recipe := t.TempDir()
+	inc1 := t.TempDir()
+	inc2 := t.TempDir()
+	writeFile(t, filepath.Join(recipe, "both.txt"))
+	writeFile(t, filepath.Join(inc1, "both.txt"))
+	writeFile(t, filepath.Join(inc1, "inc.txt"))
+	writeFile(t, filepath.Join(inc2, "inc.txt"))
+	writeFile(t, filepath.Join(inc2, "last.txt"))
+
+	r := Resolver{RecipeDir: recipe, IncludeDirs: []string{inc1, inc2}}
+	tests := map[string]string{
+		"both.txt": filepath.Join(recipe, "both.txt"),
+		"inc.txt":  filepath.Join(inc1, "inc.txt"),
+		"last.txt": filepath.Join(inc2, "last.txt"),
+	}
+	for path, want := range tests {
+		got, err := r.Find("file", path)
+		if err != nil {
+			t.Fatalf("Find(%q): %v", path, err)
+		}
+		if got != want {
+			t.Fatalf("Find(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestFindNotFoundListsSearchedLocations(t *testing.T) {
+	recipe := t.TempDir()
+	inc := t.TempDir()
+	r := Resolver{RecipeDir: recipe, IncludeDirs: []string{inc}}
+
+	_, err := r.Find("include file", "missing.yaml")
+	if !IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	for _, want := range []string{filepath.Join(recipe, "missing.yaml"), filepath.Join(inc, "missing.yaml"), "include file"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestFindAbsolutePolicy(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(dir, "a.txt")
+	writeFile(t, abs)
+
+	if _, err := (Resolver{RecipeDir: dir}).Find("COPY source", abs); err == nil {
+		t.Fatalf("expected absolute path to be rejected")
+	}
+	got, err := Resolver{AllowAbsolute: true}.Find("file", abs)
+	if err != nil || got != abs {
+		t.Fatalf("Find(abs) = %q, %v", got, err)
+	}
+}
+
+func TestFindConfineRejectsEscapes(t *testing.T) {
+	outside := t.TempDir()
+	writeFile(t, filepath.Join(outside, "secret.txt"))
+	recipe := t.TempDir()
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(recipe, "link.txt")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	r := Resolver{RecipeDir: recipe, Confine: true}
+	var escape *EscapeError
+	if _, err := r.Find("COPY source", "link.txt"); !errors.As(err, &escape) {
+		t.Fatalf("expected escape error for symlink, got %v", err)
+	}
+	if _, err := r.Find("COPY source", "../"+filepath.Base(outside)+"/secret.txt"); !errors.As(err, &escape) {
+		t.Fatalf("expected escape error for dot-dot path, got %v", err)
+	}
+
+	if _, err := (Resolver{RecipeDir: recipe}).Find("file", "link.txt"); err != nil {
+		t.Fatalf("unconfined lookup should follow symlinks: %v", err)
+	}
+}
+
+func matchRels(matches []Match) []string {
+	var out []string
+	for _, m := range matches {
+		out = append(out, m.Rel)
+	}
+	return out
+}
+
+func TestFilesDirectoriesAndPatterns(t *testing.T) {
+	recipe := t.TempDir()
+	include := t.TempDir()
+	writeFile(t, filepath.Join(recipe, "patches", "0001-fix.patch"))
+	writeFile(t, filepath.Join(recipe, "patches", "0002-build.patch"))
+	writeFile(t, filepath.Join(recipe, "patches", "README"))
+	writeFile(t, filepath.Join(recipe, "patches", "extra", "0003-arm.patch"))
+	writeFile(t, filepath.Join(recipe, "patches", ".git", "HEAD"))
+	writeFile(t, filepath.Join(include, "models", "a", "weights.bin"))
+	r := Resolver{RecipeDir: recipe, IncludeDirs: []string{include}}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"patches/*.patch", []string{"0001-fix.patch", "0002-build.patch"}},
+		{"patches/**/*.patch", []string{"0001-fix.patch", "0002-build.patch", "extra/0003-arm.patch"}},
+		{"patches", []string{"0001-fix.patch", "0002-build.patch", "README", "extra/0003-arm.patch"}},
+		{"models/*/weights.bin", []string{"a/weights.bin"}},
+	}
+	for _, tt := range tests {
+		got, err := r.Files("file", tt.path)
+		if err != nil {
+			t.Fatalf("Files(%q): %v", tt.path, err)
+		}
+		if strings.Join(matchRels(got), ",") != strings.Join(tt.want, ",") {
+			t.Fatalf("Files(%q) = %v, want %v", tt.path, matchRels(got), tt.want)
+		}
+	}
+
+	if _, err := r.Files("file", "patches/*.diff"); err == nil || !strings.Contains(err.Error(), "matched no files") {
+		t.Fatalf("expected no match error, got %v", err)
+	}
+	if _, err := r.Files("file", "missing/*.patch"); !IsNotFound(err) {
+		t.Fatalf("expected not found error for missing base directory, got %v", err)
+	}
+}
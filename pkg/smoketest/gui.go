@@ -0,0 +1,108 @@
+package smoketest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/neurodesk/builder/pkg/desktop"
+)
+
+// GUIApp is a GUI application of a recipe (gui_apps).
+type GUIApp struct {
+	Name string
+	Exec string
+}
+
+// GUIResult is the outcome of CheckGUI.
+type GUIResult struct {
+	Name string
+	Exec string
+
+	FullPath string `json:",omitempty"`
+	// DesktopEntry is the .desktop file generated for the app.
+	DesktopEntry string `json:",omitempty"`
+
+	// Launch describes the headless launch: "running" when the app was
+	// still up at the timeout, "exited" when it quit successfully before,
+	// "skipped" when the image has no xvfb-run.
+	Launch string `json:",omitempty"`
+	// Output is the combined output of the launch, truncated.
+	Output string `json:",omitempty"`
+
+	Passed bool
+	Error  string `json:",omitempty"`
+}
+
+// launchFailures match output that shows a GUI application did not start
+// properly, whatever its exit code.
+var launchFailures = regexp.MustCompile(`(?i)(cannot open display|could not connect to display|` +
+	`error while loading shared libraries|could not load the qt platform plugin|` +
+	`segmentation fault|core dumped|command not found|` +
+	`(ModuleNotFoundError|ImportError|UnsatisfiedLinkError):)`)
+
+// CheckGUI checks that the executable of app exists, that a desktop entry
+// can be generated for it and, when the image has xvfb-run, that it starts
+// on a virtual display: an app that is still running after timeout, or has
+// exited with status 0, passes unless its output shows a failure.
+func CheckGUI(app GUIApp, timeout time.Duration) GUIResult {
+	res := GUIResult{Name: app.Name, Exec: app.Exec}
+	argv := strings.Fields(app.Exec)
+	if len(argv) == 0 {
+		res.Error = "gui app has no exec"
+		return res
+	}
+	full, err := exec.LookPath(argv[0])
+	if err != nil {
+		res.Error = fmt.Sprintf("looking up %q: %v", argv[0], err)
+		return res
+	}
+	res.FullPath = full
+
+	entry, err := desktop.Entry{Name: app.Name, Exec: app.Exec, TryExec: full}.Render()
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.DesktopEntry = entry
+
+	xvfb, err := exec.LookPath("xvfb-run")
+	if err != nil {
+		res.Launch = "skipped"
+		res.Passed = true
+		return res
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, xvfb, "-a", "/bin/sh", "-c", "exec "+app.Exec)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// Stop the X server and the app with xvfb-run.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = time.Second
+	err = cmd.Run()
+	res.Output = truncate(out.String())
+
+	switch {
+	case ctx.Err() != nil:
+		res.Launch = "running"
+	case err != nil:
+		res.Error = fmt.Sprintf("exited before %s: %v", timeout, err)
+		return res
+	default:
+		res.Launch = "exited"
+	}
+	if m := launchFailures.FindString(out.String()); m != "" {
+		res.Error = fmt.Sprintf("output reports %q", m)
+		return res
+	}
+	res.Passed = true
+	return res
+}
@@ -0,0 +1,33 @@
+package smoketest
+
+import "strings"
+
+// PythonImports returns a test per module that imports it with the Python
+// interpreter python, or python3 if it is empty.
+func PythonImports(python string, modules []string) []Test {
+	if python == "" {
+		python = "python3"
+	}
+	tests := make([]Test, len(modules))
+	for i, m := range modules {
+		tests[i] = Test{Name: "python import " + m, Command: shellQuote(python) + " -c " + shellQuote("import "+m)}
+	}
+	return tests
+}
+
+// RLibraries returns a test per package that loads it with rscript, or
+// Rscript if it is empty.
+func RLibraries(rscript string, packages []string) []Test {
+	if rscript == "" {
+		rscript = "Rscript"
+	}
+	tests := make([]Test, len(packages))
+	for i, p := range packages {
+		tests[i] = Test{Name: "R library " + p, Command: shellQuote(rscript) + " -e " + shellQuote("library("+p+")")}
+	}
+	return tests
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,128 @@
+// Package smoketest describes the command-level smoke tests the builder
+// bakes into an image from a recipe's test directives, and runs them inside
+// a container for the deployment tester.
+package smoketest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+// ManifestPath is where the manifest is written inside the image.
+const ManifestPath = "/.neurodesk/tests.json"
+
+// maxOutput is how much of a command's output a Result keeps.
+const maxOutput = 4096
+
+// Manifest lists the smoke tests of an image.
+type Manifest struct {
+	Tests []Test `json:"tests"`
+}
+
+// Test is a command run through /bin/sh -c. It passes when it exits with
+// ExitCode and, if Expect is set, its combined output matches that regular
+// expression.
+type Test struct {
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	Expect   string `json:"expect,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// Result is the outcome of running a Test.
+type Result struct {
+	Name    string
+	Command string
+
+	ExitCode int
+	// Output is the combined output, truncated to its last few KiB.
+	Output string `json:",omitempty"`
+
+	Passed bool
+	Error  string `json:",omitempty"`
+}
+
+// Encode returns the manifest of tests as written to ManifestPath.
+func Encode(tests []Test) (string, error) {
+	b, err := json.MarshalIndent(Manifest{Tests: tests}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
+
+// Load reads the manifest at path. A missing manifest is not an error: ok is
+// false, as for images built without command tests.
+func Load(path string) (m Manifest, ok bool, err error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Manifest{}, false, nil
+	} else if err != nil {
+		return Manifest{}, false, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Manifest{}, false, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return m, true, nil
+}
+
+// Run runs t, stopping it after timeout.
+func Run(t Test, timeout time.Duration) Result {
+	res := Result{Name: t.Name, Command: t.Command}
+	var expect *regexp.Regexp
+	if t.Expect != "" {
+		var err error
+		if expect, err = regexp.Compile(t.Expect); err != nil {
+			res.Error = fmt.Sprintf("invalid expect pattern: %v", err)
+			return res
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", t.Command)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// Children of the shell may keep the output open after it is killed.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	res.Output = truncate(out.String())
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		res.Error = fmt.Sprintf("timed out after %s", timeout)
+		return res
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		res.Error = err.Error()
+		return res
+	}
+
+	switch {
+	case res.ExitCode != t.ExitCode:
+		res.Error = fmt.Sprintf("exit code %d, want %d", res.ExitCode, t.ExitCode)
+	case expect != nil && !expect.MatchString(out.String()):
+		res.Error = fmt.Sprintf("output does not match %q", t.Expect)
+	default:
+		res.Passed = true
+	}
+	return res
+}
+
+// truncate keeps the end of s, where errors usually are.
+func truncate(s string) string {
+	if len(s) <= maxOutput {
+		return s
+	}
+	return "..." + s[len(s)-maxOutput:]
+}
@@ -0,0 +1,107 @@
+package smoketest
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		test   Test
+		passed bool
+		err    string
+	}{
+		{Test{Name: "version", Command: "echo tool 1.2.3", Expect: `tool \d+\.\d+`}, true, ""},
+		{Test{Name: "stderr", Command: "echo usage >&2; exit 1", Expect: "usage", ExitCode: 1}, true, ""},
+		{Test{Name: "exit", Command: "exit 3"}, false, "exit code 3, want 0"},
+		{Test{Name: "output", Command: "echo other", Expect: "^tool"}, false, "does not match"},
+		{Test{Name: "pattern", Command: "true", Expect: "("}, false, "invalid expect pattern"},
+		{Test{Name: "slow", Command: "sleep 5"}, false, "timed out"},
+	} {
+		res := Run(tc.test, time.Second)
+		if res.Passed != tc.passed || !strings.Contains(res.Error, tc.err) {
+			t.Fatalf("Run(%s) = %+v, want passed %v and error %q", tc.test.Name, res, tc.passed, tc.err)
+		}
+	}
+}
+
+func TestLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tests.json")
+	if _, ok, err := Load(path); ok || err != nil {
+		t.Fatalf("Load of a missing manifest = %v, %v, want not ok and no error", ok, err)
+	}
+	tests := []Test{{Name: "version", Command: "bet --version", Expect: "BET", ExitCode: 1}}
+	text, err := Encode(tests)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, ok, err := Load(path)
+	if err != nil || !ok {
+		t.Fatalf("Load = %v, %v", ok, err)
+	}
+	if len(m.Tests) != 1 || m.Tests[0] != tests[0] {
+		t.Fatalf("Load = %+v, want %+v", m.Tests, tests)
+	}
+}
+
+func TestCheckGUI(t *testing.T) {
+	missing := CheckGUI(GUIApp{Name: "missing", Exec: "no-such-viewer --gui"}, time.Second)
+	if missing.Passed || !strings.Contains(missing.Error, "no-such-viewer") {
+		t.Fatalf("CheckGUI(missing) = %+v", missing)
+	}
+
+	res := CheckGUI(GUIApp{Name: "shell", Exec: "sh -c true"}, time.Second)
+	if !strings.Contains(res.DesktopEntry, "Name=shell\nExec=sh -c true\nTryExec=") {
+		t.Fatalf("DesktopEntry = %q", res.DesktopEntry)
+	}
+	if _, err := exec.LookPath("xvfb-run"); err != nil {
+		if !res.Passed || res.Launch != "skipped" {
+			t.Fatalf("CheckGUI without xvfb-run = %+v, want a skipped launch", res)
+		}
+		return
+	}
+	if !res.Passed || res.Launch != "exited" {
+		t.Fatalf("CheckGUI = %+v, want an app that exited", res)
+	}
+}
+
+func TestLaunchFailures(t *testing.T) {
+	for _, out := range []string{
+		"viewer: error while loading shared libraries: libGL.so.1: cannot open shared object file",
+		"qt.qpa.plugin: Could not load the Qt platform plugin \"xcb\"",
+		"ModuleNotFoundError: No module named 'wx'",
+	} {
+		if !launchFailures.MatchString(out) {
+			t.Fatalf("launchFailures does not match %q", out)
+		}
+	}
+	if launchFailures.MatchString("Loading viewer 1.2 ...") {
+		t.Fatalf("launchFailures matches normal output")
+	}
+}
+
+func TestRuntimeTests(t *testing.T) {
+	py := PythonImports("", []string{"numpy", "nibabel.processing"})
+	if len(py) != 2 || py[1].Name != "python import nibabel.processing" || py[1].Command != `'python3' -c 'import nibabel.processing'` {
+		t.Fatalf("PythonImports = %+v", py)
+	}
+	r := RLibraries("/opt/R/bin/Rscript", []string{"oro.nifti"})
+	if len(r) != 1 || r[0].Command != `'/opt/R/bin/Rscript' -e 'library(oro.nifti)'` {
+		t.Fatalf("RLibraries = %+v", r)
+	}
+
+	// A module that does not import fails like any command test.
+	if _, err := exec.LookPath("python3"); err == nil {
+		res := Run(PythonImports("", []string{"no_such_module_here"})[0], 10*time.Second)
+		if res.Passed || !strings.Contains(res.Output, "ModuleNotFoundError") {
+			t.Fatalf("Run(missing module) = %+v", res)
+		}
+	}
+}
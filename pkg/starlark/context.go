@@ -5,7 +5,6 @@ import (
 	"strings"
 
 	"github.com/neurodesk/builder/pkg/ir"
-	"github.com/neurodesk/builder/pkg/jinja2"
 	"go.starlark.net/starlark"
 )
 
@@ -16,19 +15,20 @@ type RecipeContext interface {
 	EvaluateValue(value any) (any, error)
 	// AddRunCommand allows Starlark to append shell commands to the build.
 	AddRunCommand(cmd string)
+	// AddDirective applies a directive given as the Go form of its YAML
+	// body, e.g. kind "workdir" with spec "/opt".
+	AddDirective(src ir.SourceID, kind string, spec any) error
+	// AddStarlarkDirective is AddDirective for a directive builtin called
+	// at callSite ("file:line:col") in the script.
+	AddStarlarkDirective(src ir.SourceID, callSite, kind string, spec any) error
+	// HasLocal reports whether the named local context was supplied.
+	HasLocal(key string) bool
 }
 
 // NewEvaluatorWithStarlarkContext creates a Starlark evaluator with enhanced context
 // that provides access to directive functions like installing packages
 func NewEvaluatorWithStarlarkContext(ctx RecipeContext, src ir.SourceID) *Evaluator {
-	thread := &starlark.Thread{Name: "neurodesk-builder"}
-	builtins := CreateBuiltinsWithContext(ctx, src)
-
-	return &Evaluator{
-		thread:   thread,
-		builtins: builtins,
-		globals:  make(starlark.StringDict),
-	}
+	return newEvaluator(CreateBuiltinsWithContext(ctx, src))
 }
 
 // CreateBuiltinsWithContext creates Starlark built-in functions with recipe context access
@@ -76,37 +76,7 @@ func CreateBuiltinsWithContext(ctx RecipeContext, src ir.SourceID) starlark.Stri
 
 			value := ConvertFromStarlark(args[1])
 
-			// Convert Jinja2.Value to a Go value recursively, preserving types.
-			var toGo func(jinja2.Value) any
-			toGo = func(v jinja2.Value) any {
-				switch t := v.(type) {
-				case jinja2.StringValue:
-					return string(t)
-				case jinja2.IntValue:
-					return int64(t)
-				case jinja2.FloatValue:
-					return float64(t)
-				case jinja2.BoolValue:
-					return bool(t)
-				case jinja2.ListValue:
-					out := make([]any, 0, len(t))
-					for _, it := range t {
-						out = append(out, toGo(it))
-					}
-					return out
-				case jinja2.DictValue:
-					out := make(map[string]any, len(t))
-					for k, vv := range t {
-						out[k] = toGo(vv)
-					}
-					return out
-				case jinja2.NoneValue:
-					return nil
-				default:
-					return v.String()
-				}
-			}
-			goValue := toGo(value)
+			goValue := toGoValue(value)
 
 			ctx.SetVariable(name, goValue)
 			return starlark.None, nil
@@ -156,5 +126,31 @@ func CreateBuiltinsWithContext(ctx RecipeContext, src ir.SourceID) starlark.Stri
 		}),
 	}
 
+	builtins["has_local"] = starlark.NewBuiltin("has_local", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &key); err != nil {
+			return starlark.None, err
+		}
+		return starlark.Bool(ctx.HasLocal(key)), nil
+	})
+
+	// get_local returns the get_local template call rather than a path, so
+	// a run() using it mounts the local context exactly as the same YAML
+	// would; elsewhere it renders to the mount path.
+	builtins["get_local"] = starlark.NewBuiltin("get_local", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var key string
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &key); err != nil {
+			return starlark.None, err
+		}
+		if strings.ContainsAny(key, "\"'{}") {
+			return starlark.None, fmt.Errorf("get_local: invalid local name %q", key)
+		}
+		return starlark.String(`{{ get_local("` + key + `") }}`), nil
+	})
+
+	for name, fn := range directiveBuiltins(ctx, src) {
+		builtins[name] = fn
+	}
+
 	return builtins
 }
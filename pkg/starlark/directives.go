@@ -0,0 +1,178 @@
+package starlark
+
+import (
+	"fmt"
+
+	"github.com/neurodesk/builder/pkg/ir"
+	"go.starlark.net/starlark"
+)
+
+// directiveBuiltins returns the builtins that build recipe directives. Each
+// one turns its arguments into the YAML body of the matching directive and
+// applies it immediately, so a script can stand in for a directive list:
+//
+//	run("make", "make install")
+//	env(PATH="/opt/tool/bin:$PATH")
+//	copy("src", "/opt/src")
+//	workdir("/opt")
+//	user("neuro")
+//	file("tool.tar.gz", url="https://example.org/tool.tar.gz")
+//	template("fsl", version="6.0.7")
+//	deploy(bins=["tool"], path=["/opt/tool/bin"])
+//	test("version", script="tool --version")
+//	add_directive("labels", {"maintainer": "me"})
+func directiveBuiltins(ctx RecipeContext, src ir.SourceID) starlark.StringDict {
+	apply := func(thread *starlark.Thread, fn *starlark.Builtin, kind string, spec any) (starlark.Value, error) {
+		if err := ctx.AddStarlarkDirective(src, callSite(thread), kind, spec); err != nil {
+			return starlark.None, fmt.Errorf("%s: %w", fn.Name(), err)
+		}
+		return starlark.None, nil
+	}
+
+	return starlark.StringDict{
+		"run": starlark.NewBuiltin("run", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(args) == 0 || len(kwargs) != 0 {
+				return starlark.None, fmt.Errorf("run requires one or more commands")
+			}
+			return apply(thread, fn, "run", stringArgs(args))
+		}),
+
+		"env": starlark.NewBuiltin("env", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			vars := map[string]any{}
+			switch {
+			case len(args) == 1:
+				dict, ok := args[0].(*starlark.Dict)
+				if !ok {
+					return starlark.None, fmt.Errorf("env: expected a dict, got %s", args[0].Type())
+				}
+				for _, item := range dict.Items() {
+					vars[stringArg(item[0])] = stringArg(item[1])
+				}
+			case len(args) > 1:
+				return starlark.None, fmt.Errorf("env takes a dict or keyword arguments")
+			}
+			for _, kv := range kwargs {
+				vars[stringArg(kv[0])] = stringArg(kv[1])
+			}
+			if len(vars) == 0 {
+				return starlark.None, fmt.Errorf("env requires at least one variable")
+			}
+			return apply(thread, fn, "environment", vars)
+		}),
+
+		"copy": starlark.NewBuiltin("copy", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(args) < 2 || len(kwargs) != 0 {
+				return starlark.None, fmt.Errorf("copy requires one or more sources and a destination")
+			}
+			return apply(thread, fn, "copy", stringArgs(args))
+		}),
+
+		"workdir": starlark.NewBuiltin("workdir", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var path string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &path); err != nil {
+				return starlark.None, err
+			}
+			return apply(thread, fn, "workdir", path)
+		}),
+
+		"user": starlark.NewBuiltin("user", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var name string
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &name); err != nil {
+				return starlark.None, err
+			}
+			return apply(thread, fn, "user", name)
+		}),
+
+		"file": starlark.NewBuiltin("file", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			spec, err := namedSpec(fn, args, kwargs)
+			if err != nil {
+				return starlark.None, err
+			}
+			return apply(thread, fn, "file", spec)
+		}),
+
+		"template": starlark.NewBuiltin("template", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			spec, err := namedSpec(fn, args, kwargs)
+			if err != nil {
+				return starlark.None, err
+			}
+			return apply(thread, fn, "template", spec)
+		}),
+
+		"deploy": starlark.NewBuiltin("deploy", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if len(args) != 0 || len(kwargs) == 0 {
+				return starlark.None, fmt.Errorf("deploy takes keyword arguments bins and/or path")
+			}
+			return apply(thread, fn, "deploy", kwargsSpec(kwargs))
+		}),
+
+		"test": starlark.NewBuiltin("test", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			spec, err := namedSpec(fn, args, kwargs)
+			if err != nil {
+				return starlark.None, err
+			}
+			return apply(thread, fn, "test", spec)
+		}),
+
+		"add_directive": starlark.NewBuiltin("add_directive", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var kind string
+			var spec starlark.Value
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &kind, &spec); err != nil {
+				return starlark.None, err
+			}
+			return apply(thread, fn, kind, toGoValue(ConvertFromStarlark(spec)))
+		}),
+	}
+}
+
+// stringArg returns a string argument without the quotes String() adds.
+func stringArg(v starlark.Value) string {
+	if s, ok := v.(starlark.String); ok {
+		return string(s)
+	}
+	return v.String()
+}
+
+func stringArgs(args starlark.Tuple) []any {
+	out := make([]any, len(args))
+	for i, arg := range args {
+		out[i] = stringArg(arg)
+	}
+	return out
+}
+
+func kwargsSpec(kwargs []starlark.Tuple) map[string]any {
+	spec := make(map[string]any, len(kwargs))
+	for _, kv := range kwargs {
+		spec[stringArg(kv[0])] = toGoValue(ConvertFromStarlark(kv[1]))
+	}
+	return spec
+}
+
+// namedSpec builds the body of a directive whose name may be passed as the
+// only positional argument, e.g. file("x", url=...) or file(name="x", ...).
+func namedSpec(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (map[string]any, error) {
+	spec := kwargsSpec(kwargs)
+	switch len(args) {
+	case 0:
+	case 1:
+		if _, ok := spec["name"]; ok {
+			return nil, fmt.Errorf("%s: name given both positionally and as a keyword", fn.Name())
+		}
+		spec["name"] = stringArg(args[0])
+	default:
+		return nil, fmt.Errorf("%s takes a name and keyword arguments", fn.Name())
+	}
+	if _, ok := spec["name"]; !ok {
+		return nil, fmt.Errorf("%s requires a name", fn.Name())
+	}
+	return spec, nil
+}
+
+// callSite returns the script position of the call to the running builtin.
+func callSite(thread *starlark.Thread) string {
+	if thread.CallStackDepth() < 2 {
+		return ""
+	}
+	return thread.CallFrame(1).Pos.String()
+}
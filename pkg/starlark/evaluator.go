@@ -13,30 +13,26 @@ type Evaluator struct {
 	thread   *starlark.Thread
 	builtins starlark.StringDict
 	globals  starlark.StringDict
+	limits   Limits
 }
 
-// NewEvaluator creates a new Starlark evaluator
-func NewEvaluator() *Evaluator {
-	thread := &starlark.Thread{Name: "neurodesk-builder"}
-	builtins := CreateBuiltins(nil) // No context initially
-
+func newEvaluator(builtins starlark.StringDict) *Evaluator {
 	return &Evaluator{
-		thread:   thread,
+		thread:   &starlark.Thread{Name: "neurodesk-builder"},
 		builtins: builtins,
 		globals:  make(starlark.StringDict),
+		limits:   limits,
 	}
 }
 
+// NewEvaluator creates a new Starlark evaluator
+func NewEvaluator() *Evaluator {
+	return newEvaluator(CreateBuiltins(nil)) // No context initially
+}
+
 // NewEvaluatorWithContext creates a new Starlark evaluator with access to a recipe context
 func NewEvaluatorWithContext(ctx interface{}) *Evaluator {
-	thread := &starlark.Thread{Name: "neurodesk-builder"}
-	builtins := CreateBuiltins(ctx)
-
-	return &Evaluator{
-		thread:   thread,
-		builtins: builtins,
-		globals:  make(starlark.StringDict),
-	}
+	return newEvaluator(CreateBuiltins(ctx))
 }
 
 // SetGlobal sets a global variable in the Starlark environment
@@ -51,17 +47,11 @@ func (e *Evaluator) SetGlobalStarlark(name string, value starlark.Value) {
 
 // Eval evaluates a Starlark expression and returns the result as a Jinja2 Value
 func (e *Evaluator) Eval(expr string) (jinja2.Value, error) {
-	// Combine builtins and globals for evaluation
-	predeclared := make(starlark.StringDict)
-	for k, v := range e.builtins {
-		predeclared[k] = v
-	}
-	for k, v := range e.globals {
-		predeclared[k] = v
-	}
-
-	// Evaluate the expression
-	val, err := starlark.Eval(e.thread, "<eval>", expr, predeclared)
+	var val starlark.Value
+	err := e.limited(func() (err error) {
+		val, err = starlark.EvalOptions(fileOptions, e.thread, "<eval>", expr, e.predeclared())
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("starlark evaluation error: %w", err)
 	}
@@ -71,17 +61,11 @@ func (e *Evaluator) Eval(expr string) (jinja2.Value, error) {
 
 // ExecFile executes a Starlark file and returns any globals that were modified
 func (e *Evaluator) ExecFile(filename string, src interface{}) (starlark.StringDict, error) {
-	// Combine builtins and globals for execution
-	predeclared := make(starlark.StringDict)
-	for k, v := range e.builtins {
-		predeclared[k] = v
-	}
-	for k, v := range e.globals {
-		predeclared[k] = v
-	}
-
-	// Execute the file
-	globals, err := starlark.ExecFile(e.thread, filename, src, predeclared)
+	var globals starlark.StringDict
+	err := e.limited(func() (err error) {
+		globals, err = starlark.ExecFileOptions(fileOptions, e.thread, filename, src, e.predeclared())
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("starlark execution error: %w", err)
 	}
@@ -99,6 +83,27 @@ func (e *Evaluator) ExecString(script string) (starlark.StringDict, error) {
 	return e.ExecFile("<script>", script)
 }
 
+// Call calls the global function name with args, as defined by a previous
+// ExecFile or ExecString.
+func (e *Evaluator) Call(name string, args ...starlark.Value) (starlark.Value, error) {
+	fn, ok := e.globals[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not defined", name)
+	}
+	if _, ok := fn.(starlark.Callable); !ok {
+		return nil, fmt.Errorf("%s is a %s, not a function", name, fn.Type())
+	}
+	var val starlark.Value
+	err := e.limited(func() (err error) {
+		val, err = starlark.Call(e.thread, fn, args, nil)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("calling %s: %w", name, err)
+	}
+	return val, nil
+}
+
 // GetGlobal retrieves a global variable as a Jinja2 Value
 func (e *Evaluator) GetGlobal(name string) (jinja2.Value, bool) {
 	if val, ok := e.globals[name]; ok {
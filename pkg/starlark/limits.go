@@ -0,0 +1,107 @@
+package starlark
+
+import (
+	"fmt"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Limits bounds the work a script may do, so a misbehaving recipe cannot
+// hang a build. Each ExecFile, ExecString, Eval or Call gets the full
+// budget, shared with any modules it loads. Zero fields use the defaults.
+type Limits struct {
+	// MaxSteps caps the abstract execution steps counted by the interpreter.
+	MaxSteps uint64 `yaml:"max_steps,omitempty"`
+	// MaxDepth caps the call stack depth. Functions may recurse up to it.
+	MaxDepth int `yaml:"max_depth,omitempty"`
+	// Timeout caps wall-clock time.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// DefaultLimits are used for fields a configuration leaves unset.
+var DefaultLimits = Limits{
+	MaxSteps: 10_000_000,
+	MaxDepth: 200,
+	Timeout:  time.Minute,
+}
+
+var limits = DefaultLimits
+
+// SetLimits sets the limits of evaluators created afterwards.
+func SetLimits(l Limits) error {
+	if l.MaxDepth < 0 {
+		return fmt.Errorf("starlark max_depth must not be negative, got %d", l.MaxDepth)
+	}
+	if l.Timeout < 0 {
+		return fmt.Errorf("starlark timeout must not be negative, got %s", l.Timeout)
+	}
+	if l.MaxSteps == 0 {
+		l.MaxSteps = DefaultLimits.MaxSteps
+	}
+	if l.MaxDepth == 0 {
+		l.MaxDepth = DefaultLimits.MaxDepth
+	}
+	if l.Timeout == 0 {
+		l.Timeout = DefaultLimits.Timeout
+	}
+	limits = l
+	return nil
+}
+
+// depthCheckInterval is how many steps may pass between call depth checks.
+// A call takes several steps, so the depth overshoots the limit by at most
+// a few frames before the script is stopped.
+const depthCheckInterval = 16
+
+// fileOptions are the Starlark dialect options for every script. Recursion
+// is allowed because MaxDepth bounds it.
+var fileOptions = func() *syntax.FileOptions {
+	opts := *syntax.LegacyFileOptions()
+	opts.Recursion = true
+	return &opts
+}()
+
+// nondeterministic names builtins that scripts commonly reach for but
+// that would make builds depend on when or where they ran. They are
+// predeclared so that using one fails with an explanation rather than as
+// an undefined name.
+var nondeterministic = []string{"time", "now", "random", "uuid", "getenv", "environ"}
+
+func forbiddenBuiltins() starlark.StringDict {
+	out := make(starlark.StringDict, len(nondeterministic))
+	for _, name := range nondeterministic {
+		out[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return starlark.None, fmt.Errorf("%s is not available: Starlark scripts must be deterministic", fn.Name())
+		})
+	}
+	return out
+}
+
+// limited runs f on the evaluator's thread under its limits.
+func (e *Evaluator) limited(f func() error) error {
+	thread := e.thread
+	l := e.limits
+	thread.Uncancel()
+	budget := thread.Steps + l.MaxSteps
+	next := func() uint64 { return min(thread.Steps+depthCheckInterval, budget) }
+	thread.OnMaxSteps = func(thread *starlark.Thread) {
+		if thread.Steps >= budget {
+			thread.Cancel(fmt.Sprintf("exceeded the limit of %d execution steps", l.MaxSteps))
+			return
+		}
+		if depth := thread.CallStackDepth(); depth > l.MaxDepth {
+			thread.Cancel(fmt.Sprintf("call depth %d exceeds the limit of %d", depth, l.MaxDepth))
+			return
+		}
+		thread.SetMaxExecutionSteps(next())
+	}
+	thread.SetMaxExecutionSteps(next())
+
+	timer := time.AfterFunc(l.Timeout, func() {
+		thread.Cancel(fmt.Sprintf("timed out after %s", l.Timeout))
+	})
+	defer timer.Stop()
+	return f()
+}
@@ -0,0 +1,79 @@
+package starlark
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitsStopRunawayScripts(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		limits  Limits
+		script  string
+		wantErr string
+	}{
+		{
+			name:    "steps",
+			limits:  Limits{MaxSteps: 10_000, MaxDepth: 50, Timeout: time.Minute},
+			script:  "def spin():\n    for i in range(1000000):\n        pass\nspin()\n",
+			wantErr: "exceeded the limit of 10000 execution steps",
+		},
+		{
+			name:    "depth",
+			limits:  Limits{MaxSteps: 10_000_000, MaxDepth: 50, Timeout: time.Minute},
+			script:  "def f(n):\n    return f(n + 1)\nf(0)\n",
+			wantErr: "exceeds the limit of 50",
+		},
+		{
+			name:    "timeout",
+			limits:  Limits{MaxSteps: 1 << 62, MaxDepth: 50, Timeout: 50 * time.Millisecond},
+			script:  "def spin():\n    for i in range(1 << 40):\n        pass\nspin()\n",
+			wantErr: "timed out after 50ms",
+		},
+		{
+			name:    "nondeterministic",
+			limits:  DefaultLimits,
+			script:  "x = time()\n",
+			wantErr: "time is not available: Starlark scripts must be deterministic",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			eval := NewEvaluator()
+			eval.limits = tc.limits
+			_, err := eval.ExecString(tc.script)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimitsAllowBoundedRecursion(t *testing.T) {
+	eval := NewEvaluator()
+	eval.limits = Limits{MaxSteps: 1_000_000, MaxDepth: 30, Timeout: time.Minute}
+	if _, err := eval.ExecString("def fib(n):\n    return n if n < 2 else fib(n - 1) + fib(n - 2)\nresult = fib(15)\n"); err != nil {
+		t.Fatalf("ExecString: %v", err)
+	}
+	if result, _ := eval.GetGlobal("result"); result.String() != "610" {
+		t.Fatalf("fib(15) = %v, want 610", result)
+	}
+	// The budget is per call, so a second script runs after the first.
+	if _, err := eval.Eval("fib(10)"); err != nil {
+		t.Fatalf("Eval after ExecString: %v", err)
+	}
+}
+
+func TestSetLimitsFillsDefaults(t *testing.T) {
+	defer func() { limits = DefaultLimits }()
+	if err := SetLimits(Limits{MaxSteps: 500}); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	got := NewEvaluator().limits
+	if got.MaxSteps != 500 || got.MaxDepth != DefaultLimits.MaxDepth || got.Timeout != DefaultLimits.Timeout {
+		t.Fatalf("limits = %+v", got)
+	}
+	if err := SetLimits(Limits{MaxDepth: -1}); err == nil {
+		t.Fatalf("negative max_depth accepted")
+	}
+}
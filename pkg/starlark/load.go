@@ -0,0 +1,64 @@
+package starlark
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// loadEntry caches one module's globals. A nil entry in the cache marks a
+// module that is still being loaded.
+type loadEntry struct {
+	globals starlark.StringDict
+	err     error
+}
+
+// SetModuleResolver enables load() statements. find maps a module name such
+// as "lib.star" to a file path. Each module is executed once per evaluator
+// with the same builtins and globals as the script, and its globals are
+// cached for later loads; a module that loads itself, directly or
+// indirectly, is an error.
+func (e *Evaluator) SetModuleResolver(find func(module string) (string, error)) {
+	cache := make(map[string]*loadEntry)
+	var stack []string
+
+	e.thread.Load = func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+		path, err := find(module)
+		if err != nil {
+			return nil, err
+		}
+		entry, ok := cache[path]
+		if ok {
+			if entry == nil {
+				return nil, fmt.Errorf("cycle in load graph: %s -> %s", strings.Join(stack, " -> "), module)
+			}
+			return entry.globals, entry.err
+		}
+
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading starlark module %q: %w", path, err)
+		}
+
+		cache[path] = nil
+		stack = append(stack, module)
+		globals, err := starlark.ExecFileOptions(fileOptions, thread, path, src, e.predeclared())
+		stack = stack[:len(stack)-1]
+		cache[path] = &loadEntry{globals: globals, err: err}
+		return globals, err
+	}
+}
+
+// predeclared returns the builtins and globals visible to scripts.
+func (e *Evaluator) predeclared() starlark.StringDict {
+	predeclared := forbiddenBuiltins()
+	for k, v := range e.builtins {
+		predeclared[k] = v
+	}
+	for k, v := range e.globals {
+		predeclared[k] = v
+	}
+	return predeclared
+}
@@ -0,0 +1,86 @@
+package starlark
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+// moduleDir writes the given modules to a temporary directory and returns a
+// resolver for it.
+func moduleDir(t *testing.T, modules map[string]string) func(string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range modules {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+	return func(module string) (string, error) {
+		path := filepath.Join(dir, module)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("module %q not found", module)
+		}
+		return path, nil
+	}
+}
+
+func TestLoadSharesModulesOnce(t *testing.T) {
+	eval := NewEvaluator()
+	loads := 0
+	eval.builtins["tick"] = starlark.NewBuiltin("tick", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		loads++
+		return starlark.None, nil
+	})
+	eval.SetModuleResolver(moduleDir(t, map[string]string{
+		"urls.star": "tick()\ndef fsl_url(v):\n    return \"https://fsl.example.org/fsl-\" + v + \".tar.gz\"\n",
+		"fsl.star":  "load(\"urls.star\", \"fsl_url\")\ndef fsl(v):\n    return fsl_url(v)\n",
+	}))
+
+	_, err := eval.ExecString(`
+load("urls.star", url = "fsl_url")
+load("fsl.star", "fsl")
+result = fsl("6.0.7") + " " + url("6.0.6")
+`)
+	if err != nil {
+		t.Fatalf("ExecString: %v", err)
+	}
+	result, _ := eval.GetGlobal("result")
+	want := "https://fsl.example.org/fsl-6.0.7.tar.gz https://fsl.example.org/fsl-6.0.6.tar.gz"
+	if result.String() != want {
+		t.Fatalf("result = %q, want %q", result.String(), want)
+	}
+	if loads != 1 {
+		t.Fatalf("urls.star executed %d times, want 1", loads)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	find := moduleDir(t, map[string]string{
+		"a.star":   "load(\"b.star\", \"b\")\na = 1\n",
+		"b.star":   "load(\"a.star\", \"a\")\nb = 1\n",
+		"bad.star": "x = \n",
+	})
+	for _, tc := range []struct {
+		script  string
+		wantErr string
+	}{
+		{`load("a.star", "a")`, "cycle in load graph: a.star -> b.star -> a.star"},
+		{`load("missing.star", "x")`, `module "missing.star" not found`},
+		{`load("bad.star", "x")`, "bad.star"},
+	} {
+		eval := NewEvaluator()
+		eval.SetModuleResolver(find)
+		if _, err := eval.ExecString(tc.script); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("%s: error = %v, want %q", tc.script, err, tc.wantErr)
+		}
+	}
+
+	if _, err := NewEvaluator().ExecString(`load("a.star", "a")`); err == nil || !strings.Contains(err.Error(), "load not implemented") {
+		t.Fatalf("load without a resolver: error = %v", err)
+	}
+}
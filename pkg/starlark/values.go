@@ -2,6 +2,7 @@ package starlark
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/neurodesk/builder/pkg/jinja2"
 	"go.starlark.net/starlark"
@@ -29,9 +30,11 @@ func ConvertToStarlark(val jinja2.Value) starlark.Value {
 		}
 		return starlark.NewList(items)
 	case jinja2.DictValue:
+		// Starlark dicts keep insertion order, so insert sorted keys to keep
+		// iteration in scripts deterministic.
 		dict := starlark.NewDict(len(v))
-		for key, value := range v {
-			dict.SetKey(starlark.String(key), ConvertToStarlark(value))
+		for _, key := range v.Keys() {
+			dict.SetKey(starlark.String(key), ConvertToStarlark(v[key]))
 		}
 		return dict
 	case jinja2.NoneValue:
@@ -85,6 +88,43 @@ func ConvertFromStarlark(val starlark.Value) jinja2.Value {
 	}
 }
 
+// ToGo converts a Starlark value to plain Go values (string, int64,
+// float64, bool, []any, map[string]any or nil).
+func ToGo(val starlark.Value) any {
+	return toGoValue(ConvertFromStarlark(val))
+}
+
+// toGoValue converts a Jinja2 value to plain Go values recursively,
+// preserving types.
+func toGoValue(v jinja2.Value) any {
+	switch t := v.(type) {
+	case jinja2.StringValue:
+		return string(t)
+	case jinja2.IntValue:
+		return int64(t)
+	case jinja2.FloatValue:
+		return float64(t)
+	case jinja2.BoolValue:
+		return bool(t)
+	case jinja2.ListValue:
+		out := make([]any, 0, len(t))
+		for _, it := range t {
+			out = append(out, toGoValue(it))
+		}
+		return out
+	case jinja2.DictValue:
+		out := make(map[string]any, len(t))
+		for k, vv := range t {
+			out[k] = toGoValue(vv)
+		}
+		return out
+	case jinja2.NoneValue:
+		return nil
+	default:
+		return v.String()
+	}
+}
+
 // StarlarkValueWrapper wraps a Starlark value to implement jinja2.Value
 type StarlarkValueWrapper struct {
 	Value starlark.Value
@@ -172,6 +212,7 @@ func (c *ContextObject) AttrNames() []string {
 	for name := range c.variables {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
 
@@ -186,8 +227,12 @@ var _ starlark.HasAttrs = (*ContextObject)(nil)
 var _ starlark.HasSetField = (*ContextObject)(nil)
 
 // CreateBuiltins creates Starlark built-in functions that provide access to
-// the directive system and parameter access
+// the directive system and parameter access. A RecipeContext gets the full
+// directive API of CreateBuiltinsWithContext.
 func CreateBuiltins(ctx interface{}) starlark.StringDict {
+	if rc, ok := ctx.(RecipeContext); ok {
+		return CreateBuiltinsWithContext(rc, "")
+	}
 	builtins := starlark.StringDict{
 		"print": starlark.NewBuiltin("print", func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 			var buf []string
@@ -0,0 +1,131 @@
+// Package textdiff renders line-based differences between two texts in the
+// unified format of diff -u.
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// op is one line of an edit script: ' ' kept, '-' removed, '+' added.
+type op struct {
+	kind byte
+	line string
+}
+
+// Unified returns the unified diff turning old into new, labelled with
+// oldName and newName, with context lines of context around each change.
+// It returns "" when the texts are equal.
+func Unified(oldName, newName, old, new string, context int) string {
+	if old == new {
+		return ""
+	}
+	ops := editScript(splitLines(old), splitLines(new))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(ops); {
+		// Find the next change and the extent of its hunk: changes separated
+		// by at most 2*context kept lines share a hunk.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			} else if i-last > 2*context {
+				break
+			}
+		}
+		lo := max(first-context, start)
+		hi := min(last+context+1, len(ops))
+
+		oldLine, newLine := 1, 1
+		for _, o := range ops[:lo] {
+			if o.kind != '+' {
+				oldLine++
+			}
+			if o.kind != '-' {
+				newLine++
+			}
+		}
+		var oldCount, newCount int
+		for _, o := range ops[lo:hi] {
+			if o.kind != '+' {
+				oldCount++
+			}
+			if o.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, o := range ops[lo:hi] {
+			b.WriteByte(o.kind)
+			b.WriteString(o.line)
+			b.WriteByte('\n')
+		}
+		start = hi
+	}
+	return b.String()
+}
+
+// hunkRange formats the start,count pair of a hunk header. An empty range
+// names the line before it, as diff -u does.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// editScript computes a shortest edit script from the longest common
+// subsequence of a and b, listing removals before additions.
+func editScript(a, b []string) []op {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case i < n && (j >= m || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
@@ -0,0 +1,72 @@
+package textdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedEqualTexts(t *testing.T) {
+	if got := Unified("a", "b", "x\ny\n", "x\ny\n", 3); got != "" {
+		t.Fatalf("expected no diff, got:\n%s", got)
+	}
+}
+
+func TestUnifiedSingleHunk(t *testing.T) {
+	old := "one\ntwo\nthree\nfour\n"
+	new := "one\ntwo\n3\nfour\nfive\n"
+	want := strings.Join([]string{
+		"--- old",
+		"+++ new",
+		"@@ -1,4 +1,5 @@",
+		" one",
+		" two",
+		"-three",
+		"+3",
+		" four",
+		"+five",
+		"",
+	}, "\n")
+	if got := Unified("old", "new", old, new, 3); got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedSeparateHunks(t *testing.T) {
+	var oldLines, newLines []string
+	for i := range 20 {
+		line := string(rune('a' + i))
+		oldLines = append(oldLines, line)
+		switch i {
+		case 1:
+			newLines = append(newLines, "B")
+		case 18:
+		default:
+			newLines = append(newLines, line)
+		}
+	}
+	got := Unified("old", "new", strings.Join(oldLines, "\n")+"\n", strings.Join(newLines, "\n")+"\n", 1)
+	want := strings.Join([]string{
+		"--- old",
+		"+++ new",
+		"@@ -1,3 +1,3 @@",
+		" a",
+		"-b",
+		"+B",
+		" c",
+		"@@ -18,3 +18,2 @@",
+		" r",
+		"-s",
+		" t",
+		"",
+	}, "\n")
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedFromEmpty(t *testing.T) {
+	want := "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+	if got := Unified("old", "new", "", "x\ny\n", 3); got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
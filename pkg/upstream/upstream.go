@@ -0,0 +1,194 @@
+// Package upstream looks up the releases of the software recipes package,
+// so `builder outdated` can report recipes that fall behind.
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+)
+
+// Checker queries the release sources.
+type Checker struct {
+	Client *http.Client
+	// GitHubToken authenticates GitHub API requests, which are otherwise
+	// limited to 60 an hour.
+	GitHubToken string
+	// The API endpoints, replaced in tests.
+	GitHubAPI string
+	PyPI      string
+	Anaconda  string
+}
+
+// New returns a Checker for the public endpoints.
+func New() *Checker {
+	return &Checker{
+		Client:    &http.Client{Timeout: time.Minute},
+		GitHubAPI: "https://api.github.com",
+		PyPI:      "https://pypi.org",
+		Anaconda:  "https://api.anaconda.org",
+	}
+}
+
+// Versions returns the versions src publishes, leaving out pre-releases
+// unless src.Prereleases is set.
+func (c *Checker) Versions(ctx context.Context, src recipe.UpstreamInfo) ([]string, error) {
+	var re *regexp.Regexp
+	if src.Regex != "" {
+		var err error
+		if re, err = regexp.Compile(src.Regex); err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	var versions []string
+	switch src.Kind {
+	case recipe.UpstreamGitHub:
+		var releases []struct {
+			TagName    string `json:"tag_name"`
+			Draft      bool   `json:"draft"`
+			Prerelease bool   `json:"prerelease"`
+		}
+		if err := c.getJSON(ctx, c.GitHubAPI+"/repos/"+src.Repo+"/releases?per_page=100", &releases); err != nil {
+			return nil, err
+		}
+		for _, r := range releases {
+			if r.Draft || r.Prerelease && !src.Prereleases {
+				continue
+			}
+			if re == nil {
+				versions = append(versions, strings.TrimPrefix(r.TagName, "v"))
+			} else if v, ok := extract(re, r.TagName); ok {
+				versions = append(versions, v)
+			}
+		}
+		return versions, nil
+	case recipe.UpstreamPyPI:
+		var project struct {
+			Releases map[string][]pypiFile `json:"releases"`
+		}
+		if err := c.getJSON(ctx, c.PyPI+"/pypi/"+url.PathEscape(src.Package)+"/json", &project); err != nil {
+			return nil, err
+		}
+		for v, files := range project.Releases {
+			// A release whose files were all yanked is withdrawn.
+			if len(files) > 0 && !slices.ContainsFunc(files, func(f pypiFile) bool { return !f.Yanked }) {
+				continue
+			}
+			versions = append(versions, v)
+		}
+	case recipe.UpstreamConda:
+		channel := src.Channel
+		if channel == "" {
+			channel = "conda-forge"
+		}
+		var pkg struct {
+			Versions []string `json:"versions"`
+		}
+		if err := c.getJSON(ctx, c.Anaconda+"/package/"+url.PathEscape(channel)+"/"+url.PathEscape(src.Package), &pkg); err != nil {
+			return nil, err
+		}
+		versions = pkg.Versions
+	case recipe.UpstreamURL:
+		if re == nil {
+			return nil, fmt.Errorf("upstream %s has no regex to find versions with", src.URL)
+		}
+		body, err := c.get(ctx, src.URL, "")
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, m := range re.FindAllStringSubmatch(string(body), -1) {
+			v := m[0]
+			if len(m) > 1 {
+				v = m[1]
+			}
+			if !seen[v] {
+				seen[v] = true
+				versions = append(versions, v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown upstream kind %q", src.Kind)
+	}
+	if !src.Prereleases {
+		versions = slices.DeleteFunc(versions, IsPrerelease)
+	}
+	return versions, nil
+}
+
+type pypiFile struct {
+	Yanked bool `json:"yanked"`
+}
+
+// IsPrerelease reports whether version has letters, as 2.0rc1 and
+// 1.0.dev3 do.
+func IsPrerelease(version string) bool {
+	return strings.IndexFunc(version, unicode.IsLetter) >= 0
+}
+
+// Newer returns the versions newer than current, newest first.
+func Newer(current string, versions []string) []string {
+	var newer []string
+	for _, v := range versions {
+		if recipe.CompareVersions(v, current) > 0 && !slices.Contains(newer, v) {
+			newer = append(newer, v)
+		}
+	}
+	slices.SortFunc(newer, func(a, b string) int { return recipe.CompareVersions(b, a) })
+	return newer
+}
+
+func extract(re *regexp.Regexp, s string) (string, bool) {
+	m := re.FindStringSubmatch(s)
+	switch {
+	case m == nil:
+		return "", false
+	case len(m) > 1:
+		return m[1], true
+	}
+	return m[0], true
+}
+
+func (c *Checker) getJSON(ctx context.Context, u string, v any) error {
+	token := ""
+	if strings.HasPrefix(u, c.GitHubAPI) {
+		token = c.GitHubToken
+	}
+	body, err := c.get(ctx, u, token)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", u, err)
+	}
+	return nil
+}
+
+func (c *Checker) get(ctx context.Context, u, token string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: HTTP %d", u, resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+}
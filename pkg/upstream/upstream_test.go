@@ -0,0 +1,81 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/neurodesk/builder/pkg/recipe"
+)
+
+func fakeUpstreams(t *testing.T) *Checker {
+	t.Helper()
+	responses := map[string]string{
+		"/repos/example/tool/releases": `[
+			{"tag_name": "v2.1.0"},
+			{"tag_name": "v2.2.0-rc1", "prerelease": true},
+			{"tag_name": "v3.0.0", "draft": true},
+			{"tag_name": "v1.9.0"}
+		]`,
+		"/pypi/tool/json": `{"releases": {
+			"1.0": [{"yanked": false}],
+			"1.1": [{"yanked": true}],
+			"1.2b1": [{"yanked": false}],
+			"1.0.1": []
+		}}`,
+		"/package/bioconda/tool": `{"versions": ["0.9", "1.0", "1.0a1"]}`,
+		"/downloads/":            `<a href="tool-1.0.tar.gz">tool-1.0.tar.gz</a> <a href="tool-1.10.tar.gz">tool-1.10.tar.gz</a>`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/example/tool/releases" && r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "no token", http.StatusUnauthorized)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return &Checker{Client: srv.Client(), GitHubToken: "token", GitHubAPI: srv.URL, PyPI: srv.URL, Anaconda: srv.URL}
+}
+
+func TestVersions(t *testing.T) {
+	c := fakeUpstreams(t)
+	srvURL := c.GitHubAPI
+	tests := []struct {
+		src  recipe.UpstreamInfo
+		want []string
+	}{
+		{recipe.UpstreamInfo{Kind: recipe.UpstreamGitHub, Repo: "example/tool"}, []string{"2.1.0", "1.9.0"}},
+		{recipe.UpstreamInfo{Kind: recipe.UpstreamGitHub, Repo: "example/tool", Prereleases: true}, []string{"2.1.0", "2.2.0-rc1", "1.9.0"}},
+		{recipe.UpstreamInfo{Kind: recipe.UpstreamPyPI, Package: "tool"}, []string{"1.0", "1.0.1"}},
+		{recipe.UpstreamInfo{Kind: recipe.UpstreamConda, Package: "tool", Channel: "bioconda"}, []string{"0.9", "1.0"}},
+		{recipe.UpstreamInfo{Kind: recipe.UpstreamURL, URL: srvURL + "/downloads/", Regex: `tool-([0-9.]+)\.tar\.gz`}, []string{"1.0", "1.10"}},
+	}
+	for _, tt := range tests {
+		got, err := c.Versions(context.Background(), tt.src)
+		if err != nil {
+			t.Fatalf("Versions(%+v): %v", tt.src, err)
+		}
+		slices.Sort(got)
+		slices.Sort(tt.want)
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("Versions(%+v) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+	if _, err := c.Versions(context.Background(), recipe.UpstreamInfo{Kind: recipe.UpstreamPyPI, Package: "missing"}); err == nil {
+		t.Fatalf("Versions of a missing package succeeded")
+	}
+}
+
+func TestNewer(t *testing.T) {
+	got := Newer("1.2.0", []string{"1.10.0", "1.2.0", "1.1.9", "1.3", "1.10.0"})
+	if want := []string{"1.10.0", "1.3"}; !slices.Equal(got, want) {
+		t.Fatalf("Newer = %q, want %q", got, want)
+	}
+}
@@ -1,49 +1,142 @@
+// Package validator has helpers for validating decoded recipes. Validation
+// does not stop at the first problem: the helpers collect every error, each
+// with the YAML path of the value it is about, such as
+// build.directives[7].run[2], so authors can fix them all at once.
 package validator
 
 import (
+	"errors"
 	"fmt"
 	"slices"
+	"sort"
 	"strings"
 )
 
-func All(errors ...error) error {
-	for _, err := range errors {
-		if err != nil {
-			return err
+// FieldError is a validation error about the value at Path.
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string { return e.Path + ": " + e.Err.Error() }
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// Errors is a list of validation errors, as returned by All, Each, Map and
+// MapDict when more than one check fails.
+type Errors []error
+
+func (e Errors) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d errors:", len(e))
+	for _, err := range e {
+		b.WriteString("\n  " + err.Error())
+	}
+	return b.String()
+}
+
+func (e Errors) Unwrap() []error { return e }
+
+// List returns the errors err is made of: the elements of an Errors it
+// wraps, or err itself.
+func List(err error) []error {
+	if err == nil {
+		return nil
+	}
+	var errs Errors
+	if errors.As(err, &errs) {
+		return errs
+	}
+	return []error{err}
+}
+
+// All returns every non-nil error of errs, flattening Errors, as an Errors;
+// the error itself when there is one, and nil when there is none.
+func All(errs ...error) error {
+	var out Errors
+	for _, err := range errs {
+		if list, ok := err.(Errors); ok {
+			out = append(out, list...)
+		} else if err != nil {
+			out = append(out, err)
 		}
 	}
-	return nil
+	switch len(out) {
+	case 0:
+		return nil
+	case 1:
+		return out[0]
+	}
+	return out
+}
+
+// At places err, and every error it is made of, at path: their paths become
+// relative to it. A path of a list element such as "[2]" is joined without
+// a dot.
+func At(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	list, ok := err.(Errors)
+	if !ok {
+		list = Errors{err}
+	}
+	var errs []error
+	for _, e := range list {
+		if fe, ok := e.(*FieldError); ok {
+			sep := "."
+			if strings.HasPrefix(fe.Path, "[") {
+				sep = ""
+			}
+			errs = append(errs, &FieldError{Path: path + sep + fe.Path, Err: fe.Err})
+		} else {
+			errs = append(errs, &FieldError{Path: path, Err: e})
+		}
+	}
+	return All(errs...)
 }
 
 type Validatable interface {
 	Validate() error
 }
 
+// Each validates every item, placing the errors at the item's index.
 func Each[T Validatable](items []T) error {
+	var errs []error
 	for i, item := range items {
 		if err := item.Validate(); err != nil {
-			return fmt.Errorf("item %d: %w", i, err)
+			errs = append(errs, At(fmt.Sprintf("[%d]", i), err))
 		}
 	}
-	return nil
+	return All(errs...)
 }
 
+// Map calls f for every item with its path, description[i], and places the
+// errors it returns at that path.
 func Map[T any](items []T, f func(T, string) error, description string) error {
+	var errs []error
 	for i, item := range items {
-		if err := f(item, fmt.Sprintf("%s[%d]", description, i)); err != nil {
-			return err
+		path := fmt.Sprintf("%s[%d]", description, i)
+		if err := f(item, path); err != nil {
+			errs = append(errs, At(path, err))
 		}
 	}
-	return nil
+	return All(errs...)
 }
 
+// MapDict calls f for every entry, in key order, and collects the errors.
+// f names the key in its errors itself.
 func MapDict[T any](items map[string]T, f func(string, T) error, description string) error {
-	for key, item := range items {
-		if err := f(key, item); err != nil {
-			return err
-		}
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
 	}
-	return nil
+	sort.Strings(keys)
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, f(key, items[key]))
+	}
+	return All(errs...)
 }
 
 func NotEmpty(field, description string) error {
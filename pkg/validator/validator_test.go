@@ -0,0 +1,85 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAllCollectsEveryError(t *testing.T) {
+	if err := All(nil, nil); err != nil {
+		t.Fatalf("All(nil, nil) = %v", err)
+	}
+	one := errors.New("one")
+	if err := All(nil, one); err != one {
+		t.Fatalf("All(nil, one) = %v, want one", err)
+	}
+	err := All(errors.New("a"), nil, All(errors.New("b"), errors.New("c")))
+	if got := len(List(err)); got != 3 {
+		t.Fatalf("List(All(...)) has %d errors, want 3 (flattened): %v", got, err)
+	}
+	if want := "3 errors:\n  a\n  b\n  c"; err.Error() != want {
+		t.Fatalf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestMapPlacesErrorsAtPaths(t *testing.T) {
+	run := func(cmds []string, _ string) error {
+		return Map(cmds, func(cmd, _ string) error {
+			if cmd == "" {
+				return errors.New("must not be empty")
+			}
+			return nil
+		}, "run")
+	}
+	directives := [][]string{{"ok"}, {"ok", "", ""}}
+	err := Map(directives, run, "build.directives")
+	var got []string
+	for _, e := range List(err) {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"build.directives[1].run[1]: must not be empty",
+		"build.directives[1].run[2]: must not be empty",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("errors = %q, want %q", got, want)
+	}
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Path != "build.directives[1].run[1]" {
+		t.Fatalf("errors.As(FieldError) = %+v", fe)
+	}
+}
+
+func TestAtJoinsIndexesWithoutDot(t *testing.T) {
+	err := At("items", At("[0]", errors.New("bad")))
+	if err.Error() != "items[0]: bad" {
+		t.Fatalf("At = %q", err)
+	}
+	if At("items", nil) != nil {
+		t.Fatalf("At(path, nil) is not nil")
+	}
+}
+
+func TestListOfWrappedErrors(t *testing.T) {
+	err := fmt.Errorf("validating: %w", All(errors.New("a"), errors.New("b")))
+	if got := len(List(err)); got != 2 {
+		t.Fatalf("List = %d errors, want 2", got)
+	}
+	if got := List(errors.New("x")); len(got) != 1 {
+		t.Fatalf("List(single) = %v", got)
+	}
+}
+
+func TestMapDictIsSortedAndCollectsAll(t *testing.T) {
+	err := MapDict(map[string]int{"b": 2, "a": 1, "c": 3}, func(k string, _ int) error {
+		if k == "c" {
+			return nil
+		}
+		return fmt.Errorf("%s is bad", k)
+	}, "items")
+	if want := "2 errors:\n  a is bad\n  b is bad"; err == nil || err.Error() != want {
+		t.Fatalf("MapDict = %v, want %q", err, want)
+	}
+}